@@ -19,6 +19,9 @@ type Advertisement interface {
 	RSSI() int
 	Addr() Addr
 	AddrType() uint8
+
+	// Timestamp returns when the advertisement was received, in
+	// nanoseconds since the Unix epoch.
 	Timestamp() int64
 
 	ToMap() (map[string]interface{}, error)
@@ -27,37 +30,59 @@ type Advertisement interface {
 }
 
 var AdvertisementMapKeys = struct {
-	MAC                string
-	RSSI               string
-	Name               string
-	MFG                string
-	Services           string
-	ServiceData        string
-	Connectable        string
-	Solicited          string
-	EventType          string
-	Flags              string
-	TxPower            string
-	AddressType        string
-	Controller         string
-	Timestamp          string
-	AdvertisementError string
+	MAC                      string
+	RSSI                     string
+	Name                     string
+	MFG                      string
+	Services                 string
+	ServiceData              string
+	Connectable              string
+	Solicited                string
+	EventType                string
+	Flags                    string
+	TxPower                  string
+	AddressType              string
+	IdentityAddress          string
+	Controller               string
+	Timestamp                string
+	AdvertisementError       string
+	AdvertisementParseErrors string
+	URI                      string
+	Appearance               string
+	AdvInterval              string
+	LEFeatures               string
+	PublicTargetAddresses    string
+	RandomTargetAddresses    string
+	PBADV                    string
+	MeshMessage              string
+	MeshBeacon               string
 }{
-	MAC:                "mac",
-	RSSI:               "rssi",
-	Name:               "name",
-	MFG:                "mfg",
-	Services:           "services",
-	ServiceData:        "serviceData",
-	Connectable:        "connectable",
-	Solicited:          "solicited",
-	EventType:          "eventType",
-	Flags:              "flags",
-	TxPower:            "txPower",
-	AddressType:        "addressType",
-	Controller:         "controllerMac",
-	Timestamp:          "timestamp",
-	AdvertisementError: "advertisementError",
+	MAC:                      "mac",
+	RSSI:                     "rssi",
+	Name:                     "name",
+	MFG:                      "mfg",
+	Services:                 "services",
+	ServiceData:              "serviceData",
+	Connectable:              "connectable",
+	Solicited:                "solicited",
+	EventType:                "eventType",
+	Flags:                    "flags",
+	TxPower:                  "txPower",
+	AddressType:              "addressType",
+	IdentityAddress:          "identityAddress",
+	Controller:               "controllerMac",
+	Timestamp:                "timestamp",
+	AdvertisementError:       "advertisementError",
+	AdvertisementParseErrors: "advertisementParseErrors",
+	URI:                      "uri",
+	Appearance:               "appearance",
+	AdvInterval:              "advInterval",
+	LEFeatures:               "leFeatures",
+	PublicTargetAddresses:    "publicTargetAddresses",
+	RandomTargetAddresses:    "randomTargetAddresses",
+	PBADV:                    "pbAdv",
+	MeshMessage:              "meshMessage",
+	MeshBeacon:               "meshBeacon",
 }
 
 // ServiceData ...
@@ -4,4 +4,7 @@ type GattCache interface {
 	Store(Addr, Profile, bool) error
 	Load(Addr) (Profile, error)
 	Clear() error
+
+	// Remove removes the profile of a single peer, if any.
+	Remove(Addr) error
 }
@@ -64,6 +64,24 @@ func (gc *gattCache) Load(mac ble.Addr) (ble.Profile, error) {
 	return p, nil
 }
 
+// Remove removes the profile of mac from the cache, if any.
+func (gc *gattCache) Remove(mac ble.Addr) error {
+	gc.Lock()
+	defer gc.Unlock()
+
+	cache, err := gc.loadExisting()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := cache[mac.String()]; !ok {
+		return nil
+	}
+	delete(cache, mac.String())
+
+	return gc.storeCache(cache)
+}
+
 func (gc *gattCache) Clear() error {
 	gc.Lock()
 	defer gc.Unlock()
@@ -0,0 +1,133 @@
+package ble
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Overheads of the ATT PDUs carrying attribute values, the octets of
+// ATT_MTU which aren't left for the value [Vol 3, Part F, 3.4].
+const (
+	// ReadOverhead is the opcode of Read and Read Blob Responses.
+	ReadOverhead = 1
+
+	// WriteOverhead is the opcode and the handle of Write Requests and
+	// Commands, and of Handle Value Notifications and Indications.
+	WriteOverhead = 3
+
+	// PrepareWriteOverhead is the opcode, the handle and the offset of
+	// Prepare Write Requests.
+	PrepareWriteOverhead = 5
+
+	// SignedWriteOverhead is the opcode, the handle and the signature of
+	// Signed Write Commands.
+	SignedWriteOverhead = 15
+)
+
+// MaxValueLen returns the length of the longest value a PDU with overhead
+// octets carries, for an ATT_MTU of mtu, e.g. MaxValueLen(c.TxMTU(),
+// WriteOverhead) for a Write Request or a notification.
+func MaxValueLen(mtu, overhead int) int {
+	if mtu <= overhead {
+		return 0
+	}
+	return mtu - overhead
+}
+
+// Chunks splits b into the values of consecutive PDUs with overhead octets,
+// for an ATT_MTU of mtu. The chunks share the memory of b. An empty b makes
+// no chunks.
+func Chunks(b []byte, mtu, overhead int) [][]byte {
+	n := MaxValueLen(mtu, overhead)
+	if n == 0 {
+		return nil
+	}
+	chunks := make([][]byte, 0, (len(b)+n-1)/n)
+	for len(b) > n {
+		chunks = append(chunks, b[:n:n])
+		b = b[n:]
+	}
+	if len(b) > 0 {
+		chunks = append(chunks, b)
+	}
+	return chunks
+}
+
+// Segment frames a message of up to 65535 octets into the values of
+// notifications or Write Commands for an ATT_MTU of mtu, for a Reassembler
+// on the other side to put it back together. The first segment starts with
+// the length of the message, 2 octets little-endian, which tells where the
+// message ends; there's no other framing.
+func Segment(msg []byte, mtu int) ([][]byte, error) {
+	if len(msg) > 0xFFFF {
+		return nil, fmt.Errorf("message of %d octets too long", len(msg))
+	}
+	if MaxValueLen(mtu, WriteOverhead) < 2 {
+		return nil, fmt.Errorf("invalid ATT_MTU %d", mtu)
+	}
+	b := make([]byte, 2+len(msg))
+	binary.LittleEndian.PutUint16(b, uint16(len(msg)))
+	copy(b[2:], msg)
+	return Chunks(b, mtu, WriteOverhead), nil
+}
+
+// ErrSegment is returned by Reassembler.Feed when a segment runs past the end
+// of the message, e.g. after a notification was lost.
+var ErrSegment = errors.New("segment past the end of the message")
+
+// Reassembler puts back together the messages framed by Segment, from the
+// values of the notifications, or of the writes, received in order. It
+// isn't safe for concurrent use.
+//
+//	var r ble.Reassembler
+//	h := func(v []byte) {
+//		msg, err := r.Feed(v)
+//		if err != nil || msg == nil {
+//			return
+//		}
+//		...
+//	}
+type Reassembler struct {
+	// MaxLen, if not 0, is the length of the longest message accepted.
+	MaxLen int
+
+	buf  []byte
+	want int
+	busy bool
+}
+
+// Feed adds the segment seg. It returns the message once it's complete, and
+// nil until then. The messages are returned in buffers of their own. A
+// segment running past the end of the message, or a message longer than
+// MaxLen, fails with an error, and the message is dropped.
+func (r *Reassembler) Feed(seg []byte) ([]byte, error) {
+	if !r.busy {
+		if len(seg) < 2 {
+			return nil, fmt.Errorf("segment of %d octets without message length", len(seg))
+		}
+		r.want = int(binary.LittleEndian.Uint16(seg))
+		if r.MaxLen > 0 && r.want > r.MaxLen {
+			return nil, fmt.Errorf("message of %d octets too long", r.want)
+		}
+		r.buf, r.busy = make([]byte, 0, r.want), true
+		seg = seg[2:]
+	}
+	if len(r.buf)+len(seg) > r.want {
+		r.Reset()
+		return nil, ErrSegment
+	}
+	r.buf = append(r.buf, seg...)
+	if len(r.buf) < r.want {
+		return nil, nil
+	}
+	msg := r.buf
+	r.Reset()
+	return msg, nil
+}
+
+// Reset drops the message being put together, e.g. to start over after
+// reconnecting.
+func (r *Reassembler) Reset() {
+	r.buf, r.want, r.busy = nil, 0, false
+}
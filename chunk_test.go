@@ -0,0 +1,84 @@
+package ble
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChunks(t *testing.T) {
+	b := []byte("0123456789")
+	for _, tc := range []struct {
+		mtu, overhead int
+		want          []string
+	}{
+		{8, WriteOverhead, []string{"01234", "56789"}},
+		{9, WriteOverhead, []string{"012345", "6789"}},
+		{23, WriteOverhead, []string{"0123456789"}},
+		{8, PrepareWriteOverhead, []string{"012", "345", "678", "9"}},
+		{3, WriteOverhead, nil},
+	} {
+		var got []string
+		for _, c := range Chunks(b, tc.mtu, tc.overhead) {
+			got = append(got, string(c))
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("mtu %d, overhead %d: chunks %q, want %q", tc.mtu, tc.overhead, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("mtu %d, overhead %d: chunks %q, want %q", tc.mtu, tc.overhead, got, tc.want)
+				break
+			}
+		}
+	}
+	if c := Chunks(nil, 23, WriteOverhead); len(c) != 0 {
+		t.Errorf("chunks of nothing %q", c)
+	}
+}
+
+func TestSegment(t *testing.T) {
+	var r Reassembler
+	for _, msg := range [][]byte{[]byte("hello, world"), {}, bytes.Repeat([]byte{0xAA}, 100)} {
+		segs, err := Segment(msg, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, s := range segs {
+			if len(s) > MaxValueLen(8, WriteOverhead) {
+				t.Fatalf("segment of %d octets", len(s))
+			}
+			got, err := r.Feed(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last := i == len(segs)-1; last != (got != nil) {
+				t.Fatalf("segment %d of %d: message %q", i, len(segs), got)
+			}
+			if got != nil && !bytes.Equal(got, msg) {
+				t.Fatalf("message %q, want %q", got, msg)
+			}
+		}
+	}
+
+	// A lost segment makes the next message run past the end.
+	segs, _ := Segment([]byte("0123456789"), 8)
+	next, _ := Segment([]byte("abcdefgh"), 8)
+	if _, err := r.Feed(segs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Feed(next[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Feed(next[1]); err != ErrSegment {
+		t.Fatalf("fed past the end: %v", err)
+	}
+
+	r.MaxLen = 4
+	if _, err := r.Feed(segs[0]); err == nil {
+		t.Fatal("accepted message longer than MaxLen")
+	}
+	if _, err := Segment(make([]byte, 0x10000), 23); err == nil {
+		t.Fatal("segmented message too long")
+	}
+}
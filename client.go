@@ -21,6 +21,17 @@ type Client interface {
 	// If a cache file is not designated via an option, this function will return an error
 	DiscoverAndCacheProfile(force bool) (*Profile, error)
 
+	// InvalidateProfile drops the discovered profile, and the cached one, if
+	// any, so that the next discovery starts over.
+	InvalidateProfile() error
+
+	// RefreshService rediscovers the primary service with the UUID u, with its
+	// characteristics and descriptors, in place of the one of the profile,
+	// after a known change of the service on the server. It fails if the
+	// server no longer has the service, which is then removed from the profile.
+	// Subscriptions to characteristics of the service must be renewed.
+	RefreshService(u UUID) (*Service, error)
+
 	// DiscoverServices finds all the primary services on a server. [Vol 3, Part G, 4.4.1]
 	// If filter is specified, only filtered services are returned.
 	DiscoverServices(filter []UUID) ([]*Service, error)
@@ -79,6 +90,4 @@ type Client interface {
 	Pair(AuthData, time.Duration) error
 
 	StartEncryption(c chan EncryptionChangedInfo) error
-
-	PrepareCustomPairing(c chan bool)
 }
@@ -0,0 +1,292 @@
+// Command blebeacon advertises iBeacon, Eddystone and AltBeacon payloads,
+// given by flags for a single beacon, or by a JSON config file for several:
+//
+//	{
+//		"rotate": "1s",
+//		"sets": [
+//			{"type": "ibeacon", "uuid": "...", "major": 1, "minor": 2, "power": -59},
+//			{"type": "eddystone-url", "url": "https://example.com", "power": -20},
+//			{"type": "eddystone-tlm", "voltage": 3000, "temperature": 21.5}
+//		]
+//	}
+//
+// The controller advertises a single set at a time, so sets take turns,
+// each advertised for the rotate duration. Payloads are rebuilt on each
+// turn, which keeps the telemetry of Eddystone-TLM current. SIGHUP reloads
+// the config file.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/examples/lib/dev"
+	"github.com/leso-kn/ble/linux/adv"
+)
+
+var (
+	device = flag.String("device", "default", "implementation of ble, e.g. bluez")
+	id     = flag.Int("id", -1, "adapter index, as in hciX")
+	config = flag.String("config", "", "JSON file with the sets to advertise, instead of the beacon flags")
+	rotate = flag.Duration("rotate", time.Second, "time each set is advertised before the next, if not in the config")
+	du     = flag.Duration("du", 0, "advertising duration, 0 for indefinitely")
+
+	b beacon
+)
+
+func init() {
+	flag.StringVar(&b.Type, "type", "ibeacon", "beacon type: ibeacon, eddystone-uid, eddystone-url, eddystone-tlm or altbeacon")
+	flag.StringVar(&b.Name, "name", "", "local name, advertised in the scan response")
+	flag.StringVar(&b.UUID, "uuid", "", "proximity UUID of an iBeacon, or ID1 of an AltBeacon")
+	flag.Var(uint16Flag{&b.Major}, "major", "major of an iBeacon, or ID2 of an AltBeacon")
+	flag.Var(uint16Flag{&b.Minor}, "minor", "minor of an iBeacon, or ID3 of an AltBeacon")
+	flag.Var(int8Flag{&b.Power}, "power", "calibrated power in dBm, at 1 m for iBeacon and AltBeacon, and 0 m for Eddystone")
+	flag.StringVar(&b.Namespace, "namespace", "", "namespace of an Eddystone-UID, 10 bytes in hex")
+	flag.StringVar(&b.Instance, "instance", "", "instance of an Eddystone-UID, 6 bytes in hex")
+	flag.StringVar(&b.URL, "url", "", "URL of an Eddystone-URL")
+	flag.Var(uint16Flag{&b.MfgID}, "mfg", "manufacturer of an AltBeacon")
+	flag.Var(uint16Flag{&b.Voltage}, "voltage", "battery voltage in mV of an Eddystone-TLM, 0 if unknown")
+	flag.Float64Var(&b.Temperature, "temp", -128, "temperature in degrees Celsius of an Eddystone-TLM, -128 if unknown")
+}
+
+type uint16Flag struct{ v *uint16 }
+
+func (f uint16Flag) String() string {
+	if f.v == nil {
+		return "0"
+	}
+	return fmt.Sprint(*f.v)
+}
+
+func (f uint16Flag) Set(s string) error {
+	_, err := fmt.Sscan(s, f.v)
+	return err
+}
+
+type int8Flag struct{ v *int8 }
+
+func (f int8Flag) String() string {
+	if f.v == nil {
+		return "0"
+	}
+	return fmt.Sprint(*f.v)
+}
+
+func (f int8Flag) Set(s string) error {
+	_, err := fmt.Sscan(s, f.v)
+	return err
+}
+
+// beacon is an advertising set.
+type beacon struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+
+	UUID      string `json:"uuid"`
+	Major     uint16 `json:"major"`
+	Minor     uint16 `json:"minor"`
+	Power     int8   `json:"power"`
+	Namespace string `json:"namespace"`
+	Instance  string `json:"instance"`
+	URL       string `json:"url"`
+	MfgID     uint16 `json:"mfg"`
+
+	Voltage     uint16  `json:"voltage"`
+	Temperature float64 `json:"temperature"`
+}
+
+// setup is the list of sets to advertise in turn.
+type setup struct {
+	Rotate string   `json:"rotate"`
+	Sets   []beacon `json:"sets"`
+
+	rotate time.Duration
+}
+
+func load(name string) (*setup, error) {
+	f, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &setup{}
+	if err := json.Unmarshal(f, s); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if len(s.Sets) == 0 {
+		return nil, fmt.Errorf("%s: no sets", name)
+	}
+	s.rotate = *rotate
+	if s.Rotate != "" {
+		if s.rotate, err = time.ParseDuration(s.Rotate); err != nil {
+			return nil, fmt.Errorf("%s: rotate: %v", name, err)
+		}
+	}
+	// Check the sets up front, rather than on their turn.
+	for i, b := range s.Sets {
+		if _, _, err := b.build(0, 0); err != nil {
+			return nil, fmt.Errorf("%s: set %d: %v", name, i, err)
+		}
+	}
+	return s, nil
+}
+
+// hexArray decodes s into a, which it must fill.
+func hexArray(a []byte, s, what string) error {
+	h, err := hex.DecodeString(s)
+	if err != nil || len(h) != len(a) {
+		return fmt.Errorf("%s must be %d bytes in hex", what, len(a))
+	}
+	copy(a, h)
+	return nil
+}
+
+// build returns the advertising data and scan response of b. The uptime and
+// the turns advertised so far fill Eddystone-TLM frames.
+func (b beacon) build(uptime time.Duration, turns uint32) (ad, sr []byte, err error) {
+	ab := adv.NewBuilder().Flags(adv.FlagGeneralDiscoverable | adv.FlagLEOnly)
+	switch b.Type {
+	case "ibeacon", "altbeacon":
+		u, err := ble.Parse(b.UUID)
+		if err != nil || u.Len() != 16 {
+			return nil, nil, fmt.Errorf("invalid uuid %q", b.UUID)
+		}
+		f := adv.IBeacon(u, b.Major, b.Minor, b.Power)
+		if b.Type == "altbeacon" {
+			d := adv.AltBeaconData{MfgID: b.MfgID, ID2: b.Major, ID3: b.Minor, RefRSSI: b.Power}
+			copy(d.ID1[:], ble.Reverse(u))
+			f = adv.AltBeacon(d)
+		}
+		p, err := adv.NewPacket(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The packet holds the manufacturer data field alone.
+		md := p.Bytes()[2:]
+		ab.ManufacturerData(binary.LittleEndian.Uint16(md), md[2:])
+	case "eddystone-uid", "eddystone-url", "eddystone-tlm":
+		var f adv.EddystoneFrame
+		switch b.Type {
+		case "eddystone-uid":
+			uid := adv.EddystoneUID{TxPower: b.Power}
+			if err := hexArray(uid.Namespace[:], b.Namespace, "namespace"); err != nil {
+				return nil, nil, err
+			}
+			if err := hexArray(uid.Instance[:], b.Instance, "instance"); err != nil {
+				return nil, nil, err
+			}
+			f = uid
+		case "eddystone-url":
+			f = adv.EddystoneURL{TxPower: b.Power, URL: b.URL}
+		default:
+			f = adv.EddystoneTLM{
+				BatteryVoltage: b.Voltage,
+				Temperature:    b.Temperature,
+				AdvCount:       turns,
+				Uptime:         uptime,
+			}
+		}
+		d, err := f.Bytes()
+		if err != nil {
+			return nil, nil, err
+		}
+		ab.Services(ble.UUID16(adv.EddystoneUUID)).ServiceData(ble.UUID16(adv.EddystoneUUID), d)
+	default:
+		return nil, nil, fmt.Errorf("unknown beacon type %q", b.Type)
+	}
+	if b.Name != "" {
+		ab.ScanResponse().Name(b.Name)
+	}
+	a, s, err := ab.Build()
+	if err != nil {
+		return nil, nil, err
+	}
+	return a.Bytes(), s.Bytes(), nil
+}
+
+func main() {
+	flag.Parse()
+
+	s := &setup{Sets: []beacon{b}, rotate: *rotate}
+	if *config != "" {
+		var err error
+		if s, err = load(*config); err != nil {
+			log.Fatalf("can't load config: %s", err)
+		}
+	} else if _, _, err := b.build(0, 0); err != nil {
+		log.Fatalf("invalid beacon: %s", err)
+	}
+
+	var opts []ble.Option
+	if *id >= 0 {
+		opts = append(opts, ble.OptDeviceID(*id))
+	}
+	d, err := dev.NewDevice(*device, opts...)
+	if err != nil {
+		log.Fatalf("can't new device : %s", err)
+	}
+	defer d.Stop()
+
+	var mu sync.Mutex
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if *config == "" {
+				continue
+			}
+			ns, err := load(*config)
+			if err != nil {
+				log.Printf("can't reload config, keeping the current one: %s", err)
+				continue
+			}
+			mu.Lock()
+			s = ns
+			mu.Unlock()
+			log.Printf("reloaded %d sets", len(ns.Sets))
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if *du > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), *du)
+	}
+	ctx = ble.WithSigHandler(ctx, cancel)
+
+	start := time.Now()
+	turns := map[int]uint32{}
+	for i := 0; ctx.Err() == nil; i++ {
+		mu.Lock()
+		cur := s
+		mu.Unlock()
+		n := i % len(cur.Sets)
+		b := cur.Sets[n]
+
+		ad, sr, err := b.build(time.Since(start), turns[n])
+		if err != nil {
+			log.Fatalf("set %d: %s", n, err)
+		}
+		turns[n]++
+		if len(cur.Sets) > 1 {
+			log.Printf("advertising set %d, %s", n, b.Type)
+		}
+		actx, acancel := context.WithTimeout(ctx, cur.rotate)
+		err = d.AdvertiseData(actx, ad, sr)
+		acancel()
+		if err != nil && err != context.DeadlineExceeded && err != context.Canceled {
+			log.Fatalf("can't advertise: %s", err)
+		}
+	}
+	log.Printf("done")
+}
@@ -0,0 +1,287 @@
+// Command bleexplore connects to a peripheral, prints its GATT profile, and
+// reads, writes and subscribes to its attributes from an interactive prompt.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/examples/lib/dev"
+	"github.com/pkg/errors"
+)
+
+var (
+	device = flag.String("device", "default", "implementation of ble, e.g. bluez")
+	id     = flag.Int("id", -1, "adapter index, as in hciX")
+	name   = flag.String("name", "", "name of the peripheral")
+	addr   = flag.String("addr", "", "address of the peripheral (MAC on Linux, UUID on OS X)")
+	sd     = flag.Duration("sd", 10*time.Second, "scanning duration")
+	cache  = flag.String("cache", "", "file caching the discovered profiles")
+	force  = flag.Bool("force", false, "discover the profile again, even if cached")
+)
+
+func main() {
+	flag.Parse()
+	if *name == "" && *addr == "" {
+		log.Fatalf("-name or -addr is required")
+	}
+
+	var opts []ble.Option
+	if *id >= 0 {
+		opts = append(opts, ble.OptDeviceID(*id))
+	}
+	if *cache != "" {
+		opts = append(opts, ble.OptGattCacheFile(*cache))
+	}
+	d, err := dev.NewDevice(*device, opts...)
+	if err != nil {
+		log.Fatalf("can't new device : %s", err)
+	}
+	defer d.Stop()
+
+	filter := func(a ble.Advertisement) bool {
+		if *addr != "" {
+			return strings.EqualFold(a.Addr().String(), *addr)
+		}
+		return strings.EqualFold(a.LocalName(), *name)
+	}
+	fmt.Printf("Scanning for %s...\n", *sd)
+	ctx, cancel := context.WithTimeout(context.Background(), *sd)
+	cln, err := dev.Connect(ble.WithSigHandler(ctx, cancel), d, filter)
+	if err != nil {
+		log.Fatalf("can't connect : %s", err)
+	}
+	defer cln.CancelConnection()
+	fmt.Printf("Connected to %s\n", cln.Addr())
+
+	p, err := cln.DiscoverAndCacheProfile(*force)
+	if err != nil {
+		log.Fatalf("can't discover profile: %s", err)
+	}
+	e := &explorer{cln: cln, p: p}
+	e.profile(nil)
+
+	go func() {
+		<-cln.Disconnected()
+		fmt.Printf("\n%s disconnected\n", cln.Addr())
+		os.Exit(1)
+	}()
+	e.run(bufio.NewScanner(os.Stdin))
+}
+
+// explorer runs the commands of the prompt.
+type explorer struct {
+	cln ble.Client
+	p   *ble.Profile
+}
+
+type command struct {
+	usage string
+	help  string
+	fn    func(e *explorer, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"ls":    {"ls", "print the profile", (*explorer).profile},
+		"read":  {"read <attr>", "read a characteristic or descriptor", (*explorer).read},
+		"write": {"write <attr> <hex> [nr]", "write a characteristic or descriptor; nr writes without response", (*explorer).write},
+		"sub":   {"sub <attr> [ind]", "subscribe to notifications, or indications", (*explorer).sub},
+		"unsub": {"unsub <attr> [ind]", "unsubscribe", (*explorer).unsub},
+		"rssi":  {"rssi", "read the RSSI", (*explorer).rssi},
+		"help":  {"help", "print the commands", (*explorer).help},
+	}
+}
+
+func (e *explorer) run(s *bufio.Scanner) {
+	fmt.Println(`Attributes are given by handle, e.g. 0x2a, or UUID. "help" lists the commands.`)
+	for {
+		fmt.Print("> ")
+		if !s.Scan() {
+			return
+		}
+		args := strings.Fields(s.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" || args[0] == "exit" {
+			return
+		}
+		c, ok := commands[args[0]]
+		if !ok {
+			fmt.Printf("unknown command %q\n", args[0])
+			continue
+		}
+		if err := c.fn(e, args[1:]); err != nil {
+			fmt.Printf("%s: %s\n", args[0], err)
+		}
+	}
+}
+
+func (e *explorer) help(args []string) error {
+	for _, k := range []string{"ls", "read", "write", "sub", "unsub", "rssi", "help"} {
+		fmt.Printf("  %-24s %s\n", commands[k].usage, commands[k].help)
+	}
+	fmt.Printf("  %-24s %s\n", "quit", "disconnect and exit")
+	return nil
+}
+
+// label returns the UUID of an attribute with its assigned name, if any.
+func label(u ble.UUID) string {
+	if n := ble.Name(u); n != "" {
+		return fmt.Sprintf("%s (%s)", u, n)
+	}
+	return u.String()
+}
+
+var propNames = []struct {
+	p    ble.Property
+	name string
+}{
+	{ble.CharBroadcast, "broadcast"},
+	{ble.CharRead, "read"},
+	{ble.CharWriteNR, "write-nr"},
+	{ble.CharWrite, "write"},
+	{ble.CharNotify, "notify"},
+	{ble.CharIndicate, "indicate"},
+	{ble.CharSignedWrite, "signed-write"},
+	{ble.CharExtended, "extended"},
+}
+
+func props(p ble.Property) string {
+	var ss []string
+	for _, n := range propNames {
+		if p&n.p != 0 {
+			ss = append(ss, n.name)
+		}
+	}
+	return strings.Join(ss, ",")
+}
+
+func (e *explorer) profile(args []string) error {
+	for _, s := range e.p.Services {
+		fmt.Printf("Service 0x%04X-0x%04X %s\n", s.Handle, s.EndHandle, label(s.UUID))
+		for _, c := range s.Characteristics {
+			fmt.Printf("  Characteristic 0x%04X %s [%s]\n", c.ValueHandle, label(c.UUID), props(c.Property))
+			for _, d := range c.Descriptors {
+				fmt.Printf("    Descriptor 0x%04X %s\n", d.Handle, label(d.UUID))
+			}
+		}
+	}
+	return nil
+}
+
+// find returns the characteristic, by value handle or UUID, or the
+// descriptor, by handle or UUID, named by s.
+func (e *explorer) find(s string) (*ble.Characteristic, *ble.Descriptor, error) {
+	var h uint64
+	byHandle := false
+	if strings.HasPrefix(s, "0x") {
+		if _, err := fmt.Sscanf(s, "0x%x", &h); err != nil {
+			return nil, nil, errors.Errorf("invalid handle %q", s)
+		}
+		byHandle = true
+	}
+	var u ble.UUID
+	if !byHandle {
+		var err error
+		if u, err = ble.Parse(s); err != nil {
+			return nil, nil, errors.Errorf("invalid attribute %q", s)
+		}
+	}
+	for _, sv := range e.p.Services {
+		for _, c := range sv.Characteristics {
+			if (byHandle && uint64(c.ValueHandle) == h) || (!byHandle && c.UUID.Equal(u)) {
+				return c, nil, nil
+			}
+			for _, d := range c.Descriptors {
+				if (byHandle && uint64(d.Handle) == h) || (!byHandle && d.UUID.Equal(u)) {
+					return c, d, nil
+				}
+			}
+		}
+	}
+	return nil, nil, errors.Errorf("no attribute %s", s)
+}
+
+func (e *explorer) read(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: " + commands["read"].usage)
+	}
+	c, d, err := e.find(args[0])
+	if err != nil {
+		return err
+	}
+	var b []byte
+	if d != nil {
+		b, err = e.cln.ReadDescriptor(d)
+	} else {
+		b, err = e.cln.ReadLongCharacteristic(c)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("% X | %q\n", b, b)
+	return nil
+}
+
+func (e *explorer) write(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New("usage: " + commands["write"].usage)
+	}
+	c, d, err := e.find(args[0])
+	if err != nil {
+		return err
+	}
+	var b []byte
+	if _, err := fmt.Sscanf(args[1], "%x", &b); err != nil {
+		return errors.Errorf("invalid value %q", args[1])
+	}
+	if d != nil {
+		return e.cln.WriteDescriptor(d, b)
+	}
+	return e.cln.WriteCharacteristic(c, b, len(args) == 3 && args[2] == "nr")
+}
+
+func (e *explorer) sub(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: " + commands["sub"].usage)
+	}
+	c, d, err := e.find(args[0])
+	if err != nil || d != nil {
+		return errors.Errorf("no characteristic %s", args[0])
+	}
+	ind := len(args) == 2 && args[1] == "ind"
+	return e.cln.Subscribe(c, ind, func(id uint, b []byte) {
+		fmt.Printf("\n0x%04X #%d: % X | %q\n> ", c.ValueHandle, id, b, b)
+	})
+}
+
+func (e *explorer) unsub(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: " + commands["unsub"].usage)
+	}
+	c, d, err := e.find(args[0])
+	if err != nil || d != nil {
+		return errors.Errorf("no characteristic %s", args[0])
+	}
+	return e.cln.Unsubscribe(c, len(args) == 2 && args[1] == "ind")
+}
+
+func (e *explorer) rssi(args []string) error {
+	r, err := e.cln.ReadRSSI()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d dBm\n", r)
+	return nil
+}
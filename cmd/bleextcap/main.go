@@ -0,0 +1,107 @@
+// Command bleextcap is a Wireshark extcap, which opens live captures of the
+// HCI traffic of applications built on this package.
+//
+// An application serves its traffic with a replay.Tap passed to
+// ble.OptTransportRecord, e.g.
+//
+//	tap, err := replay.ListenTap("localhost:9595")
+//	...
+//	d, err := linux.NewDevice(ble.OptTransportRecord(tap))
+//
+// Copy bleextcap to Wireshark's extcap folder, listed in About > Folders.
+// The "BLE stack" interface then appears in the capture interfaces; its
+// options set the address of the Tap.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"github.com/leso-kn/ble/linux/hci/replay"
+)
+
+const iface = "bletap"
+
+var (
+	interfaces = flag.Bool("extcap-interfaces", false, "list the interfaces")
+	dlts       = flag.Bool("extcap-dlts", false, "list the link types of the interface")
+	config     = flag.Bool("extcap-config", false, "list the options of the interface")
+	capture    = flag.Bool("capture", false, "capture to the fifo")
+	ifaceName  = flag.String("extcap-interface", "", "interface to use")
+	fifo       = flag.String("fifo", "", "fifo to write the capture to")
+	address    = flag.String("address", "localhost:9595", "address of the application's Tap")
+
+	// Passed by Wireshark, and unused.
+	_ = flag.String("extcap-version", "", "version of Wireshark")
+	_ = flag.String("extcap-capture-filter", "", "capture filter")
+	_ = flag.String("extcap-control-in", "", "control pipe from Wireshark")
+	_ = flag.String("extcap-control-out", "", "control pipe to Wireshark")
+	_ = flag.Bool("debug", false, "debug logging")
+	_ = flag.String("debug-file", "", "debug log file")
+)
+
+func main() {
+	flag.Parse()
+	switch {
+	case *interfaces:
+		fmt.Println("extcap {version=1.0}{help=https://github.com/leso-kn/ble}")
+		fmt.Printf("interface {value=%s}{display=BLE stack (HCI over a replay.Tap)}\n", iface)
+	case *ifaceName != iface:
+		log.Fatalf("unknown interface %q", *ifaceName)
+	case *dlts:
+		fmt.Printf("dlt {number=201}{name=BLUETOOTH_HCI_H4_WITH_PHDR}{display=Bluetooth HCI H4 with direction}\n")
+	case *config:
+		fmt.Printf("arg {number=0}{call=--address}{display=Address}{type=string}{default=%s}"+
+			"{tooltip=host:port the application's replay.Tap listens on}\n", *address)
+	case *capture:
+		if *fifo == "" {
+			log.Fatal("no fifo")
+		}
+		if err := run(*address, *fifo); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// run copies the btsnoop capture served at addr to the fifo, as pcap, until
+// the application closes it or Wireshark stops the capture.
+func run(addr, fifo string) error {
+	f, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pw, err := replay.NewPcapWriter(f)
+	if err != nil {
+		return err
+	}
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	tr, err := replay.NewTraceReader(c)
+	if err != nil {
+		return err
+	}
+	for {
+		p, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := pw.WritePacket(p); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Command blescan scans for advertisements and prints them as JSON, one
+// object per line, or as a single array.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/examples/lib/dev"
+	"github.com/pkg/errors"
+)
+
+var (
+	device  = flag.String("device", "default", "implementation of ble, e.g. bluez")
+	id      = flag.Int("id", -1, "adapter index, as in hciX")
+	du      = flag.Duration("du", 5*time.Second, "scanning duration; 0 scans until interrupted")
+	dup     = flag.Bool("dup", false, "report duplicate advertisements")
+	dedup   = flag.Duration("dedup", 0, "report identical advertisements once per window")
+	passive = flag.Bool("passive", false, "scan passively, without scan requests")
+	format  = flag.String("format", "ndjson", "output format: ndjson or json")
+
+	name = flag.String("name", "", "only report advertisers with this name")
+	addr = flag.String("addr", "", "only report the advertiser with this address")
+	svc  = flag.String("svc", "", "only report advertisers of this service UUID")
+	rssi = flag.Int("rssi", -128, "only report advertisements with at least this RSSI")
+	mfg  = flag.String("mfg", "", "only report manufacturer data of this company id, e.g. 004c")
+)
+
+// record is the JSON form of an advertisement.
+type record struct {
+	Time         time.Time         `json:"time"`
+	Addr         string            `json:"addr"`
+	AddrType     uint8             `json:"addrType"`
+	RSSI         int               `json:"rssi"`
+	Connectable  bool              `json:"connectable"`
+	Name         string            `json:"name,omitempty"`
+	Services     []string          `json:"services,omitempty"`
+	ServiceData  map[string]string `json:"serviceData,omitempty"`
+	Manufacturer string            `json:"mfg,omitempty"`
+	TxPower      *int              `json:"txPower,omitempty"`
+	Data         string            `json:"data,omitempty"`
+	ScanResponse string            `json:"sr,omitempty"`
+}
+
+func newRecord(a ble.Advertisement) record {
+	r := record{
+		Time:         time.Unix(0, a.Timestamp()),
+		Addr:         a.Addr().String(),
+		AddrType:     a.AddrType(),
+		RSSI:         a.RSSI(),
+		Connectable:  a.Connectable(),
+		Name:         a.LocalName(),
+		Manufacturer: hex.EncodeToString(a.ManufacturerData()),
+		Data:         hex.EncodeToString(a.Data()),
+		ScanResponse: hex.EncodeToString(a.SrData()),
+	}
+	for _, u := range a.Services() {
+		r.Services = append(r.Services, u.String())
+	}
+	for _, sd := range a.ServiceData() {
+		if r.ServiceData == nil {
+			r.ServiceData = map[string]string{}
+		}
+		r.ServiceData[sd.UUID.String()] = hex.EncodeToString(sd.Data)
+	}
+	if m, err := a.ToMap(); err == nil {
+		if _, ok := m[ble.AdvertisementMapKeys.TxPower]; ok {
+			p := a.TxPowerLevel()
+			r.TxPower = &p
+		}
+	}
+	return r
+}
+
+// filter returns the filter of the flags, or nil.
+func filter() (ble.AdvFilter, error) {
+	var fs []ble.AdvFilter
+	if *name != "" {
+		fs = append(fs, func(a ble.Advertisement) bool {
+			return strings.EqualFold(a.LocalName(), *name)
+		})
+	}
+	if *addr != "" {
+		fs = append(fs, func(a ble.Advertisement) bool {
+			return a.Addr().String() == strings.ToLower(*addr)
+		})
+	}
+	if *svc != "" {
+		u, err := ble.Parse(*svc)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid service uuid")
+		}
+		fs = append(fs, func(a ble.Advertisement) bool {
+			return ble.Contains(a.Services(), u)
+		})
+	}
+	if *rssi > -128 {
+		fs = append(fs, func(a ble.Advertisement) bool {
+			return a.RSSI() >= *rssi
+		})
+	}
+	if *mfg != "" {
+		cid, err := strconv.ParseUint(*mfg, 16, 16)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid company id")
+		}
+		fs = append(fs, func(a ble.Advertisement) bool {
+			md := a.ManufacturerData()
+			return len(md) >= 2 && binary.LittleEndian.Uint16(md) == uint16(cid)
+		})
+	}
+	if len(fs) == 0 {
+		return nil, nil
+	}
+	return func(a ble.Advertisement) bool {
+		for _, f := range fs {
+			if !f(a) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// options returns the device options of the flags. Only those which are set
+// are returned, as not every implementation supports them.
+func options() []ble.Option {
+	var opts []ble.Option
+	if *id >= 0 {
+		opts = append(opts, ble.OptDeviceID(*id))
+	}
+	if *passive {
+		opts = append(opts, ble.OptActiveScan(false))
+	}
+	if *dedup > 0 {
+		opts = append(opts, ble.OptScanDedup(*dedup))
+	}
+	return opts
+}
+
+func main() {
+	flag.Parse()
+	if *format != "ndjson" && *format != "json" {
+		log.Fatalf("unknown format %q", *format)
+	}
+	f, err := filter()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	d, err := dev.NewDevice(*device, options()...)
+	if err != nil {
+		log.Fatalf("can't new device : %s", err)
+	}
+	defer d.Stop()
+
+	var mu sync.Mutex
+	var recs []record
+	enc := json.NewEncoder(os.Stdout)
+	h := func(a ble.Advertisement) {
+		if f != nil && !f(a) {
+			return
+		}
+		r := newRecord(a)
+		mu.Lock()
+		defer mu.Unlock()
+		if *format == "json" {
+			recs = append(recs, r)
+			return
+		}
+		enc.Encode(r)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if *du > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), *du)
+	}
+	ctx = ble.WithSigHandler(ctx, cancel)
+	err = d.Scan(ctx, *dup, h)
+	switch errors.Cause(err) {
+	case nil, context.DeadlineExceeded, context.Canceled:
+	default:
+		log.Fatalf("can't scan: %s", err)
+	}
+
+	if *format == "json" {
+		mu.Lock()
+		defer mu.Unlock()
+		if recs == nil {
+			recs = []record{}
+		}
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(recs); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
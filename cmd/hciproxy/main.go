@@ -0,0 +1,172 @@
+// Command hciproxy lends a local controller, e.g. one plugged into a lab
+// board, to remote hosts over the H4 TCP transport. Clients connect with
+// ble.OptTransportH4Socket, and ble.OptH4SocketAuth with settings matching
+// the -cert and -tokenfile flags.
+//
+// A controller serves a single host at a time. The -policy flag decides
+// whether clients connecting meanwhile are refused, take over, or wait, and
+// -allow restricts which networks may connect at all. With -status, the
+// state of the proxy is served as JSON over HTTP.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/leso-kn/ble/linux/hci/h4"
+	"github.com/leso-kn/ble/linux/hci/socket"
+)
+
+var (
+	listen = flag.String("listen", ":8888", "address to serve the controller on")
+	h4uart = flag.String("h4u", "", "serial port of an H4 controller, instead of -device")
+	hciSkt = flag.Int("device", -1, "hci index")
+
+	cert      = flag.String("cert", "", "TLS certificate; enables TLS with -key")
+	key       = flag.String("key", "", "TLS key")
+	clientCA  = flag.String("clientca", "", "CA of the client certificates to require")
+	tokenFile = flag.String("tokenfile", "", "file with the token clients must present")
+
+	policy = flag.String("policy", string(h4.ProxyRefuse), "treatment of clients connecting while another is served: refuse, takeover or wait")
+	allow  = flag.String("allow", "", "comma separated networks clients may connect from, e.g. 10.0.0.0/8; all if empty")
+	status = flag.String("status", "", "address to serve the status on over HTTP, e.g. localhost:8889")
+)
+
+// auth returns the proxy's auth settings from the flags, or nil.
+func auth() (*h4.SocketAuth, error) {
+	a := &h4.SocketAuth{}
+	if *cert != "" {
+		c, err := tls.LoadX509KeyPair(*cert, *key)
+		if err != nil {
+			return nil, err
+		}
+		a.TLS = &tls.Config{Certificates: []tls.Certificate{c}}
+		if *clientCA != "" {
+			pem, err := ioutil.ReadFile(*clientCA)
+			if err != nil {
+				return nil, err
+			}
+			a.TLS.ClientCAs = x509.NewCertPool()
+			if !a.TLS.ClientCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate in %s", *clientCA)
+			}
+			a.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	if *tokenFile != "" {
+		t, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		a.Token = strings.TrimSpace(string(t))
+	}
+	if a.TLS == nil && a.Token == "" {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// allowed returns a filter passing the addresses in the networks of s, or
+// nil if s is empty.
+func allowed(s string) (func(net.Addr) bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var nets []*net.IPNet
+	for _, c := range strings.Split(s, ",") {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(c))
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return func(a net.Addr) bool {
+		ta, ok := a.(*net.TCPAddr)
+		if !ok {
+			return false
+		}
+		for _, n := range nets {
+			if n.Contains(ta.IP) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func main() {
+	flag.Parse()
+
+	a, err := auth()
+	if err != nil {
+		log.Fatalf("can't set up auth: %s", err)
+	}
+	filter, err := allowed(*allow)
+	if err != nil {
+		log.Fatalf("invalid -allow: %s", err)
+	}
+
+	var dev io.ReadWriteCloser
+	if len(*h4uart) > 0 {
+		so := h4.DefaultSerialOptions()
+		so.PortName = *h4uart
+		dev, err = h4.NewSerial(so)
+	} else {
+		dev, err = socket.NewSocket(*hciSkt)
+	}
+	if err != nil {
+		log.Fatalf("can't open controller: %s", err)
+	}
+
+	if a == nil {
+		log.Printf("warning: serving plaintext HCI without a token")
+	}
+	p, err := h4.NewProxyWithAuth(dev, *listen, a)
+	if err != nil {
+		dev.Close()
+		log.Fatalf("can't listen: %s", err)
+	}
+	if err := p.SetPolicy(h4.ProxyPolicy(*policy)); err != nil {
+		p.Close()
+		log.Fatalf("%s", err)
+	}
+	p.SetAllow(filter)
+
+	if *status != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(p.Status())
+		})
+		go func() {
+			log.Printf("serving the status on http://%s/", *status)
+			if err := http.ListenAndServe(*status, mux); err != nil {
+				log.Printf("status: %s", err)
+			}
+		}()
+	}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		p.Close()
+	}()
+
+	log.Printf("serving the controller on %v, %s policy", p.Addr(), *policy)
+	if err := p.Serve(); err != nil {
+		log.Fatalf("proxy: %s", err)
+	}
+}
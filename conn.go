@@ -50,5 +50,97 @@ type Conn interface {
 
 	StartEncryption(change chan EncryptionChangedInfo) error
 
-	PrepareCustomPairing(c chan bool)
+	// RemoteFeatures returns the LE features supported by the remote device,
+	// as a bit mask of the LEFeature constants [Vol 6, Part B, 4.6].
+	RemoteFeatures() (uint64, error)
+
+	// RemoteVersion returns the link layer version information of the remote device.
+	RemoteVersion() (RemoteVersion, error)
+
+	// ConnInfo returns how the connection was established, for diagnostics.
+	ConnInfo() ConnInfo
+
+	// SetDeadline, SetReadDeadline and SetWriteDeadline set the deadlines of
+	// Read and Write, as those of a net.Conn. A zero time clears them.
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// ConnInfo describes how a connection was established, as reported by the
+// LE (Enhanced) Connection Complete and the LE Channel Selection Algorithm
+// events [Vol 2, Part E, 7.7.65.10 & 7.7.65.20]. Fields are zero when the
+// controller didn't report them.
+type ConnInfo struct {
+	// Central is set if the local device is the central of the connection.
+	Central bool
+
+	// LocalRPA and PeerRPA are the resolvable private addresses used to
+	// establish the connection, if the controller resolved them. LocalRPA is
+	// also the private address the host rotated the local address to, if any.
+	LocalRPA Addr
+	PeerRPA  Addr
+
+	// Interval, Latency and SupervisionTimeout are the connection parameters
+	// the connection was established with.
+	Interval           time.Duration
+	Latency            uint16
+	SupervisionTimeout time.Duration
+
+	// CentralClockAccuracy is the worst case sleep clock accuracy of the
+	// central, in ppm.
+	CentralClockAccuracy int
+
+	// ChannelSelectionAlgorithm is 1 or 2, the algorithm used to hop
+	// between the data channels [Vol 6, Part B, 4.5.8].
+	ChannelSelectionAlgorithm int
+}
+
+// RemoteVersion holds the link layer version information of a remote device
+// [Vol 2, Part E, 7.7.12].
+type RemoteVersion struct {
+	Version    uint8  // Link layer version, e.g. 0x09 for Bluetooth 5.0.
+	CompanyID  uint16 // Company identifier of the manufacturer.
+	Subversion uint16 // Implementation specific subversion.
 }
+
+// LE link layer features [Vol 6, Part B, 4.6].
+const (
+	LEFeatureEncryption               uint64 = 1 << 0
+	LEFeatureConnParamsRequest        uint64 = 1 << 1
+	LEFeatureExtendedRejectInd        uint64 = 1 << 2
+	LEFeatureSlaveFeatureExchange     uint64 = 1 << 3
+	LEFeaturePing                     uint64 = 1 << 4
+	LEFeatureDataLengthExtension      uint64 = 1 << 5
+	LEFeaturePrivacy                  uint64 = 1 << 6
+	LEFeatureExtendedScanFilterPolicy uint64 = 1 << 7
+	LEFeature2MPHY                    uint64 = 1 << 8
+	LEFeatureStableModulationIndexTx  uint64 = 1 << 9
+	LEFeatureStableModulationIndexRx  uint64 = 1 << 10
+	LEFeatureCodedPHY                 uint64 = 1 << 11
+	LEFeatureExtendedAdvertising      uint64 = 1 << 12
+	LEFeaturePeriodicAdvertising      uint64 = 1 << 13
+	LEFeatureChannelSelectionAlgo2    uint64 = 1 << 14
+	LEFeaturePowerClass1              uint64 = 1 << 15
+	LEFeatureMinUsedChannels          uint64 = 1 << 16
+)
+
+// ConnParams holds the LE connection parameters a remote device asks for when it
+// requests a connection parameter update [Vol 2, Part E, 7.7.65.6].
+type ConnParams struct {
+	IntervalMin uint16 // 0x0006 - 0x0C80; N * 1.25 msec
+	IntervalMax uint16 // 0x0006 - 0x0C80; N * 1.25 msec
+	Latency     uint16 // 0x0000 - 0x01F3; number of connection events
+	Timeout     uint16 // 0x000A - 0x0C80; N * 10 msec
+}
+
+// ConnParamsRequestHandler decides on a remote connection parameter request.
+// It returns the parameters to apply, which may be a modified version of req,
+// and whether the request is accepted at all.
+type ConnParamsRequestHandler func(a Addr, req ConnParams) (ConnParams, bool)
+
+// ConnParamsResultHandler is notified of the outcome of the connection
+// parameter update a peripheral requested from the central a: nil if the
+// central accepted the parameters, ErrConnParamsRejected if it rejected
+// them, or the error which prevented the request.
+type ConnParamsResultHandler func(a Addr, err error)
@@ -1,11 +1,139 @@
 package ble
 
+import (
+	"context"
+	"sync"
+)
+
 // ContextKey is a type used for keys of a context
 type ContextKey string
 
 var (
 	// ContextKeySig for SigHandler context
 	ContextKeySig = ContextKey("sig")
-	// ContextKeyCCC for per connection contexts
+	// ContextKeyCCC for per connection contexts.
+	//
+	// Deprecated: use ConnCCC, which returns the CCCD values a connected
+	// client wrote.
 	ContextKeyCCC = ContextKey("ccc")
 )
+
+// ContextKeyDialParams for the per call parameters of Dial
+var ContextKeyDialParams = ContextKey("dialParams")
+
+// ContextKeyConnID for the correlation ID of a connection
+var ContextKeyConnID = ContextKey("connID")
+
+// ConnIDFromContext returns the correlation ID of the connection whose
+// context is ctx, if the implementation sets one. The log lines of the
+// connection carry it as the "conn" field, which tells apart the
+// connections to the same peer over time.
+func ConnIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(ContextKeyConnID).(string)
+	return id, ok
+}
+
+// A ConnKey identifies a connection-scoped value, attached to a connection
+// with SetConnValue. Keys compare by identity, so packages declare theirs as
+// variables, e.g.:
+//
+//	var sessionKey = ble.NewConnKey("session")
+//
+// The values live as long as the connection, and are dropped with it once
+// it's disconnected; they aren't carried over to the next connection to the
+// same peer.
+type ConnKey struct {
+	name string
+}
+
+// NewConnKey returns a new key, named name in its String.
+func NewConnKey(name string) *ConnKey {
+	return &ConnKey{name: name}
+}
+
+func (k *ConnKey) String() string {
+	return "ble.ConnKey(" + k.name + ")"
+}
+
+var (
+	// ConnKeyEncryption is set by the implementations to the last
+	// EncryptionChangedInfo of the connection. See ConnEncryption.
+	ConnKeyEncryption = NewConnKey("encryption")
+
+	// ConnKeyCCC is set by the GATT servers to the CCCD values the client
+	// wrote, a map[uint16]uint16 by characteristic handle which is replaced,
+	// not modified, on every write. See ConnCCC.
+	ConnKeyCCC = NewConnKey("ccc")
+)
+
+// contextKeyConnValues for the connection-scoped values.
+var contextKeyConnValues = ContextKey("connValues")
+
+type connValues struct {
+	sync.Mutex
+	m map[*ConnKey]interface{}
+}
+
+// WithConnValues returns a copy of ctx holding the store of connection-scoped
+// values. Implementations set up the context of their connections with it,
+// so the values can be set and read from any goroutine.
+func WithConnValues(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyConnValues, &connValues{m: make(map[*ConnKey]interface{})})
+}
+
+// connValueStore returns the store of the values of c, adding one to its
+// context if the implementation didn't.
+func connValueStore(c Conn) *connValues {
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if s, ok := ctx.Value(contextKeyConnValues).(*connValues); ok {
+		return s
+	}
+	ctx = WithConnValues(ctx)
+	c.SetContext(ctx)
+	return ctx.Value(contextKeyConnValues).(*connValues)
+}
+
+// SetConnValue attaches v to the connection c under k, replacing the value
+// it had. A nil v removes it.
+func SetConnValue(c Conn, k *ConnKey, v interface{}) {
+	s := connValueStore(c)
+	s.Lock()
+	defer s.Unlock()
+	if v == nil {
+		delete(s.m, k)
+		return
+	}
+	s.m[k] = v
+}
+
+// ConnValue returns the value attached to the connection c under k, if any.
+func ConnValue(c Conn, k *ConnKey) (interface{}, bool) {
+	s := connValueStore(c)
+	s.Lock()
+	defer s.Unlock()
+	v, ok := s.m[k]
+	return v, ok
+}
+
+// ConnEncryption returns the last change of the encryption of the
+// connection c, if it was ever encrypted.
+func ConnEncryption(c Conn) (EncryptionChangedInfo, bool) {
+	v, ok := ConnValue(c, ConnKeyEncryption)
+	if !ok {
+		return EncryptionChangedInfo{}, false
+	}
+	info, ok := v.(EncryptionChangedInfo)
+	return info, ok
+}
+
+// ConnCCC returns the value of the CCCD of the characteristic with the
+// handle h that the client of the connection c wrote to the local GATT
+// server, or zero.
+func ConnCCC(c Conn, h uint16) uint16 {
+	v, _ := ConnValue(c, ConnKeyCCC)
+	cccs, _ := v.(map[uint16]uint16)
+	return cccs[h]
+}
@@ -0,0 +1,65 @@
+package ble
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// valueConn is a connection with a context, and nothing else.
+type valueConn struct {
+	Conn
+	ctx context.Context
+}
+
+func (c *valueConn) Context() context.Context       { return c.ctx }
+func (c *valueConn) SetContext(ctx context.Context) { c.ctx = ctx }
+
+func TestConnValues(t *testing.T) {
+	session := NewConnKey("session")
+	c := &valueConn{ctx: WithConnValues(context.Background())}
+
+	if _, ok := ConnValue(c, session); ok {
+		t.Fatal("value before it's set")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			SetConnValue(c, session, i)
+			ConnValue(c, session)
+		}(i)
+	}
+	wg.Wait()
+	if v, ok := ConnValue(c, session); !ok || v.(int) < 0 || v.(int) > 3 {
+		t.Fatalf("value %v, %v", v, ok)
+	}
+	if _, ok := ConnValue(c, NewConnKey("session")); ok {
+		t.Fatal("value of another key with the same name")
+	}
+	SetConnValue(c, session, nil)
+	if _, ok := ConnValue(c, session); ok {
+		t.Fatal("value once removed")
+	}
+
+	// The built-in values.
+	if _, ok := ConnEncryption(c); ok {
+		t.Fatal("encryption of an unencrypted connection")
+	}
+	SetConnValue(c, ConnKeyEncryption, EncryptionChangedInfo{Enabled: true})
+	if info, ok := ConnEncryption(c); !ok || !info.Enabled {
+		t.Fatalf("encryption %+v, %v", info, ok)
+	}
+	SetConnValue(c, ConnKeyCCC, map[uint16]uint16{0x0010: 0x0002})
+	if ccc := ConnCCC(c, 0x0010); ccc != 0x0002 {
+		t.Fatalf("CCCD %04X", ccc)
+	}
+
+	// A connection whose implementation didn't set up the store gets one.
+	bare := &valueConn{ctx: context.Background()}
+	SetConnValue(bare, session, "s")
+	if v, _ := ConnValue(bare, session); v != "s" {
+		t.Fatalf("value %v", v)
+	}
+}
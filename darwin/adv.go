@@ -1,73 +1,205 @@
 package darwin
 
 import (
+	"time"
+
 	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/adv"
 	"github.com/raff/goble/xpc"
 )
 
-type adv struct {
+// advertisement is an advertisement, as CoreBluetooth reports it. It merges
+// the advertising data and the scan response into a dictionary, so the raw
+// data is rebuilt from it.
+type advertisement struct {
 	args xpc.Dict
 	ad   xpc.Dict
+	ts   int64
+	p    *adv.Packet
+}
+
+func newAdvertisement(args xpc.Dict, ad xpc.Dict) *advertisement {
+	a := &advertisement{args: args, ad: ad, ts: time.Now().UnixNano()}
+	a.p = adv.NewRawPacketLenient(a.data())
+	return a
 }
 
-func (a *adv) LocalName() string {
+func (a *advertisement) LocalName() string {
 	return a.ad.GetString("kCBAdvDataLocalName", a.args.GetString("kCBMsgArgName", ""))
 }
 
-func (a *adv) ManufacturerData() []byte {
+func (a *advertisement) ManufacturerData() []byte {
 	return a.ad.GetBytes("kCBAdvDataManufacturerData", nil)
 }
 
-func (a *adv) ServiceData() []ble.ServiceData {
-	xSDs, ok := a.ad["kCBAdvDataServiceData"]
+func (a *advertisement) ServiceData() []ble.ServiceData {
+	xSD, ok := a.ad["kCBAdvDataServiceData"].(xpc.Array)
 	if !ok {
 		return nil
 	}
-
-	xSD := xSDs.(xpc.Array)
 	var sd []ble.ServiceData
-	for i := 0; i < len(xSD); i += 2 {
-		sd = append(
-			sd, ble.ServiceData{
-				UUID: ble.UUID(xSD[i].([]byte)),
-				Data: xSD[i+1].([]byte),
-			})
+	for i := 0; i+1 < len(xSD); i += 2 {
+		u, ok := xSD[i].([]byte)
+		if !ok {
+			continue
+		}
+		b, _ := xSD[i+1].([]byte)
+		sd = append(sd, ble.ServiceData{UUID: ble.UUID(ble.Reverse(u)), Data: b})
 	}
 	return sd
 }
 
-func (a *adv) Services() []ble.UUID {
-	xUUIDs, ok := a.ad["kCBAdvDataServiceUUIDs"]
+// uuids returns the UUIDs of the array at k, which CoreBluetooth keeps in big
+// endian.
+func (a *advertisement) uuids(k string) []ble.UUID {
+	xUUIDs, ok := a.ad[k].(xpc.Array)
 	if !ok {
 		return nil
 	}
 	var uuids []ble.UUID
-	for _, xUUID := range xUUIDs.(xpc.Array) {
-		uuids = append(uuids, ble.UUID(ble.Reverse(xUUID.([]byte))))
+	for _, xUUID := range xUUIDs {
+		if b, ok := xUUID.([]byte); ok {
+			uuids = append(uuids, ble.UUID(ble.Reverse(b)))
+		}
 	}
 	return uuids
 }
 
-func (a *adv) OverflowService() []ble.UUID {
-	return nil // TODO
+func (a *advertisement) Services() []ble.UUID {
+	return a.uuids("kCBAdvDataServiceUUIDs")
 }
 
-func (a *adv) TxPowerLevel() int {
+// OverflowService returns the services a backgrounded iOS peripheral moved to
+// its overflow area.
+func (a *advertisement) OverflowService() []ble.UUID {
+	return a.uuids("kCBAdvDataOverflowServiceUUIDs")
+}
+
+func (a *advertisement) TxPowerLevel() int {
 	return a.ad.GetInt("kCBAdvDataTxPowerLevel", 0)
 }
 
-func (a *adv) SolicitedService() []ble.UUID {
-	return nil // TODO
+func (a *advertisement) SolicitedService() []ble.UUID {
+	return a.uuids("kCBAdvDataSolicitedServiceUUIDs")
 }
 
-func (a *adv) Connectable() bool {
+func (a *advertisement) Connectable() bool {
 	return a.ad.GetInt("kCBAdvDataIsConnectable", 0) > 0
 }
 
-func (a *adv) RSSI() int {
+func (a *advertisement) RSSI() int {
 	return a.args.GetInt("kCBMsgArgRssi", 0)
 }
 
-func (a *adv) Addr() ble.Addr {
-	return a.args.MustGetUUID("kCBMsgArgDeviceUUID")
+func (a *advertisement) Addr() ble.Addr {
+	return ble.NewAddr(a.args.MustGetUUID("kCBMsgArgDeviceUUID").String())
+}
+
+// AddrType is always public, as CoreBluetooth hides addresses behind UUIDs.
+func (a *advertisement) AddrType() uint8 {
+	return 0
+}
+
+func (a *advertisement) Timestamp() int64 {
+	return a.ts
+}
+
+func (a *advertisement) ToMap() (map[string]interface{}, error) {
+	keys := ble.AdvertisementMapKeys
+	m := map[string]interface{}{
+		keys.MAC:         a.Addr().String(),
+		keys.AddressType: a.AddrType(),
+		keys.Connectable: a.Connectable(),
+		keys.RSSI:        a.RSSI(),
+		keys.Timestamp:   a.ts,
+	}
+	if m[keys.RSSI] == 0 {
+		m[keys.RSSI] = -128
+	}
+	for k, v := range a.p.Map() {
+		m[k] = v
+	}
+	if n := a.LocalName(); n != "" {
+		m[keys.Name] = n
+	}
+	if _, ok := a.ad["kCBAdvDataTxPowerLevel"]; ok {
+		m[keys.TxPower] = a.TxPowerLevel()
+	}
+	return m, nil
+}
+
+// Data returns the advertising data rebuilt from the fields CoreBluetooth
+// reports, including those of the scan response. The order of the fields
+// and the flags aren't known.
+func (a *advertisement) Data() []byte {
+	return a.p.Bytes()
+}
+
+// SrData returns nil, as the scan response is merged into Data.
+func (a *advertisement) SrData() []byte {
+	return nil
+}
+
+// AD types of the rebuilt fields [CSSv6, Part A, 1].
+const (
+	adAllUUID16      = 0x03
+	adAllUUID32      = 0x05
+	adAllUUID128     = 0x07
+	adCompleteName   = 0x09
+	adTxPower        = 0x0A
+	adSolUUID16      = 0x14
+	adSolUUID128     = 0x15
+	adServiceData16  = 0x16
+	adSolUUID32      = 0x1F
+	adServiceData32  = 0x20
+	adServiceData128 = 0x21
+	adManufacturer   = 0xFF
+)
+
+func (a *advertisement) data() []byte {
+	var b []byte
+	field := func(typ byte, d []byte) {
+		if len(d) < 255 {
+			b = append(append(b, byte(len(d)+1), typ), d...)
+		}
+	}
+	uuids := func(us []ble.UUID, t16, t32, t128 byte) {
+		for _, t := range []struct {
+			n   int
+			typ byte
+		}{{2, t16}, {4, t32}, {16, t128}} {
+			var d []byte
+			for _, u := range us {
+				if u.Len() == t.n {
+					d = append(d, u...)
+				}
+			}
+			if d != nil {
+				field(t.typ, d)
+			}
+		}
+	}
+
+	if n, ok := a.ad["kCBAdvDataLocalName"].(string); ok {
+		field(adCompleteName, []byte(n))
+	}
+	if _, ok := a.ad["kCBAdvDataTxPowerLevel"]; ok {
+		field(adTxPower, []byte{uint8(a.TxPowerLevel())})
+	}
+	uuids(append(a.Services(), a.OverflowService()...), adAllUUID16, adAllUUID32, adAllUUID128)
+	uuids(a.SolicitedService(), adSolUUID16, adSolUUID32, adSolUUID128)
+	for _, sd := range a.ServiceData() {
+		typ := byte(adServiceData128)
+		switch sd.UUID.Len() {
+		case 2:
+			typ = adServiceData16
+		case 4:
+			typ = adServiceData32
+		}
+		field(typ, append(append([]byte{}, sd.UUID...), sd.Data...))
+	}
+	if md := a.ManufacturerData(); md != nil {
+		field(adManufacturer, md)
+	}
+	return b
 }
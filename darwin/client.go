@@ -2,6 +2,7 @@ package darwin
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/leso-kn/ble"
 	"github.com/raff/goble/xpc"
@@ -65,6 +66,49 @@ func (cln *Client) DiscoverProfile(force bool) (*ble.Profile, error) {
 	return cln.profile, nil
 }
 
+// DiscoverAndCacheProfile discovers the profile; CoreBluetooth caches it by
+// itself.
+func (cln *Client) DiscoverAndCacheProfile(force bool) (*ble.Profile, error) {
+	return cln.DiscoverProfile(force)
+}
+
+// InvalidateProfile drops the discovered profile, so that the next
+// discovery starts over. CoreBluetooth keeps its own cache.
+func (cln *Client) InvalidateProfile() error {
+	cln.profile = nil
+	return nil
+}
+
+// RefreshService rediscovers the primary service u, with its
+// characteristics and descriptors, in place of the one of the profile.
+func (cln *Client) RefreshService(u ble.UUID) (*ble.Service, error) {
+	ss, err := cln.DiscoverServices([]ble.UUID{u})
+	if err != nil {
+		return nil, err
+	}
+	var s *ble.Service
+	if len(ss) != 0 {
+		s = ss[0]
+		cs, err := cln.DiscoverCharacteristics(nil, s)
+		if err != nil {
+			return nil, fmt.Errorf("can't discover characteristics: %s", err)
+		}
+		for _, c := range cs {
+			if _, err := cln.DiscoverDescriptors(nil, c); err != nil {
+				return nil, fmt.Errorf("can't discover descriptors: %s", err)
+			}
+		}
+	}
+	if cln.profile == nil {
+		cln.profile = &ble.Profile{}
+	}
+	cln.profile.ReplaceService(u, s)
+	if s == nil {
+		return nil, fmt.Errorf("service %s not found", u)
+	}
+	return s, nil
+}
+
 // DiscoverServices finds all the primary services on a server. [Vol 3, Part G, 4.4.1]
 // If filter is specified, only filtered services are returned.
 func (cln *Client) DiscoverServices(ss []ble.UUID) ([]*ble.Service, error) {
@@ -234,15 +278,8 @@ func (cln *Client) WriteDescriptor(d *ble.Descriptor, b []byte) error {
 }
 
 // ReadRSSI retrieves the current RSSI value of remote peripheral. [Vol 2, Part E, 7.5.4]
-func (cln *Client) ReadRSSI() int {
-	rsp, err := cln.conn.sendReq(cmdReadRSSI, xpc.Dict{"kCBMsgArgDeviceUUID": cln.id})
-	if err != nil {
-		return 0
-	}
-	if rsp.err() != nil {
-		return 0
-	}
-	return rsp.rssi()
+func (cln *Client) ReadRSSI() (int8, error) {
+	return cln.conn.ReadRSSI()
 }
 
 // ExchangeMTU set the ATT_MTU to the maximum possible value that can be
@@ -306,6 +343,18 @@ func (cln *Client) ClearSubscriptions() error {
 	return nil
 }
 
+// Pair has CoreBluetooth pair with the peer, by reading the characteristic
+// set with SetPairingCharacteristic, which requires it.
+func (cln *Client) Pair(ad ble.AuthData, to time.Duration) error {
+	return cln.conn.Pair(ad, to)
+}
+
+// StartEncryption has CoreBluetooth encrypt the link, by reading the
+// characteristic set with SetPairingCharacteristic.
+func (cln *Client) StartEncryption(ch chan ble.EncryptionChangedInfo) error {
+	return cln.conn.StartEncryption(ch)
+}
+
 // CancelConnection disconnects the connection.
 func (cln *Client) CancelConnection() error {
 	rsp, err := cln.conn.sendReq(cmdDisconnect, xpc.Dict{"kCBMsgArgDeviceUUID": cln.id})
@@ -328,4 +377,5 @@ func (cln *Client) Conn() ble.Conn {
 type sub struct {
 	fn   ble.NotificationHandler
 	char *ble.Characteristic
+	id   uint
 }
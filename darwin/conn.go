@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/leso-kn/ble"
 	"github.com/raff/goble/xpc"
@@ -44,6 +45,11 @@ type conn struct {
 
 	subs map[uint16]*sub
 
+	// pairChar is read for CoreBluetooth to pair, and encInfo is the outcome
+	// of the last read.
+	pairChar *ble.Characteristic
+	encInfo  ble.EncryptionChangedInfo
+
 	isConnected bool
 }
 
@@ -99,8 +105,53 @@ func (c *conn) Disconnected() <-chan struct{} {
 	return c.done
 }
 
+// ReadRSSI retrieves the current RSSI value of the remote device.
+func (c *conn) ReadRSSI() (int8, error) {
+	rsp, err := c.sendReq(cmdReadRSSI, xpc.Dict{"kCBMsgArgDeviceUUID": xpc.MakeUUID(c.addr.String())})
+	if err != nil {
+		return 0, err
+	}
+	if err := rsp.err(); err != nil {
+		return 0, err
+	}
+	return int8(rsp.rssi()), nil
+}
+
+// RemoteFeatures isn't supported.
+func (c *conn) RemoteFeatures() (uint64, error) {
+	return 0, ble.ErrNotImplemented
+}
+
+// RemoteVersion isn't supported.
+func (c *conn) RemoteVersion() (ble.RemoteVersion, error) {
+	return ble.RemoteVersion{}, ble.ErrNotImplemented
+}
+
+// ConnInfo isn't supported; the zero ConnInfo is returned.
+func (c *conn) ConnInfo() ble.ConnInfo {
+	return ble.ConnInfo{}
+}
+
+// SetDeadline isn't supported.
+func (c *conn) SetDeadline(t time.Time) error {
+	return ble.ErrNotImplemented
+}
+
+// SetReadDeadline isn't supported.
+func (c *conn) SetReadDeadline(t time.Time) error {
+	return ble.ErrNotImplemented
+}
+
+// SetWriteDeadline isn't supported.
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return ble.ErrNotImplemented
+}
+
 // server (peripheral)
 func (c *conn) subscribed(char *ble.Characteristic) {
+	if char == nil {
+		return
+	}
 	h := char.Handle
 	if _, found := c.notifiers[h]; found {
 		return
@@ -113,14 +164,26 @@ func (c *conn) subscribed(char *ble.Characteristic) {
 		})
 		return len(b), err
 	}
+	// CoreBluetooth doesn't tell whether notifications or indications were
+	// enabled, and sends whichever the characteristic supports.
+	nh := char.NotifyHandler
+	if nh == nil {
+		nh = char.IndicateHandler
+	}
+	if nh == nil {
+		return
+	}
 	n := ble.NewNotifier(send)
 	c.notifiers[h] = n
 	req := ble.NewRequest(c, nil, 0) // convey *conn to user handler.
-	go char.NotifyHandler.ServeNotify(req, n)
+	go nh.ServeNotify(req, n)
 }
 
 // server (peripheral)
 func (c *conn) unsubscribed(char *ble.Characteristic) {
+	if char == nil {
+		return
+	}
 	if n, found := c.notifiers[char.Handle]; found {
 		if err := n.Close(); err != nil {
 			log.Printf("failed to clone notifier: %v", err)
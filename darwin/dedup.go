@@ -0,0 +1,66 @@
+package darwin
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// advDedup drops advertisements seen within a window, keyed by the device
+// and the advertised fields. CoreBluetooth reports every advertisement when
+// scanning with allowDup, and only the first one per device otherwise; with a
+// window, unchanged advertisements are reported again once it expires.
+type advDedup struct {
+	sync.Mutex
+	window    time.Duration
+	seen      map[advDedupKey]time.Time
+	lastSweep time.Time
+}
+
+type advDedupKey struct {
+	addr string
+	hash uint64
+}
+
+// reset forgets the advertisements seen.
+func (d *advDedup) reset() {
+	d.Lock()
+	d.seen = nil
+	d.Unlock()
+}
+
+// dup reports whether the advertisement was seen within the window, and
+// records it otherwise.
+func (d *advDedup) dup(a *advertisement, now time.Time) bool {
+	d.Lock()
+	defer d.Unlock()
+	if d.window <= 0 {
+		return false
+	}
+	if d.seen == nil {
+		d.seen = make(map[advDedupKey]time.Time)
+	}
+
+	h := fnv.New64a()
+	if a.Connectable() {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+	h.Write(a.Data())
+	k := advDedupKey{addr: a.Addr().String(), hash: h.Sum64()}
+	if t, ok := d.seen[k]; ok && now.Sub(t) < d.window {
+		return true
+	}
+	d.seen[k] = now
+
+	if now.Sub(d.lastSweep) >= d.window {
+		for k, t := range d.seen {
+			if now.Sub(t) >= d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastSweep = now
+	}
+	return false
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/adv"
 	"github.com/pkg/errors"
 	"github.com/raff/goble/xpc"
 
@@ -30,6 +31,7 @@ type Device struct {
 	// Only used in client/centralManager implementation
 	advHandlerSync bool
 	advHandler     ble.AdvHandler
+	advDedup       advDedup
 	chConn         chan *conn
 
 	// Only used in server/peripheralManager implementation
@@ -137,6 +139,7 @@ func (d *Device) AdvertiseMfgData(ctx context.Context, id uint16, md []byte) err
 		return errors.Wrap(err, "can't advertise")
 	}
 	<-ctx.Done()
+	_ = d.stopAdvertising()
 	return ctx.Err()
 }
 
@@ -157,9 +160,64 @@ func (d *Device) AdvertiseServiceData16(ctx context.Context, id uint16, b []byte
 		return errors.Wrap(err, "can't advertise")
 	}
 	<-ctx.Done()
+	_ = d.stopAdvertising()
+	return ctx.Err()
+}
+
+// AdvertiseServiceData advertises data associated with a 16, 32, or 128bit
+// service uuid.
+func (d *Device) AdvertiseServiceData(ctx context.Context, u ble.UUID, b []byte) error {
+	var typ byte
+	switch u.Len() {
+	case 2:
+		return d.AdvertiseServiceData16(ctx, binary.LittleEndian.Uint16(u), b)
+	case 4:
+		typ = 0x20
+	case 16:
+		typ = 0x21
+	default:
+		return errors.New("invalid service uuid")
+	}
+	prefix := append([]byte{byte(u.Len() + len(b) + 1), typ}, u...)
+	rsp, err := d.sendReq(d.pm, cmdAdvertiseStart, xpc.Dict{
+		"kCBAdvDataAppleMfgData": append(prefix, b...),
+	})
+	if err != nil {
+		return err
+	}
+	if err := rsp.err(); err != nil {
+		return errors.Wrap(err, "can't advertise")
+	}
+	<-ctx.Done()
+	_ = d.stopAdvertising()
 	return ctx.Err()
 }
 
+// AdvertiseEddystoneUID advertises an Eddystone-UID frame.
+func (d *Device) AdvertiseEddystoneUID(ctx context.Context, namespace [10]byte, instance [6]byte, txPower int8) error {
+	b, _ := adv.EddystoneUID{TxPower: txPower, Namespace: namespace, Instance: instance}.Bytes()
+	return d.AdvertiseServiceData16(ctx, adv.EddystoneUUID, b)
+}
+
+// AdvertiseEddystoneURL advertises an Eddystone-URL frame.
+func (d *Device) AdvertiseEddystoneURL(ctx context.Context, url string, txPower int8) error {
+	b, err := adv.EddystoneURL{TxPower: txPower, URL: url}.Bytes()
+	if err != nil {
+		return err
+	}
+	return d.AdvertiseServiceData16(ctx, adv.EddystoneUUID, b)
+}
+
+// AdvertiseAltBeacon advertises an AltBeacon with the specified parameters.
+func (d *Device) AdvertiseAltBeacon(ctx context.Context, mfgID uint16, id1 [16]byte, id2, id3 uint16, refRSSI int8) error {
+	p, err := adv.NewPacket(adv.AltBeacon(adv.AltBeaconData{MfgID: mfgID, ID1: id1, ID2: id2, ID3: id3, RefRSSI: refRSSI}))
+	if err != nil {
+		return err
+	}
+	// Strip the field header and the company identifier.
+	return d.AdvertiseMfgData(ctx, mfgID, p.Bytes()[4:])
+}
+
 // AdvertiseNameAndServices advertises name and specifid service UUIDs.
 func (d *Device) AdvertiseNameAndServices(ctx context.Context, name string, ss ...ble.UUID) error {
 	rsp, err := d.sendReq(d.pm, cmdAdvertiseStart, xpc.Dict{
@@ -210,6 +268,11 @@ func (d *Device) AdvertiseIBeacon(ctx context.Context, u ble.UUID, major, minor
 	return d.AdvertiseIBeaconData(ctx, b)
 }
 
+// AdvertiseData is not supported, as CoreBluetooth doesn't advertise raw data.
+func (d *Device) AdvertiseData(ctx context.Context, ad, sr []byte) error {
+	return errors.New("Not supported")
+}
+
 // stopAdvertising stops advertising.
 func (d *Device) stopAdvertising() error {
 	rsp, err := d.sendReq(d.pm, cmdAdvertiseStop, nil)
@@ -224,6 +287,7 @@ func (d *Device) stopAdvertising() error {
 
 // Scan ...
 func (d *Device) Scan(ctx context.Context, allowDup bool, h ble.AdvHandler) error {
+	d.advDedup.reset()
 	d.advHandler = h
 	if err := d.sendCmd(d.cm, cmdScanningStart, xpc.Dict{
 		// "kCBMsgArgUUIDs": uuidSlice(ss),
@@ -240,14 +304,35 @@ func (d *Device) Scan(ctx context.Context, allowDup bool, h ble.AdvHandler) erro
 	return ctx.Err()
 }
 
-// stopAdvertising stops advertising.
+// NonblockingScan starts scanning without blocking the caller.
+func (d *Device) NonblockingScan(allowDup bool, h ble.AdvHandler) error {
+	d.advDedup.reset()
+	d.advHandler = h
+	return d.sendCmd(d.cm, cmdScanningStart, xpc.Dict{
+		"kCBMsgArgOptions": xpc.Dict{
+			"kCBScanOptionAllowDuplicates": map[bool]int{true: 1, false: 0}[allowDup],
+		},
+	})
+}
+
+// StopScan stops scanning.
+func (d *Device) StopScan() error {
+	return d.stopScanning()
+}
+
+// stopScanning stops scanning.
 func (d *Device) stopScanning() error {
 	return errors.Wrap(d.sendCmd(d.cm, cmdScanningStop, nil), "can't stop scanning")
 }
 
 // RemoveAllServices removes all services of device's
 func (d *Device) RemoveAllServices() error {
-	return d.sendCmd(d.pm, cmdServicesRemove, nil)
+	if err := d.sendCmd(d.pm, cmdServicesRemove, nil); err != nil {
+		return err
+	}
+	d.chars = make(map[int]*ble.Characteristic)
+	d.base = 1
+	return nil
 }
 
 // AddService adds a service to device's database.
@@ -358,7 +443,7 @@ func (d *Device) AddService(s *ble.Service) error {
 // SetServices ...
 func (d *Device) SetServices(ss []*ble.Service) error {
 	if err := d.RemoveAllServices(); err != nil {
-		return nil
+		return err
 	}
 	for _, s := range ss {
 		if err := d.AddService(s); err != nil {
@@ -393,6 +478,16 @@ func (d *Device) Stop() error {
 	return nil
 }
 
+// Address isn't known, as CoreBluetooth doesn't expose the local address.
+func (d *Device) Address() ble.Addr {
+	return nil
+}
+
+// SendVendorSpecificCommand isn't supported.
+func (d *Device) SendVendorSpecificCommand(opcode uint16, length uint8, v interface{}) error {
+	return ble.ErrNotImplemented
+}
+
 // HandleXpcEvent process Device events and asynchronous errors.
 func (d *Device) HandleXpcEvent(event xpc.Dict, err error) {
 	if err != nil {
@@ -415,7 +510,10 @@ func (d *Device) HandleXpcEvent(event xpc.Dict, err error) {
 		if d.advHandler == nil {
 			break
 		}
-		a := &adv{args: m.args(), ad: args.advertisementData()}
+		a := newAdvertisement(m.args(), args.advertisementData())
+		if d.advDedup.dup(a, time.Now()) {
+			break
+		}
 
 		if d.advHandlerSync {
 			d.advHandler(a)
@@ -442,49 +540,10 @@ func (d *Device) HandleXpcEvent(event xpc.Dict, err error) {
 		c.supervisionTimeout = args.supervisionTimeout()
 
 	case evtReadRequest:
-		aid := args.attributeID()
-		char := d.chars[aid]
-		v := char.Value
-		if v == nil {
-			c := d.conn(args)
-			req := ble.NewRequest(c, nil, args.offset())
-			buf := bytes.NewBuffer(make([]byte, 0, c.txMTU-1))
-			rsp := ble.NewResponseWriter(buf)
-			char.ReadHandler.ServeRead(req, rsp)
-			v = buf.Bytes()
-		}
+		d.serveRead(args)
 
-		err := d.sendCmd(d.pm, cmdSendData, xpc.Dict{
-			"kCBMsgArgAttributeID":   aid,
-			"kCBMsgArgData":          v,
-			"kCBMsgArgTransactionID": args.transactionID(),
-			"kCBMsgArgResult":        0,
-		})
-		if err != nil {
-			log.Printf("error: %v", err)
-			return
-		}
 	case evtWriteRequest:
-		for _, xxw := range args.attWrites() {
-			xw := msg(xxw.(xpc.Dict))
-			aid := xw.attributeID()
-			char := d.chars[aid]
-			req := ble.NewRequest(d.conn(args), xw.data(), xw.offset())
-			char.WriteHandler.ServeWrite(req, nil)
-			if xw.ignoreResponse() == 1 {
-				continue
-			}
-			err := d.sendCmd(d.pm, cmdSendData, xpc.Dict{
-				"kCBMsgArgAttributeID":   aid,
-				"kCBMsgArgData":          nil,
-				"kCBMsgArgTransactionID": args.transactionID(),
-				"kCBMsgArgResult":        0,
-			})
-			if err != nil {
-				log.Println("error:", err)
-				return
-			}
-		}
+		d.serveWrite(args)
 
 	case evtSubscribe:
 		// characteristic is subscribed by remote central.
@@ -524,7 +583,8 @@ func (d *Device) HandleXpcEvent(event xpc.Dict, err error) {
 			log.Printf("notified by unsubscribed handle")
 			// FIXME: should terminate the connection?
 		} else {
-			sub.fn(args.data())
+			sub.fn(sub.id, args.data())
+			sub.id++
 		}
 		break
 
@@ -546,9 +606,88 @@ func (d *Device) HandleXpcEvent(event xpc.Dict, err error) {
 	}
 }
 
+// serveRead serves a read request of a remote central, with the static value
+// of the characteristic, or its ReadHandler.
+func (d *Device) serveRead(args msg) {
+	aid := args.attributeID()
+	off := args.offset()
+	var v []byte
+	status := ble.ErrSuccess
+	switch char := d.chars[aid]; {
+	case char == nil || char.Property&ble.CharRead == 0:
+		status = ble.ErrReadNotPerm
+	case char.Value != nil:
+		if off > len(char.Value) {
+			status = ble.ErrInvalidOffset
+			break
+		}
+		v = char.Value[off:]
+	case char.ReadHandler != nil:
+		c := d.conn(args)
+		req := ble.NewRequest(c, nil, off)
+		buf := bytes.NewBuffer(make([]byte, 0, c.txMTU-1))
+		rsp := ble.NewResponseWriter(buf)
+		char.ReadHandler.ServeRead(req, rsp)
+		v, status = buf.Bytes(), rsp.Status()
+	default:
+		status = ble.ErrReadNotPerm
+	}
+
+	err := d.sendCmd(d.pm, cmdSendData, xpc.Dict{
+		"kCBMsgArgAttributeID":   aid,
+		"kCBMsgArgData":          v,
+		"kCBMsgArgTransactionID": args.transactionID(),
+		"kCBMsgArgResult":        int(status),
+	})
+	if err != nil {
+		log.Printf("error: %v", err)
+	}
+}
+
+// serveWrite serves the write requests of a remote central with the
+// WriteHandlers of the characteristics. CoreBluetooth takes a single response
+// for all of them, with the first error.
+func (d *Device) serveWrite(args msg) {
+	ws := args.attWrites()
+	if len(ws) == 0 {
+		return
+	}
+	status := ble.ErrSuccess
+	for _, xxw := range ws {
+		xw := msg(xxw.(xpc.Dict))
+		char := d.chars[xw.attributeID()]
+		if char == nil || char.WriteHandler == nil || char.Property&(ble.CharWrite|ble.CharWriteNR) == 0 {
+			if status == ble.ErrSuccess {
+				status = ble.ErrWriteNotPerm
+			}
+			continue
+		}
+		req := ble.NewRequest(d.conn(args), xw.data(), xw.offset())
+		rsp := ble.NewResponseWriter(nil)
+		char.WriteHandler.ServeWrite(req, rsp)
+		if status == ble.ErrSuccess {
+			status = rsp.Status()
+		}
+	}
+
+	first := msg(ws[0].(xpc.Dict))
+	if first.ignoreResponse() == 1 {
+		return
+	}
+	err := d.sendCmd(d.pm, cmdSendData, xpc.Dict{
+		"kCBMsgArgAttributeID":   first.attributeID(),
+		"kCBMsgArgData":          nil,
+		"kCBMsgArgTransactionID": args.transactionID(),
+		"kCBMsgArgResult":        int(status),
+	})
+	if err != nil {
+		log.Println("error:", err)
+	}
+}
+
 func (d *Device) conn(m msg) *conn {
 	// Convert xpc.UUID to ble.UUID.
-	a := ble.MustParse(m.deviceUUID().String())
+	a := ble.NewAddr(m.deviceUUID().String())
 	d.connLock.Lock()
 	c, ok := d.conns[a.String()]
 	if !ok {
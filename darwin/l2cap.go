@@ -0,0 +1,265 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package darwin
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+char *bleL2CAPOpen(const char *uuid, uint16_t psm, int ms, void **h);
+char *bleL2CAPPublish(int encrypted, int ms, uint16_t *psm);
+void bleL2CAPUnpublish(uint16_t psm);
+long bleL2CAPRead(void *h, void *b, long n);
+long bleL2CAPWrite(void *h, const void *b, long n);
+void bleL2CAPClose(void *h);
+void bleL2CAPRelease(void *h);
+*/
+import "C"
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/leso-kn/ble"
+	"github.com/pkg/errors"
+)
+
+const (
+	// l2capMTU is the MTU the channels report. CoreBluetooth hands them over
+	// as byte streams, and segments the data into SDUs by itself, so it only
+	// sizes the buffers of the applications.
+	l2capMTU = 2048
+
+	// l2capTimeout bounds opening a channel, and publishing a PSM.
+	l2capTimeout = 10 * time.Second
+
+	// l2capAcceptQueue is the number of channels a listener holds until
+	// they're accepted.
+	l2capAcceptQueue = 8
+)
+
+// muOpen serializes the opens, which share the delegate of the central
+// manager.
+var muOpen sync.Mutex
+
+// l2capListeners holds the listeners by PSM, for the channels CoreBluetooth
+// hands to goL2CAPAccepted.
+var l2capListeners = struct {
+	sync.Mutex
+	m map[uint16]*l2capListener
+}{m: make(map[uint16]*l2capListener)}
+
+func l2capError(s *C.char) error {
+	defer C.free(unsafe.Pointer(s))
+	return errors.New("l2cap: " + C.GoString(s))
+}
+
+// peerUUID returns the identifier of the peer a, as NSUUID parses it. The
+// addresses of the darwin backend are the identifiers without dashes.
+func peerUUID(a ble.Addr) string {
+	s := a.String()
+	if len(s) != 32 {
+		return s
+	}
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// OpenL2CAPChannel opens an L2CAP channel to the PSM of the peripheral. The
+// data of the channel is a byte stream: Read may return a part of an SDU, or
+// several.
+func (cln *Client) OpenL2CAPChannel(psm uint16) (ble.L2CAPChannel, error) {
+	uuid := C.CString(peerUUID(cln.conn.RemoteAddr()))
+	defer C.free(unsafe.Pointer(uuid))
+
+	muOpen.Lock()
+	defer muOpen.Unlock()
+	var h unsafe.Pointer
+	if s := C.bleL2CAPOpen(uuid, C.uint16_t(psm), C.int(l2capTimeout/time.Millisecond), &h); s != nil {
+		return nil, l2capError(s)
+	}
+	return newL2CAPChannel(h, psm, cln.conn), nil
+}
+
+// ListenL2CAP publishes a PSM, which CoreBluetooth allocates: psm must be
+// zero. With encrypted, the channels need an encrypted link.
+func (d *Device) ListenL2CAP(psm uint16, encrypted bool) (ble.L2CAPListener, error) {
+	if psm != 0 {
+		return nil, errors.New("l2cap: CoreBluetooth allocates the PSMs, listen on zero")
+	}
+	enc := C.int(0)
+	if encrypted {
+		enc = 1
+	}
+	var cpsm C.uint16_t
+	if s := C.bleL2CAPPublish(enc, C.int(l2capTimeout/time.Millisecond), &cpsm); s != nil {
+		return nil, l2capError(s)
+	}
+	l := &l2capListener{
+		d:    d,
+		psm:  uint16(cpsm),
+		ch:   make(chan *l2capChannel, l2capAcceptQueue),
+		done: make(chan struct{}),
+	}
+	l2capListeners.Lock()
+	l2capListeners.m[l.psm] = l
+	l2capListeners.Unlock()
+	return l, nil
+}
+
+//export goL2CAPAccepted
+func goL2CAPAccepted(psm C.uint16_t, h unsafe.Pointer, peer *C.char) {
+	// The listener is held, so Close closes the channel if it isn't
+	// accepted.
+	l2capListeners.Lock()
+	defer l2capListeners.Unlock()
+	l := l2capListeners.m[uint16(psm)]
+	if l == nil {
+		C.bleL2CAPClose(h)
+		C.bleL2CAPRelease(h)
+		return
+	}
+	a := ble.NewAddr(strings.Replace(C.GoString(peer), "-", "", -1))
+	ch := newL2CAPChannel(h, uint16(psm), l.d.connByAddr(a))
+	select {
+	case l.ch <- ch:
+	default:
+		logger.Errorf("l2cap: accept queue of PSM 0x%04X full, closing a channel", uint16(psm))
+		ch.Close()
+	}
+}
+
+// connByAddr returns the connection to a, as the messages of blued do.
+func (d *Device) connByAddr(a ble.Addr) *conn {
+	d.connLock.Lock()
+	defer d.connLock.Unlock()
+	c, ok := d.conns[a.String()]
+	if !ok {
+		c = newConn(d, a, l2capMTU)
+		d.conns[a.String()] = c
+	}
+	return c
+}
+
+// l2capChannel is a CBL2CAPChannel, whose streams are read and written with
+// blocking calls.
+type l2capChannel struct {
+	psm  uint16
+	conn ble.Conn
+
+	// mu guards h, which Close releases once the reads and writes in
+	// progress returned.
+	mu   sync.RWMutex
+	h    unsafe.Pointer
+	once sync.Once
+}
+
+func newL2CAPChannel(h unsafe.Pointer, psm uint16, c ble.Conn) *l2capChannel {
+	return &l2capChannel{h: h, psm: psm, conn: c}
+}
+
+// Read reads the data of the channel, which CoreBluetooth doesn't split into
+// SDUs.
+func (ch *l2capChannel) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	if ch.h == nil {
+		return 0, io.EOF
+	}
+	n := C.bleL2CAPRead(ch.h, unsafe.Pointer(&b[0]), C.long(len(b)))
+	switch {
+	case n == 0:
+		return 0, io.EOF
+	case n < 0:
+		return 0, errors.New("l2cap: read failed")
+	}
+	return int(n), nil
+}
+
+// Write writes b to the channel.
+func (ch *l2capChannel) Write(b []byte) (int, error) {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+	if ch.h == nil {
+		return 0, io.ErrClosedPipe
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n := int(C.bleL2CAPWrite(ch.h, unsafe.Pointer(&b[0]), C.long(len(b))))
+	if n < len(b) {
+		return n, errors.New("l2cap: write failed")
+	}
+	return n, nil
+}
+
+// Close closes the channel. The streams are closed first, which ends the
+// reads and writes in progress.
+func (ch *l2capChannel) Close() error {
+	ch.once.Do(func() {
+		C.bleL2CAPClose(ch.h)
+		ch.mu.Lock()
+		C.bleL2CAPRelease(ch.h)
+		ch.h = nil
+		ch.mu.Unlock()
+	})
+	return nil
+}
+
+// PSM returns the PSM of the channel.
+func (ch *l2capChannel) PSM() uint16 { return ch.psm }
+
+// MTU returns the size of the writes the channel expects. CoreBluetooth
+// doesn't tell the MTU of the remote device.
+func (ch *l2capChannel) MTU() int { return l2capMTU }
+
+// Conn returns the connection the channel is on.
+func (ch *l2capChannel) Conn() ble.Conn { return ch.conn }
+
+// l2capListener accepts the channels opened to a published PSM.
+type l2capListener struct {
+	d    *Device
+	psm  uint16
+	ch   chan *l2capChannel
+	done chan struct{}
+	once sync.Once
+}
+
+// Accept waits for and returns the next channel.
+func (l *l2capListener) Accept() (ble.L2CAPChannel, error) {
+	select {
+	case ch := <-l.ch:
+		return ch, nil
+	case <-l.done:
+		return nil, io.EOF
+	}
+}
+
+// PSM returns the PSM CoreBluetooth allocated.
+func (l *l2capListener) PSM() uint16 { return l.psm }
+
+// Close unpublishes the PSM, and closes the channels not accepted yet.
+func (l *l2capListener) Close() error {
+	l.once.Do(func() {
+		l2capListeners.Lock()
+		delete(l2capListeners.m, l.psm)
+		l2capListeners.Unlock()
+		C.bleL2CAPUnpublish(C.uint16_t(l.psm))
+		close(l.done)
+		for {
+			select {
+			case ch := <-l.ch:
+				ch.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,287 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package darwin
+
+// The L2CAP channels don't go through blued: XPC can't carry the sockets of
+// CBL2CAPChannel, so they're opened and published with CoreBluetooth itself,
+// by a central and a peripheral manager of their own. CoreBluetooth shares
+// the links between the managers of the process, so a channel is opened on
+// the connection the Client dialed.
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework Foundation -framework CoreBluetooth
+
+#import <CoreBluetooth/CoreBluetooth.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+
+extern void goL2CAPAccepted(uint16_t psm, void *h, char *peer);
+
+static char *bleError(NSString *s) {
+	return strdup(s.UTF8String);
+}
+
+static char *bleNSError(NSString *what, NSError *err) {
+	if (err == nil) {
+		return bleError(what);
+	}
+	return bleError([NSString stringWithFormat:@"%@: %@", what, err.localizedDescription]);
+}
+
+// bleWait waits for s for up to ms milliseconds, and reports whether it was
+// signaled.
+static BOOL bleWait(dispatch_semaphore_t s, int ms) {
+	return dispatch_semaphore_wait(s, dispatch_time(DISPATCH_TIME_NOW, (int64_t)ms * NSEC_PER_MSEC)) == 0;
+}
+
+// bleDrain drops the signals left by the operations which timed out.
+static void bleDrain(dispatch_semaphore_t s) {
+	while (dispatch_semaphore_wait(s, DISPATCH_TIME_NOW) == 0) {
+	}
+}
+
+// BLEL2CAPStream holds a channel, whose streams it opened.
+API_AVAILABLE(macos(10.13))
+@interface BLEL2CAPStream : NSObject
+@property (strong) CBL2CAPChannel *channel;
+@end
+
+@implementation BLEL2CAPStream
+@end
+
+API_AVAILABLE(macos(10.13))
+static void *bleWrap(CBL2CAPChannel *ch) {
+	BLEL2CAPStream *s = [BLEL2CAPStream new];
+	s.channel = ch;
+	[ch.inputStream open];
+	[ch.outputStream open];
+	return (__bridge_retained void *)s;
+}
+
+// BLEL2CAPCentral opens the channels to the PSMs of peripherals.
+API_AVAILABLE(macos(10.13))
+@interface BLEL2CAPCentral : NSObject <CBCentralManagerDelegate, CBPeripheralDelegate>
+@property (strong) CBCentralManager *manager;
+@property (strong) dispatch_semaphore_t powered;
+@property (strong) dispatch_semaphore_t connected;
+@property (strong) dispatch_semaphore_t opened;
+@property (strong) CBL2CAPChannel *channel;
+@property (strong) NSError *error;
+@end
+
+@implementation BLEL2CAPCentral
+- (instancetype)init {
+	if ((self = [super init])) {
+		_powered = dispatch_semaphore_create(0);
+		_connected = dispatch_semaphore_create(0);
+		_opened = dispatch_semaphore_create(0);
+		dispatch_queue_t q = dispatch_queue_create("ble.l2cap.central", DISPATCH_QUEUE_SERIAL);
+		_manager = [[CBCentralManager alloc] initWithDelegate:self queue:q];
+	}
+	return self;
+}
+
+- (void)centralManagerDidUpdateState:(CBCentralManager *)central {
+	if (central.state == CBManagerStatePoweredOn) {
+		dispatch_semaphore_signal(self.powered);
+	}
+}
+
+- (void)centralManager:(CBCentralManager *)central didConnectPeripheral:(CBPeripheral *)p {
+	dispatch_semaphore_signal(self.connected);
+}
+
+- (void)centralManager:(CBCentralManager *)central didFailToConnectPeripheral:(CBPeripheral *)p error:(NSError *)err {
+	self.error = err;
+	dispatch_semaphore_signal(self.connected);
+}
+
+- (void)peripheral:(CBPeripheral *)p didOpenL2CAPChannel:(CBL2CAPChannel *)ch error:(NSError *)err {
+	self.channel = ch;
+	self.error = err;
+	dispatch_semaphore_signal(self.opened);
+}
+@end
+
+// BLEL2CAPPeripheral publishes PSMs, and hands the channels opened to them
+// to goL2CAPAccepted.
+API_AVAILABLE(macos(10.13))
+@interface BLEL2CAPPeripheral : NSObject <CBPeripheralManagerDelegate>
+@property (strong) CBPeripheralManager *manager;
+@property (strong) dispatch_semaphore_t powered;
+@property (strong) dispatch_semaphore_t published;
+@property (assign) CBL2CAPPSM psm;
+@property (strong) NSError *error;
+@end
+
+@implementation BLEL2CAPPeripheral
+- (instancetype)init {
+	if ((self = [super init])) {
+		_powered = dispatch_semaphore_create(0);
+		_published = dispatch_semaphore_create(0);
+		dispatch_queue_t q = dispatch_queue_create("ble.l2cap.peripheral", DISPATCH_QUEUE_SERIAL);
+		_manager = [[CBPeripheralManager alloc] initWithDelegate:self queue:q options:nil];
+	}
+	return self;
+}
+
+- (void)peripheralManagerDidUpdateState:(CBPeripheralManager *)pm {
+	if (pm.state == CBManagerStatePoweredOn) {
+		dispatch_semaphore_signal(self.powered);
+	}
+}
+
+- (void)peripheralManager:(CBPeripheralManager *)pm didPublishL2CAPChannel:(CBL2CAPPSM)psm error:(NSError *)err {
+	self.psm = psm;
+	self.error = err;
+	dispatch_semaphore_signal(self.published);
+}
+
+- (void)peripheralManager:(CBPeripheralManager *)pm didUnpublishL2CAPChannel:(CBL2CAPPSM)psm error:(NSError *)err {
+}
+
+- (void)peripheralManager:(CBPeripheralManager *)pm didOpenL2CAPChannel:(CBL2CAPChannel *)ch error:(NSError *)err {
+	if (err != nil || ch == nil) {
+		return;
+	}
+	goL2CAPAccepted(ch.PSM, bleWrap(ch), (char *)ch.peer.identifier.UUIDString.UTF8String);
+}
+@end
+
+API_AVAILABLE(macos(10.13))
+static BLEL2CAPCentral *bleCentral(void) {
+	static BLEL2CAPCentral *c;
+	static dispatch_once_t once;
+	dispatch_once(&once, ^{
+		c = [BLEL2CAPCentral new];
+	});
+	return c;
+}
+
+API_AVAILABLE(macos(10.13))
+static BLEL2CAPPeripheral *blePeripheral(void) {
+	static BLEL2CAPPeripheral *p;
+	static dispatch_once_t once;
+	dispatch_once(&once, ^{
+		p = [BLEL2CAPPeripheral new];
+	});
+	return p;
+}
+
+char *bleL2CAPOpen(const char *uuid, uint16_t psm, int ms, void **h) {
+	if (@available(macOS 10.13, *)) {
+		@autoreleasepool {
+			BLEL2CAPCentral *c = bleCentral();
+			if (c.manager.state != CBManagerStatePoweredOn && !bleWait(c.powered, ms)) {
+				return bleError(@"bluetooth isn't powered on");
+			}
+			NSUUID *ident = [[NSUUID alloc] initWithUUIDString:@(uuid)];
+			if (ident == nil) {
+				return bleError(@"invalid peripheral identifier");
+			}
+			CBPeripheral *p = [c.manager retrievePeripheralsWithIdentifiers:@[ident]].firstObject;
+			if (p == nil) {
+				return bleError(@"unknown peripheral");
+			}
+			p.delegate = c;
+			bleDrain(c.connected);
+			bleDrain(c.opened);
+			c.error = nil;
+			c.channel = nil;
+			if (p.state != CBPeripheralStateConnected) {
+				[c.manager connectPeripheral:p options:nil];
+				if (!bleWait(c.connected, ms)) {
+					[c.manager cancelPeripheralConnection:p];
+					return bleError(@"connecting timed out");
+				}
+				if (c.error != nil) {
+					return bleNSError(@"can't connect", c.error);
+				}
+			}
+			[p openL2CAPChannel:psm];
+			if (!bleWait(c.opened, ms)) {
+				return bleError(@"opening the channel timed out");
+			}
+			if (c.error != nil || c.channel == nil) {
+				return bleNSError(@"can't open the channel", c.error);
+			}
+			*h = bleWrap(c.channel);
+			c.channel = nil;
+			return NULL;
+		}
+	}
+	return bleError(@"l2cap channels need macOS 10.13");
+}
+
+char *bleL2CAPPublish(int encrypted, int ms, uint16_t *psm) {
+	if (@available(macOS 10.13, *)) {
+		@autoreleasepool {
+			BLEL2CAPPeripheral *p = blePeripheral();
+			if (p.manager.state != CBManagerStatePoweredOn && !bleWait(p.powered, ms)) {
+				return bleError(@"bluetooth isn't powered on");
+			}
+			bleDrain(p.published);
+			p.error = nil;
+			[p.manager publishL2CAPChannelWithEncryption:encrypted != 0];
+			if (!bleWait(p.published, ms)) {
+				return bleError(@"publishing timed out");
+			}
+			if (p.error != nil) {
+				return bleNSError(@"can't publish", p.error);
+			}
+			*psm = p.psm;
+			return NULL;
+		}
+	}
+	return bleError(@"l2cap channels need macOS 10.13");
+}
+
+void bleL2CAPUnpublish(uint16_t psm) {
+	if (@available(macOS 10.13, *)) {
+		[blePeripheral().manager unpublishL2CAPChannel:psm];
+	}
+}
+
+long bleL2CAPRead(void *h, void *b, long n) {
+	if (@available(macOS 10.13, *)) {
+		BLEL2CAPStream *s = (__bridge BLEL2CAPStream *)h;
+		return (long)[s.channel.inputStream read:(uint8_t *)b maxLength:(NSUInteger)n];
+	}
+	return -1;
+}
+
+long bleL2CAPWrite(void *h, const void *b, long n) {
+	if (@available(macOS 10.13, *)) {
+		BLEL2CAPStream *s = (__bridge BLEL2CAPStream *)h;
+		long sent = 0;
+		while (sent < n) {
+			NSInteger w = [s.channel.outputStream write:(const uint8_t *)b + sent maxLength:(NSUInteger)(n - sent)];
+			if (w <= 0) {
+				break;
+			}
+			sent += w;
+		}
+		return sent;
+	}
+	return 0;
+}
+
+void bleL2CAPClose(void *h) {
+	if (@available(macOS 10.13, *)) {
+		BLEL2CAPStream *s = (__bridge BLEL2CAPStream *)h;
+		[s.channel.inputStream close];
+		[s.channel.outputStream close];
+	}
+}
+
+void bleL2CAPRelease(void *h) {
+	if (@available(macOS 10.13, *)) {
+		BLEL2CAPStream *s = (__bridge_transfer BLEL2CAPStream *)h;
+		s.channel = nil;
+	}
+}
+*/
+import "C"
@@ -0,0 +1,22 @@
+//go:build !darwin || !cgo
+// +build !darwin !cgo
+
+package darwin
+
+import (
+	"github.com/leso-kn/ble"
+	"github.com/pkg/errors"
+)
+
+// The L2CAP channels are opened and published with CoreBluetooth, which
+// needs cgo.
+
+// OpenL2CAPChannel isn't supported without cgo.
+func (cln *Client) OpenL2CAPChannel(psm uint16) (ble.L2CAPChannel, error) {
+	return nil, errors.Wrap(ble.ErrNotImplemented, "l2cap channels need cgo")
+}
+
+// ListenL2CAP isn't supported without cgo.
+func (d *Device) ListenL2CAP(psm uint16, encrypted bool) (ble.L2CAPListener, error) {
+	return nil, errors.Wrap(ble.ErrNotImplemented, "l2cap channels need cgo")
+}
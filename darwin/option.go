@@ -2,8 +2,11 @@ package darwin
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"time"
 
+	"github.com/leso-kn/ble"
 	"github.com/leso-kn/ble/linux/hci/cmd"
 )
 
@@ -39,22 +42,380 @@ func (d *Device) SetConnParams(param cmd.LECreateConnection) error {
 	return errors.New("Not supported")
 }
 
+// SetExtConnParams sets the extended connection parameters.
+func (d *Device) SetExtConnParams(param cmd.LEExtendedCreateConnection) error {
+	return errors.New("Not supported")
+}
+
+// SetConnPHYs sets the PHYs connections are initiated on.
+func (d *Device) SetConnPHYs(phys uint8) error {
+	return errors.New("Not supported")
+}
+
+// SetInitiatorScan sets the scanning of connection initiation.
+func (d *Device) SetInitiatorScan(interval, window time.Duration, acceptList bool) error {
+	return errors.New("Not supported")
+}
+
 // SetScanParams overrides default scanning parameters.
 func (d *Device) SetScanParams(param cmd.LESetScanParameters) error {
 	return errors.New("Not supported")
 }
 
+// SetScanInterval sets the scan interval and window.
+func (d *Device) SetScanInterval(interval, window time.Duration) error {
+	return errors.New("Not supported")
+}
+
+// SetScanType selects active or passive scanning.
+func (d *Device) SetScanType(active bool) error {
+	return errors.New("Not supported")
+}
+
+// SetScanOwnAddrType sets the address type used in scan requests.
+func (d *Device) SetScanOwnAddrType(typ uint8) error {
+	return errors.New("Not supported")
+}
+
 // SetAdvParams overrides default advertising parameters.
 func (d *Device) SetAdvParams(param cmd.LESetAdvertisingParameters) error {
 	return errors.New("Not supported")
 }
 
+// SetAdvInterval sets the range of the advertising interval.
+func (d *Device) SetAdvInterval(min, max time.Duration) error {
+	return errors.New("Not supported")
+}
+
+// SetAdvChannelMap sets the channels used for advertising.
+func (d *Device) SetAdvChannelMap(m uint8) error {
+	return errors.New("Not supported")
+}
+
+// SetAdvOwnAddrType sets the address type used in advertisements.
+func (d *Device) SetAdvOwnAddrType(typ uint8) error {
+	return errors.New("Not supported")
+}
+
 // SetAdvHandlerSync overrides default advertising handler behavior (async)
 func (d *Device) SetAdvHandlerSync(sync bool) error {
 	d.advHandlerSync = sync
 	return nil
 }
 
+// SetScanAllowList isn't supported; CoreBluetooth doesn't report the
+// addresses of the advertisers.
+func (d *Device) SetScanAllowList(addrs ...string) error {
+	return errors.New("Not supported")
+}
+
+// SetScanDenyList isn't supported; CoreBluetooth doesn't report the
+// addresses of the advertisers.
+func (d *Device) SetScanDenyList(addrs ...string) error {
+	return errors.New("Not supported")
+}
+
+// SetScanDedup sets the window in which advertisements with the same device
+// and fields are reported only once. Zero disables the filtering.
+func (d *Device) SetScanDedup(window time.Duration) error {
+	if window < 0 {
+		return fmt.Errorf("invalid dedup window %v", window)
+	}
+	d.advDedup.Lock()
+	d.advDedup.window = window
+	d.advDedup.seen = nil
+	d.advDedup.Unlock()
+	return nil
+}
+
+// SetScanAggregate sets the period of consolidated advertisement reports.
+func (d *Device) SetScanAggregate(period time.Duration) error {
+	return errors.New("Not supported")
+}
+
+// SetScanResponseWait sets how long active scans wait for scan responses.
+func (d *Device) SetScanResponseWait(wait time.Duration) error {
+	return errors.New("Not supported")
+}
+
+// SetScanDutyCycle sets the scan and idle periods of duty-cycled scans.
+func (d *Device) SetScanDutyCycle(window, idle time.Duration) error {
+	return errors.New("Not supported")
+}
+
+// SetAdvParseErrorHandler sets the handler of malformed advertising reports.
+func (d *Device) SetAdvParseErrorHandler(f func(raw []byte, err error)) error {
+	return errors.New("Not supported")
+}
+
+// SetLenientAdvParsing sets whether malformed AD structures are skipped.
+func (d *Device) SetLenientAdvParsing(lenient bool) error {
+	return errors.New("Not supported")
+}
+
+// SetLazyAdvParsing sets whether the fields of advertisements are decoded on
+// demand.
+func (d *Device) SetLazyAdvParsing(lazy bool) error {
+	return errors.New("Not supported")
+}
+
 func (d *Device) EnableSecurity(bondManager interface{}) error {
 	return errors.New("Not supported")
 }
+
+// SetPairingAuthData sets the auth data used when the peer initiates pairing.
+func (d *Device) SetPairingAuthData(ad ble.AuthData) error {
+	return errors.New("Not supported")
+}
+
+// SetKeyDistribution sets the keys distributed when pairing.
+func (d *Device) SetKeyDistribution(initKeys, respKeys uint8) error {
+	return errors.New("Not supported")
+}
+
+// SetEncKeySize sets the range of encryption key sizes accepted.
+func (d *Device) SetEncKeySize(min, max uint8) error {
+	return errors.New("Not supported")
+}
+
+// SetPairingFeatures sets the IO capability and the authentication requirements.
+func (d *Device) SetPairingFeatures(f ble.PairingFeatures) error {
+	return errors.New("Not supported")
+}
+
+// SetRestoreEncryption has Dial encrypt the links with bonded peers.
+func (d *Device) SetRestoreEncryption(on bool) error {
+	return errors.New("Not supported")
+}
+
+// SetMaxConnections limits the connections of the local device.
+func (d *Device) SetMaxConnections(n int) error {
+	return errors.New("Not supported")
+}
+
+// SetInsecureDebugKeys has pairing use the debug key pair.
+func (d *Device) SetInsecureDebugKeys(enable bool) error {
+	return errors.New("Not supported")
+}
+
+// SetConnParamsRequestHandler sets the policy for remote connection parameter requests.
+func (d *Device) SetConnParamsRequestHandler(f ble.ConnParamsRequestHandler) error {
+	return errors.New("Not supported")
+}
+
+// SetPrivacy enables controller-based privacy.
+func (d *Device) SetPrivacy(localIRK []byte, rpaTimeout time.Duration) error {
+	return errors.New("Not supported")
+}
+
+// SetPrivacyFile enables controller-based privacy with the IRK of a file.
+func (d *Device) SetPrivacyFile(filename string, rpaTimeout time.Duration) error {
+	return errors.New("Not supported")
+}
+
+// SetHostAddrResolution enables resolving the private addresses of advertisers.
+func (d *Device) SetHostAddrResolution(enable bool) error {
+	return errors.New("Not supported")
+}
+
+// SetAddressRotation rotates the private address of the device.
+func (d *Device) SetAddressRotation(period time.Duration) error {
+	return errors.New("Not supported")
+}
+
+// SetRandomStaticAddr sets the random static address of the device.
+func (d *Device) SetRandomStaticAddr(a ble.Addr, filename string) error {
+	return errors.New("Not supported")
+}
+
+// SetAdvTxPowerLevel sets whether the TX power level is advertised.
+func (d *Device) SetAdvTxPowerLevel(include bool) error {
+	return errors.New("Not supported")
+}
+
+// SetEventMask sets the HCI event masks.
+func (d *Device) SetEventMask(mask, leMask uint64) error {
+	return errors.New("Not supported")
+}
+
+// SetRecovery enables automatic recovery of the controller.
+func (d *Device) SetRecovery(handler ble.RecoveryHandler) error {
+	return errors.New("Not supported")
+}
+
+// SetAuthPayloadTimeout sets the authenticated payload timeout of encrypted links.
+func (d *Device) SetAuthPayloadTimeout(dur time.Duration, expired func(ble.Addr)) error {
+	return errors.New("Not supported")
+}
+
+// SetACLWriteTimeout sets how long writes wait for controller buffers.
+func (d *Device) SetACLWriteTimeout(dur time.Duration) error {
+	return errors.New("Not supported")
+}
+
+// SetSkipHCIReset sets whether the HCI Reset is skipped at init.
+func (d *Device) SetSkipHCIReset(skip bool) error {
+	return errors.New("Not supported")
+}
+
+// SetInitCommands sets commands sent around the init sequence.
+func (d *Device) SetInitCommands(pre, post []ble.HCICommand) error {
+	return errors.New("Not supported")
+}
+
+// SetUartVendor sets the vendor hook of the H4 UART transport.
+func (d *Device) SetUartVendor(vendor interface{}, initBaud int) error {
+	return errors.New("Not supported")
+}
+
+// SetTransportVirtual sets a virtual controller as the transport.
+func (d *Device) SetTransportVirtual(ctrl io.ReadWriteCloser) error {
+	return errors.New("Not supported")
+}
+
+// SetTransportRecord records the HCI session of the transport.
+func (d *Device) SetTransportRecord(w io.Writer) error {
+	return errors.New("Not supported")
+}
+
+// SetAdvFilterPolicy sets the advertising filter policy.
+func (d *Device) SetAdvFilterPolicy(policy uint8) error {
+	return errors.New("Not supported")
+}
+
+// SetAdvRestart sets whether advertising restarts after a central connects.
+func (d *Device) SetAdvRestart(on bool, maxConns int) error {
+	return errors.New("Not supported")
+}
+
+// SetTransportTrace traces the HCI session of the transport.
+func (d *Device) SetTransportTrace(w io.Writer) error {
+	return errors.New("Not supported")
+}
+
+// SetHCIChannel sets the channel of the hci socket transport.
+func (d *Device) SetHCIChannel(ch interface{}) error {
+	return errors.New("Not supported")
+}
+
+// SetH4SocketAuth secures the h4 socket transport.
+func (d *Device) SetH4SocketAuth(auth interface{}) error {
+	return errors.New("Not supported")
+}
+
+// SetErrorHandler isn't supported.
+func (d *Device) SetErrorHandler(handler func(error)) error {
+	return errors.New("Not supported")
+}
+
+// SetTransportHCISocket isn't supported.
+func (d *Device) SetTransportHCISocket(id int) error {
+	return errors.New("Not supported")
+}
+
+// SetTransportH4Socket isn't supported.
+func (d *Device) SetTransportH4Socket(addr string, timeout time.Duration) error {
+	return errors.New("Not supported")
+}
+
+// SetTransportH4Uart isn't supported.
+func (d *Device) SetTransportH4Uart(path string, baud int) error {
+	return errors.New("Not supported")
+}
+
+// SetGattCacheFile does nothing; CoreBluetooth caches attributes by itself.
+func (d *Device) SetGattCacheFile(filename string) {
+}
+
+// SetNotificationWorkers isn't supported; notifications are handled in the
+// order CoreBluetooth reports them.
+func (d *Device) SetNotificationWorkers(n int) error {
+	return errors.New("Not supported")
+}
+
+// SetUUIDCompression can't turn compression off; CoreBluetooth reports the
+// short form of the UUIDs based on the Bluetooth Base UUID.
+func (d *Device) SetUUIDCompression(on bool) error {
+	if !on {
+		return errors.New("Not supported")
+	}
+	return nil
+}
+
+// SetRegistry isn't supported; the advertisement handler can pass the
+// advertisements to registry.Registry.Observe instead.
+func (d *Device) SetRegistry(r interface{}) error {
+	return errors.New("Not supported")
+}
+
+// SetReadCoalescing isn't supported; reads are passed to CoreBluetooth as they're
+// issued.
+func (d *Device) SetReadCoalescing(on bool) error {
+	return errors.New("Not supported")
+}
+
+// SetGATTRateLimit limits the GATT operations over each connection.
+func (d *Device) SetGATTRateLimit(rate float64, spacing time.Duration) error {
+	return errors.New("Not supported")
+}
+
+// SetNotificationOrdering sets whether reads wait for the notifications
+// received before them.
+func (d *Device) SetNotificationOrdering(on bool) error {
+	return errors.New("Not supported")
+}
+
+// SetLEOnly isn't supported; CoreBluetooth owns the controller.
+func (d *Device) SetLEOnly(on bool) error {
+	return errors.New("Not supported")
+}
+
+// SetStaticPasskey isn't supported; CoreBluetooth pairs with the user's
+// confirmation.
+func (d *Device) SetStaticPasskey(passkey int) error {
+	return errors.New("Not supported")
+}
+
+// SetPreferredConnParams isn't supported; CoreBluetooth doesn't let the
+// peripheral request connection parameters.
+func (d *Device) SetPreferredConnParams(p ble.ConnParams, delay time.Duration, result ble.ConnParamsResultHandler) error {
+	return errors.New("Not supported")
+}
+
+// SetAdvValidation isn't supported; CoreBluetooth composes the
+// advertisements.
+func (d *Device) SetAdvValidation(on bool) error {
+	return errors.New("Not supported")
+}
+
+// SetLogger isn't supported; the package logs through the logger of ble.
+func (d *Device) SetLogger(l ble.Logger) error {
+	return errors.New("Not supported")
+}
+
+// SetLogSampling isn't supported.
+func (d *Device) SetLogSampling(subsystem string, s ble.LogSampling) error {
+	return errors.New("Not supported")
+}
+
+// SetSmpCrypto isn't supported; CoreBluetooth pairs.
+func (d *Device) SetSmpCrypto(c interface{}) error {
+	return errors.New("Not supported")
+}
+
+// SetStrictSequential isn't supported; CoreBluetooth queues the requests.
+func (d *Device) SetStrictSequential(on bool) error {
+	return errors.New("Not supported")
+}
+
+// SetNotificationTimestamps isn't supported; CoreBluetooth doesn't tell
+// when the notifications were received.
+func (d *Device) SetNotificationTimestamps(on bool) error {
+	return errors.New("Not supported")
+}
+
+// SetResponseTolerance isn't supported; CoreBluetooth correlates the
+// responses.
+func (d *Device) SetResponseTolerance(tolerance time.Duration) error {
+	return errors.New("Not supported")
+}
@@ -0,0 +1,116 @@
+package darwin
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/raff/goble/xpc"
+)
+
+// ErrNoPairingCharacteristic is returned by Pair and StartEncryption if no
+// characteristic was set with SetPairingCharacteristic. CoreBluetooth has no
+// API to pair: it pairs, or encrypts the link with a bonded peer, when a
+// protected characteristic is accessed.
+var ErrNoPairingCharacteristic = errors.New("no protected characteristic set to pair with")
+
+// SetPairingCharacteristic sets the characteristic Pair and StartEncryption
+// read for CoreBluetooth to pair, or to encrypt the link with a bonded peer.
+// Reading it must require encryption or authentication on the peer.
+func (cln *Client) SetPairingCharacteristic(c *ble.Characteristic) {
+	cln.conn.Lock()
+	cln.conn.pairChar = c
+	cln.conn.Unlock()
+}
+
+// Encrypted reports whether the link is known to be encrypted, as Pair or
+// StartEncryption succeeded. CoreBluetooth doesn't report the bond state
+// otherwise.
+func (cln *Client) Encrypted() bool {
+	cln.conn.RLock()
+	defer cln.conn.RUnlock()
+	return cln.conn.encInfo.Enabled
+}
+
+// pairingFailed reports whether the ATT error err of an access to a
+// protected attribute means CoreBluetooth couldn't pair or encrypt the link
+// for it, e.g. as the user declined the pairing, or the peer lost the bond.
+func pairingFailed(err error) bool {
+	switch err {
+	case ble.ErrAuthentication, ble.ErrInsuffEnc, ble.ErrInsuffEncrKeySize:
+		return true
+	}
+	return false
+}
+
+// secure reads the pairing characteristic, for CoreBluetooth to pair or to
+// encrypt the link, and records the outcome.
+func (c *conn) secure() error {
+	c.RLock()
+	pc := c.pairChar
+	c.RUnlock()
+	if pc == nil {
+		return ErrNoPairingCharacteristic
+	}
+	rsp, err := c.sendReq(cmdReadCharacteristic, xpc.Dict{
+		"kCBMsgArgDeviceUUID":                xpc.MakeUUID(c.addr.String()),
+		"kCBMsgArgCharacteristicHandle":      pc.Handle,
+		"kCBMsgArgCharacteristicValueHandle": pc.ValueHandle,
+	})
+	if err != nil {
+		return err
+	}
+	err = rsp.err()
+	switch {
+	case err == nil:
+		c.Lock()
+		c.encInfo = ble.EncryptionChangedInfo{Enabled: true}
+		c.Unlock()
+		return nil
+	case pairingFailed(err):
+		c.Lock()
+		c.encInfo = ble.EncryptionChangedInfo{Status: int(err.(ble.ATTError)), Err: err}
+		c.Unlock()
+		return fmt.Errorf("pairing failed: %w", err)
+	}
+	return fmt.Errorf("read pairing characteristic: %w", err)
+}
+
+// Pair has CoreBluetooth pair with the peer, by reading the characteristic
+// set with SetPairingCharacteristic. CoreBluetooth asks the user for the
+// passkey, if any, and times the pairing out by itself, so ad and to are
+// ignored. It fails with an error wrapping the ATT error if the pairing
+// failed, e.g. as the user declined it.
+func (c *conn) Pair(ad ble.AuthData, to time.Duration) error {
+	return c.secure()
+}
+
+// StartEncryption has CoreBluetooth encrypt the link, with the keys of the
+// bond if the peer is bonded, or else by pairing, by reading the
+// characteristic set with SetPairingCharacteristic. The outcome is sent to
+// ch, if set.
+func (c *conn) StartEncryption(ch chan ble.EncryptionChangedInfo) error {
+	c.RLock()
+	enabled := c.encInfo.Enabled
+	c.RUnlock()
+	if enabled && ch == nil {
+		return ble.ErrEncryptionAlreadyEnabled
+	}
+	var err error
+	if !enabled {
+		err = c.secure()
+	}
+	if ch != nil && err != ErrNoPairingCharacteristic {
+		c.RLock()
+		info := c.encInfo
+		c.RUnlock()
+		go func() {
+			select {
+			case ch <- info:
+			case <-time.After(time.Second):
+			}
+		}()
+	}
+	return err
+}
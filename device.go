@@ -31,12 +31,29 @@ type Device interface {
 	// AdvertiseServiceData16 advertises data associated with a 16bit service uuid
 	AdvertiseServiceData16(ctx context.Context, id uint16, b []byte) error
 
+	// AdvertiseServiceData advertises data associated with a 16, 32, or 128bit
+	// service uuid.
+	AdvertiseServiceData(ctx context.Context, u UUID, b []byte) error
+
 	// AdvertiseIBeaconData advertise iBeacon with given manufacturer data.
 	AdvertiseIBeaconData(ctx context.Context, b []byte) error
 
 	// AdvertiseIBeacon advertises iBeacon with specified parameters.
 	AdvertiseIBeacon(ctx context.Context, u UUID, major, minor uint16, pwr int8) error
 
+	// AdvertiseEddystoneUID advertises an Eddystone-UID frame.
+	AdvertiseEddystoneUID(ctx context.Context, namespace [10]byte, instance [6]byte, txPower int8) error
+
+	// AdvertiseEddystoneURL advertises an Eddystone-URL frame.
+	AdvertiseEddystoneURL(ctx context.Context, url string, txPower int8) error
+
+	// AdvertiseAltBeacon advertises an AltBeacon with the specified parameters.
+	AdvertiseAltBeacon(ctx context.Context, mfgID uint16, id1 [16]byte, id2, id3 uint16, refRSSI int8) error
+
+	// AdvertiseData advertises the given advertising data and scan response
+	// data, such as the packets composed by an advertisement builder.
+	AdvertiseData(ctx context.Context, ad, sr []byte) error
+
 	// Scan starts scanning. Duplicated advertisements will be filtered out if allowDup is set to false, async handling
 	Scan(ctx context.Context, allowDup bool, h AdvHandler) error
 
@@ -56,3 +73,8 @@ type Device interface {
 	// When sending a struct with an array or a slice, a fixed sized array must be used rather than a slice
 	SendVendorSpecificCommand(opcode uint16, length uint8, v interface{}) error
 }
+
+// RecoveryHandler is called after an attempt to recover the controller from a
+// failure, with the cause of the failure and the error of the attempt, which
+// is nil if the controller was recovered. Failed attempts are retried.
+type RecoveryHandler func(cause error, err error)
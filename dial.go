@@ -0,0 +1,36 @@
+package ble
+
+import (
+	"context"
+	"time"
+)
+
+// DialParams are the connection parameters of a single Dial, taking the
+// place of the device defaults. Zero durations keep the defaults.
+type DialParams struct {
+	// ScanInterval and ScanWindow control the scanning for the peer while
+	// initiating the connection.
+	ScanInterval time.Duration
+	ScanWindow   time.Duration
+
+	ConnIntervalMin    time.Duration
+	ConnIntervalMax    time.Duration
+	SupervisionTimeout time.Duration
+
+	// ConnLatency is the number of connection events the peripheral may
+	// skip. Unlike the durations, it always applies.
+	ConnLatency uint16
+}
+
+// WithDialParams returns a copy of ctx which makes Dial and Connect use the
+// connection parameters p.
+func WithDialParams(ctx context.Context, p DialParams) context.Context {
+	return context.WithValue(ctx, ContextKeyDialParams, p)
+}
+
+// DialParamsFromContext returns the connection parameters set on ctx with
+// WithDialParams, if any.
+func DialParamsFromContext(ctx context.Context) (DialParams, bool) {
+	p, ok := ctx.Value(ContextKeyDialParams).(DialParams)
+	return p, ok
+}
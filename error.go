@@ -12,9 +12,27 @@ var ErrEIRPacketTooLong = errors.New("max packet length is 31")
 // ErrNotImplemented means the functionality is not implemented.
 var ErrNotImplemented = errors.New("not implemented")
 
+// ErrAdapterLost means the controller disappeared, e.g. its USB-serial
+// adapter was unplugged.
+var ErrAdapterLost = errors.New("adapter lost")
+
 // ErrEncryptionAlreadyEnabled means that encryption is enabled and shouldn't be enabled again
 var ErrEncryptionAlreadyEnabled = errors.New("encryption already enabled")
 
+// ErrBondMissing is returned by Dial when the encryption of a bonded peer
+// couldn't be restored as the peer no longer has the bond. The local bond is
+// deleted, so the peer can be paired again. Dial may wrap it, use errors.Cause
+// of github.com/pkg/errors to get it back.
+var ErrBondMissing = errors.New("bond missing on peer")
+
+// ErrBondRejected is returned by Dial when a bonded peer refused to restore
+// the encryption of the link for another reason, e.g. a key mismatch.
+var ErrBondRejected = errors.New("bond rejected by peer")
+
+// ErrConnParamsRejected means the central rejected the connection
+// parameters requested by the peripheral.
+var ErrConnParamsRejected = errors.New("connection parameters rejected by central")
+
 // ATTError is the error code of Attribute Protocol [Vol 3, Part F, 3.4.1.1].
 type ATTError byte
 
@@ -40,6 +58,14 @@ const (
 	ErrInsuffResources   ATTError = 0x11 // ErrInsuffResources means insufficient resources to complete the request.
 )
 
+// ATTError is a common profile and service error code [CSS, Part B, 1.2].
+const (
+	ErrWriteReqRejected ATTError = 0xFC // ErrWriteReqRejected means the write request was rejected by the profile or the service.
+	ErrCCCDImproperConf ATTError = 0xFD // ErrCCCDImproperConf means the Client Characteristic Configuration descriptor is not configured as the profile or the service requires.
+	ErrProcInProgress   ATTError = 0xFE // ErrProcInProgress means a request was made while a previous one is still in progress.
+	ErrOutOfRange       ATTError = 0xFF // ErrOutOfRange means the attribute value is out of range.
+)
+
 func (e ATTError) Error() string {
 	switch i := int(e); {
 	case i < 0x11:
@@ -51,6 +77,9 @@ func (e ATTError) Error() string {
 	case i >= 0xA0 && i <= 0xDF: // Reserved for future use.
 		return fmt.Sprintf("reserved error code (0x%02X)", i)
 	case i >= 0xE0 && i <= 0xFF: // Common profile and service error codes.
+		if n, ok := errName[e]; ok {
+			return n
+		}
 		return "profile or service error"
 	}
 	return "unknown error"
@@ -75,4 +104,8 @@ var errName = map[ATTError]string{
 	ErrInsuffEnc:         "insufficient encryption",
 	ErrUnsuppGrpType:     "unsupported group type",
 	ErrInsuffResources:   "insufficient resources",
+	ErrWriteReqRejected:  "write request rejected",
+	ErrCCCDImproperConf:  "client characteristic configuration descriptor improperly configured",
+	ErrProcInProgress:    "procedure already in progress",
+	ErrOutOfRange:        "out of range",
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/leso-kn/ble/linux/hci/h4"
+	"github.com/leso-kn/ble/linux/hci/socket"
+)
+
+var (
+	listen = flag.String("listen", ":8888", "address to serve the controller on")
+	h4uart = flag.String("h4u", "", "h4 uart")
+	hciSkt = flag.Int("device", -1, "hci index")
+
+	cert      = flag.String("cert", "", "TLS certificate; enables TLS with -key")
+	key       = flag.String("key", "", "TLS key")
+	clientCA  = flag.String("clientca", "", "CA of the client certificates to require")
+	tokenFile = flag.String("tokenfile", "", "file with the token clients must present")
+)
+
+// auth returns the proxy's auth settings from the flags, or nil.
+func auth() (*h4.SocketAuth, error) {
+	a := &h4.SocketAuth{}
+	if *cert != "" {
+		c, err := tls.LoadX509KeyPair(*cert, *key)
+		if err != nil {
+			return nil, err
+		}
+		a.TLS = &tls.Config{Certificates: []tls.Certificate{c}}
+		if *clientCA != "" {
+			pem, err := ioutil.ReadFile(*clientCA)
+			if err != nil {
+				return nil, err
+			}
+			a.TLS.ClientCAs = x509.NewCertPool()
+			a.TLS.ClientCAs.AppendCertsFromPEM(pem)
+			a.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	if *tokenFile != "" {
+		t, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		a.Token = strings.TrimSpace(string(t))
+	}
+	if a.TLS == nil && a.Token == "" {
+		return nil, nil
+	}
+	return a, nil
+}
+
+func main() {
+	flag.Parse()
+
+	var dev io.ReadWriteCloser
+	var err error
+	if len(*h4uart) > 0 {
+		so := h4.DefaultSerialOptions()
+		so.PortName = *h4uart
+		dev, err = h4.NewSerial(so)
+	} else {
+		dev, err = socket.NewSocket(*hciSkt)
+	}
+	if err != nil {
+		log.Fatalf("can't open controller: %s", err)
+	}
+
+	a, err := auth()
+	if err != nil {
+		log.Fatalf("can't set up auth: %s", err)
+	}
+	if a == nil {
+		log.Printf("warning: serving plaintext HCI to anyone who can connect")
+	}
+	p, err := h4.NewProxyWithAuth(dev, *listen, a)
+	if err != nil {
+		log.Fatalf("can't listen: %s", err)
+	}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		p.Close()
+	}()
+
+	// Connect with e.g. ble.OptTransportH4Socket("host:8888", 2*time.Second),
+	// and ble.OptH4SocketAuth with the matching settings.
+	log.Printf("serving the controller on %v", p.Addr())
+	if err := p.Serve(); err != nil {
+		log.Fatalf("proxy: %s", err)
+	}
+}
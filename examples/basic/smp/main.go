@@ -93,8 +93,24 @@ func main() {
 		*/
 		log.Println("pairing with", cln.Addr().String())
 		ad := ble.AuthData{}
-		if passkey != nil {
+		if *passkey != 0 {
 			ad.Passkey = *passkey
+		} else {
+			ad.InputPasskey = func() (int, error) {
+				var n int
+				fmt.Print("enter passkey: ")
+				_, err := fmt.Scanln(&n)
+				return n, err
+			}
+		}
+		ad.DisplayPasskey = func(n int) {
+			log.Printf("passkey: %06d", n)
+		}
+		ad.ConfirmNumericComparison = func(c ble.NumericComparison) bool {
+			var yes string
+			fmt.Printf("does %v show %06d? [y/n]: ", c.Peer, c.Value)
+			fmt.Scanln(&yes)
+			return yes == "y"
 		}
 		err = cln.Pair(ad, time.Minute)
 		if err != nil {
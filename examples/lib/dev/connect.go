@@ -0,0 +1,47 @@
+package dev
+
+import (
+	"context"
+
+	"github.com/leso-kn/ble"
+	"github.com/pkg/errors"
+)
+
+// connecter is implemented by devices which scan and connect by themselves,
+// such as the linux device.
+type connecter interface {
+	Connect(ctx context.Context, f ble.AdvFilter) (ble.Client, error)
+}
+
+// Connect scans with d for the first connectable advertiser matching f, and
+// connects to it.
+func Connect(ctx context.Context, d ble.Device, f ble.AdvFilter) (ble.Client, error) {
+	if c, ok := d.(connecter); ok {
+		return c.Connect(ctx, f)
+	}
+
+	ctx2, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch := make(chan ble.Advertisement, 1)
+	h := func(a ble.Advertisement) {
+		if !a.Connectable() || (f != nil && !f(a)) {
+			return
+		}
+		select {
+		case ch <- a:
+			cancel()
+		default:
+		}
+	}
+	if err := d.Scan(ctx2, false, h); err != nil && err != context.Canceled {
+		return nil, errors.Wrap(err, "can't scan")
+	}
+
+	select {
+	case a := <-ch:
+		cln, err := d.Dial(ctx, a.Addr())
+		return cln, errors.Wrap(err, "can't dial")
+	default:
+		return nil, ctx.Err()
+	}
+}
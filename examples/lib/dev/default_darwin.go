@@ -9,3 +9,7 @@ import (
 func DefaultDevice(opts ...ble.Option) (d ble.Device, err error) {
 	return darwin.NewDevice(opts...)
 }
+
+func newDevice(impl string, opts ...ble.Option) (d ble.Device, err error) {
+	return DefaultDevice(opts...)
+}
@@ -3,9 +3,17 @@ package dev
 import (
 	"github.com/leso-kn/ble"
 	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/bluez"
 )
 
 // DefaultDevice ...
 func DefaultDevice(opts ...ble.Option) (d ble.Device, err error) {
 	return linux.NewDevice(opts...)
 }
+
+func newDevice(impl string, opts ...ble.Option) (d ble.Device, err error) {
+	if impl == "bluez" {
+		return bluez.NewDevice(opts...)
+	}
+	return DefaultDevice(opts...)
+}
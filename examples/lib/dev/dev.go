@@ -4,7 +4,8 @@ import (
 	"github.com/leso-kn/ble"
 )
 
-// NewDevice ...
+// NewDevice returns the device of the implementation impl, such as "bluez" on
+// linux, or the default device of the platform.
 func NewDevice(impl string, opts ...ble.Option) (d ble.Device, err error) {
-	return DefaultDevice(opts...)
+	return newDevice(impl, opts...)
 }
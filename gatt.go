@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -81,6 +82,15 @@ func AdvertiseIBeacon(ctx context.Context, u UUID, major, minor uint16, pwr int8
 	return defaultDevice.AdvertiseIBeacon(ctx, u, major, minor, pwr)
 }
 
+// AdvertiseData advertises the given advertising data and scan response data.
+func AdvertiseData(ctx context.Context, ad, sr []byte) error {
+	if defaultDevice == nil {
+		return ErrDefaultDevice
+	}
+	defer untrap(trap(ctx))
+	return defaultDevice.AdvertiseData(ctx, ad, sr)
+}
+
 // Scan starts scanning. Duplicated advertisements will be filtered out if allowDup is set to false.
 func Scan(ctx context.Context, allowDup bool, h AdvHandler, f AdvFilter) error {
 	if defaultDevice == nil {
@@ -149,8 +159,27 @@ func Connect(ctx context.Context, f AdvFilter) (Client, error) {
 }
 
 // A NotificationHandler handles notification or indication from a server.
+// The data bb is only valid during the call, as backends may reuse its
+// buffer for the notifications received next. Handlers which keep it, e.g.
+// by sending it over a channel, copy it, or are wrapped by
+// RetainNotifications.
 type NotificationHandler func(id uint, bb []byte)
 
+// A TimestampedNotificationHandler is a NotificationHandler also passed the
+// time t the notification was received at, stamped by the HCI before any
+// queuing if the device was set up with OptNotificationTimestamps, or the
+// zero time otherwise. The times of the notifications of all the
+// connections of a device are read from the same clock.
+type TimestampedNotificationHandler func(id uint, bb []byte, t time.Time)
+
+// RetainNotifications returns a handler passing h a copy of the data, which
+// h may keep beyond the call.
+func RetainNotifications(h NotificationHandler) NotificationHandler {
+	return func(id uint, bb []byte) {
+		h(id, append([]byte(nil), bb...))
+	}
+}
+
 // WithSigHandler ...
 func WithSigHandler(ctx context.Context, cancel func()) context.Context {
 	return context.WithValue(ctx, ContextKeySig, cancel)
@@ -0,0 +1,277 @@
+// Package gattspec renders GATT profiles, discovered by a client or served
+// by a local GATT server, in the service description formats of the
+// Bluetooth SIG: GATT XML, and its YAML equivalent. The output documents a
+// device, and the profiles of two firmware revisions can be diffed.
+//
+// Attributes are listed in the order of the profile. Handles are left out,
+// unless Options.Handles is set, so that reordering the database doesn't
+// show up in a diff.
+package gattspec
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/leso-kn/ble"
+)
+
+// Options tune the rendering of a profile.
+type Options struct {
+	// Handles adds the attribute handles.
+	Handles bool
+	// Values adds the values known of characteristics and descriptors, as
+	// hex, e.g. the static values of a local server.
+	Values bool
+}
+
+// Property requirements, as in the SIG service descriptions.
+const (
+	mandatory = "Mandatory"
+	excluded  = "Excluded"
+)
+
+type xmlProfile struct {
+	XMLName  xml.Name     `xml:"Services"`
+	Services []xmlService `xml:"Service"`
+}
+
+type xmlService struct {
+	Name            string              `xml:"name,attr,omitempty"`
+	Type            string              `xml:"type,attr,omitempty"`
+	UUID            string              `xml:"uuid,attr"`
+	Handle          string              `xml:"handle,attr,omitempty"`
+	Characteristics []xmlCharacteristic `xml:"Characteristics>Characteristic"`
+}
+
+type xmlCharacteristic struct {
+	Name        string          `xml:"name,attr,omitempty"`
+	Type        string          `xml:"type,attr,omitempty"`
+	UUID        string          `xml:"uuid,attr"`
+	Handle      string          `xml:"handle,attr,omitempty"`
+	Value       string          `xml:"Value,omitempty"`
+	Properties  xmlProperties   `xml:"Properties"`
+	Descriptors []xmlDescriptor `xml:"Descriptors>Descriptor,omitempty"`
+}
+
+type xmlProperties struct {
+	Read                 string `xml:"Read"`
+	Write                string `xml:"Write"`
+	WriteWithoutResponse string `xml:"WriteWithoutResponse"`
+	SignedWrite          string `xml:"SignedWrite"`
+	ReliableWrite        string `xml:"ReliableWrite"`
+	Notify               string `xml:"Notify"`
+	Indicate             string `xml:"Indicate"`
+	WritableAuxiliaries  string `xml:"WritableAuxiliaries"`
+	Broadcast            string `xml:"Broadcast"`
+}
+
+type xmlDescriptor struct {
+	Name       string               `xml:"name,attr,omitempty"`
+	Type       string               `xml:"type,attr,omitempty"`
+	UUID       string               `xml:"uuid,attr"`
+	Handle     string               `xml:"handle,attr,omitempty"`
+	Value      string               `xml:"Value,omitempty"`
+	Properties *xmlDescriptorAccess `xml:"Properties,omitempty"`
+}
+
+type xmlDescriptorAccess struct {
+	Read  string `xml:"Read"`
+	Write string `xml:"Write"`
+}
+
+// XML renders the profile p as GATT XML, with a Service element per
+// service under a Services root.
+func XML(p *ble.Profile, o Options) ([]byte, error) {
+	xp := xmlProfile{}
+	for _, s := range p.Services {
+		xs := xmlService{
+			Name:   ble.Name(s.UUID),
+			Type:   ble.Type(s.UUID),
+			UUID:   uuid(s.UUID),
+			Handle: handle(o, s.Handle),
+		}
+		for _, c := range s.Characteristics {
+			xs.Characteristics = append(xs.Characteristics, xmlChar(c, o))
+		}
+		xp.Services = append(xp.Services, xs)
+	}
+	b, err := xml.MarshalIndent(xp, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+func xmlChar(c *ble.Characteristic, o Options) xmlCharacteristic {
+	ext := extendedProperties(c)
+	xc := xmlCharacteristic{
+		Name:   ble.Name(c.UUID),
+		Type:   ble.Type(c.UUID),
+		UUID:   uuid(c.UUID),
+		Handle: handle(o, c.Handle),
+		Value:  value(o, c.Value),
+		Properties: xmlProperties{
+			Read:                 requirement(c.Property&ble.CharRead != 0),
+			Write:                requirement(c.Property&ble.CharWrite != 0),
+			WriteWithoutResponse: requirement(c.Property&ble.CharWriteNR != 0),
+			SignedWrite:          requirement(c.Property&ble.CharSignedWrite != 0),
+			ReliableWrite:        requirement(ext&0x01 != 0),
+			Notify:               requirement(c.Property&ble.CharNotify != 0),
+			Indicate:             requirement(c.Property&ble.CharIndicate != 0),
+			WritableAuxiliaries:  requirement(ext&0x02 != 0),
+			Broadcast:            requirement(c.Property&ble.CharBroadcast != 0),
+		},
+	}
+	for _, d := range c.Descriptors {
+		xd := xmlDescriptor{
+			Name:   ble.Name(d.UUID),
+			Type:   ble.Type(d.UUID),
+			UUID:   uuid(d.UUID),
+			Handle: handle(o, d.Handle),
+			Value:  value(o, d.Value),
+		}
+		// Only the descriptors of a local server have known properties.
+		if d.Property != 0 {
+			xd.Properties = &xmlDescriptorAccess{
+				Read:  requirement(d.Property&ble.CharRead != 0),
+				Write: requirement(d.Property&ble.CharWrite != 0),
+			}
+		}
+		xc.Descriptors = append(xc.Descriptors, xd)
+	}
+	return xc
+}
+
+// YAML renders the profile p as YAML, with the structure and names of the
+// GATT XML.
+func YAML(p *ble.Profile, o Options) []byte {
+	b := &bytes.Buffer{}
+	fmt.Fprintln(b, "services:")
+	for _, s := range p.Services {
+		fmt.Fprintf(b, "  - uuid: %s\n", quote(uuid(s.UUID)))
+		attrs(b, "    ", s.UUID, o, s.Handle, nil)
+		if len(s.Characteristics) == 0 {
+			continue
+		}
+		fmt.Fprintln(b, "    characteristics:")
+		for _, c := range s.Characteristics {
+			fmt.Fprintf(b, "      - uuid: %s\n", quote(uuid(c.UUID)))
+			attrs(b, "        ", c.UUID, o, c.Handle, c.Value)
+			fmt.Fprintf(b, "        properties: [%s]\n", strings.Join(properties(c), ", "))
+			if len(c.Descriptors) == 0 {
+				continue
+			}
+			fmt.Fprintln(b, "        descriptors:")
+			for _, d := range c.Descriptors {
+				fmt.Fprintf(b, "          - uuid: %s\n", quote(uuid(d.UUID)))
+				attrs(b, "            ", d.UUID, o, d.Handle, d.Value)
+				if d.Property != 0 {
+					var ps []string
+					if d.Property&ble.CharRead != 0 {
+						ps = append(ps, "read")
+					}
+					if d.Property&ble.CharWrite != 0 {
+						ps = append(ps, "write")
+					}
+					fmt.Fprintf(b, "            properties: [%s]\n", strings.Join(ps, ", "))
+				}
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+// attrs writes the name, type, handle and value of an attribute, if known
+// and selected.
+func attrs(b *bytes.Buffer, indent string, u ble.UUID, o Options, h uint16, v []byte) {
+	if n := ble.Name(u); n != "" {
+		fmt.Fprintf(b, "%sname: %s\n", indent, quote(n))
+	}
+	if t := ble.Type(u); t != "" {
+		fmt.Fprintf(b, "%stype: %s\n", indent, quote(t))
+	}
+	if h := handle(o, h); h != "" {
+		fmt.Fprintf(b, "%shandle: %s\n", indent, quote(h))
+	}
+	if v := value(o, v); v != "" {
+		fmt.Fprintf(b, "%svalue: %s\n", indent, quote(v))
+	}
+}
+
+// properties returns the YAML names of the properties of c.
+func properties(c *ble.Characteristic) []string {
+	ext := extendedProperties(c)
+	var ps []string
+	for _, p := range []struct {
+		on   bool
+		name string
+	}{
+		{c.Property&ble.CharRead != 0, "read"},
+		{c.Property&ble.CharWrite != 0, "write"},
+		{c.Property&ble.CharWriteNR != 0, "write_without_response"},
+		{c.Property&ble.CharSignedWrite != 0, "signed_write"},
+		{ext&0x01 != 0, "reliable_write"},
+		{c.Property&ble.CharNotify != 0, "notify"},
+		{c.Property&ble.CharIndicate != 0, "indicate"},
+		{ext&0x02 != 0, "writable_auxiliaries"},
+		{c.Property&ble.CharBroadcast != 0, "broadcast"},
+	} {
+		if p.on {
+			ps = append(ps, p.name)
+		}
+	}
+	return ps
+}
+
+// extendedProperties returns the Characteristic Extended Properties of c,
+// if its descriptor's value is known. [Vol 3, Part G, 3.3.3.1]
+func extendedProperties(c *ble.Characteristic) uint16 {
+	if c.Property&ble.CharExtended == 0 {
+		return 0
+	}
+	for _, d := range c.Descriptors {
+		if d.UUID.Equal(ble.UUID16(0x2900)) && len(d.Value) >= 2 {
+			return uint16(d.Value[0]) | uint16(d.Value[1])<<8
+		}
+	}
+	return 0
+}
+
+func requirement(on bool) string {
+	if on {
+		return mandatory
+	}
+	return excluded
+}
+
+// uuid returns u as the SIG descriptions write it, in upper case, with the
+// groups of 128-bit UUIDs dashed.
+func uuid(u ble.UUID) string {
+	s := strings.ToUpper(u.String())
+	if len(s) != 32 {
+		return s
+	}
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+func handle(o Options, h uint16) string {
+	if !o.Handles {
+		return ""
+	}
+	return fmt.Sprintf("0x%04X", h)
+}
+
+func value(o Options, v []byte) string {
+	if !o.Values || len(v) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%X", v)
+}
+
+// quote quotes s as a YAML double-quoted scalar, whose escapes include Go's.
+func quote(s string) string {
+	return strconv.Quote(s)
+}
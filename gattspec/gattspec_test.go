@@ -0,0 +1,96 @@
+package gattspec_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/gattspec"
+)
+
+func profile() *ble.Profile {
+	hr := ble.NewService(ble.UUID16(0x180D))
+	hr.Handle, hr.EndHandle = 0x0010, 0x0013
+	m := hr.NewCharacteristic(ble.UUID16(0x2A37))
+	m.Property = ble.CharNotify
+	m.Handle, m.ValueHandle = 0x0011, 0x0012
+	cccd := m.NewDescriptor(ble.ClientCharacteristicConfigUUID)
+	cccd.Handle = 0x0013
+	m.CCCD = cccd
+
+	v := ble.NewService(ble.MustParse("00010000-0001-1000-8000-00805F9B34FB"))
+	c := v.NewCharacteristic(ble.MustParse("00010000-0002-1000-8000-00805F9B34FB"))
+	c.Property = ble.CharRead | ble.CharWrite | ble.CharExtended
+	c.Value = []byte{0x01, 0x02}
+	c.NewDescriptor(ble.UUID16(0x2900)).Value = []byte{0x01, 0x00}
+	return &ble.Profile{Services: []*ble.Service{hr, v}}
+}
+
+func TestXML(t *testing.T) {
+	b, err := gattspec.XML(profile(), gattspec.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(b)
+	for _, want := range []string{
+		`<Service name="Heart Rate" type="org.bluetooth.service.heart_rate" uuid="180D">`,
+		`<Characteristic name="Heart Rate Measurement" type="org.bluetooth.characteristic.heart_rate_measurement" uuid="2A37">`,
+		`<Notify>Mandatory</Notify>`,
+		`<Descriptor name="Client Characteristic Configuration" type="org.bluetooth.descriptor.gatt.client_characteristic_configuration" uuid="2902">`,
+		`<Service uuid="00010000-0001-1000-8000-00805F9B34FB">`,
+		`<ReliableWrite>Mandatory</ReliableWrite>`,
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("no %s in\n%s", want, s)
+		}
+	}
+	if strings.Contains(s, "handle") || strings.Contains(s, "<Value>") {
+		t.Errorf("handles or values rendered by default:\n%s", s)
+	}
+
+	b, err = gattspec.XML(profile(), gattspec.Options{Handles: true, Values: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = string(b)
+	for _, want := range []string{`uuid="180D" handle="0x0010"`, `<Value>0102</Value>`} {
+		if !strings.Contains(s, want) {
+			t.Errorf("no %s in\n%s", want, s)
+		}
+	}
+}
+
+func TestYAML(t *testing.T) {
+	s := string(gattspec.YAML(profile(), gattspec.Options{Handles: true}))
+	want := `services:
+  - uuid: "180D"
+    name: "Heart Rate"
+    type: "org.bluetooth.service.heart_rate"
+    handle: "0x0010"
+    characteristics:
+      - uuid: "2A37"
+        name: "Heart Rate Measurement"
+        type: "org.bluetooth.characteristic.heart_rate_measurement"
+        handle: "0x0011"
+        properties: [notify]
+        descriptors:
+          - uuid: "2902"
+            name: "Client Characteristic Configuration"
+            type: "org.bluetooth.descriptor.gatt.client_characteristic_configuration"
+            handle: "0x0013"
+  - uuid: "00010000-0001-1000-8000-00805F9B34FB"
+    handle: "0x0000"
+    characteristics:
+      - uuid: "00010000-0002-1000-8000-00805F9B34FB"
+        handle: "0x0000"
+        properties: [read, write, reliable_write]
+        descriptors:
+          - uuid: "2900"
+            name: "Characteristic Extended Properties"
+            type: "org.bluetooth.descriptor.gatt.characteristic_extended_properties"
+            handle: "0x0000"
+`
+	if s != want {
+		t.Errorf("rendered\n%s\nwant\n%s", s, want)
+	}
+}
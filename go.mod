@@ -3,7 +3,8 @@ module github.com/leso-kn/ble
 go 1.13
 
 require (
-	github.com/aead/cmac v0.0.0-20160719120800-7af84192f0b1 // indirect
+	github.com/aead/cmac v0.0.0-20160719120800-7af84192f0b1
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/jacobsa/go-serial v0.0.0-20180131005756-15cf729a72d4
 	github.com/json-iterator/go v1.1.9
 	github.com/mattn/go-colorable v0.1.4 // indirect
@@ -45,6 +45,30 @@ func (f NotifyHandlerFunc) ServeNotify(req Request, n Notifier) {
 	f(req, n)
 }
 
+// Subscription is the configuration of a characteristic by a peer, as
+// written to its Client Characteristic Configuration descriptor.
+type Subscription struct {
+	Conn     Conn
+	Notify   bool // The peer receives notifications.
+	Indicate bool // The peer receives indications.
+}
+
+// A SubscriptionHandler handles the changes of the subscriptions of the
+// peers to a characteristic: a peer subscribes by enabling notifications or
+// indications, and unsubscribes by disabling them or disconnecting. It's
+// called by the goroutine serving the peer, and must not block.
+type SubscriptionHandler interface {
+	ServeSubscription(s Subscription)
+}
+
+// SubscriptionHandlerFunc is an adapter to allow the use of ordinary functions as Handlers.
+type SubscriptionHandlerFunc func(s Subscription)
+
+// ServeSubscription returns f(s).
+func (f SubscriptionHandlerFunc) ServeSubscription(s Subscription) {
+	f(s)
+}
+
 // Request ...
 type Request interface {
 	Conn() Conn
@@ -185,3 +209,21 @@ func (n *notifier) Close() error {
 func (n *notifier) Cap() int {
 	return n.maxlen
 }
+
+// NotifyResult reports how a value notified, or indicated, by a GATT server
+// was delivered to the centrals subscribed to the characteristic.
+type NotifyResult struct {
+	// Sent are the connections the value was sent to. Indications are sent
+	// once the central confirmed them.
+	Sent []Conn
+
+	// Failed are the subscribed connections the value couldn't be sent to.
+	Failed []NotifyFailure
+}
+
+// NotifyFailure is a subscribed connection a value couldn't be sent to, e.g.
+// because it was disconnected, or the controller's buffers were full.
+type NotifyFailure struct {
+	Conn Conn
+	Err  error
+}
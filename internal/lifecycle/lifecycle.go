@@ -0,0 +1,43 @@
+// Package lifecycle tracks the goroutines started on behalf of a connection
+// or a device, so closing it can wait for all of them to return.
+package lifecycle
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// running counts the goroutines of all groups which haven't returned yet.
+var running int64
+
+// Group is the set of goroutines owned by a connection or a device. The zero
+// value is ready to use.
+type Group struct {
+	wg sync.WaitGroup
+}
+
+// Go runs f in a goroutine of the group. It must not be called once Wait may
+// have returned, unless from a goroutine of the group.
+func (g *Group) Go(f func()) {
+	g.wg.Add(1)
+	atomic.AddInt64(&running, 1)
+	go func() {
+		defer func() {
+			atomic.AddInt64(&running, -1)
+			g.wg.Done()
+		}()
+		f()
+	}()
+}
+
+// Wait blocks until all the goroutines of the group have returned.
+func (g *Group) Wait() {
+	g.wg.Wait()
+}
+
+// Running returns the number of goroutines of all groups which haven't
+// returned yet. Tests compare it before and after closing what they created
+// to detect leaks.
+func Running() int {
+	return int(atomic.LoadInt64(&running))
+}
@@ -0,0 +1,24 @@
+package lifecycle
+
+import "testing"
+
+func TestGroup(t *testing.T) {
+	n := Running()
+	var g Group
+	release := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		g.Go(func() {
+			<-release
+			// Started from the group, while it's still running.
+			g.Go(func() {})
+		})
+	}
+	if r := Running(); r != n+3 {
+		t.Fatalf("running = %d, want %d", r, n+3)
+	}
+	close(release)
+	g.Wait()
+	if r := Running(); r != n {
+		t.Fatalf("running = %d after Wait, want %d", r, n)
+	}
+}
@@ -0,0 +1,72 @@
+// Package virtualtest sets up a peripheral and a central device over
+// virtual controllers, for the tests of the packages built on linux.Device.
+package virtualtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/hci/virtual"
+)
+
+// The addresses of the controllers of a Pair.
+const (
+	PeripheralAddr = "11:22:33:44:55:66"
+	CentralAddr    = "AA:BB:CC:DD:EE:FF"
+)
+
+// Pair is a peripheral and a central device on the controllers of an air.
+type Pair struct {
+	Air *virtual.Air
+
+	PeripheralController *virtual.Controller
+	CentralController    *virtual.Controller
+
+	Peripheral *linux.Device
+	Central    *linux.Device
+}
+
+// NewPair returns a Pair, whose devices have the options popts and copts.
+// It fails t if either can't be created.
+func NewPair(t testing.TB, popts, copts []ble.Option) *Pair {
+	t.Helper()
+	p := &Pair{Air: virtual.NewAir()}
+	var err error
+	if p.PeripheralController, err = p.Air.NewController(PeripheralAddr); err != nil {
+		t.Fatal(err)
+	}
+	if p.CentralController, err = p.Air.NewController(CentralAddr); err != nil {
+		t.Fatal(err)
+	}
+	popts = append([]ble.Option{ble.OptTransportVirtual(p.PeripheralController)}, popts...)
+	if p.Peripheral, err = linux.NewDevice(popts...); err != nil {
+		t.Fatal(err)
+	}
+	copts = append([]ble.Option{ble.OptTransportVirtual(p.CentralController)}, copts...)
+	if p.Central, err = linux.NewDevice(copts...); err != nil {
+		p.Peripheral.Stop()
+		t.Fatal(err)
+	}
+	return p
+}
+
+// Stop stops both devices.
+func (p *Pair) Stop() {
+	p.Central.Stop()
+	p.Peripheral.Stop()
+}
+
+// Connect advertises the peripheral as "Gopher" until ctx is done, and
+// returns the client of the central connected to it. It fails t if the
+// central can't connect.
+func (p *Pair) Connect(ctx context.Context, t testing.TB) ble.Client {
+	t.Helper()
+	go p.Peripheral.AdvertiseNameAndServices(ctx, "Gopher")
+	cln, err := p.Central.Dial(ctx, ble.NewAddr(PeripheralAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cln
+}
@@ -0,0 +1,66 @@
+package ble
+
+import "io"
+
+// An L2CAPChannel is an LE credit based connection-oriented channel [Vol 3,
+// Part A, 10.1]. Each Write sends one SDU, and each Read returns one; except
+// on darwin, where CoreBluetooth hands the channel over as a byte stream.
+type L2CAPChannel interface {
+	io.ReadWriteCloser
+
+	// PSM returns the protocol/service multiplexer of the channel.
+	PSM() uint16
+
+	// MTU returns the largest SDU the remote device accepts.
+	MTU() int
+
+	// Conn returns the connection the channel is on.
+	Conn() Conn
+}
+
+// L2CAPDialer is implemented by clients which open L2CAP channels to the
+// remote device.
+type L2CAPDialer interface {
+	// OpenL2CAPChannel opens a channel to the PSM, which the remote device
+	// published, e.g. in a characteristic.
+	OpenL2CAPChannel(psm uint16) (L2CAPChannel, error)
+}
+
+// An L2CAPListener accepts the channels remote devices open to a PSM.
+type L2CAPListener interface {
+	// Accept waits for and returns the next channel.
+	Accept() (L2CAPChannel, error)
+
+	// PSM returns the PSM the listener is published on.
+	PSM() uint16
+
+	// Close unpublishes the PSM. Accepted channels aren't closed.
+	Close() error
+}
+
+// L2CAPPublisher is implemented by devices which accept L2CAP channels.
+type L2CAPPublisher interface {
+	// ListenL2CAP publishes a PSM, or a dynamically allocated one if psm is
+	// zero. With encrypted, channels need an encrypted link.
+	ListenL2CAP(psm uint16, encrypted bool) (L2CAPListener, error)
+}
+
+// OpenL2CAPChannel opens an L2CAP channel to the PSM of the remote device of
+// cln, if its backend supports L2CAP channels.
+func OpenL2CAPChannel(cln Client, psm uint16) (L2CAPChannel, error) {
+	d, ok := cln.(L2CAPDialer)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	return d.OpenL2CAPChannel(psm)
+}
+
+// ListenL2CAP publishes a PSM on the device, if its backend supports L2CAP
+// channels.
+func ListenL2CAP(d Device, psm uint16, encrypted bool) (L2CAPListener, error) {
+	p, ok := d.(L2CAPPublisher)
+	if !ok {
+		return nil, ErrNotImplemented
+	}
+	return p.ListenL2CAP(psm, encrypted)
+}
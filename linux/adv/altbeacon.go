@@ -0,0 +1,60 @@
+package adv
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// AltBeaconCode is the beacon code identifying AltBeacon advertisements.
+const AltBeaconCode = 0xBEAC
+
+// AltBeaconData is the content of an AltBeacon advertisement.
+type AltBeaconData struct {
+	MfgID    uint16   // Company identifier of the beacon manufacturer.
+	ID1      [16]byte // Typically an organizational unit, like a UUID.
+	ID2      uint16
+	ID3      uint16
+	RefRSSI  int8 // Average RSSI at 1 m.
+	Reserved byte // Reserved for use by the manufacturer.
+}
+
+// AltBeacon is an AltBeacon advertisement with the specified parameters.
+func AltBeacon(d AltBeaconData) Field {
+	return func(p *Packet) error {
+		md := make([]byte, 24)
+		binary.BigEndian.PutUint16(md, AltBeaconCode)
+		copy(md[2:], d.ID1[:])
+		binary.BigEndian.PutUint16(md[18:], d.ID2)
+		binary.BigEndian.PutUint16(md[20:], d.ID3)
+		md[22] = uint8(d.RefRSSI)
+		md[23] = d.Reserved
+		return ManufacturerData(d.MfgID, md)(p)
+	}
+}
+
+// DecodeAltBeacon decodes an AltBeacon advertisement from manufacturer data,
+// including the company identifier.
+func DecodeAltBeacon(md []byte) (*AltBeaconData, error) {
+	if len(md) < 26 || binary.BigEndian.Uint16(md[2:]) != AltBeaconCode {
+		return nil, fmt.Errorf("altbeacon: %w", ErrInvalid)
+	}
+	d := &AltBeaconData{
+		MfgID:    binary.LittleEndian.Uint16(md),
+		ID2:      binary.BigEndian.Uint16(md[20:]),
+		ID3:      binary.BigEndian.Uint16(md[22:]),
+		RefRSSI:  int8(md[24]),
+		Reserved: md[25],
+	}
+	copy(d.ID1[:], md[4:20])
+	return d, nil
+}
+
+// AltBeacon returns the AltBeacon content of the packet, or nil if it isn't
+// an AltBeacon advertisement.
+func (p *Packet) AltBeacon() *AltBeaconData {
+	d, err := DecodeAltBeacon(p.ManufacturerData())
+	if err != nil {
+		return nil
+	}
+	return d
+}
@@ -0,0 +1,34 @@
+package adv
+
+import "testing"
+
+func TestAltBeacon(t *testing.T) {
+	d := AltBeaconData{
+		MfgID:    0x0118,
+		ID1:      [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		ID2:      0x1234,
+		ID3:      0x5678,
+		RefRSSI:  -59,
+		Reserved: 0x42,
+	}
+	p, err := NewPacket(Flags(FlagGeneralDiscoverable|FlagLEOnly), AltBeacon(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Len() != MaxEIRPacketLength {
+		t.Fatalf("have length %v, want %v", p.Len(), MaxEIRPacketLength)
+	}
+	p, err = NewRawPacket(p.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := p.AltBeacon()
+	if a == nil || *a != d {
+		t.Fatalf("have %v, want %v", a, d)
+	}
+
+	p, _ = NewRawPacket([]byte{0x05, manufacturerData, 0x18, 0x01, 0xBE, 0xAD})
+	if a := p.AltBeacon(); a != nil {
+		t.Fatalf("have %v, want nil", a)
+	}
+}
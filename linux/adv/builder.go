@@ -0,0 +1,317 @@
+package adv
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/leso-kn/ble"
+)
+
+// Builder composes an advertising packet and its scan response field by
+// field. The flags, service data, manufacturer data, tx power and raw fields
+// are placed in the advertising packet, in the order they were added. The
+// service UUIDs and the local name are placed in the advertising packet as
+// long as they fit, and spill into the scan response otherwise.
+//
+// Fields added after ScanResponse are placed in the scan response instead,
+// which allows deliberately moving large fields, such as a long name or a
+// serial number, out of the advertising packet.
+//
+// The methods return the builder for chaining. An invalid field is reported
+// by Build. Fields may be bound to variables, see Var, to build the packets
+// again with their new values.
+//
+//	ad, sr, err := adv.NewBuilder().
+//		Flags(adv.FlagGeneralDiscoverable | adv.FlagLEOnly).
+//		Services(ble.UUID16(0x180D)).
+//		Name("Heart Rate").
+//		Build()
+type Builder struct {
+	fields  []builderField
+	uuids   []builderUUID
+	name    string
+	hasName bool
+	srName  bool
+	sr      bool
+	err     error
+}
+
+type builderField struct {
+	typ  byte
+	data []byte
+	sr   bool
+	vars []*Var
+}
+
+type builderUUID struct {
+	u  ble.UUID
+	sr bool
+}
+
+// NewBuilder returns an empty advertisement builder.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// ScanResponse places the fields added next in the scan response.
+func (b *Builder) ScanResponse() *Builder {
+	b.sr = true
+	return b
+}
+
+// Advertising places the fields added next in the advertising packet, with
+// the name and service UUIDs spilling into the scan response as needed. This
+// is the initial placement.
+func (b *Builder) Advertising() *Builder {
+	b.sr = false
+	return b
+}
+
+// Flags adds the flags field.
+func (b *Builder) Flags(f byte) *Builder {
+	return b.Field(flags, []byte{f})
+}
+
+// Name sets the local name. It's advertised as complete name if it fits,
+// and shortened otherwise.
+func (b *Builder) Name(n string) *Builder {
+	b.name, b.hasName, b.srName = n, true, b.sr
+	return b
+}
+
+// Services adds service UUIDs. The UUIDs of each size are listed in a single
+// field, which is split between the advertising packet and the scan response
+// if needed.
+func (b *Builder) Services(uuids ...ble.UUID) *Builder {
+	for _, u := range uuids {
+		if l := u.Len(); l != 2 && l != 4 && l != 16 {
+			b.setErr(fmt.Errorf("service uuid %v: %w", u, ErrInvalid))
+			continue
+		}
+		b.uuids = append(b.uuids, builderUUID{u, b.sr})
+	}
+	return b
+}
+
+// ServiceData adds service data for a 16, 32, or 128-bit service UUID.
+func (b *Builder) ServiceData(u ble.UUID, data []byte) *Builder {
+	var typ byte
+	switch u.Len() {
+	case 2:
+		typ = serviceData16
+	case 4:
+		typ = serviceData32
+	case 16:
+		typ = serviceData128
+	default:
+		b.setErr(fmt.Errorf("service data uuid %v: %w", u, ErrInvalid))
+		return b
+	}
+	return b.Field(typ, append(append([]byte{}, u...), data...))
+}
+
+// ManufacturerData adds manufacturer specific data.
+func (b *Builder) ManufacturerData(id uint16, data []byte) *Builder {
+	return b.Field(manufacturerData, append([]byte{uint8(id), uint8(id >> 8)}, data...))
+}
+
+// TxPower adds the transmitted power level in dBm.
+func (b *Builder) TxPower(pwr int8) *Builder {
+	return b.Field(txPower, []byte{uint8(pwr)})
+}
+
+// URI adds a URI. Its scheme is encoded compactly if it's a common one.
+func (b *Builder) URI(u string) *Builder {
+	for c, s := range uriSchemes {
+		if s != "" && strings.HasPrefix(u, s) {
+			return b.Field(uri, append([]byte{c}, u[len(s):]...))
+		}
+	}
+	return b.Field(uri, append([]byte{0x01}, u...))
+}
+
+// Appearance adds the external appearance of the device.
+func (b *Builder) Appearance(a uint16) *Builder {
+	return b.Field(appearance, []byte{uint8(a), uint8(a >> 8)})
+}
+
+// AdvInterval adds the advertising interval, in units of 0.625 ms.
+func (b *Builder) AdvInterval(d time.Duration) *Builder {
+	n := d / (625 * time.Microsecond)
+	if n < 1 || n > 0xFFFF {
+		b.setErr(fmt.Errorf("advertising interval %v: %w", d, ErrInvalid))
+		return b
+	}
+	return b.Field(advInterval, []byte{uint8(n), uint8(n >> 8)})
+}
+
+// LEFeatures adds the LE Supported Features, as the feature bit mask.
+func (b *Builder) LEFeatures(f []byte) *Builder {
+	return b.Field(leFeatures, f)
+}
+
+// PublicTargetAddrs adds the public addresses of the intended recipients.
+func (b *Builder) PublicTargetAddrs(addrs ...ble.Addr) *Builder {
+	return b.targetAddrs(pubTargetAddr, addrs)
+}
+
+// RandomTargetAddrs adds the random addresses of the intended recipients.
+func (b *Builder) RandomTargetAddrs(addrs ...ble.Addr) *Builder {
+	return b.targetAddrs(randTargetAddr, addrs)
+}
+
+func (b *Builder) targetAddrs(typ byte, addrs []ble.Addr) *Builder {
+	var d []byte
+	for _, a := range addrs {
+		m, err := net.ParseMAC(a.String())
+		if err != nil || len(m) != 6 {
+			b.setErr(fmt.Errorf("target address %v: %w", a, ErrInvalid))
+			return b
+		}
+		d = append(d, m[5], m[4], m[3], m[2], m[1], m[0])
+	}
+	return b.Field(typ, d)
+}
+
+// PBADV adds a PB-ADV field, carrying a mesh provisioning PDU.
+func (b *Builder) PBADV(pdu []byte) *Builder {
+	return b.Field(pbADV, pdu)
+}
+
+// MeshMessage adds a Mesh Message field, carrying a mesh network PDU.
+func (b *Builder) MeshMessage(pdu []byte) *Builder {
+	return b.Field(meshMessage, pdu)
+}
+
+// MeshBeacon adds a Mesh Beacon field.
+func (b *Builder) MeshBeacon(beacon []byte) *Builder {
+	return b.Field(meshBeacon, beacon)
+}
+
+// AutoTxPower adds the transmitted power level, filled with the advertising
+// transmit power of the controller when advertised.
+func (b *Builder) AutoTxPower() *Builder {
+	return b.TxPower(TxPowerAuto)
+}
+
+// Field adds a field of any AD type, such as a proprietary one.
+func (b *Builder) Field(typ byte, data []byte) *Builder {
+	b.fields = append(b.fields, builderField{typ: typ, data: append([]byte{}, data...), sr: b.sr})
+	return b
+}
+
+func (b *Builder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Build lays out the fields, and returns the advertising packet and the scan
+// response. It returns an error wrapping ErrNotFit if the fields don't fit
+// in the legacy advertising limit of 31 bytes per packet.
+func (b *Builder) Build() (ad *Packet, sr *Packet, err error) {
+	if b.err != nil {
+		return nil, nil, b.err
+	}
+	ad, _ = NewPacket()
+	sr, _ = NewPacket()
+
+	for _, f := range b.fields {
+		p := ad
+		if f.sr {
+			p = sr
+		}
+		if err := p.append(f.typ, f.value()); err != nil {
+			return nil, nil, fmt.Errorf("ad type 0x%02X: %w", f.typ, err)
+		}
+	}
+
+	for _, g := range []struct {
+		size     int
+		all, som byte
+	}{
+		{2, allUUID16, someUUID16},
+		{4, allUUID32, someUUID32},
+		{16, allUUID128, someUUID128},
+	} {
+		var uuids, srUUIDs []ble.UUID
+		for _, u := range b.uuids {
+			switch {
+			case u.u.Len() != g.size:
+			case u.sr:
+				srUUIDs = append(srUUIDs, u.u)
+			default:
+				uuids = append(uuids, u.u)
+			}
+		}
+		if err := placeUUIDs(ad, sr, uuids, g.size, g.all, g.som); err != nil {
+			return nil, nil, err
+		}
+		if err := placeUUIDs(nil, sr, srUUIDs, g.size, g.all, g.som); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if b.hasName {
+		p := ad
+		if b.srName {
+			p = nil
+		}
+		if err := placeName(p, sr, b.name); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ad, sr, nil
+}
+
+// free returns the number of bytes left in p for the data of a new field.
+func (p *Packet) free() int {
+	return MaxEIRPacketLength - p.Len() - 2
+}
+
+// placeUUIDs places a list of same sized UUIDs as a complete list in ad, or
+// else in sr. If it fits in neither, it's split as incomplete lists over
+// both. A nil ad places the list in sr only.
+func placeUUIDs(ad, sr *Packet, uuids []ble.UUID, size int, all, some byte) error {
+	if len(uuids) == 0 {
+		return nil
+	}
+	var b []byte
+	for _, u := range uuids {
+		b = append(b, u...)
+	}
+	if (ad != nil && ad.append(all, b) == nil) || sr.append(all, b) == nil {
+		return nil
+	}
+
+	n := 0
+	if ad != nil && ad.free() > 0 {
+		n = ad.free() / size * size
+	}
+	if n > 0 {
+		ad.append(some, b[:n])
+	}
+	if err := sr.append(some, b[n:]); err != nil {
+		return fmt.Errorf("%v %d-bit service uuids: %w", len(uuids), size*8, err)
+	}
+	return nil
+}
+
+// placeName places the name as complete name in ad, or else in sr. If it
+// fits in neither, it's shortened to the room left in sr, or else in ad. A
+// nil ad places the name in sr only.
+func placeName(ad, sr *Packet, name string) error {
+	if (ad != nil && ad.append(completeName, []byte(name)) == nil) || sr.append(completeName, []byte(name)) == nil {
+		return nil
+	}
+	p := sr
+	if sr.free() < 1 && ad != nil {
+		p = ad
+	}
+	if p.free() < 1 {
+		return fmt.Errorf("local name: %w", ErrNotFit)
+	}
+	return p.append(shortName, []byte(name)[:p.free()])
+}
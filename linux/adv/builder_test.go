@@ -0,0 +1,176 @@
+package adv
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+)
+
+func TestBuilderFits(t *testing.T) {
+	ad, sr, err := NewBuilder().
+		Flags(FlagGeneralDiscoverable|FlagLEOnly).
+		Services(ble.UUID16(0x180D), ble.UUID16(0x180F)).
+		Name("hr").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []byte{
+		0x02, flags, 0x06,
+		0x05, allUUID16, 0x0D, 0x18, 0x0F, 0x18,
+		0x03, completeName, 'h', 'r',
+	}
+	if !bytes.Equal(ad.Bytes(), exp) {
+		t.Fatalf("ad: have % X, want % X", ad.Bytes(), exp)
+	}
+	if sr.Len() != 0 {
+		t.Fatalf("sr: have % X, want none", sr.Bytes())
+	}
+}
+
+func TestBuilderSpill(t *testing.T) {
+	u1 := ble.MustParse("00010203-0405-0607-0809-0a0b0c0d0e0f")
+	u2 := ble.MustParse("10111213-1415-1617-1819-1a1b1c1d1e1f")
+	name := strings.Repeat("n", 40)
+
+	ad, sr, err := NewBuilder().
+		Flags(FlagGeneralDiscoverable).
+		Services(u1, u2).
+		Name(name).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two 128-bit UUIDs don't fit either packet, so they're split.
+	if ad.Len() != 3+18 {
+		t.Fatalf("ad: have % X", ad.Bytes())
+	}
+	if ad.Bytes()[4] != someUUID128 || sr.Bytes()[1] != someUUID128 {
+		t.Fatalf("uuids not split as incomplete lists: ad % X, sr % X", ad.Bytes(), sr.Bytes())
+	}
+	// The name is shortened into what's left of the scan response.
+	if sr.Len() != MaxEIRPacketLength || sr.Bytes()[19] != shortName {
+		t.Fatalf("sr: have % X", sr.Bytes())
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	if _, _, err := NewBuilder().Services(ble.UUID{1, 2, 3}).Build(); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("have %v, want %v", err, ErrInvalid)
+	}
+	if _, _, err := NewBuilder().ManufacturerData(0xFFFF, make([]byte, 28)).Build(); !errors.Is(err, ErrNotFit) {
+		t.Fatalf("have %v, want %v", err, ErrNotFit)
+	}
+}
+
+func TestBuilderScanResponse(t *testing.T) {
+	ad, sr, err := NewBuilder().
+		Flags(FlagGeneralDiscoverable).
+		ScanResponse().
+		Name("hr").
+		ManufacturerData(0x0059, []byte("serial")).
+		Advertising().
+		Services(ble.UUID16(0x180D)).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []byte{
+		0x02, flags, 0x02,
+		0x03, allUUID16, 0x0D, 0x18,
+	}
+	if !bytes.Equal(ad.Bytes(), exp) {
+		t.Fatalf("ad: have % X, want % X", ad.Bytes(), exp)
+	}
+	exp = []byte{
+		0x09, manufacturerData, 0x59, 0x00, 's', 'e', 'r', 'i', 'a', 'l',
+		0x03, completeName, 'h', 'r',
+	}
+	if !bytes.Equal(sr.Bytes(), exp) {
+		t.Fatalf("sr: have % X, want % X", sr.Bytes(), exp)
+	}
+
+	// Fields placed in the scan response don't spill back.
+	u := ble.MustParse("00010203-0405-0607-0809-0a0b0c0d0e0f")
+	_, _, err = NewBuilder().ScanResponse().Services(u, u).Build()
+	if !errors.Is(err, ErrNotFit) {
+		t.Fatalf("have %v, want %v", err, ErrNotFit)
+	}
+}
+
+func TestBuilderFieldTypes(t *testing.T) {
+	ad, _, err := NewBuilder().
+		URI("https://a.io").
+		Appearance(0x0340).
+		AdvInterval(100 * time.Millisecond).
+		LEFeatures([]byte{0x01}).
+		PublicTargetAddrs(ble.NewAddr("01:02:03:04:05:06")).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewRawPacket(ad.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := p.URI(); u != "https://a.io" {
+		t.Fatalf("uri: have %q", u)
+	}
+	if a, ok := p.Appearance(); !ok || a != 0x0340 {
+		t.Fatalf("appearance: have 0x%04X, %v", a, ok)
+	}
+	if d, ok := p.AdvInterval(); !ok || d != 100*time.Millisecond {
+		t.Fatalf("advertising interval: have %v, %v", d, ok)
+	}
+	if f := p.LEFeatures(); !bytes.Equal(f, []byte{0x01}) {
+		t.Fatalf("le features: have % X", f)
+	}
+	addrs := p.PublicTargetAddrs()
+	if len(addrs) != 1 || addrs[0].String() != "01:02:03:04:05:06" {
+		t.Fatalf("target addresses: have %v", addrs)
+	}
+
+	if _, _, err := NewBuilder().AdvInterval(time.Minute).Build(); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("have %v, want %v", err, ErrInvalid)
+	}
+}
+
+func TestBuilderVars(t *testing.T) {
+	level := NewVar([]byte{100})
+	var n uint8
+	counter := VarFunc(func() []byte { n++; return []byte{n} })
+	b := NewBuilder().
+		ServiceDataVar(ble.UUID16(0x180F), level).
+		ManufacturerDataVar(0xFFFF, counter, level)
+
+	build := func() []byte {
+		ad, _, err := b.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ad.Bytes()
+	}
+	exp := []byte{
+		0x04, serviceData16, 0x0F, 0x18, 100,
+		0x05, manufacturerData, 0xFF, 0xFF, 1, 100,
+	}
+	if ad := build(); !bytes.Equal(ad, exp) {
+		t.Fatalf("ad: have % X, want % X", ad, exp)
+	}
+	level.SetUint8(97)
+	exp[4], exp[9], exp[10] = 97, 2, 97
+	if ad := build(); !bytes.Equal(ad, exp) {
+		t.Fatalf("ad: have % X, want % X", ad, exp)
+	}
+
+	level.Set(make([]byte, 30))
+	if _, _, err := b.Build(); !errors.Is(err, ErrNotFit) {
+		t.Fatalf("have %v, want %v", err, ErrNotFit)
+	}
+}
@@ -21,6 +21,19 @@ const (
 	FlagBothHost            = 0x10 // Simultaneous LE and BR/EDR to Same Device Capable (Host).
 )
 
+// TxPowerAuto is a placeholder TX power level. A TX Power Level field with
+// this value is filled with the advertising transmit power of the controller
+// when advertised. It's outside of the valid range of -127 to +20 dBm.
+const TxPowerAuto int8 = 127
+
+// uriSchemes maps the code points encoding the common URI schemes in the
+// URI field. [Assigned Numbers, URI Scheme Name String Mapping]
+var uriSchemes = map[byte]string{
+	0x01: "",
+	0x16: "http:",
+	0x17: "https:",
+}
+
 // Advertising data field s
 const (
 	flags             = 0x01 // Flags
@@ -53,5 +66,10 @@ const (
 	serviceData128    = 0x21 // Service Data - 128-bit UUID
 	leSecConfirm      = 0x22 // LE Secure Connections Confirmation Value
 	leSecRandom       = 0x23 // LE Secure Connections Random Value
+	uri               = 0x24 // URI
+	leFeatures        = 0x27 // LE Supported Features
+	pbADV             = 0x29 // PB-ADV
+	meshMessage       = 0x2A // Mesh Message
+	meshBeacon        = 0x2B // Mesh Beacon
 	manufacturerData  = 0xFF // Manufacturer Specific Data
 )
@@ -0,0 +1,152 @@
+package adv
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Apple Continuity message types.
+const (
+	ContinuityTypeIBeacon          = 0x02
+	ContinuityTypeAirDrop          = 0x05
+	ContinuityTypeProximityPairing = 0x07 // AirPods and Beats status.
+	ContinuityTypeHeySiri          = 0x08
+	ContinuityTypeAirPlayTarget    = 0x09
+	ContinuityTypeAirPlaySource    = 0x0A
+	ContinuityTypeMagicSwitch      = 0x0B
+	ContinuityTypeHandoff          = 0x0C
+	ContinuityTypeTetheringTarget  = 0x0D
+	ContinuityTypeTetheringSource  = 0x0E
+	ContinuityTypeNearbyAction     = 0x0F
+	ContinuityTypeNearbyInfo       = 0x10
+	ContinuityTypeFindMy           = 0x12
+)
+
+// ContinuityMessage is one of the messages of an Apple Continuity
+// advertisement.
+type ContinuityMessage struct {
+	Type byte
+	Data []byte
+}
+
+// DecodeContinuity splits the Apple Continuity messages of manufacturer
+// data, including the company identifier. A message overflowing the data is
+// dropped, and reported along with the preceding ones.
+func DecodeContinuity(md []byte) ([]ContinuityMessage, error) {
+	if len(md) < 2 || binary.LittleEndian.Uint16(md) != 0x004C {
+		return nil, fmt.Errorf("continuity: %w", ErrInvalid)
+	}
+	var msgs []ContinuityMessage
+	for b := md[2:]; len(b) > 0; {
+		if len(b) < 2 || int(b[1]) > len(b)-2 {
+			return msgs, fmt.Errorf("continuity: message overflow: %w", ErrInvalid)
+		}
+		msgs = append(msgs, ContinuityMessage{Type: b[0], Data: b[2 : 2+b[1]]})
+		b = b[2+b[1]:]
+	}
+	return msgs, nil
+}
+
+// AppleContinuity returns the Apple Continuity messages of the packet, or nil
+// if it has none.
+func (p *Packet) AppleContinuity() []ContinuityMessage {
+	msgs, _ := DecodeContinuity(p.ManufacturerData())
+	return msgs
+}
+
+// ProximityPairing is the status of AirPods or Beats headphones.
+type ProximityPairing struct {
+	Model  uint16 // e.g. 0x0220 for AirPods, 0x0E20 for AirPods Pro.
+	Status byte
+
+	// Battery levels in percent, in steps of 10, or -1 if unknown.
+	LeftBattery  int
+	RightBattery int
+	CaseBattery  int
+
+	LeftCharging  bool
+	RightCharging bool
+	CaseCharging  bool
+
+	LidOpenCount byte
+	Color        byte
+}
+
+// ProximityPairing decodes a Proximity Pairing message.
+func (m ContinuityMessage) ProximityPairing() (*ProximityPairing, error) {
+	b := m.Data
+	if m.Type != ContinuityTypeProximityPairing || len(b) < 8 {
+		return nil, fmt.Errorf("proximity pairing: %w", ErrInvalid)
+	}
+	p := &ProximityPairing{
+		Model:        binary.BigEndian.Uint16(b[1:]),
+		Status:       b[3],
+		CaseBattery:  batteryLevel(b[5] & 0x0F),
+		CaseCharging: b[5]&0x40 != 0,
+		LidOpenCount: b[6],
+		Color:        b[7],
+	}
+
+	// The status tells which pod is the primary, and reported first.
+	l, r := b[4]&0x0F, b[4]>>4
+	lc, rc := b[5]&0x10 != 0, b[5]&0x20 != 0
+	if p.Status&0x20 == 0 {
+		l, r = r, l
+		lc, rc = rc, lc
+	}
+	p.LeftBattery, p.RightBattery = batteryLevel(l), batteryLevel(r)
+	p.LeftCharging, p.RightCharging = lc, rc
+	return p, nil
+}
+
+func batteryLevel(n byte) int {
+	if n > 10 {
+		return -1
+	}
+	return int(n) * 10
+}
+
+// NearbyInfo is the status of a nearby Apple device.
+type NearbyInfo struct {
+	StatusFlags byte // Upper nibble of the first byte.
+	ActionCode  byte // e.g. 0x0B for active user, 0x03 for locked screen.
+	DataFlags   byte
+	AuthTag     []byte
+}
+
+// NearbyInfo decodes a Nearby Info message.
+func (m ContinuityMessage) NearbyInfo() (*NearbyInfo, error) {
+	b := m.Data
+	if m.Type != ContinuityTypeNearbyInfo || len(b) < 2 {
+		return nil, fmt.Errorf("nearby info: %w", ErrInvalid)
+	}
+	return &NearbyInfo{
+		StatusFlags: b[0] >> 4,
+		ActionCode:  b[0] & 0x0F,
+		DataFlags:   b[1],
+		AuthTag:     b[2:],
+	}, nil
+}
+
+// Handoff is a Handoff message, advertising an activity to continue on
+// another device. The payload is encrypted.
+type Handoff struct {
+	ClipboardStatus byte
+	Sequence        uint16
+	AuthTag         byte
+	Payload         []byte
+}
+
+// Handoff decodes a Handoff message.
+func (m ContinuityMessage) Handoff() (*Handoff, error) {
+	b := m.Data
+	if m.Type != ContinuityTypeHandoff || len(b) < 4 {
+		return nil, fmt.Errorf("handoff: %w", ErrInvalid)
+	}
+	return &Handoff{
+		ClipboardStatus: b[0],
+		Sequence:        binary.LittleEndian.Uint16(b[1:]),
+		AuthTag:         b[3],
+		Payload:         b[4:],
+	}, nil
+}
@@ -0,0 +1,225 @@
+package adv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/leso-kn/ble"
+)
+
+// EddystoneUUID is the 16bit service uuid of Eddystone frames.
+const EddystoneUUID = 0xFEAA
+
+// Eddystone frame types.
+const (
+	EddystoneTypeUID = 0x00
+	EddystoneTypeURL = 0x10
+	EddystoneTypeTLM = 0x20
+	EddystoneTypeEID = 0x30
+)
+
+// EddystoneFrame is an Eddystone frame: EddystoneUID, EddystoneURL,
+// EddystoneTLM, or EddystoneEID.
+type EddystoneFrame interface {
+	// Bytes returns the encoded frame, i.e. the service data of the
+	// Eddystone service.
+	Bytes() ([]byte, error)
+}
+
+// EddystoneUID is an Eddystone-UID frame, identifying the beacon.
+type EddystoneUID struct {
+	TxPower   int8 // Calibrated tx power at 0 m.
+	Namespace [10]byte
+	Instance  [6]byte
+}
+
+// EddystoneURL is an Eddystone-URL frame, broadcasting a URL.
+type EddystoneURL struct {
+	TxPower int8 // Calibrated tx power at 0 m.
+	URL     string
+}
+
+// EddystoneTLM is an unencrypted Eddystone-TLM frame, broadcasting telemetry.
+type EddystoneTLM struct {
+	BatteryVoltage uint16  // In mV, 0 if not supported.
+	Temperature    float64 // In degrees Celsius, -128 if not supported.
+	AdvCount       uint32  // Frames advertised since power-up.
+	Uptime         time.Duration
+}
+
+// EddystoneEID is an Eddystone-EID frame, broadcasting an ephemeral identifier.
+type EddystoneEID struct {
+	TxPower int8 // Calibrated tx power at 0 m.
+	EID     [8]byte
+}
+
+// Eddystone is an Eddystone frame, along with the Eddystone service uuid.
+func Eddystone(f EddystoneFrame) Field {
+	return func(p *Packet) error {
+		b, err := f.Bytes()
+		if err != nil {
+			return err
+		}
+		return ServiceData16(EddystoneUUID, b)(p)
+	}
+}
+
+// Bytes returns the encoded frame.
+func (f EddystoneUID) Bytes() ([]byte, error) {
+	b := []byte{EddystoneTypeUID, uint8(f.TxPower)}
+	b = append(b, f.Namespace[:]...)
+	b = append(b, f.Instance[:]...)
+	return append(b, 0, 0), nil // RFU
+}
+
+// Bytes returns the encoded frame. It returns ErrInvalid if the URL can't be
+// encoded, and ErrNotFit if it's too long once encoded.
+func (f EddystoneURL) Bytes() ([]byte, error) {
+	b := []byte{EddystoneTypeURL, uint8(f.TxPower)}
+	u := f.URL
+	scheme := -1
+	for i, s := range eddystoneSchemes {
+		if strings.HasPrefix(u, s) && (scheme < 0 || len(s) > len(eddystoneSchemes[scheme])) {
+			scheme = i
+		}
+	}
+	if scheme < 0 {
+		return nil, ErrInvalid
+	}
+	b = append(b, byte(scheme))
+	u = u[len(eddystoneSchemes[scheme]):]
+
+	for len(u) > 0 {
+		code := -1
+		for i, s := range eddystoneExpansions {
+			if strings.HasPrefix(u, s) && (code < 0 || len(s) > len(eddystoneExpansions[code])) {
+				code = i
+			}
+		}
+		if code >= 0 {
+			b = append(b, byte(code))
+			u = u[len(eddystoneExpansions[code]):]
+			continue
+		}
+		if u[0] <= 0x20 || u[0] >= 0x7F {
+			return nil, ErrInvalid
+		}
+		b = append(b, u[0])
+		u = u[1:]
+	}
+	if len(b) > 3+17 {
+		return nil, ErrNotFit
+	}
+	return b, nil
+}
+
+// Bytes returns the encoded frame.
+func (f EddystoneTLM) Bytes() ([]byte, error) {
+	b := make([]byte, 14)
+	b[0] = EddystoneTypeTLM
+	b[1] = 0x00 // Unencrypted
+	binary.BigEndian.PutUint16(b[2:], f.BatteryVoltage)
+	binary.BigEndian.PutUint16(b[4:], uint16(int16(f.Temperature*256)))
+	binary.BigEndian.PutUint32(b[6:], f.AdvCount)
+	binary.BigEndian.PutUint32(b[10:], uint32(f.Uptime/(100*time.Millisecond)))
+	return b, nil
+}
+
+// Bytes returns the encoded frame.
+func (f EddystoneEID) Bytes() ([]byte, error) {
+	return append([]byte{EddystoneTypeEID, uint8(f.TxPower)}, f.EID[:]...), nil
+}
+
+// [Eddystone-URL, URL Scheme Prefix]
+var eddystoneSchemes = []string{
+	"http://www.",
+	"https://www.",
+	"http://",
+	"https://",
+}
+
+// [Eddystone-URL, HTTP URL encoding]
+var eddystoneExpansions = []string{
+	".com/",
+	".org/",
+	".edu/",
+	".net/",
+	".info/",
+	".biz/",
+	".gov/",
+	".com",
+	".org",
+	".edu",
+	".net",
+	".info",
+	".biz",
+	".gov",
+}
+
+// DecodeEddystone decodes an Eddystone frame from the service data of the
+// Eddystone service. Encrypted TLM frames aren't supported.
+func DecodeEddystone(b []byte) (EddystoneFrame, error) {
+	if len(b) < 1 {
+		return nil, ErrInvalid
+	}
+	switch b[0] {
+	case EddystoneTypeUID:
+		// The RFU bytes are optional in practice.
+		if len(b) < 18 {
+			return nil, fmt.Errorf("eddystone uid: length %v: %w", len(b), ErrInvalid)
+		}
+		f := EddystoneUID{TxPower: int8(b[1])}
+		copy(f.Namespace[:], b[2:12])
+		copy(f.Instance[:], b[12:18])
+		return f, nil
+
+	case EddystoneTypeURL:
+		if len(b) < 3 || int(b[2]) >= len(eddystoneSchemes) {
+			return nil, fmt.Errorf("eddystone url: %w", ErrInvalid)
+		}
+		u := eddystoneSchemes[b[2]]
+		for _, c := range b[3:] {
+			if int(c) < len(eddystoneExpansions) {
+				u += eddystoneExpansions[c]
+			} else {
+				u += string(rune(c))
+			}
+		}
+		return EddystoneURL{TxPower: int8(b[1]), URL: u}, nil
+
+	case EddystoneTypeTLM:
+		if len(b) < 14 || b[1] != 0x00 {
+			return nil, fmt.Errorf("eddystone tlm: %w", ErrInvalid)
+		}
+		return EddystoneTLM{
+			BatteryVoltage: binary.BigEndian.Uint16(b[2:]),
+			Temperature:    float64(int16(binary.BigEndian.Uint16(b[4:]))) / 256,
+			AdvCount:       binary.BigEndian.Uint32(b[6:]),
+			Uptime:         time.Duration(binary.BigEndian.Uint32(b[10:])) * 100 * time.Millisecond,
+		}, nil
+
+	case EddystoneTypeEID:
+		if len(b) < 10 {
+			return nil, fmt.Errorf("eddystone eid: length %v: %w", len(b), ErrInvalid)
+		}
+		f := EddystoneEID{TxPower: int8(b[1])}
+		copy(f.EID[:], b[2:10])
+		return f, nil
+	}
+	return nil, fmt.Errorf("eddystone frame type 0x%02X: %w", b[0], ErrInvalid)
+}
+
+// Eddystone returns the Eddystone frame of the packet, or nil if it has none.
+func (p *Packet) Eddystone() EddystoneFrame {
+	for _, sd := range p.ServiceData() {
+		if !sd.UUID.Equal(ble.UUID16(EddystoneUUID)) {
+			continue
+		}
+		if f, err := DecodeEddystone(sd.Data); err == nil {
+			return f
+		}
+	}
+	return nil
+}
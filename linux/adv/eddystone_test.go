@@ -0,0 +1,54 @@
+package adv
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEddystoneURL(t *testing.T) {
+	f := EddystoneURL{TxPower: -20, URL: "https://www.example.com/x"}
+	b, err := f.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []byte{EddystoneTypeURL, 0xEC, 0x01, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x00, 'x'}
+	if !bytes.Equal(b, exp) {
+		t.Fatalf("have % X, want % X", b, exp)
+	}
+	d, err := DecodeEddystone(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != f {
+		t.Fatalf("have %v, want %v", d, f)
+	}
+
+	if _, err := (EddystoneURL{URL: "ftp://a"}).Bytes(); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("have %v, want %v", err, ErrInvalid)
+	}
+	if _, err := (EddystoneURL{URL: "https://abcdefghijklmnopqrstuvwxyz"}).Bytes(); !errors.Is(err, ErrNotFit) {
+		t.Fatalf("have %v, want %v", err, ErrNotFit)
+	}
+}
+
+func TestEddystoneFrames(t *testing.T) {
+	for _, f := range []EddystoneFrame{
+		EddystoneUID{TxPower: -4, Namespace: [10]byte{1, 2, 3}, Instance: [6]byte{4, 5, 6}},
+		EddystoneTLM{BatteryVoltage: 3000, Temperature: 21.5, AdvCount: 100, Uptime: time.Hour},
+		EddystoneEID{TxPower: -4, EID: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}},
+	} {
+		p, err := NewPacket(Flags(FlagGeneralDiscoverable|FlagLEOnly), Eddystone(f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err = NewRawPacket(p.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := p.Eddystone(); d != f {
+			t.Fatalf("have %v, want %v", d, f)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package adv
+
+import (
+	"fmt"
+
+	"github.com/leso-kn/ble/parser"
+)
+
+// EncodeMap encodes a map of decoded fields, as returned by Packet.Map, back
+// into an advertising packet and a scan response. The fields fill the
+// advertising packet in order, and spill into the scan response once it's
+// full. This allows capturing an advertisement, modifying it, and advertising
+// it again.
+func EncodeMap(m map[string]interface{}) (ad *Packet, sr *Packet, err error) {
+	b, err := parser.Encode(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	ad, _ = NewPacket()
+	sr, _ = NewPacket()
+	it := parser.NewIterator(b)
+	for it.Next() {
+		if ad.append(it.Type(), it.Payload()) == nil {
+			continue
+		}
+		if err := sr.append(it.Type(), it.Payload()); err != nil {
+			return nil, nil, fmt.Errorf("ad type 0x%02X: %w", it.Type(), err)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, nil, err
+	}
+	return ad, sr, nil
+}
@@ -0,0 +1,54 @@
+package adv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/leso-kn/ble"
+)
+
+// IBeaconInfo is the content of an iBeacon advertisement.
+type IBeaconInfo struct {
+	UUID          ble.UUID // Proximity UUID.
+	Major         uint16
+	Minor         uint16
+	MeasuredPower int8 // RSSI at 1 m.
+}
+
+// DecodeIBeacon decodes an iBeacon advertisement from manufacturer data,
+// including the company identifier.
+func DecodeIBeacon(md []byte) (*IBeaconInfo, error) {
+	if len(md) < 25 || binary.LittleEndian.Uint16(md) != 0x004C || md[2] != 0x02 || md[3] != 0x15 {
+		return nil, fmt.Errorf("ibeacon: %w", ErrInvalid)
+	}
+	return &IBeaconInfo{
+		UUID:          ble.UUID(ble.Reverse(md[4:20])), // Big endian
+		Major:         binary.BigEndian.Uint16(md[20:]),
+		Minor:         binary.BigEndian.Uint16(md[22:]),
+		MeasuredPower: int8(md[24]),
+	}, nil
+}
+
+// IBeacon returns the iBeacon content of the packet, or nil if it isn't an
+// iBeacon advertisement.
+func (p *Packet) IBeacon() *IBeaconInfo {
+	i, err := DecodeIBeacon(p.ManufacturerData())
+	if err != nil {
+		return nil
+	}
+	return i
+}
+
+// Distance estimates the distance to the beacon in meters, given the RSSI it
+// was received with.
+func (i *IBeaconInfo) Distance(rssi int) float64 {
+	return EstimateDistance(int(i.MeasuredPower), rssi)
+}
+
+// EstimateDistance estimates the distance to a transmitter in meters, given
+// the RSSI of its signal at 1 m and the RSSI it was received with. It uses
+// the free space path loss model, so the estimate is rough indoors.
+func EstimateDistance(power1m int, rssi int) float64 {
+	return math.Pow(10, float64(power1m-rssi)/20)
+}
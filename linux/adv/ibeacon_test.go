@@ -0,0 +1,31 @@
+package adv
+
+import (
+	"math"
+	"testing"
+
+	"github.com/leso-kn/ble"
+)
+
+func TestIBeacon(t *testing.T) {
+	u := ble.MustParse("E2C56DB5-DFFB-48D2-B060-D0F5A71096E0")
+	p, err := NewPacket(Flags(FlagGeneralDiscoverable|FlagLEOnly), IBeacon(u, 1, 2, -59))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = NewRawPacket(p.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := p.IBeacon()
+	if i == nil || !i.UUID.Equal(u) || i.Major != 1 || i.Minor != 2 || i.MeasuredPower != -59 {
+		t.Fatalf("have %+v", i)
+	}
+
+	if d := i.Distance(-59); d != 1 {
+		t.Fatalf("have distance %v at measured power, want 1", d)
+	}
+	if d := i.Distance(-79); math.Abs(d-10) > 1e-9 {
+		t.Fatalf("have distance %v 20 dB below measured power, want 10", d)
+	}
+}
@@ -2,7 +2,10 @@ package adv
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"time"
 
 	"errors"
 
@@ -18,6 +21,15 @@ var keys = struct {
 	localName   string
 	txpwr       string
 	mfgdata     string
+	uri         string
+	appearance  string
+	advInterval string
+	leFeatures  string
+	pubTargets  string
+	randTargets string
+	pbADV       string
+	meshMessage string
+	meshBeacon  string
 }{
 	flags:       ble.AdvertisementMapKeys.Flags,
 	services:    ble.AdvertisementMapKeys.Services,
@@ -26,13 +38,23 @@ var keys = struct {
 	localName:   ble.AdvertisementMapKeys.Name,
 	txpwr:       ble.AdvertisementMapKeys.TxPower,
 	mfgdata:     ble.AdvertisementMapKeys.MFG,
+	uri:         ble.AdvertisementMapKeys.URI,
+	appearance:  ble.AdvertisementMapKeys.Appearance,
+	advInterval: ble.AdvertisementMapKeys.AdvInterval,
+	leFeatures:  ble.AdvertisementMapKeys.LEFeatures,
+	pubTargets:  ble.AdvertisementMapKeys.PublicTargetAddresses,
+	randTargets: ble.AdvertisementMapKeys.RandomTargetAddresses,
+	pbADV:       ble.AdvertisementMapKeys.PBADV,
+	meshMessage: ble.AdvertisementMapKeys.MeshMessage,
+	meshBeacon:  ble.AdvertisementMapKeys.MeshBeacon,
 }
 
 // Packet is an implemntation of ble.AdvPacket for crafting or parsing an advertising packet or scan response.
 // Refer to Supplement to Bluetooth Core Specification | CSSv6, Part A.
 type Packet struct {
-	b []byte
-	m map[string]interface{}
+	b    []byte
+	m    map[string]interface{}
+	errs []error
 }
 
 // Bytes returns the bytes of the packet.
@@ -93,6 +115,46 @@ func NewRawPacket(bytes ...[]byte) (*Packet, error) {
 	return p, nil
 }
 
+// NewRawPacketLenient returns a new advertising Packet like NewRawPacket, but
+// skips malformed AD structures instead of failing. The errors of the skipped
+// structures are available from ParseErrors, and listed in the map under
+// AdvertisementMapKeys.AdvertisementParseErrors.
+func NewRawPacketLenient(bytes ...[]byte) *Packet {
+	//concatenate
+	b := make([]byte, 0, MaxEIRPacketLength)
+	for _, bb := range bytes {
+		b = append(b, bb...)
+	}
+
+	//decode the bytes
+	m, errs := parser.ParseLenient(b)
+	if m == nil {
+		m = make(map[string]interface{})
+	}
+
+	var es []error
+	var ss []string
+	for _, err := range errs {
+		if errors.Is(err, parser.EmptyOrNilPdu) {
+			continue
+		}
+		err = fmt.Errorf("pdu decode: %w", err)
+		es = append(es, err)
+		ss = append(ss, err.Error())
+	}
+	if len(ss) > 0 {
+		m[ble.AdvertisementMapKeys.AdvertisementParseErrors] = ss
+	}
+
+	return &Packet{b: b, m: m, errs: es}
+}
+
+// ParseErrors returns the errors of the AD structures skipped by a lenient
+// decoding.
+func (p *Packet) ParseErrors() []error {
+	return p.errs
+}
+
 // Field is an advertising field which can be appended to a packet.
 type Field func(p *Packet) error
 
@@ -171,6 +233,33 @@ func CompleteName(n string) Field {
 	}
 }
 
+// TxPower is the transmitted power level of the packet in dBm.
+func TxPower(pwr int8) Field {
+	return func(p *Packet) error {
+		return p.append(txPower, []byte{uint8(pwr)})
+	}
+}
+
+// FillTxPower returns the advertising data b, with the TX Power Level fields
+// holding TxPowerAuto set to pwr, and whether there were any. b is left
+// intact.
+func FillTxPower(b []byte, pwr int8) ([]byte, bool) {
+	var out []byte
+	for i := 0; i+1 < len(b) && b[i] != 0; i += int(b[i]) + 1 {
+		if b[i] != 2 || b[i+1] != txPower || i+2 >= len(b) || int8(b[i+2]) != TxPowerAuto {
+			continue
+		}
+		if out == nil {
+			out = append([]byte{}, b...)
+		}
+		out[i+2] = uint8(pwr)
+	}
+	if out == nil {
+		return b, false
+	}
+	return out, true
+}
+
 // ManufacturerData is manufacturer specific data.
 func ManufacturerData(id uint16, b []byte) Field {
 	return func(p *Packet) error {
@@ -216,6 +305,51 @@ func ServiceData16(id uint16, b []byte) Field {
 	}
 }
 
+// ServiceData32 is service data for a 32bit service uuid
+func ServiceData32(id uint32, b []byte) Field {
+	return func(p *Packet) error {
+		uuid := ble.UUID{uint8(id), uint8(id >> 8), uint8(id >> 16), uint8(id >> 24)}
+		if err := p.append(allUUID32, uuid); err != nil {
+			return err
+		}
+		return p.append(serviceData32, append(uuid, b...))
+	}
+}
+
+// ServiceData128 is service data for a 128bit service uuid. Unlike the 16 and
+// 32bit variants, it doesn't list the uuid as a service, as both wouldn't
+// leave room for any data.
+func ServiceData128(u ble.UUID, b []byte) Field {
+	return func(p *Packet) error {
+		if u.Len() != 16 {
+			return ErrInvalid
+		}
+		return p.append(serviceData128, append(append(ble.UUID{}, u...), b...))
+	}
+}
+
+// PBADV is a PB-ADV field, carrying a mesh provisioning PDU.
+func PBADV(b []byte) Field {
+	return func(p *Packet) error {
+		return p.append(pbADV, b)
+	}
+}
+
+// MeshMessage is a Mesh Message field, carrying a mesh network PDU.
+func MeshMessage(b []byte) Field {
+	return func(p *Packet) error {
+		return p.append(meshMessage, b)
+	}
+}
+
+// MeshBeacon is a Mesh Beacon field, such as an unprovisioned device beacon
+// or a secure network beacon.
+func MeshBeacon(b []byte) Field {
+	return func(p *Packet) error {
+		return p.append(meshBeacon, b)
+	}
+}
+
 // Flags returns the flags of the packet.
 func (p *Packet) Flags() (flags byte, present bool) {
 	if b, ok := p.m[keys.flags].([]byte); ok {
@@ -274,11 +408,12 @@ func (p *Packet) ServiceData() []ble.ServiceData {
 			if !ok {
 				continue
 			}
-			u, err := ble.Parse(su)
+			// not ble.Parse, which rejects 32bit uuids
+			u, err := hex.DecodeString(su)
 			if err != nil {
 				continue
 			}
-			out = append(out, ble.ServiceData{UUID: u, Data: sd})
+			out = append(out, ble.ServiceData{UUID: ble.Reverse(u), Data: sd})
 		}
 	}
 
@@ -290,3 +425,76 @@ func (p *Packet) ManufacturerData() []byte {
 	v, _ := p.m[keys.mfgdata].([]byte)
 	return v
 }
+
+// URI returns the URI field, if it presents. The scheme is decoded if it's
+// one of the common ones, and omitted otherwise.
+func (p *Packet) URI() string {
+	b, ok := p.m[keys.uri].([]byte)
+	if !ok {
+		return ""
+	}
+	return uriSchemes[b[0]] + string(b[1:])
+}
+
+// Appearance returns the Appearance, if it presents.
+func (p *Packet) Appearance() (appearance uint16, present bool) {
+	if b, ok := p.m[keys.appearance].([]byte); ok {
+		return binary.LittleEndian.Uint16(b), true
+	}
+	return 0, false
+}
+
+// AdvInterval returns the Advertising Interval, if it presents.
+func (p *Packet) AdvInterval() (interval time.Duration, present bool) {
+	if b, ok := p.m[keys.advInterval].([]byte); ok {
+		return time.Duration(binary.LittleEndian.Uint16(b)) * 625 * time.Microsecond, true
+	}
+	return 0, false
+}
+
+// LEFeatures returns the LE Supported Features field, if it presents.
+func (p *Packet) LEFeatures() []byte {
+	v, _ := p.m[keys.leFeatures].([]byte)
+	return v
+}
+
+// PublicTargetAddrs returns the addresses of the Public Target Address field.
+func (p *Packet) PublicTargetAddrs() []ble.Addr {
+	v, _ := p.m[keys.pubTargets].([]byte)
+	return targetAddrs(v)
+}
+
+// RandomTargetAddrs returns the addresses of the Random Target Address field.
+func (p *Packet) RandomTargetAddrs() []ble.Addr {
+	v, _ := p.m[keys.randTargets].([]byte)
+	return targetAddrs(v)
+}
+
+func targetAddrs(b []byte) []ble.Addr {
+	var addrs []ble.Addr
+	for ; len(b) >= 6; b = b[6:] {
+		a := net.HardwareAddr{b[5], b[4], b[3], b[2], b[1], b[0]}
+		addrs = append(addrs, ble.NewAddr(a.String()))
+	}
+	return addrs
+}
+
+// PBADV returns the payloads of the PB-ADV fields, which carry mesh
+// provisioning PDUs.
+func (p *Packet) PBADV() [][]byte {
+	v, _ := p.m[keys.pbADV].([][]byte)
+	return v
+}
+
+// MeshMessages returns the payloads of the Mesh Message fields, which carry
+// mesh network PDUs.
+func (p *Packet) MeshMessages() [][]byte {
+	v, _ := p.m[keys.meshMessage].([][]byte)
+	return v
+}
+
+// MeshBeacons returns the payloads of the Mesh Beacon fields.
+func (p *Packet) MeshBeacons() [][]byte {
+	v, _ := p.m[keys.meshBeacon].([][]byte)
+	return v
+}
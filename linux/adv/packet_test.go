@@ -0,0 +1,89 @@
+package adv
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/leso-kn/ble"
+)
+
+func TestServiceData(t *testing.T) {
+	u128 := ble.MustParse("00010203-0405-0607-0809-0a0b0c0d0e0f")
+	for _, tc := range []struct {
+		f Field
+		u ble.UUID
+	}{
+		{ServiceData16(0x180D, []byte{1, 2}), ble.UUID16(0x180D)},
+		{ServiceData32(0x1234180D, []byte{1, 2}), ble.UUID{0x0D, 0x18, 0x34, 0x12}},
+		{ServiceData128(u128, []byte{1, 2}), u128},
+	} {
+		p, err := NewPacket(tc.f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err = NewRawPacket(p.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd := p.ServiceData()
+		if len(sd) != 1 || !sd[0].UUID.Equal(tc.u) || !bytes.Equal(sd[0].Data, []byte{1, 2}) {
+			t.Fatalf("uuid %v: have %v", tc.u, sd)
+		}
+	}
+}
+
+func TestEncodeMap(t *testing.T) {
+	ad, sr, err := NewBuilder().
+		Flags(FlagGeneralDiscoverable|FlagLEOnly).
+		ManufacturerData(0x0059, make([]byte, 16)).
+		Name("a long name to spill").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewRawPacket(ad.Bytes(), sr.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := p.Map()
+	m[ble.AdvertisementMapKeys.Name] = []byte("scrubbed")
+	ad, sr, err = EncodeMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.Len() == 0 {
+		t.Fatal("nothing spilled into the scan response")
+	}
+	p, err = NewRawPacket(ad.Bytes(), sr.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := p.LocalName(); n != "scrubbed" {
+		t.Fatalf("have name %q", n)
+	}
+	if md := p.ManufacturerData(); len(md) != 18 {
+		t.Fatalf("have mfg data % X", md)
+	}
+}
+
+func TestFillTxPower(t *testing.T) {
+	ad, _, err := NewBuilder().Flags(FlagGeneralDiscoverable).AutoTxPower().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, ok := FillTxPower(ad.Bytes(), -8)
+	if !ok {
+		t.Fatal("placeholder not found")
+	}
+	exp := []byte{0x02, flags, FlagGeneralDiscoverable, 0x02, txPower, 0xF8}
+	if !bytes.Equal(b, exp) {
+		t.Fatalf("have % X, want % X", b, exp)
+	}
+	if ad.Bytes()[5] != uint8(TxPowerAuto) {
+		t.Fatal("input modified")
+	}
+	if _, ok := FillTxPower(b, -8); ok {
+		t.Fatal("filled a set power level")
+	}
+}
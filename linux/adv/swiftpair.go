@@ -0,0 +1,71 @@
+package adv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/leso-kn/ble"
+)
+
+// Microsoft Swift Pair scenarios.
+const (
+	SwiftPairLE          = 0x00 // Pairing over LE only.
+	SwiftPairBREDR       = 0x01 // Pairing over BR/EDR, with the BR/EDR address.
+	SwiftPairLEWithBREDR = 0x02 // Pairing over LE and BR/EDR.
+)
+
+const (
+	microsoftCompanyID = 0x0006
+	swiftPairBeaconID  = 0x03
+)
+
+// SwiftPair is a Microsoft Swift Pair beacon, prompting Windows to offer
+// pairing with the device.
+type SwiftPair struct {
+	Scenario      byte
+	RSSI          int8     // Reserved RSSI byte.
+	Addr          ble.Addr // BR/EDR address, in the SwiftPairBREDR scenario.
+	ClassOfDevice uint32   // In the BR/EDR scenarios.
+	DisplayName   string
+}
+
+// DecodeSwiftPair decodes a Swift Pair beacon from manufacturer data,
+// including the company identifier.
+func DecodeSwiftPair(md []byte) (*SwiftPair, error) {
+	if len(md) < 5 || binary.LittleEndian.Uint16(md) != microsoftCompanyID || md[2] != swiftPairBeaconID {
+		return nil, fmt.Errorf("swift pair: %w", ErrInvalid)
+	}
+	s := &SwiftPair{Scenario: md[3], RSSI: int8(md[4])}
+	b := md[5:]
+	switch s.Scenario {
+	case SwiftPairLE:
+	case SwiftPairBREDR:
+		if len(b) < 9 {
+			return nil, fmt.Errorf("swift pair: length %v: %w", len(md), ErrInvalid)
+		}
+		s.Addr = ble.NewAddr(net.HardwareAddr{b[5], b[4], b[3], b[2], b[1], b[0]}.String())
+		s.ClassOfDevice = uint32(b[6]) | uint32(b[7])<<8 | uint32(b[8])<<16
+		b = b[9:]
+	case SwiftPairLEWithBREDR:
+		if len(b) < 3 {
+			return nil, fmt.Errorf("swift pair: length %v: %w", len(md), ErrInvalid)
+		}
+		s.ClassOfDevice = uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+		b = b[3:]
+	default:
+		return nil, fmt.Errorf("swift pair: scenario 0x%02X: %w", s.Scenario, ErrInvalid)
+	}
+	s.DisplayName = string(b)
+	return s, nil
+}
+
+// SwiftPair returns the Swift Pair beacon of the packet, or nil if it isn't
+// one.
+func (p *Packet) SwiftPair() *SwiftPair {
+	s, err := DecodeSwiftPair(p.ManufacturerData())
+	if err != nil {
+		return nil
+	}
+	return s
+}
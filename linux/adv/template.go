@@ -0,0 +1,114 @@
+package adv
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/leso-kn/ble"
+)
+
+// Var is a runtime variable of an advertisement template, such as a counter,
+// a battery level or a sensor reading. Fields bound to it, with the Var
+// methods of Builder, are encoded with its value each time the builder
+// builds, e.g. as Device.AdvertiseTemplate refreshes an advertisement.
+//
+// It's safe to set the value of a Var while it's being built.
+//
+//	level := adv.NewVar([]byte{100})
+//	b := adv.NewBuilder().
+//		Flags(adv.FlagGeneralDiscoverable | adv.FlagLEOnly).
+//		ServiceDataVar(ble.UUID16(0x180F), level)
+//	...
+//	level.SetUint8(97)
+type Var struct {
+	mu sync.Mutex
+	b  []byte
+	f  func() []byte
+}
+
+// NewVar returns a variable with the initial value b.
+func NewVar(b []byte) *Var {
+	return &Var{b: append([]byte{}, b...)}
+}
+
+// VarFunc returns a variable whose value is returned by f each time it's
+// built, e.g. to sample a sensor, or to increment a counter, when the
+// advertisement is refreshed.
+func VarFunc(f func() []byte) *Var {
+	return &Var{f: f}
+}
+
+// Set sets the value of v to b.
+func (v *Var) Set(b []byte) {
+	v.mu.Lock()
+	v.b, v.f = append([]byte{}, b...), nil
+	v.mu.Unlock()
+}
+
+// SetUint8 sets the value of v to a single octet.
+func (v *Var) SetUint8(n uint8) {
+	v.Set([]byte{n})
+}
+
+// SetUint16 sets the value of v to a little-endian 16-bit value, the byte
+// order of the Bluetooth specifications.
+func (v *Var) SetUint16(n uint16) {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, n)
+	v.Set(b)
+}
+
+// SetUint32 sets the value of v to a little-endian 32-bit value.
+func (v *Var) SetUint32(n uint32) {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, n)
+	v.Set(b)
+}
+
+// Bytes returns the current value of v.
+func (v *Var) Bytes() []byte {
+	v.mu.Lock()
+	b, f := v.b, v.f
+	v.mu.Unlock()
+	if f != nil {
+		b = f()
+	}
+	return append([]byte{}, b...)
+}
+
+// FieldVar adds a field of any AD type, whose data is the fixed prefix
+// followed by the values of vars, read each time the builder builds.
+func (b *Builder) FieldVar(typ byte, prefix []byte, vars ...*Var) *Builder {
+	b.Field(typ, prefix)
+	b.fields[len(b.fields)-1].vars = vars
+	return b
+}
+
+// ManufacturerDataVar adds manufacturer specific data, made of the values of
+// vars, read each time the builder builds.
+func (b *Builder) ManufacturerDataVar(id uint16, vars ...*Var) *Builder {
+	return b.FieldVar(manufacturerData, []byte{uint8(id), uint8(id >> 8)}, vars...)
+}
+
+// ServiceDataVar adds service data for a 16, 32, or 128-bit service UUID,
+// made of the values of vars, read each time the builder builds.
+func (b *Builder) ServiceDataVar(u ble.UUID, vars ...*Var) *Builder {
+	n := len(b.fields)
+	b.ServiceData(u, nil)
+	if len(b.fields) > n {
+		b.fields[n].vars = vars
+	}
+	return b
+}
+
+// value returns the data of f, with the current values of its variables.
+func (f builderField) value() []byte {
+	if len(f.vars) == 0 {
+		return f.data
+	}
+	d := append([]byte{}, f.data...)
+	for _, v := range f.vars {
+		d = append(d, v.Bytes()...)
+	}
+	return d
+}
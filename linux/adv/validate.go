@@ -0,0 +1,160 @@
+package adv
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// ValidationError is a rule of the Core Specification Supplement broken by
+// an advertisement.
+type ValidationError struct {
+	// ScanResponse is set if the rule is broken by the scan response data,
+	// rather than by the advertising data.
+	ScanResponse bool
+
+	// Type is the AD type of the field breaking the rule, or 0 if the rule
+	// is about the packet as a whole.
+	Type byte
+
+	// Reason tells what's wrong, and how to fix it.
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	s := "advertising data"
+	if e.ScanResponse {
+		s = "scan response data"
+	}
+	if e.Type != 0 {
+		s += fmt.Sprintf(": ad type 0x%02X", e.Type)
+	}
+	return s + ": " + e.Reason
+}
+
+// ValidationErrors are all the rules broken by an advertisement.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// singleTypes are the AD types which appear at most once in a packet
+// [CSS, Part A, 1].
+var singleTypes = map[byte]string{
+	flags:         "flags",
+	allUUID16:     "complete list of 16-bit service uuids",
+	allUUID32:     "complete list of 32-bit service uuids",
+	allUUID128:    "complete list of 128-bit service uuids",
+	someUUID16:    "incomplete list of 16-bit service uuids",
+	someUUID32:    "incomplete list of 32-bit service uuids",
+	someUUID128:   "incomplete list of 128-bit service uuids",
+	shortName:     "shortened local name",
+	completeName:  "complete local name",
+	txPower:       "tx power level",
+	classOfDevice: "class of device",
+	slaveConnInt:  "peripheral connection interval range",
+	appearance:    "appearance",
+	advInterval:   "advertising interval",
+	leDeviceAddr:  "LE bluetooth device address",
+	leRole:        "LE role",
+	uri:           "URI",
+	leFeatures:    "LE supported features",
+}
+
+// fixedLens are the lengths of the data of the AD types of a fixed size.
+var fixedLens = map[byte]int{
+	flags:        1,
+	txPower:      1,
+	appearance:   2,
+	advInterval:  2,
+	slaveConnInt: 4,
+	leDeviceAddr: 7,
+	leRole:       1,
+}
+
+// Validate checks the advertising data ad and the scan response data sr,
+// either of which may be nil, against the rules of the Core Specification
+// Supplement, which controllers don't check or reject with a bare status
+// code:
+//
+//   - the packets are at most 31 bytes long, made of well-formed AD
+//     structures, and the types of a fixed size have the right length;
+//   - connectable advertising has flags, and the scan response has none;
+//   - the types allowed once per packet, e.g. the flags, the name, the tx
+//     power and the complete lists of uuids, aren't repeated;
+//   - a packet has either a shortened or a complete local name, and a
+//     shortened name is the beginning of the complete one.
+//
+// It returns ValidationErrors listing the rules broken, or nil.
+func Validate(ad, sr []byte, connectable bool) error {
+	var errs ValidationErrors
+	report := func(isSR bool, typ byte, format string, a ...interface{}) {
+		errs = append(errs, &ValidationError{ScanResponse: isSR, Type: typ, Reason: fmt.Sprintf(format, a...)})
+	}
+
+	names := map[byte][]byte{}
+	shortSR := false
+	for i, b := range [][]byte{ad, sr} {
+		isSR := i == 1
+		if len(b) > MaxEIRPacketLength {
+			report(isSR, 0, "%d bytes long, more than the %d bytes of legacy advertising", len(b), MaxEIRPacketLength)
+		}
+		seen := map[byte]bool{}
+		for len(b) > 0 {
+			n := int(b[0])
+			if n == 0 {
+				// The rest of the packet is padding.
+				if !bytes.Equal(b, make([]byte, len(b))) {
+					report(isSR, 0, "data after an empty AD structure, which ends the packet")
+				}
+				break
+			}
+			if n+1 > len(b) {
+				report(isSR, 0, "AD structure of %d bytes runs past the end of the packet", n)
+				break
+			}
+			typ, data := b[1], b[2:n+1]
+			b = b[n+1:]
+
+			if l, ok := fixedLens[typ]; ok && len(data) != l {
+				report(isSR, typ, "%d bytes of data, want %d", len(data), l)
+			}
+			if name, ok := singleTypes[typ]; ok && seen[typ] {
+				report(isSR, typ, "%s repeated, it appears at most once per packet", name)
+			}
+			seen[typ] = true
+			if typ == shortName || typ == completeName {
+				names[typ] = data
+			}
+			if typ == shortName {
+				shortSR = isSR
+			}
+		}
+
+		switch {
+		case isSR && seen[flags]:
+			report(isSR, flags, "flags in the scan response, they belong in the advertising data")
+		case !isSR && connectable && !seen[flags]:
+			report(isSR, flags, "no flags in connectable advertising, add e.g. FlagGeneralDiscoverable|FlagLEOnly")
+		}
+		if seen[shortName] && seen[completeName] {
+			report(isSR, shortName, "both shortened and complete local names, keep one of them")
+		}
+	}
+
+	short, hasShort := names[shortName]
+	complete, hasComplete := names[completeName]
+	if hasShort && hasComplete && !bytes.HasPrefix(complete, short) {
+		report(shortSR, shortName, "shortened local name %q isn't the beginning of the complete one %q", short, complete)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
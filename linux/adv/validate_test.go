@@ -0,0 +1,84 @@
+package adv
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/leso-kn/ble"
+)
+
+func TestValidate(t *testing.T) {
+	flagsField := []byte{0x02, flags, 0x06}
+	name := func(typ byte, n string) []byte { return append([]byte{byte(len(n) + 1), typ}, n...) }
+	cat := func(bs ...[]byte) []byte {
+		var b []byte
+		for _, p := range bs {
+			b = append(b, p...)
+		}
+		return b
+	}
+
+	for _, tc := range []struct {
+		name        string
+		ad, sr      []byte
+		connectable bool
+		errs        []string // Substrings of the errors, in order.
+	}{
+		{"valid", cat(flagsField, name(completeName, "gopher")), nil, true, nil},
+		{"shortened name in scan response", cat(flagsField, name(shortName, "go")), name(completeName, "gopher"), true, nil},
+		{"non-connectable without flags", name(completeName, "gopher"), nil, false, nil},
+		{"padding", append(cat(flagsField), 0, 0, 0), nil, true, nil},
+		{"connectable without flags", name(completeName, "gopher"), nil, true,
+			[]string{"advertising data: ad type 0x01: no flags"}},
+		{"flags in scan response", flagsField, flagsField, true,
+			[]string{"scan response data: ad type 0x01: flags in the scan response"}},
+		{"too long", cat(flagsField, name(manufacturerData, strings.Repeat("x", 29))), nil, true,
+			[]string{"34 bytes long"}},
+		{"truncated", cat(flagsField, []byte{0x05, txPower, 0x00}), nil, true,
+			[]string{"runs past the end"}},
+		{"data after padding", cat(flagsField, []byte{0x00, 0x02, txPower, 0x00}), nil, true,
+			[]string{"data after an empty AD structure"}},
+		{"wrong size", cat([]byte{0x03, flags, 0x06, 0x00}), nil, true,
+			[]string{"ad type 0x01: 2 bytes of data, want 1"}},
+		{"repeated", cat(flagsField, []byte{0x03, allUUID16, 0x0D, 0x18, 0x03, allUUID16, 0x0F, 0x18}), nil, true,
+			[]string{"ad type 0x03: complete list of 16-bit service uuids repeated"}},
+		{"repeated service data", cat(flagsField, []byte{0x04, serviceData16, 0x0D, 0x18, 1, 0x04, serviceData16, 0x0F, 0x18, 2}), nil, true, nil},
+		{"both names", cat(flagsField, name(shortName, "go"), name(completeName, "gopher")), nil, true,
+			[]string{"both shortened and complete local names"}},
+		{"inconsistent names", cat(flagsField, name(shortName, "ga")), name(completeName, "gopher"), true,
+			[]string{`advertising data: ad type 0x08: shortened local name "ga" isn't the beginning`}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(tc.ad, tc.sr, tc.connectable)
+			if len(tc.errs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			errs, ok := err.(ValidationErrors)
+			if !ok || len(errs) != len(tc.errs) {
+				t.Fatalf("errors %v, want %q", err, tc.errs)
+			}
+			for i, e := range errs {
+				if !strings.Contains(e.Error(), tc.errs[i]) {
+					t.Errorf("error %q, want %q", e, tc.errs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateBuilder(t *testing.T) {
+	ad, sr, err := NewBuilder().
+		Flags(FlagGeneralDiscoverable|FlagLEOnly).
+		Services(ble.UUID16(0x180D), ble.UUID16(0x180F)).
+		Name(strings.Repeat("n", 40)).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(ad.Bytes(), sr.Bytes(), true); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,81 @@
+package adv
+
+import "testing"
+
+func TestContinuity(t *testing.T) {
+	md := []byte{
+		0x4C, 0x00,
+		ContinuityTypeNearbyInfo, 0x05, 0x1B, 0x18, 0xAA, 0xBB, 0xCC,
+		ContinuityTypeProximityPairing, 0x09, 0x01, 0x0E, 0x20, 0x2B, 0x98, 0x57, 0x03, 0x00, 0x00,
+	}
+	p, err := NewRawPacket(append([]byte{byte(len(md) + 1), manufacturerData}, md...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := p.AppleContinuity()
+	if len(msgs) != 2 {
+		t.Fatalf("have %v messages, want 2", len(msgs))
+	}
+
+	n, err := msgs[0].NearbyInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.StatusFlags != 0x01 || n.ActionCode != 0x0B || n.DataFlags != 0x18 || len(n.AuthTag) != 3 {
+		t.Fatalf("have %+v", n)
+	}
+
+	pp, err := msgs[1].ProximityPairing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := ProximityPairing{
+		Model: 0x0E20, Status: 0x2B,
+		LeftBattery: 80, RightBattery: 90, CaseBattery: 70,
+		LeftCharging: true, CaseCharging: true,
+		LidOpenCount: 0x03,
+	}
+	if *pp != exp {
+		t.Fatalf("have %+v, want %+v", *pp, exp)
+	}
+	if _, err := msgs[1].NearbyInfo(); err == nil {
+		t.Fatal("decoded proximity pairing as nearby info")
+	}
+
+	// overflowing message
+	if _, err := DecodeContinuity([]byte{0x4C, 0x00, ContinuityTypeHandoff, 0x0E, 0x00}); err == nil {
+		t.Fatal("no error on overflow")
+	}
+}
+
+func TestSwiftPair(t *testing.T) {
+	for _, tc := range []struct {
+		md  []byte
+		exp SwiftPair
+	}{
+		{
+			[]byte{0x06, 0x00, 0x03, 0x00, 0x80, 'K', 'b', 'd'},
+			SwiftPair{Scenario: SwiftPairLE, RSSI: -128, DisplayName: "Kbd"},
+		},
+		{
+			[]byte{0x06, 0x00, 0x03, 0x02, 0x80, 0x04, 0x04, 0x24, 'H', 's'},
+			SwiftPair{Scenario: SwiftPairLEWithBREDR, RSSI: -128, ClassOfDevice: 0x240404, DisplayName: "Hs"},
+		},
+	} {
+		s, err := DecodeSwiftPair(tc.md)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *s != tc.exp {
+			t.Fatalf("have %+v, want %+v", *s, tc.exp)
+		}
+	}
+
+	s, err := DecodeSwiftPair([]byte{0x06, 0x00, 0x03, 0x01, 0x80, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01, 0x04, 0x04, 0x24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Addr.String() != "01:02:03:04:05:06" || s.ClassOfDevice != 0x240404 {
+		t.Fatalf("have %+v", s)
+	}
+}
@@ -0,0 +1,166 @@
+package adv
+
+import (
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/parser"
+)
+
+// View decodes the fields of an advertising packet and its scan response on
+// demand, from the buffers it was made of, rather than up front into a map
+// as Packet does. It spares the allocations of decoding each advertisement
+// of a dense scan, when only a few fields are looked at.
+//
+// The accessors return the same values as the ones of Packet, referring to
+// the buffers where possible, so the buffers must not change while the view
+// is used. Malformed AD structures are skipped, and an overflowing one ends
+// its buffer, as in lenient parsing.
+type View struct {
+	// bufs are the advertising data and the scan response data, kept in an
+	// array to iterate over them without allocating.
+	bufs [2][]byte
+}
+
+// NewView returns a view over the advertising data ad and the scan response
+// data sr, which may be nil.
+func NewView(ad, sr []byte) *View {
+	return &View{bufs: [2][]byte{ad, sr}}
+}
+
+// Packet decodes all the fields of the view, e.g. for Map.
+func (v *View) Packet() *Packet {
+	return NewRawPacketLenient(v.bufs[:]...)
+}
+
+// each calls f with the type and payload of the non-empty AD structures,
+// until f returns false.
+func (v *View) each(f func(typ byte, b []byte) bool) {
+	it := parser.NewIterator(v.bufs[:]...)
+	for it.Next() {
+		if len(it.Payload()) > 0 && !f(it.Type(), it.Payload()) {
+			return
+		}
+	}
+}
+
+// first returns the payload of the first structure of type typ, if any.
+func (v *View) first(typ byte) []byte {
+	var p []byte
+	v.each(func(t byte, b []byte) bool {
+		if t == typ {
+			p = b
+		}
+		return p == nil
+	})
+	return p
+}
+
+// Flags returns the flags of the packet.
+func (v *View) Flags() (byte, bool) {
+	if b := v.first(flags); b != nil {
+		return b[0], true
+	}
+	return 0, false
+}
+
+// LocalName returns the ShortName or CompleteName if it presents.
+func (v *View) LocalName() string {
+	var n []byte
+	var count int
+	v.each(func(t byte, b []byte) bool {
+		if t == shortName || t == completeName {
+			if count++; count == 1 {
+				n = b
+			} else {
+				n = append(append([]byte{}, n...), b...)
+			}
+		}
+		return true
+	})
+	return string(n)
+}
+
+// TxPower returns the TxPower, if it presents.
+func (v *View) TxPower() (power int, present bool) {
+	if b := v.first(txPower); b != nil {
+		return int(int8(b[0])), true
+	}
+	return 0, false
+}
+
+// uuids returns the UUIDs of the structures of the types in sizes, which map
+// AD types to the size of their UUIDs.
+func (v *View) uuids(sizes map[byte]int) []ble.UUID {
+	var uuids []ble.UUID
+	v.each(func(t byte, b []byte) bool {
+		n, ok := sizes[t]
+		if !ok || len(b)%n != 0 {
+			return true
+		}
+		for ; len(b) > 0; b = b[n:] {
+			uuids = append(uuids, ble.UUID(b[:n]))
+		}
+		return true
+	})
+	return uuids
+}
+
+var (
+	serviceSizes = map[byte]int{
+		someUUID16: 2, allUUID16: 2,
+		someUUID32: 4, allUUID32: 4,
+		someUUID128: 16, allUUID128: 16,
+	}
+	solicitedSizes = map[byte]int{serviceSol16: 2, serviceSol32: 4, serviceSol128: 16}
+)
+
+// UUIDs returns a list of service UUIDs.
+func (v *View) UUIDs() []ble.UUID {
+	return v.uuids(serviceSizes)
+}
+
+// ServiceSol returns a list of solicited service UUIDs.
+func (v *View) ServiceSol() []ble.UUID {
+	return v.uuids(solicitedSizes)
+}
+
+// ServiceData returns the service data, in the order of the packet.
+func (v *View) ServiceData() []ble.ServiceData {
+	var sd []ble.ServiceData
+	v.each(func(t byte, b []byte) bool {
+		n := 0
+		switch t {
+		case serviceData16:
+			n = 2
+		case serviceData32:
+			n = 4
+		case serviceData128:
+			n = 16
+		}
+		if n > 0 && len(b) >= n {
+			sd = append(sd, ble.ServiceData{UUID: ble.UUID(b[:n]), Data: b[n:]})
+		}
+		return true
+	})
+	return sd
+}
+
+// ManufacturerData returns the ManufacturerData field if it presents. The
+// company identifier repeated by further fields, as in the scan response, is
+// left out.
+func (v *View) ManufacturerData() []byte {
+	var md []byte
+	var count int
+	v.each(func(t byte, b []byte) bool {
+		if t != manufacturerData {
+			return true
+		}
+		switch count++; {
+		case count == 1:
+			md = b
+		case len(b) >= 2:
+			md = append(append([]byte{}, md...), b[2:]...)
+		}
+		return true
+	})
+	return md
+}
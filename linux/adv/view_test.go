@@ -0,0 +1,70 @@
+package adv
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/leso-kn/ble"
+)
+
+func TestViewMatchesPacket(t *testing.T) {
+	u128 := ble.MustParse("00010203-0405-0607-0809-0a0b0c0d0e0f")
+	ad, sr, err := NewBuilder().
+		Flags(FlagGeneralDiscoverable|FlagLEOnly).
+		TxPower(-8).
+		ManufacturerData(0x0059, []byte{1, 2, 3}).
+		ServiceData(ble.UUID16(0xFEAA), []byte{0x10, 0x20}).
+		Services(ble.UUID16(0x180D), ble.UUID16(0x180F)).
+		ScanResponse().
+		Services(u128).
+		Name("Heart Rate").
+		ManufacturerData(0x0059, []byte{4, 5}).
+		Field(serviceSol16, []byte{0x12, 0x18}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewRawPacketLenient(ad.Bytes(), sr.Bytes())
+	v := NewView(ad.Bytes(), sr.Bytes())
+
+	if f, ok := v.Flags(); f != FlagGeneralDiscoverable|FlagLEOnly || !ok {
+		t.Errorf("flags = %02X, %v", f, ok)
+	}
+	if v.LocalName() != p.LocalName() {
+		t.Errorf("name = %q, want %q", v.LocalName(), p.LocalName())
+	}
+	pv, okv := v.TxPower()
+	pp, okp := p.TxPower()
+	if pv != pp || okv != okp {
+		t.Errorf("tx power = %d, %v, want %d, %v", pv, okv, pp, okp)
+	}
+	if !reflect.DeepEqual(v.ManufacturerData(), p.ManufacturerData()) {
+		t.Errorf("manufacturer data = % X, want % X", v.ManufacturerData(), p.ManufacturerData())
+	}
+	if !reflect.DeepEqual(v.UUIDs(), p.UUIDs()) {
+		t.Errorf("uuids = %v, want %v", v.UUIDs(), p.UUIDs())
+	}
+	if !reflect.DeepEqual(v.ServiceSol(), p.ServiceSol()) {
+		t.Errorf("solicited = %v, want %v", v.ServiceSol(), p.ServiceSol())
+	}
+	if !reflect.DeepEqual(v.ServiceData(), p.ServiceData()) {
+		t.Errorf("service data = %v, want %v", v.ServiceData(), p.ServiceData())
+	}
+	if !reflect.DeepEqual(v.Packet().Map(), p.Map()) {
+		t.Errorf("map = %v, want %v", v.Packet().Map(), p.Map())
+	}
+}
+
+func TestViewMalformed(t *testing.T) {
+	// A name, a UUID list of the wrong length, and an overflowing field.
+	v := NewView([]byte{3, completeName, 'h', 'i', 2, allUUID16, 0x0D, 9, manufacturerData, 1}, nil)
+	if v.LocalName() != "hi" {
+		t.Errorf("name = %q", v.LocalName())
+	}
+	if v.UUIDs() != nil {
+		t.Errorf("uuids = %v", v.UUIDs())
+	}
+	if v.ManufacturerData() != nil {
+		t.Errorf("manufacturer data = % X", v.ManufacturerData())
+	}
+}
@@ -0,0 +1,59 @@
+package att
+
+import (
+	"encoding/binary"
+
+	"github.com/leso-kn/ble"
+)
+
+// Access is a read or a write of an attribute of a Server by its peer.
+type Access struct {
+	Opcode byte   // Opcode of the request or command.
+	Handle uint16 // Handle of the attribute.
+	UUID   ble.UUID
+	Err    ble.ATTError // Error the request was answered with, if any.
+}
+
+// SetAccessHandler sets f to be called with the reads and writes of the
+// attributes by the peer, including the writes it prepares, once they're
+// answered. f is called by the goroutine handling the requests, and must
+// not block. A nil f removes the handler.
+func (s *Server) SetAccessHandler(f func(Access)) {
+	s.muDB.Lock()
+	defer s.muDB.Unlock()
+	s.access = f
+}
+
+// reportAccess passes the accesses of the request req, answered by rsp, to
+// the access handler.
+func (s *Server) reportAccess(req, rsp []byte) {
+	s.muDB.Lock()
+	f := s.access
+	s.muDB.Unlock()
+	if f == nil || len(req) < 3 {
+		return
+	}
+	var hs []uint16
+	switch req[0] {
+	case ReadRequestCode, ReadBlobRequestCode, WriteRequestCode, WriteCommandCode,
+		SignedWriteCommandCode, PrepareWriteRequestCode:
+		hs = []uint16{binary.LittleEndian.Uint16(req[1:])}
+	case ReadMultipleRequestCode:
+		for b := req[1:]; len(b) >= 2; b = b[2:] {
+			hs = append(hs, binary.LittleEndian.Uint16(b))
+		}
+	default:
+		return
+	}
+	var e ble.ATTError
+	if len(rsp) == 5 && rsp[0] == ErrorResponseCode {
+		e = ble.ATTError(rsp[4])
+	}
+	for _, h := range hs {
+		a := Access{Opcode: req[0], Handle: h, Err: e}
+		if at, ok := s.db.at(h); ok {
+			a.UUID = at.typ
+		}
+		f(a)
+	}
+}
@@ -15,6 +15,15 @@ var (
 	// ErrSeqProtoTimeout means the request hasn't been acknowledged in 30 seconds.
 	// [Vol 3, Part F, 3.3.3]
 	ErrSeqProtoTimeout = errors.New("req timeout")
+
+	// ErrNotSubscribed means the peer didn't subscribe to the
+	// characteristic notified.
+	ErrNotSubscribed = errors.New("not subscribed")
+
+	// ErrInvalidHandle means a request was made for the attribute handle
+	// 0x0000, which is reserved, e.g. of an attribute which wasn't
+	// discovered. [Vol 3, Part F, 3.2.2]
+	ErrInvalidHandle = errors.New("invalid attribute handle 0x0000")
 )
 
 var rspOfReq = map[byte]byte{
@@ -31,3 +40,10 @@ var rspOfReq = map[byte]byte{
 	ExecuteWriteRequestCode:    ExecuteWriteResponseCode,
 	HandleValueIndicationCode:  HandleValueConfirmationCode,
 }
+
+// ResponseOf returns the opcode of the response to the request, or the
+// confirmation of the indication, op. It reports whether op expects one.
+func ResponseOf(op byte) (byte, bool) {
+	rsp, ok := rspOfReq[op]
+	return rsp, ok
+}
@@ -11,4 +11,6 @@ type attr struct {
 	v  []byte
 	rh ble.ReadHandler
 	wh ble.WriteHandler
+
+	c *ble.Characteristic // characteristic of a value attribute
 }
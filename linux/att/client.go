@@ -6,30 +6,110 @@ import (
 
 	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/lifecycle"
 )
 
 // NotificationHandler handles notification or indication.
 type NotificationHandler interface {
+	// HandleNotification handles the PDU req, which is only valid during
+	// the call: its buffer is reused for the PDUs received next.
 	HandleNotification(req []byte)
 }
 
+// TimestampedNotificationHandler is a NotificationHandler which is also
+// passed the time the HCI received the PDU at, if the connection stamps
+// them. The client calls HandleTimestampedNotification instead of
+// HandleNotification.
+type TimestampedNotificationHandler interface {
+	NotificationHandler
+
+	// HandleTimestampedNotification handles the PDU req, received at t, or
+	// the zero time if the connection doesn't stamp the PDUs.
+	HandleTimestampedNotification(req []byte, t time.Time)
+}
+
+// timestampedReader reads the PDUs of a connection with the time they were
+// received at, e.g. a *hci.Conn.
+type timestampedReader interface {
+	ReadTimestamped(sdu []byte) (int, time.Time, error)
+}
+
+// rxPool holds the buffers PDUs are read into. Notifications are handled in
+// place, and their buffers recycled, which spares an allocation per PDU at
+// high notification rates.
+var rxPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, ble.MaxMTU)
+		return &b
+	},
+}
+
 // Client implementation an Attribute Protocol Client.
+//
+// Notifications and indications are handled by their own goroutines, so a
+// response may be returned before the notifications received ahead of it
+// are handled, unless SetNotificationOrdering is set.
 type Client struct {
 	l2c  ble.Conn
 	rspc chan []byte
 	inc  chan []byte
 
-	rxBuf      []byte
 	chTxBuf    chan []byte
 	chErr      chan error
 	handler    NotificationHandler
+	workers    int
 	done       chan bool
 	connClosed chan struct{}
 
-	server *Server
+	// g owns the goroutines of the client, except Loop, which the caller
+	// runs. closed is closed once Loop returns.
+	g      lifecycle.Group
+	closed chan struct{}
+
+	// server, if set, serves a local database to the peer, which may act
+	// as a GATT client too.
+	muServer sync.Mutex
+	server   *Server
+	access   func(Access)
+
+	// limiter spaces the requests and commands sent.
+	limiter rateLimiter
+
+	// ordered holds the responses to reads back until the notifications
+	// received before them are handled. req is the request in flight, sent
+	// at reqSince; closing cancelReq cancels it. stale are the canceled
+	// requests whose responses are to be dropped. owed is the last request
+	// canceled, sent at owedSince, until its response arrives or owedTimer
+	// expires; the tx buffer is held back in heldTxBuf meanwhile. disp
+	// dispatches the notifications, and reqBusy are those it handled as req
+	// was sent. rspAt is
+	// the time the last response was received at, and rtt the round trip of
+	// the last request. tolerance correlates the responses with req,
+	// answered once one was, and discarded counts those dropped.
+	ordered    int32
+	strict     int32
+	muReq      sync.Mutex
+	req        []byte
+	reqSince   time.Time
+	reqTimeout time.Duration
+	cancelReq  chan struct{}
+	stale      []staleReq
+	owed       []byte
+	owedSince  time.Time
+	owedTimer  *time.Timer
+	heldTxBuf  []byte
+	disp       *dispatcher
+	reqBusy    mark
+	rspAt      time.Time
+	rtt        time.Duration
+	tolerance  time.Duration
+	answered   bool
+	discarded  DiscardedResponses
 	ble.Logger
 }
 
@@ -40,33 +120,148 @@ func NewClient(l2c ble.Conn, h NotificationHandler, done chan bool, l ble.Logger
 		rspc:       make(chan []byte),
 		inc:        make(chan []byte, 10),
 		chTxBuf:    make(chan []byte, 1),
-		rxBuf:      make([]byte, ble.MaxMTU),
 		chErr:      make(chan error, 1),
 		handler:    h,
 		done:       done,
 		connClosed: make(chan struct{}),
+		closed:     make(chan struct{}),
+		reqTimeout: 2 * time.Second,
 		Logger:     l,
 	}
 	c.chTxBuf <- make([]byte, l2c.TxMTU())
 
-	go func() {
-		<-l2c.Disconnected()
-		close(c.connClosed)
-	}()
+	c.g.Go(func() {
+		select {
+		case <-l2c.Disconnected():
+			close(c.connClosed)
+		case <-c.done:
+		case <-c.closed:
+		}
+	})
 
 	return c
 }
 
+// WaitClosed blocks until the goroutines started by the client have
+// returned, which they do once Loop returned, or the connection is closed.
+func (c *Client) WaitClosed() {
+	c.g.Wait()
+}
+
+// SetNotificationWorkers sets the number of goroutines handling the
+// notifications and indications received, 1 by default. Those of an
+// attribute are handled in order, by the same goroutine, while those of
+// different attributes may be handled in parallel. It must be called before
+// Loop.
+func (c *Client) SetNotificationWorkers(n int) {
+	c.workers = n
+}
+
+// SetNotificationOrdering sets whether a read returns only once the
+// notifications and indications received before its response are handled,
+// rather than possibly before, e.g. for application state built from both
+// not to be overwritten by a stale read. It applies to Read, Read Blob and
+// Read Multiple requests, and waits for the worker handling the notifications
+// of the attribute read, see SetNotificationWorkers. A read issued while the
+// worker handles a notification, e.g. by its handler, doesn't wait for the
+// worker. The handlers must not wait for the reads of other goroutines,
+// which may wait for them.
+func (c *Client) SetNotificationOrdering(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&c.ordered, v)
+}
+
+// orderedRsp is a response to deliver once the notifications of m are
+// handled, but those being handled at busy, see dispatcher.wait.
+type orderedRsp struct {
+	b      []byte
+	cancel <-chan struct{}
+	m      mark
+	busy   mark
+}
+
+// orderMark returns the notifications received before the response to the
+// request in flight, which it waits for if it's a read and notifications
+// are ordered, and those being handled as the request was sent.
+func (c *Client) orderMark(d *dispatcher) (m, busy mark, ok bool) {
+	if atomic.LoadInt32(&c.ordered) == 0 {
+		return nil, nil, false
+	}
+	c.muReq.Lock()
+	req, busy := c.req, c.reqBusy
+	c.muReq.Unlock()
+	switch {
+	case len(req) >= 3 && (req[0] == ReadRequestCode || req[0] == ReadBlobRequestCode):
+		if h := binary.LittleEndian.Uint16(req[1:]); h != 0 {
+			return d.mark(h), busy, true
+		}
+	case len(req) > 0 && req[0] == ReadMultipleRequestCode:
+		return d.mark(0), busy, true
+	}
+	return nil, nil, false
+}
+
+// deliverRsp hands the response b to the request waiting for it, or drops
+// it if the request is canceled meanwhile. It reports false if the client
+// is closed.
+func (c *Client) deliverRsp(b []byte, cancel <-chan struct{}) bool {
+	select {
+	case <-c.done:
+		c.Info("exited client loop: closed after rsp rx")
+		return false
+	case <-c.connClosed:
+		c.Debug("exited client async loop: conn closed")
+		return false
+	case <-c.closed:
+		return false
+	case c.rspc <- b:
+	case <-cancel:
+		c.dropLate(b)
+	}
+	return true
+}
+
+// WithServer serves db to the peer, which may access it as a GATT client.
+// If a database is served already, db replaces it from the peer's next
+// request on.
 func (c *Client) WithServer(db *DB) *Client {
-	var err error
-	c.server, err = NewServer(db, c.l2c, c.Logger)
+	c.muServer.Lock()
+	defer c.muServer.Unlock()
+	if c.server != nil {
+		c.server.SetDB(db)
+		return c
+	}
+	s, err := NewServer(db, c.l2c, c.Logger)
 	if err != nil {
 		c.Errorf("failed to create server")
+		return c
 	}
-
+	s.SetAccessHandler(c.access)
+	c.server = s
 	return c
 }
 
+// Server returns the server of the database served to the peer, or nil.
+func (c *Client) Server() *Server {
+	c.muServer.Lock()
+	defer c.muServer.Unlock()
+	return c.server
+}
+
+// SetServerAccessHandler sets f to be called with the accesses of the peer
+// to the database served to it. See Server.SetAccessHandler.
+func (c *Client) SetServerAccessHandler(f func(Access)) {
+	c.muServer.Lock()
+	defer c.muServer.Unlock()
+	c.access = f
+	if c.server != nil {
+		c.server.SetAccessHandler(f)
+	}
+}
+
 // ExchangeMTU informs the server of the client’s maximum receive MTU size and
 // request the server to respond with its maximum receive MTU size. [Vol 3, Part F, 3.4.2.1]
 func (c *Client) ExchangeMTU(clientRxMTU int) (serverRxMTU int, err error) {
@@ -77,8 +272,11 @@ func (c *Client) ExchangeMTU(clientRxMTU int) (serverRxMTU int, err error) {
 	// Acquire and reuse the txBuf, and release it after usage.
 	// The same txBuf, or a newly allocate one, if the txMTU is changed,
 	// will be released back to the channel.
-	txBuf := <-c.chTxBuf
-	defer func() { c.chTxBuf <- txBuf }()
+	txBuf, err := c.acquireTxBuf()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { c.releaseTxBuf(txBuf) }()
 
 	// Let L2CAP know the MTU we can handle.
 	c.l2c.SetRxMTU(clientRxMTU)
@@ -130,8 +328,11 @@ func (c *Client) FindInformation(starth, endh uint16) (fmt int, data []byte, err
 	}
 
 	// Acquire and reuse the txBuf, and release it after usage.
-	txBuf := <-c.chTxBuf
-	defer func() { c.chTxBuf <- txBuf }()
+	txBuf, err := c.acquireTxBuf()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { c.releaseTxBuf(txBuf) }()
 
 	req := FindInformationRequest(txBuf[:5])
 	req.SetAttributeOpcode()
@@ -162,6 +363,30 @@ func (c *Client) FindInformation(starth, endh uint16) (fmt int, data []byte, err
 	return int(rsp.Format()), rsp.InformationData(), nil
 }
 
+// RoundTrip measures the round trip of a request, from its sending to the
+// receipt of its response, stamped by the HCI if the connection stamps the
+// PDUs. It sends a Find Information Request of the handle 0x0001, which any
+// server answers, if only with an Error Response.
+func (c *Client) RoundTrip() (time.Duration, error) {
+	txBuf, err := c.acquireTxBuf()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { c.releaseTxBuf(txBuf) }()
+
+	req := FindInformationRequest(txBuf[:5])
+	req.SetAttributeOpcode()
+	req.SetStartingHandle(0x0001)
+	req.SetEndingHandle(0x0001)
+
+	if _, err := c.sendReq(req); err != nil {
+		return 0, err
+	}
+	c.muReq.Lock()
+	defer c.muReq.Unlock()
+	return c.rtt, nil
+}
+
 // // HandleInformationList ...
 // type HandleInformationList []byte
 //
@@ -179,13 +404,17 @@ func (c *Client) FindInformation(starth, endh uint16) (fmt int, data []byte, err
 // ReadByType obtains the values of attributes where the attribute type is known
 // but the handle is not known. [Vol 3, Part F, 3.4.4.1 & 3.4.4.2]
 func (c *Client) ReadByType(starth, endh uint16, uuid ble.UUID) (int, []byte, error) {
+	uuid = uuid.ATTForm()
 	if starth > endh || (len(uuid) != 2 && len(uuid) != 16) {
 		return 0, nil, ErrInvalidArgument
 	}
 
 	// Acquire and reuse the txBuf, and release it after usage.
-	txBuf := <-c.chTxBuf
-	defer func() { c.chTxBuf <- txBuf }()
+	txBuf, err := c.acquireTxBuf()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { c.releaseTxBuf(txBuf) }()
 
 	req := ReadByTypeRequest(txBuf[:5+len(uuid)])
 	req.SetAttributeOpcode()
@@ -216,10 +445,16 @@ func (c *Client) ReadByType(starth, endh uint16, uuid ble.UUID) (int, []byte, er
 // Read requests the server to read the value of an attribute and return its
 // value in a Read Response. [Vol 3, Part F, 3.4.4.3 & 3.4.4.4]
 func (c *Client) Read(handle uint16) ([]byte, error) {
+	if handle == 0 {
+		return nil, ErrInvalidHandle
+	}
 
 	// Acquire and reuse the txBuf, and release it after usage.
-	txBuf := <-c.chTxBuf
-	defer func() { c.chTxBuf <- txBuf }()
+	txBuf, err := c.acquireTxBuf()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { c.releaseTxBuf(txBuf) }()
 
 	req := ReadRequest(txBuf[:3])
 	req.SetAttributeOpcode()
@@ -249,10 +484,16 @@ func (c *Client) Read(handle uint16) ([]byte, error) {
 // given offset and return a specific part of the value in a Read Blob Response.
 // [Vol 3, Part F, 3.4.4.5 & 3.4.4.6]
 func (c *Client) ReadBlob(handle, offset uint16) ([]byte, error) {
+	if handle == 0 {
+		return nil, ErrInvalidHandle
+	}
 
 	// Acquire and reuse the txBuf, and release it after usage.
-	txBuf := <-c.chTxBuf
-	defer func() { c.chTxBuf <- txBuf }()
+	txBuf, err := c.acquireTxBuf()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { c.releaseTxBuf(txBuf) }()
 
 	req := ReadBlobRequest(txBuf[:5])
 	req.SetAttributeOpcode()
@@ -290,10 +531,18 @@ func (c *Client) ReadMultiple(handles []uint16) ([]byte, error) {
 	if len(handles) < 2 || len(handles)*2 > c.l2c.TxMTU()-1 {
 		return nil, ErrInvalidArgument
 	}
+	for _, h := range handles {
+		if h == 0 {
+			return nil, ErrInvalidHandle
+		}
+	}
 
 	// Acquire and reuse the txBuf, and release it after usage.
-	txBuf := <-c.chTxBuf
-	defer func() { c.chTxBuf <- txBuf }()
+	txBuf, err := c.acquireTxBuf()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { c.releaseTxBuf(txBuf) }()
 
 	req := ReadMultipleRequest(txBuf[:1+len(handles)*2])
 	req.SetAttributeOpcode()
@@ -327,13 +576,17 @@ func (c *Client) ReadMultiple(handles []uint16) ([]byte, error) {
 // the type of a grouping attribute as defined by a higher layer specification, but
 // the handle is not known. [Vol 3, Part F, 3.4.4.9 & 3.4.4.10]
 func (c *Client) ReadByGroupType(starth, endh uint16, uuid ble.UUID) (int, []byte, error) {
+	uuid = uuid.ATTForm()
 	if starth > endh || (len(uuid) != 2 && len(uuid) != 16) {
 		return 0, nil, ErrInvalidArgument
 	}
 
 	// Acquire and reuse the txBuf, and release it after usage.
-	txBuf := <-c.chTxBuf
-	defer func() { c.chTxBuf <- txBuf }()
+	txBuf, err := c.acquireTxBuf()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { c.releaseTxBuf(txBuf) }()
 
 	req := ReadByGroupTypeRequest(txBuf[:5+len(uuid)])
 	req.SetAttributeOpcode()
@@ -364,16 +617,88 @@ func (c *Client) ReadByGroupType(starth, endh uint16, uuid ble.UUID) (int, []byt
 	return int(rsp.Length()), rsp.AttributeDataList(), nil
 }
 
+// ReadByTypeEach issues Read By Type Requests across the handles starth to
+// endh, and calls f with the handle and the value of each attribute of type
+// uuid found, in order, until the range is exhausted or f returns false. The
+// value is only valid during the call. The iteration ends without error once
+// the server finds no more attributes.
+func (c *Client) ReadByTypeEach(starth, endh uint16, uuid ble.UUID, f func(h uint16, v []byte) bool) error {
+	for starth <= endh {
+		length, b, err := c.ReadByType(starth, endh, uuid)
+		if err == ble.ErrAttrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if length < 2 {
+			return ErrInvalidResponse
+		}
+		for ; len(b) != 0; b = b[length:] {
+			h := binary.LittleEndian.Uint16(b[:2])
+			if h < starth {
+				// The server doesn't advance through the range.
+				return ErrInvalidResponse
+			}
+			if !f(h, b[2:length]) || h >= endh {
+				return nil
+			}
+			starth = h + 1
+		}
+	}
+	return nil
+}
+
+// ReadByGroupTypeEach issues Read By Group Type Requests across the handles
+// starth to endh, and calls f with the handle, the end group handle and the
+// value of each grouping attribute of type uuid found, in order, until the
+// range is exhausted or f returns false. The value is only valid during the
+// call. The iteration ends without error once the server finds no more
+// attributes.
+func (c *Client) ReadByGroupTypeEach(starth, endh uint16, uuid ble.UUID, f func(h, endGroup uint16, v []byte) bool) error {
+	for starth <= endh {
+		length, b, err := c.ReadByGroupType(starth, endh, uuid)
+		if err == ble.ErrAttrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if length < 4 {
+			return ErrInvalidResponse
+		}
+		for ; len(b) != 0; b = b[length:] {
+			h := binary.LittleEndian.Uint16(b[:2])
+			endGroup := binary.LittleEndian.Uint16(b[2:4])
+			if h < starth || endGroup < h {
+				// The server doesn't advance through the range.
+				return ErrInvalidResponse
+			}
+			if !f(h, endGroup, b[4:length]) || endGroup >= endh {
+				return nil
+			}
+			starth = endGroup + 1
+		}
+	}
+	return nil
+}
+
 // Write requests the server to write the value of an attribute and acknowledge that
 // this has been achieved in a Write Response. [Vol 3, Part F, 3.4.5.1 & 3.4.5.2]
 func (c *Client) Write(handle uint16, value []byte) error {
-	if len(value) > c.l2c.TxMTU()-3 {
+	if handle == 0 {
+		return ErrInvalidHandle
+	}
+	if len(value) > ble.MaxValueLen(c.l2c.TxMTU(), ble.WriteOverhead) {
 		return ErrInvalidArgument
 	}
 
 	// Acquire and reuse the txBuf, and release it after usage.
-	txBuf := <-c.chTxBuf
-	defer func() { c.chTxBuf <- txBuf }()
+	txBuf, err := c.acquireTxBuf()
+	if err != nil {
+		return err
+	}
+	defer func() { c.releaseTxBuf(txBuf) }()
 
 	req := WriteRequest(txBuf[:3+len(value)])
 	req.SetAttributeOpcode()
@@ -401,13 +726,16 @@ func (c *Client) Write(handle uint16, value []byte) error {
 // WriteCommand requests the server to write the value of an attribute, typically
 // into a control-point attribute. [Vol 3, Part F, 3.4.5.3]
 func (c *Client) WriteCommand(handle uint16, value []byte) error {
-	if len(value) > c.l2c.TxMTU()-3 {
+	if handle == 0 {
+		return ErrInvalidHandle
+	}
+	if len(value) > ble.MaxValueLen(c.l2c.TxMTU(), ble.WriteOverhead) {
 		return ErrInvalidArgument
 	}
 
 	// Acquire and reuse the txBuf, and release it after usage.
 	txBuf := <-c.chTxBuf
-	defer func() { c.chTxBuf <- txBuf }()
+	defer func() { c.releaseTxBuf(txBuf) }()
 
 	req := WriteCommand(txBuf[:3+len(value)])
 	req.SetAttributeOpcode()
@@ -420,13 +748,16 @@ func (c *Client) WriteCommand(handle uint16, value []byte) error {
 // SignedWrite requests the server to write the value of an attribute with an authentication
 // signature, typically into a control-point attribute. [Vol 3, Part F, 3.4.5.4]
 func (c *Client) SignedWrite(handle uint16, value []byte, signature [12]byte) error {
-	if len(value) > c.l2c.TxMTU()-15 {
+	if handle == 0 {
+		return ErrInvalidHandle
+	}
+	if len(value) > ble.MaxValueLen(c.l2c.TxMTU(), ble.SignedWriteOverhead) {
 		return ErrInvalidArgument
 	}
 
 	// Acquire and reuse the txBuf, and release it after usage.
 	txBuf := <-c.chTxBuf
-	defer func() { c.chTxBuf <- txBuf }()
+	defer func() { c.releaseTxBuf(txBuf) }()
 
 	req := SignedWriteCommand(txBuf[:15+len(value)])
 	req.SetAttributeOpcode()
@@ -442,18 +773,25 @@ func (c *Client) SignedWrite(handle uint16, value []byte, signature [12]byte) er
 // the Client can verify that the value was received correctly.
 // [Vol 3, Part F, 3.4.6.1 & 3.4.6.2]
 func (c *Client) PrepareWrite(handle uint16, offset uint16, value []byte) (uint16, uint16, []byte, error) {
-	if len(value) > c.l2c.TxMTU()-5 {
+	if handle == 0 {
+		return 0, 0, nil, ErrInvalidHandle
+	}
+	if len(value) > ble.MaxValueLen(c.l2c.TxMTU(), ble.PrepareWriteOverhead) {
 		return 0, 0, nil, ErrInvalidArgument
 	}
 
 	// Acquire and reuse the txBuf, and release it after usage.
-	txBuf := <-c.chTxBuf
-	defer func() { c.chTxBuf <- txBuf }()
+	txBuf, err := c.acquireTxBuf()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer func() { c.releaseTxBuf(txBuf) }()
 
 	req := PrepareWriteRequest(txBuf[:5+len(value)])
 	req.SetAttributeOpcode()
 	req.SetAttributeHandle(handle)
 	req.SetValueOffset(offset)
+	req.SetPartAttributeValue(value)
 
 	b, err := c.sendReq(req)
 	if err != nil {
@@ -481,10 +819,13 @@ func (c *Client) PrepareWrite(handle uint16, offset uint16, value []byte) (uint1
 func (c *Client) ExecuteWrite(flags uint8) error {
 
 	// Acquire and reuse the txBuf, and release it after usage.
-	txBuf := <-c.chTxBuf
-	defer func() { c.chTxBuf <- txBuf }()
+	txBuf, err := c.acquireTxBuf()
+	if err != nil {
+		return err
+	}
+	defer func() { c.releaseTxBuf(txBuf) }()
 
-	req := ExecuteWriteRequest(txBuf[:1])
+	req := ExecuteWriteRequest(txBuf[:2])
 	req.SetAttributeOpcode()
 	req.SetFlags(flags)
 
@@ -498,7 +839,7 @@ func (c *Client) ExecuteWrite(flags uint8) error {
 	switch {
 	case rsp[0] == ErrorResponseCode && len(rsp) == 5:
 		return ble.ATTError(rsp[4])
-	case rsp[0] == ErrorResponseCode && len(rsp) == 5:
+	case rsp[0] == ErrorResponseCode && len(rsp) != 5:
 		fallthrough
 	case rsp[0] != rsp.AttributeOpcode():
 		return ErrInvalidResponse
@@ -507,19 +848,44 @@ func (c *Client) ExecuteWrite(flags uint8) error {
 }
 
 func (c *Client) sendCmd(b []byte) error {
+	c.throttle()
 	_, err := c.l2c.Write(b)
 	return err
 }
 
 func (c *Client) sendReq(b []byte) (rsp []byte, err error) {
+	c.throttle()
 	c.Debugf("req: %x", b)
+	cancel := make(chan struct{})
+	c.muReq.Lock()
+	c.req, c.reqSince, c.cancelReq, c.answered = b, time.Now(), cancel, false
+	c.reqBusy = nil
+	if c.disp != nil {
+		c.reqBusy = c.disp.running()
+	}
+	c.muReq.Unlock()
+	defer func() {
+		c.muReq.Lock()
+		c.req, c.cancelReq = nil, nil
+		c.muReq.Unlock()
+	}()
 	if _, err := c.l2c.Write(b); err != nil {
 		return nil, fmt.Errorf("send ATT request failed: %w", err)
 	}
 	for {
 		select {
 		case rsp := <-c.rspc:
+			if c.dropStale(rsp) {
+				continue
+			}
 			if rsp[0] == ErrorResponseCode || rsp[0] == rspOfReq[b[0]] {
+				c.muReq.Lock()
+				at := c.rspAt
+				if at.IsZero() {
+					at = time.Now()
+				}
+				c.rtt = at.Sub(c.reqSince)
+				c.muReq.Unlock()
 				return rsp, nil
 			}
 			// Sometimes when we connect to an Apple device, it sends
@@ -534,7 +900,10 @@ func (c *Client) sendReq(b []byte) (rsp []byte, err error) {
 			}
 		case err := <-c.chErr:
 			return nil, fmt.Errorf("ATT request failed: %w", err)
-		case <-time.After(2 * time.Second):
+		case <-cancel:
+			return nil, ErrRequestCanceled
+		case <-time.After(c.reqTimeout):
+			c.expireRequest()
 			return nil, fmt.Errorf("ATT request timeout: %w", ErrSeqProtoTimeout)
 		}
 	}
@@ -544,7 +913,7 @@ func (c *Client) sendReq(b []byte) (rsp []byte, err error) {
 func (c *Client) sendResp(rsp []byte) error {
 	// Acquire and reuse the txBuf, and release it after usage.
 	txBuf := <-c.chTxBuf
-	defer func() { c.chTxBuf <- txBuf }()
+	defer func() { c.releaseTxBuf(txBuf) }()
 	if c.l2c == nil {
 		return fmt.Errorf("ble conn was nil")
 	}
@@ -556,6 +925,11 @@ func (c *Client) sendResp(rsp []byte) error {
 }
 
 func (c *Client) asyncReqLoop() {
+	defer func() {
+		if s := c.Server(); s != nil {
+			s.cleanup()
+		}
+	}()
 	for {
 		// keep trying?
 		select {
@@ -573,8 +947,23 @@ func (c *Client) asyncReqLoop() {
 			//ok
 		}
 
-		in := <-c.inc
-		rsp := c.server.HandleRequest(in)
+		in, ok := <-c.inc
+		if !ok {
+			c.Debug("exited async loop: loop closed")
+			return
+		}
+		s := c.Server()
+		if s == nil {
+			// Without a local GATT server, e.g. on a central-only device,
+			// requests are refused rather than left to time out.
+			if _, ok := rspOfReq[in[0]]; ok {
+				if err := c.sendResp(newErrorResponse(in[0], 0x0000, ble.ErrReqNotSupp)); err != nil {
+					c.Errorf("failed to refuse att request %x: %v", in[0], err)
+				}
+			}
+			continue
+		}
+		rsp := s.HandleRequest(in)
 		if rsp == nil {
 			continue
 		}
@@ -587,27 +976,40 @@ func (c *Client) asyncReqLoop() {
 
 // Loop ...
 func (c *Client) Loop() {
+	defer close(c.closed)
+	// No response comes anymore, so the requests waiting behind a canceled
+	// one fail at once.
+	defer func() {
+		c.muReq.Lock()
+		c.settleOwed()
+		c.muReq.Unlock()
+	}()
 
-	type asyncWork struct {
-		handle func([]byte)
-		data   []byte
+	h := func(req []byte, _ time.Time) { c.handler.HandleNotification(req) }
+	if th, ok := c.handler.(TimestampedNotificationHandler); ok {
+		h = th.HandleTimestampedNotification
 	}
-
-	ch := make(chan asyncWork, 16)
-	defer close(ch)
-	go func() {
-		for w := range ch {
-			w.handle(w.data)
+	d := newDispatcher(&c.g, c.workers, h)
+	defer d.close()
+	c.muReq.Lock()
+	c.disp = d
+	c.muReq.Unlock()
+
+	// The responses ordered after notifications wait for them apart, so
+	// the handlers may issue requests.
+	orderq := make(chan orderedRsp, 1)
+	defer close(orderq)
+	c.g.Go(func() {
+		for r := range orderq {
+			d.wait(r.m, r.busy)
+			c.deliverRsp(r.b, r.cancel)
 		}
-	}()
+	})
+	tr, _ := c.l2c.(timestampedReader)
 
-	//start up async response handling
-	if c.server != nil {
-		go c.asyncReqLoop()
-		defer func() {
-			close(c.inc)
-		}()
-	}
+	// Start up async response handling. A server may be set at any time.
+	c.g.Go(c.asyncReqLoop)
+	defer close(c.inc)
 
 	confirmation := []byte{HandleValueConfirmationCode}
 	for {
@@ -627,7 +1029,18 @@ func (c *Client) Loop() {
 			//ok
 		}
 
-		n, err := c.l2c.Read(c.rxBuf)
+		buf := rxPool.Get().(*[]byte)
+		var n int
+		var at time.Time
+		var err error
+		if tr != nil {
+			n, at, err = tr.ReadTimestamped(*buf)
+		} else {
+			n, err = c.l2c.Read(*buf)
+		}
+		if err != nil || n == 0 {
+			rxPool.Put(buf)
+		}
 		// keep trying?
 		select {
 		case <-c.done:
@@ -655,13 +1068,29 @@ func (c *Client) Loop() {
 			}
 			//ok
 		}
+		if n == 0 {
+			continue
+		}
 
-		b := make([]byte, n)
-		copy(b, c.rxBuf)
-		c.Debugf("rx: %x", b)
+		// Requests and responses are kept by their handlers and callers,
+		// so they get buffers of their own. Notifications aren't logged,
+		// as the arguments of a log call are allocated even if the level
+		// discards it.
+		b := (*buf)[:n]
+		if b[0] != HandleValueNotificationCode && b[0] != HandleValueIndicationCode {
+			b = append([]byte(nil), b...)
+			rxPool.Put(buf)
+			c.Debugf("rx: %x", b)
+		}
 
 		//all incoming requests are even numbered
 		//which means the last bit should be 0
+		if b[0] == HandleValueConfirmationCode {
+			if s := c.Server(); s != nil {
+				s.confirm()
+			}
+			continue
+		}
 		if b[0]&0x01 == 0x00 {
 			select {
 			case <-c.done:
@@ -679,21 +1108,40 @@ func (c *Client) Loop() {
 		}
 
 		if (b[0] != HandleValueNotificationCode) && (b[0] != HandleValueIndicationCode) {
-			c.Debugf("a rx: %x", c.rxBuf[:n])
-			select {
-			case <-c.done:
-				c.Info("exited client loop: closed after rsp rx")
-				return
-			case <-c.connClosed:
-				c.Debug("exited client async loop: conn closed")
-				return
-			case c.rspc <- b:
+			c.Debugf("a rx: %x", b)
+			if c.dropStale(b) {
+				c.Debugf("dropped the response of a canceled request")
 				continue
 			}
+			cancel, ok := c.correlate(b)
+			if !ok {
+				c.Debugf("dropped a response without its request")
+				continue
+			}
+			c.muReq.Lock()
+			c.rspAt = at
+			c.muReq.Unlock()
+			if m, busy, ok := c.orderMark(d); ok {
+				select {
+				case <-c.done:
+					c.Info("exited client loop: closed after rsp rx")
+					return
+				case <-c.connClosed:
+					c.Debug("exited client async loop: conn closed")
+					return
+				case orderq <- orderedRsp{b: b, cancel: cancel, m: m, busy: busy}:
+					continue
+				}
+			}
+			if !c.deliverRsp(b, cancel) {
+				return
+			}
+			continue
 		}
 
-		// Deliver the full request to upper layer.
-		c.Debugf("notif: %x", b)
+		// Deliver the full request to upper layer. The buffer is recycled
+		// once it's handled, so don't touch b after this.
+		indication := b[0] == HandleValueIndicationCode
 		select {
 		case <-c.done:
 			c.Info("exited async loop: closed after rx")
@@ -701,15 +1149,16 @@ func (c *Client) Loop() {
 		case <-c.connClosed:
 			c.Debug("exited async loop: conn closed")
 			return
-		case ch <- asyncWork{handle: c.handler.HandleNotification, data: b}:
-			// ok
 		default:
-			// If this really happens, especially on a slow machine, enlarge the channel buffer.
-			c.Error("can't enqueue incoming notification.")
+			if !d.dispatch(notification{data: b, buf: buf, at: at}) {
+				// If this really happens, especially on a slow machine, add workers.
+				c.Error("can't enqueue incoming notification.")
+				rxPool.Put(buf)
+			}
 		}
 
 		// Always write aknowledgement for an indication, even it was an invalid request.
-		if b[0] == HandleValueIndicationCode {
+		if indication {
 			c.Debugf("write confirmation for indication")
 			_, _ = c.l2c.Write(confirmation)
 		}
@@ -0,0 +1,604 @@
+package att
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/lifecycle"
+)
+
+// bearer is an L2CAP bearer which receives the PDUs sent on rx, and sends
+// the PDUs written on tx, if set.
+type bearer struct {
+	ble.Conn
+	rx   chan []byte
+	tx   chan []byte
+	disc chan struct{}
+	ctx  context.Context
+}
+
+func newBearer() *bearer {
+	return &bearer{rx: make(chan []byte), disc: make(chan struct{}), ctx: ble.WithConnValues(context.Background())}
+}
+
+func (c *bearer) Context() context.Context       { return c.ctx }
+func (c *bearer) SetContext(ctx context.Context) { c.ctx = ctx }
+
+func (c *bearer) Read(b []byte) (int, error) {
+	p, ok := <-c.rx
+	if !ok {
+		return 0, io.ErrClosedPipe
+	}
+	return copy(b, p), nil
+}
+
+func (c *bearer) Write(b []byte) (int, error) {
+	if c.tx != nil {
+		c.tx <- append([]byte(nil), b...)
+	}
+	return len(b), nil
+}
+
+func (c *bearer) TxMTU() int                    { return 23 }
+func (c *bearer) RxMTU() int                    { return ble.MaxMTU }
+func (c *bearer) Disconnected() <-chan struct{} { return c.disc }
+func (c *bearer) SetTxMTU(int)                  {}
+func (c *bearer) SetRxMTU(int)                  {}
+
+type handlerFunc func(req []byte)
+
+func (f handlerFunc) HandleNotification(req []byte) { f(req) }
+
+func notificationPDU(i byte) []byte {
+	return []byte{HandleValueNotificationCode, 0x10, 0x00, i, i, i}
+}
+
+func TestClientNotificationBuffers(t *testing.T) {
+	c0 := newBearer()
+	got := make(chan []byte, 100)
+	c := NewClient(c0, handlerFunc(func(req []byte) {
+		if !bytes.Equal(req, notificationPDU(req[3])) {
+			t.Errorf("notification = % X", req)
+		}
+		got <- append([]byte(nil), req...)
+	}), make(chan bool), ble.GetLogger())
+	go c.Loop()
+	defer close(c0.rx)
+
+	// A response is kept by the caller, while notifications arriving
+	// after it reuse the buffers.
+	rsp := make(chan []byte)
+	go func() {
+		b, err := c.Read(0x0010)
+		if err != nil {
+			t.Error(err)
+		}
+		rsp <- b
+	}()
+	c0.rx <- []byte{ReadResponseCode, 0xAA, 0xBB}
+	b := <-rsp
+	for i := 0; i < 50; i++ {
+		// Notifications overflowing the queue are dropped, so wait for
+		// each to be handled.
+		c0.rx <- notificationPDU(byte(i))
+		if n := <-got; n[3] != byte(i) {
+			t.Fatalf("notification %d = % X", i, n)
+		}
+	}
+	if !bytes.Equal(b, []byte{0xAA, 0xBB}) {
+		t.Errorf("response = % X, overwritten by notifications", b)
+	}
+}
+
+func BenchmarkClientNotifications(b *testing.B) {
+	c0 := newBearer()
+	done := make(chan struct{})
+	c := NewClient(c0, handlerFunc(func(req []byte) { done <- struct{}{} }), make(chan bool), ble.GetLogger())
+	go c.Loop()
+	defer close(c0.rx)
+
+	n := notificationPDU(1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c0.rx <- n
+		<-done
+	}
+}
+
+func TestClientNotificationWorkers(t *testing.T) {
+	c0 := newBearer()
+	block := make(chan struct{})
+	got := make(chan []byte, 100)
+	c := NewClient(c0, handlerFunc(func(req []byte) {
+		if req[1] == 0x10 && req[3] == 0 {
+			<-block
+		}
+		got <- append([]byte(nil), req...)
+	}), make(chan bool), ble.GetLogger())
+	c.SetNotificationWorkers(2)
+	go c.Loop()
+	defer close(c0.rx)
+
+	// The handler of 0x0010 is stuck, while those of 0x0011 go on.
+	c0.rx <- notificationPDU(0)
+	for i := byte(0); i < 5; i++ {
+		c0.rx <- []byte{HandleValueNotificationCode, 0x11, 0x00, i}
+		if n := <-got; n[1] != 0x11 || n[3] != i {
+			t.Fatalf("notification = % X, want 0x0011 #%d", n, i)
+		}
+	}
+
+	// Those of 0x0010 are still handled in order.
+	for i := byte(1); i < 5; i++ {
+		c0.rx <- notificationPDU(i)
+	}
+	close(block)
+	for i := byte(0); i < 5; i++ {
+		if n := <-got; n[1] != 0x10 || n[3] != i {
+			t.Fatalf("notification = % X, want 0x0010 #%d", n, i)
+		}
+	}
+}
+
+func TestClientWaitClosed(t *testing.T) {
+	// Goroutines left by the other tests can only end meanwhile.
+	n := lifecycle.Running()
+
+	c0 := newBearer()
+	c := NewClient(c0, handlerFunc(func(req []byte) {}), make(chan bool), ble.GetLogger())
+	c.WithServer(NewDB(nil, 1, ble.GetLogger()))
+	c.SetNotificationWorkers(4)
+	loop := make(chan struct{})
+	go func() {
+		c.Loop()
+		close(loop)
+	}()
+	c0.rx <- notificationPDU(0)
+	c0.rx <- []byte{ReadRequestCode, 0x01, 0x00}
+
+	// The bearer fails without a disconnection.
+	close(c0.rx)
+	<-loop
+	closed := make(chan struct{})
+	go func() {
+		c.WaitClosed()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("goroutines still running after Loop returned")
+	}
+	if r := lifecycle.Running(); r > n {
+		t.Errorf("%d goroutines leaked", r-n)
+	}
+}
+
+func TestClientServer(t *testing.T) {
+	c0 := newBearer()
+	c0.tx = make(chan []byte, 10)
+	c := NewClient(c0, handlerFunc(func(req []byte) {}), make(chan bool), ble.GetLogger())
+	go c.Loop()
+	defer close(c0.rx)
+	exchange := func(req, want []byte) {
+		t.Helper()
+		c0.rx <- req
+		if rsp := <-c0.tx; !bytes.Equal(rsp, want) {
+			t.Fatalf("response to % X = % X, want % X", req, rsp, want)
+		}
+	}
+
+	// Without a database, requests are refused.
+	exchange([]byte{ReadRequestCode, 0x03, 0x00}, []byte{ErrorResponseCode, ReadRequestCode, 0x00, 0x00, byte(ble.ErrReqNotSupp)})
+
+	svc := ble.NewService(ble.UUID16(0xFF00))
+	chr := svc.NewCharacteristic(ble.UUID16(0xFF01))
+	chr.SetValue([]byte("hi"))
+	chr.HandleIndicate(ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {}))
+	c.WithServer(NewDB([]*ble.Service{svc}, 1, ble.GetLogger()))
+	accesses := make(chan Access, 10)
+	c.SetServerAccessHandler(func(a Access) { accesses <- a })
+
+	vh, cccd := chr.ValueHandle, chr.CCCD.Handle
+	exchange([]byte{ReadRequestCode, byte(vh), 0x00}, []byte{ReadResponseCode, 'h', 'i'})
+	if a := <-accesses; a.Opcode != ReadRequestCode || a.Handle != vh || !a.UUID.Equal(chr.UUID) || a.Err != 0 {
+		t.Fatalf("access %+v", a)
+	}
+
+	// Indications, once the peer subscribed.
+	if err := c.Server().Notify(chr, true, []byte{0x01}); err != ErrNotSubscribed {
+		t.Fatalf("indicated before subscription: %v", err)
+	}
+	exchange([]byte{WriteRequestCode, byte(cccd), 0x00, 0x02, 0x00}, []byte{WriteResponseCode})
+	<-accesses
+	if err := c.Server().Notify(chr, false, []byte{0x01}); err != ErrNotSubscribed {
+		t.Fatalf("notified while subscribed to indications: %v", err)
+	}
+	done := make(chan error)
+	go func() { done <- c.Server().Notify(chr, true, []byte{0x01}) }()
+	if ind := <-c0.tx; !bytes.Equal(ind, []byte{HandleValueIndicationCode, byte(vh), 0x00, 0x01}) {
+		t.Fatalf("indication % X", ind)
+	}
+	c0.rx <- []byte{HandleValueConfirmationCode}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Another database replaces it, and resets the subscriptions.
+	other := ble.NewService(ble.UUID16(0xFF00))
+	other.NewCharacteristic(ble.UUID16(0xFF02)).SetValue([]byte("ho"))
+	c.WithServer(NewDB([]*ble.Service{other}, 1, ble.GetLogger()))
+	exchange([]byte{ReadRequestCode, byte(vh), 0x00}, []byte{ReadResponseCode, 'h', 'o'})
+	if err := c.Server().Notify(chr, true, []byte{0x01}); err != ErrNotSubscribed {
+		t.Fatalf("indicated after the database was replaced: %v", err)
+	}
+}
+
+func TestClientNotificationOrdering(t *testing.T) {
+	c0 := newBearer()
+	c0.tx = make(chan []byte, 10)
+	block := []chan struct{}{make(chan struct{}), make(chan struct{})}
+	handled := make(chan byte, 10)
+	c := NewClient(c0, handlerFunc(func(req []byte) {
+		<-block[req[3]]
+		handled <- req[3]
+	}), make(chan bool), ble.GetLogger())
+	c.SetNotificationWorkers(2)
+	c.SetNotificationOrdering(true)
+	go c.Loop()
+	defer close(c0.rx)
+
+	// The server notifies 0x0010 before each response.
+	go func() {
+		for i := byte(0); i < 2; i++ {
+			req := <-c0.tx
+			c0.rx <- notificationPDU(i)
+			c0.rx <- []byte{ReadResponseCode, req[1]}
+		}
+	}()
+
+	// A read of another characteristic, handled by another worker, doesn't
+	// wait for the notification.
+	if v, err := c.Read(0x0011); err != nil || !bytes.Equal(v, []byte{0x11}) {
+		t.Fatalf("read 0x0011 = % X, %v", v, err)
+	}
+	close(block[0])
+	<-handled
+
+	// A read of 0x0010 returns once its notifications are handled.
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Read(0x0010)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("read 0x0010 returned before the notification was handled: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(block[1])
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 1 {
+		t.Fatalf("%d notifications handled before the read returned, want 1", len(handled))
+	}
+}
+
+func TestClientNotificationOrderingHandlerRead(t *testing.T) {
+	c0 := newBearer()
+	c0.tx = make(chan []byte, 10)
+	var c *Client
+	reads := make(chan error, 1)
+	c = NewClient(c0, handlerFunc(func(req []byte) {
+		// The read waits for nothing but this handler, which doesn't
+		// return before it.
+		_, err := c.Read(0x0010)
+		reads <- err
+	}), make(chan bool), ble.GetLogger())
+	c.SetNotificationOrdering(true)
+	go c.Loop()
+	defer close(c0.rx)
+
+	c0.rx <- notificationPDU(0)
+	req := <-c0.tx
+	c0.rx <- []byte{ReadResponseCode, req[1]}
+	select {
+	case err := <-reads:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a read of a notification handler deadlocked")
+	}
+}
+
+func TestClientInvalidHandle(t *testing.T) {
+	c0 := newBearer()
+	c0.tx = make(chan []byte, 10)
+	c := NewClient(c0, handlerFunc(func(req []byte) {}), make(chan bool), ble.GetLogger())
+	go c.Loop()
+	defer close(c0.rx)
+
+	errs := []error{
+		c.Write(0, []byte{1}),
+		c.WriteCommand(0, []byte{1}),
+		c.SignedWrite(0, []byte{1}, [12]byte{}),
+	}
+	_, err := c.Read(0)
+	errs = append(errs, err)
+	_, err = c.ReadBlob(0, 1)
+	errs = append(errs, err)
+	_, err = c.ReadMultiple([]uint16{1, 0})
+	errs = append(errs, err)
+	_, _, _, err = c.PrepareWrite(0, 0, []byte{1})
+	errs = append(errs, err)
+	for i, err := range errs {
+		if err != ErrInvalidHandle {
+			t.Errorf("request %d: %v, want ErrInvalidHandle", i, err)
+		}
+	}
+	if len(c0.tx) != 0 {
+		t.Fatalf("%d requests sent for the handle 0x0000", len(c0.tx))
+	}
+}
+
+func TestReadByTypeEach(t *testing.T) {
+	c0 := newBearer()
+	c0.tx = make(chan []byte, 10)
+	c := NewClient(c0, handlerFunc(func(req []byte) {}), make(chan bool), ble.GetLogger())
+	go c.Loop()
+	defer close(c0.rx)
+
+	// The server answers with two attributes, then one, then none.
+	go func() {
+		for _, rsp := range [][]byte{
+			{ReadByTypeResponseCode, 0x04, 0x02, 0x00, 'a', 'b', 0x05, 0x00, 'c', 'd'},
+			{ReadByTypeResponseCode, 0x04, 0x08, 0x00, 'e', 'f'},
+			{ErrorResponseCode, ReadByTypeRequestCode, 0x09, 0x00, byte(ble.ErrAttrNotFound)},
+		} {
+			<-c0.tx
+			c0.rx <- rsp
+		}
+	}()
+	var got []string
+	err := c.ReadByTypeEach(0x0001, 0x0010, ble.UUID16(0x2A00), func(h uint16, v []byte) bool {
+		got = append(got, fmt.Sprintf("%04X=%s", h, v))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[0002=ab 0005=cd 0008=ef]"; fmt.Sprint(got) != want {
+		t.Fatalf("attributes %v, want %v", got, want)
+	}
+
+	// The iteration stops once f returns false, without more requests.
+	go func() {
+		req := <-c0.tx
+		if req[0] != ReadByGroupTypeRequestCode || req[1] != 0x01 {
+			t.Errorf("request % X", req)
+		}
+		c0.rx <- []byte{ReadByGroupTypeResponseCode, 0x06, 0x01, 0x00, 0x04, 0x00, 0x00, 0x18, 0x05, 0x00, 0x09, 0x00, 0x01, 0x18}
+	}()
+	got = nil
+	err = c.ReadByGroupTypeEach(0x0001, 0xFFFF, ble.PrimaryServiceUUID, func(h, endGroup uint16, v []byte) bool {
+		got = append(got, fmt.Sprintf("%04X-%04X=% X", h, endGroup, v))
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[0001-0004=00 18]"; fmt.Sprint(got) != want {
+		t.Fatalf("groups %v, want %v", got, want)
+	}
+	select {
+	case req := <-c0.tx:
+		t.Fatalf("request % X after the iteration stopped", req)
+	default:
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	t0 := time.Unix(100, 0)
+	ms := func(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+
+	// Two per second: a burst of two, then one every 500 ms.
+	l := rateLimiter{rate: 2}
+	for i, want := range []time.Duration{0, 0, ms(500), ms(1000)} {
+		if d := l.reserve(t0); d != want {
+			t.Fatalf("rate: PDU %d waits %v, want %v", i, d, want)
+		}
+	}
+	if d := l.reserve(t0.Add(ms(1500))); d != 0 {
+		t.Fatalf("rate: PDU after a pause waits %v, want 0", d)
+	}
+	if d := l.reserve(t0.Add(ms(1750))); d != ms(250) {
+		t.Fatalf("rate: PDU after it waits %v, want 250ms", d)
+	}
+
+	// The spacing applies within bursts.
+	l = rateLimiter{rate: 10, spacing: ms(50)}
+	for i, want := range []time.Duration{0, ms(50), ms(100)} {
+		if d := l.reserve(t0); d != want {
+			t.Fatalf("spacing: PDU %d waits %v, want %v", i, d, want)
+		}
+	}
+
+	if d := (&rateLimiter{}).reserve(t0); d != 0 {
+		t.Fatalf("no limit: waits %v", d)
+	}
+}
+
+func TestClientStrictSequential(t *testing.T) {
+	c0 := newBearer()
+	c0.tx = make(chan []byte, 10)
+	c := NewClient(c0, handlerFunc(func(req []byte) {}), make(chan bool), ble.GetLogger())
+	c.SetStrictSequential(true)
+	go c.Loop()
+	defer close(c0.rx)
+
+	if _, ok := c.PendingRequest(); ok {
+		t.Fatal("pending request before any")
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Read(0x0010)
+		done <- err
+	}()
+	<-c0.tx
+
+	p, ok := c.PendingRequest()
+	if !ok || p.Opcode != ReadRequestCode || p.Handle != 0x0010 {
+		t.Fatalf("pending request %+v, %v", p, ok)
+	}
+	_, err := c.Read(0x0011)
+	var pe *RequestPendingError
+	if !errors.Is(err, ErrRequestPending) || !errors.As(err, &pe) || pe.Pending.Handle != 0x0010 {
+		t.Fatalf("read while pending: %v", err)
+	}
+
+	if !c.CancelPendingRequest() {
+		t.Fatal("no request canceled")
+	}
+	if err := <-done; err != ErrRequestCanceled {
+		t.Fatalf("canceled read: %v", err)
+	}
+	if c.CancelPendingRequest() {
+		t.Fatal("canceled a request twice")
+	}
+
+	// The response to the canceled read is still owed, so the bearer is
+	// busy until it arrives and is dropped. The next read gets its own.
+	if _, err := c.Read(0x0012); !errors.As(err, &pe) || pe.Pending.Handle != 0x0010 {
+		t.Fatalf("read while the canceled response is owed: %v", err)
+	}
+	c0.rx <- []byte{ReadResponseCode, 0x10}
+	for {
+		if _, ok := c.PendingRequest(); !ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		req := <-c0.tx
+		c0.rx <- []byte{ReadResponseCode, req[1]}
+	}()
+	if v, err := c.Read(0x0012); err != nil || !bytes.Equal(v, []byte{0x12}) {
+		t.Fatalf("read after cancel = % X, %v", v, err)
+	}
+	if d := c.DiscardedResponses(); d.Late != 1 {
+		t.Fatalf("discarded %+v, want 1 late", d)
+	}
+}
+
+func TestClientCancelHoldsBearer(t *testing.T) {
+	c0 := newBearer()
+	c0.tx = make(chan []byte, 10)
+	c := NewClient(c0, handlerFunc(func(req []byte) {}), make(chan bool), ble.GetLogger())
+	go c.Loop()
+	defer close(c0.rx)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Read(0x0010)
+		done <- err
+	}()
+	<-c0.tx
+	c.CancelPendingRequest()
+	if err := <-done; err != ErrRequestCanceled {
+		t.Fatalf("canceled read: %v", err)
+	}
+
+	// The next read isn't sent until the response of the canceled one
+	// arrives.
+	rd := make(chan []byte, 1)
+	go func() {
+		v, _ := c.Read(0x0012)
+		rd <- v
+	}()
+	select {
+	case req := <-c0.tx:
+		t.Fatalf("sent % X while a response is owed", req)
+	case <-time.After(50 * time.Millisecond):
+	}
+	c0.rx <- []byte{ReadResponseCode, 0x10}
+	req := <-c0.tx
+	c0.rx <- []byte{ReadResponseCode, req[1]}
+	if v := <-rd; !bytes.Equal(v, []byte{0x12}) {
+		t.Fatalf("read after cancel = % X", v)
+	}
+}
+
+func TestClientResponseTolerance(t *testing.T) {
+	c0 := newBearer()
+	c0.tx = make(chan []byte, 10)
+	c := NewClient(c0, handlerFunc(func(req []byte) {}), make(chan bool), ble.GetLogger())
+	c.SetResponseTolerance(time.Second)
+	c.reqTimeout = 50 * time.Millisecond
+	go c.Loop()
+	defer close(c0.rx)
+
+	// read reads the handle h, the peripheral sending the responses rsps.
+	read := func(h uint16, rsps ...[]byte) ([]byte, error) {
+		type result struct {
+			v   []byte
+			err error
+		}
+		res := make(chan result, 1)
+		go func() {
+			v, err := c.Read(h)
+			res <- result{v, err}
+		}()
+		<-c0.tx
+		for _, rsp := range rsps {
+			c0.rx <- rsp
+		}
+		r := <-res
+		return r.v, r.err
+	}
+	waitDiscarded := func(want DiscardedResponses) {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if c.DiscardedResponses() == want {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("discarded %+v, want %+v", c.DiscardedResponses(), want)
+	}
+
+	// A response without a request is dropped.
+	c0.rx <- []byte{ReadResponseCode, 0x01}
+	waitDiscarded(DiscardedResponses{Unsolicited: 1})
+
+	// So is a duplicate response.
+	if v, err := read(0x0010, []byte{ReadResponseCode, 0x10}, []byte{ReadResponseCode, 0x10}); err != nil || !bytes.Equal(v, []byte{0x10}) {
+		t.Fatalf("read = % X, %v", v, err)
+	}
+	waitDiscarded(DiscardedResponses{Unsolicited: 2})
+
+	// A response of another request is dropped, and the pending one gets
+	// its own.
+	if v, err := read(0x0011, []byte{WriteResponseCode}, []byte{ReadResponseCode, 0x11}); err != nil || !bytes.Equal(v, []byte{0x11}) {
+		t.Fatalf("read = % X, %v", v, err)
+	}
+	waitDiscarded(DiscardedResponses{Unsolicited: 2, Mismatched: 1})
+
+	// The late response of a request timed out doesn't answer the next
+	// request of the same opcode.
+	if _, err := read(0x0012); !errors.Is(err, ErrSeqProtoTimeout) {
+		t.Fatalf("unanswered read: %v", err)
+	}
+	if v, err := read(0x0013, []byte{ReadResponseCode, 0x12}, []byte{ReadResponseCode, 0x13}); err != nil || !bytes.Equal(v, []byte{0x13}) {
+		t.Fatalf("read after a timeout = % X, %v", v, err)
+	}
+	waitDiscarded(DiscardedResponses{Late: 1, Unsolicited: 2, Mismatched: 1})
+}
@@ -0,0 +1,122 @@
+package att
+
+import (
+	"sync"
+
+	"github.com/leso-kn/ble"
+)
+
+// Violations counts the violations of the Attribute Protocol by the clients
+// of the servers of a DB [Vol 3, Part F], e.g. to take a product through
+// Bluetooth qualification. The requests are counted by the error they're
+// answered with, whichever layer answered them.
+type Violations struct {
+	MalformedPDUs      uint64 // PDUs of an invalid length, answered with ErrInvalidPDU or dropped.
+	OversizedPDUs      uint64 // PDUs longer than the ATT_MTU.
+	InvalidHandles     uint64 // Requests answered with ErrInvalidHandle.
+	UnsupportedOpcodes uint64 // Requests and commands the server doesn't support.
+	InvalidMTUs        uint64 // Exchange MTU Requests sent again, or with a Client Rx MTU below 23.
+	InvalidGroupTypes  uint64 // Requests answered with ErrUnsuppGrpType.
+	InvalidOffsets     uint64 // Requests answered with ErrInvalidOffset.
+	InvalidLengths     uint64 // Requests answered with ErrInvalAttrValueLen.
+}
+
+// minMTU is the default and minimum ATT_MTU of LE [Vol 3, Part F, 3.2.8].
+// The servers use ble.DefaultMTU until it's exchanged, unless strict.
+const minMTU = 23
+
+type conformance struct {
+	sync.Mutex
+	strict bool
+	v      Violations
+}
+
+// SetStrict sets whether the servers of r follow the Attribute Protocol
+// strictly rather than leniently, from their next request on:
+//
+//   - Until the ATT_MTU is exchanged, it's 23 bytes, rather than
+//     ble.DefaultMTU. PDUs longer than the ATT_MTU are answered with
+//     ErrInvalidPDU, or dropped if they're commands, rather than served.
+//   - Unsupported commands are dropped, rather than answered with
+//     ErrReqNotSupp like unsupported requests.
+//   - The ATT_MTU is only exchanged once, and stays at 23 bytes if the
+//     Client Rx MTU is below, rather than answering with ErrInvalidPDU.
+//   - Read Blob Requests of static values past their end are answered with
+//     ErrInvalidOffset.
+//   - Read By Group Type Requests of types other than the service types are
+//     answered with ErrUnsuppGrpType.
+//   - Prepare queues are executed as with PrepareQueue.Strict, which answers
+//     values longer than 512 bytes with ErrInvalAttrValueLen.
+//   - Write Commands with an empty value are served.
+func (r *DB) SetStrict(on bool) {
+	r.conf.Lock()
+	r.conf.strict = on
+	r.conf.Unlock()
+}
+
+// Violations returns the violations of the Attribute Protocol by the clients
+// of the servers of r, since r was created or the counters were reset.
+func (r *DB) Violations() Violations {
+	r.conf.Lock()
+	defer r.conf.Unlock()
+	return r.conf.v
+}
+
+// ResetViolations resets the counters of Violations.
+func (r *DB) ResetViolations() {
+	r.conf.Lock()
+	r.conf.v = Violations{}
+	r.conf.Unlock()
+}
+
+func (r *DB) strict() bool {
+	r.conf.Lock()
+	defer r.conf.Unlock()
+	return r.conf.strict
+}
+
+// violation counts a violation with f.
+func (r *DB) violation(f func(v *Violations)) {
+	r.conf.Lock()
+	f(&r.conf.v)
+	r.conf.Unlock()
+}
+
+// attMTU returns the ATT_MTU, the minimum of the Client Rx MTU and the
+// Server Rx MTU, or 23 bytes until they're exchanged [Vol 3, Part F, 3.4.2].
+func (s *Server) attMTU() int {
+	switch {
+	case !s.mtuExchanged:
+		return minMTU
+	case len(s.txBuf) < s.rxMTU:
+		return len(s.txBuf)
+	}
+	return s.rxMTU
+}
+
+// countViolation counts the violation the response rsp reports, if any.
+func (s *Server) countViolation(rsp []byte) {
+	if len(rsp) != 5 || rsp[0] != ErrorResponseCode {
+		return
+	}
+	switch ble.ATTError(rsp[4]) {
+	case ble.ErrInvalidPDU:
+		s.db.violation(func(v *Violations) { v.MalformedPDUs++ })
+	case ble.ErrInvalidHandle:
+		s.db.violation(func(v *Violations) { v.InvalidHandles++ })
+	case ble.ErrReqNotSupp:
+		s.db.violation(func(v *Violations) { v.UnsupportedOpcodes++ })
+	case ble.ErrUnsuppGrpType:
+		s.db.violation(func(v *Violations) { v.InvalidGroupTypes++ })
+	case ble.ErrInvalidOffset:
+		s.db.violation(func(v *Violations) { v.InvalidOffsets++ })
+	case ble.ErrInvalAttrValueLen:
+		s.db.violation(func(v *Violations) { v.InvalidLengths++ })
+	}
+}
+
+// isCommand reports whether the opcode op is a command, which is never
+// answered [Vol 3, Part F, 3.3.1].
+func isCommand(op byte) bool {
+	return op&0x40 != 0
+}
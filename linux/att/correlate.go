@@ -0,0 +1,105 @@
+package att
+
+import "time"
+
+// DiscardedResponses counts the responses the client discarded, rather than
+// returning them as those of its requests.
+type DiscardedResponses struct {
+	// Late are the responses of the requests canceled, or timed out while
+	// SetResponseTolerance is set.
+	Late int
+
+	// Unsolicited are the responses received while no request was pending,
+	// e.g. duplicates, or the late responses of the requests timed out
+	// longer than the tolerance ago.
+	Unsolicited int
+
+	// Mismatched are the responses which don't answer the pending request,
+	// by their opcode.
+	Mismatched int
+}
+
+// staleReq is a request canceled or timed out, whose response is dropped if
+// it arrives before until.
+type staleReq struct {
+	op    byte
+	until time.Time
+}
+
+// SetResponseTolerance sets whether the responses are correlated with the
+// pending request, for peripherals which send duplicate or late responses,
+// and for how long the response of a request timed out is still expected.
+// Such responses are discarded, and counted by DiscardedResponses, instead
+// of being returned as those of the next requests. A late response arriving
+// after d may answer the next request of the same opcode; a d of zero
+// disables the correlation.
+func (c *Client) SetResponseTolerance(d time.Duration) {
+	c.muReq.Lock()
+	defer c.muReq.Unlock()
+	c.tolerance = d
+}
+
+// DiscardedResponses returns the counts of the responses discarded so far.
+func (c *Client) DiscardedResponses() DiscardedResponses {
+	c.muReq.Lock()
+	defer c.muReq.Unlock()
+	return c.discarded
+}
+
+// correlate reports whether the response rsp answers the pending request,
+// and returns the channel canceling it. Without tolerance, all responses
+// are taken as the pending request's.
+func (c *Client) correlate(rsp []byte) (<-chan struct{}, bool) {
+	c.muReq.Lock()
+	defer c.muReq.Unlock()
+	if c.tolerance <= 0 {
+		return c.cancelReq, true
+	}
+	if c.req == nil || c.answered {
+		c.discarded.Unsolicited++
+		return nil, false
+	}
+	if !answers(rsp, c.req[0]) {
+		c.discarded.Mismatched++
+		return nil, false
+	}
+	c.answered = true
+	return c.cancelReq, true
+}
+
+// expireRequest makes the pending request stale once it timed out, with
+// tolerance set, for its response to be dropped.
+func (c *Client) expireRequest() {
+	c.muReq.Lock()
+	defer c.muReq.Unlock()
+	if c.tolerance <= 0 || c.req == nil || c.cancelReq == nil {
+		return
+	}
+	close(c.cancelReq)
+	c.cancelReq = nil
+	c.stale = append(c.stale, staleReq{op: c.req[0], until: time.Now().Add(c.tolerance)})
+}
+
+// dropLate drops the response rsp of a request which was canceled or timed
+// out as it was handed to it, and the stale entry of the request, the last
+// one rsp answers.
+func (c *Client) dropLate(rsp []byte) {
+	c.muReq.Lock()
+	defer c.muReq.Unlock()
+	for i := len(c.stale) - 1; i >= 0; i-- {
+		if answers(rsp, c.stale[i].op) {
+			c.stale = append(c.stale[:i], c.stale[i+1:]...)
+			break
+		}
+	}
+	c.discarded.Late++
+	if c.owed != nil && answers(rsp, c.owed[0]) {
+		c.settleOwed()
+	}
+}
+
+// answers reports whether rsp is the response of a request with the opcode
+// op, or its error response.
+func answers(rsp []byte, op byte) bool {
+	return rsp[0] == rspOfReq[op] || (rsp[0] == ErrorResponseCode && len(rsp) > 1 && rsp[1] == op)
+}
@@ -10,6 +10,9 @@ import (
 type DB struct {
 	attrs []*attr
 	base  uint16 // handle for first attr in attrs
+	subs  *subscriptions
+	queue *queueConfig
+	conf  *conformance
 	ble.Logger
 }
 
@@ -74,7 +77,7 @@ func NewDB(ss []*ble.Service, base uint16, l ble.Logger) *DB {
 		attrs = append(attrs, aa...)
 	}
 
-	d := &DB{attrs: attrs, base: base, Logger: l}
+	d := &DB{attrs: attrs, base: base, subs: newSubscriptions(), queue: &queueConfig{}, conf: &conformance{}, Logger: l}
 	d.DumpAttributes(attrs)
 	return d
 }
@@ -83,7 +86,7 @@ func genSvcAttr(s *ble.Service, h uint16) (uint16, []*attr) {
 	a := &attr{
 		h:   h,
 		typ: ble.PrimaryServiceUUID,
-		v:   s.UUID,
+		v:   s.UUID.ATTForm(),
 	}
 	h++
 	attrs := []*attr{a}
@@ -95,6 +98,7 @@ func genSvcAttr(s *ble.Service, h uint16) (uint16, []*attr) {
 	}
 
 	a.endh = h - 1
+	s.Handle, s.EndHandle = a.h, a.endh
 	return h, attrs
 }
 
@@ -104,20 +108,22 @@ func genCharAttr(c *ble.Characteristic, h uint16) (uint16, []*attr) {
 	a := &attr{
 		h:   h,
 		typ: ble.CharacteristicUUID,
-		v:   append([]byte{byte(c.Property), byte(vh), byte((vh) >> 8)}, c.UUID...),
+		v:   append([]byte{byte(c.Property), byte(vh), byte((vh) >> 8)}, c.UUID.ATTForm()...),
 	}
 
 	va := &attr{
 		h:   vh,
-		typ: c.UUID,
+		typ: c.UUID.ATTForm(),
 		v:   c.Value,
 		rh:  c.ReadHandler,
 		wh:  c.WriteHandler,
+		c:   c,
 	}
 
 	c.Handle = h
 	c.ValueHandle = vh
-	if c.NotifyHandler != nil || c.IndicateHandler != nil {
+	// The CCCD is kept when the DB is regenerated, e.g. by AddService.
+	if c.CCCD == nil && (c.NotifyHandler != nil || c.IndicateHandler != nil) {
 		c.CCCD = newCCCD(c)
 		c.Descriptors = append(c.Descriptors, c.CCCD)
 	}
@@ -135,9 +141,10 @@ func genCharAttr(c *ble.Characteristic, h uint16) (uint16, []*attr) {
 }
 
 func genDescAttr(d *ble.Descriptor, h uint16) *attr {
+	d.Handle = h
 	return &attr{
 		h:   h,
-		typ: d.UUID,
+		typ: d.UUID.ATTForm(),
 		v:   d.Value,
 		rh:  d.ReadHandler,
 		wh:  d.WriteHandler,
@@ -162,6 +169,19 @@ const (
 	cccIndicate = 0x0002
 )
 
+// serveSubscription passes the configuration ccc of c by cn to the
+// subscription handler of c, if any.
+func serveSubscription(c *ble.Characteristic, cn *conn, ccc uint16) {
+	if c.SubscriptionHandler == nil {
+		return
+	}
+	c.SubscriptionHandler.ServeSubscription(ble.Subscription{
+		Conn:     cn,
+		Notify:   ccc&cccNotify != 0,
+		Indicate: ccc&cccIndicate != 0,
+	})
+}
+
 func newCCCD(c *ble.Characteristic) *ble.Descriptor {
 	d := ble.NewDescriptor(ble.ClientCharacteristicConfigUUID)
 
@@ -173,40 +193,59 @@ func newCCCD(c *ble.Characteristic) *ble.Descriptor {
 
 	d.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
 		cn := req.Conn().(*conn)
+		if len(req.Data()) != 2 {
+			rsp.SetStatus(ble.ErrInvalAttrValueLen)
+			return
+		}
 		old := cn.cccs[c.Handle]
 		ccc := binary.LittleEndian.Uint16(req.Data())
 
+		// Reserved bits, and bits the properties of c don't allow, are
+		// rejected before anything changes [CSS, Part B, 1.2].
+		allowed := uint16(0)
+		if c.Property&ble.CharNotify != 0 {
+			allowed |= cccNotify
+		}
+		if c.Property&ble.CharIndicate != 0 {
+			allowed |= cccIndicate
+		}
+		if ccc&^allowed != 0 {
+			rsp.SetStatus(ble.ErrCCCDImproperConf)
+			return
+		}
+
 		oldNotify := old&cccNotify != 0
 		oldIndicate := old&cccIndicate != 0
 		newNotify := ccc&cccNotify != 0
 		newIndicate := ccc&cccIndicate != 0
 
 		if newNotify && !oldNotify {
-			if c.Property&ble.CharNotify == 0 {
-				rsp.SetStatus(ble.ErrUnlikely)
-				return
-			}
 			send := func(b []byte) (int, error) { return cn.svr.notify(c.ValueHandle, b) }
 			cn.nn[c.Handle] = ble.NewNotifier(send)
-			go c.NotifyHandler.ServeNotify(req, cn.nn[c.Handle])
+			if c.NotifyHandler != nil {
+				go c.NotifyHandler.ServeNotify(req, cn.nn[c.Handle])
+			}
 		}
 		if !newNotify && oldNotify {
 			cn.nn[c.Handle].Close()
 		}
 
 		if newIndicate && !oldIndicate {
-			if c.Property&ble.CharIndicate == 0 {
-				rsp.SetStatus(ble.ErrUnlikely)
-				return
-			}
 			send := func(b []byte) (int, error) { return cn.svr.indicate(c.ValueHandle, b) }
 			cn.in[c.Handle] = ble.NewNotifier(send)
-			go c.IndicateHandler.ServeNotify(req, cn.in[c.Handle])
+			if c.IndicateHandler != nil {
+				go c.IndicateHandler.ServeNotify(req, cn.in[c.Handle])
+			}
 		}
 		if !newIndicate && oldIndicate {
 			cn.in[c.Handle].Close()
 		}
 		cn.cccs[c.Handle] = ccc
+		cn.publishCCCs()
+		cn.svr.db.subs.set(c, cn, ccc)
+		if ccc != old {
+			serveSubscription(c, cn, ccc)
+		}
 	}))
 	return d
 }
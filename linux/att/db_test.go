@@ -0,0 +1,29 @@
+package att
+
+import (
+	"testing"
+
+	"github.com/leso-kn/ble"
+)
+
+func TestDBHandles(t *testing.T) {
+	svc := ble.NewService(ble.UUID16(0x180D))
+	chr := svc.NewCharacteristic(ble.UUID16(0x2A37))
+	chr.HandleNotify(ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {}))
+	desc := chr.NewDescriptor(ble.UUID16(0x2901))
+	desc.SetValue([]byte("rate"))
+
+	db := NewDB([]*ble.Service{svc}, 1, ble.GetLogger())
+	// Regenerating the DB keeps the CCCD.
+	db = db.WithServices([]*ble.Service{svc})
+	if len(chr.Descriptors) != 2 || chr.Descriptors[1] != chr.CCCD {
+		t.Fatalf("descriptors %v", chr.Descriptors)
+	}
+	if svc.Handle != 1 || svc.EndHandle != 5 || chr.Handle != 2 || desc.Handle != 4 || chr.CCCD.Handle != 5 {
+		t.Fatalf("handles: service 0x%04X-0x%04X, characteristic 0x%04X, descriptors 0x%04X 0x%04X",
+			svc.Handle, svc.EndHandle, chr.Handle, desc.Handle, chr.CCCD.Handle)
+	}
+	if a, ok := db.at(chr.CCCD.Handle); !ok || !a.typ.Equal(ble.ClientCharacteristicConfigUUID) {
+		t.Fatal("CCCD not in the DB")
+	}
+}
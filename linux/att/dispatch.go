@@ -0,0 +1,152 @@
+package att
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/leso-kn/ble/internal/lifecycle"
+)
+
+// notificationQueueLen is the number of notifications a worker of the
+// dispatcher holds before dropping the next ones.
+const notificationQueueLen = 16
+
+// notification is a received notification or indication, in a buffer of
+// rxPool, received at the time at. seq numbers the notifications queued to
+// a worker.
+type notification struct {
+	data []byte
+	buf  *[]byte
+	at   time.Time
+	seq  uint64
+}
+
+// dispatcher hands notifications to a set of workers. The notifications of
+// an attribute always go to the same worker, so they are handled in order,
+// while the ones of different attributes may be handled in parallel.
+type dispatcher struct {
+	queues  []chan notification
+	workers []worker
+}
+
+// worker tracks the notifications queued to a worker: queued is the seq of
+// the last one, handled the seq of the last one handled, and running that
+// of the one being handled, or zero.
+type worker struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queued  uint64
+	handled uint64
+	running uint64
+}
+
+// mark holds the seq of the last notification queued to each worker, or
+// zero for the workers not to wait for.
+type mark []uint64
+
+// newDispatcher starts workers goroutines in g, at least one, passing the
+// notifications to h.
+func newDispatcher(g *lifecycle.Group, workers int, h func([]byte, time.Time)) *dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	d := &dispatcher{
+		queues:  make([]chan notification, workers),
+		workers: make([]worker, workers),
+	}
+	for i := range d.queues {
+		q, w := make(chan notification, notificationQueueLen), &d.workers[i]
+		d.queues[i] = q
+		w.cond = sync.NewCond(&w.mu)
+		g.Go(func() {
+			for n := range q {
+				w.mu.Lock()
+				w.running = n.seq
+				w.mu.Unlock()
+				h(n.data, n.at)
+				rxPool.Put(n.buf)
+				w.mu.Lock()
+				w.handled, w.running = n.seq, 0
+				w.cond.Broadcast()
+				w.mu.Unlock()
+			}
+		})
+	}
+	return d
+}
+
+// worker returns the index of the worker of the attribute h.
+func (d *dispatcher) worker(h uint16) int {
+	return int(h) % len(d.queues)
+}
+
+// dispatch queues n to the worker of its attribute. It reports false if the
+// queue is full, leaving n to the caller.
+func (d *dispatcher) dispatch(n notification) bool {
+	var h uint16
+	if len(n.data) >= 3 {
+		h = binary.LittleEndian.Uint16(n.data[1:])
+	}
+	i := d.worker(h)
+	w := &d.workers[i]
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n.seq = w.queued + 1
+	select {
+	case d.queues[i] <- n:
+		w.queued = n.seq
+		return true
+	default:
+		return false
+	}
+}
+
+// mark returns the notifications queued so far to the worker of the
+// attribute h, or to all the workers if h is 0.
+func (d *dispatcher) mark(h uint16) mark {
+	m := make(mark, len(d.workers))
+	for i := range d.workers {
+		if h != 0 && i != d.worker(h) {
+			continue
+		}
+		w := &d.workers[i]
+		w.mu.Lock()
+		m[i] = w.queued
+		w.mu.Unlock()
+	}
+	return m
+}
+
+// running returns the notification each worker is handling.
+func (d *dispatcher) running() mark {
+	m := make(mark, len(d.workers))
+	for i := range d.workers {
+		w := &d.workers[i]
+		w.mu.Lock()
+		m[i] = w.running
+		w.mu.Unlock()
+	}
+	return m
+}
+
+// wait waits for the notifications of m to be handled. It doesn't wait for
+// a notification which was being handled at busy and still is, as its
+// handler may be waiting for the caller.
+func (d *dispatcher) wait(m, busy mark) {
+	for i, seq := range m {
+		w := &d.workers[i]
+		w.mu.Lock()
+		for w.handled < seq && (busy == nil || busy[i] == 0 || w.running != busy[i]) {
+			w.cond.Wait()
+		}
+		w.mu.Unlock()
+	}
+}
+
+// close stops the workers once they handled the queued notifications.
+func (d *dispatcher) close() {
+	for _, q := range d.queues {
+		close(q)
+	}
+}
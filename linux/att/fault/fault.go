@@ -0,0 +1,215 @@
+// Package fault injects faults in the ATT PDUs exchanged over a connection:
+// it drops, delays, duplicates and corrupts them, and answers requests with
+// ATT errors, to test the robustness of applications, and of the retries and
+// timeouts of clients and servers.
+//
+// A Conn is inserted between the ATT client or server and the connection,
+// e.g. with hci.HCI.SetConnWrapper, and is controlled with Inject.
+package fault
+
+import (
+	"sync"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/att"
+)
+
+// Action is a fault injected in the PDUs matching a Rule.
+type Action int
+
+// Actions
+const (
+	Drop      Action = iota + 1 // The PDU is lost.
+	Delay                       // The PDU is held for Rule.Delay.
+	Duplicate                   // The PDU is passed twice.
+	Corrupt                     // The last byte of the PDU is inverted.
+	Error                       // The request is answered with Rule.Err instead of passed on.
+)
+
+// Direction selects the PDUs a Rule applies to.
+type Direction int
+
+// Directions
+const (
+	Rx Direction = 1 << iota // PDUs received from the remote device.
+	Tx                       // PDUs sent to the remote device.
+)
+
+// Rule selects PDUs, and the fault injected in them.
+type Rule struct {
+	Dir    Direction // Rx, Tx, or both if zero.
+	Opcode byte      // The ATT opcode of the PDUs, or any if zero.
+	Action Action
+
+	Delay time.Duration // The delay of Delay.
+	Err   ble.ATTError  // The error of Error.
+
+	// Count is the number of PDUs the rule applies to, after which it's
+	// removed. Zero applies it to all of them.
+	Count int
+}
+
+// Conn is a connection which injects faults in the ATT PDUs read and
+// written through it. A request answered with an error by a fault reaches
+// neither the remote device nor the local server; the error response is
+// read in its place, or written to the remote device.
+type Conn struct {
+	ble.Conn
+
+	mu    sync.Mutex
+	rules []*Rule
+
+	rx   chan []byte
+	done chan struct{} // Closed once the connection's Read failed.
+	err  error
+}
+
+// Wrap returns a Conn injecting faults in the PDUs of c. It reads c from
+// then on, until its Read fails.
+func Wrap(c ble.Conn) *Conn {
+	fc := &Conn{Conn: c, rx: make(chan []byte), done: make(chan struct{})}
+	go fc.loop()
+	return fc
+}
+
+// Inject adds the rule r, which applies after the ones added earlier.
+func (c *Conn) Inject(r Rule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = append(c.rules, &r)
+}
+
+// Reset removes the rules.
+func (c *Conn) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = nil
+}
+
+// match returns the first rule matching a PDU, and counts it.
+func (c *Conn) match(dir Direction, pdu []byte) *Rule {
+	if len(pdu) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, r := range c.rules {
+		if r.Dir != 0 && r.Dir&dir == 0 || r.Opcode != 0 && r.Opcode != pdu[0] {
+			continue
+		}
+		if r.Action == Error && !isRequest(pdu[0]) {
+			continue
+		}
+		if r.Count > 0 {
+			if r.Count--; r.Count == 0 {
+				c.rules = append(c.rules[:i:i], c.rules[i+1:]...)
+			}
+		}
+		m := *r
+		return &m
+	}
+	return nil
+}
+
+func isRequest(op byte) bool {
+	_, ok := att.ResponseOf(op)
+	return ok && op != att.HandleValueIndicationCode
+}
+
+// errorResponse returns the error response of the request req.
+// [Vol 3, Part F, 3.4.1.1]
+func errorResponse(req []byte, e ble.ATTError) []byte {
+	rsp := []byte{att.ErrorResponseCode, req[0], 0x00, 0x00, byte(e)}
+	if len(req) >= 3 && req[0] != att.ExchangeMTURequestCode && req[0] != att.ExecuteWriteRequestCode {
+		copy(rsp[2:4], req[1:3])
+	}
+	return rsp
+}
+
+// loop reads the PDUs of the connection, and passes them to Read, with the
+// faults of the rules.
+func (c *Conn) loop() {
+	defer close(c.done)
+	b := make([]byte, ble.MaxMTU)
+	for {
+		n, err := c.Conn.Read(b)
+		if err != nil {
+			c.err = err
+			return
+		}
+		pdu := append([]byte(nil), b[:n]...)
+		r := c.match(Rx, pdu)
+		if r == nil {
+			c.deliver(pdu)
+			continue
+		}
+		switch r.Action {
+		case Drop:
+		case Delay:
+			time.Sleep(r.Delay)
+			c.deliver(pdu)
+		case Duplicate:
+			c.deliver(pdu)
+			c.deliver(pdu)
+		case Corrupt:
+			pdu[len(pdu)-1] ^= 0xFF
+			c.deliver(pdu)
+		case Error:
+			c.Conn.Write(errorResponse(pdu, r.Err))
+		}
+	}
+}
+
+// deliver passes pdu to Read, unless the connection is disconnected first.
+func (c *Conn) deliver(pdu []byte) {
+	select {
+	case c.rx <- pdu:
+	case <-c.Conn.Disconnected():
+	}
+}
+
+// Read reads the next PDU.
+func (c *Conn) Read(b []byte) (int, error) {
+	select {
+	case pdu := <-c.rx:
+		return copy(b, pdu), nil
+	case <-c.done:
+		return 0, c.err
+	}
+}
+
+// Write writes the PDU b.
+func (c *Conn) Write(b []byte) (int, error) {
+	r := c.match(Tx, b)
+	if r == nil {
+		return c.Conn.Write(b)
+	}
+	switch r.Action {
+	case Drop:
+		return len(b), nil
+	case Delay:
+		time.Sleep(r.Delay)
+	case Duplicate:
+		if n, err := c.Conn.Write(b); err != nil {
+			return n, err
+		}
+	case Corrupt:
+		p := append([]byte(nil), b...)
+		p[len(p)-1] ^= 0xFF
+		return c.Conn.Write(p)
+	case Error:
+		go c.deliver(errorResponse(b, r.Err))
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+// VerifySignature verifies signed writes with the connection, if it can.
+func (c *Conn) VerifySignature(data []byte) error {
+	v, ok := c.Conn.(interface{ VerifySignature([]byte) error })
+	if !ok {
+		return ble.ErrNotImplemented
+	}
+	return v.VerifySignature(data)
+}
@@ -0,0 +1,103 @@
+package fault_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/att"
+	"github.com/leso-kn/ble/linux/att/fault"
+)
+
+func TestFaultInjection(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	wrap := func(d *linux.Device) <-chan *fault.Conn {
+		ch := make(chan *fault.Conn, 1)
+		d.SetConnWrapper(func(c ble.Conn) ble.Conn {
+			fc := fault.Wrap(c)
+			ch <- fc
+			return fc
+		})
+		return ch
+	}
+	pf, cf := wrap(p), wrap(c)
+
+	svc := ble.NewService(ble.UUID16(0xFF00))
+	svc.NewCharacteristic(ble.UUID16(0xFF01)).SetValue([]byte("hello"))
+	var mu sync.Mutex
+	var written [][]byte
+	svc.NewCharacteristic(ble.UUID16(0xFF02)).HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		mu.Lock()
+		written = append(written, append([]byte(nil), req.Data()...))
+		mu.Unlock()
+	}))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	central, peripheral := <-cf, <-pf
+	prof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := prof.FindCharacteristic(ble.NewCharacteristic(ble.UUID16(0xFF01)))
+	wc := prof.FindCharacteristic(ble.NewCharacteristic(ble.UUID16(0xFF02)))
+	if rc == nil || wc == nil {
+		t.Fatal("characteristics not discovered")
+	}
+
+	// A forced error answers the request in place of the peripheral.
+	central.Inject(fault.Rule{Dir: fault.Tx, Opcode: att.ReadRequestCode, Action: fault.Error, Err: ble.ErrReadNotPerm, Count: 1})
+	if _, err := cln.ReadCharacteristic(rc); !errors.Is(err, ble.ErrReadNotPerm) {
+		t.Fatalf("read with a forced error: %v", err)
+	}
+	if b, err := cln.ReadCharacteristic(rc); err != nil || string(b) != "hello" {
+		t.Fatalf("read %q, %v", b, err)
+	}
+
+	// Corrupted and delayed responses.
+	central.Inject(fault.Rule{Dir: fault.Rx, Opcode: att.ReadResponseCode, Action: fault.Corrupt, Count: 1})
+	if b, err := cln.ReadCharacteristic(rc); err != nil || string(b) != "hell\x90" {
+		t.Fatalf("corrupted read %q, %v", b, err)
+	}
+	central.Inject(fault.Rule{Dir: fault.Rx, Action: fault.Delay, Delay: 50 * time.Millisecond, Count: 1})
+	start := time.Now()
+	if _, err := cln.ReadCharacteristic(rc); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("read took %v, not delayed", d)
+	}
+
+	// On the server side, the request doesn't reach the handler.
+	peripheral.Inject(fault.Rule{Dir: fault.Rx, Opcode: att.WriteRequestCode, Action: fault.Error, Err: ble.ErrWriteNotPerm})
+	if err := cln.WriteCharacteristic(wc, []byte{0x01}, false); !errors.Is(err, ble.ErrWriteNotPerm) {
+		t.Fatalf("write with a forced error: %v", err)
+	}
+	peripheral.Reset()
+
+	// Duplicated commands are written twice.
+	central.Inject(fault.Rule{Dir: fault.Tx, Opcode: att.WriteCommandCode, Action: fault.Duplicate})
+	if err := cln.WriteCharacteristic(wc, []byte{0x02}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cln.WriteCharacteristic(wc, []byte{0x03}, false); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(written) != 3 || written[0][0] != 0x02 || written[1][0] != 0x02 || written[2][0] != 0x03 {
+		t.Fatalf("written % X", written)
+	}
+}
@@ -0,0 +1,121 @@
+package att
+
+import (
+	"sync"
+
+	"github.com/leso-kn/ble"
+)
+
+// subscriptions tracks the client characteristic configurations written by
+// the connections served from a DB, by characteristic, to notify them.
+type subscriptions struct {
+	sync.Mutex
+	m map[*ble.Characteristic]map[*conn]uint16
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{m: make(map[*ble.Characteristic]map[*conn]uint16)}
+}
+
+// set records the configuration ccc written by cn for c.
+func (s *subscriptions) set(c *ble.Characteristic, cn *conn, ccc uint16) {
+	s.Lock()
+	defer s.Unlock()
+	if ccc == 0 {
+		delete(s.m[c], cn)
+		return
+	}
+	if s.m[c] == nil {
+		s.m[c] = make(map[*conn]uint16)
+	}
+	s.m[c][cn] = ccc
+}
+
+// remove forgets the configurations of cn, once it's closed, and returns
+// the characteristics it had subscribed to.
+func (s *subscriptions) remove(cn *conn) []*ble.Characteristic {
+	s.Lock()
+	defer s.Unlock()
+	var cs []*ble.Characteristic
+	for c, cns := range s.m {
+		if _, ok := cns[cn]; ok {
+			cs = append(cs, c)
+			delete(cns, cn)
+		}
+	}
+	return cs
+}
+
+// get returns the configuration cn wrote for c.
+func (s *subscriptions) get(c *ble.Characteristic, cn *conn) uint16 {
+	s.Lock()
+	defer s.Unlock()
+	return s.m[c][cn]
+}
+
+// subscribed returns the connections with the bits of mask set in their
+// configuration of c.
+func (s *subscriptions) subscribed(c *ble.Characteristic, mask uint16) []*conn {
+	s.Lock()
+	defer s.Unlock()
+	var cns []*conn
+	for cn, ccc := range s.m[c] {
+		if ccc&mask != 0 {
+			cns = append(cns, cn)
+		}
+	}
+	return cns
+}
+
+// WithServices returns a DB of the services ss, with the base handle and
+// the logger of r. It shares the subscriptions of r, so that Notify reaches
+// the centrals that subscribed through either DB, the configuration of its
+// prepare queue, its conformance and its counters of violations.
+func (r *DB) WithServices(ss []*ble.Service) *DB {
+	d := NewDB(ss, r.base, r.Logger)
+	d.subs = r.subs
+	d.queue = r.queue
+	d.conf = r.conf
+	return d
+}
+
+// Notify sends the value v of the characteristic c to the connections
+// subscribed to it, as an indication if ind is set, or else as a
+// notification. The value is sent to the connections concurrently, and
+// Notify returns once it was sent to all of them; indications wait for the
+// confirmations, for up to 30 seconds.
+func (r *DB) Notify(c *ble.Characteristic, ind bool, v []byte) ble.NotifyResult {
+	mask := uint16(cccNotify)
+	if ind {
+		mask = cccIndicate
+	}
+	cns := r.subs.subscribed(c, mask)
+
+	type sent struct {
+		cn  *conn
+		err error
+	}
+	ch := make(chan sent, len(cns))
+	for _, cn := range cns {
+		go func(cn *conn) {
+			var err error
+			if ind {
+				_, err = cn.svr.indicate(c.ValueHandle, v)
+			} else {
+				_, err = cn.svr.notify(c.ValueHandle, v)
+			}
+			ch <- sent{cn, err}
+		}(cn)
+	}
+
+	var res ble.NotifyResult
+	for range cns {
+		s := <-ch
+		if s.err != nil {
+			res.Failed = append(res.Failed, ble.NotifyFailure{Conn: s.cn.Conn, Err: s.err})
+			continue
+		}
+		res.Sent = append(res.Sent, s.cn.Conn)
+	}
+	return res
+}
@@ -0,0 +1,92 @@
+package att_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+)
+
+func TestSubscriptionHandler(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	subs := make(chan ble.Subscription, 4)
+	chrUUID := ble.MustParse("00010000-0002-1000-8000-00805F9B34FB")
+	svc := ble.NewService(ble.MustParse("00010000-0001-1000-8000-00805F9B34FB"))
+	chr := svc.NewCharacteristic(chrUUID)
+	chr.HandleNotify(ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {}))
+	chr.HandleSubscription(ble.SubscriptionHandlerFunc(func(s ble.Subscription) { subs <- s }))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+
+	cln, err := c.Dial(ctx, ble.NewAddr("11:22:33:44:55:66"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cln.CancelConnection()
+	prof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := prof.FindCharacteristic(ble.NewCharacteristic(chrUUID))
+	if v == nil || v.CCCD == nil {
+		t.Fatal("characteristic not discovered")
+	}
+
+	expect := func(notify bool) {
+		t.Helper()
+		select {
+		case s := <-subs:
+			if s.Notify != notify || s.Indicate || s.Conn == nil {
+				t.Fatalf("subscription %+v, want notify %v", s, notify)
+			}
+		case <-ctx.Done():
+			t.Fatal("subscription not handled")
+		}
+	}
+	if err := cln.Subscribe(v, false, func(id uint, b []byte) {}); err != nil {
+		t.Fatal(err)
+	}
+	expect(true)
+
+	// Indications aren't supported, the value has a reserved bit, or it's
+	// too long.
+	for _, b := range [][]byte{{0x02, 0x00}, {0x05, 0x00}} {
+		if err := cln.WriteDescriptor(v.CCCD, b); !errors.Is(err, ble.ErrCCCDImproperConf) {
+			t.Fatalf("write % X: %v", b, err)
+		}
+	}
+	if err := cln.WriteDescriptor(v.CCCD, []byte{0x01, 0x00, 0x00}); !errors.Is(err, ble.ErrInvalAttrValueLen) {
+		t.Fatalf("write of 3 bytes: %v", err)
+	}
+	if b, err := cln.ReadDescriptor(v.CCCD); err != nil || !bytes.Equal(b, []byte{0x01, 0x00}) {
+		t.Fatalf("CCCD % X, %v", b, err)
+	}
+
+	// Rewriting the configuration changes nothing.
+	if err := cln.WriteDescriptor(v.CCCD, []byte{0x01, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cln.Unsubscribe(v, false); err != nil {
+		t.Fatal(err)
+	}
+	expect(false)
+
+	// Disconnecting ends the subscription.
+	if err := cln.Subscribe(v, false, func(id uint, b []byte) {}); err != nil {
+		t.Fatal(err)
+	}
+	expect(true)
+	cln.CancelConnection()
+	expect(false)
+}
@@ -0,0 +1,92 @@
+package att
+
+import (
+	"io"
+	"sync"
+
+	"github.com/leso-kn/ble"
+)
+
+// peerConn is the bearer of a client of the peer's database, over the
+// connection of a server. The server passes it the responses, notifications
+// and indications it receives, for the client to read, while the client
+// writes its requests to the connection directly.
+type peerConn struct {
+	ble.Conn
+	rx        chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *peerConn) Read(b []byte) (int, error) {
+	select {
+	case p := <-c.rx:
+		return copy(b, p), nil
+	case <-c.closed:
+		return 0, io.EOF
+	}
+}
+
+func (c *peerConn) close() {
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+// isPeerPDU reports whether a PDU of opcode op is sent by the server of the
+// peer, rather than by its client.
+func isPeerPDU(op byte) bool {
+	switch op {
+	case ErrorResponseCode, HandleValueNotificationCode, HandleValueIndicationCode:
+		return true
+	}
+	for _, rsp := range rspOfReq {
+		if op == rsp {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerConn returns a bearer over the connection of s for a client of the
+// database of the peer, e.g. to read the GATT server of a central which
+// connected to the local one. From then on, s passes the responses,
+// notifications and indications it receives to the bearer, rather than
+// refusing them. Closing the bearer closes the connection.
+func (s *Server) PeerConn() ble.Conn {
+	s.muPeer.Lock()
+	defer s.muPeer.Unlock()
+	if s.peer == nil {
+		s.peer = &peerConn{Conn: s.conn.Conn, rx: make(chan []byte), closed: make(chan struct{})}
+		if s.peerDone {
+			s.peer.close()
+		}
+	}
+	return s.peer
+}
+
+// toPeer passes the PDU b to the bearer of the client of the peer, if
+// there's one and b is sent by the peer's server. It blocks until the
+// client reads it.
+func (s *Server) toPeer(b []byte) bool {
+	s.muPeer.Lock()
+	p := s.peer
+	s.muPeer.Unlock()
+	if p == nil || !isPeerPDU(b[0]) {
+		return false
+	}
+	select {
+	case p.rx <- append([]byte(nil), b...):
+	case <-p.closed:
+	}
+	return true
+}
+
+// closePeer ends the reads of the client of the peer, once the connection
+// is closed.
+func (s *Server) closePeer() {
+	s.muPeer.Lock()
+	defer s.muPeer.Unlock()
+	s.peerDone = true
+	if s.peer != nil {
+		s.peer.close()
+	}
+}
@@ -0,0 +1,202 @@
+package att
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrRequestPending means a request was attempted, in strict mode,
+	// while another one was waiting for its response. Test a
+	// *RequestPendingError against it with errors.Is.
+	ErrRequestPending = errors.New("request pending")
+
+	// ErrRequestCanceled means the request was canceled while waiting for
+	// its response.
+	ErrRequestCanceled = errors.New("request canceled")
+)
+
+// transactionTimeout is the time a server has to respond to a request,
+// after which the transaction fails [Vol 3, Part F, 3.3.3].
+const transactionTimeout = 30 * time.Second
+
+// reqNames are the names of the requests of the client.
+var reqNames = map[byte]string{
+	ExchangeMTURequestCode:     "Exchange MTU Request",
+	FindInformationRequestCode: "Find Information Request",
+	FindByTypeValueRequestCode: "Find By Type Value Request",
+	ReadByTypeRequestCode:      "Read By Type Request",
+	ReadRequestCode:            "Read Request",
+	ReadBlobRequestCode:        "Read Blob Request",
+	ReadMultipleRequestCode:    "Read Multiple Request",
+	ReadByGroupTypeRequestCode: "Read By Group Type Request",
+	WriteRequestCode:           "Write Request",
+	PrepareWriteRequestCode:    "Prepare Write Request",
+	ExecuteWriteRequestCode:    "Execute Write Request",
+}
+
+// PendingRequest describes a request waiting for its response. The ATT is
+// a sequential protocol: a client has at most one on a bearer
+// [Vol 3, Part F, 3.3.2].
+type PendingRequest struct {
+	Opcode byte
+	Name   string
+
+	// Handle is the attribute handle of the request, if it has one, or the
+	// starting handle of the range of a discovery request.
+	Handle uint16
+
+	// Since is when the request was sent.
+	Since time.Time
+}
+
+func (p PendingRequest) String() string {
+	return fmt.Sprintf("%s (0x%02X) of handle 0x%04X, pending for %v", p.Name, p.Opcode, p.Handle, time.Since(p.Since).Round(time.Millisecond))
+}
+
+// RequestPendingError is the error of a request attempted, in strict mode,
+// while another one is pending.
+type RequestPendingError struct {
+	Pending PendingRequest
+}
+
+func (e *RequestPendingError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrRequestPending, e.Pending)
+}
+
+// Is reports whether target is ErrRequestPending.
+func (e *RequestPendingError) Is(target error) bool {
+	return target == ErrRequestPending
+}
+
+// SetStrictSequential sets whether a request attempted while another one is
+// pending fails at once with a *RequestPendingError, rather than waiting
+// for the response of the other one. It makes deadlocks diagnosable, such
+// as a notification handler waiting for a request while the notifications
+// hold back the response of another.
+func (c *Client) SetStrictSequential(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&c.strict, v)
+}
+
+// PendingRequest returns the request waiting for its response, if any,
+// including one canceled whose response is still owed.
+func (c *Client) PendingRequest() (PendingRequest, bool) {
+	c.muReq.Lock()
+	defer c.muReq.Unlock()
+	req, since := c.req, c.reqSince
+	if req == nil {
+		req, since = c.owed, c.owedSince
+	}
+	if req == nil {
+		return PendingRequest{}, false
+	}
+	p := PendingRequest{Opcode: req[0], Name: reqNames[req[0]], Since: since}
+	if len(req) >= 3 && req[0] != ExchangeMTURequestCode && req[0] != ExecuteWriteRequestCode {
+		p.Handle = binary.LittleEndian.Uint16(req[1:])
+	}
+	return p, true
+}
+
+// CancelPendingRequest has the pending request, if any, return
+// ErrRequestCanceled, and reports whether there was one. Its response,
+// which the server is still bound to send, is dropped when it arrives. The
+// bearer stays busy until then, or until the transaction times out, so the
+// next requests wait rather than go on air while the response is owed.
+func (c *Client) CancelPendingRequest() bool {
+	c.muReq.Lock()
+	defer c.muReq.Unlock()
+	if c.req == nil || c.cancelReq == nil {
+		return false
+	}
+	close(c.cancelReq)
+	c.cancelReq = nil
+	c.stale = append(c.stale, staleReq{op: c.req[0], until: time.Now().Add(transactionTimeout)})
+	c.owed, c.owedSince = c.req, c.reqSince
+	var t *time.Timer
+	t = time.AfterFunc(transactionTimeout, func() {
+		c.muReq.Lock()
+		defer c.muReq.Unlock()
+		if c.owedTimer == t {
+			c.settleOwed()
+		}
+	})
+	c.owedTimer = t
+	return true
+}
+
+// releaseTxBuf returns the buffer requests are built in, unless the
+// response of a canceled request is still owed; settleOwed returns it then.
+func (c *Client) releaseTxBuf(b []byte) {
+	c.muReq.Lock()
+	if c.owed != nil {
+		c.heldTxBuf = b
+		c.muReq.Unlock()
+		return
+	}
+	c.muReq.Unlock()
+	c.chTxBuf <- b
+}
+
+// settleOwed frees the bearer once the response of the canceled request
+// arrived or its transaction timed out. muReq must be held.
+func (c *Client) settleOwed() {
+	if c.owed == nil {
+		return
+	}
+	c.owed = nil
+	c.owedTimer.Stop()
+	c.owedTimer = nil
+	if c.heldTxBuf != nil {
+		// It's the only buffer, so the channel has room for it.
+		c.chTxBuf <- c.heldTxBuf
+		c.heldTxBuf = nil
+	}
+}
+
+// acquireTxBuf takes the buffer requests are built in, waiting for the
+// request using it to complete, or failing with a *RequestPendingError in
+// strict mode.
+func (c *Client) acquireTxBuf() ([]byte, error) {
+	if atomic.LoadInt32(&c.strict) == 0 {
+		return <-c.chTxBuf, nil
+	}
+	select {
+	case b := <-c.chTxBuf:
+		return b, nil
+	default:
+	}
+	if p, ok := c.PendingRequest(); ok {
+		return nil, &RequestPendingError{Pending: p}
+	}
+	// The buffer is held briefly, by a command, or a request about to be
+	// sent, or until the response of a canceled request arrives.
+	return <-c.chTxBuf, nil
+}
+
+// dropStale reports whether the response rsp is that of a canceled request,
+// or of one timed out within the tolerance, to be dropped.
+func (c *Client) dropStale(rsp []byte) bool {
+	c.muReq.Lock()
+	defer c.muReq.Unlock()
+	now := time.Now()
+	for len(c.stale) > 0 && now.After(c.stale[0].until) {
+		c.stale = c.stale[1:]
+	}
+	if len(c.stale) == 0 || !answers(rsp, c.stale[0].op) {
+		return false
+	}
+	c.stale = c.stale[1:]
+	c.discarded.Late++
+	if len(c.stale) == 0 {
+		// The canceled request, if any, is the last one sent.
+		c.settleOwed()
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package att
+
+import (
+	"sync"
+
+	"github.com/leso-kn/ble"
+)
+
+// maxAttrValueLen is the maximum length of an attribute value.
+// [Vol 3, Part F, 3.2.9]
+const maxAttrValueLen = 512
+
+// PrepareQueue configures the queue of prepared writes the servers of a DB
+// keep for their clients, which long writes and reliable writes use.
+type PrepareQueue struct {
+	// MaxBytes and MaxWrites limit the values queued by a client, in bytes
+	// and in writes. Beyond them, prepare writes fail with ErrPrepQueueFull.
+	// Zero means no limit.
+	MaxBytes  int
+	MaxWrites int
+
+	// Strict rejects the execution of a queue that leaves a gap in a value
+	// with ErrInvalidOffset, and of one that makes a value longer than 512
+	// bytes with ErrInvalAttrValueLen. Otherwise gaps are filled with zeros.
+	Strict bool
+
+	// RejectReliable, if set, is called on the prepare writes of the value
+	// of a characteristic. If it returns true, the write fails with
+	// ErrWriteNotPerm. As prepare writes don't tell reliable writes from
+	// long ones, it rejects both.
+	RejectReliable func(c *ble.Characteristic) bool
+}
+
+type queueConfig struct {
+	sync.Mutex
+	PrepareQueue
+}
+
+// SetPrepareQueue configures the queue of prepared writes of the servers
+// of r, from their next prepare write on.
+func (r *DB) SetPrepareQueue(q PrepareQueue) {
+	r.queue.Lock()
+	r.queue.PrepareQueue = q
+	r.queue.Unlock()
+}
+
+func (r *DB) prepareQueue() PrepareQueue {
+	r.queue.Lock()
+	defer r.queue.Unlock()
+	return r.queue.PrepareQueue
+}
+
+// preparedWrite is a write queued by a PrepareWriteRequest.
+type preparedWrite struct {
+	a      *attr
+	offset int
+	data   []byte
+}
+
+// assemble returns the values written by the prepared writes pw, by
+// attribute, and the attributes in the order they were first written. If
+// the writes aren't valid, it returns the error, and the attribute in error.
+func assemble(pw []preparedWrite, strict bool) (map[*attr][]byte, []*attr, *attr, ble.ATTError) {
+	vs := make(map[*attr][]byte)
+	var order []*attr
+	for _, w := range pw {
+		v, ok := vs[w.a]
+		if !ok {
+			order = append(order, w.a)
+		}
+		if w.offset > len(v) {
+			if strict {
+				return nil, nil, w.a, ble.ErrInvalidOffset
+			}
+			v = append(v, make([]byte, w.offset-len(v))...)
+		}
+		if end := w.offset + len(w.data); end > len(v) {
+			v = append(v[:w.offset], w.data...)
+		} else {
+			copy(v[w.offset:], w.data)
+		}
+		vs[w.a] = v
+	}
+	if strict {
+		for _, a := range order {
+			if len(vs[a]) > maxAttrValueLen {
+				return nil, nil, a, ble.ErrInvalAttrValueLen
+			}
+		}
+	}
+	return vs, order, nil, ble.ErrSuccess
+}
@@ -0,0 +1,83 @@
+package att
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces the PDUs a client sends, for peers which fail when
+// they're polled too quickly. It's a token bucket of rate tokens per second,
+// holding up to a second of them, and keeps at least spacing between two
+// PDUs. The zero value doesn't limit.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	spacing time.Duration
+
+	// tokens is the number of tokens at the time at, and next the earliest
+	// time the next PDU may be sent.
+	tokens float64
+	at     time.Time
+	next   time.Time
+}
+
+// reserve reserves the slot of a PDU sent at now or later, and returns how
+// long to wait before sending it. Concurrent PDUs get consecutive slots.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t := now
+	if l.next.After(t) {
+		t = l.next
+	}
+	if l.rate > 0 {
+		burst := math.Max(1, math.Floor(l.rate))
+		if l.at.IsZero() {
+			l.tokens = burst
+		} else {
+			l.tokens = math.Min(burst, l.tokens+t.Sub(l.at).Seconds()*l.rate)
+		}
+		if l.tokens < 1 {
+			t = t.Add(time.Duration((1 - l.tokens) / l.rate * float64(time.Second)))
+			l.tokens = 1
+		}
+		l.tokens--
+		l.at = t
+	}
+	l.next = t.Add(l.spacing)
+	return t.Sub(now)
+}
+
+// SetRateLimit limits the requests and commands the client sends to rate per
+// second, in bursts of up to a second's worth, and spaces them by at least
+// spacing, for peers which crash or disconnect when they're polled too
+// quickly. Operations wait for their turn, rather than failing. Zero values
+// lift the limits.
+func (c *Client) SetRateLimit(rate float64, spacing time.Duration) error {
+	if rate < 0 || spacing < 0 {
+		return fmt.Errorf("invalid rate limit %v/s, spacing %v", rate, spacing)
+	}
+	l := &c.limiter
+	l.mu.Lock()
+	l.rate, l.spacing = rate, spacing
+	l.tokens, l.at, l.next = 0, time.Time{}, time.Time{}
+	l.mu.Unlock()
+	return nil
+}
+
+// throttle waits for the slot of the next PDU, or until the connection is
+// closed.
+func (c *Client) throttle() {
+	d := c.limiter.reserve(time.Now())
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-c.connClosed:
+	}
+}
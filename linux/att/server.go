@@ -5,9 +5,11 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/lifecycle"
 )
 
 type conn struct {
@@ -18,24 +20,48 @@ type conn struct {
 	in   map[uint16]ble.Notifier
 }
 
+// publishCCCs exposes a copy of the CCCD values to ble.ConnCCC.
+func (c *conn) publishCCCs() {
+	cccs := make(map[uint16]uint16, len(c.cccs))
+	for h, v := range c.cccs {
+		cccs[h] = v
+	}
+	ble.SetConnValue(c, ble.ConnKeyCCC, cccs)
+}
+
 // Server implements an ATT (Attribute Protocol) server.
 type Server struct {
 	conn *conn
 	db   *DB
 
+	// muDB guards db against the reads of Notify, and the DB set by
+	// SetDB, which replaces db from the next request on. db is only
+	// written by the goroutine handling the requests.
+	muDB   sync.Mutex
+	nextDB *DB
+	access func(Access)
+
 	// Refer to [Vol 3, Part F, 3.3.2 & 3.3.3] for the requirement of
 	// sequential request-response protocol, and transactions.
-	rxMTU     int
-	txBuf     []byte
-	chNotBuf  chan []byte
-	chIndBuf  chan []byte
-	chConfirm chan bool
+	rxMTU        int
+	mtuExchanged bool
+	txBuf        []byte
+	chNotBuf     chan []byte
+	chIndBuf     chan []byte
+	chConfirm    chan bool
 
 	dummyRspWriter ble.ResponseWriter
 
-	// Store a write handler for defer execute once receiving ExecuteWriteRequest
-	prepareWriteRequestAttr *attr
-	prepareWriteRequestData bytes.Buffer
+	// The writes prepared until an ExecuteWriteRequest, and their length.
+	prepared      []preparedWrite
+	preparedBytes int
+
+	// peer, if set, is passed the PDUs of the peer's server, for a client
+	// of its database over the same connection. peerDone is set once the
+	// connection is closed.
+	muPeer   sync.Mutex
+	peer     *peerConn
+	peerDone bool
 
 	ble.Logger
 }
@@ -116,12 +142,73 @@ func (s *Server) indicate(h uint16, data []byte) (int, error) {
 			return 0, io.ErrClosedPipe
 		}
 		return n, nil
+	case <-s.conn.Disconnected():
+		return 0, io.ErrClosedPipe
 	case <-time.After(time.Second * 30):
 		return 0, ErrSeqProtoTimeout
 	}
 }
 
-// Loop accepts incoming ATT request, and respond response.
+// confirm passes a Handle Value Confirmation to the pending indication.
+func (s *Server) confirm() {
+	select {
+	case s.chConfirm <- true:
+	default:
+		s.Errorf("server: received a spurious confirmation")
+	}
+}
+
+// SetDB replaces the database of the server with db, from the next request
+// on. The configurations the peer wrote to the client characteristic
+// configuration descriptors are reset, unless db shares the subscriptions
+// of the current database, as a DB returned by WithServices does.
+func (s *Server) SetDB(db *DB) {
+	s.muDB.Lock()
+	defer s.muDB.Unlock()
+	s.nextDB = db
+}
+
+// switchDB adopts the database set by SetDB, if any. It's called by the
+// goroutine handling the requests.
+func (s *Server) switchDB() {
+	s.muDB.Lock()
+	defer s.muDB.Unlock()
+	if s.nextDB == nil {
+		return
+	}
+	if s.nextDB.subs != s.db.subs {
+		s.cleanup()
+		s.conn.cccs = make(map[uint16]uint16)
+		s.conn.publishCCCs()
+	}
+	s.db, s.nextDB = s.nextDB, nil
+}
+
+// Notify sends the value v of the characteristic c to the peer, as an
+// indication if ind is set, or else as a notification. It fails with
+// ErrNotSubscribed unless the peer subscribed to c accordingly.
+func (s *Server) Notify(c *ble.Characteristic, ind bool, v []byte) error {
+	s.muDB.Lock()
+	db := s.db
+	s.muDB.Unlock()
+	mask := uint16(cccNotify)
+	if ind {
+		mask = cccIndicate
+	}
+	if db.subs.get(c, s.conn)&mask == 0 {
+		return ErrNotSubscribed
+	}
+	var err error
+	if ind {
+		_, err = s.indicate(c.ValueHandle, v)
+	} else {
+		_, err = s.notify(c.ValueHandle, v)
+	}
+	return err
+}
+
+// Loop accepts incoming ATT request, and respond response. It returns once
+// the connection is closed, and the goroutine reading it has returned.
 func (s *Server) Loop() {
 	type sbuf struct {
 		buf []byte
@@ -132,29 +219,31 @@ func (s *Server) Loop() {
 	pool <- &sbuf{buf: make([]byte, ble.MaxMTU)}
 
 	seq := make(chan *sbuf)
-	go func() {
+	var g lifecycle.Group
+	defer g.Wait()
+	g.Go(func() {
 		b := <-pool
 		for {
 			n, err := s.conn.Read(b.buf)
 			if n == 0 || err != nil {
 				close(seq)
 				close(s.chConfirm)
+				s.closePeer()
 				_ = s.conn.Close()
 				return
 			}
 			if b.buf[0] == HandleValueConfirmationCode {
-				select {
-				case s.chConfirm <- true:
-				default:
-					s.Errorf("server: received a spurious confirmation")
-				}
+				s.confirm()
+				continue
+			}
+			if s.toPeer(b.buf[:n]) {
 				continue
 			}
 			b.len = n
 			seq <- b   // Send the current request for handling
 			b = <-pool // Swap the buffer for next incoming request.
 		}
-	}()
+	})
 	for req := range seq {
 		if rsp := s.handleRequest(req.buf[:req.len]); rsp != nil {
 			if len(rsp) != 0 {
@@ -163,6 +252,15 @@ func (s *Server) Loop() {
 		}
 		pool <- req
 	}
+	s.cleanup()
+}
+
+// cleanup forgets the subscriptions of the peer, closes the notifiers of
+// its subscriptions, and reports them ended to the subscription handlers.
+// It's called by the goroutine handling the requests, once it's done with
+// the database.
+func (s *Server) cleanup() {
+	cs := s.db.subs.remove(s.conn)
 	for h, ccc := range s.conn.cccs {
 		if ccc != 0 {
 			s.Infof("server: cleanup %v - 0x%02X", ble.ContextKeyCCC, ccc)
@@ -174,6 +272,9 @@ func (s *Server) Loop() {
 			s.conn.nn[h].Close()
 		}
 	}
+	for _, c := range cs {
+		serveSubscription(c, s.conn, 0)
+	}
 }
 
 func (s *Server) HandleRequest(req []byte) []byte {
@@ -186,6 +287,25 @@ func (s *Server) handleRequest(b []byte) []byte {
 		return nil
 	}
 	s.Debugf("server: req - % X", b)
+	s.switchDB()
+	defer func() { s.reportAccess(b, resp) }()
+
+	strict := s.db.strict()
+	if strict && !s.mtuExchanged && len(s.txBuf) > minMTU {
+		// Until the ATT_MTU is exchanged, responses fit in the default.
+		s.txBuf = make([]byte, minMTU)
+	}
+	if len(b) > s.attMTU() {
+		s.db.violation(func(v *Violations) { v.OversizedPDUs++ })
+		if strict {
+			if isCommand(b[0]) {
+				return nil
+			}
+			resp = newErrorResponse(b[0], 0x0000, ble.ErrInvalidPDU)
+			return resp
+		}
+	}
+	defer func() { s.countViolation(resp) }()
 
 	switch reqType := b[0]; reqType {
 	case ExchangeMTURequestCode:
@@ -206,14 +326,20 @@ func (s *Server) handleRequest(b []byte) []byte {
 		resp = s.handleWriteRequest(b)
 	case WriteCommandCode:
 		s.handleWriteCommand(b)
+	case SignedWriteCommandCode:
+		s.handleSignedWriteCommand(b)
 	case PrepareWriteRequestCode:
 		resp = s.handlePrepareWriteRequest(b)
 	case ExecuteWriteRequestCode:
 		resp = s.handleExecuteWriteRequest(b)
-	case ReadMultipleRequestCode,
-		SignedWriteCommandCode:
-		fallthrough
+	case ReadMultipleRequestCode:
+		resp = s.handleReadMultipleRequest(b)
 	default:
+		if strict && isCommand(reqType) {
+			// Commands the server doesn't support are dropped.
+			s.db.violation(func(v *Violations) { v.UnsupportedOpcodes++ })
+			return nil
+		}
 		resp = newErrorResponse(reqType, 0x0000, ble.ErrReqNotSupp)
 	}
 	s.Debugf("server: rsp - % X", resp)
@@ -225,10 +351,22 @@ func (s *Server) handleExchangeMTURequest(r ExchangeMTURequest) []byte {
 	// Validate the request.
 	switch {
 	case len(r) != 3:
-		fallthrough
-	case r.ClientRxMTU() < 23:
 		return newErrorResponse(r.AttributeOpcode(), 0x0000, ble.ErrInvalidPDU)
+	case s.mtuExchanged || r.ClientRxMTU() < minMTU:
+		strict := s.db.strict()
+		if !strict && r.ClientRxMTU() < minMTU {
+			return newErrorResponse(r.AttributeOpcode(), 0x0000, ble.ErrInvalidPDU)
+		}
+		s.db.violation(func(v *Violations) { v.InvalidMTUs++ })
+		if strict {
+			// The ATT_MTU isn't changed.
+			rsp := ExchangeMTUResponse(make([]byte, 3))
+			rsp.SetAttributeOpcode()
+			rsp.SetServerRxMTU(uint16(s.rxMTU))
+			return rsp
+		}
 	}
+	s.mtuExchanged = true
 
 	txMTU := int(r.ClientRxMTU())
 	s.conn.SetTxMTU(txMTU)
@@ -439,6 +577,42 @@ func (s *Server) handleReadRequest(r ReadRequest) []byte {
 	return rsp[:1+buf.Len()]
 }
 
+// handle Read Multiple request. [Vol 3, Part F, 3.4.4.7 & 3.4.4.8]
+func (s *Server) handleReadMultipleRequest(r ReadMultipleRequest) []byte {
+	// Validate the request.
+	switch {
+	case len(r) < 5 || len(r)%2 != 1:
+		return newErrorResponse(r.AttributeOpcode(), 0x0000, ble.ErrInvalidPDU)
+	}
+
+	rsp := ReadMultipleResponse(s.txBuf)
+	rsp.SetAttributeOpcode()
+	buf := bytes.NewBuffer(rsp.SetOfValues())
+	buf.Reset()
+
+	for hs := r.SetOfHandles(); len(hs) >= 2; hs = hs[2:] {
+		h := binary.LittleEndian.Uint16(hs)
+		a, ok := s.db.at(h)
+		if !ok {
+			return newErrorResponse(r.AttributeOpcode(), h, ble.ErrInvalidHandle)
+		}
+		v := a.v
+		if v == nil {
+			vb := bytes.NewBuffer(make([]byte, 0, ble.MaxMTU))
+			if e := handleATT(a, s, r, ble.NewResponseWriter(vb)); e != ble.ErrSuccess {
+				return newErrorResponse(r.AttributeOpcode(), h, e)
+			}
+			v = vb.Bytes()
+		}
+		// The values are truncated to the ATT_MTU.
+		if room := buf.Cap() - buf.Len(); len(v) > room {
+			v = v[:room]
+		}
+		buf.Write(v)
+	}
+	return rsp[:1+buf.Len()]
+}
+
 // handle Read Blob request. [Vol 3, Part F, 3.4.4.5 & 3.4.4.6]
 func (s *Server) handleReadBlobRequest(r ReadBlobRequest) []byte {
 	// Validate the request.
@@ -459,7 +633,15 @@ func (s *Server) handleReadBlobRequest(r ReadBlobRequest) []byte {
 
 	// Simple case. Read-only, no-authorization, no-authentication.
 	if a.v != nil {
-		binary.Write(buf, binary.LittleEndian, a.v)
+		v := a.v
+		if s.db.strict() {
+			off := int(r.ValueOffset())
+			if off > len(v) {
+				return newErrorResponse(r.AttributeOpcode(), r.AttributeHandle(), ble.ErrInvalidOffset)
+			}
+			v = v[off:]
+		}
+		binary.Write(buf, binary.LittleEndian, v)
 		return rsp[:1+buf.Len()]
 	}
 
@@ -481,6 +663,12 @@ func (s *Server) handleReadByGroupRequest(r ReadByGroupTypeRequest) []byte {
 		return newErrorResponse(r.AttributeOpcode(), r.StartingHandle(), ble.ErrInvalidHandle)
 	}
 
+	strict := s.db.strict()
+	gt := ble.UUID(r.AttributeGroupType()).Compress()
+	if strict && !gt.Equal(ble.PrimaryServiceUUID) && !gt.Equal(ble.SecondaryServiceUUID) {
+		return newErrorResponse(r.AttributeOpcode(), r.StartingHandle(), ble.ErrUnsuppGrpType)
+	}
+
 	rsp := ReadByGroupTypeResponse(s.txBuf)
 	rsp.SetAttributeOpcode()
 	buf := bytes.NewBuffer(rsp.AttributeDataList())
@@ -488,6 +676,9 @@ func (s *Server) handleReadByGroupRequest(r ReadByGroupTypeRequest) []byte {
 
 	dlen := 0
 	for _, a := range s.db.subrange(r.StartingHandle(), r.EndingHandle()) {
+		if strict && !a.typ.Equal(gt) {
+			continue
+		}
 		v := a.v
 		if v == nil {
 			buf2 := bytes.NewBuffer(make([]byte, buf.Cap()-buf.Len()-4))
@@ -545,12 +736,13 @@ func (s *Server) handleWriteRequest(r WriteRequest) []byte {
 	return []byte{WriteResponseCode}
 }
 
+// handle Prepare Write request. [Vol 3, Part F, 3.4.6.1]
 func (s *Server) handlePrepareWriteRequest(r PrepareWriteRequest) []byte {
 	s.Debugf("prepareWriteRequest:attributeHandle %v", r.AttributeHandle())
 
 	// Validate the request.
 	switch {
-	case len(r) < 3:
+	case len(r) < 5:
 		return newErrorResponse(r.AttributeOpcode(), 0x0000, ble.ErrInvalidPDU)
 	}
 
@@ -560,13 +752,25 @@ func (s *Server) handlePrepareWriteRequest(r PrepareWriteRequest) []byte {
 	}
 
 	// We don't support write to static value. Pass the request to upper layer.
-	if a == nil {
+	if a == nil || a.wh == nil {
 		return newErrorResponse(r.AttributeOpcode(), r.AttributeHandle(), ble.ErrWriteNotPerm)
 	}
 
-	if e := handleATT(a, s, r, ble.NewResponseWriter(nil)); e != ble.ErrSuccess {
-		return newErrorResponse(r.AttributeOpcode(), r.AttributeHandle(), e)
+	q := s.db.prepareQueue()
+	if a.c != nil && q.RejectReliable != nil && q.RejectReliable(a.c) {
+		return newErrorResponse(r.AttributeOpcode(), r.AttributeHandle(), ble.ErrWriteNotPerm)
+	}
+	data := r.PartAttributeValue()
+	if q.MaxWrites > 0 && len(s.prepared) >= q.MaxWrites ||
+		q.MaxBytes > 0 && s.preparedBytes+len(data) > q.MaxBytes {
+		return newErrorResponse(r.AttributeOpcode(), r.AttributeHandle(), ble.ErrPrepQueueFull)
 	}
+	s.prepared = append(s.prepared, preparedWrite{
+		a:      a,
+		offset: int(r.ValueOffset()),
+		data:   append([]byte(nil), data...),
+	})
+	s.preparedBytes += len(data)
 
 	// Convert and validate the response.
 	rsp := PrepareWriteResponse(r)
@@ -574,6 +778,7 @@ func (s *Server) handlePrepareWriteRequest(r PrepareWriteRequest) []byte {
 	return rsp
 }
 
+// handle Execute Write request. [Vol 3, Part F, 3.4.6.3]
 func (s *Server) handleExecuteWriteRequest(r ExecuteWriteRequest) []byte {
 	// Validate the request.
 	switch {
@@ -581,18 +786,27 @@ func (s *Server) handleExecuteWriteRequest(r ExecuteWriteRequest) []byte {
 		return newErrorResponse(r.AttributeOpcode(), 0x0000, ble.ErrInvalidPDU)
 	}
 
-	switch r.Flags() {
-	case 0:
+	prepared := s.prepared
+	s.prepared, s.preparedBytes = nil, 0
+	if r.Flags() != 1 {
 		// 0x00 – Cancel all prepared writes
-		s.prepareWriteRequestAttr = nil
-	case 1:
-		// 0x01 – Immediately write all pending prepared values
-		a := s.prepareWriteRequestAttr
-		if e := handleATT(a, s, r, ble.NewResponseWriter(nil)); e != ble.ErrSuccess {
-			return newErrorResponse(r.AttributeOpcode(), 0, e)
-		}
+		return []byte{ExecuteWriteResponseCode}
 	}
 
+	// 0x01 – Immediately write all pending prepared values, once they're
+	// all valid.
+	vs, order, a, e := assemble(prepared, s.db.prepareQueue().Strict || s.db.strict())
+	if e != ble.ErrSuccess {
+		return newErrorResponse(r.AttributeOpcode(), a.h, e)
+	}
+	for _, a := range order {
+		rsp := ble.NewResponseWriter(nil)
+		rsp.SetStatus(ble.ErrSuccess)
+		a.wh.ServeWrite(ble.NewRequest(s.conn, vs[a], 0), rsp)
+		if e := rsp.Status(); e != ble.ErrSuccess {
+			return newErrorResponse(r.AttributeOpcode(), a.h, e)
+		}
+	}
 	return []byte{ExecuteWriteResponseCode}
 }
 
@@ -600,7 +814,10 @@ func (s *Server) handleExecuteWriteRequest(r ExecuteWriteRequest) []byte {
 func (s *Server) handleWriteCommand(r WriteCommand) []byte {
 	// Validate the request.
 	switch {
-	case len(r) <= 3:
+	case len(r) < 3:
+		s.db.violation(func(v *Violations) { v.MalformedPDUs++ })
+		return nil
+	case len(r) == 3 && !s.db.strict():
 		return nil
 	}
 
@@ -619,6 +836,41 @@ func (s *Server) handleWriteCommand(r WriteCommand) []byte {
 	return nil
 }
 
+// signatureLen is the length of the Authentication Signature of a Signed Write
+// Command: the SignCounter and the MAC [Vol 3, Part H, 2.4.5].
+const signatureLen = 12
+
+// signatureVerifier is implemented by connections which can verify the
+// signature of data signed by the peer.
+type signatureVerifier interface {
+	VerifySignature(data []byte) error
+}
+
+// handle Signed Write command. [Vol 3, Part F, 3.4.5.4]
+// Commands are never answered, so writes with an invalid signature are discarded.
+func (s *Server) handleSignedWriteCommand(r SignedWriteCommand) []byte {
+	// Validate the request.
+	switch {
+	case len(r) <= 3+signatureLen:
+		return nil
+	}
+
+	v, ok := s.conn.Conn.(signatureVerifier)
+	if !ok {
+		s.Warnf("server: signed write to 0x%04X discarded, signatures not supported", r.AttributeHandle())
+		return nil
+	}
+	if err := v.VerifySignature(r); err != nil {
+		s.Warnf("server: signed write to 0x%04X discarded: %v", r.AttributeHandle(), err)
+		return nil
+	}
+
+	// Without its signature, the PDU has the layout of a Write Command.
+	w := WriteCommand(append([]byte{}, r[:len(r)-signatureLen]...))
+	w.SetAttributeOpcode()
+	return s.handleWriteCommand(w)
+}
+
 func newErrorResponse(op byte, h uint16, s ble.ATTError) []byte {
 	r := ErrorResponse(make([]byte, 5))
 	r.SetAttributeOpcode()
@@ -634,7 +886,7 @@ func handleATT(a *attr, s *Server, req []byte, rsp ble.ResponseWriter) ble.ATTEr
 	var data []byte
 	conn := s.conn
 	switch req[0] {
-	case ReadByTypeRequestCode:
+	case ReadByTypeRequestCode, ReadMultipleRequestCode:
 		fallthrough
 	case ReadRequestCode:
 		if a.rh == nil {
@@ -647,26 +899,6 @@ func handleATT(a *attr, s *Server, req []byte, rsp ble.ResponseWriter) ble.ATTEr
 		}
 		offset = int(ReadBlobRequest(req).ValueOffset())
 		a.rh.ServeRead(ble.NewRequest(conn, data, offset), rsp)
-	case PrepareWriteRequestCode:
-		if a.wh == nil {
-			return ble.ErrWriteNotPerm
-		}
-		data = PrepareWriteRequest(req).PartAttributeValue()
-		s.Debugf("ATT: PartAttributeValue data %x, offset %v, %p", data, PrepareWriteRequest(req).ValueOffset(), s.prepareWriteRequestAttr)
-
-		if s.prepareWriteRequestAttr == nil {
-			s.prepareWriteRequestAttr = a
-			s.prepareWriteRequestData.Reset()
-		}
-		s.prepareWriteRequestData.Write(data)
-
-	case ExecuteWriteRequestCode:
-		if a.wh == nil {
-			return ble.ErrWriteNotPerm
-		}
-		data = s.prepareWriteRequestData.Bytes()
-		a.wh.ServeWrite(ble.NewRequest(conn, data, offset), rsp)
-		s.prepareWriteRequestAttr = nil
 	case WriteRequestCode:
 		fallthrough
 	case WriteCommandCode:
@@ -677,7 +909,6 @@ func handleATT(a *attr, s *Server, req []byte, rsp ble.ResponseWriter) ble.ATTEr
 		a.wh.ServeWrite(ble.NewRequest(conn, data, offset), rsp)
 	// case SignedWriteCommandCode:
 	// case ReadByGroupTypeRequestCode:
-	// case ReadMultipleRequestCode:
 	default:
 		return ble.ErrReqNotSupp
 	}
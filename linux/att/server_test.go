@@ -0,0 +1,167 @@
+package att
+
+import (
+	"testing"
+
+	"github.com/leso-kn/ble"
+)
+
+func TestPrepareQueue(t *testing.T) {
+	written := make(chan []byte, 1)
+	svc := ble.NewService(ble.UUID16(0x180D))
+	chr := svc.NewCharacteristic(ble.UUID16(0x2A39))
+	chr.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		written <- append([]byte(nil), req.Data()...)
+	}))
+	db := NewDB([]*ble.Service{svc}, 1, ble.GetLogger())
+	s, err := NewServer(db, newBearer(), ble.GetLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := chr.ValueHandle
+	prepare := func(off uint16, v string) []byte {
+		req := append([]byte{PrepareWriteRequestCode, byte(h), byte(h >> 8), byte(off), byte(off >> 8)}, v...)
+		return s.handleRequest(req)
+	}
+	execute := func(flags byte) []byte {
+		return s.handleRequest([]byte{ExecuteWriteRequestCode, flags})
+	}
+	// failed reports whether rsp is an error response with the code e.
+	failed := func(rsp []byte, e ble.ATTError) bool {
+		return len(rsp) == 5 && rsp[0] == ErrorResponseCode && rsp[4] == byte(e)
+	}
+
+	// A long write.
+	prepare(0, "hello ")
+	prepare(6, "world")
+	if rsp := execute(1); rsp[0] != ExecuteWriteResponseCode {
+		t.Fatalf("execute: % X", rsp)
+	}
+	if v := <-written; string(v) != "hello world" {
+		t.Fatalf("wrote %q", v)
+	}
+
+	// Gaps are filled, unless strict.
+	prepare(0, "ab")
+	prepare(4, "cd")
+	execute(1)
+	if v := <-written; string(v) != "ab\x00\x00cd" {
+		t.Fatalf("wrote %q", v)
+	}
+	db.SetPrepareQueue(PrepareQueue{Strict: true})
+	prepare(0, "ab")
+	prepare(4, "cd")
+	if rsp := execute(1); !failed(rsp, ble.ErrInvalidOffset) {
+		t.Fatalf("execute with a gap: % X", rsp)
+	}
+
+	db.SetPrepareQueue(PrepareQueue{MaxWrites: 1})
+	prepare(0, "ab")
+	if rsp := prepare(2, "cd"); !failed(rsp, ble.ErrPrepQueueFull) {
+		t.Fatalf("prepare beyond MaxWrites: % X", rsp)
+	}
+	execute(0)
+	db.SetPrepareQueue(PrepareQueue{MaxBytes: 3})
+	if rsp := prepare(0, "abcd"); !failed(rsp, ble.ErrPrepQueueFull) {
+		t.Fatalf("prepare beyond MaxBytes: % X", rsp)
+	}
+
+	db.SetPrepareQueue(PrepareQueue{RejectReliable: func(c *ble.Characteristic) bool { return c == chr }})
+	if rsp := prepare(0, "ab"); !failed(rsp, ble.ErrWriteNotPerm) {
+		t.Fatalf("rejected prepare: % X", rsp)
+	}
+	select {
+	case v := <-written:
+		t.Fatalf("wrote %q", v)
+	default:
+	}
+}
+
+func TestStrict(t *testing.T) {
+	written := make(chan []byte, 1)
+	svc := ble.NewService(ble.UUID16(0x180D))
+	static := svc.NewCharacteristic(ble.UUID16(0x2A38))
+	static.SetValue([]byte("hello"))
+	chr := svc.NewCharacteristic(ble.UUID16(0x2A39))
+	chr.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		written <- append([]byte(nil), req.Data()...)
+	}))
+	db := NewDB([]*ble.Service{svc}, 1, ble.GetLogger())
+	s, err := NewServer(db, newBearer(), ble.GetLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := func(rsp []byte, e ble.ATTError) bool {
+		return len(rsp) == 5 && rsp[0] == ErrorResponseCode && rsp[4] == byte(e)
+	}
+	h := chr.ValueHandle
+	command := func(v []byte) []byte {
+		return s.handleRequest(append([]byte{WriteCommandCode, byte(h), byte(h >> 8)}, v...))
+	}
+	exchange := func(mtu uint16) []byte {
+		return s.handleRequest([]byte{ExchangeMTURequestCode, byte(mtu), byte(mtu >> 8)})
+	}
+
+	// Leniently, oversized PDUs are served and unsupported commands
+	// answered.
+	command(make([]byte, 30))
+	if v := <-written; len(v) != 30 {
+		t.Fatalf("wrote %d bytes", len(v))
+	}
+	if rsp := s.handleRequest([]byte{0x60}); !failed(rsp, ble.ErrReqNotSupp) {
+		t.Fatalf("unsupported command: % X", rsp)
+	}
+
+	db.SetStrict(true)
+	if rsp := command(make([]byte, 30)); rsp != nil {
+		t.Fatalf("oversized command answered: % X", rsp)
+	}
+	if rsp := s.handleRequest([]byte{0x60}); rsp != nil {
+		t.Fatalf("unsupported command answered: % X", rsp)
+	}
+	command(nil)
+	if v := <-written; len(v) != 0 {
+		t.Fatalf("wrote %q", v)
+	}
+
+	// The ATT_MTU stays 23 bytes below, and is only exchanged once.
+	if rsp := exchange(10); len(rsp) != 3 || rsp[0] != ExchangeMTUResponseCode || s.attMTU() != minMTU {
+		t.Fatalf("exchange below 23: % X, ATT_MTU %d", rsp, s.attMTU())
+	}
+	exchange(ble.MaxMTU)
+	exchange(100)
+	if s.attMTU() != ble.MaxMTU {
+		t.Fatalf("ATT_MTU %d", s.attMTU())
+	}
+
+	sh := static.ValueHandle
+	if rsp := s.handleRequest([]byte{ReadBlobRequestCode, byte(sh), byte(sh >> 8), 0x02, 0x00}); string(rsp) != "\x0Dllo" {
+		t.Fatalf("read blob: % X", rsp)
+	}
+	if rsp := s.handleRequest([]byte{ReadBlobRequestCode, byte(sh), byte(sh >> 8), 0x06, 0x00}); !failed(rsp, ble.ErrInvalidOffset) {
+		t.Fatalf("read blob past the end: % X", rsp)
+	}
+	if rsp := s.handleRequest([]byte{ReadByGroupTypeRequestCode, 0x01, 0x00, 0xFF, 0xFF, 0x03, 0x28}); !failed(rsp, ble.ErrUnsuppGrpType) {
+		t.Fatalf("read by group type of characteristics: % X", rsp)
+	}
+	req := append([]byte{WriteRequestCode, byte(h), byte(h >> 8)}, make([]byte, 513)...)
+	if rsp := s.handleRequest(req); !failed(rsp, ble.ErrInvalidPDU) {
+		t.Fatalf("write of 513 bytes: % X", rsp)
+	}
+
+	want := Violations{
+		OversizedPDUs:      3,
+		UnsupportedOpcodes: 2,
+		InvalidMTUs:        2,
+		InvalidGroupTypes:  1,
+		InvalidOffsets:     1,
+	}
+	if v := db.Violations(); v != want {
+		t.Fatalf("violations %+v, want %+v", v, want)
+	}
+	db.ResetViolations()
+	if v := db.Violations(); v != (Violations{}) {
+		t.Fatalf("violations %+v after reset", v)
+	}
+}
@@ -0,0 +1,153 @@
+package bluez
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/leso-kn/ble"
+)
+
+// adv is an advertisement, as BlueZ reports it in the properties of the
+// advertiser's Device1 object. The raw advertising data isn't available.
+type adv struct {
+	p  props
+	ts int64
+}
+
+func newAdv(p props) *adv {
+	return &adv{p: p, ts: time.Now().UnixNano()}
+}
+
+func (a *adv) LocalName() string {
+	return a.p.str("Name")
+}
+
+// ManufacturerData returns the data of the lowest company id, starting with
+// the id as in the advertising data.
+func (a *adv) ManufacturerData() []byte {
+	md, _ := a.p["ManufacturerData"].Value().(map[uint16]dbus.Variant)
+	if len(md) == 0 {
+		return nil
+	}
+	ids := make([]int, 0, len(md))
+	for id := range md {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	b, _ := md[uint16(ids[0])].Value().([]byte)
+	d := make([]byte, 2, 2+len(b))
+	binary.LittleEndian.PutUint16(d, uint16(ids[0]))
+	return append(d, b...)
+}
+
+func (a *adv) ServiceData() []ble.ServiceData {
+	sd, _ := a.p["ServiceData"].Value().(map[string]dbus.Variant)
+	var ds []ble.ServiceData
+	for s, v := range sd {
+		u, err := parseUUID(s)
+		if err != nil {
+			continue
+		}
+		b, _ := v.Value().([]byte)
+		ds = append(ds, ble.ServiceData{UUID: u, Data: b})
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i].UUID.String() < ds[j].UUID.String() })
+	return ds
+}
+
+func (a *adv) Services() []ble.UUID {
+	var us []ble.UUID
+	for _, s := range a.p.strs("UUIDs") {
+		if u, err := parseUUID(s); err == nil {
+			us = append(us, u)
+		}
+	}
+	return us
+}
+
+func (a *adv) OverflowService() []ble.UUID {
+	return nil
+}
+
+func (a *adv) TxPowerLevel() int {
+	return int(a.p.int16("TxPower"))
+}
+
+func (a *adv) SolicitedService() []ble.UUID {
+	return nil
+}
+
+// Connectable isn't reported by BlueZ; devices are assumed connectable.
+func (a *adv) Connectable() bool {
+	return true
+}
+
+func (a *adv) RSSI() int {
+	return int(a.p.int16("RSSI"))
+}
+
+func (a *adv) Addr() ble.Addr {
+	return ble.NewAddr(a.p.str("Address"))
+}
+
+func (a *adv) AddrType() uint8 {
+	if a.p.str("AddressType") == "random" {
+		return 1
+	}
+	return 0
+}
+
+func (a *adv) Timestamp() int64 {
+	return a.ts
+}
+
+func (a *adv) ToMap() (map[string]interface{}, error) {
+	keys := ble.AdvertisementMapKeys
+	m := map[string]interface{}{
+		keys.MAC:         strings.Replace(a.Addr().String(), ":", "", -1),
+		keys.AddressType: a.AddrType(),
+		keys.Connectable: a.Connectable(),
+		keys.RSSI:        a.RSSI(),
+		keys.Timestamp:   a.ts,
+	}
+	if m[keys.RSSI] == 0 {
+		m[keys.RSSI] = -128
+	}
+	if n := a.LocalName(); n != "" {
+		m[keys.Name] = n
+	}
+	if md := a.ManufacturerData(); md != nil {
+		m[keys.MFG] = md
+	}
+	if us := a.Services(); len(us) > 0 {
+		m[keys.Services] = us
+	}
+	if sd := a.ServiceData(); len(sd) > 0 {
+		m[keys.ServiceData] = sd
+	}
+	if _, ok := a.p["TxPower"]; ok {
+		m[keys.TxPower] = a.TxPowerLevel()
+	}
+	return m, nil
+}
+
+func (a *adv) Data() []byte {
+	return nil
+}
+
+func (a *adv) SrData() []byte {
+	return nil
+}
+
+// parseUUID parses a UUID as BlueZ formats it, compressing those of the
+// Bluetooth base UUID to 16 or 32 bits, as they're advertised.
+func parseUUID(s string) (ble.UUID, error) {
+	u, err := ble.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	return u.Compress(), nil
+}
@@ -0,0 +1,89 @@
+package bluez
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/leso-kn/ble"
+)
+
+func TestParseUUID(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want ble.UUID
+	}{
+		{"0000180f-0000-1000-8000-00805f9b34fb", ble.UUID16(0x180F)},
+		{"00002A19-0000-1000-8000-00805F9B34FB", ble.UUID16(0x2A19)},
+		{"6e400001-b5a3-f393-e0a9-e50e24dcca9e", ble.MustParse("6e400001-b5a3-f393-e0a9-e50e24dcca9e")},
+	} {
+		u, err := parseUUID(tt.s)
+		if err != nil {
+			t.Fatalf("parseUUID(%q): %v", tt.s, err)
+		}
+		if !u.Equal(tt.want) {
+			t.Errorf("parseUUID(%q) = %v, want %v", tt.s, u, tt.want)
+		}
+	}
+}
+
+func TestAdv(t *testing.T) {
+	a := newAdv(props{
+		"Address":     dbus.MakeVariant("C0:11:22:33:44:55"),
+		"AddressType": dbus.MakeVariant("random"),
+		"Name":        dbus.MakeVariant("sensor"),
+		"RSSI":        dbus.MakeVariant(int16(-60)),
+		"UUIDs":       dbus.MakeVariant([]string{"0000180f-0000-1000-8000-00805f9b34fb"}),
+		"ManufacturerData": dbus.MakeVariant(map[uint16]dbus.Variant{
+			0x0059: dbus.MakeVariant([]byte{1, 2}),
+			0x004C: dbus.MakeVariant([]byte{3}),
+		}),
+		"ServiceData": dbus.MakeVariant(map[string]dbus.Variant{
+			"0000feaa-0000-1000-8000-00805f9b34fb": dbus.MakeVariant([]byte{4}),
+		}),
+	})
+
+	if a.Addr().String() != "c0:11:22:33:44:55" || a.AddrType() != 1 {
+		t.Errorf("addr = %v (%d)", a.Addr(), a.AddrType())
+	}
+	if a.LocalName() != "sensor" || a.RSSI() != -60 {
+		t.Errorf("name = %q, rssi = %d", a.LocalName(), a.RSSI())
+	}
+	if s := a.Services(); len(s) != 1 || !s[0].Equal(ble.BatteryUUID) {
+		t.Errorf("services = %v", s)
+	}
+	if md := a.ManufacturerData(); !bytes.Equal(md, []byte{0x4C, 0x00, 3}) {
+		t.Errorf("manufacturer data = % X", md)
+	}
+	sd := a.ServiceData()
+	if len(sd) != 1 || !sd[0].UUID.Equal(ble.UUID16(0xFEAA)) || !bytes.Equal(sd[0].Data, []byte{4}) {
+		t.Errorf("service data = %v", sd)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	p := dbus.ObjectPath("/org/bluez/hci0/dev_C0_11_22_33_44_55/service000a/char000b")
+	if h := handle(p, props{}); h != 0x0B {
+		t.Errorf("handle from path = %#x", h)
+	}
+	if h := handle(p, props{"Handle": dbus.MakeVariant(uint16(0x0C))}); h != 0x0C {
+		t.Errorf("handle from property = %#x", h)
+	}
+}
+
+func TestUnder(t *testing.T) {
+	dev := dbus.ObjectPath("/org/bluez/hci0/dev_C0_11_22_33_44_55")
+	for _, tt := range []struct {
+		p    dbus.ObjectPath
+		want bool
+	}{
+		{dev, true},
+		{dev + "/service000a", true},
+		{dev + "0", false},
+		{"/org/bluez/hci0", false},
+	} {
+		if got := under(tt.p, dev); got != tt.want {
+			t.Errorf("under(%s) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
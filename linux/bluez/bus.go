@@ -0,0 +1,220 @@
+package bluez
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	bluezService = "org.bluez"
+
+	adapterIface = "org.bluez.Adapter1"
+	deviceIface  = "org.bluez.Device1"
+	serviceIface = "org.bluez.GattService1"
+	charIface    = "org.bluez.GattCharacteristic1"
+	descIface    = "org.bluez.GattDescriptor1"
+
+	propsIface  = "org.freedesktop.DBus.Properties"
+	objMgrIface = "org.freedesktop.DBus.ObjectManager"
+
+	propertiesChanged = propsIface + ".PropertiesChanged"
+	interfacesAdded   = objMgrIface + ".InterfacesAdded"
+	interfacesRemoved = objMgrIface + ".InterfacesRemoved"
+)
+
+// props are the properties of an interface.
+type props map[string]dbus.Variant
+
+// objects are the interfaces and their properties of objects, by path.
+type objects map[dbus.ObjectPath]map[string]props
+
+// watch is a handler of the signals of objects under a path.
+type watch struct {
+	path dbus.ObjectPath
+	fn   func(s *dbus.Signal)
+}
+
+// bus is a connection to BlueZ on the system bus. It dispatches the signals
+// of BlueZ objects to watches.
+type bus struct {
+	conn *dbus.Conn
+	ch   chan *dbus.Signal
+
+	mu      sync.Mutex
+	watches map[int]*watch
+	next    int
+}
+
+func newBus() (*bus, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, err
+	}
+	b := &bus{
+		conn:    conn,
+		ch:      make(chan *dbus.Signal, 64),
+		watches: map[int]*watch{},
+	}
+	for _, m := range []string{"PropertiesChanged", "InterfacesAdded", "InterfacesRemoved"} {
+		iface := propsIface
+		if m != "PropertiesChanged" {
+			iface = objMgrIface
+		}
+		if err := conn.AddMatchSignal(
+			dbus.WithMatchSender(bluezService),
+			dbus.WithMatchInterface(iface),
+			dbus.WithMatchMember(m),
+		); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	conn.Signal(b.ch)
+	go b.loop()
+	return b, nil
+}
+
+func (b *bus) close() error {
+	return b.conn.Close()
+}
+
+// watch calls fn with the signals of path and the objects under it, until
+// the returned cancel is called.
+func (b *bus) watch(path dbus.ObjectPath, fn func(s *dbus.Signal)) (cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.watches[id] = &watch{path: path, fn: fn}
+	return func() {
+		b.mu.Lock()
+		delete(b.watches, id)
+		b.mu.Unlock()
+	}
+}
+
+// loop dispatches signals until the connection is closed.
+func (b *bus) loop() {
+	for s := range b.ch {
+		// Interfaces are added and removed by the object manager, on behalf
+		// of the object in the first argument.
+		p := s.Path
+		if (s.Name == interfacesAdded || s.Name == interfacesRemoved) && len(s.Body) > 0 {
+			p, _ = s.Body[0].(dbus.ObjectPath)
+		}
+
+		b.mu.Lock()
+		var fns []func(*dbus.Signal)
+		for _, w := range b.watches {
+			if under(p, w.path) {
+				fns = append(fns, w.fn)
+			}
+		}
+		b.mu.Unlock()
+		for _, fn := range fns {
+			fn(s)
+		}
+	}
+}
+
+// under reports whether p is path, or an object under it.
+func under(p, path dbus.ObjectPath) bool {
+	return p == path || strings.HasPrefix(string(p), string(path)+"/")
+}
+
+// objects returns all BlueZ objects.
+func (b *bus) objects() (objects, error) {
+	var o objects
+	err := b.conn.Object(bluezService, "/").Call(objMgrIface+".GetManagedObjects", 0).Store(&o)
+	return o, err
+}
+
+// call calls the method, e.g. org.bluez.Device1.Connect, of the object at path.
+func (b *bus) call(ctx context.Context, path dbus.ObjectPath, method string, args ...interface{}) *dbus.Call {
+	return b.conn.Object(bluezService, path).CallWithContext(ctx, method, 0, args...)
+}
+
+// props returns the properties of the interface of the object at path.
+func (b *bus) props(path dbus.ObjectPath, iface string) (props, error) {
+	var p props
+	err := b.conn.Object(bluezService, path).Call(propsIface+".GetAll", 0, iface).Store(&p)
+	return p, err
+}
+
+// setProp sets a property of the interface of the object at path.
+func (b *bus) setProp(path dbus.ObjectPath, iface, name string, v interface{}) error {
+	return b.conn.Object(bluezService, path).Call(propsIface+".Set", 0, iface, name, dbus.MakeVariant(v)).Err
+}
+
+// changed returns the interface and changed properties of a
+// PropertiesChanged signal.
+func changed(s *dbus.Signal) (string, props, bool) {
+	if s.Name != propertiesChanged || len(s.Body) < 2 {
+		return "", nil, false
+	}
+	iface, ok := s.Body[0].(string)
+	if !ok {
+		return "", nil, false
+	}
+	p, ok := s.Body[1].(map[string]dbus.Variant)
+	return iface, p, ok
+}
+
+// added returns the properties of an interface added by an InterfacesAdded
+// signal, if it was added.
+func added(s *dbus.Signal, iface string) (dbus.ObjectPath, props, bool) {
+	if s.Name != interfacesAdded || len(s.Body) < 2 {
+		return "", nil, false
+	}
+	path, _ := s.Body[0].(dbus.ObjectPath)
+	ifaces, ok := s.Body[1].(map[string]map[string]dbus.Variant)
+	if !ok {
+		return "", nil, false
+	}
+	p, ok := ifaces[iface]
+	return path, p, ok
+}
+
+// removed reports whether an InterfacesRemoved signal removed iface.
+func removed(s *dbus.Signal, iface string) bool {
+	if s.Name != interfacesRemoved || len(s.Body) < 2 {
+		return false
+	}
+	ifaces, _ := s.Body[1].([]string)
+	for _, i := range ifaces {
+		if i == iface {
+			return true
+		}
+	}
+	return false
+}
+
+// The getters return a property of the given type, or the zero value.
+
+func (p props) str(name string) string {
+	s, _ := p[name].Value().(string)
+	return s
+}
+
+func (p props) bool(name string) bool {
+	b, _ := p[name].Value().(bool)
+	return b
+}
+
+func (p props) int16(name string) int16 {
+	i, _ := p[name].Value().(int16)
+	return i
+}
+
+func (p props) uint16(name string) (uint16, bool) {
+	i, ok := p[name].Value().(uint16)
+	return i, ok
+}
+
+func (p props) strs(name string) []string {
+	s, _ := p[name].Value().([]string)
+	return s
+}
@@ -0,0 +1,511 @@
+package bluez
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/leso-kn/ble"
+	"github.com/pkg/errors"
+)
+
+// A Client is a GATT client of a device connected through BlueZ, which
+// discovers the server's attributes by itself; discovery reads its objects.
+type Client struct {
+	dev  *Device
+	path dbus.ObjectPath
+	addr ble.Addr
+	name string
+	conn *conn
+
+	mu      sync.Mutex
+	profile *ble.Profile
+	paths   map[interface{}]dbus.ObjectPath // Of services, characteristics and descriptors.
+	subs    map[dbus.ObjectPath]func()      // Cancels the watches of subscriptions.
+
+	done     chan struct{}
+	doneOnce sync.Once
+	stop     func()
+}
+
+func newClient(d *Device, path dbus.ObjectPath, p props) *Client {
+	cln := &Client{
+		dev:   d,
+		path:  path,
+		addr:  ble.NewAddr(p.str("Address")),
+		name:  p.str("Name"),
+		paths: map[interface{}]dbus.ObjectPath{},
+		subs:  map[dbus.ObjectPath]func(){},
+		done:  make(chan struct{}),
+	}
+	cln.conn = newConn(cln)
+	cln.mu.Lock()
+	defer cln.mu.Unlock()
+	cln.stop = d.bus.watch(path, func(s *dbus.Signal) {
+		iface, cp, ok := changed(s)
+		gone := removed(s, deviceIface) && s.Body[0].(dbus.ObjectPath) == path
+		down := ok && s.Path == path && iface == deviceIface && hasFalse(cp, "Connected")
+		if gone || down {
+			cln.disconnected()
+		}
+	})
+	return cln
+}
+
+func hasFalse(p props, name string) bool {
+	v, ok := p[name]
+	if !ok {
+		return false
+	}
+	b, _ := v.Value().(bool)
+	return !b
+}
+
+// disconnected cleans up once the device is disconnected.
+func (cln *Client) disconnected() {
+	cln.doneOnce.Do(func() {
+		cln.mu.Lock()
+		cln.stop()
+		for _, cancel := range cln.subs {
+			cancel()
+		}
+		cln.subs = map[dbus.ObjectPath]func(){}
+		cln.mu.Unlock()
+		close(cln.done)
+	})
+}
+
+// Addr returns the address of the remote peripheral.
+func (cln *Client) Addr() ble.Addr {
+	return cln.addr
+}
+
+// Name returns the name of the remote peripheral.
+func (cln *Client) Name() string {
+	return cln.name
+}
+
+// Profile returns the discovered profile.
+func (cln *Client) Profile() *ble.Profile {
+	cln.mu.Lock()
+	defer cln.mu.Unlock()
+	return cln.profile
+}
+
+// DiscoverProfile discovers the whole hierarchy of a server.
+func (cln *Client) DiscoverProfile(force bool) (*ble.Profile, error) {
+	if p := cln.Profile(); p != nil && !force {
+		return p, nil
+	}
+	ss, err := cln.DiscoverServices(nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't discover services: %s", err)
+	}
+	for _, s := range ss {
+		cs, err := cln.DiscoverCharacteristics(nil, s)
+		if err != nil {
+			return nil, fmt.Errorf("can't discover characteristics: %s", err)
+		}
+		for _, c := range cs {
+			if _, err := cln.DiscoverDescriptors(nil, c); err != nil {
+				return nil, fmt.Errorf("can't discover descriptors: %s", err)
+			}
+		}
+	}
+	p := &ble.Profile{Services: ss}
+	cln.mu.Lock()
+	cln.profile = p
+	cln.mu.Unlock()
+	return p, nil
+}
+
+// DiscoverAndCacheProfile discovers the profile; BlueZ caches it by itself.
+func (cln *Client) DiscoverAndCacheProfile(force bool) (*ble.Profile, error) {
+	return cln.DiscoverProfile(force)
+}
+
+// InvalidateProfile drops the discovered profile, so that the next
+// discovery starts over. BlueZ keeps its own cache.
+func (cln *Client) InvalidateProfile() error {
+	cln.mu.Lock()
+	cln.profile = nil
+	cln.mu.Unlock()
+	return nil
+}
+
+// RefreshService rediscovers the primary service u, with its
+// characteristics and descriptors, in place of the one of the profile.
+func (cln *Client) RefreshService(u ble.UUID) (*ble.Service, error) {
+	ss, err := cln.DiscoverServices([]ble.UUID{u})
+	if err != nil {
+		return nil, err
+	}
+	var s *ble.Service
+	if len(ss) != 0 {
+		s = ss[0]
+		cs, err := cln.DiscoverCharacteristics(nil, s)
+		if err != nil {
+			return nil, fmt.Errorf("can't discover characteristics: %s", err)
+		}
+		for _, c := range cs {
+			if _, err := cln.DiscoverDescriptors(nil, c); err != nil {
+				return nil, fmt.Errorf("can't discover descriptors: %s", err)
+			}
+		}
+	}
+	cln.mu.Lock()
+	if cln.profile == nil {
+		cln.profile = &ble.Profile{}
+	}
+	cln.profile.ReplaceService(u, s)
+	cln.mu.Unlock()
+	if s == nil {
+		return nil, fmt.Errorf("service %s not found", u)
+	}
+	return s, nil
+}
+
+// attr is a GATT object of the device.
+type attr struct {
+	path   dbus.ObjectPath
+	p      props
+	handle uint16
+}
+
+// attrs returns the objects of iface under parent, in handle order.
+func (cln *Client) attrs(parent dbus.ObjectPath, iface string) ([]attr, error) {
+	objs, err := cln.dev.bus.objects()
+	if err != nil {
+		return nil, err
+	}
+	var as []attr
+	for path, ifaces := range objs {
+		p, ok := ifaces[iface]
+		if !ok || !under(path, parent) || path == parent {
+			continue
+		}
+		as = append(as, attr{path: path, p: p, handle: handle(path, p)})
+	}
+	sort.Slice(as, func(i, j int) bool { return as[i].handle < as[j].handle })
+	return as, nil
+}
+
+// handle returns the attribute handle of a GATT object. Older versions of
+// BlueZ don't have the Handle property, but use it in the object's name, e.g.
+// char0010.
+func handle(path dbus.ObjectPath, p props) uint16 {
+	if h, ok := p.uint16("Handle"); ok && h != 0 {
+		return h
+	}
+	s := string(path)
+	s = strings.TrimLeft(s[strings.LastIndex(s, "/")+1:], "abcdefghijklmnopqrstuvwxyz")
+	h, _ := strconv.ParseUint(s, 16, 16)
+	return uint16(h)
+}
+
+func match(filter []ble.UUID, u ble.UUID) bool {
+	return filter == nil || ble.Contains(filter, u)
+}
+
+// DiscoverServices finds all the primary services on a server.
+// If filter is specified, only filtered services are returned.
+func (cln *Client) DiscoverServices(filter []ble.UUID) ([]*ble.Service, error) {
+	as, err := cln.attrs(cln.path, serviceIface)
+	if err != nil {
+		return nil, err
+	}
+	cln.mu.Lock()
+	defer cln.mu.Unlock()
+	var ss []*ble.Service
+	for i, a := range as {
+		u, err := parseUUID(a.p.str("UUID"))
+		if err != nil || !a.p.bool("Primary") || !match(filter, u) {
+			continue
+		}
+		end := uint16(0xFFFF)
+		if i+1 < len(as) {
+			end = as[i+1].handle - 1
+		}
+		s := &ble.Service{UUID: u, Handle: a.handle, EndHandle: end}
+		cln.paths[s] = a.path
+		ss = append(ss, s)
+	}
+	if cln.profile == nil {
+		cln.profile = &ble.Profile{Services: ss}
+	}
+	return ss, nil
+}
+
+// DiscoverIncludedServices isn't supported.
+func (cln *Client) DiscoverIncludedServices(filter []ble.UUID, s *ble.Service) ([]*ble.Service, error) {
+	return nil, ble.ErrNotImplemented
+}
+
+// charProps maps the flags of GattCharacteristic1 to properties.
+var charProps = map[string]ble.Property{
+	"broadcast":                   ble.CharBroadcast,
+	"read":                        ble.CharRead,
+	"write-without-response":      ble.CharWriteNR,
+	"write":                       ble.CharWrite,
+	"notify":                      ble.CharNotify,
+	"indicate":                    ble.CharIndicate,
+	"authenticated-signed-writes": ble.CharSignedWrite,
+	"extended-properties":         ble.CharExtended,
+}
+
+// DiscoverCharacteristics finds all the characteristics within a service.
+// If filter is specified, only filtered characteristics are returned.
+func (cln *Client) DiscoverCharacteristics(filter []ble.UUID, s *ble.Service) ([]*ble.Characteristic, error) {
+	sp, err := cln.pathOf(s)
+	if err != nil {
+		return nil, err
+	}
+	as, err := cln.attrs(sp, charIface)
+	if err != nil {
+		return nil, err
+	}
+	cln.mu.Lock()
+	defer cln.mu.Unlock()
+	var cs []*ble.Characteristic
+	for i, a := range as {
+		u, err := parseUUID(a.p.str("UUID"))
+		if err != nil || !match(filter, u) {
+			continue
+		}
+		end := s.EndHandle
+		if i+1 < len(as) {
+			end = as[i+1].handle - 1
+		}
+		c := &ble.Characteristic{
+			UUID:        u,
+			Handle:      a.handle,
+			ValueHandle: a.handle + 1,
+			EndHandle:   end,
+		}
+		for _, f := range a.p.strs("Flags") {
+			c.Property |= charProps[f]
+		}
+		cln.paths[c] = a.path
+		cs = append(cs, c)
+	}
+	s.Characteristics = cs
+	return cs, nil
+}
+
+// DiscoverDescriptors finds all the descriptors within a characteristic.
+// If filter is specified, only filtered descriptors are returned.
+func (cln *Client) DiscoverDescriptors(filter []ble.UUID, c *ble.Characteristic) ([]*ble.Descriptor, error) {
+	cp, err := cln.pathOf(c)
+	if err != nil {
+		return nil, err
+	}
+	as, err := cln.attrs(cp, descIface)
+	if err != nil {
+		return nil, err
+	}
+	cln.mu.Lock()
+	defer cln.mu.Unlock()
+	var ds []*ble.Descriptor
+	for _, a := range as {
+		u, err := parseUUID(a.p.str("UUID"))
+		if err != nil || !match(filter, u) {
+			continue
+		}
+		d := &ble.Descriptor{UUID: u, Handle: a.handle}
+		if u.Equal(ble.ClientCharacteristicConfigUUID) {
+			c.CCCD = d
+		}
+		cln.paths[d] = a.path
+		ds = append(ds, d)
+	}
+	c.Descriptors = ds
+	return ds, nil
+}
+
+// pathOf returns the object path of a discovered attribute.
+func (cln *Client) pathOf(a interface{}) (dbus.ObjectPath, error) {
+	cln.mu.Lock()
+	defer cln.mu.Unlock()
+	p, ok := cln.paths[a]
+	if !ok {
+		return "", fmt.Errorf("attribute not discovered")
+	}
+	return p, nil
+}
+
+// call calls a method of a discovered attribute.
+func (cln *Client) call(a interface{}, method string, args ...interface{}) *dbus.Call {
+	p, err := cln.pathOf(a)
+	if err != nil {
+		return &dbus.Call{Err: err}
+	}
+	return cln.dev.bus.call(context.Background(), p, method, args...)
+}
+
+func (cln *Client) read(a interface{}, iface string) ([]byte, error) {
+	var b []byte
+	err := cln.call(a, iface+".ReadValue", map[string]interface{}{}).Store(&b)
+	return b, err
+}
+
+// ReadCharacteristic reads a characteristic value from a server.
+func (cln *Client) ReadCharacteristic(c *ble.Characteristic) ([]byte, error) {
+	b, err := cln.read(c, charIface)
+	if err == nil {
+		c.Value = b
+	}
+	return b, err
+}
+
+// ReadLongCharacteristic reads a characteristic value; BlueZ reads long
+// values by itself.
+func (cln *Client) ReadLongCharacteristic(c *ble.Characteristic) ([]byte, error) {
+	return cln.ReadCharacteristic(c)
+}
+
+// WriteCharacteristic writes a characteristic value to a server.
+func (cln *Client) WriteCharacteristic(c *ble.Characteristic, value []byte, noRsp bool) error {
+	typ := "request"
+	if noRsp {
+		typ = "command"
+	}
+	return cln.call(c, charIface+".WriteValue", value, map[string]interface{}{"type": typ}).Err
+}
+
+// ReadDescriptor reads a characteristic descriptor from a server.
+func (cln *Client) ReadDescriptor(d *ble.Descriptor) ([]byte, error) {
+	b, err := cln.read(d, descIface)
+	if err == nil {
+		d.Value = b
+	}
+	return b, err
+}
+
+// WriteDescriptor writes a characteristic descriptor to a server. BlueZ
+// doesn't allow writing the CCCD; use Subscribe.
+func (cln *Client) WriteDescriptor(d *ble.Descriptor, v []byte) error {
+	return cln.call(d, descIface+".WriteValue", v, map[string]interface{}{}).Err
+}
+
+// ReadRSSI returns the last RSSI BlueZ got from the peripheral's
+// advertisements; it isn't available for a connection.
+func (cln *Client) ReadRSSI() (int8, error) {
+	p, err := cln.dev.bus.props(cln.path, deviceIface)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := p["RSSI"]; !ok {
+		return 0, fmt.Errorf("rssi not available")
+	}
+	return int8(p.int16("RSSI")), nil
+}
+
+// ExchangeMTU returns the ATT_MTU, which BlueZ exchanges by itself.
+func (cln *Client) ExchangeMTU(rxMTU int) (int, error) {
+	return cln.conn.TxMTU(), nil
+}
+
+// Subscribe subscribes to notifications or indications of a characteristic;
+// BlueZ chooses which from its properties, regardless of ind.
+func (cln *Client) Subscribe(c *ble.Characteristic, ind bool, h ble.NotificationHandler) error {
+	p, err := cln.pathOf(c)
+	if err != nil {
+		return err
+	}
+	var id uint
+	cancel := cln.dev.bus.watch(p, func(s *dbus.Signal) {
+		iface, cp, ok := changed(s)
+		if !ok || s.Path != p || iface != charIface {
+			return
+		}
+		if v, ok := cp["Value"]; ok {
+			b, _ := v.Value().([]byte)
+			h(id, b)
+			id++
+		}
+	})
+	if err := cln.call(c, charIface+".StartNotify").Err; err != nil {
+		cancel()
+		return err
+	}
+	cln.mu.Lock()
+	if old, ok := cln.subs[p]; ok {
+		old()
+	}
+	cln.subs[p] = cancel
+	cln.mu.Unlock()
+	return nil
+}
+
+// Unsubscribe unsubscribes from notifications or indications of a
+// characteristic.
+func (cln *Client) Unsubscribe(c *ble.Characteristic, ind bool) error {
+	p, err := cln.pathOf(c)
+	if err != nil {
+		return err
+	}
+	cln.mu.Lock()
+	cancel, ok := cln.subs[p]
+	delete(cln.subs, p)
+	cln.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	cancel()
+	return cln.call(c, charIface+".StopNotify").Err
+}
+
+// ClearSubscriptions clears all subscriptions to notifications and
+// indications.
+func (cln *Client) ClearSubscriptions() error {
+	cln.mu.Lock()
+	subs := cln.subs
+	cln.subs = map[dbus.ObjectPath]func(){}
+	cln.mu.Unlock()
+	var err error
+	for p, cancel := range subs {
+		cancel()
+		if e := cln.dev.bus.call(context.Background(), p, charIface+".StopNotify").Err; e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// CancelConnection disconnects the connection.
+func (cln *Client) CancelConnection() error {
+	err := cln.dev.bus.call(context.Background(), cln.path, deviceIface+".Disconnect").Err
+	if err != nil {
+		return errors.Wrap(err, "can't disconnect")
+	}
+	return nil
+}
+
+// Disconnected returns a receiving channel, which is closed when the client
+// disconnects.
+func (cln *Client) Disconnected() <-chan struct{} {
+	return cln.done
+}
+
+// Conn returns the client's connection.
+func (cln *Client) Conn() ble.Conn {
+	return cln.conn
+}
+
+// Pair pairs with the peripheral. The authentication is up to the BlueZ agent
+// of the system, so ad isn't used.
+func (cln *Client) Pair(ad ble.AuthData, to time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), to)
+	defer cancel()
+	return cln.dev.bus.call(ctx, cln.path, deviceIface+".Pair").Err
+}
+
+// StartEncryption isn't supported; BlueZ encrypts bonded links by itself.
+func (cln *Client) StartEncryption(ch chan ble.EncryptionChangedInfo) error {
+	return ble.ErrNotImplemented
+}
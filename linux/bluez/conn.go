@@ -0,0 +1,130 @@
+package bluez
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/leso-kn/ble"
+)
+
+// attMinMTU is the ATT_MTU before an exchange; BlueZ doesn't tell the
+// exchanged one.
+const attMinMTU = 23
+
+// conn is the connection of a Client. BlueZ doesn't expose the L2CAP channel
+// of the ATT bearer, so it can't be read or written.
+type conn struct {
+	cln *Client
+
+	mu    sync.Mutex
+	ctx   context.Context
+	rxMTU int
+	txMTU int
+}
+
+func newConn(cln *Client) *conn {
+	return &conn{
+		cln:   cln,
+		ctx:   ble.WithConnValues(context.Background()),
+		rxMTU: attMinMTU,
+		txMTU: attMinMTU,
+	}
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	return 0, ble.ErrNotImplemented
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	return 0, ble.ErrNotImplemented
+}
+
+// Close disconnects the connection.
+func (c *conn) Close() error {
+	return c.cln.CancelConnection()
+}
+
+func (c *conn) Context() context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ctx
+}
+
+func (c *conn) SetContext(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ctx = ctx
+}
+
+func (c *conn) LocalAddr() ble.Addr {
+	return c.cln.dev.Address()
+}
+
+func (c *conn) RemoteAddr() ble.Addr {
+	return c.cln.Addr()
+}
+
+func (c *conn) ReadRSSI() (int8, error) {
+	return c.cln.ReadRSSI()
+}
+
+func (c *conn) RxMTU() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rxMTU
+}
+
+func (c *conn) SetRxMTU(mtu int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rxMTU = mtu
+}
+
+func (c *conn) TxMTU() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.txMTU
+}
+
+func (c *conn) SetTxMTU(mtu int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.txMTU = mtu
+}
+
+func (c *conn) Disconnected() <-chan struct{} {
+	return c.cln.Disconnected()
+}
+
+func (c *conn) Pair(ad ble.AuthData, to time.Duration) error {
+	return c.cln.Pair(ad, to)
+}
+
+func (c *conn) StartEncryption(ch chan ble.EncryptionChangedInfo) error {
+	return ble.ErrNotImplemented
+}
+
+func (c *conn) RemoteFeatures() (uint64, error) {
+	return 0, ble.ErrNotImplemented
+}
+
+func (c *conn) RemoteVersion() (ble.RemoteVersion, error) {
+	return ble.RemoteVersion{}, ble.ErrNotImplemented
+}
+
+func (c *conn) ConnInfo() ble.ConnInfo {
+	return ble.ConnInfo{}
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	return ble.ErrNotImplemented
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	return ble.ErrNotImplemented
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return ble.ErrNotImplemented
+}
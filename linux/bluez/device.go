@@ -0,0 +1,358 @@
+// Package bluez implements a BLE device on top of BlueZ, using its D-Bus API
+// instead of an HCI socket. It's meant for systems where bluetoothd owns the
+// adapter, which can't be detached for the HCI backend.
+//
+// Scanning, the central role and the GATT client are supported; advertising
+// and the GATT server aren't.
+package bluez
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/leso-kn/ble"
+	"github.com/pkg/errors"
+)
+
+// Device is a BLE device backed by a BlueZ adapter.
+type Device struct {
+	bus     *bus
+	adapter dbus.ObjectPath
+	addr    ble.Addr
+
+	id        int // The adapter, as in hciX, or -1 for the first one.
+	dialerTmo time.Duration
+
+	mu       sync.Mutex
+	stopScan context.CancelFunc
+}
+
+// NewDevice returns a device using the first BlueZ adapter, or the one chosen
+// with ble.OptDeviceID.
+func NewDevice(opts ...ble.Option) (*Device, error) {
+	d := &Device{id: -1, dialerTmo: 30 * time.Second}
+	if err := d.Option(opts...); err != nil {
+		return nil, err
+	}
+
+	b, err := newBus()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't connect to the system bus")
+	}
+	d.bus = b
+	if err := d.init(); err != nil {
+		b.close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Option sets the options specified.
+func (d *Device) Option(opts ...ble.Option) error {
+	var err error
+	for _, opt := range opts {
+		err = opt(d)
+	}
+	return err
+}
+
+// init finds and powers on the adapter.
+func (d *Device) init() error {
+	objs, err := d.bus.objects()
+	if err != nil {
+		return errors.Wrap(err, "can't get bluez objects")
+	}
+	var paths []string
+	for p, ifaces := range objs {
+		if _, ok := ifaces[adapterIface]; ok {
+			paths = append(paths, string(p))
+		}
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if d.id == -1 || strings.HasSuffix(p, fmt.Sprintf("/hci%d", d.id)) {
+			d.adapter = dbus.ObjectPath(p)
+			break
+		}
+	}
+	if d.adapter == "" {
+		return fmt.Errorf("no bluez adapter found")
+	}
+
+	p := objs[d.adapter][adapterIface]
+	d.addr = ble.NewAddr(p.str("Address"))
+	if !p.bool("Powered") {
+		if err := d.bus.setProp(d.adapter, adapterIface, "Powered", true); err != nil {
+			return errors.Wrap(err, "can't power on the adapter")
+		}
+	}
+	return nil
+}
+
+// AddService isn't supported.
+func (d *Device) AddService(svc *ble.Service) error {
+	return ble.ErrNotImplemented
+}
+
+// RemoveAllServices isn't supported.
+func (d *Device) RemoveAllServices() error {
+	return ble.ErrNotImplemented
+}
+
+// SetServices isn't supported.
+func (d *Device) SetServices(svcs []*ble.Service) error {
+	return ble.ErrNotImplemented
+}
+
+// Stop stops scanning and closes the connection to BlueZ. Connections are
+// kept by BlueZ.
+func (d *Device) Stop() error {
+	d.StopScan()
+	return d.bus.close()
+}
+
+// Advertise isn't supported.
+func (d *Device) Advertise(ctx context.Context, adv ble.Advertisement) error {
+	return ble.ErrNotImplemented
+}
+
+// AdvertiseNameAndServices isn't supported.
+func (d *Device) AdvertiseNameAndServices(ctx context.Context, name string, uuids ...ble.UUID) error {
+	return ble.ErrNotImplemented
+}
+
+// AdvertiseMfgData isn't supported.
+func (d *Device) AdvertiseMfgData(ctx context.Context, id uint16, b []byte) error {
+	return ble.ErrNotImplemented
+}
+
+// AdvertiseServiceData16 isn't supported.
+func (d *Device) AdvertiseServiceData16(ctx context.Context, id uint16, b []byte) error {
+	return ble.ErrNotImplemented
+}
+
+// AdvertiseServiceData isn't supported.
+func (d *Device) AdvertiseServiceData(ctx context.Context, u ble.UUID, b []byte) error {
+	return ble.ErrNotImplemented
+}
+
+// AdvertiseIBeaconData isn't supported.
+func (d *Device) AdvertiseIBeaconData(ctx context.Context, b []byte) error {
+	return ble.ErrNotImplemented
+}
+
+// AdvertiseIBeacon isn't supported.
+func (d *Device) AdvertiseIBeacon(ctx context.Context, u ble.UUID, major, minor uint16, pwr int8) error {
+	return ble.ErrNotImplemented
+}
+
+// AdvertiseEddystoneUID isn't supported.
+func (d *Device) AdvertiseEddystoneUID(ctx context.Context, namespace [10]byte, instance [6]byte, txPower int8) error {
+	return ble.ErrNotImplemented
+}
+
+// AdvertiseEddystoneURL isn't supported.
+func (d *Device) AdvertiseEddystoneURL(ctx context.Context, url string, txPower int8) error {
+	return ble.ErrNotImplemented
+}
+
+// AdvertiseAltBeacon isn't supported.
+func (d *Device) AdvertiseAltBeacon(ctx context.Context, mfgID uint16, id1 [16]byte, id2, id3 uint16, refRSSI int8) error {
+	return ble.ErrNotImplemented
+}
+
+// AdvertiseData isn't supported.
+func (d *Device) AdvertiseData(ctx context.Context, ad, sr []byte) error {
+	return ble.ErrNotImplemented
+}
+
+// Scan scans until ctx is done. BlueZ reports a device's advertisements when
+// its properties change, e.g. its RSSI; with allowDup, every one is reported.
+func (d *Device) Scan(ctx context.Context, allowDup bool, h ble.AdvHandler) error {
+	if err := d.NonblockingScan(allowDup, h); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	if err := d.StopScan(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// NonblockingScan starts scanning without blocking the caller.
+func (d *Device) NonblockingScan(allowDup bool, h ble.AdvHandler) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopScan != nil {
+		return fmt.Errorf("already scanning")
+	}
+
+	filter := map[string]interface{}{
+		"Transport":     "le",
+		"DuplicateData": allowDup,
+	}
+	bg := context.Background()
+	if err := d.bus.call(bg, d.adapter, adapterIface+".SetDiscoveryFilter", filter).Err; err != nil {
+		return errors.Wrap(err, "can't set discovery filter")
+	}
+
+	s := &scanner{bus: d.bus, h: h, devs: map[dbus.ObjectPath]props{}}
+	cancel := d.bus.watch(d.adapter, s.handle)
+	if err := d.bus.call(bg, d.adapter, adapterIface+".StartDiscovery").Err; err != nil {
+		cancel()
+		return errors.Wrap(err, "can't start discovery")
+	}
+	d.stopScan = func() {
+		cancel()
+		d.bus.call(bg, d.adapter, adapterIface+".StopDiscovery")
+	}
+	return nil
+}
+
+// StopScan stops scanning.
+func (d *Device) StopScan() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopScan != nil {
+		d.stopScan()
+		d.stopScan = nil
+	}
+	return nil
+}
+
+// scanner turns the devices BlueZ discovers into advertisements.
+type scanner struct {
+	bus *bus
+	h   ble.AdvHandler
+
+	mu   sync.Mutex
+	devs map[dbus.ObjectPath]props
+}
+
+func (s *scanner) handle(sig *dbus.Signal) {
+	var p props
+	if path, ap, ok := added(sig, deviceIface); ok {
+		p = s.update(path, ap, nil)
+	} else if iface, cp, ok := changed(sig); ok && iface == deviceIface {
+		p = s.update(sig.Path, nil, cp)
+	} else if removed(sig, deviceIface) {
+		s.mu.Lock()
+		delete(s.devs, sig.Body[0].(dbus.ObjectPath))
+		s.mu.Unlock()
+	}
+	if p != nil {
+		s.h(newAdv(p))
+	}
+}
+
+// update merges the properties of a device, from an InterfacesAdded signal
+// or a PropertiesChanged one, and returns a copy of them if an advertisement
+// was received.
+func (s *scanner) update(path dbus.ObjectPath, all, changed props) props {
+	s.mu.Lock()
+	_, known := s.devs[path]
+	s.mu.Unlock()
+	if !known && all == nil {
+		// A device BlueZ already knew; get its properties once.
+		all, _ = s.bus.props(path, deviceIface)
+		if all == nil {
+			return nil
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.devs[path]
+	if !ok {
+		p = props{}
+		s.devs[path] = p
+	}
+	for k, v := range all {
+		p[k] = v
+	}
+	for k, v := range changed {
+		p[k] = v
+	}
+
+	// Connection and pairing changes aren't advertisements.
+	if changed != nil && !advChange(changed) {
+		return nil
+	}
+	if _, ok := p["RSSI"]; !ok {
+		// Not in range, e.g. a bonded device which was never seen.
+		return nil
+	}
+	cp := make(props, len(p))
+	for k, v := range p {
+		cp[k] = v
+	}
+	return cp
+}
+
+// advChange reports whether the changed properties come from an
+// advertisement.
+func advChange(p props) bool {
+	for _, k := range []string{"RSSI", "ManufacturerData", "ServiceData", "UUIDs", "Name", "TxPower"} {
+		if _, ok := p[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Dial connects to the device at a, which BlueZ should have discovered, and
+// waits for its services to be resolved.
+func (d *Device) Dial(ctx context.Context, a ble.Addr) (ble.Client, error) {
+	path := d.devicePath(a)
+	ctx, cancel := context.WithTimeout(ctx, d.dialerTmo)
+	defer cancel()
+
+	resolved := make(chan struct{}, 1)
+	stop := d.bus.watch(path, func(s *dbus.Signal) {
+		if iface, p, ok := changed(s); ok && iface == deviceIface && p.bool("ServicesResolved") {
+			select {
+			case resolved <- struct{}{}:
+			default:
+			}
+		}
+	})
+	defer stop()
+
+	if err := d.bus.call(ctx, path, deviceIface+".Connect").Err; err != nil {
+		return nil, errors.Wrapf(err, "can't connect to %v", a)
+	}
+	p, err := d.bus.props(path, deviceIface)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get properties of %v", a)
+	}
+	if !p.bool("ServicesResolved") {
+		select {
+		case <-resolved:
+		case <-ctx.Done():
+			d.bus.call(context.Background(), path, deviceIface+".Disconnect")
+			return nil, errors.Wrapf(ctx.Err(), "services of %v not resolved", a)
+		}
+	}
+	return newClient(d, path, p), nil
+}
+
+// devicePath returns the object path BlueZ uses for the device at a.
+func (d *Device) devicePath(a ble.Addr) dbus.ObjectPath {
+	s := strings.ToUpper(strings.Replace(a.String(), ":", "_", -1))
+	return dbus.ObjectPath(fmt.Sprintf("%s/dev_%s", d.adapter, s))
+}
+
+// Address returns the adapter's public address.
+func (d *Device) Address() ble.Addr {
+	return d.addr
+}
+
+// SendVendorSpecificCommand isn't supported.
+func (d *Device) SendVendorSpecificCommand(opcode uint16, length uint8, v interface{}) error {
+	return ble.ErrNotImplemented
+}
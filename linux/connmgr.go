@@ -0,0 +1,313 @@
+package linux
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/lifecycle"
+)
+
+// ConnState is the state of a peer managed by a ConnManager.
+type ConnState int
+
+// Connection states of managed peers.
+const (
+	ConnStateIdle       ConnState = iota // Waiting to dial, e.g. backing off after a failure.
+	ConnStateConnecting                  // Dialing.
+	ConnStateConnected                   // Connected, the client was handed to the application.
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnStateIdle:
+		return "idle"
+	case ConnStateConnecting:
+		return "connecting"
+	case ConnStateConnected:
+		return "connected"
+	}
+	return "unknown"
+}
+
+// ConnManagerOptions configures a ConnManager. Zero values select the defaults.
+type ConnManagerOptions struct {
+	// MaxDials bounds the number of concurrent dials. Defaults to 1, as the
+	// controller initiates one connection at a time anyway.
+	MaxDials int
+
+	// DialTimeout bounds a single dial. Defaults to 10 seconds.
+	DialTimeout time.Duration
+
+	// MinBackoff and MaxBackoff bound the delay before redialing a peer. It
+	// starts at MinBackoff and doubles with every failed dial. Default to 1
+	// second and 1 minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// OnConnect is called with the client of each connection made. It runs
+	// on the goroutine of the peer, which waits for the disconnection after
+	// it returns.
+	OnConnect func(ble.Client)
+
+	// OnDisconnect is called when the connection to a peer is lost.
+	OnDisconnect func(ble.Addr)
+
+	// OnStateChange is called whenever the state of a peer changes.
+	OnStateChange func(ble.Addr, ConnState)
+}
+
+// ConnManager maintains connections to a set of peers. It dials them with
+// bounded concurrency, redials them with backoff when the connection is
+// lost, and hands the clients of the connections to the application. Peers
+// are added by address, or by filters matched against advertisements.
+type ConnManager struct {
+	dev  ble.Device
+	opts ConnManagerOptions
+	sem  chan struct{}
+
+	mu      sync.Mutex
+	ctx     context.Context // Set while running.
+	peers   map[string]*managedPeer
+	filters []ble.AdvFilter
+	g       lifecycle.Group // Runs the maintenance of the peers.
+}
+
+type managedPeer struct {
+	addr    ble.Addr
+	state   ConnState
+	client  ble.Client
+	started bool
+	removed chan struct{}
+}
+
+// NewConnManager returns a connection manager dialing with dev.
+func NewConnManager(dev ble.Device, opts ConnManagerOptions) *ConnManager {
+	if opts.MaxDials <= 0 {
+		opts.MaxDials = 1
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 10 * time.Second
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = time.Second
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = time.Minute
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+	return &ConnManager{
+		dev:   dev,
+		opts:  opts,
+		sem:   make(chan struct{}, opts.MaxDials),
+		peers: make(map[string]*managedPeer),
+	}
+}
+
+func peerKey(a ble.Addr) string { return strings.ToLower(a.String()) }
+
+// AddPeer adds a peer to maintain a connection to. Adding a peer twice has
+// no effect.
+func (m *ConnManager) AddPeer(a ble.Addr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := peerKey(a)
+	if _, ok := m.peers[k]; ok {
+		return
+	}
+	p := &managedPeer{addr: a, removed: make(chan struct{})}
+	m.peers[k] = p
+	if m.ctx != nil {
+		m.start(p)
+	}
+}
+
+// RemovePeer stops maintaining the connection to a peer, and disconnects it.
+func (m *ConnManager) RemovePeer(a ble.Addr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := peerKey(a)
+	if p, ok := m.peers[k]; ok {
+		close(p.removed)
+		delete(m.peers, k)
+	}
+}
+
+// AddFilter makes Run scan for advertisements, and add the connectable
+// advertisers matching f as peers. Filters must be added before Run.
+func (m *ConnManager) AddFilter(f ble.AdvFilter) {
+	m.mu.Lock()
+	m.filters = append(m.filters, f)
+	m.mu.Unlock()
+}
+
+// State returns the state of a peer, and whether it's managed.
+func (m *ConnManager) State(a ble.Addr) (ConnState, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.peers[peerKey(a)]
+	if !ok {
+		return ConnStateIdle, false
+	}
+	return p.state, true
+}
+
+// Clients returns the clients of the connected peers.
+func (m *ConnManager) Clients() []ble.Client {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var clns []ble.Client
+	for _, p := range m.peers {
+		if p.state == ConnStateConnected {
+			clns = append(clns, p.client)
+		}
+	}
+	return clns
+}
+
+// Run maintains the connections until ctx is done, and then disconnects
+// the peers. It returns ctx.Err(), or the error of the scan for filters.
+func (m *ConnManager) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	m.mu.Lock()
+	m.ctx = ctx
+	for _, p := range m.peers {
+		m.start(p)
+	}
+	filters := m.filters
+	m.mu.Unlock()
+
+	defer func() {
+		cancel()
+		m.mu.Lock()
+		m.ctx = nil
+		for _, p := range m.peers {
+			p.started = false
+		}
+		m.mu.Unlock()
+		m.g.Wait()
+	}()
+
+	if len(filters) == 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	err := m.dev.Scan(ctx, false, func(a ble.Advertisement) {
+		if !a.Connectable() {
+			return
+		}
+		for _, f := range filters {
+			if f(a) {
+				m.AddPeer(a.Addr())
+				return
+			}
+		}
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// start starts maintaining the connection to p. Must be called with m.mu held.
+func (m *ConnManager) start(p *managedPeer) {
+	if p.started {
+		return
+	}
+	p.started = true
+	ctx := m.ctx
+	m.g.Go(func() { m.maintain(ctx, p) })
+}
+
+func (m *ConnManager) setState(p *managedPeer, s ConnState, cln ble.Client) {
+	m.mu.Lock()
+	p.state, p.client = s, cln
+	m.mu.Unlock()
+	if m.opts.OnStateChange != nil {
+		m.opts.OnStateChange(p.addr, s)
+	}
+}
+
+// maintain dials p, and redials it whenever the connection is lost, until
+// ctx is done or p is removed.
+func (m *ConnManager) maintain(ctx context.Context, p *managedPeer) {
+	backoff := m.opts.MinBackoff
+	for {
+		cln, err := m.dial(ctx, p)
+		if err != nil {
+			m.setState(p, ConnStateIdle, nil)
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.removed:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > m.opts.MaxBackoff {
+				backoff = m.opts.MaxBackoff
+			}
+			continue
+		}
+		backoff = m.opts.MinBackoff
+
+		m.setState(p, ConnStateConnected, cln)
+		if m.opts.OnConnect != nil {
+			m.opts.OnConnect(cln)
+		}
+
+		select {
+		case <-cln.Disconnected():
+		case <-ctx.Done():
+			cln.CancelConnection()
+			m.setState(p, ConnStateIdle, nil)
+			return
+		case <-p.removed:
+			cln.CancelConnection()
+			m.setState(p, ConnStateIdle, nil)
+			return
+		}
+		m.setState(p, ConnStateIdle, nil)
+		if m.opts.OnDisconnect != nil {
+			m.opts.OnDisconnect(p.addr)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.removed:
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// dial dials p once a dial slot is free.
+func (m *ConnManager) dial(ctx context.Context, p *managedPeer) (ble.Client, error) {
+	select {
+	case m.sem <- struct{}{}:
+		defer func() { <-m.sem }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.removed:
+		return nil, context.Canceled
+	}
+
+	m.setState(p, ConnStateConnecting, nil)
+	ctx, cancel := context.WithTimeout(ctx, m.opts.DialTimeout)
+	defer cancel()
+	m.g.Go(func() {
+		// Abandon the dial when the peer is removed.
+		select {
+		case <-p.removed:
+			cancel()
+		case <-ctx.Done():
+		}
+	})
+	return m.dev.Dial(ctx, p.addr)
+}
@@ -0,0 +1,180 @@
+package linux_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux"
+)
+
+// countingDevice counts the dials in progress, and the most at once.
+type countingDevice struct {
+	*linux.Device
+
+	mu       sync.Mutex
+	dials    int
+	maxDials int
+}
+
+func (d *countingDevice) Dial(ctx context.Context, a ble.Addr) (ble.Client, error) {
+	d.mu.Lock()
+	if d.dials++; d.dials > d.maxDials {
+		d.maxDials = d.dials
+	}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.dials--
+		d.mu.Unlock()
+	}()
+	return d.Device.Dial(ctx, a)
+}
+
+func TestConnManagerDialLimit(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	addrs := []ble.Addr{ble.NewAddr(virtualtest.PeripheralAddr)}
+	go pair.Peripheral.AdvertiseNameAndServices(ctx, "Gopher")
+	for i := 1; i <= 3; i++ {
+		a := fmt.Sprintf("11:22:33:44:55:%02X", i)
+		pc, err := pair.Air.NewController(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := linux.NewDevice(ble.OptTransportVirtual(pc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Stop()
+		go p.AdvertiseNameAndServices(ctx, "Gopher")
+		addrs = append(addrs, ble.NewAddr(a))
+	}
+
+	dev := &countingDevice{Device: pair.Central}
+	connected := make(chan ble.Client, len(addrs))
+	m := linux.NewConnManager(dev, linux.ConnManagerOptions{
+		MaxDials:   2,
+		MinBackoff: 10 * time.Millisecond,
+		OnConnect:  func(cln ble.Client) { connected <- cln },
+	})
+	for _, a := range addrs {
+		m.AddPeer(a)
+	}
+	go m.Run(ctx)
+
+	for range addrs {
+		select {
+		case <-connected:
+		case <-ctx.Done():
+			t.Fatal("peers not connected")
+		}
+	}
+	if n := len(m.Clients()); n != len(addrs) {
+		t.Fatalf("%d clients, want %d", n, len(addrs))
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if dev.maxDials > 2 {
+		t.Fatalf("%d dials at once, want at most 2", dev.maxDials)
+	}
+}
+
+func TestConnManagerRedial(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	go pair.Peripheral.AdvertiseNameAndServices(ctx, "Gopher")
+
+	a := ble.NewAddr(virtualtest.PeripheralAddr)
+	connected := make(chan ble.Client, 2)
+	disconnected := make(chan ble.Addr, 2)
+	m := linux.NewConnManager(pair.Central, linux.ConnManagerOptions{
+		MinBackoff:   10 * time.Millisecond,
+		OnConnect:    func(cln ble.Client) { connected <- cln },
+		OnDisconnect: func(a ble.Addr) { disconnected <- a },
+	})
+	m.AddPeer(a)
+	go m.Run(ctx)
+
+	var cln ble.Client
+	select {
+	case cln = <-connected:
+	case <-ctx.Done():
+		t.Fatal("peer not connected")
+	}
+	cln.CancelConnection()
+	select {
+	case got := <-disconnected:
+		if got.String() != a.String() {
+			t.Fatalf("disconnected %v, want %v", got, a)
+		}
+	case <-ctx.Done():
+		t.Fatal("disconnection not reported")
+	}
+	select {
+	case cln = <-connected:
+	case <-ctx.Done():
+		t.Fatal("peer not redialed")
+	}
+	if s, ok := m.State(a); !ok || s != linux.ConnStateConnected {
+		t.Fatalf("state %v, %v", s, ok)
+	}
+}
+
+func TestConnManagerStop(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	go pair.Peripheral.AdvertiseNameAndServices(ctx, "Gopher")
+
+	a := ble.NewAddr(virtualtest.PeripheralAddr)
+	connected := make(chan ble.Client, 1)
+	m := linux.NewConnManager(pair.Central, linux.ConnManagerOptions{
+		OnConnect: func(cln ble.Client) { connected <- cln },
+	})
+	m.AddPeer(a)
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- m.Run(runCtx) }()
+
+	var cln ble.Client
+	select {
+	case cln = <-connected:
+	case <-ctx.Done():
+		t.Fatal("peer not connected")
+	}
+
+	// Run disconnects the peers, and returns once their goroutines ended.
+	stop()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("Run: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Run didn't return")
+	}
+	select {
+	case <-cln.Disconnected():
+	case <-ctx.Done():
+		t.Fatal("peer not disconnected")
+	}
+	if s, ok := m.State(a); !ok || s != linux.ConnStateIdle {
+		t.Fatalf("state %v, %v", s, ok)
+	}
+	if n := len(m.Clients()); n != 0 {
+		t.Fatalf("%d clients after Run returned", n)
+	}
+}
@@ -1,16 +1,22 @@
 package linux
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	smp2 "github.com/leso-kn/ble/linux/hci/smp"
 
 	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/lifecycle"
+	"github.com/leso-kn/ble/linux/adv"
 	"github.com/leso-kn/ble/linux/att"
 	"github.com/leso-kn/ble/linux/gatt"
 	"github.com/leso-kn/ble/linux/hci"
+	"github.com/leso-kn/ble/registry"
 	"github.com/pkg/errors"
 )
 
@@ -24,6 +30,22 @@ func NewDeviceWithName(name string, opts ...ble.Option) (*Device, error) {
 	return NewDeviceWithNameAndHandler(name, nil, opts...)
 }
 
+// NewCentralDevice returns the default HCI device, in the central role only.
+// It has no GATT server nor accept loop, and refuses incoming connections:
+// the methods of the server fail with hci.ErrCentralOnly, or do nothing.
+func NewCentralDevice(opts ...ble.Option) (*Device, error) {
+	dev, err := hci.NewHCI(smp2.NewSmpFactory(nil), opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create hci")
+	}
+	dev.SetCentralOnly(true)
+	if err = dev.Init(); err != nil {
+		dev.Close()
+		return nil, errors.Wrap(err, "can't init hci")
+	}
+	return &Device{HCI: dev}, nil
+}
+
 func NewDeviceWithNameAndHandler(name string, handler ble.NotifyHandler, opts ...ble.Option) (*Device, error) {
 	dev, err := hci.NewHCI(smp2.NewSmpFactory(nil), opts...)
 	if err != nil {
@@ -40,18 +62,27 @@ func NewDeviceWithNameAndHandler(name string, handler ble.NotifyHandler, opts ..
 		return nil, errors.Wrap(err, "can't create server")
 	}
 
+	if p, ok := dev.PreferredConnParams(); ok {
+		srv.SetPreferredConnParams(p)
+	}
+
 	mtu := ble.MaxMTU // TODO: get this from user using Option.
 	if mtu > ble.MaxMTU {
 		dev.Close()
 		return nil, errors.Wrapf(err, "maximum ATT_MTU is %d", ble.MaxMTU)
 	}
 
-	go loop(dev, srv, mtu)
+	d := &Device{HCI: dev, Server: srv, loopDone: make(chan struct{})}
+	d.g.Go(func() { d.loop(mtu) })
 
-	return &Device{HCI: dev, Server: srv}, nil
+	return d, nil
 }
 
-func loop(dev *hci.HCI, s *gatt.Server, mtu int) {
+// loop accepts the incoming connections, and serves the GATT database to
+// them, or hands them to the listener, if any, until the device is stopped.
+func (d *Device) loop(mtu int) {
+	defer close(d.loopDone)
+	dev := d.HCI
 	for {
 		l2c, err := dev.Accept()
 		if err != nil {
@@ -68,50 +99,297 @@ func loop(dev *hci.HCI, s *gatt.Server, mtu int) {
 			return
 		}
 
-		// Initialize the per-connection cccd values.
-		l2c.SetContext(context.WithValue(l2c.Context(), ble.ContextKeyCCC, make(map[uint16]uint16)))
 		l2c.SetRxMTU(mtu)
 
-		s.Lock()
-		as, err := att.NewServer(s.DB(), l2c, dev.Logger)
-		s.Unlock()
-		if err != nil {
-			dev.Errorf("att.NewServer: %v", err)
+		in := newIncoming(d, l2c)
+		if l := d.currentListener(); l != nil && l.deliver(in) {
 			continue
 		}
+		if err := in.Accept(); err != nil {
+			dev.Errorf("att.NewServer: %v", err)
+		}
+	}
+}
+
+// serve serves the database db, or that of the GATT server if nil, to the
+// connection l2c.
+func (d *Device) serve(l2c ble.Conn, db *att.DB) error {
+	dev, s := d.HCI, d.Server
+
+	// Log with the identity of the connection, if it has one.
+	var l ble.Logger = dev.Logger
+	if cl, ok := l2c.(ble.Logger); ok {
+		l = cl
+	}
+	s.Lock()
+	if db == nil {
+		db = s.DB()
+	}
+	as, err := att.NewServer(db, l2c, dev.SampledLogger(ble.LogATT, l))
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	dev.Infof("starting att server loop")
+	d.addConn(l2c, as)
+	d.g.Go(func() {
+		as.Loop()
+		d.removeConn(l2c)
+	})
+	return nil
+}
+
+func (d *Device) addConn(c ble.Conn, as *att.Server) {
+	d.muConns.Lock()
+	defer d.muConns.Unlock()
+	d.conns = append(d.conns, c)
+	d.served = append(d.served, &servedConn{server: as})
+}
+
+func (d *Device) removeConn(c ble.Conn) {
+	d.muConns.Lock()
+	defer d.muConns.Unlock()
+	for i, cc := range d.conns {
+		if cc == c {
+			d.conns = append(d.conns[:i], d.conns[i+1:]...)
+			d.served = append(d.served[:i], d.served[i+1:]...)
+			return
+		}
+	}
+}
 
-		dev.Infof("starting att server loop")
-		go as.Loop()
+// ErrNotServed means a connection isn't one the GATT server serves.
+var ErrNotServed = errors.New("connection not served")
+
+// servedConn is the ATT server of a connection, and the client of the
+// database of its central, once requested.
+type servedConn struct {
+	server *att.Server
+	client *gatt.Client
+}
+
+// PeerClient returns a GATT client of the database of the central of c, a
+// connection the GATT server serves, e.g. to read the current time or the
+// notification source of a phone which connected. c is either one of Conns,
+// or the connection of a request. The client shares the connection with the
+// server, and is the same for all the calls for a connection. Canceling it
+// disconnects the central.
+func (d *Device) PeerClient(c ble.Conn) (*gatt.Client, error) {
+	d.muConns.Lock()
+	defer d.muConns.Unlock()
+	id := c.Context().Value(ble.ContextKeyConnID)
+	for i, cc := range d.conns {
+		if cc != c && (id == nil || cc.Context().Value(ble.ContextKeyConnID) != id) {
+			continue
+		}
+		sc := d.served[i]
+		if sc.client == nil {
+			// The connection is closed by the server once the central
+			// disconnects, which ends the client.
+			cln, err := gatt.NewClient(sc.server.PeerConn(), nil, nil, sc.server.Logger)
+			if err != nil {
+				return nil, err
+			}
+			cln.SetResponseTolerance(d.HCI.ResponseTolerance())
+			sc.client = cln
+		}
+		return sc.client, nil
 	}
+	return nil, ErrNotServed
+}
+
+// Conns returns the connections of the centrals the GATT server serves, in
+// the order they connected. Like the connections passed to the handlers, by
+// ble.Request.Conn, they read the RSSI of the centrals with ReadRSSI, e.g.
+// for a proximity-aware peripheral.
+func (d *Device) Conns() []ble.Conn {
+	d.muConns.Lock()
+	defer d.muConns.Unlock()
+	return append([]ble.Conn(nil), d.conns...)
+}
+
+// Devices returns the HCI devices available on the system. Pass the id of a
+// device to ble.OptDeviceID to use it; different devices can be used
+// concurrently by separate Device instances.
+func Devices() ([]hci.DeviceInfo, error) {
+	return hci.List()
 }
 
-// Device ...
+// Device is an HCI device, serving its GATT database to the centrals which
+// connect to it, or, with Listen, to those the application accepts.
+//
+// A device runs in both roles at once: it may be connected as a peripheral
+// while it scans and dials as a central. After accepting a connection, it
+// resumes advertising until advertising is stopped, or as set by
+// ble.OptAdvRestart. If the controller can't
+// initiate a connection while advertising, Dial pauses advertising until it
+// returns.
+//
+// The controller limits the number of connections, across both roles. At the
+// limit, Dial fails with the controller's error, e.g. hci.ErrConnLimit, and
+// connectable advertising stops until a connection drops.
 type Device struct {
 	HCI    *hci.HCI
-	Server *gatt.Server
+	Server *gatt.Server // Nil for a device created by NewCentralDevice.
+
+	// g owns the accept loop, and the ATT servers of the connections.
+	g lifecycle.Group
+
+	// loopDone is closed once the accept loop returned.
+	loopDone chan struct{}
+
+	// listener, if set, is handed the incoming connections.
+	muListen sync.Mutex
+	listener *Listener
+
+	// conns are the connections served, in the order they were accepted,
+	// and served their servers and peer clients.
+	muConns sync.Mutex
+	conns   []ble.Conn
+	served  []*servedConn
+}
+
+// Option applies options to the device. Scan and advertising parameters are
+// used from the next scan or advertisement on.
+func (d *Device) Option(opts ...ble.Option) error {
+	return d.HCI.Option(opts...)
 }
 
 // AddService adds a service to database.
 func (d *Device) AddService(svc *ble.Service) error {
+	if d.Server == nil {
+		return hci.ErrCentralOnly
+	}
 	return d.Server.AddService(svc)
 }
 
 // RemoveAllServices removes all services that are currently in the database.
 func (d *Device) RemoveAllServices() error {
+	if d.Server == nil {
+		return hci.ErrCentralOnly
+	}
 	return d.Server.RemoveAllServices()
 }
 
 // SetServices set the specified service to the database.
 // It removes all currently added services, if any.
 func (d *Device) SetServices(svcs []*ble.Service) error {
+	if d.Server == nil {
+		return hci.ErrCentralOnly
+	}
 	return d.Server.SetServices(svcs)
 }
 
+// SetPrepareQueue limits and validates the writes prepared by the clients
+// of the GATT server, which long and reliable writes use.
+func (d *Device) SetPrepareQueue(q att.PrepareQueue) {
+	if d.Server == nil {
+		return
+	}
+	d.Server.SetPrepareQueue(q)
+}
+
+// SetStrictATT sets whether the GATT server follows the Attribute Protocol
+// strictly, e.g. for Bluetooth qualification, as att.DB.SetStrict
+// describes.
+func (d *Device) SetStrictATT(on bool) {
+	if d.Server == nil {
+		return
+	}
+	d.Server.SetStrict(on)
+}
+
+// ATTViolations returns the violations of the Attribute Protocol by the
+// clients of the GATT server.
+func (d *Device) ATTViolations() att.Violations {
+	if d.Server == nil {
+		return att.Violations{}
+	}
+	return d.Server.Violations()
+}
+
+// Notify sends the value v of the characteristic c to the centrals
+// subscribed to it, as an indication if ind is set, or else as a
+// notification. The result lists the centrals it was sent to, and the ones
+// it failed to reach, to build reliable delivery upon.
+func (d *Device) Notify(c *ble.Characteristic, ind bool, v []byte) ble.NotifyResult {
+	if d.Server == nil {
+		return ble.NotifyResult{}
+	}
+	return d.Server.Notify(c, ind, v)
+}
+
+// Bind binds the value of the characteristic c to channels, which notify
+// the subscribed centrals of the values set, and deliver the values written.
+// See gatt.Server.Bind. It returns nil on a central-only device.
+func (d *Device) Bind(c *ble.Characteristic, ind bool) *gatt.Binding {
+	if d.Server == nil {
+		return nil
+	}
+	return d.Server.Bind(c, ind)
+}
+
+// KnownDevices returns the devices recorded by the registry set with
+// ble.OptRegistry, the most recently seen first, or nil without one.
+func (d *Device) KnownDevices() []registry.Device {
+	if r := d.HCI.Registry(); r != nil {
+		return r.Devices()
+	}
+	return nil
+}
+
+// KnownDevice returns the device with the address a recorded by the
+// registry set with ble.OptRegistry.
+func (d *Device) KnownDevice(a ble.Addr) (registry.Device, bool) {
+	if r := d.HCI.Registry(); r != nil {
+		return r.Device(a)
+	}
+	return registry.Device{}, false
+}
+
+// State returns the state the device drives the controller in: whether it
+// scans, advertises and dials, and its number of connections.
+func (d *Device) State() hci.State {
+	return d.HCI.State()
+}
+
+// DumpState returns a snapshot of the state of the device, its connections
+// and the HCI commands in flight, e.g. to attach to a bug report.
+func (d *Device) DumpState() hci.Dump {
+	return d.HCI.DumpState()
+}
+
+// SetConnWrapper sets f to wrap the connections dialed and accepted from
+// then on, before the GATT client or server uses them, e.g. with fault.Wrap
+// to inject faults in the ATT PDUs. A nil f removes the wrapper.
+func (d *Device) SetConnWrapper(f func(ble.Conn) ble.Conn) {
+	d.HCI.SetConnWrapper(f)
+}
+
+// ListenL2CAP publishes an LE_PSM, or a dynamically allocated one if psm is
+// zero, and accepts the LE credit based channels opened to it.
+func (d *Device) ListenL2CAP(psm uint16, encrypted bool) (ble.L2CAPListener, error) {
+	l, err := d.HCI.ListenL2CAP(psm, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
 // Stop stops gatt server.
 func (d *Device) Stop() error {
 	return d.HCI.Close()
 }
 
+// WaitClosed blocks until the goroutines serving the GATT database, and
+// those of the HCI, have returned, which they do once the device is stopped.
+// Clients dialed by the device have their own WaitClosed.
+func (d *Device) WaitClosed() {
+	d.g.Wait()
+	d.HCI.WaitClosed()
+}
+
 func (d *Device) Advertise(ctx context.Context, adv ble.Advertisement) error {
 	if err := d.HCI.AdvertiseAdv(adv); err != nil {
 		return err
@@ -154,6 +432,17 @@ func (d *Device) AdvertiseServiceData16(ctx context.Context, id uint16, b []byte
 	return ctx.Err()
 }
 
+// AdvertiseServiceData advertises data associated with a 16, 32, or 128bit
+// service uuid.
+func (d *Device) AdvertiseServiceData(ctx context.Context, u ble.UUID, b []byte) error {
+	if err := d.HCI.AdvertiseServiceData(u, b); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	d.HCI.StopAdvertising()
+	return ctx.Err()
+}
+
 // AdvertiseIBeaconData advertise iBeacon with given manufacturer data.
 func (d *Device) AdvertiseIBeaconData(ctx context.Context, b []byte) error {
 	if err := d.HCI.AdvertiseIBeaconData(b); err != nil {
@@ -174,6 +463,115 @@ func (d *Device) AdvertiseIBeacon(ctx context.Context, u ble.UUID, major, minor
 	return ctx.Err()
 }
 
+// AdvertiseData advertises the given advertising data and scan response data.
+func (d *Device) AdvertiseData(ctx context.Context, ad, sr []byte) error {
+	if err := d.HCI.AdvertiseData(ad, sr); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	d.HCI.StopAdvertising()
+	return ctx.Err()
+}
+
+// AdvertiseTemplate advertises the packets built by b, and builds them again
+// every period, to refresh the fields bound to variables, see adv.Var, until
+// ctx is done. A packet is only sent again if it has changed. A refresh that
+// fails, e.g. as a variable no longer fits, is logged, and the previous
+// packets stay advertised.
+// This is linux specific.
+func (d *Device) AdvertiseTemplate(ctx context.Context, b *adv.Builder, period time.Duration) error {
+	if period <= 0 {
+		return fmt.Errorf("invalid refresh period %v", period)
+	}
+	ad, sr, err := b.Build()
+	if err != nil {
+		return err
+	}
+	if err := d.HCI.AdvertiseData(ad.Bytes(), sr.Bytes()); err != nil {
+		return err
+	}
+	defer d.HCI.StopAdvertising()
+
+	t := time.NewTicker(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		nad, nsr, err := b.Build()
+		if err != nil {
+			d.HCI.Warnf("advertising template: %v", err)
+			continue
+		}
+		srb := nsr.Bytes()
+		if bytes.Equal(srb, sr.Bytes()) {
+			if bytes.Equal(nad.Bytes(), ad.Bytes()) {
+				continue
+			}
+			srb = nil
+		}
+		if err := d.HCI.UpdateAdvertisement(nad.Bytes(), srb); err != nil {
+			d.HCI.Warnf("advertising template: %v", err)
+			continue
+		}
+		ad, sr = nad, nsr
+	}
+}
+
+// SetScanResponseData sets the scan response data independently from the
+// advertising data, e.g. to update it while advertising.
+// This is linux specific.
+func (d *Device) SetScanResponseData(sr []byte) error {
+	return d.HCI.SetScanResponseData(sr)
+}
+
+// UpdateAdvertisement replaces the advertising data ad, and the scan
+// response data sr unless it's nil, while the device goes on advertising,
+// e.g. to rotate the payload of a beacon without a gap. It may be called
+// while any of the Advertise methods runs.
+// This is linux specific.
+func (d *Device) UpdateAdvertisement(ad, sr []byte) error {
+	return d.HCI.UpdateAdvertisement(ad, sr)
+}
+
+// LocalOOBData generates LE Secure Connections OOB data of the device, to pass
+// to a peer out of band. Pairing of connections created from then on uses it.
+// This is linux specific.
+func (d *Device) LocalOOBData() (*ble.OOBData, error) {
+	return d.HCI.LocalOOBData()
+}
+
+// AdvertiseEddystoneUID advertises an Eddystone-UID frame.
+func (d *Device) AdvertiseEddystoneUID(ctx context.Context, namespace [10]byte, instance [6]byte, txPower int8) error {
+	return d.advertiseEddystone(ctx, adv.EddystoneUID{TxPower: txPower, Namespace: namespace, Instance: instance})
+}
+
+// AdvertiseEddystoneURL advertises an Eddystone-URL frame.
+func (d *Device) AdvertiseEddystoneURL(ctx context.Context, url string, txPower int8) error {
+	return d.advertiseEddystone(ctx, adv.EddystoneURL{TxPower: txPower, URL: url})
+}
+
+// AdvertiseAltBeacon advertises an AltBeacon with the specified parameters.
+func (d *Device) AdvertiseAltBeacon(ctx context.Context, mfgID uint16, id1 [16]byte, id2, id3 uint16, refRSSI int8) error {
+	if err := d.HCI.AdvertiseAltBeacon(adv.AltBeaconData{MfgID: mfgID, ID1: id1, ID2: id2, ID3: id3, RefRSSI: refRSSI}); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	d.HCI.StopAdvertising()
+	return ctx.Err()
+}
+
+func (d *Device) advertiseEddystone(ctx context.Context, f adv.EddystoneFrame) error {
+	if err := d.HCI.AdvertiseEddystone(f); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	d.HCI.StopAdvertising()
+	return ctx.Err()
+}
+
 func (d *Device) Scan(ctx context.Context, allowDup bool, h ble.AdvHandler) error {
 	if err := d.HCI.SetAdvHandler(h); err != nil {
 		return err
@@ -222,7 +620,7 @@ func (d *Device) Dial(ctx context.Context, a ble.Addr) (ble.Client, error) {
 		return nil, fmt.Errorf("device: unexpectedly received nil client")
 	}
 
-	if d.Server.DB() != nil {
+	if d.Server != nil && d.Server.DB() != nil {
 		//get client access to the local GATT DB
 		gattClient := cln.(*gatt.Client)
 		cln = gatt.ClientWithServer(gattClient, d.Server.DB())
@@ -231,6 +629,42 @@ func (d *Device) Dial(ctx context.Context, a ble.Addr) (ble.Client, error) {
 	return cln, errors.Wrap(err, "can't dial")
 }
 
+// Connect scans for the first connectable advertiser matching f, and dials
+// it. Scanning is stopped before dialing, so the controller never has to
+// scan and initiate at the same time.
+func (d *Device) Connect(ctx context.Context, f ble.AdvFilter) (ble.Client, error) {
+	// The handler runs in the event loop; only the first match is kept, and
+	// later ones are dropped rather than blocking it.
+	ch := make(chan ble.Advertisement, 1)
+	h := func(a ble.Advertisement) {
+		if !a.Connectable() || (f != nil && !f(a)) {
+			return
+		}
+		select {
+		case ch <- a:
+		default:
+		}
+	}
+	if err := d.HCI.SetAdvHandler(h); err != nil {
+		return nil, err
+	}
+	if err := d.HCI.Scan(false); err != nil {
+		return nil, errors.Wrap(err, "can't scan")
+	}
+
+	var a ble.Advertisement
+	select {
+	case a = <-ch:
+	case <-ctx.Done():
+		d.HCI.StopScanning()
+		return nil, ctx.Err()
+	}
+	if err := d.HCI.StopScanning(); err != nil {
+		return nil, errors.Wrap(err, "can't stop scanning")
+	}
+	return d.Dial(ctx, a.Addr())
+}
+
 // Address returns the listener's device address.
 func (d *Device) Address() ble.Addr {
 	return d.HCI.Addr()
@@ -0,0 +1,533 @@
+package linux_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/lifecycle"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/adv"
+	"github.com/leso-kn/ble/linux/hci"
+	"github.com/leso-kn/ble/linux/hci/virtual"
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestAdvertiseTemplate(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	level := adv.NewVar([]byte{1})
+	b := adv.NewBuilder().
+		Flags(adv.FlagGeneralDiscoverable|adv.FlagLEOnly).
+		ManufacturerDataVar(0xFFFF, level)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- p.AdvertiseTemplate(ctx, b, 20*time.Millisecond) }()
+
+	got := make(chan byte, 16)
+	sctx, scancel := context.WithCancel(ctx)
+	defer scancel()
+	go c.Scan(sctx, true, func(a ble.Advertisement) {
+		if md := a.ManufacturerData(); len(md) == 3 {
+			select {
+			case got <- md[2]:
+			default:
+			}
+		}
+	})
+	// wait waits for an advertisement with the payload v.
+	wait := func(v byte) {
+		t.Helper()
+		for {
+			select {
+			case b := <-got:
+				if b == v {
+					return
+				}
+			case <-ctx.Done():
+				t.Fatalf("payload %d not advertised", v)
+			}
+		}
+	}
+	wait(1)
+	level.SetUint8(2)
+	wait(2)
+
+	// A value which doesn't fit keeps the previous one advertised.
+	level.Set(make([]byte, 30))
+	time.Sleep(50 * time.Millisecond)
+	wait(2)
+	level.SetUint8(3)
+	wait(3)
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("AdvertiseTemplate: %v", err)
+	}
+	if s := p.State(); s.Advertising {
+		t.Fatalf("state %+v after the template", s)
+	}
+}
+
+func TestConcurrentClients(t *testing.T) {
+	const n = 8
+	air := virtual.NewAir()
+	cc, err := air.NewController("AA:BB:CC:DD:EE:FF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.SetConnectionLimit(n)
+	c, err := linux.NewDevice(ble.OptTransportVirtual(cc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	svcUUID := ble.MustParse("00010000-0001-1000-8000-00805F9B34FB")
+	chrUUID := ble.MustParse("00010000-0002-1000-8000-00805F9B34FB")
+	var addrs []ble.Addr
+	var peers []*linux.Device
+	var chrs []*ble.Characteristic
+	for i := 0; i <= n; i++ {
+		a := fmt.Sprintf("11:22:33:44:55:%02X", i)
+		pc, err := air.NewController(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := linux.NewDevice(ble.OptTransportVirtual(pc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Stop()
+
+		// The characteristic reads back the last value written.
+		var mu sync.Mutex
+		var v []byte
+		svc := ble.NewService(svcUUID)
+		chr := svc.NewCharacteristic(chrUUID)
+		chr.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+			mu.Lock()
+			v = append([]byte(nil), req.Data()...)
+			mu.Unlock()
+		}))
+		chr.HandleRead(ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+			mu.Lock()
+			rsp.Write(v)
+			mu.Unlock()
+		}))
+		chr.HandleNotify(ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {}))
+		if err := p.AddService(svc); err != nil {
+			t.Fatal(err)
+		}
+		go p.AdvertiseNameAndServices(ctx, "Gopher")
+		addrs = append(addrs, ble.NewAddr(a))
+		peers = append(peers, p)
+		chrs = append(chrs, chr)
+	}
+
+	// Each client has its own MTU and subscription.
+	clns := make([]ble.Client, n)
+	vals := make([]*ble.Characteristic, n)
+	got := make([]chan byte, n)
+	for i := range clns {
+		cln, err := c.Dial(ctx, addrs[i])
+		if err != nil {
+			t.Fatalf("dial %d: %v", i, err)
+		}
+		clns[i] = cln
+		if _, err := cln.ExchangeMTU(ble.DefaultMTU + 10*i); err != nil {
+			t.Fatal(err)
+		}
+		prof, err := cln.DiscoverProfile(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vals[i] = prof.FindCharacteristic(ble.NewCharacteristic(chrUUID))
+		got[i] = make(chan byte, 1)
+		ch := got[i]
+		if err := cln.Subscribe(vals[i], false, func(id uint, b []byte) {
+			select {
+			case ch <- b[0]:
+			default:
+			}
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, cln := range clns {
+		if mtu := cln.Conn().RxMTU(); mtu != ble.DefaultMTU+10*i {
+			t.Fatalf("client %d: Rx MTU %d", i, mtu)
+		}
+	}
+
+	// The controller refuses a ninth connection, which sets the limit.
+	if m := c.HCI.MaxConnections(); m != 0 {
+		t.Fatalf("limit %d before it's reached", m)
+	}
+	if _, err := c.Dial(ctx, addrs[n]); pkgerrors.Cause(err) != hci.ErrConnLimit {
+		t.Fatalf("dial at the limit: %v", err)
+	}
+	if m := c.HCI.MaxConnections(); m != n {
+		t.Fatalf("limit %d, want %d", m, n)
+	}
+
+	// The clients share the ACL buffers of the controller while one of them
+	// disconnects.
+	var wg sync.WaitGroup
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for k := 0; k < 20; k++ {
+				w := bytes.Repeat([]byte{byte(i), byte(k)}, (ble.DefaultMTU+10*i)/2)
+				if err := clns[i].WriteCharacteristic(vals[i], w, false); err != nil {
+					t.Errorf("client %d: write: %v", i, err)
+					return
+				}
+				r, err := clns[i].ReadLongCharacteristic(vals[i])
+				if err != nil || !bytes.Equal(r, w) {
+					t.Errorf("client %d: read % X, %v", i, r, err)
+					return
+				}
+			}
+		}(i)
+	}
+	clns[0].CancelConnection()
+	wg.Wait()
+	<-clns[0].Disconnected()
+
+	for i := 1; i < n; i++ {
+		if res := peers[i].Notify(chrs[i], false, []byte{byte(i)}); len(res.Sent) != 1 {
+			t.Fatalf("peripheral %d: notified %d centrals", i, len(res.Sent))
+		}
+		select {
+		case b := <-got[i]:
+			if b != byte(i) {
+				t.Fatalf("client %d: notified %d", i, b)
+			}
+		case <-ctx.Done():
+			t.Fatalf("client %d: no notification", i)
+		}
+	}
+
+	// A limit set by the host fails before reaching the controller.
+	if err := c.HCI.SetMaxConnections(n - 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Dial(ctx, addrs[n]); pkgerrors.Cause(err) != hci.ErrConnLimit {
+		t.Fatalf("dial at the host limit: %v", err)
+	}
+	if m, k := c.HCI.MaxConnections(), c.HCI.Connections(); m != n-1 || k != n-1 {
+		t.Fatalf("limit %d, %d connections", m, k)
+	}
+}
+
+func TestWaitClosed(t *testing.T) {
+	n := lifecycle.Running()
+	pair := virtualtest.NewPair(t, nil, []ble.Option{
+		ble.OptAddressRotation(time.Hour),
+		ble.OptScanDutyCycle(100*time.Millisecond, 100*time.Millisecond),
+	})
+	p, c := pair.Peripheral, pair.Central
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+	seen := make(chan struct{}, 1)
+	sctx, scancel := context.WithCancel(ctx)
+	scanErr := make(chan error, 1)
+	go func() {
+		scanErr <- c.Scan(sctx, true, func(a ble.Advertisement) {
+			select {
+			case seen <- struct{}{}:
+			default:
+			}
+		})
+	}()
+	select {
+	case <-seen:
+	case err := <-scanErr:
+		t.Fatalf("scan: %v", err)
+	}
+	scancel()
+	<-scanErr
+
+	// The timers and the handler calls of the devices return once they're
+	// stopped.
+	pair.Stop()
+	closed := make(chan struct{})
+	go func() {
+		c.WaitClosed()
+		p.WaitClosed()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitClosed didn't return")
+	}
+	if r := lifecycle.Running(); r > n {
+		t.Fatalf("%d goroutines still running, want %d", r, n)
+	}
+}
+
+func TestMultiRole(t *testing.T) {
+	air := virtual.NewAir()
+	dev := func(addr string) (*linux.Device, *virtual.Controller) {
+		c, err := air.NewController(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := linux.NewDevice(ble.OptTransportVirtual(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d, c
+	}
+	hub, hc := dev("11:11:11:11:11:11")
+	defer hub.Stop()
+	sensor, _ := dev("22:22:22:22:22:22")
+	defer sensor.Stop()
+	c1, _ := dev("33:33:33:33:33:33")
+	defer c1.Stop()
+	c2, _ := dev("44:44:44:44:44:44")
+	defer c2.Stop()
+	hc.SetConnectionLimit(2)
+	hc.SetInitiateWhileAdvertising(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go hub.AdvertiseNameAndServices(ctx, "Hub")
+	go sensor.AdvertiseNameAndServices(ctx, "Sensor")
+	hubAddr := ble.NewAddr("11:11:11:11:11:11")
+
+	// A peripheral of c1, the hub resumes advertising.
+	cln1, err := c1.Dial(ctx, hubAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A central of the sensor, it pauses advertising to initiate, and can't
+	// resume it at the connection limit.
+	s, err := hub.Dial(ctx, ble.NewAddr("22:22:22:22:22:22"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cln := range []ble.Client{cln1, s} {
+		if _, err := cln.DiscoverServices(nil); err != nil {
+			t.Fatalf("%v: %v", cln.Addr(), err)
+		}
+	}
+	tctx, tcancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	_, err = c2.Dial(tctx, hubAddr)
+	tcancel()
+	if err == nil {
+		t.Fatal("connected to the hub at its connection limit")
+	}
+
+	// Once a connection drops, the hub advertises again.
+	s.CancelConnection()
+	<-s.Disconnected()
+	cln2, err := c2.Dial(ctx, hubAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cln2.DiscoverServices(nil); err != nil {
+		t.Fatal(err)
+	}
+	cln1.CancelConnection()
+	cln2.CancelConnection()
+}
+
+func TestPeripheralRSSI(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p := pair.Peripheral
+
+	// A handler reads the RSSI of the central reading the characteristic.
+	chrUUID := ble.MustParse("00010000-0002-1000-8000-00805F9B34FB")
+	svc := ble.NewService(ble.MustParse("00010000-0001-1000-8000-00805F9B34FB"))
+	svc.NewCharacteristic(chrUUID).HandleRead(ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		rssi, err := req.Conn().ReadRSSI()
+		if err != nil {
+			rsp.SetStatus(ble.ErrUnlikely)
+			return
+		}
+		rsp.Write([]byte{byte(rssi)})
+	}))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	prof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := cln.ReadCharacteristic(prof.FindCharacteristic(ble.NewCharacteristic(chrUUID)))
+	if err != nil || len(b) != 1 || int8(b[0]) != -40 {
+		t.Fatalf("read % X, %v", b, err)
+	}
+
+	conns := p.Conns()
+	if len(conns) != 1 || conns[0].RemoteAddr().String() != "aa:bb:cc:dd:ee:ff" {
+		t.Fatalf("conns %v", conns)
+	}
+	if rssi, err := conns[0].ReadRSSI(); err != nil || rssi != -40 {
+		t.Fatalf("rssi %d, %v", rssi, err)
+	}
+
+	cln.CancelConnection()
+	for len(p.Conns()) != 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("connection still listed")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestCentralDevice(t *testing.T) {
+	air := virtual.NewAir()
+	pc, err := air.NewController("11:22:33:44:55:66")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := air.NewController("AA:BB:CC:DD:EE:FF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := linux.NewDevice(ble.OptTransportVirtual(pc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+	c, err := linux.NewCentralDevice(ble.OptTransportVirtual(cc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	if err := c.AddService(ble.NewService(ble.UUID16(0xFF00))); err != hci.ErrCentralOnly {
+		t.Fatalf("added a service to a central-only device: %v", err)
+	}
+
+	svc := ble.NewService(ble.UUID16(0xFF00))
+	svc.NewCharacteristic(ble.UUID16(0xFF01)).SetValue([]byte("hello"))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	actx, acancel := context.WithCancel(ctx)
+	go p.AdvertiseNameAndServices(actx, "Gopher")
+	cln, err := c.Dial(ctx, ble.NewAddr("11:22:33:44:55:66"))
+	acancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := prof.FindCharacteristic(ble.NewCharacteristic(ble.UUID16(0xFF01)))
+	if ch == nil {
+		t.Fatal("characteristic not discovered")
+	}
+	if b, err := cln.ReadCharacteristic(ch); err != nil || string(b) != "hello" {
+		t.Fatalf("read %q, %v", b, err)
+	}
+	cln.CancelConnection()
+	<-cln.Disconnected()
+
+	// Incoming connections are refused, even while advertising.
+	go c.AdvertiseNameAndServices(ctx, "Central")
+	in, err := p.Dial(ctx, ble.NewAddr("AA:BB:CC:DD:EE:FF"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-in.Disconnected():
+	case <-ctx.Done():
+		t.Fatal("incoming connection not refused")
+	}
+}
+
+func TestPeerClient(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	// The central serves the current time, the peripheral a name.
+	cts := ble.NewService(ble.UUID16(0x1805))
+	cts.NewCharacteristic(ble.UUID16(0x2A2B)).SetValue([]byte("noon"))
+	if err := c.AddService(cts); err != nil {
+		t.Fatal(err)
+	}
+	svc := ble.NewService(ble.UUID16(0xFF00))
+	svc.NewCharacteristic(ble.UUID16(0xFF01)).SetValue([]byte("gopher"))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	defer cln.CancelConnection()
+
+	var conns []ble.Conn
+	for i := 0; i < 100 && len(conns) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		conns = p.Conns()
+	}
+	if len(conns) != 1 {
+		t.Fatalf("%d connections served", len(conns))
+	}
+	peer, err := p.PeerClient(conns[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := p.PeerClient(conns[0]); err != nil || again != peer {
+		t.Fatalf("another client %p, %v", again, err)
+	}
+	if _, err := c.PeerClient(cln.Conn()); err != linux.ErrNotServed {
+		t.Fatalf("client of a dialed connection: %v", err)
+	}
+
+	// The peripheral reads the database of the central, while the central
+	// reads its own.
+	prof, err := peer.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := prof.FindCharacteristic(ble.NewCharacteristic(ble.UUID16(0x2A2B)))
+	if ch == nil {
+		t.Fatal("current time not discovered")
+	}
+	if v, err := peer.ReadCharacteristic(ch); err != nil || string(v) != "noon" {
+		t.Fatalf("read %q, %v", v, err)
+	}
+	cprof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := cprof.FindCharacteristic(ble.NewCharacteristic(ble.UUID16(0xFF01)))
+	if name == nil {
+		t.Fatal("name not discovered")
+	}
+	if v, err := cln.ReadCharacteristic(name); err != nil || string(v) != "gopher" {
+		t.Fatalf("read %q, %v", v, err)
+	}
+}
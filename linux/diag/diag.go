@@ -0,0 +1,163 @@
+// Package diag serves a diagnostic GATT service, which exposes the state of
+// the stack to the centrals and takes management actions, e.g. to manage a
+// headless gateway over BLE itself. The service is only served once added
+// to a device with Add, and by default only to encrypted connections.
+package diag
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux"
+)
+
+var (
+	// ServiceUUID is the UUID of the diagnostic service.
+	ServiceUUID = ble.MustParse("6e7a0000-5c2d-4f0b-9d3e-8b1f2a6c4d10")
+
+	PeersUUID   = ble.MustParse("6e7a0001-5c2d-4f0b-9d3e-8b1f2a6c4d10") // Number of connected centrals, a uint16.
+	UptimeUUID  = ble.MustParse("6e7a0002-5c2d-4f0b-9d3e-8b1f2a6c4d10") // Seconds since the service was added, a uint32.
+	RSSIUUID    = ble.MustParse("6e7a0003-5c2d-4f0b-9d3e-8b1f2a6c4d10") // RSSI of the reading central, an int8.
+	VersionUUID = ble.MustParse("6e7a0004-5c2d-4f0b-9d3e-8b1f2a6c4d10") // Version, as set by Config.
+	ControlUUID = ble.MustParse("6e7a0005-5c2d-4f0b-9d3e-8b1f2a6c4d10") // Control point, written with an opcode.
+)
+
+// Opcodes of the control point, followed by their parameters, if any.
+const (
+	OpClearBonds byte = 0x01 // Calls Config.ClearBonds.
+)
+
+// ErrOpcodeNotSupported answers the writes of an opcode the control point
+// has no action for.
+const ErrOpcodeNotSupported ble.ATTError = 0x80
+
+// Action is a management action of the control point, called with the
+// connection of the central, and the parameters following the opcode. An
+// error answers the write with ble.ErrWriteReqRejected.
+type Action func(c ble.Conn, params []byte) error
+
+// Config configures the diagnostic service.
+type Config struct {
+	// Version is read from the Version characteristic, e.g. the version of
+	// the application.
+	Version string
+
+	// ClearBonds, if set, is called by OpClearBonds, e.g. with DeleteAll of
+	// the bond manager of the device.
+	ClearBonds func() error
+
+	// Actions are called by the other opcodes of the control point.
+	Actions map[byte]Action
+
+	// AllowUnencrypted serves the service to connections which aren't
+	// encrypted. Otherwise, their requests fail with
+	// ble.ErrAuthentication, for the central to pair.
+	AllowUnencrypted bool
+
+	// Authorize, if set, authorizes the connections the service is served
+	// to, beyond the encryption. The requests of the others fail with
+	// ble.ErrAuthorization.
+	Authorize func(c ble.Conn) bool
+}
+
+// Add adds the diagnostic service configured by cfg to the device d, and
+// returns it.
+func Add(d *linux.Device, cfg Config) (*ble.Service, error) {
+	s := newService(d, cfg, time.Now())
+	if err := d.AddService(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func newService(d *linux.Device, cfg Config, start time.Time) *ble.Service {
+	s := ble.NewService(ServiceUUID)
+	s.NewCharacteristic(PeersUUID).HandleRead(cfg.read(func(req ble.Request) ([]byte, error) {
+		b := make([]byte, 2)
+		binary.LittleEndian.PutUint16(b, uint16(len(d.Conns())))
+		return b, nil
+	}))
+	s.NewCharacteristic(UptimeUUID).HandleRead(cfg.read(func(req ble.Request) ([]byte, error) {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(time.Since(start)/time.Second))
+		return b, nil
+	}))
+	s.NewCharacteristic(RSSIUUID).HandleRead(cfg.read(func(req ble.Request) ([]byte, error) {
+		rssi, err := req.Conn().ReadRSSI()
+		if err != nil {
+			return nil, err
+		}
+		return []byte{byte(rssi)}, nil
+	}))
+	s.NewCharacteristic(VersionUUID).HandleRead(cfg.read(func(req ble.Request) ([]byte, error) {
+		return []byte(cfg.Version), nil
+	}))
+	ctl := s.NewCharacteristic(ControlUUID)
+	ctl.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		if e := cfg.check(req.Conn()); e != ble.ErrSuccess {
+			rsp.SetStatus(e)
+			return
+		}
+		b := req.Data()
+		if len(b) == 0 {
+			rsp.SetStatus(ble.ErrInvalAttrValueLen)
+			return
+		}
+		a := cfg.action(b[0])
+		if a == nil {
+			rsp.SetStatus(ErrOpcodeNotSupported)
+			return
+		}
+		if err := a(req.Conn(), b[1:]); err != nil {
+			rsp.SetStatus(ble.ErrWriteReqRejected)
+		}
+	}))
+	// The control point is written, not read.
+	ctl.Property &^= ble.CharWriteNR
+	return s
+}
+
+// check returns the error the requests of the connection c fail with, if
+// the service isn't served to it.
+func (cfg *Config) check(c ble.Conn) ble.ATTError {
+	if !cfg.AllowUnencrypted {
+		if info, ok := ble.ConnEncryption(c); !ok || !info.Enabled {
+			return ble.ErrAuthentication
+		}
+	}
+	if cfg.Authorize != nil && !cfg.Authorize(c) {
+		return ble.ErrAuthorization
+	}
+	return ble.ErrSuccess
+}
+
+// read returns a read handler serving the values of f to the connections
+// the service is served to.
+func (cfg *Config) read(f func(req ble.Request) ([]byte, error)) ble.ReadHandler {
+	return ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		if e := cfg.check(req.Conn()); e != ble.ErrSuccess {
+			rsp.SetStatus(e)
+			return
+		}
+		v, err := f(req)
+		if err != nil {
+			rsp.SetStatus(ble.ErrUnlikely)
+			return
+		}
+		if req.Offset() < len(v) {
+			rsp.Write(v[req.Offset():])
+		}
+	})
+}
+
+// action returns the action of the opcode op, or nil.
+func (cfg *Config) action(op byte) Action {
+	if op == OpClearBonds {
+		if cfg.ClearBonds == nil {
+			return nil
+		}
+		return func(ble.Conn, []byte) error { return cfg.ClearBonds() }
+	}
+	return cfg.Actions[op]
+}
@@ -0,0 +1,123 @@
+package diag_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/diag"
+	"github.com/leso-kn/ble/linux/hci/virtual"
+)
+
+// serve serves the diagnostic service configured by cfg on a peripheral,
+// and returns a client connected to it, with its profile discovered, and a
+// function stopping both devices.
+func serve(ctx context.Context, t *testing.T, cfg diag.Config) (ble.Client, *ble.Profile, func()) {
+	air := virtual.NewAir()
+	pc, err := air.NewController("11:22:33:44:55:66")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := air.NewController("AA:BB:CC:DD:EE:FF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := linux.NewDevice(ble.OptTransportVirtual(pc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := linux.NewDevice(ble.OptTransportVirtual(cc))
+	if err != nil {
+		p.Stop()
+		t.Fatal(err)
+	}
+	stop := func() {
+		c.Stop()
+		p.Stop()
+	}
+
+	if _, err := diag.Add(p, cfg); err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	go p.AdvertiseNameAndServices(ctx, "Gateway")
+	cln, err := c.Dial(ctx, ble.NewAddr("11:22:33:44:55:66"))
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	prof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	return cln, prof, stop
+}
+
+func find(t *testing.T, prof *ble.Profile, u ble.UUID) *ble.Characteristic {
+	c := prof.FindCharacteristic(ble.NewCharacteristic(u))
+	if c == nil {
+		t.Fatalf("characteristic %s not discovered", u)
+	}
+	return c
+}
+
+func TestEncryptionRequired(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln, prof, stop := serve(ctx, t, diag.Config{Version: "1.0"})
+	defer stop()
+
+	if _, err := cln.ReadCharacteristic(find(t, prof, diag.VersionUUID)); !errors.Is(err, ble.ErrAuthentication) {
+		t.Fatalf("read over an unencrypted link: %v", err)
+	}
+	if err := cln.WriteCharacteristic(find(t, prof, diag.ControlUUID), []byte{diag.OpClearBonds}, false); !errors.Is(err, ble.ErrAuthentication) {
+		t.Fatalf("write over an unencrypted link: %v", err)
+	}
+}
+
+func TestService(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cleared := make(chan struct{}, 1)
+	cln, prof, stop := serve(ctx, t, diag.Config{
+		Version:          "1.2.3",
+		ClearBonds:       func() error { cleared <- struct{}{}; return nil },
+		Actions:          map[byte]diag.Action{0x10: func(ble.Conn, []byte) error { return errors.New("failed") }},
+		AllowUnencrypted: true,
+	})
+	defer stop()
+
+	if b, err := cln.ReadCharacteristic(find(t, prof, diag.PeersUUID)); err != nil || len(b) != 2 || binary.LittleEndian.Uint16(b) != 1 {
+		t.Fatalf("peers % X, %v", b, err)
+	}
+	if b, err := cln.ReadCharacteristic(find(t, prof, diag.UptimeUUID)); err != nil || len(b) != 4 {
+		t.Fatalf("uptime % X, %v", b, err)
+	}
+	if b, err := cln.ReadCharacteristic(find(t, prof, diag.RSSIUUID)); err != nil || len(b) != 1 {
+		t.Fatalf("RSSI % X, %v", b, err)
+	}
+	if b, err := cln.ReadCharacteristic(find(t, prof, diag.VersionUUID)); err != nil || string(b) != "1.2.3" {
+		t.Fatalf("version %q, %v", b, err)
+	}
+
+	ctl := find(t, prof, diag.ControlUUID)
+	if err := cln.WriteCharacteristic(ctl, []byte{diag.OpClearBonds}, false); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-cleared:
+	default:
+		t.Fatal("bonds not cleared")
+	}
+	if err := cln.WriteCharacteristic(ctl, []byte{0x10}, false); !errors.Is(err, ble.ErrWriteReqRejected) {
+		t.Fatalf("failed action: %v", err)
+	}
+	if err := cln.WriteCharacteristic(ctl, []byte{0x20}, false); !errors.Is(err, diag.ErrOpcodeNotSupported) {
+		t.Fatalf("unknown opcode: %v", err)
+	}
+}
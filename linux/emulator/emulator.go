@@ -0,0 +1,313 @@
+// Package emulator runs GATT peripherals declared by a profile, with
+// scripted responses, delays and errors, to test client applications and
+// gatt.Client end to end. A peripheral runs on any transport of
+// linux.Device: a virtual controller, or a second adapter.
+package emulator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/hci/virtual"
+)
+
+// Response is a scripted response to a read or a write.
+type Response struct {
+	Value      []byte        // Value read. Ignored for writes.
+	Delay      time.Duration // Delay before responding.
+	Err        ble.ATTError  // If set, the request fails with it.
+	Disconnect bool          // Drop the connection instead of responding.
+}
+
+// Characteristic declares a characteristic of a peripheral.
+type Characteristic struct {
+	UUID ble.UUID
+
+	// Value is read when no scripted response is left, and set by writes.
+	Value []byte
+
+	// Properties of the characteristic.
+	Read, Write, Notify, Indicate bool
+
+	// Reads and Writes are the responses to the first reads and writes, in
+	// turn. Once they're used up, requests succeed.
+	Reads  []Response
+	Writes []Response
+}
+
+// Service declares a primary service of a peripheral.
+type Service struct {
+	UUID            ble.UUID
+	Characteristics []Characteristic
+}
+
+// Profile declares a peripheral.
+type Profile struct {
+	Name     string // Advertised, along with the UUIDs of the services.
+	Services []Service
+}
+
+// Peripheral is a running emulated peripheral.
+type Peripheral struct {
+	dev    *linux.Device
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	chars map[string]*char
+}
+
+type char struct {
+	value     []byte
+	reads     []Response
+	writes    []Response
+	written   [][]byte
+	notifiers map[ble.Notifier]struct{}
+}
+
+// New runs the peripheral p on a device created with opts, e.g.
+// ble.OptTransportVirtual or ble.OptDeviceID, and advertises it until it's
+// closed.
+func New(p Profile, opts ...ble.Option) (*Peripheral, error) {
+	dev, err := linux.NewDeviceWithName(p.Name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	e := &Peripheral{dev: dev, chars: make(map[string]*char)}
+
+	var svcs []*ble.Service
+	var uuids []ble.UUID
+	for _, s := range p.Services {
+		svc := ble.NewService(s.UUID)
+		for _, c := range s.Characteristics {
+			if _, ok := e.chars[c.UUID.String()]; ok {
+				dev.Stop()
+				return nil, fmt.Errorf("characteristic %s declared twice", c.UUID)
+			}
+			svc.AddCharacteristic(e.characteristic(c))
+		}
+		svcs = append(svcs, svc)
+		uuids = append(uuids, s.UUID)
+	}
+	if err := dev.SetServices(svcs); err != nil {
+		dev.Stop()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	go dev.AdvertiseNameAndServices(ctx, p.Name, uuids...)
+	return e, nil
+}
+
+// NewVirtual runs the peripheral p on a virtual controller with the address
+// addr, attached to air.
+func NewVirtual(air *virtual.Air, addr string, p Profile) (*Peripheral, error) {
+	ctrl, err := air.NewController(addr)
+	if err != nil {
+		return nil, err
+	}
+	return New(p, ble.OptTransportVirtual(ctrl))
+}
+
+// characteristic returns the characteristic declared by c, served by e.
+func (e *Peripheral) characteristic(c Characteristic) *ble.Characteristic {
+	ch := &char{
+		value:     append([]byte(nil), c.Value...),
+		reads:     append([]Response(nil), c.Reads...),
+		writes:    append([]Response(nil), c.Writes...),
+		notifiers: make(map[ble.Notifier]struct{}),
+	}
+	e.chars[c.UUID.String()] = ch
+
+	bc := ble.NewCharacteristic(c.UUID)
+	if c.Read {
+		bc.HandleRead(ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+			e.mu.Lock()
+			r, ok := next(&ch.reads)
+			v := ch.value
+			e.mu.Unlock()
+			if ok {
+				if !respond(r, req, rsp) {
+					return
+				}
+				v = r.Value
+			}
+			if req.Offset() < len(v) {
+				rsp.Write(v[req.Offset():])
+			}
+		}))
+	}
+	if c.Write {
+		bc.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+			e.mu.Lock()
+			r, ok := next(&ch.writes)
+			e.mu.Unlock()
+			if ok && !respond(r, req, rsp) {
+				return
+			}
+			b := append([]byte(nil), req.Data()...)
+			e.mu.Lock()
+			ch.value = b
+			ch.written = append(ch.written, b)
+			e.mu.Unlock()
+		}))
+	}
+	h := ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {
+		e.mu.Lock()
+		ch.notifiers[n] = struct{}{}
+		e.mu.Unlock()
+		<-n.Context().Done()
+		e.mu.Lock()
+		delete(ch.notifiers, n)
+		e.mu.Unlock()
+	})
+	if c.Notify {
+		bc.HandleNotify(h)
+	}
+	if c.Indicate {
+		bc.HandleIndicate(h)
+	}
+	return bc
+}
+
+// next pops the next scripted response off rs. Must be called with e.mu held.
+func next(rs *[]Response) (Response, bool) {
+	if len(*rs) == 0 {
+		return Response{}, false
+	}
+	r := (*rs)[0]
+	*rs = (*rs)[1:]
+	return r, true
+}
+
+// respond applies the delay and the failures of r to a request. It reports
+// whether the request goes on.
+func respond(r Response, req ble.Request, rsp ble.ResponseWriter) bool {
+	time.Sleep(r.Delay)
+	switch {
+	case r.Disconnect:
+		req.Conn().Close()
+		return false
+	case r.Err != ble.ErrSuccess:
+		rsp.SetStatus(r.Err)
+		return false
+	}
+	return true
+}
+
+func (e *Peripheral) char(u ble.UUID) (*char, error) {
+	ch, ok := e.chars[u.String()]
+	if !ok {
+		return nil, fmt.Errorf("characteristic %s not declared", u)
+	}
+	return ch, nil
+}
+
+// Device returns the device the peripheral runs on.
+func (e *Peripheral) Device() *linux.Device {
+	return e.dev
+}
+
+// Addr returns the address of the peripheral.
+func (e *Peripheral) Addr() ble.Addr {
+	return e.dev.Address()
+}
+
+// Value returns the value of the characteristic u.
+func (e *Peripheral) Value(u ble.UUID) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ch, err := e.char(u)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), ch.value...), nil
+}
+
+// SetValue sets the value of the characteristic u.
+func (e *Peripheral) SetValue(u ble.UUID, v []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ch, err := e.char(u)
+	if err != nil {
+		return err
+	}
+	ch.value = append([]byte(nil), v...)
+	return nil
+}
+
+// Written returns the values written to the characteristic u so far.
+func (e *Peripheral) Written(u ble.UUID) ([][]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ch, err := e.char(u)
+	if err != nil {
+		return nil, err
+	}
+	return append([][]byte(nil), ch.written...), nil
+}
+
+// ScriptReads appends responses to the ones of the next reads of the
+// characteristic u.
+func (e *Peripheral) ScriptReads(u ble.UUID, rs ...Response) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ch, err := e.char(u)
+	if err != nil {
+		return err
+	}
+	ch.reads = append(ch.reads, rs...)
+	return nil
+}
+
+// ScriptWrites appends responses to the ones of the next writes of the
+// characteristic u.
+func (e *Peripheral) ScriptWrites(u ble.UUID, rs ...Response) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ch, err := e.char(u)
+	if err != nil {
+		return err
+	}
+	ch.writes = append(ch.writes, rs...)
+	return nil
+}
+
+// Notify sets the value of the characteristic u, and sends it to the
+// centrals subscribed to it. It returns the number of centrals it was sent
+// to; indications return once they're confirmed.
+func (e *Peripheral) Notify(u ble.UUID, v []byte) (int, error) {
+	e.mu.Lock()
+	ch, err := e.char(u)
+	if err != nil {
+		e.mu.Unlock()
+		return 0, err
+	}
+	ch.value = append([]byte(nil), v...)
+	var ns []ble.Notifier
+	for n := range ch.notifiers {
+		ns = append(ns, n)
+	}
+	e.mu.Unlock()
+
+	sent := 0
+	for _, n := range ns {
+		if _, err := n.Write(v); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// Close stops the peripheral, and waits for it to be stopped.
+func (e *Peripheral) Close() error {
+	e.cancel()
+	err := e.dev.Stop()
+	e.dev.WaitClosed()
+	return err
+}
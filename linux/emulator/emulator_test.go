@@ -0,0 +1,127 @@
+package emulator_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/emulator"
+	"github.com/leso-kn/ble/linux/hci/virtual"
+)
+
+var (
+	svcUUID   = ble.MustParse("00010000-0001-1000-8000-00805F9B34FB")
+	valueUUID = ble.MustParse("00010000-0002-1000-8000-00805F9B34FB")
+	eventUUID = ble.MustParse("00010000-0003-1000-8000-00805F9B34FB")
+)
+
+func TestPeripheral(t *testing.T) {
+	air := virtual.NewAir()
+	p, err := emulator.NewVirtual(air, "11:22:33:44:55:66", emulator.Profile{
+		Name: "Emulated",
+		Services: []emulator.Service{{
+			UUID: svcUUID,
+			Characteristics: []emulator.Characteristic{
+				{
+					UUID:  valueUUID,
+					Value: []byte("idle"),
+					Read:  true,
+					Write: true,
+					Reads: []emulator.Response{
+						{Value: []byte("first"), Delay: 50 * time.Millisecond},
+						{Err: ble.ErrReadNotPerm},
+					},
+				},
+				{UUID: eventUUID, Notify: true},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	cc, err := air.NewController("AA:BB:CC:DD:EE:FF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := linux.NewDevice(ble.OptTransportVirtual(cc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln, err := c.Connect(ctx, func(a ble.Advertisement) bool { return a.LocalName() == "Emulated" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	prof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := prof.FindCharacteristic(ble.NewCharacteristic(valueUUID))
+	event := prof.FindCharacteristic(ble.NewCharacteristic(eventUUID))
+	if value == nil || event == nil {
+		t.Fatal("characteristics not discovered")
+	}
+
+	// The scripted reads, then the value.
+	start := time.Now()
+	if b, err := cln.ReadCharacteristic(value); err != nil || string(b) != "first" {
+		t.Fatalf("read %q, %v", b, err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("read took %v, not delayed", d)
+	}
+	if _, err := cln.ReadCharacteristic(value); err == nil {
+		t.Fatal("scripted error not returned")
+	}
+	if b, err := cln.ReadCharacteristic(value); err != nil || string(b) != "idle" {
+		t.Fatalf("read %q, %v", b, err)
+	}
+
+	if err := cln.WriteCharacteristic(value, []byte("set"), false); err != nil {
+		t.Fatal(err)
+	}
+	if w, _ := p.Written(valueUUID); len(w) != 1 || string(w[0]) != "set" {
+		t.Fatalf("written %q", w)
+	}
+
+	got := make(chan []byte, 1)
+	if err := cln.Subscribe(event, false, ble.RetainNotifications(func(id uint, b []byte) { got <- b })); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		// The subscription reaches the peripheral after the CCCD write.
+		n, err := p.Notify(eventUUID, []byte{0x01})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case b := <-got:
+		if !bytes.Equal(b, []byte{0x01}) {
+			t.Fatalf("notified % X", b)
+		}
+	case <-ctx.Done():
+		t.Fatal("no notification")
+	}
+
+	// A scripted disconnection.
+	p.ScriptReads(valueUUID, emulator.Response{Disconnect: true})
+	cln.ReadCharacteristic(value)
+	select {
+	case <-cln.Disconnected():
+	case <-ctx.Done():
+		t.Fatal("not disconnected")
+	}
+}
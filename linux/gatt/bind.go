@@ -0,0 +1,91 @@
+package gatt
+
+import (
+	"sync"
+
+	"github.com/leso-kn/ble"
+)
+
+// Binding binds the value of a characteristic served by a Server to
+// channels, in place of its handlers.
+type Binding struct {
+	// Set takes the values set by the application. Each value is stored,
+	// and notified, or indicated, to the subscribed centrals. Closing Set
+	// ends the binding; the last value is still read afterwards.
+	Set chan<- []byte
+
+	// Written delivers the values written by centrals, which are stored as
+	// well. A write waits for the value to be received from Written, until
+	// the binding ends.
+	Written <-chan []byte
+
+	mu    sync.Mutex
+	value []byte
+	done  chan struct{}
+}
+
+// Bind binds the value of the characteristic c to a Binding. The value is
+// initially c.Value. The characteristic is readable and writable, and is
+// notified, or indicated if ind is set. Like the handlers it replaces, Bind
+// must be called before the containing service is added to the server.
+func (s *Server) Bind(c *ble.Characteristic, ind bool) *Binding {
+	set := make(chan []byte)
+	written := make(chan []byte)
+	b := &Binding{
+		Set:     set,
+		Written: written,
+		value:   c.Value,
+		done:    make(chan struct{}),
+	}
+	c.Value = nil
+
+	c.HandleRead(ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		v := b.Value()
+		if req.Offset() < len(v) {
+			rsp.Write(v[req.Offset():])
+		}
+	}))
+	c.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		v := append([]byte(nil), req.Data()...)
+		b.store(v)
+		select {
+		case written <- v:
+		case <-b.done:
+		}
+	}))
+	// Subscriptions are tracked by the server; the handler only enables them.
+	h := ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {})
+	if ind {
+		c.HandleIndicate(h)
+	} else {
+		c.HandleNotify(h)
+	}
+
+	s.g.Go(func() {
+		defer close(b.done)
+		for v := range set {
+			b.store(v)
+			s.Notify(c, ind, v)
+		}
+	})
+	return b
+}
+
+// WaitClosed blocks until the goroutines of the bindings of s have returned,
+// which they do once their Set channels are closed.
+func (s *Server) WaitClosed() {
+	s.g.Wait()
+}
+
+// Value returns the current value of the characteristic.
+func (b *Binding) Value() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.value
+}
+
+func (b *Binding) store(v []byte) {
+	b.mu.Lock()
+	b.value = v
+	b.mu.Unlock()
+}
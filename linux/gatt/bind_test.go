@@ -0,0 +1,70 @@
+package gatt_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+)
+
+func TestBind(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p := pair.Peripheral
+
+	chrUUID := ble.MustParse("00010000-0002-1000-8000-00805F9B34FB")
+	svc := ble.NewService(ble.MustParse("00010000-0001-1000-8000-00805F9B34FB"))
+	chr := svc.NewCharacteristic(chrUUID)
+	chr.SetValue([]byte("idle"))
+	b := p.Bind(chr, false)
+	defer close(b.Set)
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	prof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := prof.FindCharacteristic(ble.NewCharacteristic(chrUUID))
+	if v == nil {
+		t.Fatal("characteristic not discovered")
+	}
+	if got, err := cln.ReadCharacteristic(v); err != nil || string(got) != "idle" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+
+	go func() {
+		if got := <-b.Written; string(got) != "set" {
+			t.Errorf("written %q", got)
+		}
+	}()
+	if err := cln.WriteCharacteristic(v, []byte("set"), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Value(); string(got) != "set" {
+		t.Errorf("value %q after write", got)
+	}
+
+	notified := make(chan []byte, 1)
+	if err := cln.Subscribe(v, false, func(id uint, b []byte) { notified <- b }); err != nil {
+		t.Fatal(err)
+	}
+	b.Set <- []byte("telemetry")
+	select {
+	case got := <-notified:
+		if string(got) != "telemetry" {
+			t.Fatalf("notified %q", got)
+		}
+	case <-ctx.Done():
+		t.Fatal("no notification")
+	}
+	if got, err := cln.ReadCharacteristic(v); err != nil || string(got) != "telemetry" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+}
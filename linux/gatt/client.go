@@ -1,6 +1,7 @@
 package gatt
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/lifecycle"
 	"github.com/leso-kn/ble/linux/att"
 )
 
@@ -25,23 +27,83 @@ type Client struct {
 	subs    map[uint16]*sub
 
 	ac *att.Client
+	g  lifecycle.Group // Runs the loop of ac, and the requests of its handlers.
 
 	conn  ble.Conn
 	cache ble.GattCache
 
+	// fullUUIDs keeps the discovered UUIDs as the server encoded them.
+	fullUUIDs bool
+
+	// muReads guards coalesce and reads, the reads in flight by handle.
+	muReads  sync.Mutex
+	coalesce bool
+	reads    map[uint16]*readCall
+
+	// lastToken is the token of the notification handler added last.
+	lastToken NotificationToken
+
+	// unregistered decides on the notifications without subscription.
+	unregistered func(pdu []byte) UnregisteredAction
+
 	ble.Logger
 }
 
+// readCall is a read in flight, whose result is shared by the concurrent
+// reads of the same handle.
+type readCall struct {
+	done chan struct{}
+	val  []byte
+	err  error
+}
+
+// A NotificationToken identifies a notification handler added with
+// AddNotificationHandler, to remove it.
+type NotificationToken uint64
+
 type sub struct {
-	cccdh    uint16
-	ccc      uint16
-	nHandler ble.NotificationHandler
-	iHandler ble.NotificationHandler
-	id       uint
+	cccdh     uint16
+	ccc       uint16
+	nHandlers []subHandler
+	iHandlers []subHandler
+	id        uint
+}
+
+// subHandler is a handler of a subscription, h, or th if it was subscribed
+// with SubscribeTimestamped.
+type subHandler struct {
+	token NotificationToken
+	h     ble.NotificationHandler
+	th    ble.TimestampedNotificationHandler
+}
+
+func (sh subHandler) set() bool { return sh.h != nil || sh.th != nil }
+
+func (sh subHandler) handle(id uint, b []byte, t time.Time) {
+	if sh.th != nil {
+		sh.th(id, b, t)
+		return
+	}
+	sh.h(id, b)
+}
+
+// handlers returns the handlers of the notifications, or the indications.
+func (s *sub) handlers(flag uint16) *[]subHandler {
+	if flag == cccNotify {
+		return &s.nHandlers
+	}
+	return &s.iHandlers
 }
 
 // NewClient returns a GATT Client.
 func NewClient(conn ble.Conn, cache ble.GattCache, done chan bool, l ble.Logger) (*Client, error) {
+	return NewClientWithWorkers(conn, cache, done, l, 1)
+}
+
+// NewClientWithWorkers is like NewClient, with the notifications of
+// different characteristics handled in parallel by up to workers goroutines.
+// Those of a characteristic are still handled in order.
+func NewClientWithWorkers(conn ble.Conn, cache ble.GattCache, done chan bool, l ble.Logger, workers int) (*Client, error) {
 	cl := l.ChildLogger(map[string]interface{}{"gatt": hex.EncodeToString(conn.RemoteAddr().Bytes())})
 	p := &Client{
 		subs:   make(map[uint16]*sub),
@@ -50,12 +112,118 @@ func NewClient(conn ble.Conn, cache ble.GattCache, done chan bool, l ble.Logger)
 		Logger: cl,
 	}
 	p.ac = att.NewClient(conn, p, done, cl)
+	p.ac.SetNotificationWorkers(workers)
 
-	go p.ac.Loop()
+	p.g.Go(p.ac.Loop)
 
 	return p, nil
 }
 
+// WaitClosed blocks until all the goroutines of the client have returned,
+// which they do once the connection is closed, or the device stopped.
+func (p *Client) WaitClosed() {
+	// The notification handlers of ac start goroutines in g.
+	p.ac.WaitClosed()
+	p.g.Wait()
+}
+
+// SetUUIDCompression sets whether the discovered 128-bit UUIDs based on the
+// Bluetooth Base UUID are compressed to their 16-bit or 32-bit form, as they
+// are by default, whichever form the server used.
+func (p *Client) SetUUIDCompression(on bool) {
+	p.Lock()
+	defer p.Unlock()
+	p.fullUUIDs = !on
+}
+
+// uuid returns the UUID discovered in b. Must be called with p locked.
+func (p *Client) uuid(b []byte) ble.UUID {
+	u := ble.UUID(b)
+	if p.fullUUIDs {
+		return u
+	}
+	return u.Compress()
+}
+
+// SetRateLimit limits the ATT requests and commands sent to the peer to rate
+// per second, and spaces them by at least spacing, for peripherals which
+// crash or disconnect when they're polled too quickly. Operations wait for
+// their turn. Zero values lift the limits.
+func (p *Client) SetRateLimit(rate float64, spacing time.Duration) error {
+	return p.ac.SetRateLimit(rate, spacing)
+}
+
+// SetNotificationOrdering sets whether ReadCharacteristic and
+// ReadLongCharacteristic return only once the notifications and indications
+// of the characteristic received before their response are handled, rather
+// than possibly before. Notification handlers may issue operations of p, but
+// must then not wait for those of other goroutines.
+func (p *Client) SetNotificationOrdering(on bool) {
+	p.ac.SetNotificationOrdering(on)
+}
+
+// SetStrictSequential sets whether an operation attempted while a request
+// of another one waits for its response fails at once, with an error
+// matching att.ErrRequestPending, rather than waiting for it.
+func (p *Client) SetStrictSequential(on bool) {
+	p.ac.SetStrictSequential(on)
+}
+
+// PendingRequest returns the ATT request waiting for its response, if any,
+// e.g. to diagnose an operation which doesn't return.
+func (p *Client) PendingRequest() (att.PendingRequest, bool) {
+	return p.ac.PendingRequest()
+}
+
+// CancelPendingRequest has the operation whose request waits for its
+// response return att.ErrRequestCanceled, and reports whether there was
+// one. The next operations wait until the response arrives anyway, or the
+// transaction times out.
+func (p *Client) CancelPendingRequest() bool {
+	return p.ac.CancelPendingRequest()
+}
+
+// SetResponseTolerance sets whether the ATT responses are correlated with
+// the pending request, and for how long the response of a request timed out
+// is expected, see att.Client.SetResponseTolerance.
+func (p *Client) SetResponseTolerance(d time.Duration) {
+	p.ac.SetResponseTolerance(d)
+}
+
+// DiscardedResponses returns the counts of the ATT responses discarded, as
+// duplicate, late or mismatched.
+func (p *Client) DiscardedResponses() att.DiscardedResponses {
+	return p.ac.DiscardedResponses()
+}
+
+// SetReadCoalescing sets whether concurrent ReadCharacteristic calls for the
+// same characteristic are coalesced into a single ATT read, whose result they
+// all return. A call joining a read in flight gets the value read by it, which
+// may predate the call.
+func (p *Client) SetReadCoalescing(on bool) {
+	p.muReads.Lock()
+	defer p.muReads.Unlock()
+	p.coalesce = on
+	if on && p.reads == nil {
+		p.reads = make(map[uint16]*readCall)
+	}
+}
+
+// matches reports whether the UUID u passes the discovery filter, in any of
+// its forms.
+func matches(filter []ble.UUID, u ble.UUID) bool {
+	if filter == nil {
+		return true
+	}
+	u = u.Compress()
+	for _, f := range filter {
+		if f.Compress().Equal(u) {
+			return true
+		}
+	}
+	return false
+}
+
 func ClientWithServer(c *Client, db *att.DB) *Client {
 	c.ac = c.ac.WithServer(db)
 	return c
@@ -140,38 +308,94 @@ func (p *Client) DiscoverServices(filter []ble.UUID) ([]*ble.Service, error) {
 	if p.profile == nil {
 		p.profile = &ble.Profile{}
 	}
-	start := uint16(0x0001)
-	for {
-		length, b, err := p.ac.ReadByGroupType(start, 0xFFFF, ble.PrimaryServiceUUID)
-		if err == ble.ErrAttrNotFound {
-			return p.profile.Services, nil
+	ss, err := p.primaryServices(filter)
+	if err != nil {
+		return nil, err
+	}
+	p.profile.Services = append(p.profile.Services, ss...)
+	return p.profile.Services, nil
+}
+
+// primaryServices finds the primary services on the server matching
+// filter. Must be called with p locked.
+func (p *Client) primaryServices(filter []ble.UUID) ([]*ble.Service, error) {
+	var ss []*ble.Service
+	err := p.ac.ReadByGroupTypeEach(0x0001, 0xFFFF, ble.PrimaryServiceUUID, func(h, endh uint16, v []byte) bool {
+		u := p.uuid(append([]byte(nil), v...))
+		if matches(filter, u) {
+			s := &ble.Service{
+				UUID:      u,
+				Handle:    h,
+				EndHandle: endh,
+			}
+			ss = append(ss, s)
 		}
+		return len(ss) != len(filter)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// InvalidateProfile drops the discovered profile, and the cached one, if
+// any, so that the next discovery starts over.
+func (p *Client) InvalidateProfile() error {
+	p.Lock()
+	p.profile = nil
+	p.Unlock()
+	if p.cache == nil {
+		return nil
+	}
+	return p.cache.Remove(p.Addr())
+}
+
+// RefreshService rediscovers the primary service u, with its
+// characteristics and descriptors, in place of the one of the profile. The
+// cached profile, if any, is updated as well. Subscriptions to the
+// characteristics of the service must be renewed, as their handles may have
+// changed.
+func (p *Client) RefreshService(u ble.UUID) (*ble.Service, error) {
+	p.Lock()
+	ss, err := p.primaryServices([]ble.UUID{u})
+	p.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var s *ble.Service
+	if len(ss) != 0 {
+		s = ss[0]
+		cs, err := p.DiscoverCharacteristics(nil, s)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("can't discover characteristics: %s", err)
 		}
-		for len(b) != 0 {
-			h := binary.LittleEndian.Uint16(b[:2])
-			endh := binary.LittleEndian.Uint16(b[2:4])
-			u := ble.UUID(b[4:length])
-			if filter == nil || ble.Contains(filter, u) {
-				s := &ble.Service{
-					UUID:      u,
-					Handle:    h,
-					EndHandle: endh,
-				}
-				p.profile.Services = append(p.profile.Services, s)
-			}
-			if endh == 0xFFFF {
-				return p.profile.Services, nil
+		for _, c := range cs {
+			if _, err := p.DiscoverDescriptors(nil, c); err != nil {
+				return nil, fmt.Errorf("can't discover descriptors: %s", err)
 			}
+		}
+	}
 
-			if len(p.profile.Services) == len(filter) {
-				return p.profile.Services, nil
+	p.Lock()
+	if p.profile == nil {
+		p.profile = &ble.Profile{}
+	}
+	p.profile.ReplaceService(u, s)
+	prof := *p.profile
+	p.Unlock()
+
+	if p.cache != nil {
+		// Only a profile cached by DiscoverAndCacheProfile is updated.
+		if _, err := p.cache.Load(p.Addr()); err == nil {
+			if err := p.cache.Store(p.Addr(), prof, true); err != nil {
+				return s, err
 			}
-			start = endh + 1
-			b = b[length:]
 		}
 	}
+	if s == nil {
+		return nil, fmt.Errorf("service %s not found", u)
+	}
+	return s, nil
 }
 
 // DiscoverIncludedServices finds the included services of a service. [Vol 3, Part G, 4.5.1]
@@ -187,37 +411,34 @@ func (p *Client) DiscoverIncludedServices(ss []ble.UUID, s *ble.Service) ([]*ble
 func (p *Client) DiscoverCharacteristics(filter []ble.UUID, s *ble.Service) ([]*ble.Characteristic, error) {
 	p.Lock()
 	defer p.Unlock()
-	start := s.Handle
 	var lastChar *ble.Characteristic
-	for start <= s.EndHandle {
-		length, b, err := p.ac.ReadByType(start, s.EndHandle, ble.CharacteristicUUID)
-		if err == ble.ErrAttrNotFound {
-			break
-		} else if err != nil {
-			return nil, err
+	var short bool
+	err := p.ac.ReadByTypeEach(s.Handle, s.EndHandle, ble.CharacteristicUUID, func(h uint16, v []byte) bool {
+		if len(v) < 3 {
+			short = true
+			return false
 		}
-		for len(b) != 0 {
-			h := binary.LittleEndian.Uint16(b[:2])
-			p := ble.Property(b[2])
-			vh := binary.LittleEndian.Uint16(b[3:5])
-			u := ble.UUID(b[5:length])
-			c := &ble.Characteristic{
-				UUID:        u,
-				Property:    p,
-				Handle:      h,
-				ValueHandle: vh,
-				EndHandle:   s.EndHandle,
-			}
-			if filter == nil || ble.Contains(filter, u) {
-				s.Characteristics = append(s.Characteristics, c)
-			}
-			if lastChar != nil {
-				lastChar.EndHandle = c.Handle - 1
-			}
-			lastChar = c
-			start = vh + 1
-			b = b[length:]
+		c := &ble.Characteristic{
+			UUID:        p.uuid(append([]byte(nil), v[3:]...)),
+			Property:    ble.Property(v[0]),
+			Handle:      h,
+			ValueHandle: binary.LittleEndian.Uint16(v[1:3]),
+			EndHandle:   s.EndHandle,
+		}
+		if matches(filter, c.UUID) {
+			s.Characteristics = append(s.Characteristics, c)
 		}
+		if lastChar != nil {
+			lastChar.EndHandle = c.Handle - 1
+		}
+		lastChar = c
+		return true
+	})
+	if err == nil && short {
+		err = att.ErrInvalidResponse
+	}
+	if err != nil {
+		return nil, err
 	}
 	return s.Characteristics, nil
 }
@@ -241,9 +462,9 @@ func (p *Client) DiscoverDescriptors(filter []ble.UUID, c *ble.Characteristic) (
 		}
 		for len(b) != 0 {
 			h := binary.LittleEndian.Uint16(b[:2])
-			u := ble.UUID(b[2:length])
+			u := p.uuid(b[2:length])
 			d := &ble.Descriptor{UUID: u, Handle: h}
-			if filter == nil || ble.Contains(filter, u) {
+			if matches(filter, u) {
 				c.Descriptors = append(c.Descriptors, d)
 			}
 			if u.Equal(ble.ClientCharacteristicConfigUUID) {
@@ -256,8 +477,102 @@ func (p *Client) DiscoverDescriptors(filter []ble.UUID, c *ble.Characteristic) (
 	return c.Descriptors, nil
 }
 
+// findDescriptor finds the descriptor u of c, whose descriptors weren't
+// discovered, with Find Information requests over the handles of its
+// descriptors, up to the descriptor. It returns nil if c has none. Must be
+// called with p locked.
+func (p *Client) findDescriptor(c *ble.Characteristic, u ble.UUID) (*ble.Descriptor, error) {
+	start := c.ValueHandle + 1
+	for start != 0 && start <= c.EndHandle {
+		format, b, err := p.ac.FindInformation(start, c.EndHandle)
+		if err == ble.ErrAttrNotFound {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		length := 2 + 2
+		if format == 0x02 {
+			length = 2 + 16
+		}
+		for len(b) >= length {
+			h := binary.LittleEndian.Uint16(b[:2])
+			du := p.uuid(b[2:length])
+			if matches([]ble.UUID{u}, du) {
+				return &ble.Descriptor{UUID: du, Handle: h}, nil
+			}
+			if matches(declarations, du) {
+				// Past the descriptors of c.
+				return nil, nil
+			}
+			start = h + 1
+			b = b[length:]
+		}
+	}
+	return nil, nil
+}
+
+// declarations are the UUIDs of the declarations which end the descriptors
+// of a characteristic.
+var declarations = []ble.UUID{ble.PrimaryServiceUUID, ble.SecondaryServiceUUID, ble.IncludeUUID, ble.CharacteristicUUID}
+
+// descriptor returns the descriptor u of c, discovered or else found.
+// Must be called with p locked.
+func (p *Client) descriptor(c *ble.Characteristic, u ble.UUID) (*ble.Descriptor, error) {
+	for _, d := range c.Descriptors {
+		if matches([]ble.UUID{u}, d.UUID) {
+			return d, nil
+		}
+	}
+	if c.CCCD != nil && matches([]ble.UUID{u}, c.CCCD.UUID) {
+		return c.CCCD, nil
+	}
+	d, err := p.findDescriptor(c, u)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, fmt.Errorf("descriptor %s not found", u)
+	}
+	if u.Equal(ble.ClientCharacteristicConfigUUID) {
+		c.CCCD = d
+	}
+	return d, nil
+}
+
 // ReadCharacteristic reads a characteristic value from a server. [Vol 3, Part G, 4.8.1]
 func (p *Client) ReadCharacteristic(c *ble.Characteristic) ([]byte, error) {
+	p.muReads.Lock()
+	if !p.coalesce {
+		p.muReads.Unlock()
+		return p.read(c)
+	}
+	if call, ok := p.reads[c.ValueHandle]; ok {
+		p.muReads.Unlock()
+		<-call.done
+		if call.err != nil {
+			return nil, call.err
+		}
+		val := append([]byte(nil), call.val...)
+		p.Lock()
+		c.Value = val
+		p.Unlock()
+		return val, nil
+	}
+	call := &readCall{done: make(chan struct{})}
+	p.reads[c.ValueHandle] = call
+	p.muReads.Unlock()
+
+	call.val, call.err = p.read(c)
+
+	p.muReads.Lock()
+	delete(p.reads, c.ValueHandle)
+	p.muReads.Unlock()
+	close(call.done)
+	return call.val, call.err
+}
+
+// read reads the value of c in an ATT transaction of its own.
+func (p *Client) read(c *ble.Characteristic) ([]byte, error) {
 	p.Lock()
 	defer p.Unlock()
 	val, err := p.ac.Read(c.ValueHandle)
@@ -283,7 +598,7 @@ func (p *Client) ReadLongCharacteristic(c *ble.Characteristic) ([]byte, error) {
 	}
 	buffer = append(buffer, read...)
 
-	for len(read) >= p.conn.TxMTU()-1 {
+	for len(read) >= ble.MaxValueLen(p.conn.TxMTU(), ble.ReadOverhead) {
 		if read, err = p.ac.ReadBlob(c.ValueHandle, uint16(len(buffer))); err != nil {
 			return nil, err
 		}
@@ -304,6 +619,29 @@ func (p *Client) WriteCharacteristic(c *ble.Characteristic, v []byte, noRsp bool
 	return p.ac.Write(c.ValueHandle, v)
 }
 
+// WriteLongCharacteristic writes a characteristic value which is longer
+// than ATT_MTU-3, in chunks queued with Prepare Write Requests, and then
+// written at once with an Execute Write Request. The queued chunks are
+// cancelled if one fails, or isn't echoed as sent. [Vol 3, Part G, 4.9.4]
+func (p *Client) WriteLongCharacteristic(c *ble.Characteristic, v []byte) error {
+	p.Lock()
+	defer p.Unlock()
+
+	offset := 0
+	for _, chunk := range ble.Chunks(v, p.conn.TxMTU(), ble.PrepareWriteOverhead) {
+		h, o, echo, err := p.ac.PrepareWrite(c.ValueHandle, uint16(offset), chunk)
+		if err == nil && (h != c.ValueHandle || int(o) != offset || !bytes.Equal(echo, chunk)) {
+			err = fmt.Errorf("prepare write at offset %d not echoed as sent", offset)
+		}
+		if err != nil {
+			p.ac.ExecuteWrite(0x00)
+			return err
+		}
+		offset += len(chunk)
+	}
+	return p.ac.ExecuteWrite(0x01)
+}
+
 // ReadDescriptor reads a characteristic descriptor from a server. [Vol 3, Part G, 4.12.1]
 func (p *Client) ReadDescriptor(d *ble.Descriptor) ([]byte, error) {
 	p.Lock()
@@ -324,6 +662,36 @@ func (p *Client) WriteDescriptor(d *ble.Descriptor, v []byte) error {
 	return p.ac.Write(d.Handle, v)
 }
 
+// ReadDescriptorByUUID reads the descriptor u of the characteristic c, e.g.
+// its User Description, which is found first if the descriptors of c weren't
+// discovered.
+func (p *Client) ReadDescriptorByUUID(c *ble.Characteristic, u ble.UUID) ([]byte, error) {
+	p.Lock()
+	defer p.Unlock()
+	d, err := p.descriptor(c, u)
+	if err != nil {
+		return nil, err
+	}
+	val, err := p.ac.Read(d.Handle)
+	if err != nil {
+		return nil, err
+	}
+	d.Value = val
+	return val, nil
+}
+
+// WriteDescriptorByUUID writes the descriptor u of the characteristic c,
+// which is found first if the descriptors of c weren't discovered.
+func (p *Client) WriteDescriptorByUUID(c *ble.Characteristic, u ble.UUID, v []byte) error {
+	p.Lock()
+	defer p.Unlock()
+	d, err := p.descriptor(c, u)
+	if err != nil {
+		return err
+	}
+	return p.ac.Write(d.Handle, v)
+}
+
 // ReadRSSI retrieves the current RSSI value of remote peripheral. [Vol 2, Part E, 7.5.4]
 func (p *Client) ReadRSSI() (int8, error) {
 	p.Lock()
@@ -342,61 +710,173 @@ func (p *Client) ExchangeMTU(mtu int) (int, error) {
 
 // Subscribe subscribes to indication (if ind is set true), or notification of a
 // characteristic value. [Vol 3, Part G, 4.10 & 4.11]
+// If the descriptors of c weren't discovered, its CCCD is found first.
+// Each call adds h to the handlers of the subscription, which are all passed
+// the values received, in the order they were added. A nil h unsubscribes.
 func (p *Client) Subscribe(c *ble.Characteristic, ind bool, h ble.NotificationHandler) error {
+	if h == nil {
+		return p.Unsubscribe(c, ind)
+	}
+	_, err := p.AddNotificationHandler(c, ind, h)
+	return err
+}
+
+// AddNotificationHandler is like Subscribe, and returns a token which removes
+// h alone with RemoveNotificationHandler, so that modules sharing a
+// subscription come and go independently.
+func (p *Client) AddNotificationHandler(c *ble.Characteristic, ind bool, h ble.NotificationHandler) (NotificationToken, error) {
+	o := SubscribeOptions{Notify: h}
+	if ind {
+		o = SubscribeOptions{Indicate: h}
+	}
+	return p.SubscribeWith(c, o)
+}
+
+// SubscribeOptions are the handlers of a subscription made with
+// SubscribeWith. The notifications and the indications are enabled if their
+// handler is set.
+type SubscribeOptions struct {
+	Notify   ble.NotificationHandler
+	Indicate ble.NotificationHandler
+}
+
+// SubscribeWith subscribes to the notifications, the indications, or both of
+// c, in a single CCCD write, and adds the handlers of o to those of the
+// subscriptions. The token returned removes them with
+// RemoveNotificationHandler.
+func (p *Client) SubscribeWith(c *ble.Characteristic, o SubscribeOptions) (NotificationToken, error) {
+	return p.subscribe(c, subHandler{h: o.Notify}, subHandler{h: o.Indicate})
+}
+
+// SubscribeTimestamped is like AddNotificationHandler, with h also passed the
+// time each value was received at, see ble.OptNotificationTimestamps.
+func (p *Client) SubscribeTimestamped(c *ble.Characteristic, ind bool, h ble.TimestampedNotificationHandler) (NotificationToken, error) {
+	if ind {
+		return p.subscribe(c, subHandler{}, subHandler{th: h})
+	}
+	return p.subscribe(c, subHandler{th: h}, subHandler{})
+}
+
+// subscribe subscribes to the notifications, the indications, or both of c,
+// with the handlers n and i set.
+func (p *Client) subscribe(c *ble.Characteristic, n, i subHandler) (NotificationToken, error) {
 	p.Lock()
 	defer p.Unlock()
+	if !n.set() && !i.set() {
+		return 0, fmt.Errorf("nil notification handler")
+	}
 	if c.CCCD == nil {
-		return fmt.Errorf("CCCD not found")
+		d, err := p.findDescriptor(c, ble.ClientCharacteristicConfigUUID)
+		if err != nil {
+			return 0, err
+		}
+		if d == nil {
+			return 0, fmt.Errorf("CCCD not found")
+		}
+		c.CCCD = d
 	}
-	flag := cccNotify
-	if ind {
-		flag = cccIndicate
+
+	s, ok := p.subs[c.ValueHandle]
+	if !ok {
+		s = &sub{cccdh: c.CCCD.Handle}
+		p.subs[c.ValueHandle] = s
+	}
+	ccc := s.ccc
+	if n.set() {
+		ccc |= cccNotify
+	}
+	if i.set() {
+		ccc |= cccIndicate
 	}
+	if ccc != s.ccc {
+		if err := p.writeCCC(c.ValueHandle, s, ccc); err != nil {
+			return 0, err
+		}
+	}
+	p.lastToken++
+	if n.set() {
+		n.token = p.lastToken
+		s.nHandlers = append(s.nHandlers, n)
+	}
+	if i.set() {
+		i.token = p.lastToken
+		s.iHandlers = append(s.iHandlers, i)
+	}
+	return p.lastToken, nil
+}
 
-	return p.setHandlers(c.CCCD.Handle, c.ValueHandle, flag, h)
+// RemoveNotificationHandler removes the handlers added with the token t from
+// the subscriptions of c. A subscription is disabled on the server once its
+// last handler is removed. Removing handlers twice does nothing.
+func (p *Client) RemoveNotificationHandler(c *ble.Characteristic, t NotificationToken) error {
+	p.Lock()
+	defer p.Unlock()
+	s, ok := p.subs[c.ValueHandle]
+	if !ok {
+		return nil
+	}
+	ccc := s.ccc
+	for _, flag := range []uint16{cccNotify, cccIndicate} {
+		hs := s.handlers(flag)
+		for i, sh := range *hs {
+			if sh.token != t {
+				continue
+			}
+			*hs = append((*hs)[:i:i], (*hs)[i+1:]...)
+			if len(*hs) == 0 {
+				ccc &^= flag
+			}
+			break
+		}
+	}
+	if ccc == s.ccc {
+		return nil
+	}
+	return p.writeCCC(c.ValueHandle, s, ccc)
+}
+
+// CCC returns the value of the CCCD of c written by the client, a
+// combination of 0x0001 for notifications and 0x0002 for indications.
+func (p *Client) CCC(c *ble.Characteristic) uint16 {
+	p.Lock()
+	defer p.Unlock()
+	if s, ok := p.subs[c.ValueHandle]; ok {
+		return s.ccc
+	}
+	return 0
 }
 
 // Unsubscribe unsubscribes to indication (if ind is set true), or notification
 // of a specified characteristic value. [Vol 3, Part G, 4.10 & 4.11]
+// It removes all the handlers of the subscription.
 func (p *Client) Unsubscribe(c *ble.Characteristic, ind bool) error {
 	p.Lock()
 	defer p.Unlock()
-	if c.CCCD == nil {
-		return fmt.Errorf("CCCD not found")
-	}
+	flag := cccNotify
 	if ind {
-		return p.setHandlers(c.CCCD.Handle, c.ValueHandle, cccIndicate, nil)
+		flag = cccIndicate
 	}
-	return p.setHandlers(c.CCCD.Handle, c.ValueHandle, cccNotify, nil)
-}
-
-func (p *Client) setHandlers(cccdh, vh, flag uint16, h ble.NotificationHandler) error {
-	s, ok := p.subs[vh]
+	s, ok := p.subs[c.ValueHandle]
 	if !ok {
-		s = &sub{cccdh: cccdh}
-		p.subs[vh] = s
-	}
-	switch {
-	case h == nil && (s.ccc&flag) == 0:
 		return nil
-	case h != nil && (s.ccc&flag) != 0:
+	}
+	*s.handlers(flag) = nil
+	if s.ccc&flag == 0 {
 		return nil
-	case h == nil && (s.ccc&flag) != 0:
-		s.ccc &= ^uint16(flag)
-	case h != nil && (s.ccc&flag) == 0:
-		s.ccc |= flag
 	}
+	return p.writeCCC(c.ValueHandle, s, s.ccc&^flag)
+}
 
+// writeCCC writes ccc to the CCCD of the subscription s of the value handle
+// vh, and forgets s once it's disabled, or if it failed to be enabled.
+func (p *Client) writeCCC(vh uint16, s *sub, ccc uint16) error {
 	v := make([]byte, 2)
-	binary.LittleEndian.PutUint16(v, s.ccc)
-	if flag == cccNotify {
-		s.nHandler = h
-	} else {
-		s.iHandler = h
-	}
-
+	binary.LittleEndian.PutUint16(v, ccc)
 	err := p.ac.Write(s.cccdh, v)
-	if err != nil {
+	if err == nil {
+		s.ccc = ccc
+	}
+	if s.ccc == 0 {
 		delete(p.subs, vh)
 	}
 	return err
@@ -435,35 +915,60 @@ func (p *Client) Conn() ble.Conn {
 	return p.conn
 }
 
+// OpenL2CAPChannel opens an LE credit based channel to the PSM of the
+// remote device, over the connection of the client, if it supports them.
+func (p *Client) OpenL2CAPChannel(psm uint16) (ble.L2CAPChannel, error) {
+	p.RLock()
+	d, ok := p.conn.(ble.L2CAPDialer)
+	p.RUnlock()
+	if !ok {
+		return nil, ble.ErrNotImplemented
+	}
+	return d.OpenL2CAPChannel(psm)
+}
+
 // HandleNotification ...
+// The handler is called without holding the lock, so the handlers of
+// different characteristics may run in parallel, and may use the client.
 func (p *Client) HandleNotification(req []byte) {
+	p.HandleTimestampedNotification(req, time.Time{})
+}
+
+// HandleTimestampedNotification is like HandleNotification, for the
+// notification received at t.
+func (p *Client) HandleTimestampedNotification(req []byte, t time.Time) {
 	p.Lock()
-	defer p.Unlock()
 	vh := att.HandleValueIndication(req).AttributeHandle()
 	sub, ok := p.subs[vh]
 	if !ok {
-		// FIXME: disconnects and propagate an error to the user.
-		p.Warnf("got an unregistered notification")
+		p.handleUnregistered(vh, req)
 		return
 	}
 
 	indication := req[0] == att.HandleValueIndicationCode
 	nd := req[3:]
 
-	switch {
-	case indication && sub.iHandler != nil:
-		sub.iHandler(sub.id, nd)
-	case sub.nHandler != nil:
-		sub.nHandler(sub.id, nd)
-	default:
-		select {
-		case <-p.conn.Disconnected():
-			//ok
-		default:
-			p.Warnf("no handler, dropping data vh 0x%x, indication %v, id %v, %x", vh, indication, sub.id, nd)
-		}
+	hs := sub.nHandlers
+	if indication && len(sub.iHandlers) != 0 {
+		hs = sub.iHandlers
 	}
+	id := sub.id
 	sub.id++
+	p.Unlock()
+
+	// The slices are replaced, not modified, once handed out.
+	for _, sh := range hs {
+		sh.handle(id, nd, t)
+	}
+	if len(hs) != 0 {
+		return
+	}
+	select {
+	case <-p.conn.Disconnected():
+		//ok
+	default:
+		p.Warnf("no handler, dropping data vh 0x%x, indication %v, id %v, %x", vh, indication, id, nd)
+	}
 }
 
 func (p *Client) Pair(authData ble.AuthData, to time.Duration) error {
@@ -473,7 +978,3 @@ func (p *Client) Pair(authData ble.AuthData, to time.Duration) error {
 func (p *Client) StartEncryption(ch chan ble.EncryptionChangedInfo) error {
 	return p.conn.StartEncryption(ch)
 }
-
-func (p *Client) PrepareCustomPairing(ch chan bool) {
-	p.conn.PrepareCustomPairing(ch)
-}
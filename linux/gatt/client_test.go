@@ -0,0 +1,456 @@
+package gatt_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/gatt"
+	"github.com/leso-kn/ble/linux/hci/virtual"
+)
+
+func TestUUIDCompression(t *testing.T) {
+	air := virtual.NewAir()
+	dev := func(addr string, opts ...ble.Option) *linux.Device {
+		c, err := air.NewController(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := linux.NewDevice(append([]ble.Option{ble.OptTransportVirtual(c)}, opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	p := dev("11:11:11:11:11:11")
+	defer p.Stop()
+
+	// The peripheral encodes the UUIDs in 128 bits.
+	svc := ble.NewService(ble.MustParse("0000180D-0000-1000-8000-00805F9B34FB"))
+	svc.NewCharacteristic(ble.MustParse("00002A37-0000-1000-8000-00805F9B34FB")).SetValue([]byte{0x00})
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+
+	for _, tt := range []struct {
+		addr string
+		opts []ble.Option
+		want ble.UUID
+	}{
+		{"33:33:33:33:33:33", nil, ble.UUID16(0x2A37)},
+		{"44:44:44:44:44:44", []ble.Option{ble.OptUUIDCompression(false)}, ble.MustParse("00002A37-0000-1000-8000-00805F9B34FB")},
+	} {
+		c := dev(tt.addr, tt.opts...)
+		defer c.Stop()
+		cln, err := c.Dial(ctx, ble.NewAddr("11:11:11:11:11:11"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The filter matches either form.
+		ss, err := cln.DiscoverServices([]ble.UUID{ble.UUID16(0x180D)})
+		if err != nil || len(ss) != 1 {
+			t.Fatalf("services %v, %v", ss, err)
+		}
+		cs, err := cln.DiscoverCharacteristics(nil, ss[0])
+		if err != nil || len(cs) != 1 {
+			t.Fatalf("characteristics %v, %v", cs, err)
+		}
+		if !cs[0].UUID.Equal(tt.want) {
+			t.Errorf("discovered %s, want %s", cs[0].UUID, tt.want)
+		}
+		cln.CancelConnection()
+		<-cln.Disconnected()
+	}
+}
+
+func TestReadCoalescing(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, []ble.Option{ble.OptReadCoalescing(true)})
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	// Each read of the peripheral returns a new value, slowly.
+	var mu sync.Mutex
+	n := 0
+	chrUUID := ble.MustParse("00010000-0002-1000-8000-00805F9B34FB")
+	svc := ble.NewService(ble.MustParse("00010000-0001-1000-8000-00805F9B34FB"))
+	svc.NewCharacteristic(chrUUID).HandleRead(ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		mu.Lock()
+		n++
+		v := byte(n)
+		mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+		rsp.Write([]byte{v})
+	}))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+
+	cln, err := c.Dial(ctx, ble.NewAddr("11:22:33:44:55:66"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chr := prof.FindCharacteristic(ble.NewCharacteristic(chrUUID))
+	if chr == nil {
+		t.Fatal("characteristic not discovered")
+	}
+
+	// The concurrent reads share a single transaction.
+	const readers = 5
+	vals := make(chan []byte, readers)
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := cln.ReadCharacteristic(chr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			vals <- b
+		}()
+	}
+	wg.Wait()
+	close(vals)
+	for b := range vals {
+		if !bytes.Equal(b, []byte{0x01}) {
+			t.Errorf("read % X, want 01", b)
+		}
+	}
+
+	// A later read is a transaction of its own.
+	if b, err := cln.ReadCharacteristic(chr); err != nil || !bytes.Equal(b, []byte{0x02}) {
+		t.Fatalf("read % X, %v", b, err)
+	}
+}
+
+func TestNotificationHandlers(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	chrUUID := ble.MustParse("00010000-0002-1000-8000-00805F9B34FB")
+	svc := ble.NewService(ble.MustParse("00010000-0001-1000-8000-00805F9B34FB"))
+	chr := svc.NewCharacteristic(chrUUID)
+	chr.HandleNotify(ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {}))
+	chr.HandleIndicate(ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {}))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+
+	cln, err := c.Dial(ctx, ble.NewAddr("11:22:33:44:55:66"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cln.CancelConnection()
+	prof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := prof.FindCharacteristic(ble.NewCharacteristic(chrUUID))
+	if v == nil {
+		t.Fatal("characteristic not discovered")
+	}
+
+	// Two modules share the subscription.
+	gc := cln.(*gatt.Client)
+	got1, got2 := make(chan byte, 10), make(chan byte, 10)
+	t1, err := gc.AddNotificationHandler(v, false, func(id uint, b []byte) { got1 <- b[0] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t2, err := gc.AddNotificationHandler(v, false, func(id uint, b []byte) { got2 <- b[0] })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// notify notifies until the value is sent to n centrals.
+	ind := false
+	notify := func(b byte, n int) {
+		for {
+			if res := p.Notify(chr, ind, []byte{b}); len(res.Sent) == n {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatalf("not sent to %d centrals", n)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	recv := func(got chan byte, want byte) {
+		select {
+		case b := <-got:
+			if b != want {
+				t.Fatalf("notified %02X, want %02X", b, want)
+			}
+		case <-ctx.Done():
+			t.Fatal("no notification")
+		}
+	}
+	notify(0x01, 1)
+	recv(got1, 0x01)
+	recv(got2, 0x01)
+
+	// Removing a handler leaves the other one subscribed.
+	if err := gc.RemoveNotificationHandler(v, t1); err != nil {
+		t.Fatal(err)
+	}
+	notify(0x02, 1)
+	recv(got2, 0x02)
+	select {
+	case b := <-got1:
+		t.Fatalf("removed handler notified %02X", b)
+	default:
+	}
+
+	// The last one unsubscribes.
+	if err := gc.RemoveNotificationHandler(v, t2); err != nil {
+		t.Fatal(err)
+	}
+	notify(0x03, 0)
+	if ccc := gc.CCC(v); ccc != 0 {
+		t.Fatalf("CCC %04X once unsubscribed", ccc)
+	}
+
+	// Notifications and indications, to handlers of their own.
+	tok, err := gc.SubscribeWith(v, gatt.SubscribeOptions{
+		Notify:   func(id uint, b []byte) { got1 <- b[0] },
+		Indicate: func(id uint, b []byte) { got2 <- b[0] },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ccc := gc.CCC(v); ccc != 0x0003 {
+		t.Fatalf("CCC %04X, want 0003", ccc)
+	}
+	notify(0x04, 1)
+	recv(got1, 0x04)
+	ind = true
+	notify(0x05, 1)
+	recv(got2, 0x05)
+	if err := gc.RemoveNotificationHandler(v, tok); err != nil {
+		t.Fatal(err)
+	}
+	if ccc := gc.CCC(v); ccc != 0 {
+		t.Fatalf("CCC %04X once removed", ccc)
+	}
+}
+
+func TestFindDescriptors(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	// The CCCD follows another descriptor, and precedes another
+	// characteristic.
+	chrUUID := ble.MustParse("00010000-0002-1000-8000-00805F9B34FB")
+	svc := ble.NewService(ble.MustParse("00010000-0001-1000-8000-00805F9B34FB"))
+	chr := svc.NewCharacteristic(chrUUID)
+	chr.NewDescriptor(ble.UUID16(0x2901)).SetValue([]byte("Level"))
+	chr.HandleNotify(ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {}))
+	svc.NewCharacteristic(ble.MustParse("00010000-0003-1000-8000-00805F9B34FB")).SetValue([]byte{0x00})
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+
+	cln, err := c.Dial(ctx, ble.NewAddr("11:22:33:44:55:66"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cln.CancelConnection()
+	ss, err := cln.DiscoverServices([]ble.UUID{svc.UUID})
+	if err != nil || len(ss) != 1 {
+		t.Fatalf("services %v, %v", ss, err)
+	}
+	cs, err := cln.DiscoverCharacteristics([]ble.UUID{chrUUID}, ss[0])
+	if err != nil || len(cs) != 1 {
+		t.Fatalf("characteristics %v, %v", cs, err)
+	}
+
+	// The descriptors weren't discovered.
+	gc := cln.(*gatt.Client)
+	if b, err := gc.ReadDescriptorByUUID(cs[0], ble.UUID16(0x2901)); err != nil || string(b) != "Level" {
+		t.Fatalf("user description %q, %v", b, err)
+	}
+	if _, err := gc.ReadDescriptorByUUID(cs[0], ble.UUID16(0x2904)); err == nil {
+		t.Fatal("read a missing descriptor")
+	}
+	got := make(chan []byte, 1)
+	if err := cln.Subscribe(cs[0], false, ble.RetainNotifications(func(id uint, b []byte) { got <- b })); err != nil {
+		t.Fatal(err)
+	}
+	if cs[0].CCCD == nil || len(cs[0].Descriptors) != 0 {
+		t.Fatalf("CCCD %v, descriptors %v", cs[0].CCCD, cs[0].Descriptors)
+	}
+	for {
+		if res := p.Notify(chr, false, []byte{0x01}); len(res.Sent) == 1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("not subscribed")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	select {
+	case b := <-got:
+		if !bytes.Equal(b, []byte{0x01}) {
+			t.Fatalf("notified % X", b)
+		}
+	case <-ctx.Done():
+		t.Fatal("no notification")
+	}
+}
+
+func TestRefreshService(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	svcUUID := ble.MustParse("00010000-0001-1000-8000-00805F9B34FB")
+	otherUUID := ble.MustParse("00020000-0001-1000-8000-00805F9B34FB")
+	chr1 := ble.MustParse("00010000-0002-1000-8000-00805F9B34FB")
+	chr2 := ble.MustParse("00010000-0003-1000-8000-00805F9B34FB")
+	svc := ble.NewService(svcUUID)
+	svc.NewCharacteristic(chr1).SetValue([]byte{0x01})
+	svc.NewCharacteristic(chr2).SetValue([]byte{0x02})
+	other := ble.NewService(otherUUID)
+	other.NewCharacteristic(chr1).SetValue([]byte{0x03})
+	if err := p.SetServices([]*ble.Service{svc, other}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+
+	cln, err := c.Dial(ctx, ble.NewAddr("11:22:33:44:55:66"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cln.CancelConnection()
+
+	// A stale profile, which misses a characteristic of the service.
+	ss, err := cln.DiscoverServices(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range ss {
+		if _, err := cln.DiscoverCharacteristics([]ble.UUID{chr1}, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prof := cln.Profile()
+	n := len(prof.Services)
+	stale := prof.FindService(ble.NewService(svcUUID))
+	unchanged := prof.FindService(ble.NewService(otherUUID))
+	if stale == nil || unchanged == nil || len(stale.Characteristics) != 1 {
+		t.Fatal("services not discovered")
+	}
+
+	s, err := cln.RefreshService(svcUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s == stale || len(s.Characteristics) != 2 || !s.Characteristics[1].UUID.Equal(chr2) {
+		t.Fatalf("refreshed service has %d characteristics", len(s.Characteristics))
+	}
+	prof = cln.Profile()
+	if len(prof.Services) != n || prof.FindService(ble.NewService(svcUUID)) != s {
+		t.Fatalf("profile has %d services, want %d, with the refreshed one", len(prof.Services), n)
+	}
+	if prof.FindService(ble.NewService(otherUUID)) != unchanged {
+		t.Fatal("unchanged service rediscovered")
+	}
+	if b, err := cln.ReadCharacteristic(s.Characteristics[1]); err != nil || !bytes.Equal(b, []byte{0x02}) {
+		t.Fatalf("read % X, %v", b, err)
+	}
+
+	// A service the server doesn't have is left out of the profile.
+	missing := ble.MustParse("00030000-0001-1000-8000-00805F9B34FB")
+	if _, err := cln.RefreshService(missing); err == nil {
+		t.Fatal("missing service refreshed")
+	}
+	if len(cln.Profile().Services) != n {
+		t.Fatal("missing service changed the profile")
+	}
+
+	if err := cln.InvalidateProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if cln.Profile() != nil {
+		t.Fatal("profile not invalidated")
+	}
+	if prof, err = cln.DiscoverProfile(false); err != nil || len(prof.Services) != n {
+		t.Fatalf("rediscovery: %v", err)
+	}
+}
+
+func TestWriteLong(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p := pair.Peripheral
+
+	written := make(chan []byte, 4)
+	svc := ble.NewService(ble.UUID16(0xFF00))
+	svc.NewCharacteristic(ble.UUID16(0xFF01)).HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		written <- append([]byte(nil), req.Data()...)
+	}))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	defer cln.CancelConnection()
+	gc := cln.(*gatt.Client)
+	prof, err := gc.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := prof.FindCharacteristic(ble.NewCharacteristic(ble.UUID16(0xFF01)))
+	if ch == nil {
+		t.Fatal("characteristic not discovered")
+	}
+
+	v := make([]byte, 500)
+	for i := range v {
+		v[i] = byte(i)
+	}
+	if n := len(ble.Chunks(v, cln.Conn().TxMTU(), ble.PrepareWriteOverhead)); n < 2 {
+		t.Fatalf("value written in %d chunks", n)
+	}
+	if err := gc.WriteLongCharacteristic(ch, v); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-written:
+		if !bytes.Equal(got, v) {
+			t.Fatalf("wrote % X, want % X", got, v)
+		}
+	case <-ctx.Done():
+		t.Fatal("value not written")
+	}
+}
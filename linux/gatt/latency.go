@@ -0,0 +1,64 @@
+package gatt
+
+import (
+	"sort"
+	"time"
+)
+
+// Latency is an estimate of the delay between the queuing of a notification
+// by the server, e.g. as it samples a sensor, and its receipt, for the
+// samples of several connections to be aligned.
+//
+// A notification waits for the next connection event, up to a connection
+// interval, then takes about as long as a request or its response to be
+// received. Half the round trip of a request estimates the mean delay.
+type Latency struct {
+	// RTT is the median of the round trips measured.
+	RTT time.Duration
+
+	// Interval is the connection interval the connection was established
+	// with.
+	Interval time.Duration
+
+	// Delay is the estimated mean delay, half RTT.
+	Delay time.Duration
+
+	// Jitter is the variation of the delay around Delay, half the
+	// connection interval, which a notification waits for its event.
+	Jitter time.Duration
+}
+
+// SampleTime returns the estimated time a notification received at t was
+// queued at by the server.
+func (l Latency) SampleTime(t time.Time) time.Time {
+	return t.Add(-l.Delay)
+}
+
+// EstimateLatency estimates the latency of the notifications of the
+// connection from n round trips of requests, at least one. The round trips
+// end at the receipt of the responses stamped by the HCI if the device was
+// set up with ble.OptNotificationTimestamps, as the notifications are, so
+// it's more accurate then.
+func (p *Client) EstimateLatency(n int) (Latency, error) {
+	if n < 1 {
+		n = 1
+	}
+	rtts := make([]time.Duration, 0, n)
+	for i := 0; i < n; i++ {
+		p.Lock()
+		rtt, err := p.ac.RoundTrip()
+		p.Unlock()
+		if err != nil {
+			return Latency{}, err
+		}
+		rtts = append(rtts, rtt)
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	l := Latency{
+		RTT:      rtts[len(rtts)/2],
+		Interval: p.conn.ConnInfo().Interval,
+	}
+	l.Delay = l.RTT / 2
+	l.Jitter = l.Interval / 2
+	return l, nil
+}
@@ -0,0 +1,93 @@
+package gatt_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux/gatt"
+)
+
+func TestNotificationTimestamps(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, []ble.Option{ble.OptNotificationTimestamps(true)})
+	defer pair.Stop()
+	p := pair.Peripheral
+
+	chrUUID := ble.UUID16(0xFF01)
+	svc := ble.NewService(ble.UUID16(0xFF00))
+	chr := svc.NewCharacteristic(chrUUID)
+	chr.HandleNotify(ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {}))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	defer cln.CancelConnection()
+	prof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := prof.FindCharacteristic(ble.NewCharacteristic(chrUUID))
+	if v == nil {
+		t.Fatal("characteristic not discovered")
+	}
+	gc := cln.(*gatt.Client)
+
+	type stamped struct {
+		v  []byte
+		at time.Time
+		rx time.Time
+	}
+	got := make(chan stamped, 1)
+	if _, err := gc.SubscribeTimestamped(v, false, func(id uint, b []byte, at time.Time) {
+		got <- stamped{append([]byte(nil), b...), at, time.Now()}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Plain handlers of the subscription still get the values.
+	plain := make(chan []byte, 1)
+	if err := cln.Subscribe(v, false, func(id uint, b []byte) {
+		plain <- append([]byte(nil), b...)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	if res := p.Notify(chr, false, []byte{0x2A}); len(res.Sent) != 1 {
+		t.Fatalf("notification sent to %d centrals", len(res.Sent))
+	}
+	select {
+	case s := <-got:
+		if !bytes.Equal(s.v, []byte{0x2A}) {
+			t.Fatalf("value % X", s.v)
+		}
+		if s.at.Before(before) || s.at.After(s.rx) {
+			t.Fatalf("stamped at %v, notified at %v and handled at %v", s.at, before, s.rx)
+		}
+	case <-ctx.Done():
+		t.Fatal("timestamped handler not called")
+	}
+	select {
+	case b := <-plain:
+		if !bytes.Equal(b, []byte{0x2A}) {
+			t.Fatalf("value % X", b)
+		}
+	case <-ctx.Done():
+		t.Fatal("handler not called")
+	}
+
+	l, err := gc.EstimateLatency(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.RTT <= 0 || l.Delay != l.RTT/2 || l.Interval != gc.Conn().ConnInfo().Interval || l.Jitter != l.Interval/2 {
+		t.Fatalf("latency %+v", l)
+	}
+	if at := time.Now(); !l.SampleTime(at).Equal(at.Add(-l.Delay)) {
+		t.Fatal("sample time not shifted by the delay")
+	}
+}
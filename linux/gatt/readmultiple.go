@@ -0,0 +1,113 @@
+package gatt
+
+import (
+	"fmt"
+
+	"github.com/leso-kn/ble"
+)
+
+// formatLen are the lengths of the values of the fixed length formats of
+// the Characteristic Presentation Format descriptor. [Assigned Numbers, 2.4.1]
+var formatLen = map[byte]int{
+	0x01: 1,  // boolean
+	0x02: 1,  // 2bit
+	0x03: 1,  // nibble
+	0x04: 1,  // uint8
+	0x05: 2,  // uint12
+	0x06: 2,  // uint16
+	0x07: 3,  // uint24
+	0x08: 4,  // uint32
+	0x09: 6,  // uint48
+	0x0A: 8,  // uint64
+	0x0B: 16, // uint128
+	0x0C: 1,  // sint8
+	0x0D: 2,  // sint12
+	0x0E: 2,  // sint16
+	0x0F: 3,  // sint24
+	0x10: 4,  // sint32
+	0x11: 6,  // sint48
+	0x12: 8,  // sint64
+	0x13: 16, // sint128
+	0x14: 4,  // float32
+	0x15: 8,  // float64
+	0x16: 2,  // SFLOAT
+	0x17: 4,  // FLOAT
+	0x18: 4,  // duint16
+}
+
+// ReadMultipleCharacteristics reads the values of the characteristics cs
+// with a single Read Multiple Request, sets them, and returns them in
+// order. [Vol 3, Part G, 4.8.4]
+//
+// The server concatenates the values, so the length of all but the last
+// must be known: lens[i] is the length of the value of cs[i], or 0 to take
+// it from the format of its Characteristic Presentation Format descriptor.
+// The descriptor must have been discovered; it's read unless its value is
+// known. lens may be nil. The response is truncated to ATT_MTU-1 bytes,
+// which cuts the last value short, as a plain read would.
+func (p *Client) ReadMultipleCharacteristics(cs []*ble.Characteristic, lens []int) ([][]byte, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	hs := make([]uint16, len(cs))
+	ls := make([]int, len(cs))
+	for i, c := range cs {
+		hs[i] = c.ValueHandle
+		if i == len(cs)-1 {
+			break
+		}
+		if i < len(lens) && lens[i] > 0 {
+			ls[i] = lens[i]
+			continue
+		}
+		n, err := p.formatLen(c)
+		if err != nil {
+			return nil, err
+		}
+		ls[i] = n
+	}
+
+	b, err := p.ac.ReadMultiple(hs)
+	if err != nil {
+		return nil, err
+	}
+	vs := make([][]byte, len(cs))
+	for i, c := range cs {
+		n := len(b)
+		if i < len(cs)-1 {
+			if n = ls[i]; n > len(b) {
+				return nil, fmt.Errorf("read multiple response truncated at characteristic %s", c.UUID)
+			}
+		}
+		vs[i] = append([]byte(nil), b[:n]...)
+		b = b[n:]
+		c.Value = vs[i]
+	}
+	return vs, nil
+}
+
+// formatLen returns the length of the value of c given by its
+// Characteristic Presentation Format descriptor. Must be called with p
+// locked.
+func (p *Client) formatLen(c *ble.Characteristic) (int, error) {
+	for _, d := range c.Descriptors {
+		if !d.UUID.Equal(ble.UUID16(0x2904)) {
+			continue
+		}
+		if len(d.Value) == 0 {
+			v, err := p.ac.Read(d.Handle)
+			if err != nil {
+				return 0, err
+			}
+			d.Value = v
+		}
+		if len(d.Value) == 0 {
+			break
+		}
+		if n, ok := formatLen[d.Value[0]]; ok {
+			return n, nil
+		}
+		return 0, fmt.Errorf("format 0x%02X of characteristic %s has no fixed length", d.Value[0], c.UUID)
+	}
+	return 0, fmt.Errorf("length of characteristic %s isn't known", c.UUID)
+}
@@ -0,0 +1,62 @@
+package gatt_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux/gatt"
+)
+
+func TestReadMultipleCharacteristics(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p := pair.Peripheral
+
+	u := func(i uint16) ble.UUID { return ble.UUID16(0xFF00 + i) }
+	svc := ble.NewService(u(0))
+	// A uint16, a value of a length known to the client, and a string.
+	svc.NewCharacteristic(u(1)).SetValue([]byte{0x34, 0x12})
+	svc.Characteristics[0].NewDescriptor(ble.UUID16(0x2904)).SetValue([]byte{0x06, 0, 0x27, 0x00, 0x01, 0, 0})
+	svc.NewCharacteristic(u(2)).HandleRead(ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		rsp.Write([]byte{1, 2, 3, 4})
+	}))
+	svc.NewCharacteristic(u(3)).SetValue([]byte("hello"))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	prof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cs []*ble.Characteristic
+	for i := uint16(1); i <= 3; i++ {
+		ch := prof.FindCharacteristic(ble.NewCharacteristic(u(i)))
+		if ch == nil {
+			t.Fatal("characteristic not discovered")
+		}
+		cs = append(cs, ch)
+	}
+
+	gc := cln.(*gatt.Client)
+	if _, err := gc.ReadMultipleCharacteristics(cs, nil); err == nil {
+		t.Fatal("read a value of unknown length")
+	}
+	vs, err := gc.ReadMultipleCharacteristics(cs, []int{0, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]byte{{0x34, 0x12}, {1, 2, 3, 4}, []byte("hello")}
+	for i, v := range vs {
+		if !bytes.Equal(v, want[i]) {
+			t.Errorf("value %d: % X, want % X", i, v, want[i])
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package gatt
+
+import (
+	"errors"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/att"
+)
+
+// ErrNotServed means no database is served to the peer of a client.
+var ErrNotServed = errors.New("no database served to the peer")
+
+// Serve serves the services svcs to the peer, which may access them as a
+// GATT client, in place of the database of the device, if any. Their
+// handles are assigned from 1, so they mustn't be served by another
+// database meanwhile.
+func (p *Client) Serve(svcs []*ble.Service) {
+	p.ac.WithServer(att.NewDB(svcs, 1, p.Logger))
+}
+
+// SetServerAccessHandler sets f to be called with the reads and writes of
+// the peer to the attributes served to it. See att.Server.SetAccessHandler.
+func (p *Client) SetServerAccessHandler(f func(att.Access)) {
+	p.ac.SetServerAccessHandler(f)
+}
+
+// NotifyPeer sends the value v of the characteristic c, served to the peer,
+// to it, as an indication if ind is set, or else as a notification. It
+// fails with att.ErrNotSubscribed unless the peer subscribed to c.
+func (p *Client) NotifyPeer(c *ble.Characteristic, ind bool, v []byte) error {
+	s := p.ac.Server()
+	if s == nil {
+		return ErrNotServed
+	}
+	return s.Notify(c, ind, v)
+}
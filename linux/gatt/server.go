@@ -1,10 +1,12 @@
 package gatt
 
 import (
+	"encoding/binary"
 	"log"
 	"sync"
 
 	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/lifecycle"
 	"github.com/leso-kn/ble/linux/att"
 )
 
@@ -33,8 +35,15 @@ type Server struct {
 	sync.Mutex
 	name string
 
+	// ppcp is the value of the Peripheral Preferred Connection Parameters
+	// characteristic, if set.
+	ppcp []byte
+
 	svcs []*ble.Service
 	db   *att.DB
+
+	// g runs the bindings of the characteristics.
+	g lifecycle.Group
 	ble.Logger
 }
 
@@ -43,7 +52,7 @@ func (s *Server) AddService(svc *ble.Service) error {
 	s.Lock()
 	defer s.Unlock()
 	s.svcs = append(s.svcs, svc)
-	s.db = att.NewDB(s.svcs, uint16(1), s.Logger) // ble attrs start at 1
+	s.db = s.db.WithServices(s.svcs)
 	return nil
 }
 
@@ -51,8 +60,8 @@ func (s *Server) AddService(svc *ble.Service) error {
 func (s *Server) RemoveAllServices() error {
 	s.Lock()
 	defer s.Unlock()
-	s.svcs = defaultServices(s.name)
-	s.db = att.NewDB(s.svcs, uint16(1), s.Logger) // ble attrs start at 1
+	s.svcs = s.defaultServices()
+	s.db = s.db.WithServices(s.svcs)
 	return nil
 }
 
@@ -60,16 +69,109 @@ func (s *Server) RemoveAllServices() error {
 func (s *Server) SetServices(svcs []*ble.Service) error {
 	s.Lock()
 	defer s.Unlock()
-	s.svcs = append(defaultServices(s.name), svcs...)
-	s.db = att.NewDB(s.svcs, uint16(1), s.Logger) // ble attrs start at 1
+	s.svcs = append(s.defaultServices(), svcs...)
+	s.db = s.db.WithServices(s.svcs)
 	return nil
 }
 
+// SetPreferredConnParams sets the value of the Peripheral Preferred
+// Connection Parameters characteristic of the GAP service [Vol 3, Part C,
+// 12.3].
+func (s *Server) SetPreferredConnParams(p ble.ConnParams) {
+	s.Lock()
+	defer s.Unlock()
+	s.ppcp = make([]byte, 8)
+	binary.LittleEndian.PutUint16(s.ppcp[0:], p.IntervalMin)
+	binary.LittleEndian.PutUint16(s.ppcp[2:], p.IntervalMax)
+	binary.LittleEndian.PutUint16(s.ppcp[4:], p.Latency)
+	binary.LittleEndian.PutUint16(s.ppcp[6:], p.Timeout)
+	setPreferredConnParams(s.svcs, s.ppcp)
+	s.db = s.db.WithServices(s.svcs)
+}
+
+// defaultServices returns the GAP and GATT services of the server.
+func (s *Server) defaultServices() []*ble.Service {
+	svcs := defaultServices(s.name)
+	if s.ppcp != nil {
+		setPreferredConnParams(svcs, s.ppcp)
+	}
+	return svcs
+}
+
+// setPreferredConnParams sets the value of the Peripheral Preferred
+// Connection Parameters characteristic of the GAP service in svcs.
+func setPreferredConnParams(svcs []*ble.Service, v []byte) {
+	for _, svc := range svcs {
+		if !svc.UUID.Equal(ble.GAPUUID) {
+			continue
+		}
+		for _, c := range svc.Characteristics {
+			if c.UUID.Equal(ble.PeferredParamsUUID) {
+				c.SetValue(v)
+			}
+		}
+	}
+}
+
 // DB ...
 func (s *Server) DB() *att.DB {
 	return s.db
 }
 
+// DBWithServices returns a database of the GAP and GATT services of the
+// server, followed by svcs, e.g. to serve other services to some of the
+// centrals, with linux.Incoming.AcceptDB. It shares the subscriptions and
+// the configuration of the database of the server. The handles of svcs are
+// assigned by the database, so they mustn't be part of another one.
+func (s *Server) DBWithServices(svcs []*ble.Service) *att.DB {
+	s.Lock()
+	defer s.Unlock()
+	return s.db.WithServices(append(s.defaultServices(), svcs...))
+}
+
+// Profile returns the services of the server, including the GAP and GATT
+// services, with their handles.
+func (s *Server) Profile() *ble.Profile {
+	s.Lock()
+	defer s.Unlock()
+	return &ble.Profile{Services: append([]*ble.Service(nil), s.svcs...)}
+}
+
+// SetPrepareQueue configures the queue of prepared writes kept for each
+// client, from its next prepare write on.
+func (s *Server) SetPrepareQueue(q att.PrepareQueue) {
+	s.Lock()
+	defer s.Unlock()
+	s.db.SetPrepareQueue(q)
+}
+
+// SetStrict sets whether the ATT servers of the clients follow the
+// Attribute Protocol strictly, as att.DB.SetStrict describes.
+func (s *Server) SetStrict(on bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.db.SetStrict(on)
+}
+
+// Violations returns the violations of the Attribute Protocol by the
+// clients.
+func (s *Server) Violations() att.Violations {
+	s.Lock()
+	defer s.Unlock()
+	return s.db.Violations()
+}
+
+// Notify sends the value v of the characteristic c to the centrals
+// subscribed to it, as an indication if ind is set, or else as a
+// notification. It reports the centrals the value was sent to, and the ones
+// it failed to reach.
+func (s *Server) Notify(c *ble.Characteristic, ind bool, v []byte) ble.NotifyResult {
+	s.Lock()
+	db := s.db
+	s.Unlock()
+	return db.Notify(c, ind, v)
+}
+
 func defaultServices(name string) []*ble.Service {
 	return defaultServicesWithHandler(name, nil)
 }
@@ -0,0 +1,105 @@
+package gatt_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/hci/virtual"
+)
+
+func TestNotify(t *testing.T) {
+	air := virtual.NewAir()
+	dev := func(addr string) *linux.Device {
+		c, err := air.NewController(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := linux.NewDevice(ble.OptTransportVirtual(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	p := dev("11:11:11:11:11:11")
+	defer p.Stop()
+	c1 := dev("33:33:33:33:33:33")
+	defer c1.Stop()
+	c2 := dev("44:44:44:44:44:44")
+	defer c2.Stop()
+
+	chrUUID := ble.MustParse("00010000-0002-1000-8000-00805F9B34FB")
+	svc := ble.NewService(ble.MustParse("00010000-0001-1000-8000-00805F9B34FB"))
+	chr := svc.NewCharacteristic(chrUUID)
+	chr.HandleNotify(ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {}))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+	pa := ble.NewAddr("11:11:11:11:11:11")
+
+	got := make(chan []byte, 2)
+	var clns []ble.Client
+	for _, c := range []*linux.Device{c1, c2} {
+		cln, err := c.Dial(ctx, pa)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clns = append(clns, cln)
+		prof, err := cln.DiscoverProfile(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := prof.FindCharacteristic(ble.NewCharacteristic(chrUUID))
+		if v == nil {
+			t.Fatal("characteristic not discovered")
+		}
+		if err := cln.Subscribe(v, false, func(id uint, b []byte) { got <- b }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// notify notifies until the value is sent to n centrals.
+	notify := func(n int) ble.NotifyResult {
+		for {
+			res := p.Notify(chr, false, []byte{0x01})
+			if len(res.Sent) == n {
+				return res
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatalf("sent to %d centrals, failed %v", len(res.Sent), res.Failed)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	res := notify(2)
+	if len(res.Failed) != 0 {
+		t.Fatalf("failed %v", res.Failed)
+	}
+	for _, c := range res.Sent {
+		if ccc := ble.ConnCCC(c, chr.Handle); ccc != 0x0001 {
+			t.Errorf("CCCD of %s %04X, want 0001", c.RemoteAddr(), ccc)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-got:
+		case <-ctx.Done():
+			t.Fatal("no notification")
+		}
+	}
+
+	// A disconnected central isn't subscribed anymore.
+	clns[1].CancelConnection()
+	<-clns[1].Disconnected()
+	res = notify(1)
+	if a := res.Sent[0].RemoteAddr().String(); a != "33:33:33:33:33:33" {
+		t.Errorf("sent to %s", a)
+	}
+}
@@ -0,0 +1,105 @@
+package gatt
+
+import (
+	"fmt"
+
+	"github.com/leso-kn/ble"
+)
+
+// UnregisteredAction is what a client does with a notification or an
+// indication of a characteristic it isn't subscribed to.
+type UnregisteredAction int
+
+// The actions on unregistered notifications.
+const (
+	// DropUnregistered logs and drops the value, as by default.
+	DropUnregistered UnregisteredAction = iota
+
+	// UnsubscribeUnregistered drops the value, and writes 0 to the CCCD
+	// of the characteristic, so the server stops sending it.
+	UnsubscribeUnregistered
+
+	// DisconnectUnregistered drops the value, and disconnects.
+	DisconnectUnregistered
+)
+
+// SetUnregisteredNotificationHandler sets f to be called with the PDU of each
+// notification or indication of a characteristic the client isn't
+// subscribed to, e.g. one subscribed to by an earlier connection to a bonded
+// server. The PDU is only valid during the call. f returns the action taken.
+// A nil f drops them.
+func (p *Client) SetUnregisteredNotificationHandler(f func(pdu []byte) UnregisteredAction) {
+	p.Lock()
+	defer p.Unlock()
+	p.unregistered = f
+}
+
+// handleUnregistered applies the policy on unregistered notifications to the
+// PDU req of the value handle vh. Must be called with p locked, which it
+// unlocks.
+func (p *Client) handleUnregistered(vh uint16, req []byte) {
+	f := p.unregistered
+	p.Unlock()
+	act := DropUnregistered
+	if f != nil {
+		act = f(req)
+	}
+
+	switch act {
+	case UnsubscribeUnregistered:
+		// The request waits for the notifications handled before.
+		p.g.Go(func() {
+			if err := p.clearCCCD(vh); err != nil {
+				p.Warnf("unsubscribe unregistered notification vh 0x%x: %v", vh, err)
+			}
+		})
+	case DisconnectUnregistered:
+		p.Warnf("disconnecting on unregistered notification vh 0x%x", vh)
+		p.conn.Close()
+	default:
+		p.Warnf("got an unregistered notification vh 0x%x", vh)
+	}
+}
+
+// clearCCCD writes 0 to the CCCD of the characteristic with the value handle
+// vh, which is found in the discovered profile, or else on the server.
+func (p *Client) clearCCCD(vh uint16) error {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.subs[vh]; ok {
+		// Subscribed meanwhile.
+		return nil
+	}
+	c := p.characteristic(vh)
+	if c == nil {
+		// The search stops at the next declaration.
+		c = &ble.Characteristic{ValueHandle: vh, EndHandle: 0xFFFF}
+	}
+	d := c.CCCD
+	if d == nil {
+		var err error
+		if d, err = p.findDescriptor(c, ble.ClientCharacteristicConfigUUID); err != nil {
+			return err
+		}
+		if d == nil {
+			return fmt.Errorf("CCCD not found")
+		}
+	}
+	return p.ac.Write(d.Handle, make([]byte, 2))
+}
+
+// characteristic returns the discovered characteristic with the value handle
+// vh, or nil. Must be called with p locked.
+func (p *Client) characteristic(vh uint16) *ble.Characteristic {
+	if p.profile == nil {
+		return nil
+	}
+	for _, s := range p.profile.Services {
+		for _, c := range s.Characteristics {
+			if c.ValueHandle == vh {
+				return c
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package gatt_test
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux/att"
+	"github.com/leso-kn/ble/linux/gatt"
+)
+
+func TestUnregisteredNotifications(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	chrUUID := ble.MustParse("00010000-0002-1000-8000-00805F9B34FB")
+	svc := ble.NewService(ble.MustParse("00010000-0001-1000-8000-00805F9B34FB"))
+	chr := svc.NewCharacteristic(chrUUID)
+	chr.HandleNotify(ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {}))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+
+	cln, err := c.Dial(ctx, ble.NewAddr("11:22:33:44:55:66"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cln.CancelConnection()
+	prof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := prof.FindCharacteristic(ble.NewCharacteristic(chrUUID))
+	if v == nil {
+		t.Fatal("characteristic not discovered")
+	}
+	gc := cln.(*gatt.Client)
+	pdus := make(chan []byte, 10)
+	act := int32(gatt.UnsubscribeUnregistered)
+	gc.SetUnregisteredNotificationHandler(func(pdu []byte) gatt.UnregisteredAction {
+		select {
+		case pdus <- append([]byte(nil), pdu...):
+		default:
+		}
+		return gatt.UnregisteredAction(atomic.LoadInt32(&act))
+	})
+
+	// notify notifies until the value is sent to n centrals.
+	notify := func(n int) {
+		for {
+			if res := p.Notify(chr, false, []byte{0x01}); len(res.Sent) == n {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatalf("not sent to %d centrals", n)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	// Enabled behind the back of the client, the notifications are
+	// unregistered, and disabled by the policy.
+	if err := cln.WriteDescriptor(v.CCCD, []byte{0x01, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	notify(1)
+	select {
+	case pdu := <-pdus:
+		if want := []byte{att.HandleValueNotificationCode, byte(v.ValueHandle), byte(v.ValueHandle >> 8), 0x01}; !bytes.Equal(pdu, want) {
+			t.Fatalf("PDU % X, want % X", pdu, want)
+		}
+	case <-ctx.Done():
+		t.Fatal("handler not called")
+	}
+	notify(0)
+
+	atomic.StoreInt32(&act, int32(gatt.DisconnectUnregistered))
+	if err := cln.WriteDescriptor(v.CCCD, []byte{0x01, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	notify(1)
+	select {
+	case <-cln.Disconnected():
+	case <-ctx.Done():
+		t.Fatal("not disconnected")
+	}
+}
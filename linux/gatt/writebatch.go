@@ -0,0 +1,69 @@
+package gatt
+
+import (
+	"errors"
+
+	"github.com/leso-kn/ble"
+)
+
+// ErrWriteSkipped is the result of the writes of a batch skipped after a
+// failed one.
+var ErrWriteSkipped = errors.New("write skipped after a failed one")
+
+// WriteOp is a write of a WriteBatch: of the value of Characteristic, or of
+// Descriptor, if set. NoRsp writes a characteristic with a Write Command
+// rather than a Write Request; descriptors are written with requests.
+type WriteOp struct {
+	Characteristic *ble.Characteristic
+	Descriptor     *ble.Descriptor
+	Value          []byte
+	NoRsp          bool
+}
+
+// WriteBatchOptions sets how a WriteBatch interleaves with the other uses of
+// the client, and carries on after a failure.
+type WriteBatchOptions struct {
+	// YieldEvery releases the client after every YieldEvery writes, for the
+	// requests of other goroutines to go through before the batch goes on.
+	// The client is held for the whole batch if it's 0.
+	YieldEvery int
+
+	// ContinueOnError carries on with the writes following a failed one.
+	// Otherwise, they're skipped and their result is ErrWriteSkipped.
+	ContinueOnError bool
+}
+
+// WriteBatch issues the writes ops back-to-back, e.g. to configure dozens of
+// characteristics of a device, without acquiring the client for each. It
+// returns the results of the writes in order, nil for the successful ones,
+// and the error of the first failed one. Write Commands only fail locally,
+// e.g. with a value longer than ATT_MTU-3.
+func (p *Client) WriteBatch(ops []WriteOp, o WriteBatchOptions) ([]error, error) {
+	errs := make([]error, len(ops))
+	var first error
+
+	p.Lock()
+	for i, op := range ops {
+		if first != nil && !o.ContinueOnError {
+			errs[i] = ErrWriteSkipped
+			continue
+		}
+		if o.YieldEvery > 0 && i > 0 && i%o.YieldEvery == 0 {
+			p.Unlock()
+			p.Lock()
+		}
+		switch {
+		case op.Descriptor != nil:
+			errs[i] = p.ac.Write(op.Descriptor.Handle, op.Value)
+		case op.NoRsp:
+			errs[i] = p.ac.WriteCommand(op.Characteristic.ValueHandle, op.Value)
+		default:
+			errs[i] = p.ac.Write(op.Characteristic.ValueHandle, op.Value)
+		}
+		if errs[i] != nil && first == nil {
+			first = errs[i]
+		}
+	}
+	p.Unlock()
+	return errs, first
+}
@@ -0,0 +1,90 @@
+package gatt_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux/gatt"
+)
+
+func TestWriteBatch(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p := pair.Peripheral
+
+	var mu sync.Mutex
+	var written []string
+	record := func(name string) ble.WriteHandler {
+		return ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+			mu.Lock()
+			written = append(written, name+"="+string(req.Data()))
+			mu.Unlock()
+		})
+	}
+	svc := ble.NewService(ble.UUID16(0xFF00))
+	svc.NewCharacteristic(ble.UUID16(0xFF01)).HandleWrite(record("a"))
+	svc.NewCharacteristic(ble.UUID16(0xFF02)).HandleWrite(record("b"))
+	svc.NewCharacteristic(ble.UUID16(0xFF03)).HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		rsp.SetStatus(ble.ErrWriteNotPerm)
+	}))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	defer cln.CancelConnection()
+	gc := cln.(*gatt.Client)
+	prof, err := gc.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := prof.FindCharacteristic(ble.NewCharacteristic(ble.UUID16(0xFF01)))
+	b := prof.FindCharacteristic(ble.NewCharacteristic(ble.UUID16(0xFF02)))
+	rejected := prof.FindCharacteristic(ble.NewCharacteristic(ble.UUID16(0xFF03)))
+	if a == nil || b == nil || rejected == nil {
+		t.Fatal("characteristics not discovered")
+	}
+
+	ops := []gatt.WriteOp{
+		{Characteristic: a, Value: []byte("1")},
+		{Characteristic: b, Value: []byte("2"), NoRsp: true},
+		{Characteristic: rejected, Value: []byte("3")},
+		{Characteristic: a, Value: []byte("4")},
+	}
+	errs, err := gc.WriteBatch(ops, gatt.WriteBatchOptions{})
+	if !errors.Is(err, ble.ErrWriteNotPerm) {
+		t.Fatalf("batch error %v", err)
+	}
+	if errs[0] != nil || errs[1] != nil || !errors.Is(errs[2], ble.ErrWriteNotPerm) || errs[3] != gatt.ErrWriteSkipped {
+		t.Fatalf("results %v", errs)
+	}
+
+	errs, err = gc.WriteBatch(ops, gatt.WriteBatchOptions{YieldEvery: 1, ContinueOnError: true})
+	if !errors.Is(err, ble.ErrWriteNotPerm) {
+		t.Fatalf("batch error %v", err)
+	}
+	if errs[0] != nil || errs[1] != nil || !errors.Is(errs[2], ble.ErrWriteNotPerm) || errs[3] != nil {
+		t.Fatalf("results %v", errs)
+	}
+
+	// The write request following the write command makes sure the latter
+	// was served.
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"a=1", "b=2", "a=1", "b=2", "a=4"}
+	if len(written) != len(want) {
+		t.Fatalf("written %q, want %q", written, want)
+	}
+	for i := range want {
+		if written[i] != want[i] {
+			t.Fatalf("written %q, want %q", written, want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package hci
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci/cmd"
+	"github.com/leso-kn/ble/sliceops"
+)
+
+// The filter accept list of the controller holds the devices let through by
+// the advertising filter policy, set with ble.OptAdvFilterPolicy. It can't be
+// changed while advertising uses it; the changes fail with ErrDisallowed then.
+
+// AddToAcceptList adds a device to the controller's filter accept list. The
+// address is random if a is a RandomAddress, public otherwise. With privacy
+// enabled, peers using an RPA are added by their identity address.
+func (h *HCI) AddToAcceptList(a ble.Addr) error {
+	t, b, err := acceptListAddr(a)
+	if err != nil {
+		return err
+	}
+	return h.Send(&cmd.LEAddDeviceToWhiteList{AddressType: t, Address: b}, nil)
+}
+
+// RemoveFromAcceptList removes a device from the controller's filter accept
+// list.
+func (h *HCI) RemoveFromAcceptList(a ble.Addr) error {
+	t, b, err := acceptListAddr(a)
+	if err != nil {
+		return err
+	}
+	return h.Send(&cmd.LERemoveDeviceFromWhiteList{AddressType: t, Address: b}, nil)
+}
+
+// ClearAcceptList removes all devices from the controller's filter accept
+// list.
+func (h *HCI) ClearAcceptList() error {
+	return h.Send(&cmd.LEClearWhiteList{}, nil)
+}
+
+// AcceptListSize returns the number of devices the controller's filter accept
+// list holds.
+func (h *HCI) AcceptListSize() (int, error) {
+	rp := cmd.LEReadWhiteListSizeRP{}
+	if err := h.Send(&cmd.LEReadWhiteListSize{}, &rp); err != nil {
+		return 0, err
+	}
+	return int(rp.WhiteListSize), nil
+}
+
+// AcceptBonded replaces the filter accept list with the bonded peers, so a
+// filter policy of AdvFilterPolicyScanAndConn hides the device from others.
+// Peers are added by their identity address; a bond without one is added by
+// its address, of both types.
+func (h *HCI) AcceptBonded() error {
+	if h.bondManager == nil {
+		return fmt.Errorf("no bond manager")
+	}
+	bonds, err := h.bondManager.List()
+	if err != nil {
+		return err
+	}
+	if err := h.ClearAcceptList(); err != nil {
+		return err
+	}
+	for addr, bi := range bonds {
+		var cs []*cmd.LEAddDeviceToWhiteList
+		if id := bi.Identity(); id != nil && len(id.Addr) == 6 {
+			c := &cmd.LEAddDeviceToWhiteList{AddressType: id.AddrType}
+			copy(c.Address[:], sliceops.SwapBuf(id.Addr))
+			cs = append(cs, c)
+		} else {
+			// Bonds are keyed by the address, least significant octet first.
+			b, err := hex.DecodeString(addr)
+			if err != nil || len(b) != 6 {
+				h.Warnf("accept list: invalid bond address %q", addr)
+				continue
+			}
+			for _, t := range []uint8{AddressTypePublic, AddressTypeRandom} {
+				c := &cmd.LEAddDeviceToWhiteList{AddressType: t}
+				copy(c.Address[:], b)
+				cs = append(cs, c)
+			}
+		}
+		for _, c := range cs {
+			if err := h.Send(c, nil); err != nil {
+				return fmt.Errorf("accept list: add %s: %v", addr, err)
+			}
+		}
+	}
+	return nil
+}
+
+// acceptListAddr returns the address type and the address of a, least
+// significant octet first.
+func acceptListAddr(a ble.Addr) (uint8, [6]byte, error) {
+	var b [6]byte
+	ab := a.Bytes()
+	if len(ab) != 6 {
+		return 0, b, ErrInvalidAddr
+	}
+	copy(b[:], sliceops.SwapBuf(ab))
+	if _, ok := a.(RandomAddress); ok {
+		return AddressTypeRandom, b, nil
+	}
+	return AddressTypePublic, b, nil
+}
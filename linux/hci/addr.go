@@ -0,0 +1,212 @@
+package hci
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci/cmd"
+	"github.com/leso-kn/ble/sliceops"
+)
+
+// NewRandomStaticAddr generates a random static device address [Vol 6, Part B, 1.3.2.1].
+func NewRandomStaticAddr() (net.HardwareAddr, error) {
+	for {
+		a := make(net.HardwareAddr, 6)
+		if _, err := rand.Read(a); err != nil {
+			return nil, err
+		}
+		a[0] |= 0xC0
+		if ValidateRandomStaticAddr(a) == nil {
+			return a, nil
+		}
+	}
+}
+
+// ValidateRandomStaticAddr checks that a is a valid random static address.
+// The two most significant bits shall be 1, and the remaining bits shall
+// neither be all 0 nor all 1 [Vol 6, Part B, 1.3.2.1].
+func ValidateRandomStaticAddr(a net.HardwareAddr) error {
+	if len(a) != 6 {
+		return fmt.Errorf("invalid address length %v", len(a))
+	}
+	if a[0]&0xC0 != 0xC0 {
+		return fmt.Errorf("%v is not a static address", a)
+	}
+
+	zeros, ones := a[0]&0x3F == 0x00, a[0]&0x3F == 0x3F
+	for _, b := range a[1:] {
+		zeros = zeros && b == 0x00
+		ones = ones && b == 0xFF
+	}
+	if zeros || ones {
+		return fmt.Errorf("%v has an invalid random part", a)
+	}
+	return nil
+}
+
+// NewNonResolvableAddr generates a non-resolvable private address
+// [Vol 6, Part B, 1.3.2.2], e.g. to rotate the address of a beacon.
+func NewNonResolvableAddr() (net.HardwareAddr, error) {
+	for {
+		a := make(net.HardwareAddr, 6)
+		if _, err := rand.Read(a); err != nil {
+			return nil, err
+		}
+		a[0] &= 0x3F
+		if validateRandomAddr(a) == nil {
+			return a, nil
+		}
+	}
+}
+
+// NewResolvableAddr generates a resolvable private address from irk, least
+// significant octet first [Vol 6, Part B, 1.3.2.2], which the peers that
+// got the IRK when bonding resolve to the identity of the local device.
+func NewResolvableAddr(irk []byte) (net.HardwareAddr, error) {
+	for {
+		a := make(net.HardwareAddr, 6)
+		if _, err := rand.Read(a[:3]); err != nil {
+			return nil, err
+		}
+		a[0] = a[0]&0x3F | 0x40
+		if validateRandomAddr(a) != nil {
+			continue
+		}
+		// The hash is computed over prand, least significant octet first.
+		hash, err := ah(irk, []byte{a[2], a[1], a[0]})
+		if err != nil {
+			return nil, err
+		}
+		a[3], a[4], a[5] = hash[2], hash[1], hash[0]
+		return a, nil
+	}
+}
+
+// validateRandomAddr checks that a is a valid static, resolvable private or
+// non-resolvable private address: its random part is neither all 0 nor all 1
+// [Vol 6, Part B, 1.3.2].
+func validateRandomAddr(a net.HardwareAddr) error {
+	if len(a) != 6 {
+		return fmt.Errorf("invalid address length %v", len(a))
+	}
+	switch a[0] & 0xC0 {
+	case 0xC0:
+		return ValidateRandomStaticAddr(a)
+	case 0x40:
+		// The random part of an RPA is its prand, the 22 bits below the
+		// two most significant ones.
+		if p := a[0] & 0x3F; (p == 0x00 && a[1] == 0x00 && a[2] == 0x00) ||
+			(p == 0x3F && a[1] == 0xFF && a[2] == 0xFF) {
+			return fmt.Errorf("%v has an invalid random part", a)
+		}
+		return nil
+	case 0x00:
+		zeros, ones := a[0] == 0x00, a[0] == 0x3F
+		for _, b := range a[1:] {
+			zeros = zeros && b == 0x00
+			ones = ones && b == 0xFF
+		}
+		if zeros || ones {
+			return fmt.Errorf("%v has an invalid random part", a)
+		}
+		return nil
+	}
+	return fmt.Errorf("%v is not a random address", a)
+}
+
+// SetAdvertisingSetRandomAddress sets the random address of the extended
+// advertising set of handle [Vol 2, Part E, 7.8.52], which the set uses with
+// a random own address type. Each set may have its own: connectable sets keep
+// a stable address, while beacons rotate theirs, e.g. to ones made by
+// NewNonResolvableAddr. Like for CreateBIG, the sets are created with custom
+// commands. The address can't be changed while a connectable set advertises.
+func (h *HCI) SetAdvertisingSetRandomAddress(handle uint8, a ble.Addr) error {
+	ra, err := net.ParseMAC(a.String())
+	if err != nil {
+		return ErrInvalidAddr
+	}
+	if err := validateRandomAddr(ra); err != nil {
+		return err
+	}
+	c := &cmd.LESetAdvertisingSetRandomAddress{AdvertisingHandle: handle}
+	copy(c.RandomAddress[:], sliceops.SwapBuf(ra))
+	if err := h.Send(c, nil); err != nil {
+		return err
+	}
+
+	h.muAdvSetAddrs.Lock()
+	defer h.muAdvSetAddrs.Unlock()
+	if h.advSetAddrs == nil {
+		h.advSetAddrs = make(map[uint8]ble.Addr)
+	}
+	h.advSetAddrs[handle] = ble.NewAddr(ra.String())
+	return nil
+}
+
+// AdvertisingSetRandomAddress returns the random address last set for the
+// advertising set of handle, or nil. The controller can't be asked for it.
+func (h *HCI) AdvertisingSetRandomAddress(handle uint8) ble.Addr {
+	h.muAdvSetAddrs.Lock()
+	defer h.muAdvSetAddrs.Unlock()
+	return h.advSetAddrs[handle]
+}
+
+// loadRandomStaticAddr reads a random static address from filename. If the
+// file doesn't exist, a new address is generated and stored there.
+func loadRandomStaticAddr(filename string) (net.HardwareAddr, error) {
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		a, err := NewRandomStaticAddr()
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filename, []byte(a.String()+"\n"), 0644); err != nil {
+			return nil, fmt.Errorf("failed to store random address: %v", err)
+		}
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read random address: %v", err)
+	}
+
+	a, err := net.ParseMAC(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse random address: %v", err)
+	}
+	return a, ValidateRandomStaticAddr(a)
+}
+
+// ownAddressType returns the address type the local device uses for
+// advertising, scanning and initiating.
+func (h *HCI) ownAddressType() uint8 {
+	switch {
+	case h.privacy != nil && h.randomAddr != nil:
+		return AddressTypeRPAOrRandom
+	case h.privacy != nil:
+		return AddressTypeRPAOrPublic
+	case h.rotation != nil:
+		return AddressTypeRandom
+	case h.randomAddr != nil:
+		return AddressTypeRandom
+	}
+	return AddressTypePublic
+}
+
+// initRandomAddr configures the random address in the controller, if set.
+func (h *HCI) initRandomAddr() error {
+	if h.randomAddr == nil {
+		return nil
+	}
+
+	c := &cmd.LESetRandomAddress{}
+	copy(c.RandomAddress[:], sliceops.SwapBuf(h.randomAddr))
+	if err := h.Send(c, nil); err != nil {
+		return fmt.Errorf("set random address: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package hci
+
+import (
+	"net"
+	"testing"
+)
+
+func TestValidateRandomAddr(t *testing.T) {
+	for _, tt := range []struct {
+		addr string
+		ok   bool
+	}{
+		{"C1:22:33:44:55:66", true},  // Static.
+		{"FF:FF:FF:FF:FF:FF", false}, // Static, all 1.
+		{"41:22:33:44:55:66", true},  // Resolvable private.
+		{"40:00:00:44:55:66", false}, // Resolvable private, prand all 0.
+		{"01:22:33:44:55:66", true},  // Non-resolvable private.
+		{"00:00:00:00:00:00", false}, // Non-resolvable private, all 0.
+		{"81:22:33:44:55:66", false}, // Reserved.
+	} {
+		a, err := net.ParseMAC(tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := validateRandomAddr(a); (err == nil) != tt.ok {
+			t.Errorf("%s: %v", tt.addr, err)
+		}
+	}
+}
+
+func TestNewNonResolvableAddr(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		a, err := NewNonResolvableAddr()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a[0]&0xC0 != 0x00 || validateRandomAddr(a) != nil {
+			t.Fatalf("invalid non-resolvable address %v", a)
+		}
+	}
+}
+
+func TestNewResolvableAddr(t *testing.T) {
+	irk := []byte{0x9B, 0x7D, 0x39, 0x0A, 0xA6, 0x10, 0x10, 0x34, 0x05, 0xAD, 0xC8, 0x57, 0xA3, 0x34, 0x02, 0xEC}
+	for i := 0; i < 100; i++ {
+		a, err := NewResolvableAddr(irk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b [6]byte
+		copy(b[:], a)
+		if !isRPA(b) || validateRandomAddr(a) != nil {
+			t.Fatalf("invalid resolvable private address %v", a)
+		}
+		if ok, err := resolveRPA(irk, b); err != nil || !ok {
+			t.Fatalf("%v not resolved with its irk: %v", a, err)
+		}
+	}
+}
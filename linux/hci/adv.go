@@ -2,6 +2,7 @@ package hci
 
 import (
 	"encoding/hex"
+	"net"
 	"strings"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/leso-kn/ble"
 	"github.com/leso-kn/ble/linux/adv"
 	"github.com/leso-kn/ble/linux/hci/evt"
+	"github.com/leso-kn/ble/parser"
 )
 
 // RandomAddress is a Random Device Address.
@@ -26,12 +28,14 @@ const (
 	evtTypScanRsp       = 0x04 // Scan Response (SCAN_RSP).
 )
 
-func newAdvertisement(e evt.LEAdvertisingReport, i int) (*Advertisement, error) {
+// newAdvertisement returns the i-th advertisement of the report. If lenient,
+// malformed AD structures are skipped rather than failing the advertisement.
+func newAdvertisement(e evt.LEAdvertisingReport, i int, lenient bool) (*Advertisement, error) {
 	ad, err := e.DataWErr(i)
 	if err != nil {
 		return nil, err
 	}
-	p, err := adv.NewRawPacket(ad)
+	p, err := newRawPacket(lenient, ad)
 	if err != nil {
 		//reverse for printing
 		a := e.Address(i)
@@ -42,21 +46,101 @@ func newAdvertisement(e evt.LEAdvertisingReport, i int) (*Advertisement, error)
 		return nil, errors.Wrap(err, hex.EncodeToString(a[:]))
 	}
 
-	ts := int64(time.Now().UnixNano() / 1000)
-	a := &Advertisement{e: e, i: i, p: p, ts: ts}
+	a := &Advertisement{e: e, i: i, p: p, rx: time.Now(), lenient: lenient}
 	return a, nil
 }
 
+// newLazyAdvertisement returns the i-th advertisement of the report, which
+// decodes its fields on demand.
+func newLazyAdvertisement(e evt.LEAdvertisingReport, i int) (*Advertisement, error) {
+	ad, err := e.DataWErr(i)
+	if err != nil {
+		return nil, err
+	}
+	return &Advertisement{e: e, i: i, v: adv.NewView(ad, nil), rx: time.Now(), lenient: true}, nil
+}
+
+// newAdvertisement returns the i-th advertisement of the report received at
+// rx, parsed as set up.
+func (h *HCI) newAdvertisement(e evt.LEAdvertisingReport, i int, rx time.Time) (*Advertisement, error) {
+	var a *Advertisement
+	var err error
+	if h.advLazy {
+		a, err = newLazyAdvertisement(e, i)
+	} else {
+		a, err = newAdvertisement(e, i, h.advLenient)
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.rx = rx
+	return a, nil
+}
+
+func newRawPacket(lenient bool, b ...[]byte) (*adv.Packet, error) {
+	if lenient {
+		return adv.NewRawPacketLenient(b...), nil
+	}
+	return adv.NewRawPacket(b...)
+}
+
 // Advertisement implements ble.Advertisement and other functions that are only
 // available on Linux.
 type Advertisement struct {
 	e  evt.LEAdvertisingReport
 	i  int
 	sr *Advertisement
-	ts int64
+
+	// rx is when the HCI received the report, with a monotonic clock
+	// reading, and delivered when it was passed to the advertisement handler.
+	rx        time.Time
+	delivered time.Time
+
+	// identity is set when the advertiser's private address was resolved on the host.
+	identity *Identity
+
+	// rssi overrides the RSSI of the report in aggregated advertisements.
+	rssi *int
+
+	// lenient is set when malformed AD structures are skipped.
+	lenient bool
 
 	// cached packets.
 	p *adv.Packet
+
+	// v, in lazy parsing mode, decodes the fields on demand instead of p.
+	v *adv.View
+}
+
+// advFields are the fields of an advertisement, decoded by adv.Packet or
+// adv.View.
+type advFields interface {
+	LocalName() string
+	ManufacturerData() []byte
+	ServiceData() []ble.ServiceData
+	UUIDs() []ble.UUID
+	TxPower() (int, bool)
+	ServiceSol() []ble.UUID
+}
+
+// fields returns the view of a lazily parsed advertisement, or its packet.
+func (a *Advertisement) fields() advFields {
+	switch {
+	case a.v != nil:
+		return a.v
+	case a.p != nil:
+		return a.p
+	}
+	return nil
+}
+
+// packet returns the decoded packet of the advertisement. Lazily parsed
+// advertisements are decoded on each call.
+func (a *Advertisement) packet() *adv.Packet {
+	if a.v != nil {
+		return a.v.Packet()
+	}
+	return a.p
 }
 
 // setScanResponse associate scan response to the existing advertisement.
@@ -72,8 +156,14 @@ func (a *Advertisement) setScanResponse(sr *Advertisement) error {
 		return err
 	}
 
+	if a.v != nil {
+		a.sr = sr
+		a.v = adv.NewView(ad, srd)
+		return nil
+	}
+
 	//does this parse ok?
-	p, err := adv.NewRawPacket(ad, srd)
+	p, err := newRawPacket(a.lenient, ad, srd)
 	if err != nil {
 		return errors.Wrap(err, "setScanResp")
 	}
@@ -158,6 +248,41 @@ func (a *Advertisement) AddrType() uint8 {
 	return v
 }
 
+// IdentityResolved reports whether the advertiser's private address was
+// resolved, either by the controller or the host.
+// This is linux specific.
+func (a *Advertisement) IdentityResolved() bool {
+	return a.IdentityAddr() != nil
+}
+
+// IdentityAddr returns the identity address of the advertiser, if its private
+// address was resolved. If the controller resolved it, this equals Addr.
+// This is linux specific.
+func (a *Advertisement) IdentityAddr() ble.Addr {
+	if a.identity != nil {
+		return ble.NewAddr(net.HardwareAddr(a.identity.Addr).String())
+	}
+	v, _ := a.addressTypeWErr()
+	if v == AddressTypeRPAOrPublic || v == AddressTypeRPAOrRandom {
+		return a.Addr()
+	}
+	return nil
+}
+
+// resolveIdentity looks up the advertiser's identity, if it uses a
+// resolvable private address.
+func (a *Advertisement) resolveIdentity(r *resolver) {
+	at, err := a.addressTypeWErr()
+	if err != nil || at != AddressTypeRandom {
+		return
+	}
+	addr, err := a.e.AddressWErr(a.i)
+	if err != nil {
+		return
+	}
+	a.identity = r.resolve([6]byte{addr[5], addr[4], addr[3], addr[2], addr[1], addr[0]})
+}
+
 // Data returns the advertising data of the packet.
 // This is linux specific.
 func (a *Advertisement) Data() []byte {
@@ -172,6 +297,97 @@ func (a *Advertisement) SrData() []byte {
 	return v
 }
 
+// ADStructures returns an iterator over the raw AD structures of the
+// advertising data and then the scan response data, including the types
+// that aren't decoded. Buffer 0 of the iterator is the advertising data, 1 the
+// scan response data.
+// This is linux specific.
+func (a *Advertisement) ADStructures() *parser.Iterator {
+	return parser.NewIterator(a.Data(), a.ScanResponse())
+}
+
+// Eddystone returns the Eddystone frame of the advertisement, or nil if it has
+// none.
+// This is linux specific.
+func (a *Advertisement) Eddystone() adv.EddystoneFrame {
+	p := a.packet()
+	if p == nil {
+		return nil
+	}
+	return p.Eddystone()
+}
+
+// IBeacon returns the iBeacon content of the advertisement, or nil if it
+// isn't an iBeacon.
+// This is linux specific.
+func (a *Advertisement) IBeacon() *adv.IBeaconInfo {
+	p := a.packet()
+	if p == nil {
+		return nil
+	}
+	return p.IBeacon()
+}
+
+// DataMap returns a copy of the decoded fields of the advertising and scan
+// response data, keyed by AdvertisementMapKeys. Unlike ToMap, the values are
+// as decoded, so the map can be modified and encoded back with
+// adv.EncodeMap.
+// This is linux specific.
+func (a *Advertisement) DataMap() map[string]interface{} {
+	m := make(map[string]interface{})
+	if p := a.packet(); p != nil {
+		for k, v := range p.Map() {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// MeshPDUs returns the payloads of the PB-ADV, Mesh Message, and Mesh Beacon
+// fields of the advertisement, for a mesh stack running on top of the
+// scanner.
+// This is linux specific.
+func (a *Advertisement) MeshPDUs() (pbADV, messages, beacons [][]byte) {
+	p := a.packet()
+	if p == nil {
+		return nil, nil, nil
+	}
+	return p.PBADV(), p.MeshMessages(), p.MeshBeacons()
+}
+
+// AppleContinuity returns the Apple Continuity messages of the
+// advertisement, or nil if it has none.
+// This is linux specific.
+func (a *Advertisement) AppleContinuity() []adv.ContinuityMessage {
+	p := a.packet()
+	if p == nil {
+		return nil
+	}
+	return p.AppleContinuity()
+}
+
+// SwiftPair returns the Microsoft Swift Pair beacon of the advertisement, or
+// nil if it isn't one.
+// This is linux specific.
+func (a *Advertisement) SwiftPair() *adv.SwiftPair {
+	p := a.packet()
+	if p == nil {
+		return nil
+	}
+	return p.SwiftPair()
+}
+
+// AltBeacon returns the AltBeacon content of the advertisement, or nil if it
+// isn't an AltBeacon.
+// This is linux specific.
+func (a *Advertisement) AltBeacon() *adv.AltBeaconData {
+	p := a.packet()
+	if p == nil {
+		return nil
+	}
+	return p.AltBeacon()
+}
+
 // ScanResponse returns the scan response of the packet, if it presents.
 // This is linux specific.
 func (a *Advertisement) ScanResponse() []byte {
@@ -179,8 +395,49 @@ func (a *Advertisement) ScanResponse() []byte {
 	return v
 }
 
+// ParseErrors returns the errors of the malformed AD structures skipped in
+// lenient parsing mode.
+// This is linux specific.
+func (a *Advertisement) ParseErrors() []error {
+	p := a.packet()
+	if p == nil {
+		return nil
+	}
+	return p.ParseErrors()
+}
+
+// HasScanResponse reports whether the scan response of the advertiser is
+// attached to the advertisement. With OptScanResponseWait, a scannable
+// advertisement whose scan response didn't arrive in time doesn't have it.
+func (a *Advertisement) HasScanResponse() bool {
+	return a.sr != nil
+}
+
+// Timestamp returns when the HCI received the advertising report, in
+// nanoseconds since the Unix epoch. Legacy reports carry no controller
+// timestamp; this is the host's clock as the event was read.
 func (a *Advertisement) Timestamp() int64 {
-	return a.ts
+	return a.rx.UnixNano()
+}
+
+// ReceivedAt returns when the HCI received the advertising report. It
+// carries a monotonic clock reading, so the intervals between the reports
+// aren't affected by changes of the wall clock.
+// This is linux specific.
+func (a *Advertisement) ReceivedAt() time.Time {
+	return a.rx
+}
+
+// Latency returns the time from the receipt of the report to its delivery to
+// the advertisement handler, including the time it waited for a handler
+// goroutine or an aggregation period. It's zero until the advertisement is
+// delivered.
+// This is linux specific.
+func (a *Advertisement) Latency() time.Duration {
+	if a.delivered.IsZero() {
+		return 0
+	}
+	return a.delivered.Sub(a.rx)
 }
 
 func (a *Advertisement) ToMap() (map[string]interface{}, error) {
@@ -199,6 +456,10 @@ func (a *Advertisement) ToMap() (map[string]interface{}, error) {
 	}
 	m[keys.AddressType] = at
 
+	if ia := a.IdentityAddr(); ia != nil {
+		m[keys.IdentityAddress] = strings.Replace(ia.String(), ":", "", -1)
+	}
+
 	et, err := a.eventTypeWErr()
 	if err != nil {
 		return nil, errors.Wrap(err, keys.EventType)
@@ -222,8 +483,8 @@ func (a *Advertisement) ToMap() (map[string]interface{}, error) {
 	}
 
 	//join the adv data maps
-	if a.p != nil {
-		for k, v := range a.p.Map() {
+	if p := a.packet(); p != nil {
+		for k, v := range p.Map() {
 			//some special processing requirements for certain keys
 			//todo: this should be handled better in the parser
 			if k == keys.Name {
@@ -238,6 +499,23 @@ func (a *Advertisement) ToMap() (map[string]interface{}, error) {
 				} else {
 					m[k] = v
 				}
+			} else if k == keys.URI {
+				m[k] = p.URI()
+			} else if k == keys.Appearance {
+				ap, _ := p.Appearance()
+				m[k] = int(ap)
+			} else if k == keys.AdvInterval {
+				m[k], _ = p.AdvInterval()
+			} else if k == keys.PublicTargetAddresses || k == keys.RandomTargetAddresses {
+				addrs := p.PublicTargetAddrs()
+				if k == keys.RandomTargetAddresses {
+					addrs = p.RandomTargetAddrs()
+				}
+				var ss []string
+				for _, addr := range addrs {
+					ss = append(ss, strings.Replace(addr.String(), ":", "", -1))
+				}
+				m[k] = ss
 			} else {
 				m[k] = v
 			}
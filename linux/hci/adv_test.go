@@ -0,0 +1,49 @@
+package hci_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux/hci"
+)
+
+func TestAdvTimestamps(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+
+	start := time.Now()
+	got := make(chan *hci.Advertisement, 1)
+	sctx, scancel := context.WithCancel(ctx)
+	defer scancel()
+	go c.Scan(sctx, true, func(a ble.Advertisement) {
+		select {
+		case got <- a.(*hci.Advertisement):
+		default:
+		}
+	})
+	var a *hci.Advertisement
+	select {
+	case a = <-got:
+	case <-ctx.Done():
+		t.Fatal("no advertisement")
+	}
+	now := time.Now()
+
+	if rx := a.ReceivedAt(); rx.Before(start) || rx.After(now) {
+		t.Errorf("received at %v, not between %v and %v", rx, start, now)
+	}
+	if ts := a.Timestamp(); ts != a.ReceivedAt().UnixNano() {
+		t.Errorf("timestamp %d, want %d", ts, a.ReceivedAt().UnixNano())
+	}
+	if l := a.Latency(); l <= 0 || l > now.Sub(start) {
+		t.Errorf("latency %v", l)
+	}
+}
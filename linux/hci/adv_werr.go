@@ -8,54 +8,61 @@ import (
 )
 
 func (a *Advertisement) localNameWErr() (string, error) {
-	if a.p == nil {
+	f := a.fields()
+	if f == nil {
 		return "", fmt.Errorf("nil packet")
 	}
-	return a.p.LocalName(), nil
+	return f.LocalName(), nil
 }
 
 func (a *Advertisement) manufacturerDataWErr() ([]byte, error) {
-	if a.p == nil {
+	f := a.fields()
+	if f == nil {
 		return nil, fmt.Errorf("nil packet")
 	}
-	return a.p.ManufacturerData(), nil
+	return f.ManufacturerData(), nil
 }
 
 func (a *Advertisement) serviceDataWErr() ([]ble.ServiceData, error) {
-	if a.p == nil {
+	f := a.fields()
+	if f == nil {
 		return nil, fmt.Errorf("nil packet")
 	}
-	return a.p.ServiceData(), nil
+	return f.ServiceData(), nil
 }
 
 func (a *Advertisement) servicesWErr() ([]ble.UUID, error) {
-	if a.p == nil {
+	f := a.fields()
+	if f == nil {
 		return nil, fmt.Errorf("nil packet")
 	}
-	return a.p.UUIDs(), nil
+	return f.UUIDs(), nil
 }
 
 func (a *Advertisement) overflowServiceWErr() ([]ble.UUID, error) {
-	if a.p == nil {
+	f := a.fields()
+	if f == nil {
 		return nil, fmt.Errorf("nil packet")
 	}
-	return a.p.UUIDs(), nil
+	return f.UUIDs(), nil
 }
 
 func (a *Advertisement) txPowerLevelWErr() (int, error) {
-	if a.p == nil {
+	f := a.fields()
+	if f == nil {
 		return 0, fmt.Errorf("nil packet")
 	}
 
-	pwr, _ := a.p.TxPower()
+	pwr, _ := f.TxPower()
 	return pwr, nil
 }
 
 func (a *Advertisement) solicitedServiceWErr() ([]ble.UUID, error) {
-	if a.p == nil {
+	f := a.fields()
+	if f == nil {
 		return nil, fmt.Errorf("nil packet")
 	}
-	return a.p.ServiceSol(), nil
+	return f.ServiceSol(), nil
 }
 
 func (a *Advertisement) connectableWErr() (bool, error) {
@@ -69,6 +76,9 @@ func (a *Advertisement) connectableWErr() (bool, error) {
 }
 
 func (a *Advertisement) rssiWErr() (int, error) {
+	if a.rssi != nil {
+		return *a.rssi, nil
+	}
 	r, err := a.e.RSSIWErr(a.i)
 	return int(r), err
 }
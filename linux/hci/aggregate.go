@@ -0,0 +1,135 @@
+package hci
+
+import (
+	"sync"
+	"time"
+)
+
+// aggIdlePeriods is the number of periods without reports after which a peer
+// is forgotten, along with its last scan response.
+const aggIdlePeriods = 10
+
+// advAggregator merges the advertising data and scan responses of each peer,
+// and delivers a single consolidated Advertisement per peer and period: the
+// latest advertising data, the latest scan response and the freshest RSSI.
+type advAggregator struct {
+	sync.Mutex
+	period time.Duration
+	peers  map[string]*aggPeer
+	stop   chan struct{}
+}
+
+type aggPeer struct {
+	ad      *Advertisement
+	sr      *Advertisement
+	rssi    int
+	updated bool
+	idle    int
+}
+
+// add records a report. It must be called from the event loop, as it copies
+// the advertisement, which the loop mutates to attach scan responses.
+func (g *advAggregator) add(a *Advertisement) {
+	cp := *a
+	rssi := cp.RSSI()
+	if cp.sr != nil {
+		// The scan response is the latest report.
+		rssi = cp.sr.RSSI()
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	if g.peers == nil {
+		g.peers = make(map[string]*aggPeer)
+	}
+	k := cp.Addr().String()
+	p := g.peers[k]
+	if p == nil {
+		p = &aggPeer{}
+		g.peers[k] = p
+	}
+	p.ad = &cp
+	if cp.sr != nil {
+		p.sr = cp.sr
+	}
+	p.rssi = rssi
+	p.updated, p.idle = true, 0
+}
+
+// flush returns the consolidated advertisements of the peers reported since
+// the last flush, and forgets the peers idle for too long.
+func (g *advAggregator) flush() []*Advertisement {
+	g.Lock()
+	defer g.Unlock()
+	var advs []*Advertisement
+	for k, p := range g.peers {
+		if !p.updated {
+			if p.idle++; p.idle >= aggIdlePeriods {
+				delete(g.peers, k)
+			}
+			continue
+		}
+		p.updated = false
+
+		a := &Advertisement{e: p.ad.e, i: p.ad.i, p: p.ad.p, v: p.ad.v, identity: p.ad.identity, rx: p.ad.rx}
+		if p.sr != nil && a.setScanResponse(p.sr) != nil {
+			// Keep the advertising data alone if they don't parse together.
+			a.p = p.ad.p
+		}
+		rssi := p.rssi
+		a.rssi = &rssi
+		advs = append(advs, a)
+	}
+	return advs
+}
+
+// startAggregation delivers the consolidated advertisements every period,
+// until stopAggregation.
+func (h *HCI) startAggregation() {
+	g := &h.advAggregator
+	g.Lock()
+	defer g.Unlock()
+	if g.period <= 0 || g.stop != nil {
+		return
+	}
+	g.peers = nil
+	stop := make(chan struct{})
+	g.stop = stop
+
+	h.group.Go(func() {
+		t := time.NewTicker(g.period)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-h.done:
+				return
+			case <-t.C:
+			}
+			for _, a := range g.flush() {
+				if h.advHandler == nil {
+					break
+				}
+				h.dispatchAdv(a)
+			}
+		}
+	})
+}
+
+func (h *HCI) stopAggregation() {
+	g := &h.advAggregator
+	g.Lock()
+	defer g.Unlock()
+	if g.stop != nil {
+		close(g.stop)
+		g.stop = nil
+	}
+}
+
+// aggregating reports whether advertisements are aggregated.
+func (h *HCI) aggregating() bool {
+	h.advAggregator.Lock()
+	defer h.advAggregator.Unlock()
+	return h.advAggregator.stop != nil
+}
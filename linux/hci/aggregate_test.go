@@ -0,0 +1,50 @@
+package hci
+
+import (
+	"testing"
+
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+// advReport returns a single LE Advertising Report.
+func advReport(t *testing.T, et uint8, data []byte, rssi int8) *Advertisement {
+	b := []byte{evt.LEAdvertisingReportSubCode, 1, et, 0, 1, 2, 3, 4, 5, 6, byte(len(data))}
+	b = append(b, data...)
+	b = append(b, byte(rssi))
+	a, err := newAdvertisement(evt.LEAdvertisingReport(b), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAdvAggregator(t *testing.T) {
+	var g advAggregator
+
+	ad := advReport(t, evtTypAdvInd, []byte{0x02, 0x01, 0x06, 0x03, 0x03, 0x0D, 0x18}, -70)
+	g.add(ad)
+	sr := advReport(t, evtTypScanRsp, []byte{0x04, 0x09, 'h', 'r', 's'}, -60)
+	ad.setScanResponse(sr)
+	g.add(ad)
+	// A later report without the scan response keeps the last one.
+	g.add(advReport(t, evtTypAdvInd, []byte{0x02, 0x01, 0x06, 0x03, 0x03, 0x0D, 0x18}, -50))
+
+	advs := g.flush()
+	if len(advs) != 1 {
+		t.Fatalf("got %d advertisements, want 1", len(advs))
+	}
+	a := advs[0]
+	if a.LocalName() != "hrs" || len(a.Services()) != 1 || a.RSSI() != -50 {
+		t.Fatalf("unexpected advertisement: name %q, services %v, rssi %d", a.LocalName(), a.Services(), a.RSSI())
+	}
+
+	if n := len(g.flush()); n != 0 {
+		t.Fatalf("got %d advertisements without reports, want 0", n)
+	}
+	for i := 0; i < aggIdlePeriods; i++ {
+		g.flush()
+	}
+	if len(g.peers) != 0 {
+		t.Fatal("idle peer not forgotten")
+	}
+}
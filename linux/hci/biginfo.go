@@ -0,0 +1,70 @@
+package hci
+
+import (
+	"time"
+
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+// BIGInfo describes a broadcast isochronous group, as reported from the
+// periodic advertising train it's announced in. It holds what's needed to
+// synchronize to the group with BIGCreateSync.
+type BIGInfo struct {
+	SyncHandle  uint16 // Periodic advertising sync the BIGInfo was received on.
+	NumBIS      uint8
+	NSE         uint8
+	ISOInterval time.Duration
+	BN          uint8
+	PTO         uint8
+	IRC         uint8
+	MaxPDU      uint16
+	SDUInterval time.Duration
+	MaxSDU      uint16
+	PHY         uint8
+	Framed      bool
+	Encrypted   bool // A broadcast code is needed to synchronize.
+}
+
+func newBIGInfo(e evt.LEBIGInfoAdvertisingReport) BIGInfo {
+	return BIGInfo{
+		SyncHandle:  e.SyncHandle(),
+		NumBIS:      e.NumBIS(),
+		NSE:         e.NSE(),
+		ISOInterval: time.Duration(e.ISOInterval()) * 1250 * time.Microsecond,
+		BN:          e.BN(),
+		PTO:         e.PTO(),
+		IRC:         e.IRC(),
+		MaxPDU:      e.MaxPDU(),
+		SDUInterval: time.Duration(e.SDUInterval()) * time.Microsecond,
+		MaxSDU:      e.MaxSDU(),
+		PHY:         e.PHY(),
+		Framed:      e.Framing() == 1,
+		Encrypted:   e.Encryption() == 1,
+	}
+}
+
+// SetBIGInfoHandler sets the handler called with the BIGInfo reports of the
+// periodic advertising trains the controller is synchronized to.
+func (h *HCI) SetBIGInfoHandler(f func(BIGInfo)) error {
+	if err := h.EnableISO(false); err != nil {
+		return err
+	}
+	h.iso.mu.Lock()
+	h.iso.bigInfoHandler = f
+	h.iso.mu.Unlock()
+	return nil
+}
+
+func (h *HCI) handleLEBIGInfoAdvertisingReport(b []byte) error {
+	if len(b) < 20 {
+		return nil
+	}
+	h.iso.mu.Lock()
+	f := h.iso.bigInfoHandler
+	h.iso.mu.Unlock()
+	if f != nil {
+		info := newBIGInfo(evt.LEBIGInfoAdvertisingReport(b))
+		h.group.Go(func() { f(info) })
+	}
+	return nil
+}
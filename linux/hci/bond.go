@@ -1,10 +1,14 @@
 package hci
 
+import "io"
+
 type bondInfo struct {
 	longTermKey []byte
 	ediv        uint16
 	randVal     uint64
 	legacy      bool
+	identity    *Identity
+	signing     *SigningKeys
 }
 
 type BondManager interface {
@@ -12,6 +16,24 @@ type BondManager interface {
 	Save(string, BondInfo) error
 	Exists(addr string) bool
 	Delete(addr string) error
+	BondLister
+
+	// DeleteAll deletes all bonds.
+	DeleteAll() error
+
+	// Export writes all bonds to w, unencrypted, in a format Import reads,
+	// e.g. to move them to another device.
+	Export(w io.Writer) error
+
+	// Import adds the bonds written by Export, replacing those with the
+	// same address.
+	Import(r io.Reader) error
+}
+
+// BondLister enumerates the stored bonds, keyed by the same address strings
+// used with Find.
+type BondLister interface {
+	List() (map[string]BondInfo, error)
 }
 
 type BondInfo interface {
@@ -19,14 +41,52 @@ type BondInfo interface {
 	EDiv() uint16
 	Random() uint64
 	Legacy() bool
+	Identity() *Identity
+	Signing() *SigningKeys
+}
+
+// Identity is the identity information distributed by a peer during key
+// distribution [Vol 3, Part H, 3.6.4 & 3.6.5].
+type Identity struct {
+	// IRK is the Identity Resolving Key, least significant octet first.
+	IRK []byte
+	// Addr is the public or static random identity address, most significant octet first.
+	Addr []byte
+	// AddrType is 0x00 for a public and 0x01 for a static random identity address.
+	AddrType uint8
+}
+
+// SigningKeys are the Connection Signature Resolving Keys exchanged during key
+// distribution [Vol 3, Part H, 3.6.6], for data signing on unencrypted links.
+type SigningKeys struct {
+	// RemoteCSRK is distributed by the peer, to verify its signed data.
+	RemoteCSRK []byte
+	// LocalCSRK is distributed to the peer, to sign our data.
+	LocalCSRK []byte
+	// RemoteSignCounter is the lowest SignCounter accepted in the peer's
+	// next signed data, one past the last verified one.
+	RemoteSignCounter uint32
 }
 
 func NewBondInfo(longTermKey []byte, ediv uint16, random uint64, legacy bool) BondInfo {
+	return NewBondInfoWithIdentity(longTermKey, ediv, random, legacy, nil)
+}
+
+// NewBondInfoWithIdentity returns a BondInfo which also carries the peer's identity information.
+func NewBondInfoWithIdentity(longTermKey []byte, ediv uint16, random uint64, legacy bool, id *Identity) BondInfo {
+	return NewBondInfoWithKeys(longTermKey, ediv, random, legacy, id, nil)
+}
+
+// NewBondInfoWithKeys returns a BondInfo which also carries the peer's
+// identity information and the signing keys, either of which may be nil.
+func NewBondInfoWithKeys(longTermKey []byte, ediv uint16, random uint64, legacy bool, id *Identity, sk *SigningKeys) BondInfo {
 	return &bondInfo{
 		longTermKey: longTermKey,
 		ediv:        ediv,
 		randVal:     random,
 		legacy:      legacy,
+		identity:    id,
+		signing:     sk,
 	}
 }
 
@@ -45,3 +105,13 @@ func (b *bondInfo) Random() uint64 {
 func (b *bondInfo) Legacy() bool {
 	return b.legacy
 }
+
+// Identity returns the peer's identity information, or nil if it wasn't distributed.
+func (b *bondInfo) Identity() *Identity {
+	return b.identity
+}
+
+// Signing returns the signing keys, or nil if none were distributed.
+func (b *bondInfo) Signing() *SigningKeys {
+	return b.signing
+}
@@ -0,0 +1,165 @@
+package bond
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci"
+	"github.com/leso-kn/ble/sliceops"
+)
+
+// BlueZ LTK types, stored as Authenticated [mgmt-api.txt, Load Long Term Keys].
+const (
+	bluezLTKP256Unauth = 0x02
+	bluezLTKP256Auth   = 0x03
+)
+
+// ImportBlueZ imports the LE bonds stored by bluetoothd for an adapter, e.g.
+// /var/lib/bluetooth/00:11:22:33:44:55, into bm, so bonded devices don't
+// need to pair again. Devices without an LTK, such as BR/EDR only ones, are
+// skipped, as are those whose info file can't be read. It returns the number
+// of imported bonds.
+func ImportBlueZ(bm hci.BondManager, adapterDir string) (int, error) {
+	entries, err := ioutil.ReadDir(adapterDir)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, e := range entries {
+		addr, err := net.ParseMAC(e.Name())
+		if !e.IsDir() || err != nil || len(addr) != 6 {
+			continue
+		}
+		info, err := readBlueZInfo(filepath.Join(adapterDir, e.Name(), "info"))
+		if err != nil {
+			ble.GetLogger().Warnf("bluez: %s: %v", e.Name(), err)
+			continue
+		}
+		bi, err := createBlueZBondInfo(addr, info)
+		if err != nil {
+			ble.GetLogger().Warnf("bluez: %s: %v", e.Name(), err)
+			continue
+		}
+		if bi == nil {
+			continue
+		}
+		// Bonds are keyed by the address, least significant octet first.
+		if err := bm.Save(hex.EncodeToString(sliceops.SwapBuf(addr)), bi); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// readBlueZInfo reads an info file into its groups of keys.
+func readBlueZInfo(name string) (map[string]map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	groups := map[string]map[string]string{}
+	var g map[string]string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[' && line[len(line)-1] == ']':
+			g = map[string]string{}
+			groups[line[1:len(line)-1]] = g
+		case g != nil:
+			if i := strings.IndexByte(line, '='); i > 0 {
+				g[line[:i]] = line[i+1:]
+			}
+		}
+	}
+	return groups, s.Err()
+}
+
+// createBlueZBondInfo returns the bond of a device, or nil if it has no LTK.
+// Keys are stored least significant octet first, as hci.BondInfo holds them.
+func createBlueZBondInfo(addr net.HardwareAddr, info map[string]map[string]string) (hci.BondInfo, error) {
+	// A bond holds a single LTK; prefer the one used as the central.
+	ltkGroup := info["LongTermKey"]
+	for _, g := range []string{"PeripheralLongTermKey", "SlaveLongTermKey"} {
+		if ltkGroup == nil {
+			ltkGroup = info[g]
+		}
+	}
+	if ltkGroup == nil {
+		return nil, nil
+	}
+
+	ltk, err := hex.DecodeString(ltkGroup["Key"])
+	if err != nil || len(ltk) != 16 {
+		return nil, fmt.Errorf("invalid long term key")
+	}
+	auth, _ := strconv.Atoi(ltkGroup["Authenticated"])
+	legacy := auth != bluezLTKP256Unauth && auth != bluezLTKP256Auth
+
+	var ediv uint64
+	var rand uint64
+	if legacy {
+		if ediv, err = strconv.ParseUint(ltkGroup["EDiv"], 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid ediv: %v", err)
+		}
+		if rand, err = strconv.ParseUint(ltkGroup["Rand"], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid rand: %v", err)
+		}
+	}
+
+	irk, err := blueZKey(info, "IdentityResolvingKey")
+	if err != nil {
+		return nil, err
+	}
+	var id *hci.Identity
+	if irk != nil {
+		id = &hci.Identity{IRK: irk, Addr: append([]byte{}, addr...)}
+		if info["General"]["AddressType"] == "static" {
+			id.AddrType = 1
+		}
+	}
+
+	remote, err := blueZKey(info, "RemoteSignatureKey")
+	if err != nil {
+		return nil, err
+	}
+	local, err := blueZKey(info, "LocalSignatureKey")
+	if err != nil {
+		return nil, err
+	}
+	var sk *hci.SigningKeys
+	if remote != nil || local != nil {
+		sk = &hci.SigningKeys{RemoteCSRK: remote, LocalCSRK: local}
+		if c, err := strconv.ParseUint(info["RemoteSignatureKey"]["Counter"], 10, 32); err == nil {
+			sk.RemoteSignCounter = uint32(c)
+		}
+	}
+
+	return hci.NewBondInfoWithKeys(ltk, uint16(ediv), rand, legacy, id, sk), nil
+}
+
+// blueZKey returns the 128-bit key of a group, or nil if there's no group.
+func blueZKey(info map[string]map[string]string, group string) ([]byte, error) {
+	g, ok := info[group]
+	if !ok {
+		return nil, nil
+	}
+	k, err := hex.DecodeString(g["Key"])
+	if err != nil || len(k) != 16 {
+		return nil, fmt.Errorf("invalid %s", group)
+	}
+	return k, nil
+}
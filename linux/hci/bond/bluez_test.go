@@ -0,0 +1,98 @@
+package bond
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportBlueZ(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bluez")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	devices := map[string]string{
+		// Legacy LE bond
+		"11:22:33:44:55:66": `[General]
+Name=Sensor
+AddressType=static
+
+[IdentityResolvingKey]
+Key=1A1A1A1A1A1A1A1A1A1A1A1A1A1A1A1A
+
+[LocalSignatureKey]
+Key=3C3C3C3C3C3C3C3C3C3C3C3C3C3C3C3C
+Counter=0
+Authenticated=false
+
+[RemoteSignatureKey]
+Key=2B2B2B2B2B2B2B2B2B2B2B2B2B2B2B2B
+Counter=0
+Authenticated=false
+
+[LongTermKey]
+Key=4D4D4D4D4D4D4D4D4D4D4D4D4D4D4D4D
+Authenticated=0
+EncSize=16
+EDiv=4660
+Rand=72623859790382856
+`,
+		// LE Secure Connections bond
+		"AA:BB:CC:DD:EE:FF": `[General]
+AddressType=public
+
+[PeripheralLongTermKey]
+Key=00112233445566778899AABBCCDDEEFF
+Authenticated=2
+EncSize=16
+EDiv=0
+Rand=0
+`,
+		// BR/EDR only
+		"01:02:03:04:05:06": `[LinkKey]
+Key=00112233445566778899AABBCCDDEEFF
+Type=4
+PINLength=0
+`,
+	}
+	for addr, info := range devices {
+		if err := os.Mkdir(filepath.Join(dir, addr), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, addr, "info"), []byte(info), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "cache"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	bm := NewBondManager(filepath.Join(dir, "bonds.json"))
+	n, err := ImportBlueZ(bm, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("imported %d bonds, expected 2", n)
+	}
+	bi, err := bm.Find("665544332211")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := bi.Identity(); id == nil || !bytes.Equal(id.Addr, []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}) || id.AddrType != 1 {
+		t.Errorf("identity %+v", id)
+	}
+
+	bi, err = bm.Find("ffeeddccbbaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xAA, 0xBB, 0xCC, 0xDD, 0xEE, 0xFF}
+	if bi.Legacy() || !bytes.Equal(bi.LongTermKey(), exp) || bi.Identity() != nil || bi.Signing() != nil {
+		t.Errorf("bond ltk %X, legacy %v, identity %+v, signing keys %+v", bi.LongTermKey(), bi.Legacy(), bi.Identity(), bi.Signing())
+	}
+}
@@ -1,12 +1,17 @@
 package bond
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/leso-kn/ble"
@@ -15,21 +20,39 @@ import (
 
 type manager struct {
 	filePath string
+	aead     cipher.AEAD // Encrypts the bonds at rest, if set.
 	lock     sync.RWMutex
 	ble.Logger
 }
 
+// bondFile is the content of the bond file. Version 1 files hold the bonds
+// alone, keyed by address; they're written in the current format on the
+// next change.
+type bondFile struct {
+	Version   int                 `json:"version"`
+	Bonds     map[string]bondData `json:"bonds,omitempty"`
+	Encrypted []byte              `json:"encrypted,omitempty"` // Nonce and AES-GCM sealed bonds.
+}
+
 type bondData struct {
 	LongTermKey           string `json:"longTermKey"`
 	EncryptionDiversifier string `json:"encryptionDiversifier"`
 	RandomValue           string `json:"randomValue"`
 	Legacy                bool   `json:"legacy"`
+	IdentityResolvingKey  string `json:"identityResolvingKey,omitempty"`
+	IdentityAddress       string `json:"identityAddress,omitempty"`
+	IdentityAddressType   uint8  `json:"identityAddressType,omitempty"`
+	RemoteCSRK            string `json:"remoteCSRK,omitempty"`
+	LocalCSRK             string `json:"localCSRK,omitempty"`
+	RemoteSignCounter     uint32 `json:"remoteSignCounter,omitempty"`
 }
 
 const (
 	defaultBondFilename = "bonds.json"
+	bondFileVersion     = 2
 )
 
+// NewBondManager returns a BondManager which persists bonds to a JSON file.
 func NewBondManager(bondFilePath string) hci.BondManager {
 	if len(bondFilePath) == 0 {
 		bondFilePath = defaultBondFilename
@@ -40,6 +63,23 @@ func NewBondManager(bondFilePath string) hci.BondManager {
 	}
 }
 
+// NewEncryptedBondManager is like NewBondManager, but encrypts the bonds with
+// AES-GCM using key, which must be 16, 24 or 32 bytes long. Bonds of an
+// unencrypted file are encrypted on the next change.
+func NewEncryptedBondManager(bondFilePath string, key []byte) (hci.BondManager, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bond file key: %v", err)
+	}
+	aead, err := cipher.NewGCM(c)
+	if err != nil {
+		return nil, err
+	}
+	m := NewBondManager(bondFilePath).(*manager)
+	m.aead = aead
+	return m, nil
+}
+
 //todo: is this function really needed?
 func (m *manager) Exists(addr string) bool {
 	if len(addr) != 12 {
@@ -125,6 +165,29 @@ func (m *manager) Save(addr string, bond hci.BondInfo) error {
 	return m.storeBonds(bonds)
 }
 
+// List returns all valid bonds, keyed by address.
+func (m *manager) List() (map[string]hci.BondInfo, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	bonds, err := m.loadBonds()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]hci.BondInfo, len(bonds))
+	for addr, bd := range bonds {
+		bi, err := createBondInfo(bd)
+		if err != nil {
+			m.Warnf("bondManager: skipping invalid bond for %s: %v", addr, err)
+			continue
+		}
+		out[addr] = bi
+	}
+
+	return out, nil
+}
+
 func (m *manager) Delete(addr string) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -148,47 +211,151 @@ func (m *manager) Delete(addr string) error {
 	return nil
 }
 
-//this is mutex protected at the public function level
-func (m *manager) loadBonds() (map[string]bondData, error) {
-	//open local file
-	_, err := os.Stat(m.filePath)
-	var f *os.File
-	if os.IsNotExist(err) {
-		f, err = os.Create(m.filePath)
-		if err != nil {
-			return nil, fmt.Errorf("unable to create bondData file: %s", err)
+// DeleteAll deletes all bonds.
+func (m *manager) DeleteAll() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.storeBonds(make(map[string]bondData))
+}
+
+// Export writes all bonds to w, unencrypted, in the current bond file format.
+func (m *manager) Export(w io.Writer) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	bonds, err := m.loadBonds()
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(bondFile{Version: bondFileVersion, Bonds: bonds})
+}
+
+// Import adds the bonds of an exported or unencrypted bond file, replacing
+// those with the same address. Nothing is imported if any bond is invalid.
+func (m *manager) Import(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	in, err := m.decodeBonds(data)
+	if err != nil {
+		return err
+	}
+	for addr, bd := range in {
+		if len(addr) != 12 {
+			return fmt.Errorf("invalid address: %s", addr)
+		}
+		if _, err := createBondInfo(bd); err != nil {
+			return fmt.Errorf("invalid bondData for %s: %v", addr, err)
 		}
-		_ = f.Close()
 	}
 
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	bonds, err := m.loadBonds()
+	if err != nil {
+		return err
+	}
+	for addr, bd := range in {
+		bonds[addr] = bd
+	}
+
+	return m.storeBonds(bonds)
+}
+
+//this is mutex protected at the public function level
+func (m *manager) loadBonds() (map[string]bondData, error) {
 	fileData, err := ioutil.ReadFile(m.filePath)
+	if os.IsNotExist(err) {
+		return make(map[string]bondData), nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read bondData file information: %s", err)
 	}
 
+	bonds, err := m.decodeBonds(fileData)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bonds) == 0 {
+		bonds = make(map[string]bondData)
+	}
+
+	return bonds, nil
+}
+
+func (m *manager) decodeBonds(fileData []byte) (map[string]bondData, error) {
+	if len(fileData) == 0 {
+		return nil, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(fileData, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal current bondData info: %s", err)
+	}
+
 	var bonds map[string]bondData
-	if len(fileData) > 0 {
-		err = json.Unmarshal(fileData, &bonds)
-		if err != nil {
+	if _, ok := fields["version"]; !ok {
+		// Version 1
+		if err := json.Unmarshal(fileData, &bonds); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal current bondData info: %s", err)
 		}
+		return bonds, nil
 	}
 
-	if len(bonds) == 0 {
-		bonds = make(map[string]bondData)
+	var bf bondFile
+	if err := json.Unmarshal(fileData, &bf); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal current bondData info: %s", err)
+	}
+	if bf.Version > bondFileVersion {
+		return nil, fmt.Errorf("unsupported bondData file version %d", bf.Version)
+	}
+	if bf.Encrypted == nil {
+		return bf.Bonds, nil
 	}
 
+	if m.aead == nil {
+		return nil, fmt.Errorf("bondData file is encrypted, but no key is set")
+	}
+	ns := m.aead.NonceSize()
+	if len(bf.Encrypted) < ns {
+		return nil, fmt.Errorf("invalid encrypted bondData")
+	}
+	plain, err := m.aead.Open(nil, bf.Encrypted[:ns], bf.Encrypted[ns:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt bondData: %s", err)
+	}
+	if err := json.Unmarshal(plain, &bonds); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal current bondData info: %s", err)
+	}
 	return bonds, nil
 }
 
 //this is mutex protected at the public function level
 func (m *manager) storeBonds(bonds map[string]bondData) error {
-	out, err := json.Marshal(bonds)
+	bf := bondFile{Version: bondFileVersion, Bonds: bonds}
+	if m.aead != nil {
+		plain, err := json.Marshal(bonds)
+		if err != nil {
+			return fmt.Errorf("failed to marshal bonds to json: %s", err)
+		}
+		nonce := make([]byte, m.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		bf.Bonds, bf.Encrypted = nil, m.aead.Seal(nonce, nonce, plain, nil)
+	}
+
+	out, err := json.Marshal(bf)
 	if err != nil {
 		return fmt.Errorf("failed to marshal bonds to json: %s", err)
 	}
 
-	err = ioutil.WriteFile(m.filePath, out, 0644)
+	err = writeFileAtomic(m.filePath, out, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to update bondData information: %s", err)
 	}
@@ -196,6 +363,33 @@ func (m *manager) storeBonds(bonds map[string]bondData) error {
 	return nil
 }
 
+// writeFileAtomic writes data to a temporary file, which then replaces the
+// named file, so a crash doesn't leave a partially written file behind.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
 //bondData is a local structure
 func createBondData(bi hci.BondInfo) bondData {
 	b := bondData{}
@@ -212,6 +406,18 @@ func createBondData(bi hci.BondInfo) bondData {
 	b.RandomValue = hex.EncodeToString(randVal)
 	b.Legacy = bi.Legacy()
 
+	if id := bi.Identity(); id != nil {
+		b.IdentityResolvingKey = hex.EncodeToString(id.IRK)
+		b.IdentityAddress = hex.EncodeToString(id.Addr)
+		b.IdentityAddressType = id.AddrType
+	}
+
+	if sk := bi.Signing(); sk != nil {
+		b.RemoteCSRK = hex.EncodeToString(sk.RemoteCSRK)
+		b.LocalCSRK = hex.EncodeToString(sk.LocalCSRK)
+		b.RemoteSignCounter = sk.RemoteSignCounter
+	}
+
 	return b
 }
 
@@ -236,6 +442,32 @@ func createBondInfo(b bondData) (hci.BondInfo, error) {
 		return nil, fmt.Errorf("invalid random value in bondData file")
 	}
 
-	bi := hci.NewBondInfo(ltk, binary.LittleEndian.Uint16(eDiv), binary.LittleEndian.Uint64(randVal), b.Legacy)
+	var id *hci.Identity
+	if len(b.IdentityResolvingKey) > 0 {
+		irk, err := hex.DecodeString(b.IdentityResolvingKey)
+		if err != nil || len(irk) != 16 {
+			return nil, fmt.Errorf("invalid identity resolving key in bondData file")
+		}
+		idAddr, err := hex.DecodeString(b.IdentityAddress)
+		if err != nil || len(idAddr) != 6 {
+			return nil, fmt.Errorf("invalid identity address in bondData file")
+		}
+		id = &hci.Identity{IRK: irk, Addr: idAddr, AddrType: b.IdentityAddressType}
+	}
+
+	var sk *hci.SigningKeys
+	if len(b.RemoteCSRK) > 0 || len(b.LocalCSRK) > 0 {
+		remote, err := hex.DecodeString(b.RemoteCSRK)
+		if err != nil || len(remote) != 0 && len(remote) != 16 {
+			return nil, fmt.Errorf("invalid remote csrk in bondData file")
+		}
+		local, err := hex.DecodeString(b.LocalCSRK)
+		if err != nil || len(local) != 0 && len(local) != 16 {
+			return nil, fmt.Errorf("invalid local csrk in bondData file")
+		}
+		sk = &hci.SigningKeys{RemoteCSRK: remote, LocalCSRK: local, RemoteSignCounter: b.RemoteSignCounter}
+	}
+
+	bi := hci.NewBondInfoWithKeys(ltk, binary.LittleEndian.Uint16(eDiv), binary.LittleEndian.Uint64(randVal), b.Legacy, id, sk)
 	return bi, nil
 }
@@ -0,0 +1,158 @@
+package bond
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/leso-kn/ble/linux/hci"
+)
+
+const testAddr = "112233445566"
+
+func testBond() hci.BondInfo {
+	id := &hci.Identity{IRK: bytes.Repeat([]byte{0x1A}, 16), Addr: []byte{1, 2, 3, 4, 5, 6}, AddrType: 1}
+	sk := &hci.SigningKeys{RemoteCSRK: bytes.Repeat([]byte{0x2B}, 16), LocalCSRK: bytes.Repeat([]byte{0x3C}, 16)}
+	return hci.NewBondInfoWithKeys(bytes.Repeat([]byte{0x4D}, 16), 0x1234, 0x0102030405060708, true, id, sk)
+}
+
+func checkBond(t *testing.T, bm hci.BondManager) {
+	t.Helper()
+	bi, err := bm.Find(testAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := testBond()
+	if !bytes.Equal(bi.LongTermKey(), exp.LongTermKey()) || bi.EDiv() != exp.EDiv() || bi.Random() != exp.Random() {
+		t.Errorf("ltk %X %X %X, expected %X %X %X", bi.LongTermKey(), bi.EDiv(), bi.Random(),
+			exp.LongTermKey(), exp.EDiv(), exp.Random())
+	}
+	if id := bi.Identity(); id == nil || !bytes.Equal(id.IRK, exp.Identity().IRK) {
+		t.Errorf("identity %+v", id)
+	}
+	if sk := bi.Signing(); sk == nil || !bytes.Equal(sk.LocalCSRK, exp.Signing().LocalCSRK) {
+		t.Errorf("signing keys %+v", sk)
+	}
+}
+
+func TestEncryptedBondManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bonds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bonds.json")
+
+	key := bytes.Repeat([]byte{0x5E}, 32)
+	bm, err := NewEncryptedBondManager(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.Save(testAddr, testBond()); err != nil {
+		t.Fatal(err)
+	}
+	checkBond(t, bm)
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), testAddr) {
+		t.Errorf("bond file isn't encrypted: %s", b)
+	}
+
+	if _, err := NewBondManager(path).Find(testAddr); err == nil {
+		t.Error("found bond without key")
+	}
+	bm, _ = NewEncryptedBondManager(path, bytes.Repeat([]byte{0x6F}, 32))
+	if _, err := bm.Find(testAddr); err == nil {
+		t.Error("found bond with wrong key")
+	}
+	if _, err := NewEncryptedBondManager(path, key[:10]); err == nil {
+		t.Error("accepted invalid key")
+	}
+}
+
+func TestBondFileMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bonds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bonds.json")
+
+	v1 := `{"112233445566":{"longTermKey":"4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d4d",` +
+		`"encryptionDiversifier":"3412","randomValue":"0807060504030201","legacy":true,` +
+		`"identityResolvingKey":"1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a1a","identityAddress":"010203040506",` +
+		`"identityAddressType":1,"remoteCSRK":"2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b2b",` +
+		`"localCSRK":"3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c3c"}}`
+	if err := ioutil.WriteFile(path, []byte(v1), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	bm, err := NewEncryptedBondManager(path, bytes.Repeat([]byte{0x5E}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBond(t, bm)
+
+	// Bonds are written in the current format, encrypted, on the next change.
+	if err := bm.Save("aabbccddeeff", testBond()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"version":2`) || strings.Contains(string(b), testAddr) {
+		t.Errorf("bond file not migrated: %s", b)
+	}
+	checkBond(t, bm)
+}
+
+func TestExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bonds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := NewEncryptedBondManager(filepath.Join(dir, "src.json"), bytes.Repeat([]byte{0x5E}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Save(testAddr, testBond()); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := src.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewBondManager(filepath.Join(dir, "dst.json"))
+	if err := dst.Save("aabbccddeeff", testBond()); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Import(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	checkBond(t, dst)
+	if bonds, err := dst.List(); err != nil || len(bonds) != 2 {
+		t.Errorf("bonds %v, %v", bonds, err)
+	}
+
+	if err := dst.Import(strings.NewReader(`{"version":2,"bonds":{"112233445566":{"longTermKey":"zz"}}}`)); err == nil {
+		t.Error("imported invalid bond")
+	}
+	checkBond(t, dst)
+
+	if err := dst.DeleteAll(); err != nil {
+		t.Fatal(err)
+	}
+	if bonds, err := dst.List(); err != nil || len(bonds) != 0 {
+		t.Errorf("bonds %v, %v", bonds, err)
+	}
+}
@@ -2,17 +2,35 @@ package hci
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"sync"
+	"time"
 )
 
-// Pool ...
+// ErrACLBufferFull is returned by writes when no controller buffer became
+// available within the ACL write timeout.
+var ErrACLBufferFull = errors.New("controller acl buffers full")
+
+// Pool tracks the controller's ACL data buffers shared by all connections.
+// Each buffer corresponds to a credit, which is returned to the pool when the
+// controller reports the packet completed [Vol 2, Part E, 4.1.1].
 type Pool struct {
-	sync.Mutex
+	mu   sync.Mutex
+	sz   int
+	cnt  int
+	free []*bytes.Buffer
+
+	// clients is the number of connections sharing the pool.
+	clients int
+
+	// waiters are the clients waiting for buffers, in the order they
+	// started waiting, which they're handed out in.
+	waiters []*Client
 
-	sz  int
-	cnt int
-	ch  chan *bytes.Buffer
+	// wake is closed and replaced whenever buffers are returned.
+	wake chan struct{}
 }
 
 // NewPool ...
@@ -20,59 +38,180 @@ func NewPool(sz int, cnt int) (*Pool, error) {
 	if cnt <= 0 {
 		return nil, fmt.Errorf("invalid buffer size %v", cnt)
 	}
-	ch := make(chan *bytes.Buffer, cnt)
-	for len(ch) < cnt {
-		ch <- bytes.NewBuffer(make([]byte, sz))
+	free := make([]*bytes.Buffer, 0, cnt)
+	for len(free) < cnt {
+		free = append(free, bytes.NewBuffer(make([]byte, sz)))
+	}
+	return &Pool{sz: sz, cnt: cnt, free: free, wake: make(chan struct{})}, nil
+}
+
+// quota returns the number of buffers a single connection may occupy, so a
+// connection whose packets don't complete can't starve the others.
+// Must be called with p.mu held.
+func (p *Pool) quota() int {
+	if p.clients <= 1 {
+		return p.cnt
+	}
+	if q := p.cnt / p.clients; q > 1 {
+		return q
+	}
+	return 1
+}
+
+// turn reports whether c may take a free buffer: it's under its quota, and no
+// client which waits for longer is. Must be called with p.mu held.
+func (p *Pool) turn(c *Client) bool {
+	if len(c.sent) >= p.quota() {
+		return false
+	}
+	for _, w := range p.waiters {
+		if w == c {
+			return true
+		}
+		if len(w.sent) < p.quota() {
+			return false
+		}
+	}
+	return true
+}
+
+// wait queues c as a waiter, unless it's queued already. Must be called with
+// p.mu held.
+func (p *Pool) wait(c *Client) {
+	for _, w := range p.waiters {
+		if w == c {
+			return
+		}
+	}
+	p.waiters = append(p.waiters, c)
+}
+
+// unwait removes c from the waiters, and wakes up the next ones. Must be
+// called with p.mu held.
+func (p *Pool) unwait(c *Client) {
+	for i, w := range p.waiters {
+		if w == c {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			p.broadcast()
+			return
+		}
 	}
-	return &Pool{sz: sz, cnt: cnt, ch: ch}, nil
 }
 
-// Client ...
+// broadcast wakes up the clients waiting for buffers. Must be called with p.mu held.
+func (p *Pool) broadcast() {
+	close(p.wake)
+	p.wake = make(chan struct{})
+}
+
+// Client tracks the buffers occupied by a connection.
 type Client struct {
-	p    *Pool
-	sent chan *bytes.Buffer
+	p      *Pool
+	sent   []*bytes.Buffer
+	closed bool
 }
 
 // NewClient ...
 func NewClient(p *Pool) *Client {
-	return &Client{p: p, sent: make(chan *bytes.Buffer, p.cnt)}
+	p.mu.Lock()
+	p.clients++
+	p.mu.Unlock()
+	return &Client{p: p}
 }
 
-// LockPool ...
-func (c *Client) LockPool() {
-	c.p.Lock()
-}
+// Get returns a buffer from the shared buffer pool. If none is available to
+// the client, it waits up to timeout, or until done is closed. A zero timeout
+// waits indefinitely, and a negative one fails immediately. The clients
+// waiting get the buffers returned in turn, so a busy connection can't keep
+// the others waiting.
+func (c *Client) Get(timeout time.Duration, done <-chan struct{}) (*bytes.Buffer, error) {
+	p := c.p
+	var tmo <-chan time.Time
+	for {
+		p.mu.Lock()
+		if c.closed {
+			p.mu.Unlock()
+			return nil, io.ErrClosedPipe
+		}
+		if len(p.free) > 0 && p.turn(c) {
+			b := p.free[len(p.free)-1]
+			p.free = p.free[:len(p.free)-1]
+			c.sent = append(c.sent, b)
+			p.unwait(c)
+			p.mu.Unlock()
+			b.Reset()
+			return b, nil
+		}
+		if timeout < 0 {
+			p.mu.Unlock()
+			return nil, ErrACLBufferFull
+		}
+		p.wait(c)
+		wake := p.wake
+		p.mu.Unlock()
 
-// UnlockPool ...
-func (c *Client) UnlockPool() {
-	c.p.Unlock()
+		if timeout > 0 && tmo == nil {
+			tmo = time.After(timeout)
+		}
+		select {
+		case <-wake:
+		case <-done:
+			c.stopWaiting()
+			return nil, io.ErrClosedPipe
+		case <-tmo:
+			c.stopWaiting()
+			return nil, ErrACLBufferFull
+		}
+	}
 }
 
-// Get returns a buffer from the shared buffer pool.
-func (c *Client) Get() *bytes.Buffer {
-	b := <-c.p.ch
-	b.Reset()
-	c.sent <- b
-	return b
+// stopWaiting removes c from the waiters, as it gave up.
+func (c *Client) stopWaiting() {
+	c.p.mu.Lock()
+	c.p.unwait(c)
+	c.p.mu.Unlock()
 }
 
 // Put puts the oldest sent buffer back to the shared pool.
 func (c *Client) Put() {
-	select {
-	case b := <-c.sent:
-		c.p.ch <- b
-	default:
+	p := c.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(c.sent) == 0 {
+		return
 	}
+	p.free = append(p.free, c.sent[0])
+	c.sent = c.sent[1:]
+	p.broadcast()
 }
 
-// PutAll puts all the sent buffers back to the shared pool.
+// PutAll puts all the sent buffers back to the shared pool, and releases the
+// client's share of the pool. Subsequent calls to Get fail.
 func (c *Client) PutAll() {
-	for {
-		select {
-		case b := <-c.sent:
-			c.p.ch <- b
-		default:
-			return
-		}
+	p := c.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.free = append(p.free, c.sent...)
+	c.sent = nil
+	if !c.closed {
+		c.closed = true
+		p.clients--
 	}
+	p.unwait(c)
+	p.broadcast()
+}
+
+// Quota returns the number of buffers the client may occupy at once, its
+// share of the pool, and the size of their payloads.
+func (c *Client) Quota() (n int, size int) {
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+	return c.p.quota(), c.p.sz - 1 - 4
+}
+
+// InFlight returns the number of buffers occupied by the client.
+func (c *Client) InFlight() int {
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+	return len(c.sent)
 }
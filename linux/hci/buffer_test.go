@@ -0,0 +1,124 @@
+package hci
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPoolQuota(t *testing.T) {
+	p, err := NewPool(32, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := NewClient(p), NewClient(p)
+
+	// Each of the two clients may occupy half of the buffers.
+	for i := 0; i < 2; i++ {
+		if _, err := a.Get(-1, nil); err != nil {
+			t.Fatalf("get %d: %v", i, err)
+		}
+	}
+	if _, err := a.Get(-1, nil); err != ErrACLBufferFull {
+		t.Fatalf("expected ErrACLBufferFull over quota, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := b.Get(-1, nil); err != nil {
+			t.Fatalf("stalled client starved the other one: %v", err)
+		}
+	}
+
+	a.Put()
+	if _, err := a.Get(-1, nil); err != nil {
+		t.Fatalf("get after put: %v", err)
+	}
+}
+
+func TestPoolGetWaits(t *testing.T) {
+	p, err := NewPool(32, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(p)
+	if _, err := c.Get(0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Get(10*time.Millisecond, nil); err != ErrACLBufferFull {
+		t.Fatalf("expected ErrACLBufferFull on timeout, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.Put()
+	}()
+	if _, err := c.Get(0, nil); err != nil {
+		t.Fatalf("blocking get: %v", err)
+	}
+
+	done := make(chan struct{})
+	close(done)
+	if _, err := c.Get(0, done); err != io.ErrClosedPipe {
+		t.Fatalf("expected io.ErrClosedPipe when done, got %v", err)
+	}
+}
+
+func TestPoolPutAll(t *testing.T) {
+	p, err := NewPool(32, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := NewClient(p), NewClient(p)
+	if _, err := a.Get(-1, nil); err != nil {
+		t.Fatal(err)
+	}
+	a.PutAll()
+	if _, err := a.Get(-1, nil); err != io.ErrClosedPipe {
+		t.Fatalf("expected io.ErrClosedPipe after PutAll, got %v", err)
+	}
+
+	// The remaining client gets the whole pool.
+	for i := 0; i < 2; i++ {
+		if _, err := b.Get(-1, nil); err != nil {
+			t.Fatalf("get %d: %v", i, err)
+		}
+	}
+}
+
+func TestPoolTurns(t *testing.T) {
+	// With more clients than buffers, each may occupy one.
+	p, err := NewPool(32, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := NewClient(p), NewClient(p), NewClient(p)
+	if _, err := a.Get(-1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(-1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// c waits for the buffer a returns, so a can't take it back first.
+	got := make(chan error, 1)
+	go func() {
+		_, err := c.Get(0, nil)
+		got <- err
+	}()
+	for {
+		p.mu.Lock()
+		n := len(p.waiters)
+		p.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	a.Put()
+	if _, err := a.Get(-1, nil); err != ErrACLBufferFull {
+		t.Fatalf("expected ErrACLBufferFull ahead of a waiter, got %v", err)
+	}
+	if err := <-got; err != nil {
+		t.Fatal(err)
+	}
+}
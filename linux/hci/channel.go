@@ -0,0 +1,91 @@
+package hci
+
+import (
+	"fmt"
+
+	"github.com/leso-kn/ble/linux/hci/cmd"
+)
+
+// NumDataChannels is the number of LE data channels [Vol 6, Part B, 1.4.1].
+const NumDataChannels = 37
+
+// ChannelMap is a bit field of the LE data channels 0 to 36, with the bit of
+// channel n at position n, least significant byte first. In a host channel
+// classification, a set bit marks the channel as unknown and a cleared bit as
+// bad; in a connection's channel map, a set bit marks the channel as used.
+type ChannelMap [5]byte
+
+// AllChannels returns a map with all data channels set.
+func AllChannels() ChannelMap {
+	return ChannelMap{0xFF, 0xFF, 0xFF, 0xFF, 0x1F}
+}
+
+// Set sets or clears the bit of channel ch.
+func (m *ChannelMap) Set(ch int, set bool) {
+	if ch < 0 || ch >= NumDataChannels {
+		return
+	}
+	if set {
+		m[ch/8] |= 1 << uint(ch%8)
+	} else {
+		m[ch/8] &^= 1 << uint(ch%8)
+	}
+}
+
+// IsSet reports whether the bit of channel ch is set.
+func (m ChannelMap) IsSet(ch int) bool {
+	if ch < 0 || ch >= NumDataChannels {
+		return false
+	}
+	return m[ch/8]&(1<<uint(ch%8)) != 0
+}
+
+// Count returns the number of channels set.
+func (m ChannelMap) Count() int {
+	n := 0
+	for ch := 0; ch < NumDataChannels; ch++ {
+		if m.IsSet(ch) {
+			n++
+		}
+	}
+	return n
+}
+
+// Channels returns the channels set, in ascending order.
+func (m ChannelMap) Channels() []int {
+	var chs []int
+	for ch := 0; ch < NumDataChannels; ch++ {
+		if m.IsSet(ch) {
+			chs = append(chs, ch)
+		}
+	}
+	return chs
+}
+
+// SetHostChannelClassification tells the controller which data channels are
+// known to be bad, e.g. because they overlap with a Wi-Fi network. The
+// controller excludes them from the channel maps of its connections. At
+// least two channels must remain unknown [Vol 2, Part E, 7.8.19].
+func (h *HCI) SetHostChannelClassification(m ChannelMap) error {
+	if m[4]&0xE0 != 0 {
+		return fmt.Errorf("invalid channel map %X: reserved bits set", m)
+	}
+	if m.Count() < 2 {
+		return fmt.Errorf("invalid channel map %X: less than 2 channels", m)
+	}
+	rp := cmd.LESetHostChannelClassificationRP{}
+	if err := h.Send(&cmd.LESetHostChannelClassification{ChannelMap: m}, &rp); err != nil {
+		return fmt.Errorf("failed to set host channel classification: %v", err)
+	}
+	return nil
+}
+
+// ReadChannelMap returns the data channels currently used by the connection
+// [Vol 2, Part E, 7.8.20].
+func (c *Conn) ReadChannelMap() (ChannelMap, error) {
+	rp := cmd.LEReadChannelMapRP{}
+	if err := c.hci.Send(&cmd.LEReadChannelMap{ConnectionHandle: c.param.ConnectionHandle()}, &rp); err != nil {
+		return ChannelMap{}, fmt.Errorf("failed to read channel map: %v", err)
+	}
+	return ChannelMap(rp.ChannelMap), nil
+}
@@ -0,0 +1,31 @@
+package hci
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChannelMap(t *testing.T) {
+	m := AllChannels()
+	if n := m.Count(); n != NumDataChannels {
+		t.Fatalf("AllChannels has %d channels, want %d", n, NumDataChannels)
+	}
+
+	m.Set(0, false)
+	m.Set(12, false)
+	m.Set(36, false)
+	m.Set(37, false) // Out of range, ignored.
+	if m != (ChannelMap{0xFE, 0xEF, 0xFF, 0xFF, 0x0F}) {
+		t.Fatalf("unexpected map % X", m)
+	}
+	if m.IsSet(12) || !m.IsSet(13) {
+		t.Fatal("IsSet doesn't match Set")
+	}
+
+	var few ChannelMap
+	few.Set(3, true)
+	few.Set(9, true)
+	if got := few.Channels(); !reflect.DeepEqual(got, []int{3, 9}) {
+		t.Fatalf("Channels() = %v", got)
+	}
+}
@@ -29,3 +29,240 @@ func unmarshal(c commandRP, b []byte) error {
 	buf := bytes.NewBuffer(b)
 	return binary.Read(buf, binary.LittleEndian, c)
 }
+
+// LEExtendedCreateConnectionPHY holds the scan and connection parameters of an
+// initiating PHY of LE Extended Create Connection.
+type LEExtendedCreateConnectionPHY struct {
+	ScanInterval       uint16 // 0x0004 - 0xFFFF; N * 0.625 msec
+	ScanWindow         uint16 // 0x0004 - 0xFFFF; N * 0.625 msec
+	ConnIntervalMin    uint16 // 0x0006 - 0x0C80; N * 1.25 msec
+	ConnIntervalMax    uint16 // 0x0006 - 0x0C80; N * 1.25 msec
+	ConnLatency        uint16 // 0x0000 - 0x01F3
+	SupervisionTimeout uint16 // 0x000A - 0x0C80; N * 10 msec
+	MinimumCELength    uint16 // 0x0000 - 0xFFFF; N * 0.625 msec
+	MaximumCELength    uint16 // 0x0000 - 0xFFFF; N * 0.625 msec
+}
+
+// LEExtendedCreateConnection implements LE Extended Create Connection (0x08|0x0043) [Vol 2, Part E, 7.8.66]
+type LEExtendedCreateConnection struct {
+	InitiatorFilterPolicy uint8
+	OwnAddressType        uint8
+	PeerAddressType       uint8
+	PeerAddress           [6]byte
+	InitiatingPHYs        uint8
+
+	// PHYs holds the parameters of each PHY set in InitiatingPHYs, in the
+	// order LE 1M, LE 2M, LE Coded.
+	PHYs []LEExtendedCreateConnectionPHY
+}
+
+func (c *LEExtendedCreateConnection) String() string {
+	return "LE Extended Create Connection (0x08|0x0043)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEExtendedCreateConnection) OpCode() int { return 0x08<<10 | 0x0043 }
+
+// Len returns the length of the command.
+func (c *LEExtendedCreateConnection) Len() int { return 10 + 16*len(c.PHYs) }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEExtendedCreateConnection) Marshal(b []byte) error {
+	if len(b) < c.Len() {
+		return io.ErrShortBuffer
+	}
+	b[0] = c.InitiatorFilterPolicy
+	b[1] = c.OwnAddressType
+	b[2] = c.PeerAddressType
+	copy(b[3:9], c.PeerAddress[:])
+	b[9] = c.InitiatingPHYs
+	buf := bytes.NewBuffer(b[10:10])
+	return binary.Write(buf, binary.LittleEndian, c.PHYs)
+}
+
+// LESetCIGParametersCIS holds the parameters of a CIS of LE Set CIG Parameters.
+type LESetCIGParametersCIS struct {
+	CISID      uint8
+	MaxSDUCToP uint16 // 0x0000 - 0x0FFF
+	MaxSDUPToC uint16 // 0x0000 - 0x0FFF
+	PHYCToP    uint8
+	PHYPToC    uint8
+	RTNCToP    uint8
+	RTNPToC    uint8
+}
+
+// LESetCIGParameters implements LE Set CIG Parameters (0x08|0x0062) [Vol 2, Part E, 7.8.97]
+type LESetCIGParameters struct {
+	CIGID                   uint8
+	SDUIntervalCToP         [3]byte // 0x0000FF - 0x0FFFFF; usec
+	SDUIntervalPToC         [3]byte // 0x0000FF - 0x0FFFFF; usec
+	WorstCaseSCA            uint8
+	Packing                 uint8
+	Framing                 uint8
+	MaxTransportLatencyCToP uint16 // 0x0005 - 0x0FA0; msec
+	MaxTransportLatencyPToC uint16 // 0x0005 - 0x0FA0; msec
+	CISs                    []LESetCIGParametersCIS
+}
+
+func (c *LESetCIGParameters) String() string {
+	return "LE Set CIG Parameters (0x08|0x0062)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetCIGParameters) OpCode() int { return 0x08<<10 | 0x0062 }
+
+// Len returns the length of the command.
+func (c *LESetCIGParameters) Len() int { return 15 + 9*len(c.CISs) }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetCIGParameters) Marshal(b []byte) error {
+	if len(b) < c.Len() {
+		return io.ErrShortBuffer
+	}
+	b[0] = c.CIGID
+	copy(b[1:4], c.SDUIntervalCToP[:])
+	copy(b[4:7], c.SDUIntervalPToC[:])
+	b[7] = c.WorstCaseSCA
+	b[8] = c.Packing
+	b[9] = c.Framing
+	binary.LittleEndian.PutUint16(b[10:], c.MaxTransportLatencyCToP)
+	binary.LittleEndian.PutUint16(b[12:], c.MaxTransportLatencyPToC)
+	b[14] = uint8(len(c.CISs))
+	buf := bytes.NewBuffer(b[15:15])
+	return binary.Write(buf, binary.LittleEndian, c.CISs)
+}
+
+// LESetCIGParametersRP returns the return parameter of LE Set CIG Parameters
+type LESetCIGParametersRP struct {
+	Status            uint8
+	CIGID             uint8
+	ConnectionHandles []uint16
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetCIGParametersRP) Unmarshal(b []byte) error {
+	if len(b) < 3 || len(b) < 3+2*int(b[2]) {
+		return io.ErrUnexpectedEOF
+	}
+	c.Status = b[0]
+	c.CIGID = b[1]
+	c.ConnectionHandles = make([]uint16, b[2])
+	for i := range c.ConnectionHandles {
+		c.ConnectionHandles[i] = binary.LittleEndian.Uint16(b[3+2*i:])
+	}
+	return nil
+}
+
+// LECreateCISPair pairs a CIS with the ACL connection it is established over.
+type LECreateCISPair struct {
+	CISConnectionHandle uint16
+	ACLConnectionHandle uint16
+}
+
+// LECreateCIS implements LE Create CIS (0x08|0x0064) [Vol 2, Part E, 7.8.99]
+type LECreateCIS struct {
+	CISs []LECreateCISPair
+}
+
+func (c *LECreateCIS) String() string {
+	return "LE Create CIS (0x08|0x0064)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LECreateCIS) OpCode() int { return 0x08<<10 | 0x0064 }
+
+// Len returns the length of the command.
+func (c *LECreateCIS) Len() int { return 1 + 4*len(c.CISs) }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LECreateCIS) Marshal(b []byte) error {
+	if len(b) < c.Len() {
+		return io.ErrShortBuffer
+	}
+	b[0] = uint8(len(c.CISs))
+	buf := bytes.NewBuffer(b[1:1])
+	return binary.Write(buf, binary.LittleEndian, c.CISs)
+}
+
+// LEBIGCreateSync implements LE BIG Create Sync (0x08|0x006B) [Vol 2, Part E, 7.8.106]
+type LEBIGCreateSync struct {
+	BIGHandle      uint8
+	SyncHandle     uint16
+	Encryption     uint8
+	BroadcastCode  [16]byte
+	MSE            uint8
+	BIGSyncTimeout uint16 // 0x000A - 0x4000; N * 10 msec
+	BISs           []uint8
+}
+
+func (c *LEBIGCreateSync) String() string {
+	return "LE BIG Create Sync (0x08|0x006B)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEBIGCreateSync) OpCode() int { return 0x08<<10 | 0x006B }
+
+// Len returns the length of the command.
+func (c *LEBIGCreateSync) Len() int { return 24 + len(c.BISs) }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEBIGCreateSync) Marshal(b []byte) error {
+	if len(b) < c.Len() {
+		return io.ErrShortBuffer
+	}
+	b[0] = c.BIGHandle
+	binary.LittleEndian.PutUint16(b[1:], c.SyncHandle)
+	b[3] = c.Encryption
+	copy(b[4:20], c.BroadcastCode[:])
+	b[20] = c.MSE
+	binary.LittleEndian.PutUint16(b[21:], c.BIGSyncTimeout)
+	b[23] = uint8(len(c.BISs))
+	copy(b[24:], c.BISs)
+	return nil
+}
+
+// LESetupISODataPath implements LE Setup ISO Data Path (0x08|0x006E) [Vol 2, Part E, 7.8.109]
+type LESetupISODataPath struct {
+	ConnectionHandle   uint16
+	DataPathDirection  uint8
+	DataPathID         uint8
+	CodecID            [5]byte
+	ControllerDelay    [3]byte // usec
+	CodecConfiguration []byte
+}
+
+func (c *LESetupISODataPath) String() string {
+	return "LE Setup ISO Data Path (0x08|0x006E)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetupISODataPath) OpCode() int { return 0x08<<10 | 0x006E }
+
+// Len returns the length of the command.
+func (c *LESetupISODataPath) Len() int { return 13 + len(c.CodecConfiguration) }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetupISODataPath) Marshal(b []byte) error {
+	if len(b) < c.Len() {
+		return io.ErrShortBuffer
+	}
+	binary.LittleEndian.PutUint16(b, c.ConnectionHandle)
+	b[2] = c.DataPathDirection
+	b[3] = c.DataPathID
+	copy(b[4:9], c.CodecID[:])
+	copy(b[9:12], c.ControllerDelay[:])
+	b[12] = uint8(len(c.CodecConfiguration))
+	copy(b[13:], c.CodecConfiguration)
+	return nil
+}
+
+// LESetupISODataPathRP returns the return parameter of LE Setup ISO Data Path
+type LESetupISODataPathRP struct {
+	Status           uint8
+	ConnectionHandle uint16
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetupISODataPathRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
@@ -213,8 +213,9 @@ func (c *ReadTransmitPowerLevel) Marshal(b []byte) error {
 
 // ReadTransmitPowerLevelRP returns the return parameter of Read Transmit Power Level
 type ReadTransmitPowerLevelRP struct {
-	Status           uint8
-	ConnectionHandle uint16
+	Status             uint8
+	ConnectionHandle   uint16
+	TransmitPowerLevel int8
 }
 
 // Unmarshal de-serializes the binary data and stores the result in the receiver.
@@ -302,6 +303,36 @@ func (c *SetEventMaskPage2RP) Unmarshal(b []byte) error {
 	return unmarshal(c, b)
 }
 
+// WriteScanEnable implements Write Scan Enable (0x03|0x001A) [Vol 2, Part E, 7.3.18]
+type WriteScanEnable struct {
+	ScanEnable uint8
+}
+
+func (c *WriteScanEnable) String() string {
+	return "Write Scan Enable (0x03|0x001A)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *WriteScanEnable) OpCode() int { return 0x03<<10 | 0x001A }
+
+// Len returns the length of the command.
+func (c *WriteScanEnable) Len() int { return 1 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *WriteScanEnable) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// WriteScanEnableRP returns the return parameter of Write Scan Enable
+type WriteScanEnableRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *WriteScanEnableRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
 // WriteLEHostSupport implements Write LE Host Support (0x03|0x006D) [Vol 2, Part E, 7.3.79]
 type WriteLEHostSupport struct {
 	LESupportedHost    uint8
@@ -365,18 +396,18 @@ func (c *ReadAuthenticatedPayloadTimeoutRP) Unmarshal(b []byte) error {
 	return unmarshal(c, b)
 }
 
-// WriteAuthenticatedPayloadTimeout implements Write Authenticated Payload Timeout (0x01|0x007C) [Vol 2, Part E, 7.3.94]
+// WriteAuthenticatedPayloadTimeout implements Write Authenticated Payload Timeout (0x03|0x007C) [Vol 2, Part E, 7.3.94]
 type WriteAuthenticatedPayloadTimeout struct {
 	ConnectionHandle            uint16
 	AuthenticatedPayloadTimeout uint16
 }
 
 func (c *WriteAuthenticatedPayloadTimeout) String() string {
-	return "Write Authenticated Payload Timeout (0x01|0x007C)"
+	return "Write Authenticated Payload Timeout (0x03|0x007C)"
 }
 
 // OpCode returns the opcode of the command.
-func (c *WriteAuthenticatedPayloadTimeout) OpCode() int { return 0x01<<10 | 0x007C }
+func (c *WriteAuthenticatedPayloadTimeout) OpCode() int { return 0x03<<10 | 0x007C }
 
 // Len returns the length of the command.
 func (c *WriteAuthenticatedPayloadTimeout) Len() int { return 4 }
@@ -586,6 +617,38 @@ func (c *ReadRSSIRP) Unmarshal(b []byte) error {
 	return unmarshal(c, b)
 }
 
+// ReadEncryptionKeySize implements Read Encryption Key Size (0x05|0x0008) [Vol 2, Part E, 7.5.7]
+type ReadEncryptionKeySize struct {
+	ConnectionHandle uint16
+}
+
+func (c *ReadEncryptionKeySize) String() string {
+	return "Read Encryption Key Size (0x05|0x0008)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *ReadEncryptionKeySize) OpCode() int { return 0x05<<10 | 0x0008 }
+
+// Len returns the length of the command.
+func (c *ReadEncryptionKeySize) Len() int { return 2 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *ReadEncryptionKeySize) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// ReadEncryptionKeySizeRP returns the return parameter of Read Encryption Key Size
+type ReadEncryptionKeySizeRP struct {
+	Status           uint8
+	ConnectionHandle uint16
+	KeySize          uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *ReadEncryptionKeySizeRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
 // LESetEventMask implements LE Set Event Mask (0x08|0x0001) [Vol 2, Part E, 7.8.1]
 type LESetEventMask struct {
 	LEEventMask uint64
@@ -1591,3 +1654,620 @@ type LEWriteSuggestedDefaultDataLengthRP struct {
 func (c *LEWriteSuggestedDefaultDataLengthRP) Unmarshal(b []byte) error {
 	return unmarshal(c, b)
 }
+
+// LEAddDeviceToResolvingList implements LE Add Device To Resolving List (0x08|0x0027) [Vol 2, Part E, 7.8.38]
+type LEAddDeviceToResolvingList struct {
+	PeerIdentityAddressType uint8
+	PeerIdentityAddress     [6]byte
+	PeerIRK                 [16]byte
+	LocalIRK                [16]byte
+}
+
+func (c *LEAddDeviceToResolvingList) String() string {
+	return "LE Add Device To Resolving List (0x08|0x0027)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEAddDeviceToResolvingList) OpCode() int { return 0x08<<10 | 0x0027 }
+
+// Len returns the length of the command.
+func (c *LEAddDeviceToResolvingList) Len() int { return 39 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEAddDeviceToResolvingList) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LEAddDeviceToResolvingListRP returns the return parameter of LE Add Device To Resolving List
+type LEAddDeviceToResolvingListRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LEAddDeviceToResolvingListRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LERemoveDeviceFromResolvingList implements LE Remove Device From Resolving List (0x08|0x0028) [Vol 2, Part E, 7.8.39]
+type LERemoveDeviceFromResolvingList struct {
+	PeerIdentityAddressType uint8
+	PeerIdentityAddress     [6]byte
+}
+
+func (c *LERemoveDeviceFromResolvingList) String() string {
+	return "LE Remove Device From Resolving List (0x08|0x0028)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LERemoveDeviceFromResolvingList) OpCode() int { return 0x08<<10 | 0x0028 }
+
+// Len returns the length of the command.
+func (c *LERemoveDeviceFromResolvingList) Len() int { return 7 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LERemoveDeviceFromResolvingList) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LERemoveDeviceFromResolvingListRP returns the return parameter of LE Remove Device From Resolving List
+type LERemoveDeviceFromResolvingListRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LERemoveDeviceFromResolvingListRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LEClearResolvingList implements LE Clear Resolving List (0x08|0x0029) [Vol 2, Part E, 7.8.40]
+type LEClearResolvingList struct {
+}
+
+func (c *LEClearResolvingList) String() string {
+	return "LE Clear Resolving List (0x08|0x0029)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEClearResolvingList) OpCode() int { return 0x08<<10 | 0x0029 }
+
+// Len returns the length of the command.
+func (c *LEClearResolvingList) Len() int { return 0 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEClearResolvingList) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LEClearResolvingListRP returns the return parameter of LE Clear Resolving List
+type LEClearResolvingListRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LEClearResolvingListRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LEReadResolvingListSize implements LE Read Resolving List Size (0x08|0x002A) [Vol 2, Part E, 7.8.41]
+type LEReadResolvingListSize struct {
+}
+
+func (c *LEReadResolvingListSize) String() string {
+	return "LE Read Resolving List Size (0x08|0x002A)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEReadResolvingListSize) OpCode() int { return 0x08<<10 | 0x002A }
+
+// Len returns the length of the command.
+func (c *LEReadResolvingListSize) Len() int { return 0 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEReadResolvingListSize) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LEReadResolvingListSizeRP returns the return parameter of LE Read Resolving List Size
+type LEReadResolvingListSizeRP struct {
+	Status            uint8
+	ResolvingListSize uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LEReadResolvingListSizeRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LEReadPeerResolvableAddress implements LE Read Peer Resolvable Address (0x08|0x002B) [Vol 2, Part E, 7.8.42]
+type LEReadPeerResolvableAddress struct {
+	PeerIdentityAddressType uint8
+	PeerIdentityAddress     [6]byte
+}
+
+func (c *LEReadPeerResolvableAddress) String() string {
+	return "LE Read Peer Resolvable Address (0x08|0x002B)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEReadPeerResolvableAddress) OpCode() int { return 0x08<<10 | 0x002B }
+
+// Len returns the length of the command.
+func (c *LEReadPeerResolvableAddress) Len() int { return 7 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEReadPeerResolvableAddress) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LEReadPeerResolvableAddressRP returns the return parameter of LE Read Peer Resolvable Address
+type LEReadPeerResolvableAddressRP struct {
+	Status                uint8
+	PeerResolvableAddress [6]byte
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LEReadPeerResolvableAddressRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LEReadLocalResolvableAddress implements LE Read Local Resolvable Address (0x08|0x002C) [Vol 2, Part E, 7.8.43]
+type LEReadLocalResolvableAddress struct {
+	PeerIdentityAddressType uint8
+	PeerIdentityAddress     [6]byte
+}
+
+func (c *LEReadLocalResolvableAddress) String() string {
+	return "LE Read Local Resolvable Address (0x08|0x002C)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEReadLocalResolvableAddress) OpCode() int { return 0x08<<10 | 0x002C }
+
+// Len returns the length of the command.
+func (c *LEReadLocalResolvableAddress) Len() int { return 7 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEReadLocalResolvableAddress) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LEReadLocalResolvableAddressRP returns the return parameter of LE Read Local Resolvable Address
+type LEReadLocalResolvableAddressRP struct {
+	Status                 uint8
+	LocalResolvableAddress [6]byte
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LEReadLocalResolvableAddressRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetAddressResolutionEnable implements LE Set Address Resolution Enable (0x08|0x002D) [Vol 2, Part E, 7.8.44]
+type LESetAddressResolutionEnable struct {
+	AddressResolutionEnable uint8
+}
+
+func (c *LESetAddressResolutionEnable) String() string {
+	return "LE Set Address Resolution Enable (0x08|0x002D)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetAddressResolutionEnable) OpCode() int { return 0x08<<10 | 0x002D }
+
+// Len returns the length of the command.
+func (c *LESetAddressResolutionEnable) Len() int { return 1 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetAddressResolutionEnable) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LESetAddressResolutionEnableRP returns the return parameter of LE Set Address Resolution Enable
+type LESetAddressResolutionEnableRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetAddressResolutionEnableRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetResolvablePrivateAddressTimeout implements LE Set Resolvable Private Address Timeout (0x08|0x002E) [Vol 2, Part E, 7.8.45]
+type LESetResolvablePrivateAddressTimeout struct {
+	RPATimeout uint16
+}
+
+func (c *LESetResolvablePrivateAddressTimeout) String() string {
+	return "LE Set Resolvable Private Address Timeout (0x08|0x002E)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetResolvablePrivateAddressTimeout) OpCode() int { return 0x08<<10 | 0x002E }
+
+// Len returns the length of the command.
+func (c *LESetResolvablePrivateAddressTimeout) Len() int { return 2 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetResolvablePrivateAddressTimeout) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LESetResolvablePrivateAddressTimeoutRP returns the return parameter of LE Set Resolvable Private Address Timeout
+type LESetResolvablePrivateAddressTimeoutRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetResolvablePrivateAddressTimeoutRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetAdvertisingSetRandomAddress implements LE Set Advertising Set Random Address (0x08|0x0035) [Vol 2, Part E, 7.8.52]
+type LESetAdvertisingSetRandomAddress struct {
+	AdvertisingHandle uint8
+	RandomAddress     [6]byte
+}
+
+func (c *LESetAdvertisingSetRandomAddress) String() string {
+	return "LE Set Advertising Set Random Address (0x08|0x0035)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetAdvertisingSetRandomAddress) OpCode() int { return 0x08<<10 | 0x0035 }
+
+// Len returns the length of the command.
+func (c *LESetAdvertisingSetRandomAddress) Len() int { return 7 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetAdvertisingSetRandomAddress) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LESetAdvertisingSetRandomAddressRP returns the return parameter of LE Set Advertising Set Random Address
+type LESetAdvertisingSetRandomAddressRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetAdvertisingSetRandomAddressRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetExtendedAdvertisingParameters implements LE Set Extended Advertising Parameters (0x08|0x0036) [Vol 2, Part E, 7.8.53]
+type LESetExtendedAdvertisingParameters struct {
+	AdvertisingHandle             uint8
+	AdvertisingEventProperties    uint16
+	PrimaryAdvertisingIntervalMin [3]byte
+	PrimaryAdvertisingIntervalMax [3]byte
+	PrimaryAdvertisingChannelMap  uint8
+	OwnAddressType                uint8
+	PeerAddressType               uint8
+	PeerAddress                   [6]byte
+	AdvertisingFilterPolicy       uint8
+	AdvertisingTXPower            int8
+	PrimaryAdvertisingPHY         uint8
+	SecondaryAdvertisingMaxSkip   uint8
+	SecondaryAdvertisingPHY       uint8
+	AdvertisingSID                uint8
+	ScanRequestNotificationEnable uint8
+}
+
+func (c *LESetExtendedAdvertisingParameters) String() string {
+	return "LE Set Extended Advertising Parameters (0x08|0x0036)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetExtendedAdvertisingParameters) OpCode() int { return 0x08<<10 | 0x0036 }
+
+// Len returns the length of the command.
+func (c *LESetExtendedAdvertisingParameters) Len() int { return 25 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetExtendedAdvertisingParameters) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LESetExtendedAdvertisingParametersRP returns the return parameter of LE Set Extended Advertising Parameters
+type LESetExtendedAdvertisingParametersRP struct {
+	Status          uint8
+	SelectedTXPower int8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetExtendedAdvertisingParametersRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetPrivacyMode implements LE Set Privacy Mode (0x08|0x004E) [Vol 2, Part E, 7.8.77]
+type LESetPrivacyMode struct {
+	PeerIdentityAddressType uint8
+	PeerIdentityAddress     [6]byte
+	PrivacyMode             uint8
+}
+
+func (c *LESetPrivacyMode) String() string {
+	return "LE Set Privacy Mode (0x08|0x004E)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetPrivacyMode) OpCode() int { return 0x08<<10 | 0x004E }
+
+// Len returns the length of the command.
+func (c *LESetPrivacyMode) Len() int { return 8 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetPrivacyMode) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LESetPrivacyModeRP returns the return parameter of LE Set Privacy Mode
+type LESetPrivacyModeRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetPrivacyModeRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LEReadBufferSizeV2 implements LE Read Buffer Size V2 (0x08|0x0060) [Vol 2, Part E, 7.8.2]
+type LEReadBufferSizeV2 struct {
+}
+
+func (c *LEReadBufferSizeV2) String() string {
+	return "LE Read Buffer Size V2 (0x08|0x0060)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEReadBufferSizeV2) OpCode() int { return 0x08<<10 | 0x0060 }
+
+// Len returns the length of the command.
+func (c *LEReadBufferSizeV2) Len() int { return 0 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEReadBufferSizeV2) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LEReadBufferSizeV2RP returns the return parameter of LE Read Buffer Size V2
+type LEReadBufferSizeV2RP struct {
+	Status                     uint8
+	HCLEACLDataPacketLength    uint16
+	HCTotalNumLEACLDataPackets uint8
+	HCISODataPacketLength      uint16
+	HCTotalNumISODataPackets   uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LEReadBufferSizeV2RP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LERemoveCIG implements LE Remove CIG (0x08|0x0065) [Vol 2, Part E, 7.8.100]
+type LERemoveCIG struct {
+	CIGID uint8
+}
+
+func (c *LERemoveCIG) String() string {
+	return "LE Remove CIG (0x08|0x0065)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LERemoveCIG) OpCode() int { return 0x08<<10 | 0x0065 }
+
+// Len returns the length of the command.
+func (c *LERemoveCIG) Len() int { return 1 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LERemoveCIG) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LERemoveCIGRP returns the return parameter of LE Remove CIG
+type LERemoveCIGRP struct {
+	Status uint8
+	CIGID  uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LERemoveCIGRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LEAcceptCISRequest implements LE Accept CIS Request (0x08|0x0066) [Vol 2, Part E, 7.8.101]
+type LEAcceptCISRequest struct {
+	ConnectionHandle uint16
+}
+
+func (c *LEAcceptCISRequest) String() string {
+	return "LE Accept CIS Request (0x08|0x0066)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEAcceptCISRequest) OpCode() int { return 0x08<<10 | 0x0066 }
+
+// Len returns the length of the command.
+func (c *LEAcceptCISRequest) Len() int { return 2 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEAcceptCISRequest) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LERejectCISRequest implements LE Reject CIS Request (0x08|0x0067) [Vol 2, Part E, 7.8.102]
+type LERejectCISRequest struct {
+	ConnectionHandle uint16
+	Reason           uint8
+}
+
+func (c *LERejectCISRequest) String() string {
+	return "LE Reject CIS Request (0x08|0x0067)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LERejectCISRequest) OpCode() int { return 0x08<<10 | 0x0067 }
+
+// Len returns the length of the command.
+func (c *LERejectCISRequest) Len() int { return 3 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LERejectCISRequest) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LERejectCISRequestRP returns the return parameter of LE Reject CIS Request
+type LERejectCISRequestRP struct {
+	Status           uint8
+	ConnectionHandle uint16
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LERejectCISRequestRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LECreateBIG implements LE Create BIG (0x08|0x0068) [Vol 2, Part E, 7.8.103]
+type LECreateBIG struct {
+	BIGHandle           uint8
+	AdvertisingHandle   uint8
+	NumBIS              uint8
+	SDUInterval         [3]byte
+	MaxSDU              uint16
+	MaxTransportLatency uint16
+	RTN                 uint8
+	PHY                 uint8
+	Packing             uint8
+	Framing             uint8
+	Encryption          uint8
+	BroadcastCode       [16]byte
+}
+
+func (c *LECreateBIG) String() string {
+	return "LE Create BIG (0x08|0x0068)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LECreateBIG) OpCode() int { return 0x08<<10 | 0x0068 }
+
+// Len returns the length of the command.
+func (c *LECreateBIG) Len() int { return 31 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LECreateBIG) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LETerminateBIG implements LE Terminate BIG (0x08|0x006A) [Vol 2, Part E, 7.8.105]
+type LETerminateBIG struct {
+	BIGHandle uint8
+	Reason    uint8
+}
+
+func (c *LETerminateBIG) String() string {
+	return "LE Terminate BIG (0x08|0x006A)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LETerminateBIG) OpCode() int { return 0x08<<10 | 0x006A }
+
+// Len returns the length of the command.
+func (c *LETerminateBIG) Len() int { return 2 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LETerminateBIG) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LEBIGTerminateSync implements LE BIG Terminate Sync (0x08|0x006C) [Vol 2, Part E, 7.8.107]
+type LEBIGTerminateSync struct {
+	BIGHandle uint8
+}
+
+func (c *LEBIGTerminateSync) String() string {
+	return "LE BIG Terminate Sync (0x08|0x006C)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LEBIGTerminateSync) OpCode() int { return 0x08<<10 | 0x006C }
+
+// Len returns the length of the command.
+func (c *LEBIGTerminateSync) Len() int { return 1 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LEBIGTerminateSync) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LEBIGTerminateSyncRP returns the return parameter of LE BIG Terminate Sync
+type LEBIGTerminateSyncRP struct {
+	Status    uint8
+	BIGHandle uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LEBIGTerminateSyncRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LERemoveISODataPath implements LE Remove ISO Data Path (0x08|0x006F) [Vol 2, Part E, 7.8.110]
+type LERemoveISODataPath struct {
+	ConnectionHandle  uint16
+	DataPathDirection uint8
+}
+
+func (c *LERemoveISODataPath) String() string {
+	return "LE Remove ISO Data Path (0x08|0x006F)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LERemoveISODataPath) OpCode() int { return 0x08<<10 | 0x006F }
+
+// Len returns the length of the command.
+func (c *LERemoveISODataPath) Len() int { return 3 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LERemoveISODataPath) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LERemoveISODataPathRP returns the return parameter of LE Remove ISO Data Path
+type LERemoveISODataPathRP struct {
+	Status           uint8
+	ConnectionHandle uint16
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LERemoveISODataPathRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
+
+// LESetHostFeature implements LE Set Host Feature (0x08|0x0074) [Vol 2, Part E, 7.8.115]
+type LESetHostFeature struct {
+	BitNumber uint8
+	BitValue  uint8
+}
+
+func (c *LESetHostFeature) String() string {
+	return "LE Set Host Feature (0x08|0x0074)"
+}
+
+// OpCode returns the opcode of the command.
+func (c *LESetHostFeature) OpCode() int { return 0x08<<10 | 0x0074 }
+
+// Len returns the length of the command.
+func (c *LESetHostFeature) Len() int { return 2 }
+
+// Marshal serializes the command parameters into binary form.
+func (c *LESetHostFeature) Marshal(b []byte) error {
+	return marshal(c, b)
+}
+
+// LESetHostFeatureRP returns the return parameter of LE Set Host Feature
+type LESetHostFeatureRP struct {
+	Status uint8
+}
+
+// Unmarshal de-serializes the binary data and stores the result in the receiver.
+func (c *LESetHostFeatureRP) Unmarshal(b []byte) error {
+	return unmarshal(c, b)
+}
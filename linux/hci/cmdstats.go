@@ -0,0 +1,120 @@
+package hci
+
+import (
+	"sync"
+	"time"
+)
+
+// CommandLatencyBounds are the upper bounds of the buckets of the latency
+// histograms of CommandStats. Latencies above the last bound are counted in
+// an extra bucket.
+var CommandLatencyBounds = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// CommandStats are the latencies and outcomes of the commands with an
+// opcode, since the HCI was created or the counters were reset. The latency
+// of a command runs from its transmission to its Command Complete or Command
+// Status event.
+type CommandStats struct {
+	OpCode int
+	Name   string
+
+	Count    uint64 // Commands answered by the controller.
+	Failed   uint64 // Commands answered with an error status.
+	TimedOut uint64 // Commands the controller didn't answer.
+
+	Total time.Duration // Sum of the latencies of the answered commands.
+	Max   time.Duration
+
+	// Buckets count the answered commands by latency, up to the bounds of
+	// CommandLatencyBounds in turn, and above the last one.
+	Buckets []uint64
+}
+
+// Mean returns the mean latency of the answered commands.
+func (s CommandStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+type cmdStats struct {
+	sync.Mutex
+	m map[int]*CommandStats
+}
+
+// stats returns the counters of the command c. Must be called with st held.
+func (st *cmdStats) stats(c Command) *CommandStats {
+	if st.m == nil {
+		st.m = make(map[int]*CommandStats)
+	}
+	s, ok := st.m[c.OpCode()]
+	if !ok {
+		s = &CommandStats{
+			OpCode:  c.OpCode(),
+			Name:    c.String(),
+			Buckets: make([]uint64, len(CommandLatencyBounds)+1),
+		}
+		st.m[c.OpCode()] = s
+	}
+	return s
+}
+
+// answered counts the command c, answered after d with the return
+// parameters or the status rsp.
+func (st *cmdStats) answered(c Command, d time.Duration, rsp []byte) {
+	st.Lock()
+	defer st.Unlock()
+	s := st.stats(c)
+	s.Count++
+	if len(rsp) > 0 && rsp[0] != 0x00 {
+		s.Failed++
+	}
+	s.Total += d
+	if d > s.Max {
+		s.Max = d
+	}
+	i := 0
+	for i < len(CommandLatencyBounds) && d > CommandLatencyBounds[i] {
+		i++
+	}
+	s.Buckets[i]++
+}
+
+// timedOut counts the command c, left unanswered.
+func (st *cmdStats) timedOut(c Command) {
+	st.Lock()
+	st.stats(c).TimedOut++
+	st.Unlock()
+}
+
+// CommandStats returns the latencies and outcomes of the commands sent, by
+// opcode, e.g. to identify a slow or flaky controller.
+func (h *HCI) CommandStats() map[int]CommandStats {
+	st := &h.cmdStats
+	st.Lock()
+	defer st.Unlock()
+	m := make(map[int]CommandStats, len(st.m))
+	for oc, s := range st.m {
+		c := *s
+		c.Buckets = append([]uint64(nil), s.Buckets...)
+		m[oc] = c
+	}
+	return m
+}
+
+// ResetCommandStats resets the command counters.
+func (h *HCI) ResetCommandStats() {
+	st := &h.cmdStats
+	st.Lock()
+	st.m = nil
+	st.Unlock()
+}
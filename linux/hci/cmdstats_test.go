@@ -0,0 +1,45 @@
+package hci
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble/linux/hci/cmd"
+)
+
+func TestCommandStats(t *testing.T) {
+	h := &HCI{}
+	st := &h.cmdStats
+	c := &cmd.LESetScanEnable{}
+
+	st.answered(c, 2*time.Millisecond, []byte{0x00})
+	st.answered(c, 300*time.Millisecond, []byte{0x0C})
+	st.answered(c, 2*time.Second, []byte{0x00})
+	st.timedOut(c)
+
+	s, ok := h.CommandStats()[c.OpCode()]
+	if !ok {
+		t.Fatal("command not counted")
+	}
+	if s.Count != 3 || s.Failed != 1 || s.TimedOut != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if s.Max != 2*time.Second || s.Mean() != (2302*time.Millisecond)/3 {
+		t.Fatalf("max %v, mean %v", s.Max, s.Mean())
+	}
+	want := []uint64{0, 1, 0, 0, 0, 1, 0, 1}
+	for i, n := range want {
+		if s.Buckets[i] != n {
+			t.Fatalf("buckets %v, want %v", s.Buckets, want)
+		}
+	}
+	s.Buckets[0] = 10
+	if h.CommandStats()[c.OpCode()].Buckets[0] != 0 {
+		t.Fatal("CommandStats returned the internal buckets")
+	}
+
+	h.ResetCommandStats()
+	if len(h.CommandStats()) != 0 {
+		t.Fatal("stats not reset")
+	}
+}
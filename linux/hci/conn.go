@@ -3,24 +3,36 @@ package hci
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/leso-kn/ble"
 	"github.com/leso-kn/ble/linux/hci/cmd"
 	"github.com/leso-kn/ble/linux/hci/evt"
+	"github.com/leso-kn/ble/sliceops"
 	"github.com/pkg/errors"
 )
 
 // Conn ...
 type Conn struct {
 	hci *HCI
+	id  string
 	ctx context.Context
 
 	param evt.LEConnectionComplete
+	rpa   connRPA
+
+	// csa is the channel selection algorithm, once the controller reported
+	// it. Accessed atomically.
+	csa int32
 
 	// While MTU is the maximum size of payload data that the upper layer (ATT)
 	// can accept, the MPS is the maximum PDU payload size this L2CAP implementation
@@ -45,16 +57,36 @@ type Conn struct {
 	sigRxMTU int
 	sigTxMTU int
 
-	sigSent chan []byte
-	// smpSent chan []byte
+	// muSigReq serializes the requests of Signal. sigPending is the
+	// identifier of the one waiting for its response on sigRsp; muSig guards
+	// them and sigID.
+	muSigReq   sync.Mutex
+	muSig      sync.Mutex
+	sigPending uint8
+	sigRsp     chan sigCmd
+	sigOnRsp   func(sigCmd)
+
+	// muChans guards chans, the LE credit based channels of the connection
+	// by their local CID.
+	muChans sync.Mutex
+	chans   map[uint16]*L2CAPChannel
 
-	chInPkt chan packet
-	chInPDU chan pdu
+	chInPkt chan rxPacket
+	chInPDU chan rxPDU
+
+	// rxRing holds the buffers of the fragmented ATT PDUs, queued to
+	// chInPDU until Read copies them.
+	rxRing *pduRing
 
 	chDone chan struct{}
+
+	// rd and wd are the read and write deadlines.
+	rd, wd deadline
+
 	// Host to Controller Data Flow Control pkt-based Data flow control for LE-U [Vol 2, Part E, 4.1.1]
 	// chSentBufs tracks the HCI buffer occupied by this connection.
 	txBuffer *Client
+	muTx     sync.Mutex // Serializes the fragments of outgoing PDUs.
 
 	// sigID is used to match responses with signaling requests.
 	// The requesting device sets this field and the responding device uses the
@@ -69,21 +101,42 @@ type Conn struct {
 	// with a success status
 	encryptionEnabled bool
 
+	// remote holds the features and version read from the remote device.
+	remote remoteInfo
+
 	smp        SmpManager
 	encInfo    ble.EncryptionChangedInfo
 	encChanged chan ble.EncryptionChangedInfo
 	ble.Logger
+
+	// aclLog logs the ACL data packets, sampled as set for ble.LogACL.
+	aclLog ble.Logger
+}
+
+// connRPA holds the resolvable private addresses used on a connection when
+// privacy is enabled, and the private address the host rotated the local
+// one to. They are all zero if the identity addresses were used.
+type connRPA struct {
+	local [6]byte
+	peer  [6]byte
 }
 
 type Encrypter interface {
 	Encrypt() error
 }
 
-func newConn(h *HCI, param evt.LEConnectionComplete, mac string) *Conn {
+func newConn(h *HCI, param evt.LEConnectionComplete, rpa connRPA, mac string) *Conn {
+	id := newConnID()
+	role := "peripheral"
+	if param.Role() == roleMaster {
+		role = "central"
+	}
 	c := &Conn{
 		hci:   h,
-		ctx:   context.Background(),
+		id:    id,
+		ctx:   ble.WithConnValues(context.WithValue(context.Background(), ble.ContextKeyConnID, id)),
 		param: param,
+		rpa:   rpa,
 
 		rxMTU: ble.DefaultMTU,
 		txMTU: ble.DefaultMTU,
@@ -93,20 +146,34 @@ func newConn(h *HCI, param evt.LEConnectionComplete, mac string) *Conn {
 		sigRxMTU: ble.MaxMTU,
 		sigTxMTU: ble.DefaultMTU,
 
-		chInPkt: make(chan packet, 16),
-		chInPDU: make(chan pdu, 16),
+		chInPkt: make(chan rxPacket, 16),
+		chInPDU: make(chan rxPDU, 16),
 
 		txBuffer: NewClient(h.pool),
 
+		remote: newRemoteInfo(),
+
 		chDone: make(chan struct{}),
-		Logger: h.Logger.ChildLogger(map[string]interface{}{"l2cap": mac}),
-	}
+		// The layers above log through this logger, or children of it, so
+		// all the log lines of the connection tell which it is.
+		Logger: h.Logger.ChildLogger(map[string]interface{}{
+			"conn":   id,
+			"peer":   mac,
+			"handle": fmt.Sprintf("%04x", param.ConnectionHandle()),
+			"role":   role,
+		}),
+	}
+	c.aclLog = h.SampledLogger(ble.LogACL, c.Logger)
+	// The PDUs queued, the one Read copies, and the one reassembled.
+	c.rxRing = newPDURing(cap(c.chInPDU) + 2)
 
 	if c.hci.smpEnabled {
-		c.smp = c.hci.smp.Create(defaultSmpConfig, c.Logger)
+		c.smp = c.hci.smp.Create(c.hci.smpConfig, c.Logger)
 		c.initPairingContext()
 		c.smp.SetWritePDUFunc(c.writePDU)
 		c.smp.SetEncryptFunc(c.encrypt)
+		c.smp.SetAuthData(c.hci.pairingAuthData)
+		c.smp.SetLocalIdentity(c.hci.localIdentity())
 	}
 
 	go func() {
@@ -130,6 +197,20 @@ func newConn(h *HCI, param evt.LEConnectionComplete, mac string) *Conn {
 	return c
 }
 
+// newConnID returns a random correlation ID for a connection.
+func newConnID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ID returns the correlation ID of the connection, which its log lines carry
+// as the "conn" field. It's also set on the context of the connection, see
+// ble.ConnIDFromContext.
+func (c *Conn) ID() string {
+	return c.id
+}
+
 // Context returns the context that is used by this Conn.
 func (c *Conn) Context() context.Context {
 	return c.ctx
@@ -172,18 +253,32 @@ func (c *Conn) StartEncryption(ch chan ble.EncryptionChangedInfo) error {
 	return err
 }
 
-func (c *Conn) PrepareCustomPairing(ch chan bool) {
-	c.smp.PrepareCustomPairing(ch)
-}
-
 // Read copies re-assembled L2CAP PDUs into sdu.
 func (c *Conn) Read(sdu []byte) (n int, err error) {
-	p, ok := <-c.chInPDU
+	n, _, err = c.ReadTimestamped(sdu)
+	return n, err
+}
+
+// ReadTimestamped is like Read, and also returns the time the first ACL
+// packet of the PDU was received at, before it was queued, or the zero time
+// unless the HCI stamps them, see SetNotificationTimestamps.
+func (c *Conn) ReadTimestamped(sdu []byte) (int, time.Time, error) {
+	rd := c.rd.wait()
+	if expired(rd) {
+		return 0, time.Time{}, os.ErrDeadlineExceeded
+	}
+	var p rxPDU
+	var ok bool
+	select {
+	case p, ok = <-c.chInPDU:
+	case <-rd:
+		return 0, time.Time{}, os.ErrDeadlineExceeded
+	}
 	if !ok {
-		return 0, fmt.Errorf("input channel closed: %w", io.ErrClosedPipe)
+		return 0, time.Time{}, fmt.Errorf("input channel closed: %w", io.ErrClosedPipe)
 	}
-	if len(p) == 0 {
-		return 0, errors.Wrap(io.ErrUnexpectedEOF, "received empty packet")
+	if len(p.pdu) == 0 {
+		return 0, time.Time{}, errors.Wrap(io.ErrUnexpectedEOF, "received empty packet")
 	}
 
 	// Assume it's a B-Frame.
@@ -191,11 +286,11 @@ func (c *Conn) Read(sdu []byte) (n int, err error) {
 	data := p.payload()
 	if c.leFrame {
 		// LE-Frame.
-		slen = leFrameHdr(p).slen()
-		data = leFrameHdr(p).payload()
+		slen = leFrameHdr(p.pdu).slen()
+		data = leFrameHdr(p.pdu).payload()
 	}
 	if cap(sdu) < slen {
-		return 0, errors.Wrapf(io.ErrShortBuffer, "payload received exceeds sdu buffer")
+		return 0, time.Time{}, errors.Wrapf(io.ErrShortBuffer, "payload received exceeds sdu buffer")
 	}
 	buf := bytes.NewBuffer(sdu)
 	buf.Reset()
@@ -204,7 +299,7 @@ func (c *Conn) Read(sdu []byte) (n int, err error) {
 		p := <-c.chInPDU
 		buf.Write(p.payload())
 	}
-	return slen, nil
+	return slen, p.at, nil
 }
 
 // Write breaks down a L2CAP SDU into segmants [Vol 3, Part A, 7.3.1]
@@ -256,11 +351,59 @@ func (c *Conn) initPairingContext() {
 		lat = 0x01
 	}
 	ra := c.RemoteAddr().Bytes()
-	rat := c.param.PeerAddressType()
+	// Identity address types (0x02, 0x03) map onto public and random.
+	rat := c.param.PeerAddressType() & 0x01
+
+	// Pairing uses the addresses that were used to establish the connection.
+	if a := c.LocalRPA(); a != nil {
+		la, lat = a.Bytes(), 0x01
+	}
+	if a := c.PeerRPA(); a != nil {
+		ra, rat = a.Bytes(), 0x01
+	}
 
 	smp.InitContext(la, ra, lat, rat)
 }
 
+// bonded reports whether the bond manager has a bond with the peer, under
+// the address the pairing context uses.
+func (c *Conn) bonded() bool {
+	bm := c.hci.bondManager
+	if bm == nil || c.smp == nil {
+		return false
+	}
+	ra := c.RemoteAddr().Bytes()
+	if a := c.PeerRPA(); a != nil {
+		ra = a.Bytes()
+	}
+	return bm.Exists(hex.EncodeToString(sliceops.SwapBuf(ra)))
+}
+
+// restoreEncryption encrypts the link with the keys of the bond with the
+// peer. It fails with ble.ErrBondMissing if the peer lost the bond, and
+// ble.ErrBondRejected if it refused the keys otherwise.
+func (c *Conn) restoreEncryption(ctx context.Context) error {
+	ch := make(chan ble.EncryptionChangedInfo, 1)
+	if err := c.StartEncryption(ch); err != nil {
+		return err
+	}
+	select {
+	case info := <-ch:
+		switch {
+		case info.Status == 0x00 && info.Enabled:
+			return nil
+		case ErrCommand(info.Status) == ErrPINMissing:
+			return ble.ErrBondMissing
+		default:
+			return ble.ErrBondRejected
+		}
+	case <-c.Disconnected():
+		return ble.ErrBondRejected
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (c *Conn) encrypt(bi BondInfo) error {
 	legacy, stk := c.smp.LegacyPairingInfo()
 	//if a short term key is present, use it as the long term key
@@ -305,6 +448,28 @@ func (c *Conn) encrypt(bi BondInfo) error {
 	return c.hci.Send(&m, nil)
 }
 
+// replyLongTermKey replies to the request of the controller for the key to
+// encrypt the link with, when the peer starts encryption.
+func (c *Conn) replyLongTermKey(ediv uint16, rand uint64) {
+	var ltk []byte
+	if c.smp != nil {
+		ltk = c.smp.LongTermKey(ediv, rand)
+	}
+
+	h := c.param.ConnectionHandle()
+	var err error
+	if len(ltk) == 16 {
+		m := cmd.LELongTermKeyRequestReply{ConnectionHandle: h}
+		copy(m.LongTermKey[:], ltk)
+		err = c.hci.Send(&m, nil)
+	} else {
+		err = c.hci.Send(&cmd.LELongTermKeyRequestNegativeReply{ConnectionHandle: h}, nil)
+	}
+	if err != nil {
+		c.Errorf("replyLongTermKey: %v", err)
+	}
+}
+
 func (c *Conn) stkEncrypt(key []byte) error {
 	m := cmd.LEStartEncryption{}
 	m.ConnectionHandle = c.param.ConnectionHandle()
@@ -328,22 +493,33 @@ func (c *Conn) writePDU(pdu []byte) (int, error) {
 	// All L2CAP fragments associated with an L2CAP PDU shall be processed for
 	// transmission by the Controller before any other L2CAP PDU for the same
 	// logical transport shall be processed.
-	c.txBuffer.LockPool()
-	defer c.txBuffer.UnlockPool()
+	c.muTx.Lock()
+	defer c.muTx.Unlock()
 
 	// Fail immediately if the connection is already closed
-	// Check this with the pool locked to avoid race conditions
-	// with handleDisconnectionComplete
 	select {
 	case <-c.chDone:
 		return 0, io.ErrClosedPipe
 	default:
 	}
 
+	// The write timeout only applies to the first fragment, as the PDU can't
+	// be abandoned once it's partially sent.
+	tmo, byDeadline, err := c.writeTimeout()
+	if err != nil {
+		return 0, err
+	}
 	for len(pdu) > 0 {
 		// Get a buffer from our pre-allocated and flow-controlled pool.
-		pkt := c.txBuffer.Get() // ACL pkt
-		flen := len(pdu)        // fragment length
+		pkt, err := c.txBuffer.Get(tmo, c.chDone) // ACL pkt
+		if err == ErrACLBufferFull && byDeadline {
+			err = os.ErrDeadlineExceeded
+		}
+		if err != nil {
+			return sent, err
+		}
+		tmo = 0
+		flen := len(pdu) // fragment length
 		if flen > pkt.Cap()-1-4 {
 			flen = pkt.Cap() - 1 - 4
 		}
@@ -374,8 +550,8 @@ func (c *Conn) writePDU(pdu []byte) (int, error) {
 		default:
 		}
 
-		c.Debugf("tx: %x", pkt.Bytes())
-		if _, err := c.hci.skt.Write(pkt.Bytes()); err != nil {
+		c.aclLog.Debugf("tx: %x", pkt.Bytes())
+		if _, err := c.hci.socket().Write(pkt.Bytes()); err != nil {
 			return sent, err
 		}
 		sent += flen
@@ -388,7 +564,7 @@ func (c *Conn) writePDU(pdu []byte) (int, error) {
 
 // Recombines fragments into a L2CAP PDU. [Vol 3, Part A, 7.2.2]
 func (c *Conn) recombine() error {
-	var pkt packet
+	var pkt rxPacket
 	var ok bool
 	select {
 	case <-c.hci.done:
@@ -400,7 +576,8 @@ func (c *Conn) recombine() error {
 	}
 
 	p := pdu(pkt.data())
-	c.Debugf("recombine: pdu in - %x", pkt.data())
+	at := pkt.at
+	c.aclLog.Debugf("recombine: pdu in - %x", pkt.data())
 	// Currently, check for LE-U only. For channels that we don't recognizes,
 	// re-combine them anyway, and discard them later when we dispatch the PDU
 	// according to CID.
@@ -409,10 +586,17 @@ func (c *Conn) recombine() error {
 	}
 
 	// If this pkt is not a complete PDU, and we'll be receiving more
-	// fragments, re-allocate the whole PDU (including Header).
+	// fragments, reassemble the whole PDU (including Header). ATT PDUs,
+	// which Read copies out, are reassembled in the buffers of the ring.
+	// Others are handled by the signaling and SMP code, which may keep
+	// them.
 	if len(p.payload()) < p.dlen() {
-		p = make([]byte, 0, 4+p.dlen())
-		p = append(p, pdu(pkt.data())...)
+		if p.cid() == cidLEAtt {
+			p = append(c.rxRing.get(4+p.dlen(), c.rxMTU), pdu(pkt.data())...)
+		} else {
+			p = make([]byte, 0, 4+p.dlen())
+			p = append(p, pdu(pkt.data())...)
+		}
 	}
 	for len(p) < 4+p.dlen() {
 		//need to wait on more data
@@ -427,10 +611,9 @@ func (c *Conn) recombine() error {
 		p = append(p, pdu(pkt.data())...)
 	}
 
-	// TODO: support dynamic or assigned channels for LE-Frames.
 	switch p.cid() {
 	case cidLEAtt:
-		c.chInPDU <- p
+		c.chInPDU <- rxPDU{pdu: p, at: at}
 	case cidLESignal:
 		_ = c.handleSignal(p)
 	case CidSMP:
@@ -441,6 +624,10 @@ func (c *Conn) recombine() error {
 		}
 
 	default:
+		if ch := c.channel(p.cid()); ch != nil {
+			ch.handleKFrame(p.payload())
+			break
+		}
 		c.Errorf("recombine: unrecognized CID %04X, [%X]", p.cid(), p)
 	}
 	return nil
@@ -457,8 +644,23 @@ func (c *Conn) handleEncryptionChanged(status uint8, enabled uint8) {
 	}
 
 	c.encryptionEnabled = enabled == 0x01
+	if c.encryptionEnabled {
+		// Commands can't be sent from the event loop.
+		go c.applyAuthPayloadTimeout()
+		// Nor can key distribution, which waits for ACL buffers.
+		go func() {
+			if err := c.checkEncKeySize(); err != nil {
+				c.Errorf("encryptionChanged: %v", err)
+				return
+			}
+			if c.smp != nil {
+				c.smp.EncryptionChanged()
+			}
+		}()
+	}
 
 	c.encInfo = ble.EncryptionChangedInfo{Status: int(status), Err: err, Enabled: c.encryptionEnabled}
+	ble.SetConnValue(c, ble.ConnKeyEncryption, c.encInfo)
 	if c.encChanged != nil {
 		select {
 		case c.encChanged <- c.encInfo:
@@ -471,6 +673,27 @@ func (c *Conn) handleEncryptionChanged(status uint8, enabled uint8) {
 	}
 }
 
+// checkEncKeySize disconnects the link if it's encrypted with a key shorter
+// than the minimum key size.
+func (c *Conn) checkEncKeySize() error {
+	min := c.hci.smpConfig.MinKeySize
+	if min <= EncKeySizeMin {
+		return nil
+	}
+	rp := cmd.ReadEncryptionKeySizeRP{}
+	err := c.hci.Send(&cmd.ReadEncryptionKeySize{ConnectionHandle: c.param.ConnectionHandle()}, &rp)
+	if err == nil && rp.KeySize >= min {
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("encryption key size %d below minimum %d", rp.KeySize, min)
+	} else {
+		err = fmt.Errorf("failed to read encryption key size: %v", err)
+	}
+	c.hci.Send(&cmd.Disconnect{ConnectionHandle: c.param.ConnectionHandle(), Reason: uint8(ErrAuth)}, nil)
+	return err
+}
+
 func (c *Conn) handleEncryptionKeyRefreshComplete(status uint8) {
 	var err error
 	if status != 0x00 {
@@ -484,6 +707,7 @@ func (c *Conn) handleEncryptionKeyRefreshComplete(status uint8) {
 	c.encryptionEnabled = true
 
 	info := ble.EncryptionChangedInfo{Status: int(status), Err: err, Enabled: true}
+	ble.SetConnValue(c, ble.ConnKeyEncryption, info)
 	if c.encChanged != nil {
 		select {
 		case c.encChanged <- info:
@@ -503,6 +727,19 @@ func (c *Conn) Disconnected() <-chan struct{} {
 
 // Close disconnects the connection by sending hci disconnect command to the device.
 func (c *Conn) Close() error {
+	return c.Disconnect(ErrRemoteUser)
+}
+
+// Disconnect disconnects the remote device with the reason, one of those
+// the Disconnect command allows, e.g. ErrRemoteLowResources to turn down a
+// central while the device serves others. [Vol 2, Part E, 7.1.6]
+func (c *Conn) Disconnect(reason ErrCommand) error {
+	switch reason {
+	case ErrAuth, ErrRemoteUser, ErrRemoteLowResources, ErrRemotePowerOff,
+		ErrUnsupportedLMP, ErrUnitKeyNotSupported, ErrConnParams:
+	default:
+		return fmt.Errorf("invalid disconnection reason 0x%02X", uint8(reason))
+	}
 	select {
 	case <-c.chDone:
 		// Return if it's already closed.
@@ -510,7 +747,7 @@ func (c *Conn) Close() error {
 	default:
 		err := c.hci.Send(&cmd.Disconnect{
 			ConnectionHandle: c.param.ConnectionHandle(),
-			Reason:           0x13,
+			Reason:           uint8(reason),
 		}, nil)
 
 		c.Debugf("conn connection close called")
@@ -519,6 +756,15 @@ func (c *Conn) Close() error {
 	}
 }
 
+// VerifySignature verifies the signature at the end of data, such as a Signed
+// Write Command, with the CSRK distributed by the bonded peer.
+func (c *Conn) VerifySignature(data []byte) error {
+	if c.smp == nil {
+		return fmt.Errorf("smp not enabled")
+	}
+	return c.smp.VerifySignature(data)
+}
+
 // LocalAddr returns local device's MAC address.
 func (c *Conn) LocalAddr() ble.Addr { return c.hci.Addr() }
 
@@ -544,6 +790,67 @@ func (c *Conn) ReadRSSI() (int8, error) {
 	return readRsp.RSSI, nil
 }
 
+// ReadTxPowerLevel returns the current transmit power level on the
+// connection in dBm, or the maximum level if max is set. [Vol 2, Part E, 7.3.35]
+func (c *Conn) ReadTxPowerLevel(max bool) (int8, error) {
+	read := &cmd.ReadTransmitPowerLevel{ConnectionHandle: c.param.ConnectionHandle()}
+	if max {
+		read.Type = 1
+	}
+	readRsp := cmd.ReadTransmitPowerLevelRP{}
+
+	err := c.hci.Send(read, &readRsp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read tx power level: %v", err)
+	}
+
+	if readRsp.Status != 0 {
+		return 0, fmt.Errorf("read tx power level failed with status %x", readRsp.Status)
+	}
+
+	return readRsp.TransmitPowerLevel, nil
+}
+
+// LocalRPA returns the resolvable private address used by the local device on
+// this connection, or nil if the identity address was used.
+func (c *Conn) LocalRPA() ble.Addr { return rpaAddr(c.rpa.local) }
+
+// PeerRPA returns the resolvable private address used by the remote device on
+// this connection, or nil if it was not resolved by the controller.
+// RemoteAddr returns the peer's identity address in that case.
+func (c *Conn) PeerRPA() ble.Addr { return rpaAddr(c.rpa.peer) }
+
+// ConnInfo returns how the connection was established.
+func (c *Conn) ConnInfo() ble.ConnInfo {
+	return ble.ConnInfo{
+		Central:                   c.param.Role() == roleMaster,
+		LocalRPA:                  c.LocalRPA(),
+		PeerRPA:                   c.PeerRPA(),
+		Interval:                  time.Duration(c.param.ConnInterval()) * 1250 * time.Microsecond,
+		Latency:                   c.param.ConnLatency(),
+		SupervisionTimeout:        time.Duration(c.param.SupervisionTimeout()) * 10 * time.Millisecond,
+		CentralClockAccuracy:      clockAccuracy(c.param.MasterClockAccuracy()),
+		ChannelSelectionAlgorithm: int(atomic.LoadInt32(&c.csa)),
+	}
+}
+
+// clockAccuracy returns the sleep clock accuracy in ppm of a
+// Central_Clock_Accuracy value [Vol 2, Part E, 7.7.65.1].
+func clockAccuracy(v uint8) int {
+	ppm := []int{500, 250, 150, 100, 75, 50, 30, 20}
+	if int(v) >= len(ppm) {
+		return 0
+	}
+	return ppm[v]
+}
+
+func rpaAddr(a [6]byte) ble.Addr {
+	if a == [6]byte{} {
+		return nil
+	}
+	return ble.NewAddr(net.HardwareAddr([]byte{a[5], a[4], a[3], a[2], a[1], a[0]}).String())
+}
+
 // RxMTU returns the MTU which the upper layer is capable of accepting.
 func (c *Conn) RxMTU() int { return c.rxMTU }
 
@@ -569,8 +876,22 @@ func (a packet) bcf() int       { return (int(a[1]) >> 6) & 0x3 }
 func (a packet) dlen() int      { return int(a[2]) | (int(a[3]) << 8) }
 func (a packet) data() []byte   { return a[4:] }
 
+// rxPacket is an ACL data packet received, with the time it was received
+// at, if the HCI stamps them.
+type rxPacket struct {
+	packet
+	at time.Time
+}
+
 type pdu []byte
 
+// rxPDU is a reassembled PDU, with the time its first packet was received
+// at.
+type rxPDU struct {
+	pdu
+	at time.Time
+}
+
 func (p pdu) dlen() int       { return int(binary.LittleEndian.Uint16(p[0:2])) }
 func (p pdu) cid() uint16     { return binary.LittleEndian.Uint16(p[2:4]) }
 func (p pdu) payload() []byte { return p[4:] }
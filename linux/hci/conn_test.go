@@ -0,0 +1,139 @@
+package hci
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+// tagLogger records the tags of its children.
+type tagLogger struct {
+	ble.Logger
+	tags map[string]interface{}
+}
+
+func (l *tagLogger) ChildLogger(tags map[string]interface{}) ble.Logger {
+	l.tags = tags
+	return l
+}
+
+func TestConnLogContext(t *testing.T) {
+	l := &tagLogger{Logger: ble.GetLogger()}
+	p, err := NewPool(32, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &HCI{done: make(chan bool), pool: p, Logger: l}
+	defer close(h.done)
+
+	// Handle 0x0041, peripheral role, peer 11:22:33:44:55:66.
+	e := evt.LEConnectionComplete{evt.LEConnectionCompleteSubCode, 0x00, 0x41, 0x00, 0x01, 0x00,
+		0x66, 0x55, 0x44, 0x33, 0x22, 0x11, 0x18, 0x00, 0x00, 0x00, 0x48, 0x00, 0x00}
+	c := newConn(h, e, connRPA{}, "112233445566")
+
+	id, ok := ble.ConnIDFromContext(c.Context())
+	if !ok || id == "" || id != c.ID() {
+		t.Fatalf("context id %q, conn id %q", id, c.ID())
+	}
+	want := map[string]interface{}{"conn": id, "peer": "112233445566", "handle": "0041", "role": "peripheral"}
+	for k, v := range want {
+		if l.tags[k] != v {
+			t.Errorf("tag %s = %v, want %v", k, l.tags[k], v)
+		}
+	}
+	if c2 := newConn(h, e, connRPA{}, "112233445566"); c2.ID() == id {
+		t.Errorf("connections share the id %s", id)
+	}
+}
+
+func TestConnInfo(t *testing.T) {
+	p, err := NewPool(32, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &HCI{done: make(chan bool), pool: p, Logger: ble.GetLogger(), conns: map[uint16]*Conn{}}
+	defer close(h.done)
+
+	// Handle 0x0041, central role, interval 30ms, latency 2, timeout 720ms,
+	// clock accuracy 50 ppm.
+	e := evt.LEConnectionComplete{evt.LEConnectionCompleteSubCode, 0x00, 0x41, 0x00, 0x00, 0x00,
+		0x66, 0x55, 0x44, 0x33, 0x22, 0x11, 0x18, 0x00, 0x02, 0x00, 0x48, 0x00, 0x05}
+	c := newConn(h, e, connRPA{peer: [6]byte{1, 2, 3, 4, 5, 0x46}}, "112233445566")
+	h.conns[0x0041] = c
+
+	if got := c.ConnInfo().ChannelSelectionAlgorithm; got != 0 {
+		t.Errorf("algorithm %d before the event", got)
+	}
+	if err := h.handleLEChannelSelectionAlgorithm([]byte{evt.LEChannelSelectionAlgorithmSubCode, 0x41, 0x00, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	want := ble.ConnInfo{
+		Central:                   true,
+		PeerRPA:                   ble.NewAddr("46:05:04:03:02:01"),
+		Interval:                  30 * time.Millisecond,
+		Latency:                   2,
+		SupervisionTimeout:        720 * time.Millisecond,
+		CentralClockAccuracy:      50,
+		ChannelSelectionAlgorithm: 2,
+	}
+	if got := c.ConnInfo(); got != want {
+		t.Errorf("info %+v, want %+v", got, want)
+	}
+}
+
+func TestConnDeadlines(t *testing.T) {
+	p, err := NewPool(32, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &HCI{done: make(chan bool), pool: p, Logger: ble.GetLogger()}
+	defer close(h.done)
+	e := evt.LEConnectionComplete{evt.LEConnectionCompleteSubCode, 0x00, 0x41, 0x00, 0x01, 0x00,
+		0x66, 0x55, 0x44, 0x33, 0x22, 0x11, 0x18, 0x00, 0x00, 0x00, 0x48, 0x00, 0x00}
+	c := newConn(h, e, connRPA{}, "112233445566")
+
+	timedOut := func(err error) bool {
+		ne, ok := err.(net.Error)
+		return errors.Is(err, os.ErrDeadlineExceeded) && ok && ne.Timeout()
+	}
+
+	// A deadline set while Read waits unblocks it.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	}()
+	if _, err := c.Read(make([]byte, 32)); !timedOut(err) {
+		t.Fatalf("read: %v", err)
+	}
+	// An expired deadline fails Read at once, until it's cleared.
+	if _, err := c.Read(make([]byte, 32)); !timedOut(err) {
+		t.Fatalf("read after the deadline: %v", err)
+	}
+	c.SetReadDeadline(time.Time{})
+	select {
+	case <-c.rd.wait():
+		t.Fatal("cleared deadline expired")
+	default:
+	}
+
+	// Write waits for a controller buffer until the deadline.
+	if _, err := c.txBuffer.Get(0, nil); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	c.SetWriteDeadline(start.Add(20 * time.Millisecond))
+	if _, err := c.Write([]byte{0x01}); !timedOut(err) {
+		t.Fatalf("write: %v", err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("write failed after %v", d)
+	}
+	if _, err := c.Write([]byte{0x01}); !timedOut(err) {
+		t.Fatalf("write after the deadline: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package hci
+
+import (
+	"github.com/pkg/errors"
+)
+
+// MaxConnections returns the maximum number of connections of the local
+// device, in either role: the limit set with OptMaxConnections, or else the
+// number of connections the controller had when it last refused one with
+// Connection Limit Exceeded. HCI has no command to read the limit of the
+// controller, so it's 0 until then.
+func (h *HCI) MaxConnections() int {
+	h.muConns.Lock()
+	defer h.muConns.Unlock()
+	if h.maxConns > 0 {
+		return h.maxConns
+	}
+	return h.connLimit
+}
+
+// Connections returns the number of connections of the local device, in
+// either role.
+func (h *HCI) Connections() int {
+	h.muConns.Lock()
+	defer h.muConns.Unlock()
+	return len(h.conns)
+}
+
+// checkConnLimit fails with ErrConnLimit if the local device has as many
+// connections as the limit set with OptMaxConnections.
+func (h *HCI) checkConnLimit() error {
+	h.muConns.Lock()
+	defer h.muConns.Unlock()
+	if h.maxConns > 0 && len(h.conns) >= h.maxConns {
+		return errors.Wrapf(ErrConnLimit, "dial: %d of %d connections", len(h.conns), h.maxConns)
+	}
+	return nil
+}
+
+// connLimitReached records that the controller refused a connection at its
+// limit, and returns ErrConnLimit with the number of connections.
+func (h *HCI) connLimitReached() error {
+	h.muConns.Lock()
+	defer h.muConns.Unlock()
+	h.connLimit = len(h.conns)
+	return errors.Wrapf(ErrConnLimit, "dial: controller limit at %d connections", h.connLimit)
+}
@@ -0,0 +1,90 @@
+package hci
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/leso-kn/ble"
+)
+
+// ValidatePreferredConnParams checks the connection parameters a peripheral
+// prefers.
+func ValidatePreferredConnParams(p ble.ConnParams) error {
+	// The supervision timeout, in ms, must be larger than
+	// (1 + latency) * interval max * 2, the interval in ms.
+	minSto := (1 + float64(p.Latency)) * float64(p.IntervalMax) * 1.25 * 2
+	switch {
+	case p.IntervalMin < ConnIntervalMin || p.IntervalMin > ConnIntervalMax:
+		return fmt.Errorf("invalid IntervalMin %v", p.IntervalMin)
+	case p.IntervalMax < ConnIntervalMin || p.IntervalMax > ConnIntervalMax:
+		return fmt.Errorf("invalid IntervalMax %v", p.IntervalMax)
+	case p.IntervalMin > p.IntervalMax:
+		return fmt.Errorf("IntervalMin %v > IntervalMax %v", p.IntervalMin, p.IntervalMax)
+	case p.Latency > ConnLatencyMax:
+		return fmt.Errorf("invalid Latency %v", p.Latency)
+	case p.Timeout < SupervisionTimeoutMin || p.Timeout > SupervisionTimeoutMax:
+		return fmt.Errorf("invalid Timeout %v", p.Timeout)
+	case float64(p.Timeout)*10 <= minSto:
+		return fmt.Errorf("invalid Timeout %v (too small)", p.Timeout)
+	}
+	return nil
+}
+
+// RequestConnParams asks the central for the connection parameters p with
+// a Connection Parameter Update Request [Vol 3, Part A, 4.20]. It returns
+// ble.ErrConnParamsRejected if the central rejected them. Once accepted,
+// the central updates the connection, possibly with other parameters in the
+// range. It's only available in the peripheral role.
+func (c *Conn) RequestConnParams(p ble.ConnParams) error {
+	if c.param.Role() == roleMaster {
+		return fmt.Errorf("connection parameter update requested by the central")
+	}
+	if err := ValidatePreferredConnParams(p); err != nil {
+		return err
+	}
+	var rsp ConnectionParameterUpdateResponse
+	err := c.Signal(&ConnectionParameterUpdateRequest{
+		IntervalMin:       p.IntervalMin,
+		IntervalMax:       p.IntervalMax,
+		SlaveLatency:      p.Latency,
+		TimeoutMultiplier: p.Timeout,
+	}, &rsp)
+	if err != nil {
+		return err
+	}
+	if rsp.Result != 0 {
+		return ble.ErrConnParamsRejected
+	}
+	return nil
+}
+
+// requestPreferredConnParams requests the preferred connection parameters,
+// if any, the configured delay after the connection is established, and
+// reports the outcome.
+func (c *Conn) requestPreferredConnParams() {
+	h := c.hci
+	if h.prefConnParams == nil {
+		return
+	}
+	select {
+	case <-time.After(h.prefConnDelay):
+	case <-c.chDone:
+		return
+	}
+	err := c.RequestConnParams(*h.prefConnParams)
+	if err != nil {
+		c.Infof("preferredConnParams: %v", err)
+	}
+	if h.prefConnResult != nil {
+		h.prefConnResult(c.RemoteAddr(), err)
+	}
+}
+
+// PreferredConnParams returns the connection parameters the peripheral
+// requests, if set.
+func (h *HCI) PreferredConnParams() (ble.ConnParams, bool) {
+	if h.prefConnParams == nil {
+		return ble.ConnParams{}, false
+	}
+	return *h.prefConnParams, true
+}
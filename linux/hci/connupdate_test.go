@@ -0,0 +1,89 @@
+package hci_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/hci/virtual"
+)
+
+func TestPreferredConnParams(t *testing.T) {
+	pref := ble.ConnParams{IntervalMin: 0x0050, IntervalMax: 0x0060, Latency: 4, Timeout: 0x0258}
+	for _, accept := range []bool{true, false} {
+		t.Run(fmt.Sprintf("accept %v", accept), func(t *testing.T) {
+			results := make(chan error, 1)
+			requests := make(chan ble.ConnParams, 1)
+			pair := virtualtest.NewPair(t, []ble.Option{
+				ble.OptPreferredConnParams(pref, 10*time.Millisecond, func(a ble.Addr, err error) {
+					if a.String() != "aa:bb:cc:dd:ee:ff" {
+						t.Errorf("result for %s", a)
+					}
+					results <- err
+				}),
+			}, []ble.Option{
+				ble.OptConnParamsRequestHandler(func(a ble.Addr, req ble.ConnParams) (ble.ConnParams, bool) {
+					requests <- req
+					return req, accept
+				}),
+			})
+			defer pair.Stop()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			cln := pair.Connect(ctx, t)
+			defer cln.CancelConnection()
+
+			select {
+			case req := <-requests:
+				if req != pref {
+					t.Errorf("requested %+v, want %+v", req, pref)
+				}
+			case <-ctx.Done():
+				t.Fatal("no request")
+			}
+			want := ble.ErrConnParamsRejected
+			if accept {
+				want = nil
+			}
+			select {
+			case err := <-results:
+				if err != want {
+					t.Errorf("result %v, want %v", err, want)
+				}
+			case <-ctx.Done():
+				t.Fatal("no result")
+			}
+
+			// The central may read them from the GAP service too.
+			prof, err := cln.DiscoverProfile(true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ch := prof.FindCharacteristic(ble.NewCharacteristic(ble.PeferredParamsUUID))
+			if ch == nil {
+				t.Fatal("preferred connection parameters not discovered")
+			}
+			v, err := cln.ReadCharacteristic(ch)
+			if exp := []byte{0x50, 0, 0x60, 0, 4, 0, 0x58, 0x02}; err != nil || !bytes.Equal(v, exp) {
+				t.Fatalf("read % X, %v, want % X", v, err, exp)
+			}
+		})
+	}
+
+	vc, err := virtual.NewAir().NewController("11:22:33:44:55:66")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := linux.NewDevice(ble.OptTransportVirtual(vc),
+		ble.OptPreferredConnParams(ble.ConnParams{IntervalMin: 6, IntervalMax: 6, Timeout: 1}, 0, nil))
+	if err == nil {
+		d.Stop()
+		t.Fatal("invalid parameters accepted")
+	}
+}
@@ -8,6 +8,7 @@ const (
 	pktTypeACLData uint8 = 0x02
 	pktTypeSCOData uint8 = 0x03
 	pktTypeEvent   uint8 = 0x04
+	pktTypeISOData uint8 = 0x05
 	pktTypeVendor  uint8 = 0xFF
 )
 
@@ -26,6 +27,21 @@ const (
 	CidSMP      uint16 = 0x06 // SecurityManager Protocol [Vol 3, Part H].
 )
 
+// LE event mask bits [Vol 2, Part E, 7.8.1].
+const (
+	leEvtMaskRemoteConnParamsReq  = 1 << 5     // LE Remote Connection Parameter Request event.
+	leEvtMaskEnhancedConnComplete = 1 << 9     // LE Enhanced Connection Complete event.
+	leEvtMaskScanRequestReceived  = 1 << 18    // LE Scan Request Received event.
+	leEvtMaskChannelSelAlgorithm  = 1 << 19    // LE Channel Selection Algorithm event.
+	leEvtMaskISO                  = 0x3F << 24 // LE CIS Established to LE BIG Sync Lost events.
+	leEvtMaskBIGInfo              = 1 << 33    // LE BIGInfo Advertising Report event.
+)
+
+// Event mask page 2 bits [Vol 2, Part E, 7.3.69].
+const (
+	evtMask2AuthPayloadTimeoutExpired = 1 << 23 // Authenticated Payload Timeout Expired event.
+)
+
 const (
 	roleMaster = 0x00
 	roleSlave  = 0x01
@@ -39,6 +55,10 @@ const (
 	chCmdBufTimeout     = time.Second * 5
 )
 
+// dialCancelTimeout bounds the wait for the connection complete event after
+// canceling a Dial.
+const dialCancelTimeout = time.Second
+
 const (
 	ogfBitShift            = 10
 	ogfVendorSpecificDebug = 0x3f
@@ -0,0 +1,112 @@
+package hci
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// deadline is a read or write deadline of a connection. Its channel is
+// closed once it expires, which wakes up the calls waiting on it.
+type deadline struct {
+	mu     sync.Mutex
+	t      time.Time
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+// set sets the deadline to t. The zero time clears it.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // Wait for the timer to close it.
+	}
+	d.timer = nil
+	d.t = t
+
+	if d.cancel == nil || expired(d.cancel) {
+		d.cancel = make(chan struct{})
+	}
+	if t.IsZero() {
+		return
+	}
+	dur := time.Until(t)
+	if dur <= 0 {
+		close(d.cancel)
+		return
+	}
+	cancel := d.cancel
+	d.timer = time.AfterFunc(dur, func() { close(cancel) })
+}
+
+// wait returns a channel which is closed once the deadline expires.
+func (d *deadline) wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel == nil {
+		d.cancel = make(chan struct{})
+	}
+	return d.cancel
+}
+
+// time returns the deadline, or the zero time if there's none.
+func (d *deadline) time() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.t
+}
+
+func expired(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetDeadline sets the read and write deadlines of the connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.rd.set(t)
+	c.wd.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline of Read, which then fails with
+// os.ErrDeadlineExceeded, as a net.Conn does. It bounds the wait for the
+// first fragment of an SDU; once it's received, Read returns the whole SDU.
+// The zero time clears the deadline.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.rd.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of Write, which then fails with
+// os.ErrDeadlineExceeded, as a net.Conn does. It bounds the wait for a
+// controller buffer for the first fragment, on top of the ACL write
+// timeout, as a PDU can't be abandoned once it's partially sent. The zero
+// time clears the deadline.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.wd.set(t)
+	return nil
+}
+
+// writeTimeout returns the time to wait for the buffer of the first fragment
+// of a PDU, with a flag set if it's the time left until the write deadline,
+// or os.ErrDeadlineExceeded if it expired.
+func (c *Conn) writeTimeout() (time.Duration, bool, error) {
+	tmo := c.hci.aclWriteTimeout
+	t := c.wd.time()
+	if t.IsZero() {
+		return tmo, false, nil
+	}
+	left := time.Until(t)
+	if left <= 0 {
+		return 0, false, os.ErrDeadlineExceeded
+	}
+	if tmo == 0 || tmo > left {
+		return left, true, nil
+	}
+	return tmo, false, nil
+}
@@ -0,0 +1,65 @@
+package hci
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// advDedup drops advertisements seen within a window, keyed by address and
+// a hash of the event type and payload. It filters in the host, so reports
+// the controller doesn't filter, e.g. when scanning with allowDup, don't
+// flood the handler.
+type advDedup struct {
+	sync.Mutex
+	window    time.Duration
+	seen      map[advDedupKey]time.Time
+	lastSweep time.Time
+}
+
+type advDedupKey struct {
+	addr string
+	hash uint64
+}
+
+// reset forgets the advertisements seen.
+func (d *advDedup) reset() {
+	d.Lock()
+	d.seen = nil
+	d.Unlock()
+}
+
+// dup reports whether the advertisement was seen within the window, and
+// records it otherwise.
+func (d *advDedup) dup(addr string, et uint8, data, sr []byte, now time.Time) bool {
+	d.Lock()
+	defer d.Unlock()
+	if d.window <= 0 {
+		return false
+	}
+	if d.seen == nil {
+		d.seen = make(map[advDedupKey]time.Time)
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte{et})
+	h.Write(data)
+	h.Write(sr)
+	k := advDedupKey{addr: addr, hash: h.Sum64()}
+	if t, ok := d.seen[k]; ok && now.Sub(t) < d.window {
+		return true
+	}
+	d.seen[k] = now
+
+	// Drop the expired entries once per window, so the map stays bounded by
+	// the advertisements of a window.
+	if now.Sub(d.lastSweep) >= d.window {
+		for k, t := range d.seen {
+			if now.Sub(t) >= d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastSweep = now
+	}
+	return false
+}
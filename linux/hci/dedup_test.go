@@ -0,0 +1,39 @@
+package hci
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdvDedup(t *testing.T) {
+	d := advDedup{window: time.Second}
+	t0 := time.Unix(0, 0)
+	data := []byte{0x02, 0x01, 0x06}
+
+	if d.dup("a", 0, data, nil, t0) {
+		t.Fatal("first advertisement reported as duplicate")
+	}
+	if !d.dup("a", 0, data, nil, t0.Add(500*time.Millisecond)) {
+		t.Fatal("repeated advertisement not filtered")
+	}
+	if d.dup("b", 0, data, nil, t0.Add(500*time.Millisecond)) {
+		t.Fatal("other address filtered")
+	}
+	if d.dup("a", 0, []byte{0x02, 0x01, 0x04}, nil, t0.Add(500*time.Millisecond)) {
+		t.Fatal("changed payload filtered")
+	}
+	if d.dup("a", 0, data, []byte{0x01}, t0.Add(500*time.Millisecond)) {
+		t.Fatal("added scan response filtered")
+	}
+	if d.dup("a", 0, data, nil, t0.Add(1500*time.Millisecond)) {
+		t.Fatal("advertisement filtered after the window expired")
+	}
+	if n := len(d.seen); n != 1 {
+		t.Fatalf("%d entries after sweep, want 1", n)
+	}
+
+	d.window = 0
+	if d.dup("a", 0, data, nil, t0.Add(1500*time.Millisecond)) {
+		t.Fatal("filtered while disabled")
+	}
+}
@@ -0,0 +1,144 @@
+package hci_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/hci"
+	"github.com/leso-kn/ble/linux/hci/bond"
+	"github.com/leso-kn/ble/linux/hci/virtual"
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestRestoreEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bonds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pbm := bond.NewBondManager(filepath.Join(dir, "peripheral.json"))
+	cbm := bond.NewBondManager(filepath.Join(dir, "central.json"))
+
+	pair := virtualtest.NewPair(t, []ble.Option{ble.OptEnableSecurity(pbm)}, []ble.Option{ble.OptEnableSecurity(cbm), ble.OptRestoreEncryption(true)})
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+
+	// Bonds are keyed by the address, least significant octet first.
+	ltk := bytes.Repeat([]byte{0x5A}, 16)
+	if err := cbm.Save("665544332211", hci.NewBondInfo(ltk, 0x1234, 0x56789A, false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pbm.Save("ffeeddccbbaa", hci.NewBondInfo(ltk, 0x1234, 0x56789A, false)); err != nil {
+		t.Fatal(err)
+	}
+	dial := func() (ble.Client, error) {
+		t.Helper()
+		cln, err := c.Dial(ctx, ble.NewAddr(virtualtest.PeripheralAddr))
+		if err == nil {
+			return cln, nil
+		}
+		// Wait for advertising to resume after a failed Dial.
+		for c.State().Connections != 0 || !p.State().Advertising || p.State().AdvertisingPaused {
+			select {
+			case <-ctx.Done():
+				t.Fatal("advertising not resumed")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		return nil, err
+	}
+
+	cln, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, ok := ble.ConnEncryption(cln.Conn()); !ok || !info.Enabled {
+		t.Fatalf("link not encrypted: %+v", info)
+	}
+	cln.CancelConnection()
+	<-cln.Disconnected()
+
+	// The peripheral lost the bond.
+	if err := pbm.Delete("ffeeddccbbaa"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dial(); pkgerrors.Cause(err) != ble.ErrBondMissing {
+		t.Fatalf("dial with a bond missing on the peer: %v", err)
+	}
+	if cbm.Exists("665544332211") {
+		t.Fatal("local bond not deleted")
+	}
+
+	// Without a bond, the link is left unencrypted.
+	cln, err = dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cln.CancelConnection()
+	if info, ok := ble.ConnEncryption(cln.Conn()); ok && info.Enabled {
+		t.Fatal("link encrypted without a bond")
+	}
+}
+
+func TestDialAcceptList(t *testing.T) {
+	air := virtual.NewAir()
+	dev := func(addr string, opts ...ble.Option) *linux.Device {
+		c, err := air.NewController(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := linux.NewDevice(append([]ble.Option{ble.OptTransportVirtual(c)}, opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	p1 := dev("11:11:11:11:11:11")
+	defer p1.Stop()
+	p2 := dev("22:22:22:22:22:22")
+	defer p2.Stop()
+	c := dev("33:33:33:33:33:33", ble.OptInitiatorScan(20*time.Millisecond, 20*time.Millisecond, true))
+	defer c.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p1.AdvertiseNameAndServices(ctx, "Gopher1")
+	go p2.AdvertiseNameAndServices(ctx, "Gopher2")
+	a1 := ble.NewAddr("11:11:11:11:11:11")
+
+	// Only p2 is on the accept list, so the controller connects to it.
+	if err := c.HCI.AddToAcceptList(ble.NewAddr("22:22:22:22:22:22")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Dial(ctx, a1); pkgerrors.Cause(err) != hci.ErrPeerMismatch {
+		t.Fatalf("dialed %v with another peer on the accept list: %v", a1, err)
+	}
+
+	if err := c.HCI.ClearAcceptList(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.HCI.AddToAcceptList(a1); err != nil {
+		t.Fatal(err)
+	}
+	cln, err := c.Dial(ctx, a1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.EqualFold(cln.Addr().String(), a1.String()) {
+		t.Fatalf("connected to %v, want %v", cln.Addr(), a1)
+	}
+	cln.CancelConnection()
+}
@@ -0,0 +1,155 @@
+package hci
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/leso-kn/ble"
+)
+
+// Dump is a snapshot of the state of the HCI, its connections and the
+// commands the controller hasn't answered yet, for support tools and bug
+// reports. Its String method formats it for humans.
+type Dump struct {
+	State
+
+	// Addr is the address of the device.
+	Addr ble.Addr
+
+	// AdvertisingSets are the advertising sets a random address was set
+	// for, by handle.
+	AdvertisingSets []AdvertisingSetDump
+
+	// Connections are the connections, in both roles, by handle.
+	Connections []ConnDump
+
+	// PendingCommands are the commands sent to the controller, waiting for
+	// their Command Complete or Command Status event, by opcode.
+	PendingCommands []CommandDump
+}
+
+// AdvertisingSetDump is the state of an advertising set.
+type AdvertisingSetDump struct {
+	Handle     uint8
+	RandomAddr ble.Addr
+}
+
+// ConnDump is the state of a connection.
+type ConnDump struct {
+	Handle     uint16
+	ID         string
+	RemoteAddr ble.Addr
+
+	ble.ConnInfo
+
+	RxMTU int
+	TxMTU int
+
+	// Encrypted is set while the link is encrypted.
+	Encrypted bool
+
+	// Pairing is the state of the pairing, if the SMP is enabled.
+	Pairing string
+}
+
+// CommandDump is a command waiting for the controller.
+type CommandDump struct {
+	OpCode int
+	Name   string
+}
+
+// DumpState returns a snapshot of the state of the HCI.
+func (h *HCI) DumpState() Dump {
+	d := Dump{State: h.State(), Addr: h.Addr()}
+
+	h.muAdvSetAddrs.Lock()
+	for handle, a := range h.advSetAddrs {
+		d.AdvertisingSets = append(d.AdvertisingSets, AdvertisingSetDump{Handle: handle, RandomAddr: a})
+	}
+	h.muAdvSetAddrs.Unlock()
+	sort.Slice(d.AdvertisingSets, func(i, j int) bool {
+		return d.AdvertisingSets[i].Handle < d.AdvertisingSets[j].Handle
+	})
+
+	h.muConns.Lock()
+	conns := make([]*Conn, 0, len(h.conns))
+	for _, c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.muConns.Unlock()
+	for _, c := range conns {
+		d.Connections = append(d.Connections, c.dump())
+	}
+	sort.Slice(d.Connections, func(i, j int) bool {
+		return d.Connections[i].Handle < d.Connections[j].Handle
+	})
+
+	h.muSent.Lock()
+	for _, p := range h.sent {
+		d.PendingCommands = append(d.PendingCommands, CommandDump{OpCode: p.cmd.OpCode(), Name: p.cmd.String()})
+	}
+	h.muSent.Unlock()
+	sort.Slice(d.PendingCommands, func(i, j int) bool {
+		return d.PendingCommands[i].OpCode < d.PendingCommands[j].OpCode
+	})
+	return d
+}
+
+func (c *Conn) dump() ConnDump {
+	d := ConnDump{
+		Handle:     c.param.ConnectionHandle(),
+		ID:         c.id,
+		RemoteAddr: c.RemoteAddr(),
+		ConnInfo:   c.ConnInfo(),
+		RxMTU:      c.RxMTU(),
+		TxMTU:      c.TxMTU(),
+	}
+	if info, ok := ble.ConnEncryption(c); ok {
+		d.Encrypted = info.Enabled
+	}
+	if c.smp != nil {
+		d.Pairing = c.smp.PairingState()
+	}
+	return d
+}
+
+func (d Dump) String() string {
+	var b strings.Builder
+	onOff := func(on, paused bool) string {
+		switch {
+		case on && paused:
+			return "paused"
+		case on:
+			return "on"
+		}
+		return "off"
+	}
+	fmt.Fprintf(&b, "address %s", d.Addr)
+	if d.Closed {
+		b.WriteString(", closed")
+	}
+	fmt.Fprintf(&b, "\nscanning %s, advertising %s, dialing %t\n",
+		onOff(d.Scanning, d.ScanningPaused), onOff(d.Advertising, d.AdvertisingPaused), d.Dialing)
+	for _, s := range d.AdvertisingSets {
+		fmt.Fprintf(&b, "advertising set %d: random address %s\n", s.Handle, s.RandomAddr)
+	}
+	fmt.Fprintf(&b, "connections: %d\n", len(d.Connections))
+	for _, c := range d.Connections {
+		role := "peripheral"
+		if c.Central {
+			role = "central"
+		}
+		fmt.Fprintf(&b, "  %04X %s %s (%s): MTU rx %d tx %d, interval %v, latency %d, timeout %v, encrypted %t",
+			c.Handle, c.RemoteAddr, role, c.ID, c.RxMTU, c.TxMTU, c.Interval, c.Latency, c.SupervisionTimeout, c.Encrypted)
+		if c.Pairing != "" {
+			fmt.Fprintf(&b, ", pairing %s", c.Pairing)
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "pending commands: %d\n", len(d.PendingCommands))
+	for _, c := range d.PendingCommands {
+		fmt.Fprintf(&b, "  %04X %s\n", c.OpCode, c.Name)
+	}
+	return b.String()
+}
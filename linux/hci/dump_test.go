@@ -0,0 +1,42 @@
+package hci_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble/internal/virtualtest"
+)
+
+func TestDumpState(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	c := pair.Central
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	defer cln.CancelConnection()
+
+	d := c.DumpState()
+	if d.Addr.String() != "aa:bb:cc:dd:ee:ff" || d.Closed {
+		t.Fatalf("dump %+v", d)
+	}
+	if len(d.Connections) != 1 {
+		t.Fatalf("%d connections dumped, want 1", len(d.Connections))
+	}
+	conn := d.Connections[0]
+	if !conn.Central || conn.RemoteAddr.String() != "11:22:33:44:55:66" || conn.Encrypted {
+		t.Fatalf("connection %+v", conn)
+	}
+	if conn.RxMTU != cln.Conn().RxMTU() || conn.TxMTU != cln.Conn().TxMTU() {
+		t.Fatalf("MTU rx %d tx %d, want %d %d", conn.RxMTU, conn.TxMTU, cln.Conn().RxMTU(), cln.Conn().TxMTU())
+	}
+	s := d.String()
+	for _, want := range []string{"address aa:bb:cc:dd:ee:ff", "connections: 1", "11:22:33:44:55:66 central", "pending commands: "} {
+		if !strings.Contains(s, want) {
+			t.Errorf("dump %q doesn't contain %q", s, want)
+		}
+	}
+}
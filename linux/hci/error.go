@@ -9,6 +9,8 @@ var (
 	ErrBusyDialing     = errors.New("busy dialing")
 	ErrBusyListening   = errors.New("busy listening")
 	ErrInvalidAddr     = errors.New("invalid address")
+	ErrCentralOnly     = errors.New("central-only device")
+	ErrPeerMismatch    = errors.New("connected to another peer")
 )
 
 // HCI Command Errors  [Vol2, Part D, 1.3 ]
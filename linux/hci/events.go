@@ -0,0 +1,48 @@
+package hci
+
+// EventHandler handles an HCI event. It's called from the event loop with the
+// event parameters, and must not block or send HCI commands synchronously.
+type EventHandler func(b []byte) error
+
+// SetEventHandler registers f for HCI events with the given event code,
+// including vendor specific events (0xFF). Events the hci package handles
+// itself are passed to f afterwards, except Command Complete and Command
+// Status. A nil f removes the handler.
+// Events which aren't unmasked by default need to be enabled with
+// ble.OptEventMask.
+func (h *HCI) SetEventHandler(code int, f EventHandler) {
+	h.muEvth.Lock()
+	defer h.muEvth.Unlock()
+	if f == nil {
+		delete(h.usrEvth, code)
+		return
+	}
+	h.usrEvth[code] = f
+}
+
+// SetLEEventHandler registers f for LE Meta events with the given subevent
+// code. f receives the event parameters starting with the subevent code.
+// A nil f removes the handler.
+func (h *HCI) SetLEEventHandler(subcode int, f EventHandler) {
+	h.muEvth.Lock()
+	defer h.muEvth.Unlock()
+	if f == nil {
+		delete(h.usrSubh, subcode)
+		return
+	}
+	h.usrSubh[subcode] = f
+}
+
+// userEventHandler returns the user handler for an event code, if any.
+func (h *HCI) userEventHandler(code int) EventHandler {
+	h.muEvth.RLock()
+	defer h.muEvth.RUnlock()
+	return h.usrEvth[code]
+}
+
+// userLEEventHandler returns the user handler for an LE subevent code, if any.
+func (h *HCI) userLEEventHandler(subcode int) EventHandler {
+	h.muEvth.RLock()
+	defer h.muEvth.RUnlock()
+	return h.usrSubh[subcode]
+}
@@ -228,6 +228,89 @@ func (r AuthenticatedPayloadTimeoutExpired) ConnectionHandle() uint16 {
 	return binary.LittleEndian.Uint16(r[0:])
 }
 
+const LEEnhancedConnectionCompleteCode = 0x3E
+
+const LEEnhancedConnectionCompleteSubCode = 0x0A
+
+// LEEnhancedConnectionComplete implements LE Enhanced Connection Complete (0x3E:0x0A) [Vol 2, Part E, 7.7.65.10].
+type LEEnhancedConnectionComplete []byte
+
+func (r LEEnhancedConnectionComplete) SubeventCode() uint8 { return r[0] }
+
+func (r LEEnhancedConnectionComplete) Status() uint8 { return r[1] }
+
+func (r LEEnhancedConnectionComplete) ConnectionHandle() uint16 {
+	return binary.LittleEndian.Uint16(r[2:])
+}
+
+func (r LEEnhancedConnectionComplete) Role() uint8 { return r[4] }
+
+func (r LEEnhancedConnectionComplete) PeerAddressType() uint8 { return r[5] }
+
+func (r LEEnhancedConnectionComplete) PeerAddress() [6]byte {
+	b := [6]byte{}
+	copy(b[:], r[6:])
+	return b
+}
+
+func (r LEEnhancedConnectionComplete) LocalResolvablePrivateAddress() [6]byte {
+	b := [6]byte{}
+	copy(b[:], r[12:])
+	return b
+}
+
+func (r LEEnhancedConnectionComplete) PeerResolvablePrivateAddress() [6]byte {
+	b := [6]byte{}
+	copy(b[:], r[18:])
+	return b
+}
+
+func (r LEEnhancedConnectionComplete) ConnInterval() uint16 {
+	return binary.LittleEndian.Uint16(r[24:])
+}
+
+func (r LEEnhancedConnectionComplete) ConnLatency() uint16 { return binary.LittleEndian.Uint16(r[26:]) }
+
+func (r LEEnhancedConnectionComplete) SupervisionTimeout() uint16 {
+	return binary.LittleEndian.Uint16(r[28:])
+}
+
+func (r LEEnhancedConnectionComplete) MasterClockAccuracy() uint8 { return r[30] }
+
+const LEScanRequestReceivedCode = 0x3E
+
+const LEScanRequestReceivedSubCode = 0x13
+
+// LEScanRequestReceived implements LE Scan Request Received (0x3E:0x13) [Vol 2, Part E, 7.7.65.19].
+type LEScanRequestReceived []byte
+
+func (r LEScanRequestReceived) SubeventCode() uint8 { return r[0] }
+
+func (r LEScanRequestReceived) AdvertisingHandle() uint8 { return r[1] }
+
+func (r LEScanRequestReceived) ScannerAddressType() uint8 { return r[2] }
+
+func (r LEScanRequestReceived) ScannerAddress() [6]byte {
+	b := [6]byte{}
+	copy(b[:], r[3:])
+	return b
+}
+
+const LEChannelSelectionAlgorithmCode = 0x3E
+
+const LEChannelSelectionAlgorithmSubCode = 0x14
+
+// LEChannelSelectionAlgorithm implements LE Channel Selection Algorithm (0x3E:0x14) [Vol 2, Part E, 7.7.65.20].
+type LEChannelSelectionAlgorithm []byte
+
+func (r LEChannelSelectionAlgorithm) SubeventCode() uint8 { return r[0] }
+
+func (r LEChannelSelectionAlgorithm) ConnectionHandle() uint16 {
+	return binary.LittleEndian.Uint16(r[1:])
+}
+
+func (r LEChannelSelectionAlgorithm) ChannelSelectionAlgorithm() uint8 { return r[3] }
+
 const VendorEventCode = 0xff
 
 type VendorEvent []byte
@@ -0,0 +1,167 @@
+package evt
+
+import "encoding/binary"
+
+// The isochronous events have 24-bit fields and variable length lists, which
+// the generator doesn't handle, so they are written by hand.
+
+func uint24(b []byte) uint32 { return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 }
+
+const LECISEstablishedSubCode = 0x19
+
+// LECISEstablished implements LE CIS Established (0x3E:0x19) [Vol 2, Part E, 7.7.65.25].
+type LECISEstablished []byte
+
+func (r LECISEstablished) SubeventCode() uint8 { return r[0] }
+
+func (r LECISEstablished) Status() uint8 { return r[1] }
+
+func (r LECISEstablished) ConnectionHandle() uint16 { return binary.LittleEndian.Uint16(r[2:]) }
+
+func (r LECISEstablished) CIGSyncDelay() uint32 { return uint24(r[4:]) }
+
+func (r LECISEstablished) CISSyncDelay() uint32 { return uint24(r[7:]) }
+
+func (r LECISEstablished) TransportLatencyCToP() uint32 { return uint24(r[10:]) }
+
+func (r LECISEstablished) TransportLatencyPToC() uint32 { return uint24(r[13:]) }
+
+func (r LECISEstablished) PHYCToP() uint8 { return r[16] }
+
+func (r LECISEstablished) PHYPToC() uint8 { return r[17] }
+
+func (r LECISEstablished) NSE() uint8 { return r[18] }
+
+func (r LECISEstablished) BNCToP() uint8 { return r[19] }
+
+func (r LECISEstablished) BNPToC() uint8 { return r[20] }
+
+func (r LECISEstablished) FTCToP() uint8 { return r[21] }
+
+func (r LECISEstablished) FTPToC() uint8 { return r[22] }
+
+func (r LECISEstablished) MaxPDUCToP() uint16 { return binary.LittleEndian.Uint16(r[23:]) }
+
+func (r LECISEstablished) MaxPDUPToC() uint16 { return binary.LittleEndian.Uint16(r[25:]) }
+
+func (r LECISEstablished) ISOInterval() uint16 { return binary.LittleEndian.Uint16(r[27:]) }
+
+const LECISRequestSubCode = 0x1A
+
+// LECISRequest implements LE CIS Request (0x3E:0x1A) [Vol 2, Part E, 7.7.65.26].
+type LECISRequest []byte
+
+func (r LECISRequest) SubeventCode() uint8 { return r[0] }
+
+func (r LECISRequest) ACLConnectionHandle() uint16 { return binary.LittleEndian.Uint16(r[1:]) }
+
+func (r LECISRequest) CISConnectionHandle() uint16 { return binary.LittleEndian.Uint16(r[3:]) }
+
+func (r LECISRequest) CIGID() uint8 { return r[5] }
+
+func (r LECISRequest) CISID() uint8 { return r[6] }
+
+const LECreateBIGCompleteSubCode = 0x1B
+
+// LECreateBIGComplete implements LE Create BIG Complete (0x3E:0x1B) [Vol 2, Part E, 7.7.65.27].
+type LECreateBIGComplete []byte
+
+func (r LECreateBIGComplete) SubeventCode() uint8 { return r[0] }
+
+func (r LECreateBIGComplete) Status() uint8 { return r[1] }
+
+func (r LECreateBIGComplete) BIGHandle() uint8 { return r[2] }
+
+func (r LECreateBIGComplete) BIGSyncDelay() uint32 { return uint24(r[3:]) }
+
+func (r LECreateBIGComplete) TransportLatencyBIG() uint32 { return uint24(r[6:]) }
+
+func (r LECreateBIGComplete) PHY() uint8 { return r[9] }
+
+func (r LECreateBIGComplete) MaxPDU() uint16 { return binary.LittleEndian.Uint16(r[14:]) }
+
+func (r LECreateBIGComplete) ISOInterval() uint16 { return binary.LittleEndian.Uint16(r[16:]) }
+
+func (r LECreateBIGComplete) NumBIS() uint8 { return r[18] }
+
+func (r LECreateBIGComplete) ConnectionHandle(i int) uint16 {
+	return binary.LittleEndian.Uint16(r[19+2*i:])
+}
+
+const LETerminateBIGCompleteSubCode = 0x1C
+
+// LETerminateBIGComplete implements LE Terminate BIG Complete (0x3E:0x1C) [Vol 2, Part E, 7.7.65.28].
+type LETerminateBIGComplete []byte
+
+func (r LETerminateBIGComplete) SubeventCode() uint8 { return r[0] }
+
+func (r LETerminateBIGComplete) BIGHandle() uint8 { return r[1] }
+
+func (r LETerminateBIGComplete) Reason() uint8 { return r[2] }
+
+const LEBIGSyncEstablishedSubCode = 0x1D
+
+// LEBIGSyncEstablished implements LE BIG Sync Established (0x3E:0x1D) [Vol 2, Part E, 7.7.65.29].
+type LEBIGSyncEstablished []byte
+
+func (r LEBIGSyncEstablished) SubeventCode() uint8 { return r[0] }
+
+func (r LEBIGSyncEstablished) Status() uint8 { return r[1] }
+
+func (r LEBIGSyncEstablished) BIGHandle() uint8 { return r[2] }
+
+func (r LEBIGSyncEstablished) TransportLatencyBIG() uint32 { return uint24(r[3:]) }
+
+func (r LEBIGSyncEstablished) MaxPDU() uint16 { return binary.LittleEndian.Uint16(r[10:]) }
+
+func (r LEBIGSyncEstablished) ISOInterval() uint16 { return binary.LittleEndian.Uint16(r[12:]) }
+
+func (r LEBIGSyncEstablished) NumBIS() uint8 { return r[14] }
+
+func (r LEBIGSyncEstablished) ConnectionHandle(i int) uint16 {
+	return binary.LittleEndian.Uint16(r[15+2*i:])
+}
+
+const LEBIGSyncLostSubCode = 0x1E
+
+// LEBIGSyncLost implements LE BIG Sync Lost (0x3E:0x1E) [Vol 2, Part E, 7.7.65.30].
+type LEBIGSyncLost []byte
+
+func (r LEBIGSyncLost) SubeventCode() uint8 { return r[0] }
+
+func (r LEBIGSyncLost) BIGHandle() uint8 { return r[1] }
+
+func (r LEBIGSyncLost) Reason() uint8 { return r[2] }
+
+const LEBIGInfoAdvertisingReportSubCode = 0x22
+
+// LEBIGInfoAdvertisingReport implements LE BIGInfo Advertising Report (0x3E:0x22) [Vol 4, Part E, 7.7.65.34].
+type LEBIGInfoAdvertisingReport []byte
+
+func (r LEBIGInfoAdvertisingReport) SubeventCode() uint8 { return r[0] }
+
+func (r LEBIGInfoAdvertisingReport) SyncHandle() uint16 { return binary.LittleEndian.Uint16(r[1:]) }
+
+func (r LEBIGInfoAdvertisingReport) NumBIS() uint8 { return r[3] }
+
+func (r LEBIGInfoAdvertisingReport) NSE() uint8 { return r[4] }
+
+func (r LEBIGInfoAdvertisingReport) ISOInterval() uint16 { return binary.LittleEndian.Uint16(r[5:]) }
+
+func (r LEBIGInfoAdvertisingReport) BN() uint8 { return r[7] }
+
+func (r LEBIGInfoAdvertisingReport) PTO() uint8 { return r[8] }
+
+func (r LEBIGInfoAdvertisingReport) IRC() uint8 { return r[9] }
+
+func (r LEBIGInfoAdvertisingReport) MaxPDU() uint16 { return binary.LittleEndian.Uint16(r[10:]) }
+
+func (r LEBIGInfoAdvertisingReport) SDUInterval() uint32 { return uint24(r[12:]) }
+
+func (r LEBIGInfoAdvertisingReport) MaxSDU() uint16 { return binary.LittleEndian.Uint16(r[15:]) }
+
+func (r LEBIGInfoAdvertisingReport) PHY() uint8 { return r[17] }
+
+func (r LEBIGInfoAdvertisingReport) Framing() uint8 { return r[18] }
+
+func (r LEBIGInfoAdvertisingReport) Encryption() uint8 { return r[19] }
@@ -0,0 +1,65 @@
+package evt
+
+import "fmt"
+
+// names are the names of the events [Vol 4, Part E, 7.7].
+var names = map[uint8]string{
+	0x05: "Disconnection Complete",
+	0x08: "Encryption Change",
+	0x0C: "Read Remote Version Information Complete",
+	0x0E: "Command Complete",
+	0x0F: "Command Status",
+	0x10: "Hardware Error",
+	0x13: "Number Of Completed Packets",
+	0x1A: "Data Buffer Overflow",
+	0x30: "Encryption Key Refresh Complete",
+	0x3E: "LE Meta",
+	0x57: "Authenticated Payload Timeout Expired",
+	0xFF: "Vendor Specific",
+}
+
+// leNames are the names of the LE Meta subevents [Vol 4, Part E, 7.7.65].
+var leNames = map[uint8]string{
+	0x01: "LE Connection Complete",
+	0x02: "LE Advertising Report",
+	0x03: "LE Connection Update Complete",
+	0x04: "LE Read Remote Features Complete",
+	0x05: "LE Long Term Key Request",
+	0x06: "LE Remote Connection Parameter Request",
+	0x07: "LE Data Length Change",
+	0x08: "LE Read Local P-256 Public Key Complete",
+	0x09: "LE Generate DHKey Complete",
+	0x0A: "LE Enhanced Connection Complete",
+	0x0B: "LE Directed Advertising Report",
+	0x0C: "LE PHY Update Complete",
+	0x0D: "LE Extended Advertising Report",
+	0x0E: "LE Periodic Advertising Sync Established",
+	0x0F: "LE Periodic Advertising Report",
+	0x10: "LE Periodic Advertising Sync Lost",
+	0x11: "LE Scan Timeout",
+	0x12: "LE Advertising Set Terminated",
+	0x13: "LE Scan Request Received",
+	0x14: "LE Channel Selection Algorithm",
+	0x19: "LE CIS Established",
+	0x1A: "LE CIS Request",
+	0x1B: "LE Create BIG Complete",
+	0x1C: "LE Terminate BIG Complete",
+	0x1D: "LE BIG Sync Established",
+	0x1E: "LE BIG Sync Lost",
+	0x22: "LE BIGInfo Advertising Report",
+}
+
+// Name returns the name of the event with the code, or of the LE Meta
+// subevent with the subcode.
+func Name(code, subcode uint8) string {
+	if code == 0x3E {
+		if n, ok := leNames[subcode]; ok {
+			return n
+		}
+		return fmt.Sprintf("LE Meta 0x%02X", subcode)
+	}
+	if n, ok := names[code]; ok {
+		return n
+	}
+	return fmt.Sprintf("Event 0x%02X", code)
+}
@@ -0,0 +1,88 @@
+package hci
+
+import (
+	"fmt"
+
+	"github.com/leso-kn/ble/linux/hci/cmd"
+)
+
+// PHYs for LE Extended Create Connection [Vol 2, Part E, 7.8.66].
+const (
+	PHY1M    = 0x01
+	PHY2M    = 0x02
+	PHYCoded = 0x04
+)
+
+// ValidateExtConnParams checks the parameters of LE Extended Create
+// Connection. The peer address is filled in by Dial and isn't checked.
+func ValidateExtConnParams(p cmd.LEExtendedCreateConnection) error {
+	n := 0
+	for phy := uint8(PHY1M); phy <= PHYCoded; phy <<= 1 {
+		if p.InitiatingPHYs&phy != 0 {
+			n++
+		}
+	}
+
+	switch {
+	case p.InitiatingPHYs == 0 || p.InitiatingPHYs&^(PHY1M|PHY2M|PHYCoded) != 0:
+		return fmt.Errorf("invalid InitiatingPHYs %v", p.InitiatingPHYs)
+
+	case p.InitiatingPHYs == PHY2M:
+		// LE 2M can't be used for scanning, so can't initiate on its own.
+		return fmt.Errorf("InitiatingPHYs %v: LE 2M requires another PHY", p.InitiatingPHYs)
+
+	case n != len(p.PHYs):
+		return fmt.Errorf("%v PHYs initiating, but %v parameter sets", n, len(p.PHYs))
+
+	case p.InitiatorFilterPolicy != FilterPolicyAcceptAll && p.InitiatorFilterPolicy != FilterPolicyAcceptWhitelist:
+		return fmt.Errorf("invalid InitiatorFilterPolicy %v", p.InitiatorFilterPolicy)
+
+	case p.OwnAddressType > AddressTypeRPAOrRandom:
+		return fmt.Errorf("invalid OwnAddressType %v", p.OwnAddressType)
+	}
+
+	for i, pp := range p.PHYs {
+		// The per-PHY parameters have the same constraints as the legacy ones.
+		err := ValidateConnParams(cmd.LECreateConnection{
+			LEScanInterval:     pp.ScanInterval,
+			LEScanWindow:       pp.ScanWindow,
+			ConnIntervalMin:    pp.ConnIntervalMin,
+			ConnIntervalMax:    pp.ConnIntervalMax,
+			ConnLatency:        pp.ConnLatency,
+			SupervisionTimeout: pp.SupervisionTimeout,
+			MinimumCELength:    pp.MinimumCELength,
+			MaximumCELength:    pp.MaximumCELength,
+		})
+		if err != nil {
+			return fmt.Errorf("PHY parameters %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// ExtConnParams returns LE Extended Create Connection parameters initiating
+// on the given PHYs, with the scan and connection parameters of the legacy
+// connection parameters p for each of them.
+func ExtConnParams(phys uint8, p cmd.LECreateConnection) cmd.LEExtendedCreateConnection {
+	e := cmd.LEExtendedCreateConnection{
+		InitiatorFilterPolicy: p.InitiatorFilterPolicy,
+		OwnAddressType:        p.OwnAddressType,
+		InitiatingPHYs:        phys,
+	}
+	for phy := uint8(PHY1M); phy <= PHYCoded; phy <<= 1 {
+		if phys&phy == 0 {
+			continue
+		}
+		e.PHYs = append(e.PHYs, cmd.LEExtendedCreateConnectionPHY{
+			ScanInterval:       p.LEScanInterval,
+			ScanWindow:         p.LEScanWindow,
+			ConnIntervalMin:    p.ConnIntervalMin,
+			ConnIntervalMax:    p.ConnIntervalMax,
+			ConnLatency:        p.ConnLatency,
+			SupervisionTimeout: p.SupervisionTimeout,
+			MinimumCELength:    p.MinimumCELength,
+			MaximumCELength:    p.MaximumCELength,
+		})
+	}
+	return e
+}
@@ -0,0 +1,41 @@
+package hci
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExtConnParams(t *testing.T) {
+	var p params
+	p.init()
+
+	e := ExtConnParams(PHY1M|PHYCoded, p.connParams)
+	if len(e.PHYs) != 2 {
+		t.Fatalf("got %d PHY parameter sets, want 2", len(e.PHYs))
+	}
+	if err := ValidateExtConnParams(e); err != nil {
+		t.Fatal(err)
+	}
+
+	e.PeerAddress = [6]byte{1, 2, 3, 4, 5, 6}
+	b := make([]byte, e.Len())
+	if err := e.Marshal(b); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x00, 0x00, 0x00, 1, 2, 3, 4, 5, 6, 0x05}
+	if !bytes.Equal(b[:10], want) {
+		t.Fatalf("header % X, want % X", b[:10], want)
+	}
+	// Scan interval of the first PHY, little endian.
+	if b[10] != 0x40 || b[11] != 0x00 {
+		t.Fatalf("first PHY parameters % X", b[10:26])
+	}
+
+	if ValidateExtConnParams(ExtConnParams(PHY2M, p.connParams)) == nil {
+		t.Error("expected error initiating on LE 2M only")
+	}
+	e.PHYs = e.PHYs[:1]
+	if ValidateExtConnParams(e) == nil {
+		t.Error("expected error for missing PHY parameters")
+	}
+}
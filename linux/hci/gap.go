@@ -1,7 +1,9 @@
 package hci
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"net"
 	"time"
@@ -9,14 +11,20 @@ import (
 	"github.com/leso-kn/ble"
 	"github.com/leso-kn/ble/linux/adv"
 	"github.com/leso-kn/ble/linux/gatt"
-	"github.com/leso-kn/ble/sliceops"
+	"github.com/leso-kn/ble/linux/hci/cmd"
 	"github.com/pkg/errors"
 )
 
-// Addr ...
-func (h *HCI) Addr() ble.Addr { return ble.NewAddr(h.addr.String()) }
+// Addr returns the address of the local device. This is the random static
+// address, if one is configured, and the public address otherwise.
+func (h *HCI) Addr() ble.Addr {
+	if h.randomAddr != nil {
+		return ble.NewAddr(h.randomAddr.String())
+	}
+	return ble.NewAddr(h.addr.String())
+}
 func (h *HCI) Bytes() []byte {
-	return ble.NewAddr(h.addr.String()).Bytes()
+	return h.Addr().Bytes()
 }
 
 // SetAdvHandler ...
@@ -27,20 +35,95 @@ func (h *HCI) SetAdvHandler(ah ble.AdvHandler) error {
 
 // Scan starts scanning.
 func (h *HCI) Scan(allowDup bool) error {
-	h.params.scanEnable.FilterDuplicates = 1
+	h.muScan.Lock()
+	defer h.muScan.Unlock()
+	dup := uint8(1)
 	if allowDup {
-		h.params.scanEnable.FilterDuplicates = 0
+		dup = 0
 	}
+	if h.params.scanEnable.LEScanEnable == 1 {
+		if h.params.scanEnable.FilterDuplicates == dup {
+			return nil
+		}
+		// Restarted with the new filter.
+		if !h.stopScanSchedule() {
+			if err := h.Send(&cmd.LESetScanEnable{LEScanEnable: 0}, nil); err != nil {
+				return h.busyErr(err)
+			}
+		}
+		h.stopAggregation()
+		h.stopStitching()
+		h.params.scanEnable.LEScanEnable = 0
+		h.setScanning(false)
+	}
+	if err := h.applyScanParams(); err != nil {
+		return err
+	}
+	h.params.scanEnable.FilterDuplicates = dup
 	h.params.scanEnable.LEScanEnable = 1
+	if h.resolver != nil {
+		if err := h.resolver.load(h.bondManager); err != nil {
+			h.Warnf("scan: load identities: %v", err)
+		}
+	}
 	h.adHist = make([]*Advertisement, 128)
 	h.adLast = 0
-	return h.Send(&h.params.scanEnable, nil)
+	h.advDedup.reset()
+	if err := h.Send(&h.params.scanEnable, nil); err != nil {
+		h.params.scanEnable.LEScanEnable = 0
+		return h.busyErr(err)
+	}
+	h.setScanning(true)
+	h.startAggregation()
+	h.params.Lock()
+	active := h.params.scanParams.LEScanType == LEScanTypeActive
+	h.params.Unlock()
+	h.startStitching(active)
+	h.startScanSchedule()
+	return nil
 }
 
-// StopScanning stops scanning.
+// applyScanParams sends the scan parameters to the controller, if they were
+// changed since they were last sent.
+func (h *HCI) applyScanParams() error {
+	h.params.Lock()
+	defer h.params.Unlock()
+	if !h.params.scanParamsDirty {
+		return nil
+	}
+	// Parameters can't be changed while scanning.
+	if h.params.scanEnable.LEScanEnable == 1 {
+		if err := h.Send(&cmd.LESetScanEnable{LEScanEnable: 0}, nil); err != nil {
+			return errors.Wrap(err, "can't stop scanning")
+		}
+	}
+	if err := h.Send(&h.params.scanParams, nil); err != nil {
+		return errors.Wrap(err, "can't set scan parameters")
+	}
+	h.params.scanParamsDirty = false
+	return nil
+}
+
+// StopScanning stops scanning. It does nothing if the HCI isn't scanning, or
+// is closed.
 func (h *HCI) StopScanning() error {
+	h.muScan.Lock()
+	defer h.muScan.Unlock()
+	h.stopAggregation()
+	h.stopStitching()
+	paused := h.stopScanSchedule()
+	if h.params.scanEnable.LEScanEnable == 0 || paused || !h.isOpen() {
+		h.params.scanEnable.LEScanEnable = 0
+		h.setScanning(false)
+		return nil
+	}
 	h.params.scanEnable.LEScanEnable = 0
-	return h.Send(&h.params.scanEnable, nil)
+	if err := h.Send(&h.params.scanEnable, nil); err != nil {
+		h.params.scanEnable.LEScanEnable = 1
+		return err
+	}
+	h.setScanning(false)
+	return nil
 }
 
 // AdvertiseAdv advertises a given Advertisement
@@ -81,8 +164,9 @@ func (h *HCI) AdvertiseAdv(a ble.Advertisement) error {
 		case sr.Append(manufacuturerData) == nil:
 		}
 	}
+	h.appendTxPower(ad, sr)
 	if err := h.SetAdvertisement(ad.Bytes(), sr.Bytes()); err != nil {
-		return nil
+		return err
 	}
 	return h.Advertise()
 
@@ -120,8 +204,9 @@ func (h *HCI) AdvertiseNameAndServices(name string, uuids ...ble.UUID) error {
 	case sr.Append(adv.CompleteName(name)) == nil:
 	case sr.Append(adv.ShortName(name)) == nil:
 	}
+	h.appendTxPower(ad, sr)
 	if err := h.SetAdvertisement(ad.Bytes(), sr.Bytes()); err != nil {
-		return nil
+		return err
 	}
 	return h.Advertise()
 }
@@ -133,7 +218,7 @@ func (h *HCI) AdvertiseMfgData(id uint16, md []byte) error {
 		return err
 	}
 	if err := h.SetAdvertisement(ad.Bytes(), nil); err != nil {
-		return nil
+		return err
 	}
 	return h.Advertise()
 }
@@ -145,7 +230,29 @@ func (h *HCI) AdvertiseServiceData16(id uint16, b []byte) error {
 		return err
 	}
 	if err := h.SetAdvertisement(ad.Bytes(), nil); err != nil {
-		return nil
+		return err
+	}
+	return h.Advertise()
+}
+
+// AdvertiseServiceData advertises data associated with a 16, 32, or 128bit
+// service uuid.
+func (h *HCI) AdvertiseServiceData(u ble.UUID, b []byte) error {
+	var f adv.Field
+	switch u.Len() {
+	case 2:
+		f = adv.ServiceData16(binary.LittleEndian.Uint16(u), b)
+	case 4:
+		f = adv.ServiceData32(binary.LittleEndian.Uint32(u), b)
+	default:
+		f = adv.ServiceData128(u, b)
+	}
+	ad, err := adv.NewPacket(f)
+	if err != nil {
+		return err
+	}
+	if err := h.SetAdvertisement(ad.Bytes(), nil); err != nil {
+		return err
 	}
 	return h.Advertise()
 }
@@ -157,7 +264,7 @@ func (h *HCI) AdvertiseIBeaconData(md []byte) error {
 		return err
 	}
 	if err := h.SetAdvertisement(ad.Bytes(), nil); err != nil {
-		return nil
+		return err
 	}
 	return h.Advertise()
 }
@@ -169,34 +276,175 @@ func (h *HCI) AdvertiseIBeacon(u ble.UUID, major, minor uint16, pwr int8) error
 		return err
 	}
 	if err := h.SetAdvertisement(ad.Bytes(), nil); err != nil {
-		return nil
+		return err
+	}
+	return h.Advertise()
+}
+
+// AdvertiseData advertises the given advertising data and scan response data.
+func (h *HCI) AdvertiseData(ad, sr []byte) error {
+	if err := h.SetAdvertisement(ad, sr); err != nil {
+		return err
+	}
+	return h.Advertise()
+}
+
+// AdvertiseAltBeacon advertises an AltBeacon.
+func (h *HCI) AdvertiseAltBeacon(d adv.AltBeaconData) error {
+	ad, err := adv.NewPacket(adv.Flags(adv.FlagGeneralDiscoverable|adv.FlagLEOnly), adv.AltBeacon(d))
+	if err != nil {
+		return err
+	}
+	if err := h.SetAdvertisement(ad.Bytes(), nil); err != nil {
+		return err
+	}
+	return h.Advertise()
+}
+
+// AdvertiseEddystone advertises an Eddystone frame.
+func (h *HCI) AdvertiseEddystone(f adv.EddystoneFrame) error {
+	ad, err := adv.NewPacket(adv.Flags(adv.FlagGeneralDiscoverable|adv.FlagLEOnly), adv.Eddystone(f))
+	if err != nil {
+		return err
+	}
+	if err := h.SetAdvertisement(ad.Bytes(), nil); err != nil {
+		return err
 	}
 	return h.Advertise()
 }
 
 // StopAdvertising stops advertising.
 func (h *HCI) StopAdvertising() error {
+	h.muAdv.Lock()
+	defer h.muAdv.Unlock()
+	defer h.syncAdvState()
+	// The controller already stopped, if advertising is paused.
+	on := h.params.advEnable.AdvertisingEnable == 1 && !h.advStopped
 	h.params.advEnable.AdvertisingEnable = 0
-	return h.Send(&h.params.advEnable, nil)
+	h.advStopped = false
+	if !on || !h.isOpen() {
+		return nil
+	}
+	if err := h.Send(&h.params.advEnable, nil); err != nil {
+		h.params.advEnable.AdvertisingEnable = 1
+		return err
+	}
+	return nil
+}
+
+// advertisingStopped is called when the controller stopped advertising on
+// its own, as it does when it accepts a connection. Advertising is resumed,
+// unless the user stopped it in the meantime.
+func (h *HCI) advertisingStopped() {
+	h.muAdv.Lock()
+	if h.advNoRestart {
+		// Left stopped, as if StopAdvertising was called.
+		h.params.advEnable.AdvertisingEnable = 0
+	}
+	h.advStopped = h.params.advEnable.AdvertisingEnable == 1
+	h.syncAdvState()
+	h.muAdv.Unlock()
+	h.resumeAdvertising()
+}
+
+// peripheralConns returns the number of connections in the peripheral role.
+func (h *HCI) peripheralConns() int {
+	h.muConns.Lock()
+	defer h.muConns.Unlock()
+	n := 0
+	for _, c := range h.conns {
+		if c.param.Role() == roleSlave {
+			n++
+		}
+	}
+	return n
+}
+
+// resumeAdvertising re-enables advertising, if it was stopped by the
+// controller or paused by Dial. It's held back while advMaxConns centrals are
+// connected, and the controller refuses it while it's at its connection
+// limit, or can't advertise in its current state; it's tried again when a
+// connection drops and when a Dial returns.
+func (h *HCI) resumeAdvertising() {
+	h.muAdv.Lock()
+	defer h.muAdv.Unlock()
+	defer h.syncAdvState()
+	if !h.advStopped || !h.isOpen() {
+		return
+	}
+	if h.advMaxConns > 0 && h.peripheralConns() >= h.advMaxConns {
+		h.Debugf("resume advertising: %d centrals connected", h.advMaxConns)
+		return
+	}
+	if err := h.Send(&h.params.advEnable, nil); err != nil {
+		h.Debugf("resume advertising: %v", err)
+		return
+	}
+	h.advStopped = false
+}
+
+// pauseAdvertising stops advertising for a Dial, on controllers which can't
+// initiate a connection while advertising. It reports whether advertising was
+// paused; it's resumed by resumeAdvertising.
+func (h *HCI) pauseAdvertising() bool {
+	h.muAdv.Lock()
+	defer h.muAdv.Unlock()
+	defer h.syncAdvState()
+	if h.params.advEnable.AdvertisingEnable != 1 || h.advStopped {
+		return false
+	}
+	if err := h.Send(&cmd.LESetAdvertiseEnable{AdvertisingEnable: 0}, nil); err != nil {
+		h.Debugf("pause advertising: %v", err)
+		return false
+	}
+	h.advStopped = true
+	return true
+}
+
+// SetConnWrapper sets f to wrap the connections dialed and accepted from
+// then on, before the GATT client or server uses them. It's meant for tests
+// and tracing, e.g. with fault.Wrap to inject faults in the ATT PDUs. A nil
+// f removes the wrapper.
+func (h *HCI) SetConnWrapper(f func(ble.Conn) ble.Conn) {
+	h.muConns.Lock()
+	defer h.muConns.Unlock()
+	h.connWrapper = f
+}
+
+// wrapConn returns c wrapped by the connection wrapper, if any.
+func (h *HCI) wrapConn(c *Conn) ble.Conn {
+	h.muConns.Lock()
+	f := h.connWrapper
+	h.muConns.Unlock()
+	if f == nil {
+		return c
+	}
+	return f(c)
 }
 
 // Accept starts advertising and accepts connection.
 func (h *HCI) Accept() (ble.Conn, error) {
+	if h.centralOnly {
+		return nil, ErrCentralOnly
+	}
 	var tmo <-chan time.Time
 	if h.listenerTmo != time.Duration(0) {
 		tmo = time.After(h.listenerTmo)
 	}
 	select {
 	case <-h.done:
-		return nil, h.err
+		return nil, h.Error()
 	case c := <-h.chSlaveConn:
-		return c, nil
+		return h.wrapConn(c), nil
 	case <-tmo:
 		return nil, fmt.Errorf("listener timed out")
 	}
 }
 
-// Dial ...
+// Dial connects to the device with the address a. A bonded peer which
+// advertises with resolvable private addresses can be dialed by its identity
+// address, if privacy or host address resolution is enabled; with the latter,
+// it must have been seen scanning since its address last rotated.
 func (h *HCI) Dial(ctx context.Context, a ble.Addr) (ble.Client, error) {
 	_, err := net.ParseMAC(a.String())
 	if err != nil {
@@ -208,18 +456,77 @@ func (h *HCI) Dial(ctx context.Context, a ble.Addr) (ble.Client, error) {
 		return nil, ErrInvalidAddr
 	}
 
+	var pat uint8
 	if _, ok := a.(RandomAddress); ok {
-		h.params.connParams.PeerAddressType = 1
+		pat = 1
+	}
+	pat, ab = h.dialAddr(ab, pat)
+
+	// Work on copies, so the parameters of a single Dial don't replace the
+	// defaults.
+	var c Command
+	var acceptList bool
+	dp, custom := ble.DialParamsFromContext(ctx)
+	if h.params.extConnParams != nil {
+		e := *h.params.extConnParams
+		if custom {
+			applyExtDialParams(&e, dp)
+			if err := ValidateExtConnParams(e); err != nil {
+				return nil, err
+			}
+		}
+		e.PeerAddressType = pat
+		copy(e.PeerAddress[:], ab)
+		acceptList = e.InitiatorFilterPolicy == FilterPolicyAcceptWhitelist
+		c = &e
 	} else {
-		h.params.connParams.PeerAddressType = 0
+		cp := h.params.connParams
+		if custom {
+			applyDialParams(&cp, dp)
+			if err := ValidateConnParams(cp); err != nil {
+				return nil, err
+			}
+		}
+		cp.PeerAddressType = pat
+		copy(cp.PeerAddress[:], ab)
+		acceptList = cp.InitiatorFilterPolicy == FilterPolicyAcceptWhitelist
+		c = &cp
 	}
 
-	ab = sliceops.SwapBuf(ab)
-	copy(h.params.connParams.PeerAddress[:], ab)
+	h.Infof("dial: addr %v, type %v", a.String(), pat)
 
-	h.Infof("dial: addr %v, type %v", a.String(), h.params.connParams.PeerAddressType)
+	select {
+	case h.dialSem <- struct{}{}:
+		defer func() { <-h.dialSem }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-h.done:
+		return nil, h.Error()
+	}
+	h.reapDial()
+	if err := h.checkConnLimit(); err != nil {
+		return nil, err
+	}
 
-	if err = h.Send(&h.params.connParams, nil); err != nil {
+	// Dialing is set before the command is sent, as its completion may be
+	// handled before Send returns, and cleared if it's refused.
+	h.setDialing(true)
+	defer h.setDialing(false)
+	// Advertising which was refused or paused while initiating is resumed
+	// once the Dial is over.
+	defer h.resumeAdvertising()
+	err = h.Send(c, nil)
+	if err == ErrDisallowed && h.pauseAdvertising() {
+		h.Debug("dial: controller can't initiate while advertising, pausing advertising")
+		err = h.Send(c, nil)
+	}
+	if err != nil {
+		h.setDialing(false)
+	}
+	if err == ErrConnLimit {
+		return nil, h.connLimitReached()
+	}
+	if err != nil {
 		return nil, err
 	}
 	var tmo <-chan time.Time
@@ -233,44 +540,262 @@ func (h *HCI) Dial(ctx context.Context, a ble.Addr) (ble.Client, error) {
 	case <-tmo:
 		return h.cancelDial(fmt.Errorf("dialer timeout (%s)", h.dialerTmo))
 	case <-h.done:
-		return nil, h.err
+		return nil, h.Error()
 	case c, ok := <-h.chMasterConn:
 		if !ok {
 			return nil, fmt.Errorf("chMasterConn closed")
 		}
-		return gatt.NewClient(c, h.cache, h.done, h.Logger)
+		// With the accept list policy, the controller ignores the peer
+		// address and connects to any peer of its list.
+		if pa := c.param.PeerAddress(); acceptList && !bytes.Equal(pa[:], ab) {
+			h.Infof("dial: connected to %v instead of %v, disconnecting", c.RemoteAddr(), a)
+			c.Close()
+			return nil, ErrPeerMismatch
+		}
+		cln, err := gatt.NewClientWithWorkers(h.wrapConn(c), h.cache, h.done, h.SampledLogger(ble.LogATT, c.Logger), h.notifWorkers)
+		if err != nil {
+			return nil, err
+		}
+		cln.SetUUIDCompression(!h.fullUUIDs)
+		cln.SetReadCoalescing(h.coalesceReads)
+		cln.SetNotificationOrdering(h.orderNotifs)
+		cln.SetStrictSequential(h.strictSeq)
+		cln.SetResponseTolerance(h.respTolerance)
+		if err := cln.SetRateLimit(h.gattRate, h.gattSpacing); err != nil {
+			cln.CancelConnection()
+			return nil, err
+		}
+		if h.restoreEnc && c.bonded() {
+			if err := c.restoreEncryption(ctx); err != nil {
+				h.Infof("dial: restore encryption: %v", err)
+				cln.CancelConnection()
+				return nil, err
+			}
+		}
+		return cln, nil
+	case err := <-h.chDialErr:
+		if err == ErrConnLimit {
+			return nil, h.connLimitReached()
+		}
+		return nil, errors.Wrap(err, "connection failed")
 	}
 }
 
-// cancelDial cancels the Dialing
+// setDialing marks whether a Dial waits for its connection.
+func (h *HCI) setDialing(dialing bool) {
+	h.muDial.Lock()
+	h.dialing = dialing
+	h.muDial.Unlock()
+}
+
+// dialFailed passes a failed connection attempt of the central role to the
+// Dial whose create connection command is pending.
+func (h *HCI) dialFailed(err error) {
+	h.muDial.Lock()
+	defer h.muDial.Unlock()
+	if !h.dialing {
+		return
+	}
+	select {
+	case h.chDialErr <- err:
+	default:
+	}
+}
+
+// reapDial discards the results of earlier Dials, which completed after they
+// gave up, so they aren't taken for the result of the next one.
+func (h *HCI) reapDial() {
+	select {
+	case c, ok := <-h.chMasterConn:
+		if ok {
+			h.Debugf("dial: closing stale connection %04X", c.param.ConnectionHandle())
+			go c.Close()
+		}
+	default:
+	}
+	select {
+	case <-h.chDialErr:
+	default:
+	}
+}
+
+// cancelDial cancels the Dialing. The controller completes the pending
+// connection either way, with the connection if it was made in the meantime
+// or with ErrConnID. The event is reaped here, so the initiator is idle and
+// the next Dial can start right away.
 func (h *HCI) cancelDial(passthrough error) (ble.Client, error) {
 	err := h.Send(&h.params.connCancel, nil)
-	if err == nil {
-		// The pending connection was canceled successfully
-		return nil, errors.Wrap(passthrough, "connection cancelled")
+	if err != nil && err != ErrDisallowed {
+		return nil, errors.Wrapf(passthrough, "cancel connection failed - %s", err.Error())
 	}
 
-	// The connection has been established, the cancel command
-	// failed with ErrDisallowed.
-	if err == ErrDisallowed {
-		select {
-		case c := <-h.chMasterConn:
-			h.Debug("cancelDial: got connection complete after disallowed")
-			return gatt.NewClient(c, h.cache, h.done, h.Logger)
-		case <-time.After(50 * time.Millisecond):
-			h.Debug("cancelDial: connection req timed out after a connection was made")
-			return nil, errors.Wrap(passthrough, "cancel connection failed - connection req timed out after a connection was made")
+	// ErrDisallowed means the connection has been made, and the event is
+	// already queued or about to arrive.
+	select {
+	case c, ok := <-h.chMasterConn:
+		if ok {
+			h.Debug("cancelDial: got connection complete before the cancel, disconnecting")
+			c.Close()
 		}
+		return nil, errors.Wrap(passthrough, "connection cancelled")
+	case err := <-h.chDialErr:
+		h.Debugf("cancelDial: connection complete with %v", err)
+		return nil, errors.Wrap(passthrough, "connection cancelled")
+	case <-h.done:
+		return nil, errors.Wrap(passthrough, "connection cancelled")
+	case <-time.After(dialCancelTimeout):
+		h.Warn("cancelDial: no connection complete after cancel")
+		return nil, errors.Wrap(passthrough, "cancel connection failed - no connection complete event")
+	}
+}
+
+// AdvTxPowerLevel reads the transmit power level used for advertising
+// packets from the controller, in dBm.
+func (h *HCI) AdvTxPowerLevel() (int, error) {
+	rp := cmd.LEReadAdvertisingChannelTxPowerRP{}
+	if err := h.Send(&cmd.LEReadAdvertisingChannelTxPower{}, &rp); err != nil {
+		return 0, err
 	}
+	h.txPwrLv = int(int8(rp.TransmitPowerLevel))
+	return h.txPwrLv, nil
+}
+
+// legacyAdvProperties maps the types of legacy advertising to the event
+// properties of the extended advertising sets using legacy PDUs.
+var legacyAdvProperties = map[uint8]uint16{
+	0x00: 0x0013, // ADV_IND
+	0x01: 0x001D, // ADV_DIRECT_IND, high duty cycle
+	0x02: 0x0012, // ADV_SCAN_IND
+	0x03: 0x0010, // ADV_NONCONN_IND
+	0x04: 0x0015, // ADV_DIRECT_IND, low duty cycle
+}
+
+// SetAdvertisingSetTxPower sets the parameters of the extended advertising
+// set of handle [Vol 2, Part E, 7.8.53], asking for the transmit power level
+// in dBm, from -127 to +20, or 127 to let the controller choose. It returns
+// the level the controller selected, which is at most the one asked for.
+// The set takes the intervals, the type, the channels, the own address type
+// and the filter policy of the advertising parameters, and uses legacy PDUs.
+// Like for SetAdvertisingSetRandomAddress, the sets are otherwise set up and
+// enabled with custom commands; the parameters can't be changed while the
+// set advertises.
+func (h *HCI) SetAdvertisingSetTxPower(handle uint8, level int8) (int8, error) {
+	if level > 20 && level != 127 {
+		return 0, fmt.Errorf("invalid tx power level %d", level)
+	}
+	h.params.RLock()
+	ap := h.params.advParams
+	h.params.RUnlock()
+	c := &cmd.LESetExtendedAdvertisingParameters{
+		AdvertisingHandle:            handle,
+		AdvertisingEventProperties:   legacyAdvProperties[ap.AdvertisingType],
+		PrimaryAdvertisingChannelMap: ap.AdvertisingChannelMap,
+		OwnAddressType:               ap.OwnAddressType,
+		PeerAddressType:              ap.DirectAddressType,
+		PeerAddress:                  ap.DirectAddress,
+		AdvertisingFilterPolicy:      ap.AdvertisingFilterPolicy,
+		AdvertisingTXPower:           level,
+		PrimaryAdvertisingPHY:        0x01, // LE 1M, as legacy PDUs require.
+		SecondaryAdvertisingPHY:      0x01,
+	}
+	// The intervals are in the same units, on 3 octets.
+	c.PrimaryAdvertisingIntervalMin = [3]byte{byte(ap.AdvertisingIntervalMin), byte(ap.AdvertisingIntervalMin >> 8)}
+	c.PrimaryAdvertisingIntervalMax = [3]byte{byte(ap.AdvertisingIntervalMax), byte(ap.AdvertisingIntervalMax >> 8)}
+	rp := cmd.LESetExtendedAdvertisingParametersRP{}
+	if err := h.Send(c, &rp); err != nil {
+		return 0, err
+	}
+
+	h.muAdvSetAddrs.Lock()
+	defer h.muAdvSetAddrs.Unlock()
+	if h.advSetTxPower == nil {
+		h.advSetTxPower = make(map[uint8]int8)
+	}
+	h.advSetTxPower[handle] = rp.SelectedTXPower
+	return rp.SelectedTXPower, nil
+}
+
+// AdvertisingSetTxPower returns the transmit power level the controller last
+// selected for the advertising set of handle, in dBm, and whether one was.
+func (h *HCI) AdvertisingSetTxPower(handle uint8) (int8, bool) {
+	h.muAdvSetAddrs.Lock()
+	defer h.muAdvSetAddrs.Unlock()
+	l, ok := h.advSetTxPower[handle]
+	return l, ok
+}
 
-	// some other issue
-	return nil, errors.Wrapf(passthrough, "cancel connection failed - %s", err.Error())
+// appendTxPower adds the advertising transmit power level to the advertising
+// data, or the scan response if it doesn't fit, when enabled by the user.
+func (h *HCI) appendTxPower(ad, sr *adv.Packet) {
+	if !h.advTxPower {
+		return
+	}
+	f := adv.TxPower(h.advTxPowerLevel())
+	switch {
+	case ad.Append(f) == nil:
+	case sr.Append(f) == nil:
+	}
 }
 
-// Advertise starts advertising.
+// advTxPowerLevel reads the current advertising transmit power of the
+// controller, which may have changed since init, falling back to the level
+// read then.
+func (h *HCI) advTxPowerLevel() int8 {
+	if _, err := h.AdvTxPowerLevel(); err != nil {
+		h.Warnf("can't read advertising tx power: %v", err)
+	}
+	return int8(h.txPwrLv)
+}
+
+// fillTxPower fills the TX Power Level fields of b holding adv.TxPowerAuto.
+func (h *HCI) fillTxPower(b []byte) []byte {
+	if _, ok := adv.FillTxPower(b, 0); !ok {
+		return b
+	}
+	b, _ = adv.FillTxPower(b, h.advTxPowerLevel())
+	return b
+}
+
+// applyAdvParams sends the advertising parameters to the controller, if they
+// were changed since they were last sent.
+func (h *HCI) applyAdvParams() error {
+	h.params.Lock()
+	defer h.params.Unlock()
+	if !h.params.advParamsDirty {
+		return nil
+	}
+	// Parameters can't be changed while advertising.
+	if h.params.advEnable.AdvertisingEnable == 1 {
+		if err := h.Send(&cmd.LESetAdvertiseEnable{AdvertisingEnable: 0}, nil); err != nil {
+			return errors.Wrap(err, "can't stop advertising")
+		}
+	}
+	if err := h.Send(&h.params.advParams, nil); err != nil {
+		return errors.Wrap(err, "can't set advertising parameters")
+	}
+	h.params.advParamsDirty = false
+	return nil
+}
+
+// Advertise starts advertising. It does nothing if the HCI is advertising
+// already, and its parameters didn't change.
 func (h *HCI) Advertise() error {
+	h.muAdv.Lock()
+	defer h.muAdv.Unlock()
+	defer h.syncAdvState()
+	if h.params.advEnable.AdvertisingEnable == 1 && !h.advStopped && !h.advParamsDirty() {
+		return nil
+	}
+	if err := h.applyAdvParams(); err != nil {
+		return err
+	}
 	h.params.advEnable.AdvertisingEnable = 1
-	return h.Send(&h.params.advEnable, nil)
+	h.advStopped = false
+	if err := h.Send(&h.params.advEnable, nil); err != nil {
+		h.params.advEnable.AdvertisingEnable = 0
+		return h.busyErr(err)
+	}
+	return nil
 }
 
 // SetAdvertisement sets advertising data and scanResp.
@@ -278,17 +803,94 @@ func (h *HCI) SetAdvertisement(ad []byte, sr []byte) error {
 	if len(ad) > adv.MaxEIRPacketLength || len(sr) > adv.MaxEIRPacketLength {
 		return ble.ErrEIRPacketTooLong
 	}
+	h.muAdv.Lock()
+	defer h.muAdv.Unlock()
+	if err := h.validateAdv(ad, sr); err != nil {
+		return err
+	}
+	if err := h.setAdvData(ad); err != nil {
+		return err
+	}
+	return h.setScanResp(sr)
+}
+
+// SetScanResponseData sets the scan response data, leaving the advertising
+// data as is. It may be called while advertising.
+func (h *HCI) SetScanResponseData(sr []byte) error {
+	if len(sr) > adv.MaxEIRPacketLength {
+		return ble.ErrEIRPacketTooLong
+	}
+	h.muAdv.Lock()
+	defer h.muAdv.Unlock()
+	if err := h.validateAdv(nil, sr); err != nil {
+		return err
+	}
+	return h.setScanResp(sr)
+}
+
+// UpdateAdvertisement replaces the advertising data, and the scan response
+// data unless sr is nil, without stopping advertising: the controller
+// advertises the new data from its next advertising event on, e.g. to rotate
+// the telemetry of a beacon. Both are validated before either is sent, and
+// other changes of advertising wait for the update to complete.
+func (h *HCI) UpdateAdvertisement(ad, sr []byte) error {
+	if len(ad) > adv.MaxEIRPacketLength || len(sr) > adv.MaxEIRPacketLength {
+		return ble.ErrEIRPacketTooLong
+	}
+	h.muAdv.Lock()
+	defer h.muAdv.Unlock()
+	if err := h.validateAdv(ad, sr); err != nil {
+		return err
+	}
+	if err := h.setAdvData(ad); err != nil {
+		return err
+	}
+	if sr == nil {
+		return nil
+	}
+	return h.setScanResp(sr)
+}
+
+// validateAdv checks the advertising data ad and the scan response data sr
+// with adv.Validate, if enabled, against the advertising type set. A nil ad
+// or sr stands for the current one. Must be called with muAdv held.
+func (h *HCI) validateAdv(ad, sr []byte) error {
+	if !h.advValidate {
+		return nil
+	}
+	if ad == nil {
+		ad = h.params.advData.AdvertisingData[:h.params.advData.AdvertisingDataLength]
+	}
+	if sr == nil {
+		sr = h.params.scanResp.ScanResponseData[:h.params.scanResp.ScanResponseDataLength]
+	}
+	// ADV_IND and directed advertising are connectable.
+	t := h.params.advParams.AdvertisingType
+	return adv.Validate(ad, sr, t == 0x00 || t == 0x01 || t == 0x04)
+}
 
-	h.params.advData.AdvertisingDataLength = uint8(len(ad))
-	copy(h.params.advData.AdvertisingData[:], ad)
-	if err := h.Send(&h.params.advData, nil); err != nil {
+// setAdvData sends the advertising data ad to the controller, and keeps it
+// once it's accepted. Must be called with muAdv held.
+func (h *HCI) setAdvData(ad []byte) error {
+	ad = h.fillTxPower(ad)
+	c := cmd.LESetAdvertisingData{AdvertisingDataLength: uint8(len(ad))}
+	copy(c.AdvertisingData[:], ad)
+	if err := h.Send(&c, nil); err != nil {
 		return err
 	}
+	h.params.advData = c
+	return nil
+}
 
-	h.params.scanResp.ScanResponseDataLength = uint8(len(sr))
-	copy(h.params.scanResp.ScanResponseData[:], sr)
-	if err := h.Send(&h.params.scanResp, nil); err != nil {
+// setScanResp sends the scan response data sr to the controller, and keeps
+// it once it's accepted. Must be called with muAdv held.
+func (h *HCI) setScanResp(sr []byte) error {
+	sr = h.fillTxPower(sr)
+	c := cmd.LESetScanResponseData{ScanResponseDataLength: uint8(len(sr))}
+	copy(c.ScanResponseData[:], sr)
+	if err := h.Send(&c, nil); err != nil {
 		return err
 	}
+	h.params.scanResp = c
 	return nil
 }
@@ -0,0 +1,191 @@
+package hci_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/adv"
+	"github.com/leso-kn/ble/linux/hci"
+	"github.com/leso-kn/ble/linux/hci/cmd"
+	"github.com/leso-kn/ble/linux/hci/virtual"
+)
+
+func TestAdvRestart(t *testing.T) {
+	air := virtual.NewAir()
+	dev := func(addr string, opts ...ble.Option) *linux.Device {
+		c, err := air.NewController(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := linux.NewDevice(append([]ble.Option{ble.OptTransportVirtual(c)}, opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	p := dev("11:11:11:11:11:11", ble.OptAdvRestart(true, 1))
+	defer p.Stop()
+	c1 := dev("33:33:33:33:33:33")
+	defer c1.Stop()
+	c2 := dev("44:44:44:44:44:44")
+	defer c2.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+	pa := ble.NewAddr("11:11:11:11:11:11")
+
+	cln1, err := c1.Dial(ctx, pa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tctx, tcancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	_, err = c2.Dial(tctx, pa)
+	tcancel()
+	if err == nil {
+		t.Fatal("advertising restarted beyond the maximum connections")
+	}
+
+	cln1.CancelConnection()
+	<-cln1.Disconnected()
+	cln2, err := c2.Dial(ctx, pa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cln2.CancelConnection()
+}
+
+func TestAdvFilterPolicy(t *testing.T) {
+	air := virtual.NewAir()
+	dev := func(addr string, opts ...ble.Option) *linux.Device {
+		c, err := air.NewController(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := linux.NewDevice(append([]ble.Option{ble.OptTransportVirtual(c)}, opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	p := dev("11:11:11:11:11:11", ble.OptAdvFilterPolicy(hci.AdvFilterPolicyScanAndConn))
+	defer p.Stop()
+	c1 := dev("33:33:33:33:33:33")
+	defer c1.Stop()
+	c2 := dev("44:44:44:44:44:44")
+	defer c2.Stop()
+
+	if err := p.HCI.AddToAcceptList(ble.NewAddr("33:33:33:33:33:33")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+	pa := ble.NewAddr("11:11:11:11:11:11")
+
+	tctx, tcancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	_, err := c2.Dial(tctx, pa)
+	tcancel()
+	if err == nil {
+		t.Fatal("connected from outside the accept list")
+	}
+	if err := p.HCI.AddToAcceptList(ble.NewAddr("44:44:44:44:44:44")); err != hci.ErrDisallowed {
+		t.Fatalf("accept list changed while in use: %v", err)
+	}
+	cln, err := c1.Dial(ctx, pa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cln.CancelConnection()
+}
+
+func TestUpdateAdvertisement(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	packet := func(v byte) []byte {
+		pkt, err := adv.NewPacket(adv.Flags(adv.FlagGeneralDiscoverable|adv.FlagLEOnly), adv.ManufacturerData(0xFFFF, []byte{v}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pkt.Bytes()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseData(ctx, packet(1), nil)
+
+	got := make(chan byte, 16)
+	sctx, scancel := context.WithCancel(ctx)
+	defer scancel()
+	go c.Scan(sctx, true, func(a ble.Advertisement) {
+		if md := a.ManufacturerData(); len(md) == 3 {
+			select {
+			case got <- md[2]:
+			default:
+			}
+		}
+	})
+	// wait waits for an advertisement with the payload v.
+	wait := func(v byte) {
+		t.Helper()
+		for {
+			select {
+			case b := <-got:
+				if b == v {
+					return
+				}
+			case <-ctx.Done():
+				t.Fatalf("payload %d not advertised", v)
+			}
+		}
+	}
+	wait(1)
+
+	enable := (&cmd.LESetAdvertiseEnable{}).OpCode()
+	before := p.HCI.CommandStats()[enable].Count
+	for v := byte(2); v <= 3; v++ {
+		if err := p.UpdateAdvertisement(packet(v), nil); err != nil {
+			t.Fatal(err)
+		}
+		wait(v)
+	}
+	if n := p.HCI.CommandStats()[enable].Count; n != before {
+		t.Fatalf("advertising toggled %d times during the updates", n-before)
+	}
+	if s := p.State(); !s.Advertising || s.AdvertisingPaused {
+		t.Fatalf("state %+v after the updates", s)
+	}
+	if err := p.UpdateAdvertisement(make([]byte, 32), nil); err != ble.ErrEIRPacketTooLong {
+		t.Fatalf("update with 32 bytes: %v", err)
+	}
+}
+
+func TestAdvValidation(t *testing.T) {
+	pair := virtualtest.NewPair(t, []ble.Option{ble.OptAdvValidation(true)}, nil)
+	defer pair.Stop()
+	d := pair.Peripheral
+
+	// Connectable advertising needs flags.
+	err := d.HCI.AdvertiseMfgData(0xFFFF, []byte{1, 2, 3})
+	if _, ok := err.(adv.ValidationErrors); !ok {
+		t.Fatalf("advertised without flags: %v", err)
+	}
+	ad, sr, err := adv.NewBuilder().Flags(adv.FlagGeneralDiscoverable | adv.FlagLEOnly).Name("gopher").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.HCI.SetAdvertisement(ad.Bytes(), sr.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.HCI.SetScanResponseData(ad.Bytes()); err == nil {
+		t.Fatal("flags accepted in the scan response")
+	}
+	if err := d.HCI.UpdateAdvertisement([]byte{0x02, 0x01, 0x06, 0x05, 0x0A, 0x00}, nil); err == nil {
+		t.Fatal("truncated advertising data accepted")
+	}
+}
@@ -0,0 +1,119 @@
+package h4
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	authHandshakeTimeout = time.Second * 5
+
+	authAccepted = byte(0x00)
+	authDenied   = byte(0x01)
+)
+
+// authMagic starts the token handshake. It can't be taken for an H4 packet,
+// as 'H' isn't a packet indicator.
+var authMagic = []byte("H4AUTH\x01")
+
+// SocketAuth secures the link between an H4 socket and a Proxy, which
+// otherwise carries plaintext HCI to anyone who can connect. Both ends need
+// the same settings.
+type SocketAuth struct {
+	// TLS, if set, encrypts the link. The client sets RootCAs to verify
+	// the proxy, and Certificates to present a client certificate. The
+	// proxy sets Certificates, and ClientCAs with ClientAuth set to
+	// tls.RequireAndVerifyClientCert to accept only known clients.
+	TLS *tls.Config
+
+	// Token, if set, is presented by the client before any HCI traffic.
+	// Without TLS, it's sent in the clear.
+	Token string
+}
+
+// dial connects to the proxy at addr, and presents the token.
+func (a *SocketAuth) dial(addr string, timeout time.Duration) (net.Conn, error) {
+	d := &net.Dialer{Timeout: timeout}
+	if a == nil {
+		return d.Dial("tcp", addr)
+	}
+
+	var c net.Conn
+	var err error
+	if a.TLS != nil {
+		c, err = tls.DialWithDialer(d, "tcp", addr, a.TLS)
+	} else {
+		c, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if a.Token == "" {
+		return c, nil
+	}
+
+	if len(a.Token) > 255 {
+		c.Close()
+		return nil, fmt.Errorf("token too long")
+	}
+	c.SetDeadline(time.Now().Add(authHandshakeTimeout))
+	defer c.SetDeadline(time.Time{})
+	b := append(append([]byte{}, authMagic...), byte(len(a.Token)))
+	if _, err := c.Write(append(b, a.Token...)); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("can't send token: %v", err)
+	}
+	r := make([]byte, 1)
+	if _, err := io.ReadFull(c, r); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("no token reply: %v", err)
+	}
+	if r[0] != authAccepted {
+		c.Close()
+		return nil, fmt.Errorf("token rejected")
+	}
+	return c, nil
+}
+
+// accept completes the TLS handshake of the client c, if any, and checks its
+// token.
+func (a *SocketAuth) accept(c net.Conn) error {
+	if a == nil {
+		return nil
+	}
+	c.SetDeadline(time.Now().Add(authHandshakeTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	if tc, ok := c.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			return fmt.Errorf("tls handshake: %v", err)
+		}
+	}
+	if a.Token == "" {
+		return nil
+	}
+
+	b := make([]byte, len(authMagic)+1)
+	if _, err := io.ReadFull(c, b); err != nil {
+		return fmt.Errorf("no token: %v", err)
+	}
+	if !bytes.Equal(b[:len(authMagic)], authMagic) {
+		c.Write([]byte{authDenied})
+		return fmt.Errorf("no token")
+	}
+	t := make([]byte, b[len(authMagic)])
+	if _, err := io.ReadFull(c, t); err != nil {
+		return fmt.Errorf("no token: %v", err)
+	}
+	if subtle.ConstantTimeCompare(t, []byte(a.Token)) != 1 {
+		c.Write([]byte{authDenied})
+		return fmt.Errorf("invalid token")
+	}
+	_, err := c.Write([]byte{authAccepted})
+	return err
+}
@@ -0,0 +1,73 @@
+package h4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	bcmDownloadMinidriver = uint16(0xFC2E)
+	bcmUpdateBaudRate     = uint16(0xFC18)
+)
+
+// Broadcom brings up Broadcom and Cypress controllers, such as the BCM43xx
+// of Raspberry Pi class boards, by uploading a patchram file (.hcd).
+type Broadcom struct {
+	// Firmware is the path of the .hcd file, e.g. BCM43430A1.hcd. If empty,
+	// the controller runs its ROM firmware.
+	Firmware string
+}
+
+// Setup uploads the patchram file, the same way btbcm does.
+func (b *Broadcom) Setup(c *CommandConn) error {
+	if _, err := c.SendStatus(opReset, nil); err != nil {
+		return err
+	}
+	if b.Firmware == "" {
+		return nil
+	}
+	fw, err := ioutil.ReadFile(b.Firmware)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("h4: uploading broadcom patchram %v", b.Firmware)
+	if _, err := c.SendStatus(bcmDownloadMinidriver, nil); err != nil {
+		return err
+	}
+	// Give the controller time to start the minidriver.
+	time.Sleep(50 * time.Millisecond)
+
+	// The file is a sequence of commands, ending with Launch RAM.
+	for len(fw) > 0 {
+		if len(fw) < 3 || len(fw) < 3+int(fw[2]) {
+			return fmt.Errorf("truncated patchram file")
+		}
+		op := binary.LittleEndian.Uint16(fw)
+		l := 3 + int(fw[2])
+		if _, err := c.SendStatus(op, fw[3:l]); err != nil {
+			return err
+		}
+		fw = fw[l:]
+	}
+	// The controller restarts with the new firmware, at its initial baud rate.
+	time.Sleep(250 * time.Millisecond)
+
+	_, err = c.SendStatus(opReset, nil)
+	return err
+}
+
+// SetBaudRate switches the controller's UART to baud.
+func (b *Broadcom) SetBaudRate(c *CommandConn, baud uint) error {
+	p := make([]byte, 6)
+	binary.LittleEndian.PutUint32(p[2:], uint32(baud))
+	if _, err := c.SendStatus(bcmUpdateBaudRate, p); err != nil {
+		return err
+	}
+	time.Sleep(10 * time.Millisecond)
+	return nil
+}
@@ -1,6 +1,9 @@
 package h4
 
 const (
-	eventPacket = byte(0x04)
-	aclPacket   = byte(0x02)
+	commandPacket = byte(0x01)
+	aclPacket     = byte(0x02)
+	scoPacket     = byte(0x03)
+	eventPacket   = byte(0x04)
+	isoPacket     = byte(0x05)
 )
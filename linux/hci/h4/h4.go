@@ -3,12 +3,11 @@ package h4
 import (
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"sync"
 	"time"
 
-	"github.com/jacobsa/go-serial/serial"
+	"github.com/leso-kn/ble"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -31,56 +30,23 @@ type h4 struct {
 
 	done chan int
 	cmu  sync.Mutex
-}
 
-func DefaultSerialOptions() serial.OpenOptions {
-	return serial.OpenOptions{
-		PortName:              "/dev/ttyACM0",
-		BaudRate:              1000000,
-		DataBits:              8,
-		ParityMode:            serial.PARITY_NONE,
-		StopBits:              1,
-		RTSCTSFlowControl:     true,
-		MinimumReadSize:       0,
-		InterCharacterTimeout: 100,
-	}
+	// lost reports whether the device has disappeared; nil if it can't.
+	lost func() bool
+	// err is why rxLoop closed the transport; set before done is closed.
+	// It's guarded by cmu.
+	err error
 }
 
-func NewSerial(opts serial.OpenOptions) (io.ReadWriteCloser, error) {
-	// force these
-	opts.MinimumReadSize = 0
-	opts.InterCharacterTimeout = 100
-
-	logrus.Debugf("opening h4 uart %v...", opts.PortName)
-	rwc, err := serial.Open(opts)
-	if err != nil {
-		return nil, err
-	}
-
-	// eof is ok (read timeout)
-	eofAsError := false
-	if err := resetAndWaitIdle(rwc, time.Second*2, eofAsError); err != nil {
-		rwc.Close()
-		return nil, err
-	}
-	logrus.Debugf("opened %v", opts)
-
-	h := &h4{
-		rwc:     rwc,
-		done:    make(chan int),
-		rxQueue: make(chan []byte, rxQueueSize),
-		txQueue: make(chan []byte, txQueueSize),
-	}
-	h.frame = newFrame(h.rxQueue)
-
-	go h.rxLoop(eofAsError)
-
-	return h, nil
+func NewSocket(addr string, connTimeout time.Duration) (io.ReadWriteCloser, error) {
+	return NewSocketWithAuth(addr, connTimeout, nil)
 }
 
-func NewSocket(addr string, connTimeout time.Duration) (io.ReadWriteCloser, error) {
+// NewSocketWithAuth is like NewSocket, but secures the link to the proxy
+// with auth, if not nil.
+func NewSocketWithAuth(addr string, connTimeout time.Duration, auth *SocketAuth) (io.ReadWriteCloser, error) {
 	logrus.Debugf("opening h4 socket %v ...", addr)
-	c, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	c, err := auth.dial(addr, 10*time.Second)
 	if err != nil {
 		return nil, err
 	}
@@ -115,7 +81,7 @@ func NewSocket(addr string, connTimeout time.Duration) (io.ReadWriteCloser, erro
 
 func (h *h4) Read(p []byte) (int, error) {
 	if !h.isOpen() {
-		return 0, io.EOF
+		return 0, h.closedErr()
 	}
 
 	h.rmu.Lock()
@@ -137,7 +103,7 @@ func (h *h4) Read(p []byte) (int, error) {
 
 	// check if we are still open since the read could take a while
 	if !h.isOpen() {
-		return 0, io.EOF
+		return 0, h.closedErr()
 	}
 	return n, errors.Wrap(err, "can't read h4")
 }
@@ -150,10 +116,31 @@ func (h *h4) Write(p []byte) (int, error) {
 	h.wmu.Lock()
 	defer h.wmu.Unlock()
 	n, err := h.rwc.Write(p)
+	if err != nil && h.lost != nil && h.lost() {
+		return n, ble.ErrAdapterLost
+	}
 
 	return n, errors.Wrap(err, "can't write h4")
 }
 
+// closedErr returns the error reads fail with once closed: ble.ErrAdapterLost
+// if the device disappeared, io.EOF otherwise.
+func (h *h4) closedErr() error {
+	h.cmu.Lock()
+	defer h.cmu.Unlock()
+	if h.err != nil {
+		return h.err
+	}
+	return io.EOF
+}
+
+// setErr records why rxLoop closes the transport.
+func (h *h4) setErr(err error) {
+	h.cmu.Lock()
+	h.err = err
+	h.cmu.Unlock()
+}
+
 func (h *h4) Close() error {
 	h.cmu.Lock()
 	defer h.cmu.Unlock()
@@ -209,11 +196,19 @@ func (h *h4) rxLoop(eofAsError bool) {
 		case os.IsTimeout(err):
 			continue
 		case !eofAsError && err == io.EOF:
-			// trap eof, read timeout
+			// trap eof, read timeout. A hung up tty reads eof as well.
+			if h.lost != nil && h.lost() {
+				logrus.Errorf("h4 device lost")
+				h.setErr(ble.ErrAdapterLost)
+				return
+			}
 			continue
 		default:
 			// uhoh!
 			logrus.Error(err)
+			if h.lost != nil && h.lost() {
+				h.setErr(ble.ErrAdapterLost)
+			}
 			return
 		}
 	}
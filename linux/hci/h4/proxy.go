@@ -0,0 +1,399 @@
+package h4
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Proxy exposes a local controller over TCP, so a remote instance of this
+// package can use it as an H4 socket, e.g. with ble.OptTransportH4Socket.
+// This allows developing against a controller attached to a remote board.
+// One client is served at a time, as the controller has a single state; the
+// policy decides what happens to others while it's connected.
+type Proxy struct {
+	dev  io.ReadWriteCloser
+	l    net.Listener
+	auth *SocketAuth
+
+	// slot is held by the client being served.
+	slot chan struct{}
+
+	mu     sync.Mutex
+	policy ProxyPolicy
+	allow  func(net.Addr) bool
+	client net.Conn
+	since  time.Time
+	err    error
+	stats  ProxyStatus
+
+	done chan struct{}
+	once sync.Once
+}
+
+// NewProxy listens on the TCP address addr, to forward H4 packets between a
+// client and dev, such as an H4 UART from NewSerial or an HCI user channel.
+func NewProxy(dev io.ReadWriteCloser, addr string) (*Proxy, error) {
+	return NewProxyWithAuth(dev, addr, nil)
+}
+
+// NewProxyWithAuth is like NewProxy, but only serves clients which pass
+// auth, if not nil.
+func NewProxyWithAuth(dev io.ReadWriteCloser, addr string, auth *SocketAuth) (*Proxy, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if auth != nil && auth.TLS != nil {
+		l = tls.NewListener(l, auth.TLS)
+	}
+	return &Proxy{
+		dev:    dev,
+		l:      l,
+		auth:   auth,
+		slot:   make(chan struct{}, 1),
+		policy: ProxyRefuse,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// ProxyPolicy decides how a Proxy treats a client which connects while
+// another one is served.
+type ProxyPolicy string
+
+// Proxy policies.
+const (
+	// ProxyRefuse disconnects the new client.
+	ProxyRefuse ProxyPolicy = "refuse"
+
+	// ProxyTakeover disconnects the client being served, and serves the
+	// new one.
+	ProxyTakeover ProxyPolicy = "takeover"
+
+	// ProxyWait holds the new client until the one being served leaves.
+	// Waiting clients are served in no particular order.
+	ProxyWait ProxyPolicy = "wait"
+)
+
+// SetPolicy sets how clients connecting while another one is served are
+// treated. It's ProxyRefuse by default.
+func (p *Proxy) SetPolicy(policy ProxyPolicy) error {
+	switch policy {
+	case ProxyRefuse, ProxyTakeover, ProxyWait:
+	default:
+		return fmt.Errorf("invalid proxy policy %q", policy)
+	}
+	p.mu.Lock()
+	p.policy = policy
+	p.mu.Unlock()
+	return nil
+}
+
+// SetAllow sets a filter on the addresses of clients, which are refused
+// before authentication unless it returns true. All are allowed by default.
+func (p *Proxy) SetAllow(allow func(net.Addr) bool) {
+	p.mu.Lock()
+	p.allow = allow
+	p.mu.Unlock()
+}
+
+// ProxyStatus is a snapshot of the state of a Proxy.
+type ProxyStatus struct {
+	Addr   string      `json:"addr"`
+	Policy ProxyPolicy `json:"policy"`
+
+	// Client is the address of the client being served, if any, since
+	// ClientSince.
+	Client      string    `json:"client,omitempty"`
+	ClientSince time.Time `json:"clientSince"`
+
+	Served  int `json:"served"`  // clients served
+	Refused int `json:"refused"` // clients refused, filtered or failing auth
+
+	ToController   uint64 `json:"toController"`   // bytes
+	FromController uint64 `json:"fromController"` // bytes
+
+	// Err is the error the controller failed with, if any.
+	Err string `json:"err,omitempty"`
+}
+
+// Status returns the current state of the proxy.
+func (p *Proxy) Status() ProxyStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats
+	s.Addr = p.l.Addr().String()
+	s.Policy = p.policy
+	if p.client != nil {
+		s.Client = p.client.RemoteAddr().String()
+		s.ClientSince = p.since
+	}
+	if p.err != nil {
+		s.Err = p.err.Error()
+	}
+	return s
+}
+
+// Addr returns the address the proxy listens on.
+func (p *Proxy) Addr() net.Addr {
+	return p.l.Addr()
+}
+
+// Serve accepts clients until the proxy is closed, or the controller fails.
+func (p *Proxy) Serve() error {
+	go p.devLoop()
+	for {
+		c, err := p.l.Accept()
+		if err != nil {
+			select {
+			case <-p.done:
+				p.mu.Lock()
+				defer p.mu.Unlock()
+				return p.err
+			default:
+				return err
+			}
+		}
+
+		go p.serve(c)
+	}
+}
+
+// serve authenticates c and, as the policy allows, serves it.
+func (p *Proxy) serve(c net.Conn) {
+	p.mu.Lock()
+	allow := p.allow
+	p.mu.Unlock()
+	if allow != nil && !allow(c.RemoteAddr()) {
+		p.refuse(c, "not allowed")
+		return
+	}
+	if err := p.auth.accept(c); err != nil {
+		p.refuse(c, err.Error())
+		return
+	}
+	if !p.acquire(c) {
+		return
+	}
+	logrus.Infof("h4 proxy: serving %v", c.RemoteAddr())
+	p.clientLoop(c)
+}
+
+// refuse disconnects c, which isn't served.
+func (p *Proxy) refuse(c net.Conn, reason string) {
+	logrus.Warnf("h4 proxy: refusing %v: %s", c.RemoteAddr(), reason)
+	p.mu.Lock()
+	p.stats.Refused++
+	p.mu.Unlock()
+	c.Close()
+}
+
+// acquire makes c the client being served, as the policy allows, and
+// reports whether it is.
+func (p *Proxy) acquire(c net.Conn) bool {
+	p.mu.Lock()
+	policy := p.policy
+	p.mu.Unlock()
+
+	switch policy {
+	case ProxyWait:
+		logrus.Infof("h4 proxy: %v waiting", c.RemoteAddr())
+		select {
+		case p.slot <- struct{}{}:
+		case <-p.done:
+			c.Close()
+			return false
+		}
+	case ProxyTakeover:
+		for acquired := false; !acquired; {
+			p.mu.Lock()
+			old := p.client
+			p.mu.Unlock()
+			if old != nil {
+				logrus.Infof("h4 proxy: %v takes over from %v", c.RemoteAddr(), old.RemoteAddr())
+				p.drop(old)
+			}
+			select {
+			case p.slot <- struct{}{}:
+				acquired = true
+			case <-p.done:
+				c.Close()
+				return false
+			case <-time.After(100 * time.Millisecond):
+				// another client took over first
+			}
+		}
+	default:
+		select {
+		case p.slot <- struct{}{}:
+		default:
+			p.refuse(c, "already serving a client")
+			return false
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		<-p.slot
+		c.Close()
+		return false
+	default:
+	}
+	p.client = c
+	p.since = time.Now()
+	p.stats.Served++
+	return true
+}
+
+// Close stops the proxy, disconnects the client and closes the controller.
+func (p *Proxy) Close() error {
+	var err error
+	p.once.Do(func() {
+		close(p.done)
+		p.l.Close()
+		p.mu.Lock()
+		if p.client != nil {
+			p.client.Close()
+			p.client = nil
+		}
+		p.mu.Unlock()
+		err = p.dev.Close()
+	})
+	return err
+}
+
+// devLoop forwards the packets from the controller to the client, if any.
+func (p *Proxy) devLoop() {
+	b := make([]byte, 4096)
+	for {
+		n, err := p.dev.Read(b)
+		if err != nil {
+			select {
+			case <-p.done:
+			default:
+				logrus.Errorf("h4 proxy: controller: %v", err)
+				p.mu.Lock()
+				p.err = err
+				p.mu.Unlock()
+				p.Close()
+			}
+			return
+		}
+		if n == 0 {
+			// read timeout
+			continue
+		}
+
+		p.mu.Lock()
+		c := p.client
+		if c != nil {
+			p.stats.FromController += uint64(n)
+		}
+		p.mu.Unlock()
+		if c == nil {
+			continue
+		}
+		if _, err := c.Write(b[:n]); err != nil {
+			logrus.Warnf("h4 proxy: %v: %v", c.RemoteAddr(), err)
+			p.drop(c)
+		}
+	}
+}
+
+// clientLoop forwards the packets from the client to the controller. The
+// TCP stream is split into whole packets, as an HCI user channel expects.
+func (p *Proxy) clientLoop(c net.Conn) {
+	defer p.drop(c)
+	var buf []byte
+	b := make([]byte, 4096)
+	for {
+		n, err := c.Read(b)
+		if err != nil {
+			select {
+			case <-p.done:
+			default:
+				if err != io.EOF {
+					logrus.Warnf("h4 proxy: %v: %v", c.RemoteAddr(), err)
+				}
+			}
+			return
+		}
+		buf = append(buf, b[:n]...)
+
+		for {
+			l, err := packetLen(buf)
+			if err != nil {
+				logrus.Warnf("h4 proxy: %v: %v", c.RemoteAddr(), err)
+				return
+			}
+			if l == 0 || l > len(buf) {
+				break
+			}
+			if _, err := p.dev.Write(buf[:l]); err != nil {
+				logrus.Errorf("h4 proxy: controller: %v", err)
+				return
+			}
+			p.mu.Lock()
+			p.stats.ToController += uint64(l)
+			p.mu.Unlock()
+			buf = buf[l:]
+		}
+	}
+}
+
+// drop disconnects c, making room for the next client.
+func (p *Proxy) drop(c net.Conn) {
+	p.mu.Lock()
+	if p.client == c {
+		p.client = nil
+		<-p.slot
+		logrus.Infof("h4 proxy: %v disconnected", c.RemoteAddr())
+	}
+	p.mu.Unlock()
+	c.Close()
+}
+
+// packetLen returns the length of the H4 packet at the start of b, including
+// its packet indicator, or 0 if its header is incomplete.
+func packetLen(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	var hdr, l int
+	switch b[0] {
+	case commandPacket, scoPacket:
+		// opcode or handle, 1 octet length
+		if hdr = 4; len(b) >= hdr {
+			l = int(b[3])
+		}
+	case eventPacket:
+		// event code, 1 octet length
+		if hdr = 3; len(b) >= hdr {
+			l = int(b[2])
+		}
+	case aclPacket:
+		// handle, 2 octet length
+		if hdr = 5; len(b) >= hdr {
+			l = int(binary.LittleEndian.Uint16(b[3:]))
+		}
+	case isoPacket:
+		// handle, 14 bit length
+		if hdr = 5; len(b) >= hdr {
+			l = int(binary.LittleEndian.Uint16(b[3:]) & 0x3FFF)
+		}
+	default:
+		return 0, fmt.Errorf("invalid packet indicator 0x%02X", b[0])
+	}
+	if len(b) < hdr {
+		return 0, nil
+	}
+	return hdr + l, nil
+}
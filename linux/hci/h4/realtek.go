@@ -0,0 +1,127 @@
+package h4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	rtlDownload       = uint16(0xFC20)
+	rtlReadROMVersion = uint16(0xFC6D)
+
+	rtlFragLen = 252
+)
+
+var (
+	rtlEpatchSignature    = []byte("Realtech")
+	rtlExtensionSignature = []byte{0x51, 0x04, 0xFD, 0x77}
+)
+
+// Realtek brings up Realtek UART controllers, such as the RTL8723BS and
+// RTL8723DS, by uploading their firmware and config blob.
+type Realtek struct {
+	// Firmware is the path of the firmware, e.g. rtl8723bs_fw.bin.
+	Firmware string
+	// Config is the path of the config blob, e.g. rtl8723bs_config.bin,
+	// which also sets the operating baud rate. It's optional.
+	Config string
+}
+
+// Setup uploads the patch for the controller's ROM version, the same way
+// btrtl does.
+func (r *Realtek) Setup(c *CommandConn) error {
+	fw, err := ioutil.ReadFile(r.Firmware)
+	if err != nil {
+		return err
+	}
+	var cfg []byte
+	if r.Config != "" {
+		if cfg, err = ioutil.ReadFile(r.Config); err != nil {
+			return err
+		}
+	}
+
+	if bytes.HasPrefix(fw, rtlEpatchSignature) {
+		rp, err := c.SendStatus(rtlReadROMVersion, nil)
+		if err != nil {
+			return err
+		}
+		if len(rp) < 2 {
+			return fmt.Errorf("invalid rom version")
+		}
+		if fw, err = rtlEpatch(fw, rp[1]); err != nil {
+			return err
+		}
+	}
+
+	logrus.Infof("h4: uploading realtek firmware %v", r.Firmware)
+	if err := rtlDownloadFirmware(c, append(fw, cfg...)); err != nil {
+		return err
+	}
+	// The controller restarts with the new firmware.
+	time.Sleep(50 * time.Millisecond)
+	return nil
+}
+
+// SetBaudRate isn't supported; the config blob sets the operating baud rate.
+func (r *Realtek) SetBaudRate(c *CommandConn, baud uint) error {
+	return fmt.Errorf("realtek: the baud rate is set by the config blob")
+}
+
+// rtlEpatch returns the patch for the ROM version from an epatch file.
+func rtlEpatch(fw []byte, romVersion byte) ([]byte, error) {
+	const hdr = 14
+	if len(fw) < hdr || !bytes.HasSuffix(fw, rtlExtensionSignature) {
+		return nil, fmt.Errorf("invalid epatch file")
+	}
+	version := binary.LittleEndian.Uint32(fw[8:])
+	n := int(binary.LittleEndian.Uint16(fw[12:]))
+	if len(fw) < hdr+n*8 {
+		return nil, fmt.Errorf("truncated epatch file")
+	}
+
+	// The chip ids, patch lengths and patch offsets are arrays of n.
+	for i := 0; i < n; i++ {
+		chip := binary.LittleEndian.Uint16(fw[hdr+i*2:])
+		if chip != uint16(romVersion)+1 {
+			continue
+		}
+		l := int(binary.LittleEndian.Uint16(fw[hdr+n*2+i*2:]))
+		o := int(binary.LittleEndian.Uint32(fw[hdr+n*4+i*4:]))
+		if l < 4 || o+l > len(fw) {
+			return nil, fmt.Errorf("invalid patch for rom version %d", romVersion)
+		}
+		p := append([]byte{}, fw[o:o+l]...)
+		// The last 4 octets are replaced by the firmware version.
+		binary.LittleEndian.PutUint32(p[l-4:], version)
+		return p, nil
+	}
+	return nil, fmt.Errorf("no patch for rom version %d", romVersion)
+}
+
+// rtlDownloadFirmware sends the firmware in indexed fragments.
+func rtlDownloadFirmware(c *CommandConn, fw []byte) error {
+	for i := 0; len(fw) > 0; i++ {
+		l := len(fw)
+		if l > rtlFragLen {
+			l = rtlFragLen
+		}
+		index := byte(i)
+		if i > 0x7F {
+			index = byte(i&0x7F) + 1
+		}
+		if l == len(fw) {
+			index |= 0x80
+		}
+		if _, err := c.SendStatus(rtlDownload, append([]byte{index}, fw[:l]...)); err != nil {
+			return err
+		}
+		fw = fw[l:]
+	}
+	return nil
+}
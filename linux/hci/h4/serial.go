@@ -0,0 +1,96 @@
+//go:build !freebsd
+// +build !freebsd
+
+package h4
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/sirupsen/logrus"
+)
+
+func DefaultSerialOptions() serial.OpenOptions {
+	return serial.OpenOptions{
+		PortName:              "/dev/ttyACM0",
+		BaudRate:              1000000,
+		DataBits:              8,
+		ParityMode:            serial.PARITY_NONE,
+		StopBits:              1,
+		RTSCTSFlowControl:     true,
+		MinimumReadSize:       0,
+		InterCharacterTimeout: 100,
+	}
+}
+
+func NewSerial(opts serial.OpenOptions) (io.ReadWriteCloser, error) {
+	// force these
+	opts.MinimumReadSize = 0
+	opts.InterCharacterTimeout = 100
+
+	logrus.Debugf("opening h4 uart %v...", opts.PortName)
+	rwc, err := serial.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// eof is ok (read timeout)
+	eofAsError := false
+	if err := resetAndWaitIdle(rwc, time.Second*2, eofAsError); err != nil {
+		rwc.Close()
+		return nil, err
+	}
+	logrus.Debugf("opened %v", opts)
+
+	h := &h4{
+		rwc:     rwc,
+		done:    make(chan int),
+		rxQueue: make(chan []byte, rxQueueSize),
+		txQueue: make(chan []byte, txQueueSize),
+	}
+	h.frame = newFrame(h.rxQueue)
+	h.lost = func() bool {
+		// The device node is removed when a USB-serial adapter is unplugged.
+		_, err := os.Stat(opts.PortName)
+		return os.IsNotExist(err)
+	}
+
+	go h.rxLoop(eofAsError)
+
+	return h, nil
+}
+
+// NewSerialWithVendor opens an H4 UART at initBaud, brings up the controller
+// with v and, if needed, switches to the operating baud rate of opts.
+func NewSerialWithVendor(opts serial.OpenOptions, v Vendor, initBaud uint) (io.ReadWriteCloser, error) {
+	baud := opts.BaudRate
+	if initBaud == 0 {
+		initBaud = baud
+	}
+	opts.BaudRate = initBaud
+	rwc, err := NewSerial(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewCommandConn(rwc)
+	if err := v.Setup(c); err != nil {
+		rwc.Close()
+		return nil, fmt.Errorf("vendor setup: %v", err)
+	}
+	if baud == initBaud {
+		return rwc, nil
+	}
+
+	logrus.Debugf("switching h4 uart to %v baud", baud)
+	if err := v.SetBaudRate(c, baud); err != nil {
+		rwc.Close()
+		return nil, fmt.Errorf("can't set baud rate: %v", err)
+	}
+	rwc.Close()
+	opts.BaudRate = baud
+	return NewSerial(opts)
+}
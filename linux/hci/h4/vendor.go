@@ -0,0 +1,92 @@
+package h4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	evtCommandComplete = byte(0x0E)
+	evtCommandStatus   = byte(0x0F)
+
+	opReset              = uint16(0x0C03)
+	opReadLocalVersion   = uint16(0x1001)
+	vendorCommandTimeout = time.Second * 2
+)
+
+// Vendor brings up a controller on the H4 UART path before the standard HCI
+// init, e.g. by uploading its firmware or patches.
+type Vendor interface {
+	// Setup prepares the controller, sending commands with c at the
+	// initial baud rate.
+	Setup(c *CommandConn) error
+
+	// SetBaudRate switches the controller's UART to baud. It's called after
+	// Setup, if the operating baud rate differs from the initial one.
+	SetBaudRate(c *CommandConn, baud uint) error
+}
+
+// CommandConn sends HCI commands to a controller during its bring-up, when
+// the HCI layer isn't running yet.
+type CommandConn struct {
+	rw io.ReadWriter
+}
+
+// NewCommandConn returns a CommandConn for an H4 transport, which reads
+// whole packets.
+func NewCommandConn(rw io.ReadWriter) *CommandConn {
+	return &CommandConn{rw: rw}
+}
+
+// Send sends the command and waits for its Command Complete event, returning
+// the event's return parameters. The status, if any, is their first octet.
+// A failed Command Status event is returned as an error.
+func (c *CommandConn) Send(opcode uint16, params []byte) ([]byte, error) {
+	if len(params) > 255 {
+		return nil, fmt.Errorf("command 0x%04X: parameters too long", opcode)
+	}
+	b := []byte{commandPacket, byte(opcode), byte(opcode >> 8), byte(len(params))}
+	if _, err := c.rw.Write(append(b, params...)); err != nil {
+		return nil, err
+	}
+
+	to := time.Now().Add(vendorCommandTimeout)
+	p := make([]byte, 512)
+	for time.Now().Before(to) {
+		n, err := c.rw.Read(p)
+		if err != nil {
+			return nil, err
+		}
+		if n < 3 || p[0] != eventPacket {
+			continue
+		}
+		e := p[3:n]
+		switch {
+		case p[1] == evtCommandComplete && len(e) >= 3 && binary.LittleEndian.Uint16(e[1:]) == opcode:
+			return append([]byte{}, e[3:]...), nil
+		case p[1] == evtCommandStatus && len(e) >= 4 && binary.LittleEndian.Uint16(e[2:]) == opcode:
+			if e[0] != 0x00 {
+				return nil, fmt.Errorf("command 0x%04X: status 0x%02X", opcode, e[0])
+			}
+			return []byte{e[0]}, nil
+		}
+	}
+	return nil, fmt.Errorf("command 0x%04X: timed out", opcode)
+}
+
+// SendStatus sends the command and checks its status.
+func (c *CommandConn) SendStatus(opcode uint16, params []byte) ([]byte, error) {
+	rp, err := c.Send(opcode, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(rp) == 0 {
+		return nil, fmt.Errorf("command 0x%04X: no status", opcode)
+	}
+	if rp[0] != 0x00 {
+		return nil, fmt.Errorf("command 0x%04X: status 0x%02X", opcode, rp[0])
+	}
+	return rp, nil
+}
@@ -6,11 +6,14 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/lifecycle"
 	"github.com/leso-kn/ble/linux/hci/cmd"
 	"github.com/leso-kn/ble/linux/hci/evt"
+	"github.com/leso-kn/ble/registry"
 	"github.com/leso-kn/ble/sliceops"
 	"github.com/pkg/errors"
 )
@@ -39,6 +42,7 @@ type pkt struct {
 func NewHCI(smp SmpManagerFactory, opts ...ble.Option) (*HCI, error) {
 	h := &HCI{
 		smp:       smp,
+		smpConfig: defaultSmpConfig,
 		chCmdPkt:  make(chan *pkt),
 		chCmdBufs: make(chan []byte, chCmdBufChanSize),
 		sent:      make(map[int]*pkt),
@@ -47,10 +51,15 @@ func NewHCI(smp SmpManagerFactory, opts ...ble.Option) (*HCI, error) {
 		evth: map[int]handlerFn{},
 		subh: map[int]handlerFn{},
 
+		usrEvth: map[int]EventHandler{},
+		usrSubh: map[int]EventHandler{},
+
 		muConns:      sync.Mutex{},
 		conns:        make(map[uint16]*Conn),
 		chMasterConn: make(chan *Conn, 1),
 		chSlaveConn:  make(chan *Conn),
+		dialSem:      make(chan struct{}, 1),
+		chDialErr:    make(chan error, 1),
 
 		muClose:   sync.Mutex{},
 		done:      make(chan bool),
@@ -74,11 +83,51 @@ type HCI struct {
 
 	params params
 
-	smp        SmpManagerFactory
-	smpEnabled bool
+	smp         SmpManagerFactory
+	smpEnabled  bool
+	bondManager BondManager
+
+	// pairingAuthData is used when the peer initiates pairing.
+	pairingAuthData ble.AuthData
+	// smpConfig holds the pairing features of new connections.
+	smpConfig SmpConfig
+
+	// restoreEnc has Dial encrypt the links with bonded peers.
+	restoreEnc bool
+
+	// maxConns limits the connections of the local device, if set.
+	// connLimit is the number of connections the controller had when it
+	// last refused one at its limit. Both are guarded by muConns.
+	maxConns  int
+	connLimit int
+
+	// privacy is set when controller-based privacy is enabled.
+	privacy *privacy
+
+	// rotation is set when the private addresses are rotated.
+	rotation *addrRotation
+
+	// resolver is set when advertisers' private addresses are resolved on the host.
+	resolver *resolver
+
+	// watchdog is set when the controller is recovered automatically on failures.
+	watchdog *watchdog
 
 	transport transport
-	skt       io.ReadWriteCloser
+
+	// muSkt guards skt, and err, which the watchdog swaps on a recovery while
+	// the commands, the data and the event loop use them.
+	muSkt sync.RWMutex
+	skt   io.ReadWriteCloser
+
+	// skipReset skips the HCI Reset at init, and preInitCmds and postInitCmds
+	// are sent before and after the standard init sequence.
+	skipReset    bool
+	preInitCmds  []ble.HCICommand
+	postInitCmds []ble.HCICommand
+
+	// leOnly disables the BR/EDR scans of dual-mode controllers at init.
+	leOnly bool
 
 	// Host to Controller command flow control [Vol 2, Part E, 4.4]
 	chCmdPkt  chan *pkt
@@ -90,6 +139,27 @@ type HCI struct {
 	evth map[int]handlerFn
 	subh map[int]handlerFn
 
+	// User registered event handlers.
+	muEvth  sync.RWMutex
+	usrEvth map[int]EventHandler
+	usrSubh map[int]EventHandler
+
+	// Taps of the events.
+	muTaps sync.RWMutex
+	taps   []*EventTap
+
+	// User registered signaling handlers.
+	muSigh  sync.RWMutex
+	usrSigh map[uint8]SignalHandler
+
+	// muL2CAP guards l2capListeners, the listeners of the published LE_PSMs.
+	muL2CAP        sync.Mutex
+	l2capListeners map[uint16]*L2CAPListener
+
+	// Events unmasked by the user in addition to the default ones.
+	evtMask   uint64
+	leEvtMask uint64
+
 	// aclHandler
 	bufSize int
 	bufCnt  int
@@ -98,18 +168,59 @@ type HCI struct {
 	addr    net.HardwareAddr
 	txPwrLv int
 
+	// advTxPower adds the TX Power Level AD field to advertisements.
+	advTxPower bool
+
+	// logSamplers sample the log lines of the high-frequency subsystems,
+	// and sampledLogs are the loggers of the device passing through them,
+	// by subsystem.
+	muLogs      sync.Mutex
+	logSamplers map[string]*ble.LogSampler
+	sampledLogs map[string]ble.Logger
+
+	// advValidate checks the advertising data against the rules of the
+	// specification before it's sent.
+	advValidate bool
+
+	// randomAddr is the random static address used instead of addr, if set.
+	randomAddr net.HardwareAddr
+
 	// adHist and adLast track the history of past scannable advertising packets.
 	// Controller delivers AD(Advertising Data) and SR(Scan Response) separately
 	// through HCI. Upon receiving an AD, no matter it's scannable or not, we
 	// pass a Advertisement (AD only) to advHandler immediately.
 	// Upon receiving a SR, we search the AD history for the AD from the same
 	// device, and pass the Advertisiement (AD+SR) to advHandler.
+	// With a scan response wait, scannable ADs are held until their SR
+	// arrives instead, refer to advStitcher.
 	// The adHist and adLast are allocated in the Scan().
 	advHandlerSync bool
 	advHandler     ble.AdvHandler
 	adHist         []*Advertisement
 	adLast         int
 
+	// advDedup, advAggregator and advStitcher filter and merge the
+	// advertisements passed to advHandler.
+	advDedup      advDedup
+	advAggregator advAggregator
+	advStitcher   advStitcher
+
+	// scanFilter drops the reports of the advertisers which aren't allowed.
+	scanFilter scanFilter
+
+	// scanStats counts the reports, and advParseErrorHandler is passed the
+	// malformed ones.
+	scanStats            scanStats
+	advParseErrorHandler func(raw []byte, err error)
+
+	// cmdStats times the commands, by opcode.
+	cmdStats cmdStats
+
+	// advLenient skips malformed AD structures instead of dropping the
+	// advertisement, and advLazy decodes the fields on demand.
+	advLenient bool
+	advLazy    bool
+
 	// Host to Controller Data Flow Control Packet-based Data flow control for LE-U [Vol 2, Part E, 4.1.1]
 	// Minimum 27 bytes. 4 bytes of L2CAP Header, and 23 bytes Payload from upper layer (ATT)
 	pool *Pool
@@ -120,9 +231,80 @@ type HCI struct {
 	chMasterConn chan *Conn // Dial returns master connections.
 	chSlaveConn  chan *Conn // Peripheral accept slave connections.
 
+	// connWrapper, if set, wraps the connections before the ATT client or
+	// server uses them, e.g. to inject faults.
+	connWrapper func(ble.Conn) ble.Conn
+
+	// dialSem serializes Dial, as the controller initiates one connection
+	// at a time. While a Dial's create connection command is pending,
+	// dialing is set and the failed connection attempts of the central role
+	// are passed to it on chDialErr.
+	dialSem   chan struct{}
+	muDial    sync.Mutex
+	dialing   bool
+	chDialErr chan error
+
+	// muAdv serializes the changes of the advertising state, by the user,
+	// by incoming connections and around Dial. advStopped is set while the
+	// controller isn't advertising though advertising is enabled, e.g. after
+	// it accepted a connection; it's resumed as soon as the controller
+	// allows.
+	muAdv      sync.Mutex
+	advStopped bool
+
+	// muScan serializes Scan and StopScanning.
+	muScan sync.Mutex
+
+	// scanSched duty-cycles scans, if configured.
+	scanSched scanScheduler
+
+	// state mirrors the scanning and advertising state for State, so that
+	// it's read without waiting for muScan and muAdv.
+	muState sync.Mutex
+	state   State
+
+	// scanReqHandler is passed the scan requests received by advertising
+	// sets.
+	muScanReq      sync.Mutex
+	scanReqHandler func(ScanRequest)
+
+	// advSetAddrs holds the random addresses set for the advertising sets,
+	// and advSetTxPower the TX power levels the controller selected for them.
+	muAdvSetAddrs sync.Mutex
+	advSetAddrs   map[uint8]ble.Addr
+	advSetTxPower map[uint8]int8
+
+	// advNoRestart leaves advertising stopped after a central connects, and
+	// advMaxConns holds back the restart while as many centrals are
+	// connected, if set.
+	advNoRestart bool
+	advMaxConns  int
+
 	dialerTmo   time.Duration
 	listenerTmo time.Duration
 
+	// aclWriteTimeout bounds the wait for controller buffers on writes.
+	aclWriteTimeout time.Duration
+
+	// aptoTimeout is the authenticated payload timeout applied to encrypted
+	// links, and aptoExpired is notified when it expires.
+	aptoTimeout time.Duration
+	aptoExpired func(ble.Addr)
+
+	// connParamsReqHandler decides on connection parameter changes requested
+	// by remote devices, either over the link layer or L2CAP signaling.
+	connParamsReqHandler ble.ConnParamsRequestHandler
+
+	// prefConnParams are the connection parameters requested from the
+	// centrals prefConnDelay after they connect, and prefConnResult is
+	// notified of the outcome.
+	prefConnParams *ble.ConnParams
+	prefConnDelay  time.Duration
+	prefConnResult ble.ConnParamsResultHandler
+
+	// Isochronous channels, set up by EnableISO.
+	iso isoState
+
 	//error handler
 	errorHandler func(error)
 	err          error
@@ -130,10 +312,50 @@ type HCI struct {
 	muClose sync.Mutex
 	done    chan bool
 
+	// group owns the goroutines started by the device's features, e.g.
+	// its timers and the calls of the handlers of the application.
+	group lifecycle.Group
+
 	sktRxChan chan []byte
 
 	cache ble.GattCache
 
+	// notifWorkers handle the notifications of each connection dialed.
+	notifWorkers int
+
+	// fullUUIDs keeps the UUIDs discovered by the clients uncompressed.
+	fullUUIDs bool
+
+	// coalesceReads coalesces the concurrent reads of the clients.
+	coalesceReads bool
+
+	// gattRate and gattSpacing limit the operations of the clients.
+	gattRate    float64
+	gattSpacing time.Duration
+
+	// orderNotifs makes the reads of the clients wait for the notifications
+	// received before them.
+	orderNotifs bool
+
+	// strictSeq makes the requests of the clients fail while another one
+	// is pending.
+	strictSeq bool
+
+	// rxTimestamps stamps the ACL data packets with the time they're
+	// received at.
+	rxTimestamps bool
+
+	// respTolerance correlates the responses received by the clients with
+	// their pending request.
+	respTolerance time.Duration
+
+	// centralOnly refuses incoming connections, as no GATT server serves
+	// them.
+	centralOnly bool
+
+	// registry, if set, records the devices observed while scanning.
+	registry *registry.Registry
+
 	vendorChan chan []byte
 
 	ocl *opCodeLocker
@@ -149,37 +371,80 @@ func (h *HCI) Init() error {
 	h.evth[evt.DisconnectionCompleteCode] = h.handleDisconnectionComplete
 	h.evth[evt.NumberOfCompletedPacketsCode] = h.handleNumberOfCompletedPackets
 	h.evth[evt.EncryptionChangeCode] = h.handleEncryptionChange
+	h.evth[evt.HardwareErrorCode] = h.handleHardwareError
+	h.evth[evt.ReadRemoteVersionInformationCompleteCode] = h.handleReadRemoteVersionInformationComplete
+	h.evth[evt.AuthenticatedPayloadTimeoutExpiredCode] = h.handleAuthPayloadTimeoutExpired
 
 	h.subh[evt.LEAdvertisingReportSubCode] = h.handleLEAdvertisingReport
 	h.subh[evt.LEConnectionCompleteSubCode] = h.handleLEConnectionComplete
+	h.subh[evt.LEEnhancedConnectionCompleteSubCode] = h.handleLEEnhancedConnectionComplete
 	h.subh[evt.LEConnectionUpdateCompleteSubCode] = h.handleLEConnectionUpdateComplete
+	h.subh[evt.LEChannelSelectionAlgorithmSubCode] = h.handleLEChannelSelectionAlgorithm
+	h.subh[evt.LEScanRequestReceivedSubCode] = h.handleLEScanRequestReceived
 	h.subh[evt.LELongTermKeyRequestSubCode] = h.handleLELongTermKeyRequest
 	h.subh[evt.LERemoteConnectionParameterRequestSubCode] = h.handleLEConnectionParameterRequest
-	// evt.ReadRemoteVersionInformationCompleteCode: todo),
-	// evt.HardwareErrorCode:                        todo),
+	h.subh[evt.LEReadRemoteUsedFeaturesCompleteSubCode] = h.handleLEReadRemoteUsedFeaturesComplete
+	h.subh[evt.LECISEstablishedSubCode] = h.handleLECISEstablished
+	h.subh[evt.LECISRequestSubCode] = h.handleLECISRequest
+	h.subh[evt.LECreateBIGCompleteSubCode] = h.handleLECreateBIGComplete
+	h.subh[evt.LETerminateBIGCompleteSubCode] = h.handleLETerminateBIGComplete
+	h.subh[evt.LEBIGSyncEstablishedSubCode] = h.handleLEBIGSyncEstablished
+	h.subh[evt.LEBIGSyncLostSubCode] = h.handleLEBIGSyncLost
+	h.subh[evt.LEBIGInfoAdvertisingReportSubCode] = h.handleLEBIGInfoAdvertisingReport
 	// evt.DataBufferOverflowCode:                   todo),
 	h.subh[evt.EncryptionKeyRefreshCompleteCode] = h.handleEncryptionKeyRefreshComplete
-	// evt.AuthenticatedPayloadTimeoutExpiredCode:   todo),
-	// evt.LEReadRemoteUsedFeaturesCompleteSubCode:   todo),
 
-	var err error
-	h.skt, err = getTransport(h.transport)
+	skt, err := getTransport(h.transport)
 	if err != nil {
 		return err
 	}
+	h.muSkt.Lock()
+	h.skt = skt
+	h.muSkt.Unlock()
 
 	// check params
 	p := &h.params
+	if h.privacy != nil || h.randomAddr != nil || h.rotation != nil {
+		p.setOwnAddressType(AddressTypePublic, h.ownAddressType())
+	}
 	if err = p.validate(); err != nil {
 		return err
 	}
 	h.setAllowedCommands(1)
 
-	go h.sktReadLoop()
+	var readDone chan struct{}
+	if h.watchdog != nil {
+		readDone = make(chan struct{})
+		h.watchdog.readDone = readDone
+		h.group.Go(h.superviseLoop)
+	}
+	go h.sktReadLoop(skt, readDone)
 	go h.sktProcessLoop()
 	if err := h.init(); err != nil {
 		return err
 	}
+	if err := h.initRandomAddr(); err != nil {
+		return err
+	}
+	if h.rotation != nil && h.privacy != nil {
+		h.privacy.rpaTimeout = h.rotation.period
+		if h.privacy.rpaTimeout < RPATimeoutMin {
+			h.privacy.rpaTimeout = RPATimeoutMin
+		}
+	}
+	if err := h.initPrivacy(); err == errPrivacyUnsupported && h.rotation != nil {
+		// The host rotates RPAs made from the local IRK instead.
+		h.Warn("privacy: not supported by the controller, rotating addresses on the host")
+		h.rotation.irk = append([]byte{}, h.privacy.localIRK[:]...)
+		oat := h.ownAddressType()
+		h.privacy = nil
+		p.setOwnAddressType(oat, h.ownAddressType())
+	} else if err != nil {
+		return err
+	}
+	if err := h.initRotation(); err != nil {
+		return err
+	}
 
 	// Pre-allocate buffers with additional head room for lower layer headers.
 	// HCI header (1 Byte) + ACL Data Header (4 bytes) + L2CAP PDU (or fragment)
@@ -189,6 +454,7 @@ func (h *HCI) Init() error {
 	}
 	h.Send(&p.advParams, nil)
 	h.Send(&p.scanParams, nil)
+	p.scanParamsDirty, p.advParamsDirty = false, false
 	return nil
 }
 
@@ -213,6 +479,7 @@ func (h *HCI) cleanup() {
 	for _, ch := range hh {
 		h.cleanupConnectionHandle(ch)
 	}
+	h.closeISOChannels()
 
 	// clean out all sent commands (prob unneeded)
 	h.muSent.Lock()
@@ -237,18 +504,43 @@ func (h *HCI) Close() error {
 	return nil
 }
 
+// WaitClosed blocks until the goroutines started by the features of the
+// device have returned, which they do once it's closed and the handlers of
+// the application they call returned.
+func (h *HCI) WaitClosed() {
+	h.group.Wait()
+}
+
 // Error ...
 func (h *HCI) Error() error {
+	h.muSkt.RLock()
+	defer h.muSkt.RUnlock()
 	return h.err
 }
 
-// Option sets the options specified.
+// setErr sets the error the device failed with.
+func (h *HCI) setErr(err error) {
+	h.muSkt.Lock()
+	h.err = err
+	h.muSkt.Unlock()
+}
+
+// socket returns the current transport.
+func (h *HCI) socket() io.ReadWriteCloser {
+	h.muSkt.RLock()
+	defer h.muSkt.RUnlock()
+	return h.skt
+}
+
+// Option sets the options specified. Scan and advertising parameters set
+// after Init are applied with the next Scan or Advertise.
 func (h *HCI) Option(opts ...ble.Option) error {
-	var err error
 	for _, opt := range opts {
-		err = opt(h)
+		if err := opt(h); err != nil {
+			return err
+		}
 	}
-	return err
+	return nil
 }
 
 func (h *HCI) isOpen() bool {
@@ -261,8 +553,18 @@ func (h *HCI) isOpen() bool {
 }
 
 func (h *HCI) init() error {
-	h.Debug("hci reset")
-	h.Send(&cmd.Reset{}, nil)
+	for _, c := range h.preInitCmds {
+		if err := h.Send(c, nil); err != nil {
+			return fmt.Errorf("pre-init command %v: %v", c, err)
+		}
+	}
+
+	if h.skipReset {
+		h.Debug("hci reset skipped")
+	} else {
+		h.Debug("hci reset")
+		h.Send(&cmd.Reset{}, nil)
+	}
 
 	ReadBDADDRRP := cmd.ReadBDADDRRP{}
 	h.Send(&cmd.ReadBDADDR{}, &ReadBDADDRRP)
@@ -291,21 +593,66 @@ func (h *HCI) init() error {
 	LEReadAdvertisingChannelTxPowerRP := cmd.LEReadAdvertisingChannelTxPowerRP{}
 	h.Send(&cmd.LEReadAdvertisingChannelTxPower{}, &LEReadAdvertisingChannelTxPowerRP)
 
-	h.txPwrLv = int(LEReadAdvertisingChannelTxPowerRP.TransmitPowerLevel)
+	h.txPwrLv = int(int8(LEReadAdvertisingChannelTxPowerRP.TransmitPowerLevel))
 
-	LESetEventMaskRP := cmd.LESetEventMaskRP{}
-	h.Send(&cmd.LESetEventMask{LEEventMask: 0x000000000000001F}, &LESetEventMaskRP)
-
-	SetEventMaskRP := cmd.SetEventMaskRP{}
-	h.Send(&cmd.SetEventMask{EventMask: 0x3dbff807fffbffff}, &SetEventMaskRP)
+	h.setEventMask()
 
 	WriteLEHostSupportRP := cmd.WriteLEHostSupportRP{}
 	h.Send(&cmd.WriteLEHostSupport{LESupportedHost: 1, SimultaneousLEHost: 0}, &WriteLEHostSupportRP)
 
+	if h.leOnly {
+		if err := h.configureLEOnly(); err != nil {
+			return err
+		}
+	}
+
 	WriteDefaultDataLengthRP := cmd.LEWriteSuggestedDefaultDataLengthRP{}
 	h.Send(&cmd.LEWriteSuggestedDefaultDataLength{SuggestedMaxTxOctets: 251, SuggestedMaxTxTime: 2120}, &WriteDefaultDataLengthRP)
 
-	return h.err
+	if err := h.Error(); err != nil {
+		return err
+	}
+	for _, c := range h.postInitCmds {
+		if err := h.Send(c, nil); err != nil {
+			return fmt.Errorf("post-init command %v: %v", c, err)
+		}
+	}
+	return nil
+}
+
+// setEventMask unmasks the events handled by default, plus the ones
+// requested by the user.
+func (h *HCI) setEventMask() error {
+	// Remote Connection Parameter Request events are only unmasked when
+	// the user supplied a policy; otherwise the controller handles them.
+	leEventMask := uint64(0x000000000000001F) | leEvtMaskChannelSelAlgorithm | h.leEvtMask
+	if h.connParamsReqHandler != nil {
+		leEventMask |= leEvtMaskRemoteConnParamsReq
+	}
+	if h.privacy != nil || h.params.extConnParams != nil {
+		leEventMask |= leEvtMaskEnhancedConnComplete
+	}
+	if h.isoEnabled() {
+		leEventMask |= leEvtMaskISO | leEvtMaskBIGInfo
+	}
+	if h.scanRequestHandler() != nil {
+		leEventMask |= leEvtMaskScanRequestReceived
+	}
+	LESetEventMaskRP := cmd.LESetEventMaskRP{}
+	if err := h.Send(&cmd.LESetEventMask{LEEventMask: leEventMask}, &LESetEventMaskRP); err != nil {
+		return err
+	}
+
+	SetEventMaskRP := cmd.SetEventMaskRP{}
+	if err := h.Send(&cmd.SetEventMask{EventMask: 0x3dbff807fffbffff | h.evtMask}, &SetEventMaskRP); err != nil {
+		return err
+	}
+
+	// Not all controllers support page 2, which only has optional events.
+	if err := h.setEventMaskPage2(); err != nil {
+		h.Warnf("setEventMaskPage2: %v", err)
+	}
+	return nil
 }
 
 // Send ...
@@ -351,8 +698,8 @@ func (h *HCI) getTxBuf() ([]byte, error) {
 }
 
 func (h *HCI) send(c Command) ([]byte, error) {
-	if h.err != nil {
-		return nil, h.err
+	if err := h.Error(); err != nil {
+		return nil, err
 	}
 
 	p := &pkt{c, make(chan []byte)}
@@ -404,9 +751,10 @@ func (h *HCI) send(c Command) ([]byte, error) {
 	h.muSent.Unlock()
 
 	h.Debugf("tx op: %v - %v", c.OpCode(), hex.EncodeToString(b))
+	start := time.Now()
 	if !h.isOpen() {
 		return nil, fmt.Errorf("hci closed")
-	} else if n, err := h.skt.Write(b[:4+c.Len()]); err != nil {
+	} else if n, err := h.socket().Write(b[:4+c.Len()]); err != nil {
 		h.close(fmt.Errorf("hci: failed to send cmd"))
 	} else if n != 4+c.Len() {
 		h.close(fmt.Errorf("hci: failed to send whole cmd pkt to hci socket"))
@@ -422,13 +770,16 @@ func (h *HCI) send(c Command) ([]byte, error) {
 		err = fmt.Errorf("hci: no response to command, hci connection failed")
 		h.Errorf("%v - cmd 0x%x (%v) pkt: %x", err, c.OpCode(), c.String(), b[:4+c.Len()])
 		h.dispatchError(err)
+		h.fault(err)
 		ret = nil
+		h.cmdStats.timedOut(c)
 	case <-h.done:
-		err = h.err
+		err = h.Error()
 		ret = nil
 	case b := <-p.done:
 		err = nil
 		ret = b
+		h.cmdStats.answered(c, time.Since(start), b)
 	}
 
 	// clear sent table when done, we sometimes get command complete or
@@ -444,7 +795,7 @@ func (h *HCI) send(c Command) ([]byte, error) {
 func (h *HCI) sktProcessLoop() {
 
 	defer h.cleanup()
-	defer h.dispatchError(h.err)
+	defer func() { h.dispatchError(h.Error()) }()
 
 	for {
 		var p []byte
@@ -453,13 +804,18 @@ func (h *HCI) sktProcessLoop() {
 		select {
 		case <-h.done:
 			h.Debugf("sktProcessLoop: close requested")
-			h.err = io.EOF
+			h.setErr(io.EOF)
 			return
 
 		case p, ok = <-h.sktRxChan:
 			if !ok {
 				h.Debugf("sktProcessLoop: rx channel closed")
-				h.err = io.EOF
+				// Keep the read loop's error, e.g. a lost adapter.
+				h.muSkt.Lock()
+				if h.err == nil {
+					h.err = io.EOF
+				}
+				h.muSkt.Unlock()
 				return
 			}
 			// will process the bytes below
@@ -471,8 +827,19 @@ func (h *HCI) sktProcessLoop() {
 	}
 }
 
-func (h *HCI) sktReadLoop() {
+// sktReadLoop reads packets from skt. If the watchdog is enabled, transport
+// failures are reported to it and readDone is closed on exit; otherwise the
+// processing loop is terminated.
+func (h *HCI) sktReadLoop(skt io.ReadWriteCloser, readDone chan struct{}) {
 	defer func() {
+		if readDone != nil {
+			close(readDone)
+			if h.isOpen() {
+				h.Debugf("sktReadLoop: done, reporting to watchdog")
+				h.fault(fmt.Errorf("transport failed: %w", h.Error()))
+				return
+			}
+		}
 		h.Debugf("sktReadLoop: done, closing sktRxChan")
 		close(h.sktRxChan)
 	}()
@@ -480,7 +847,7 @@ func (h *HCI) sktReadLoop() {
 	b := make([]byte, 4096)
 
 	for {
-		n, err := h.skt.Read(b)
+		n, err := skt.Read(b)
 
 		switch {
 		case n == 0 && err == nil:
@@ -493,13 +860,13 @@ func (h *HCI) sktReadLoop() {
 				continue
 			}
 
-		//callers depend on detecting io.EOF, don't wrap it.
-		case err == io.EOF:
-			h.err = err
+		//callers depend on detecting io.EOF and a lost adapter, don't wrap them.
+		case err == io.EOF || err == ble.ErrAdapterLost:
+			h.setErr(err)
 			return
 
 		case err != nil:
-			h.err = fmt.Errorf("skt read error: %v", err)
+			h.setErr(fmt.Errorf("skt read error: %v", err))
 			return
 
 		default:
@@ -520,8 +887,11 @@ func (h *HCI) sktReadLoop() {
 }
 
 func (h *HCI) close(err error) error {
+	h.muSkt.Lock()
 	h.err = err
-	return h.skt.Close()
+	skt := h.skt
+	h.muSkt.Unlock()
+	return skt.Close()
 }
 
 func (h *HCI) handlePkt(b []byte) error {
@@ -529,10 +899,13 @@ func (h *HCI) handlePkt(b []byte) error {
 	t, b := b[0], b[1:]
 	switch t {
 	case pktTypeACLData:
-		h.Debugf("hci rx acl: %v", hex.EncodeToString(b))
+		h.logs(ble.LogACL).Debugf("hci rx acl: %v", hex.EncodeToString(b))
 		return h.handleACL(b)
 	case pktTypeEvent:
+		h.tapEvent(b)
 		return h.handleEvt(b)
+	case pktTypeISOData:
+		return h.handleISO(b)
 
 		//unhandled stuff
 	case pktTypeCommand:
@@ -547,13 +920,17 @@ func (h *HCI) handlePkt(b []byte) error {
 }
 
 func (h *HCI) handleACL(b []byte) error {
-	handle := packet(b).handle()
+	in := rxPacket{packet: b}
+	if h.rxTimestamps {
+		in.at = time.Now()
+	}
+	handle := in.handle()
 
 	h.muConns.Lock()
 	defer h.muConns.Unlock()
 
 	if c, ok := h.conns[handle]; ok {
-		c.chInPkt <- b
+		c.chInPkt <- in
 	} else {
 		h.Warnf("handleACL: invalid connection handle %v", handle)
 	}
@@ -573,6 +950,15 @@ func (h *HCI) handleEvt(b []byte) error {
 		}
 	}
 
+	uf := h.userEventHandler(code)
+	if uf != nil {
+		defer func() {
+			if err := uf(b[2:]); err != nil {
+				h.Errorf("user event handler for %v failed: %v", code, err)
+			}
+		}()
+	}
+
 	if f := h.evth[code]; f != nil {
 		if err := f(b[2:]); err != nil {
 			h.Errorf("event handler for %v failed: %v", code, err)
@@ -580,24 +966,47 @@ func (h *HCI) handleEvt(b []byte) error {
 		return nil
 	}
 	if code == evt.VendorEventCode {
+		// Vendor events the user subscribed to may be unsolicited.
+		if uf != nil && !h.vendorCommandPending() {
+			return nil
+		}
 		err := h.handleVendorEvent(b[2:])
 		//vendor commands should be reported up the stack
 		h.dispatchError(err)
 		return nil
 	}
+	if uf != nil {
+		return nil
+	}
 	return fmt.Errorf("unsupported event packet: % X", b)
 }
 
 func (h *HCI) handleLEMeta(b []byte) error {
 	subcode := int(b[0])
+	uf := h.userLEEventHandler(subcode)
+	if uf != nil {
+		defer func() {
+			if err := uf(b); err != nil {
+				h.Errorf("user LE event handler for %v failed: %v", subcode, err)
+			}
+		}()
+	}
 	if f := h.subh[subcode]; f != nil {
 		return f(b)
 	}
+	if uf != nil {
+		return nil
+	}
 	return fmt.Errorf("unsupported LE event: % X", b)
 }
 
 func (h *HCI) makeAdvError(e error, b []byte, dispatch bool) error {
+	h.scanStats.parseError()
+	if h.advParseErrorHandler != nil {
+		h.advParseErrorHandler(append([]byte(nil), b...), e)
+	}
 	err := fmt.Errorf("%v, bytes %v", e, b)
+	h.logs(ble.LogScan).Debugf("adv: %v", err)
 	if dispatch {
 		h.dispatchError(err)
 	}
@@ -620,6 +1029,9 @@ func (h *HCI) handleLEAdvertisingReport(b []byte) error {
 		return ee
 	}
 
+	rx := time.Now()
+	h.scanStats.received(int(nr), rx)
+
 	//DSC: zephyr currently returns 1 report per report wrapper
 	if nr != 1 {
 		ee := h.makeAdvError(fmt.Errorf("invalid rep count %v", nr), e, true)
@@ -633,12 +1045,16 @@ func (h *HCI) handleLEAdvertisingReport(b []byte) error {
 			h.makeAdvError(errors.Wrap(err, "advRep eventType"), e, true)
 			continue
 		}
+		if !h.admitAdv(e, i) {
+			h.scanStats.filtered()
+			continue
+		}
 
 		switch et {
 		case evtTypAdvInd: //0x00
 			fallthrough
 		case evtTypAdvScanInd: //0x02
-			a, err = newAdvertisement(e, i)
+			a, err = h.newAdvertisement(e, i, rx)
 			if err != nil {
 				h.makeAdvError(errors.Wrap(err, fmt.Sprintf("newAdv (typ %v)", et)), e, true)
 				continue
@@ -652,7 +1068,7 @@ func (h *HCI) handleLEAdvertisingReport(b []byte) error {
 			//advInd, advScanInd
 
 		case evtTypScanRsp: //0x04
-			sr, err := newAdvertisement(e, i)
+			sr, err := h.newAdvertisement(e, i, rx)
 			if err != nil {
 				h.makeAdvError(errors.Wrap(err, fmt.Sprintf("newAdv (typ %v)", et)), e, true)
 				continue
@@ -685,13 +1101,16 @@ func (h *HCI) handleLEAdvertisingReport(b []byte) error {
 
 				//set the scan response here
 				if addrh.String() == addrsr.String() {
-					//this will leave everything alone if there is an error when we attach the scanresp
-					err = h.adHist[idx].setScanResponse(sr)
+					//this will leave everything alone if there is an error when we attach the scanresp.
+					//attach it to a copy, as the handler may still use the dispatched advertisement.
+					ac := *h.adHist[idx]
+					err = ac.setScanResponse(sr)
 					if err != nil {
 						h.makeAdvError(errors.Wrap(err, fmt.Sprintf("setScanResp (typ %v)", et)), e, true)
 						break
 					}
-					a = h.adHist[idx]
+					h.adHist[idx] = &ac
+					a = &ac
 					break
 				}
 			} //for
@@ -701,12 +1120,13 @@ func (h *HCI) handleLEAdvertisingReport(b []byte) error {
 				ee := h.makeAdvError(errors.Wrap(err, fmt.Sprintf("scanRsp (typ %v) w/o associated advData, srAddr %v", et, sr.Addr())), e, true)
 				return ee
 			}
+			h.releaseAdv(a.Addr().String())
 			// sr
 
 		case evtTypAdvDirectInd: //0x01
 			fallthrough
 		case evtTypAdvNonconnInd: //0x03
-			a, err = newAdvertisement(e, i)
+			a, err = h.newAdvertisement(e, i, rx)
 			if err != nil {
 				h.makeAdvError(errors.Wrap(err, fmt.Sprintf("newAdv (typ %v)", et)), e, true)
 				continue
@@ -721,19 +1141,35 @@ func (h *HCI) handleLEAdvertisingReport(b []byte) error {
 			h.makeAdvError(fmt.Errorf("nil advertisement (i %v, typ %v)", i, et), e, true)
 			continue
 		}
-
-		//dispatch
-		if h.advHandlerSync {
-			h.advHandler(a)
-		} else {
-			go h.advHandler(a)
+		if (et == evtTypAdvInd || et == evtTypAdvScanInd) && h.holdAdv(a) {
+			continue
 		}
+		h.deliverAdv(a)
 
 	} //for
 
 	return nil
 }
 
+// deliverAdv resolves the identity of the advertiser of a, and passes it to
+// the handler unless it's a duplicate, or to the aggregator.
+func (h *HCI) deliverAdv(a *Advertisement) {
+	if h.resolver != nil && a.identity == nil {
+		a.resolveIdentity(h.resolver)
+	}
+	h.scanStats.parsed(a.Addr().String())
+	h.logs(ble.LogScan).Debugf("adv: %v, event type %d, rssi %d", a.Addr(), a.EventType(), a.RSSI())
+
+	if h.advDedup.dup(a.Addr().String(), a.EventType(), a.Data(), a.ScanResponse(), a.rx) {
+		return
+	}
+	if h.aggregating() {
+		h.advAggregator.add(a)
+		return
+	}
+	h.dispatchAdv(a)
+}
+
 func (h *HCI) handleCommandComplete(b []byte) error {
 	e := evt.CommandComplete(b)
 	h.setAllowedCommands(int(e.NumHCICommandPackets()))
@@ -786,58 +1222,160 @@ func (h *HCI) handleCommandStatus(b []byte) error {
 }
 
 func (h *HCI) handleLEConnectionComplete(b []byte) error {
-	e := evt.LEConnectionComplete(b)
+	return h.connectionComplete(evt.LEConnectionComplete(b), connRPA{})
+}
+
+// handleLEEnhancedConnectionComplete handles the connection complete event
+// delivered instead of LEConnectionComplete when privacy is enabled.
+func (h *HCI) handleLEEnhancedConnectionComplete(b []byte) error {
+	e := evt.LEEnhancedConnectionComplete(b)
+	if len(e) < 31 {
+		return fmt.Errorf("invalid enhanced connection complete: % X", b)
+	}
+
+	// Strip the RPAs to get an equivalent LEConnectionComplete.
+	lecc := make(evt.LEConnectionComplete, 0, 19)
+	lecc = append(lecc, evt.LEConnectionCompleteSubCode)
+	lecc = append(lecc, e[1:12]...)
+	lecc = append(lecc, e[24:31]...)
 
+	return h.connectionComplete(lecc, connRPA{
+		local: e.LocalResolvablePrivateAddress(),
+		peer:  e.PeerResolvablePrivateAddress(),
+	})
+}
+
+func (h *HCI) connectionComplete(e evt.LEConnectionComplete, rpa connRPA) error {
 	if status := e.Status(); status != 0 {
 		h.Warnf("connectionComplete: connection failed with status %X", status)
+		if ErrCommand(status) == ErrDirAdvTimeout || e.Role() != roleMaster {
+			// Directed advertising ended without a connection, or another
+			// attempt of the peripheral role failed. It's not the outcome
+			// of a Dial.
+			return nil
+		}
+		h.dialFailed(ErrCommand(status))
 		return nil
 	}
 
+	if la, ok := h.rotatedLocalAddr(e.Role()); ok && rpa.local == ([6]byte{}) {
+		rpa.local = la
+	}
 	pa := e.PeerAddress()
 	addr := hex.EncodeToString(sliceops.SwapBuf(pa[:]))
-	c := newConn(h, e, addr)
+	c := newConn(h, e, rpa, addr)
 	h.muConns.Lock()
-	h.Debugf("connectionComplete: handle %04x, addr %v, lecc evt %X", e.ConnectionHandle(), addr, b)
+	h.Debugf("connectionComplete: handle %04x, addr %v, lecc evt %X", e.ConnectionHandle(), addr, []byte(e))
 	h.conns[e.ConnectionHandle()] = c
 	h.muConns.Unlock()
 
+	// Commands can't be sent from the event loop.
+	go c.readRemoteInfo()
+
 	if e.Role() == roleMaster {
-		if e.Status() == 0x00 {
-			select {
-			case h.chMasterConn <- c:
-			case <-time.After(100 * time.Millisecond):
-				go c.Close()
-			}
-			return nil
-		}
-		if ErrCommand(e.Status()) == ErrConnID {
-			// The connection was canceled successfully.
-			return nil
+		select {
+		case h.chMasterConn <- c:
+		case <-time.After(100 * time.Millisecond):
+			go c.Close()
 		}
 		return nil
 	}
-	if e.Status() == 0x00 {
-		h.chSlaveConn <- c
-		// When a controller accepts a connection, it moves from advertising
-		// state to idle/ready state. Host needs to explicitly ask the
-		// controller to re-enable advertising. Note that the host was most
-		// likely in advertising state. Otherwise it couldn't accept the
-		// connection in the first place. The only exception is that user
-		// asked the host to stop advertising during this tiny window.
-		// The re-enabling might failed or ignored by the controller, if
-		// it had reached the maximum number of concurrent connections.
-		// So we also re-enable the advertising when a connection disconnected
-		h.params.RLock()
-		if h.params.advEnable.AdvertisingEnable == 1 {
-			go h.Send(&h.params.advEnable, nil)
-		}
-		h.params.RUnlock()
+
+	if h.centralOnly {
+		h.Warnf("connectionComplete: incoming connection from %v refused, central-only device", addr)
+		go c.Close()
+		return nil
+	}
+
+	go c.requestPreferredConnParams()
+
+	// When a controller accepts a connection, it stops advertising. The host
+	// re-enables it, unless the user stopped advertising in the meantime. It
+	// may be refused, if the controller reached its connection limit, in
+	// which case it's retried when a connection drops.
+	go h.advertisingStopped()
+	select {
+	case h.chSlaveConn <- c:
+	case <-h.done:
 	}
 	return nil
 }
 
 func (h *HCI) handleLEConnectionParameterRequest(b []byte) error {
-	h.Warn("LEConnectionParameterRequest: ignored")
+	e := evt.LERemoteConnectionParameterRequest(b)
+	if len(e) < 11 {
+		return fmt.Errorf("invalid remote connection parameter request: % X", b)
+	}
+
+	c := h.findConnection(e.ConnectionHandle())
+	if c == nil {
+		return fmt.Errorf("connParamsRequest: unknown connection handle %04X", e.ConnectionHandle())
+	}
+
+	req := ble.ConnParams{
+		IntervalMin: e.IntervalMin(),
+		IntervalMax: e.IntervalMax(),
+		Latency:     e.Latency(),
+		Timeout:     e.Timeout(),
+	}
+
+	// The reply is a command, which can't be sent from the event loop, and
+	// the user policy shouldn't stall it either.
+	go func() {
+		p, ok := h.connParamsRequestPolicy(c.RemoteAddr(), req)
+		if !ok {
+			c.Infof("connParamsRequest: rejected %+v", req)
+			h.Send(&cmd.LERemoteConnectionParameterRequestNegativeReply{
+				ConnectionHandle: e.ConnectionHandle(),
+				Reason:           uint8(ErrConnParams),
+			}, nil)
+			return
+		}
+
+		c.Debugf("connParamsRequest: accepted %+v as %+v", req, p)
+		h.Send(&cmd.LERemoteConnectionParameterRequestReply{
+			ConnectionHandle: e.ConnectionHandle(),
+			IntervalMin:      p.IntervalMin,
+			IntervalMax:      p.IntervalMax,
+			Latency:          p.Latency,
+			Timeout:          p.Timeout,
+			MinimumCELength:  0, // Informational, and spec doesn't specify the use.
+			MaximumCELength:  0, // Informational, and spec doesn't specify the use.
+		}, nil)
+	}()
+	return nil
+}
+
+// connParamsRequestPolicy applies the user policy, if any, to a connection
+// parameter request. Parameters modified into an invalid set are rejected.
+func (h *HCI) connParamsRequestPolicy(a ble.Addr, req ble.ConnParams) (ble.ConnParams, bool) {
+	if h.connParamsReqHandler == nil {
+		return req, true
+	}
+	p, ok := h.connParamsReqHandler(a, req)
+	if !ok {
+		return req, false
+	}
+	if err := ValidateConnParamsRequest(p); err != nil {
+		h.Warnf("connParamsRequest: handler returned %v, rejecting", err)
+		return req, false
+	}
+	return p, true
+}
+
+// handleLEChannelSelectionAlgorithm records the channel selection algorithm
+// of a new connection, reported right after it's complete.
+func (h *HCI) handleLEChannelSelectionAlgorithm(b []byte) error {
+	e := evt.LEChannelSelectionAlgorithm(b)
+	if len(e) < 4 {
+		return fmt.Errorf("invalid channel selection algorithm event: % X", b)
+	}
+	c := h.findConnection(e.ConnectionHandle())
+	if c == nil {
+		return nil
+	}
+	// 0x00 is algorithm #1, 0x01 algorithm #2.
+	atomic.StoreInt32(&c.csa, int32(e.ChannelSelectionAlgorithm())+1)
 	return nil
 }
 
@@ -858,21 +1396,14 @@ func (h *HCI) cleanupConnectionHandle(ch uint16) error {
 	h.Debugf("cleanupConnectionHandle %04X: found device with address %v", ch, c.RemoteAddr().String())
 	delete(h.conns, ch)
 
-	if !h.isOpen() && c.param.Role() == roleSlave {
-		// Re-enable advertising, if it was advertising. Refer to the
-		// handleLEConnectionComplete() for details.
-		// This may failed with ErrCommandDisallowed, if the controller
-		// was actually in advertising state. It does no harm though.
-		h.params.RLock()
-		if h.params.advEnable.AdvertisingEnable == 1 {
-			go h.Send(&h.params.advEnable, nil)
-		}
-		h.params.RUnlock()
-	} else {
-		// remote peripheral disconnected
-		h.Debugf("cleanupConnectionHandle %04X: close c.chDone", ch)
-		close(c.chDone)
+	// A connection slot is free again, so advertising refused at the
+	// connection limit can resume. Refer to connectionComplete() for
+	// details.
+	if h.isOpen() {
+		go h.resumeAdvertising()
 	}
+	h.Debugf("cleanupConnectionHandle %04X: close c.chDone", ch)
+	close(c.chDone)
 
 	//Clean up this channel after we close chDone. Otherwise, closing this channel before
 	//causes a spurious error in the att client packet handling loop
@@ -881,13 +1412,8 @@ func (h *HCI) cleanupConnectionHandle(ch uint16) error {
 
 	// When a connection disconnects, all the sent packets and weren't acked yet
 	// will be recycled. [Vol2, Part E 4.3]
-	//
-	// must be done with the pool locked to avoid race conditions where
-	// writePDU is in progress and does a Get from the pool after this completes,
-	// leaking a buffer from the main pool.
-	c.txBuffer.LockPool()
+	// Gets in progress fail afterwards, so no buffer is leaked.
 	c.txBuffer.PutAll()
-	c.txBuffer.UnlockPool()
 	return nil
 }
 
@@ -895,6 +1421,10 @@ func (h *HCI) handleDisconnectionComplete(b []byte) error {
 	e := evt.DisconnectionComplete(b)
 	ch := e.ConnectionHandle()
 	h.Debugf("disconnectComplete: handle %04X, reason %02X", ch, e.Reason())
+	if h.closeISOChannel(ch) {
+		// A CIS, which has no L2CAP connection to clean up.
+		return nil
+	}
 	if ErrCommand(e.Reason()) == ErrLocalHost {
 		//if the local host triggered the disconnect, the connection handle was already
 		//cleaned up. otherwise, the connection handle will be cleaned up because this
@@ -934,12 +1464,13 @@ func (h *HCI) handleEncryptionKeyRefreshComplete(b []byte) error {
 
 func (h *HCI) handleNumberOfCompletedPackets(b []byte) error {
 	e := evt.NumberOfCompletedPackets(b)
-	h.Debugf("numberOfCompletedPackets: %v", hex.EncodeToString(b))
+	h.logs(ble.LogACL).Debugf("numberOfCompletedPackets: %v", hex.EncodeToString(b))
 	h.muConns.Lock()
 	defer h.muConns.Unlock()
 	for i := 0; i < int(e.NumberOfHandles()); i++ {
 		c, found := h.conns[e.ConnectionHandle(i)]
 		if !found {
+			h.isoCompletedPackets(e.ConnectionHandle(i), int(e.HCNumOfCompletedPackets(i)))
 			continue
 		}
 
@@ -952,10 +1483,16 @@ func (h *HCI) handleNumberOfCompletedPackets(b []byte) error {
 }
 
 func (h *HCI) handleLELongTermKeyRequest(b []byte) error {
-	//todo: probably need to support this
 	e := evt.LELongTermKeyRequest(b)
-	h.Logger.Errorf("LELongTermKeyRequest: not supported - pkt %X", e)
-	return fmt.Errorf("not supported")
+
+	c := h.findConnection(e.ConnectionHandle())
+	if c == nil {
+		return fmt.Errorf("longTermKeyRequest: unknown connection handle %04X", e.ConnectionHandle())
+	}
+
+	// Commands can't be sent from the event loop.
+	go c.replyLongTermKey(e.EncryptionDiversifier(), e.RandomNumber())
+	return nil
 }
 
 func (h *HCI) setAllowedCommands(n int) {
@@ -982,6 +1519,13 @@ func (h *HCI) setAllowedCommands(n int) {
 	}
 }
 
+func (h *HCI) vendorCommandPending() bool {
+	h.muSent.Lock()
+	defer h.muSent.Unlock()
+	_, found := h.sent[ogfVendorSpecificDebug]
+	return found
+}
+
 func (h *HCI) handleVendorEvent(b []byte) error {
 	//find the opcode
 	h.muSent.Lock()
@@ -16,12 +16,67 @@ func BenchmarkAdv2Map(b *testing.B) {
 
 	for i := 0; i < b.N; i++ {
 		e := evt.LEAdvertisingReport{2, 1, 3, 1, 144, 17, 101, 210, 60, 246, 30, 2, 1, 2, 26, 255, 76, 0, 2, 21, 255, 254, 45, 18, 30, 75, 15, 164, 153, 78, 4, 99, 49, 239, 205, 171, 52, 18, 120, 86, 195, 205}
-		a, _ := newAdvertisement(e, 0)
+		a, _ := newAdvertisement(e, 0, false)
 		rr, _ = a.ToMap()
 	}
 	r = rr
 }
 
+func BenchmarkAdvLazyAccessors(b *testing.B) {
+	e := evt.LEAdvertisingReport{2, 1, 3, 1, 144, 17, 101, 210, 60, 246, 30, 2, 1, 2, 26, 255, 76, 0, 2, 21, 255, 254, 45, 18, 30, 75, 15, 164, 153, 78, 4, 99, 49, 239, 205, 171, 52, 18, 120, 86, 195, 205}
+	for _, lazy := range []bool{false, true} {
+		b.Run(map[bool]string{false: "map", true: "lazy"}[lazy], func(b *testing.B) {
+			b.ReportAllocs()
+			var n int
+			for i := 0; i < b.N; i++ {
+				var a *Advertisement
+				if lazy {
+					a, _ = newLazyAdvertisement(e, 0)
+				} else {
+					a, _ = newAdvertisement(e, 0, false)
+				}
+				n += len(a.ManufacturerData()) + a.TxPowerLevel()
+			}
+			r = n
+		})
+	}
+}
+
+func TestAdvLazy(t *testing.T) {
+	reports := []evt.LEAdvertisingReport{
+		// ibeacon
+		{2, 1, 3, 1, 144, 17, 101, 210, 60, 246, 30, 2, 1, 2, 26, 255, 76, 0, 2, 21, 255, 254, 45, 18, 30, 75, 15, 164, 153, 78, 4, 99, 49, 239, 205, 171, 52, 18, 120, 86, 195, 205},
+		// uuid16, uuid128
+		{2, 1, 3, 1, 1, 2, 3, 4, 5, 6, 24, 5, 2, 1, 2, 11, 22, 17, 6, 1, 2, 3, 4, 5, 6, 7, 8, 9, 1, 2, 3, 4, 5, 6, 7, 16},
+	}
+	for _, e := range reports {
+		a, err := newAdvertisement(e, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := newLazyAdvertisement(e, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(l.ManufacturerData(), a.ManufacturerData()) ||
+			!reflect.DeepEqual(l.Services(), a.Services()) ||
+			l.LocalName() != a.LocalName() || l.TxPowerLevel() != a.TxPowerLevel() {
+			t.Errorf("lazy fields differ: % X", e)
+		}
+		lm, err := l.ToMap()
+		if err != nil {
+			t.Fatal(err)
+		}
+		am, _ := a.ToMap()
+		if !reflect.DeepEqual(lm, am) {
+			t.Errorf("lazy map = %v, want %v", lm, am)
+		}
+		if !reflect.DeepEqual(l.IBeacon(), a.IBeacon()) {
+			t.Errorf("lazy ibeacon = %v, want %v", l.IBeacon(), a.IBeacon())
+		}
+	}
+}
+
 func TestAdvDecode(t *testing.T) {
 	/*
 		2, (subevt code)
@@ -43,7 +98,7 @@ func TestAdvDecode(t *testing.T) {
 		197 (rssi)
 	*/
 	bad := evt.LEAdvertisingReport{2, 1, 0, 0, 45, 58, 130, 157, 134, 122, 29, 2, 1, 6, 2, 5, 9, 67, 97, 115, 99, 97, 100, 101, 45, 67, 48, 51, 49, 48, 54, 49, 56, 51, 52, 45, 48, 48, 49, 57, 49, 197}
-	a, err := newAdvertisement(bad, 0)
+	a, err := newAdvertisement(bad, 0, false)
 	t.Log(a, err)
 	if err == nil {
 		t.Fatal("no error on malformed payload")
@@ -51,7 +106,7 @@ func TestAdvDecode(t *testing.T) {
 
 	//good ibeacon
 	good := evt.LEAdvertisingReport{2, 1, 3, 1, 144, 17, 101, 210, 60, 246, 30, 2, 1, 2, 26, 255, 76, 0, 2, 21, 255, 254, 45, 18, 30, 75, 15, 164, 153, 78, 4, 99, 49, 239, 205, 171, 52, 18, 120, 86, 195, 205}
-	a, err = newAdvertisement(good, 0)
+	a, err = newAdvertisement(good, 0, false)
 	t.Log(a, err)
 	if err != nil {
 		t.Fatal(err)
@@ -67,7 +122,7 @@ func TestAdvDecode(t *testing.T) {
 		9, 1, 2, 3,
 		4, 5, 6, 7,
 		16}
-	a, err = newAdvertisement(good, 0)
+	a, err = newAdvertisement(good, 0, false)
 	t.Log(a, err)
 	if err != nil {
 		t.Fatal(err)
@@ -105,7 +160,7 @@ func TestAdvDecode(t *testing.T) {
 
 	//good mfg data (ruuvi mode 3)
 	good = evt.LEAdvertisingReport{2, 1, 3, 1, 1, 2, 3, 4, 5, 6, 21, 0x02, 0x01, 0x06, 0x11, 0xFF, 0x99, 0x04, 0x03, 0x4B, 0x16, 0x19, 0xC7, 0x3B, 0xFF, 0xFF, 0x00, 0x0C, 0x03, 0xE0, 0x0B, 0x89, 255}
-	a, err = newAdvertisement(good, 0)
+	a, err = newAdvertisement(good, 0, false)
 	t.Log(a, err)
 	if err != nil {
 		t.Fatal(err)
@@ -145,3 +200,38 @@ func TestAdvDecode(t *testing.T) {
 		t.Fatal("mfgData mismatch")
 	}
 }
+
+func TestConnectionCompleteFailure(t *testing.T) {
+	h := &HCI{chDialErr: make(chan error, 1), Logger: ble.GetLogger()}
+	failed := func(status, role uint8) evt.LEConnectionComplete {
+		e := make(evt.LEConnectionComplete, 19)
+		e[0], e[1], e[4] = evt.LEConnectionCompleteSubCode, status, role
+		return e
+	}
+	dialErr := func() error {
+		select {
+		case err := <-h.chDialErr:
+			return err
+		default:
+			return nil
+		}
+	}
+
+	// Without a pending Dial, failures are dropped.
+	h.connectionComplete(failed(0x3E, roleMaster), connRPA{})
+	if err := dialErr(); err != nil {
+		t.Fatalf("failure without a dial: %v", err)
+	}
+
+	// With one, those of the peripheral role are still dropped.
+	h.setDialing(true)
+	h.connectionComplete(failed(uint8(ErrDirAdvTimeout), roleSlave), connRPA{})
+	h.connectionComplete(failed(0x3E, roleSlave), connRPA{})
+	if err := dialErr(); err != nil {
+		t.Fatalf("peripheral failure passed to the dial: %v", err)
+	}
+	h.connectionComplete(failed(0x3E, roleMaster), connRPA{})
+	if err := dialErr(); err != ErrCommand(0x3E) {
+		t.Fatalf("dial failure %v", err)
+	}
+}
@@ -0,0 +1,849 @@
+package hci
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/leso-kn/ble/linux/hci/cmd"
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+// ErrISONotSupported is returned when the controller has no ISO data buffers.
+var ErrISONotSupported = errors.New("controller doesn't support isochronous channels")
+
+// ISO data path directions [Vol 2, Part E, 7.8.109].
+const (
+	ISODataPathInput  = 0x00 // Host to Controller.
+	ISODataPathOutput = 0x01 // Controller to Host.
+)
+
+// Packet status flags of received SDUs [Vol 2, Part E, 5.4.5].
+const (
+	ISOStatusValid           = 0x00 // Valid data.
+	ISOStatusPossiblyInvalid = 0x01 // Possibly invalid data.
+	ISOStatusLost            = 0x02 // Part of the data was lost.
+)
+
+// Packet boundary flags of HCI ISO Data Packet [Vol 2, Part E, 5.4.5].
+const (
+	isoPBFirst    = 0x00 // First fragment of a fragmented SDU.
+	isoPBContinue = 0x01 // Continuation fragment.
+	isoPBComplete = 0x02 // Complete SDU.
+	isoPBLast     = 0x03 // Last fragment.
+)
+
+const (
+	isoMaxSDULen  = 0x0FFF
+	isoRxQueueLen = 16
+
+	// leHostFeatureCIS is the Connected Isochronous Stream (Host Support)
+	// feature bit [Vol 6, Part B, 4.6].
+	leHostFeatureCIS = 32
+
+	// codingFormatTransparent passes SDUs through the controller unmodified.
+	codingFormatTransparent = 0x03
+)
+
+// isoState holds the isochronous channels and the ISO operations in progress.
+type isoState struct {
+	// muEnable serializes EnableISO, which sends commands. It must not be
+	// taken by event handlers.
+	muEnable sync.Mutex
+	cis      bool
+
+	mu      sync.Mutex
+	pool    *Pool
+	bufSize int
+	chans   map[uint16]*ISOChannel
+
+	// pending delivers CIS Established events to CIG.Connect, and
+	// bigPending the BIG events to CreateBIG and BIGCreateSync.
+	pending    map[uint16]chan []byte
+	bigPending map[uint8]chan []byte
+	bigs       map[uint8]*BIG
+
+	// cisReqHandler decides on CIS requests, and accepted holds the ACL
+	// connections of the accepted ones until they are established.
+	cisReqHandler func(CISRequest) bool
+	accepted      map[uint16]*Conn
+	chCIS         chan *ISOChannel
+
+	// bigInfoHandler is passed the BIGInfo reports.
+	bigInfoHandler func(BIGInfo)
+}
+
+// ISOSDU is a service data unit received on an isochronous channel.
+type ISOSDU struct {
+	Data []byte
+	Seq  uint16
+
+	// Timestamp is the SDU's time reference in microseconds, if HasTimestamp.
+	Timestamp    uint32
+	HasTimestamp bool
+
+	// Status is one of the ISOStatus values.
+	Status uint8
+}
+
+// ISOChannel is an established CIS or BIS. Each Write sends an SDU, and
+// ReadSDU returns the received SDUs in order.
+type ISOChannel struct {
+	hci    *HCI
+	handle uint16
+	conn   *Conn // ACL connection of a CIS, nil for a BIS.
+
+	muTx     sync.Mutex
+	seq      uint16
+	txBuffer *Client
+
+	// Reassembly of received SDUs; only accessed by the event loop.
+	rx    *ISOSDU
+	rxLen int
+	chSDU chan ISOSDU
+
+	closeOnce sync.Once
+	chDone    chan struct{}
+}
+
+// EnableISO prepares the controller for isochronous channels. It reads the
+// ISO data buffers and unmasks the ISO events, and if cis is set, announces
+// host support for connected isochronous streams. The ISO methods call it as
+// needed, but the controller only accepts the CIS host feature while there
+// are no connections, so devices using CISes should call it before connecting.
+func (h *HCI) EnableISO(cis bool) error {
+	h.iso.muEnable.Lock()
+	defer h.iso.muEnable.Unlock()
+
+	if !h.isoEnabled() {
+		rp := cmd.LEReadBufferSizeV2RP{}
+		if err := h.Send(&cmd.LEReadBufferSizeV2{}, &rp); err != nil {
+			return fmt.Errorf("failed to read iso buffer size: %v", err)
+		}
+		if rp.HCTotalNumISODataPackets == 0 || rp.HCISODataPacketLength == 0 {
+			return ErrISONotSupported
+		}
+		pool, err := NewPool(1+4+int(rp.HCISODataPacketLength), int(rp.HCTotalNumISODataPackets))
+		if err != nil {
+			return err
+		}
+
+		h.iso.mu.Lock()
+		h.iso.pool = pool
+		h.iso.bufSize = int(rp.HCISODataPacketLength)
+		h.iso.chans = make(map[uint16]*ISOChannel)
+		h.iso.pending = make(map[uint16]chan []byte)
+		h.iso.bigPending = make(map[uint8]chan []byte)
+		h.iso.bigs = make(map[uint8]*BIG)
+		h.iso.accepted = make(map[uint16]*Conn)
+		h.iso.chCIS = make(chan *ISOChannel)
+		h.iso.mu.Unlock()
+
+		if err := h.setEventMask(); err != nil {
+			return fmt.Errorf("failed to unmask iso events: %v", err)
+		}
+	}
+
+	if cis && !h.iso.cis {
+		rp := cmd.LESetHostFeatureRP{}
+		if err := h.Send(&cmd.LESetHostFeature{BitNumber: leHostFeatureCIS, BitValue: 1}, &rp); err != nil {
+			return fmt.Errorf("failed to set cis host support: %v", err)
+		}
+		h.iso.cis = true
+	}
+	return nil
+}
+
+func (h *HCI) isoEnabled() bool {
+	h.iso.mu.Lock()
+	defer h.iso.mu.Unlock()
+	return h.iso.pool != nil
+}
+
+// waitISOEvent waits for an ISO event delivered on ch.
+func (h *HCI) waitISOEvent(ctx context.Context, ch chan []byte) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-h.done:
+		return nil, fmt.Errorf("hci closed")
+	case b := <-ch:
+		return b, nil
+	}
+}
+
+// newISOChannel registers a channel for the connection handle of an
+// established CIS or BIS.
+func (h *HCI) newISOChannel(handle uint16, conn *Conn) *ISOChannel {
+	h.iso.mu.Lock()
+	defer h.iso.mu.Unlock()
+	c := &ISOChannel{
+		hci:      h,
+		handle:   handle,
+		conn:     conn,
+		txBuffer: NewClient(h.iso.pool),
+		chSDU:    make(chan ISOSDU, isoRxQueueLen),
+		chDone:   make(chan struct{}),
+	}
+	h.iso.chans[handle] = c
+	return c
+}
+
+// isoChannel returns the channel of a connection handle, or nil.
+func (h *HCI) isoChannel(handle uint16) *ISOChannel {
+	h.iso.mu.Lock()
+	defer h.iso.mu.Unlock()
+	return h.iso.chans[handle]
+}
+
+// closeISOChannel forgets the channel of a connection handle, and reports
+// whether there was one.
+func (h *HCI) closeISOChannel(handle uint16) bool {
+	h.iso.mu.Lock()
+	c, ok := h.iso.chans[handle]
+	delete(h.iso.chans, handle)
+	h.iso.mu.Unlock()
+	if ok {
+		c.closeOnce.Do(func() {
+			close(c.chDone)
+			c.txBuffer.PutAll()
+		})
+	}
+	return ok
+}
+
+// closeISOChannels closes all channels, when the HCI is closed.
+func (h *HCI) closeISOChannels() {
+	h.iso.mu.Lock()
+	hh := make([]uint16, 0, len(h.iso.chans))
+	for handle := range h.iso.chans {
+		hh = append(hh, handle)
+	}
+	h.iso.mu.Unlock()
+	for _, handle := range hh {
+		h.closeISOChannel(handle)
+	}
+}
+
+// isoCompletedPackets returns the buffers of the packets the controller has
+// sent on an ISO channel, and reports whether handle is one.
+func (h *HCI) isoCompletedPackets(handle uint16, n int) bool {
+	c := h.isoChannel(handle)
+	if c == nil {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		c.txBuffer.Put()
+	}
+	return true
+}
+
+// setupISODataPath routes the SDUs of a channel in the given direction over HCI.
+func (h *HCI) setupISODataPath(handle uint16, dir uint8) error {
+	rp := cmd.LESetupISODataPathRP{}
+	err := h.Send(&cmd.LESetupISODataPath{
+		ConnectionHandle:  handle,
+		DataPathDirection: dir,
+		DataPathID:        0x00, // HCI
+		CodecID:           [5]byte{codingFormatTransparent},
+	}, &rp)
+	if err != nil {
+		return fmt.Errorf("failed to setup iso data path: %v", err)
+	}
+	return nil
+}
+
+// Handle returns the connection handle of the channel.
+func (c *ISOChannel) Handle() uint16 { return c.handle }
+
+// Conn returns the ACL connection of a CIS, or nil for a BIS.
+func (c *ISOChannel) Conn() *Conn { return c.conn }
+
+// Disconnected returns a receiving channel, which is closed when the channel
+// is closed or lost.
+func (c *ISOChannel) Disconnected() <-chan struct{} { return c.chDone }
+
+// ReadSDU returns the next received SDU. It returns io.EOF once the channel
+// is closed.
+func (c *ISOChannel) ReadSDU(ctx context.Context) (ISOSDU, error) {
+	select {
+	case sdu := <-c.chSDU:
+		return sdu, nil
+	case <-c.chDone:
+		return ISOSDU{}, io.EOF
+	case <-ctx.Done():
+		return ISOSDU{}, ctx.Err()
+	}
+}
+
+// Write sends sdu as a single SDU, fragmented to the controller's ISO buffer
+// size. Waiting for buffers is bounded by the ACL write timeout.
+func (c *ISOChannel) Write(sdu []byte) (int, error) {
+	if len(sdu) > isoMaxSDULen {
+		return 0, fmt.Errorf("sdu length %d exceeds %d", len(sdu), isoMaxSDULen)
+	}
+	c.muTx.Lock()
+	defer c.muTx.Unlock()
+
+	select {
+	case <-c.chDone:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	seq := c.seq
+	c.seq++
+
+	sent := 0
+	tmo := c.hci.aclWriteTimeout
+	for first := true; first || sent < len(sdu); first = false {
+		pkt, err := c.txBuffer.Get(tmo, c.chDone)
+		if err != nil {
+			return sent, err
+		}
+		tmo = 0
+
+		hlen := 0
+		if first {
+			hlen = 4 // Packet sequence number and SDU length.
+		}
+		flen := len(sdu) - sent
+		if flen > pkt.Cap()-1-4-hlen {
+			flen = pkt.Cap() - 1 - 4 - hlen
+		}
+		last := sent+flen == len(sdu)
+
+		var pb uint16
+		switch {
+		case first && last:
+			pb = isoPBComplete
+		case first:
+			pb = isoPBFirst
+		case last:
+			pb = isoPBLast
+		default:
+			pb = isoPBContinue
+		}
+
+		b := make([]byte, 1+4+hlen, 1+4+hlen+flen)
+		b[0] = pktTypeISOData
+		binary.LittleEndian.PutUint16(b[1:], c.handle|pb<<12)
+		binary.LittleEndian.PutUint16(b[3:], uint16(hlen+flen))
+		if first {
+			binary.LittleEndian.PutUint16(b[5:], seq)
+			binary.LittleEndian.PutUint16(b[7:], uint16(len(sdu)))
+		}
+		b = append(b, sdu[sent:sent+flen]...)
+		pkt.Write(b)
+
+		select {
+		case <-c.chDone:
+			return sent, io.ErrClosedPipe
+		default:
+		}
+		if _, err := c.hci.socket().Write(pkt.Bytes()); err != nil {
+			return sent, err
+		}
+		sent += flen
+	}
+	return sent, nil
+}
+
+// Close disconnects a CIS. For a BIS, it removes the data paths and stops
+// the channel locally; closing the BIG terminates it.
+func (c *ISOChannel) Close() error {
+	var err error
+	if c.conn != nil {
+		err = c.hci.Send(&cmd.Disconnect{ConnectionHandle: c.handle, Reason: uint8(ErrRemoteUser)}, nil)
+	} else {
+		rp := cmd.LERemoveISODataPathRP{}
+		err = c.hci.Send(&cmd.LERemoveISODataPath{ConnectionHandle: c.handle, DataPathDirection: 0x03}, &rp)
+	}
+	c.hci.closeISOChannel(c.handle)
+	return err
+}
+
+// handleFragment reassembles the fragments of HCI ISO Data Packets into
+// SDUs [Vol 2, Part E, 5.4.5].
+func (c *ISOChannel) handleFragment(pb uint16, ts bool, b []byte) error {
+	if pb == isoPBFirst || pb == isoPBComplete {
+		var sdu ISOSDU
+		if ts {
+			if len(b) < 4 {
+				return fmt.Errorf("iso: short packet: % X", b)
+			}
+			sdu.Timestamp = binary.LittleEndian.Uint32(b)
+			sdu.HasTimestamp = true
+			b = b[4:]
+		}
+		if len(b) < 4 {
+			return fmt.Errorf("iso: short packet: % X", b)
+		}
+		sdu.Seq = binary.LittleEndian.Uint16(b)
+		l := binary.LittleEndian.Uint16(b[2:])
+		sdu.Status = uint8(l >> 14)
+		c.rxLen = int(l & isoMaxSDULen)
+		sdu.Data = make([]byte, 0, c.rxLen)
+		c.rx = &sdu
+		b = b[4:]
+	} else if c.rx == nil {
+		return fmt.Errorf("iso: fragment without start on handle %04X", c.handle)
+	}
+
+	c.rx.Data = append(c.rx.Data, b...)
+	if pb == isoPBFirst || pb == isoPBContinue {
+		return nil
+	}
+
+	sdu := *c.rx
+	c.rx = nil
+	if len(sdu.Data) != c.rxLen {
+		return fmt.Errorf("iso: sdu length %d, want %d", len(sdu.Data), c.rxLen)
+	}
+	select {
+	case c.chSDU <- sdu:
+		return nil
+	default:
+		// Don't stall the event loop on a slow reader; stale SDUs are of
+		// little use for isochronous data anyway.
+		return fmt.Errorf("iso: rx queue of handle %04X full, sdu %d dropped", c.handle, sdu.Seq)
+	}
+}
+
+func (h *HCI) handleISO(b []byte) error {
+	if len(b) < 4 {
+		return fmt.Errorf("invalid iso packet: % X", b)
+	}
+	hdr := binary.LittleEndian.Uint16(b)
+	handle := hdr & 0x0FFF
+	pb := hdr >> 12 & 0x03
+	ts := hdr&(1<<14) != 0
+	if n := int(binary.LittleEndian.Uint16(b[2:]) & 0x3FFF); n != len(b[4:]) {
+		return fmt.Errorf("invalid iso packet: % X", b)
+	}
+
+	c := h.isoChannel(handle)
+	if c == nil {
+		h.Warnf("handleISO: invalid connection handle %v", handle)
+		return nil
+	}
+	return c.handleFragment(pb, ts, b[4:])
+}
+
+// openCIS sets up the data paths of an established CIS. The directions
+// without data are left alone.
+func (h *HCI) openCIS(e evt.LECISEstablished, conn *Conn, central bool) (*ISOChannel, error) {
+	in, out := e.BNCToP() > 0, e.BNPToC() > 0
+	if !central {
+		in, out = out, in
+	}
+	c := h.newISOChannel(e.ConnectionHandle(), conn)
+	for _, p := range []struct {
+		ok  bool
+		dir uint8
+	}{{in, ISODataPathInput}, {out, ISODataPathOutput}} {
+		if !p.ok {
+			continue
+		}
+		if err := h.setupISODataPath(c.handle, p.dir); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// CIG is a connected isochronous group configured in the controller.
+type CIG struct {
+	hci     *HCI
+	id      uint8
+	handles []uint16
+}
+
+// SetCIGParameters configures a CIG, and allocates the connection handles
+// of its CISes [Vol 2, Part E, 7.8.97].
+func (h *HCI) SetCIGParameters(p cmd.LESetCIGParameters) (*CIG, error) {
+	if len(p.CISs) == 0 {
+		return nil, fmt.Errorf("no CIS in CIG %d", p.CIGID)
+	}
+	if err := h.EnableISO(true); err != nil {
+		return nil, err
+	}
+	rp := cmd.LESetCIGParametersRP{}
+	if err := h.Send(&p, &rp); err != nil {
+		return nil, fmt.Errorf("failed to set cig parameters: %v", err)
+	}
+	return &CIG{hci: h, id: rp.CIGID, handles: rp.ConnectionHandles}, nil
+}
+
+// ID returns the CIG identifier.
+func (g *CIG) ID() uint8 { return g.id }
+
+// CISHandles returns the connection handles of the CISes, in the order of
+// the parameters.
+func (g *CIG) CISHandles() []uint16 { return g.handles }
+
+// Connect establishes the first len(conns) CISes of the group, the i-th one
+// over conns[i], and returns their channels [Vol 2, Part E, 7.8.99].
+func (g *CIG) Connect(ctx context.Context, conns []*Conn) ([]*ISOChannel, error) {
+	h := g.hci
+	if len(conns) == 0 || len(conns) > len(g.handles) {
+		return nil, fmt.Errorf("%d connections for %d CISes", len(conns), len(g.handles))
+	}
+
+	c := cmd.LECreateCIS{}
+	waits := make([]chan []byte, len(conns))
+	h.iso.mu.Lock()
+	for i, conn := range conns {
+		c.CISs = append(c.CISs, cmd.LECreateCISPair{
+			CISConnectionHandle: g.handles[i],
+			ACLConnectionHandle: conn.param.ConnectionHandle(),
+		})
+		waits[i] = make(chan []byte, 1)
+		h.iso.pending[g.handles[i]] = waits[i]
+	}
+	h.iso.mu.Unlock()
+
+	defer func() {
+		h.iso.mu.Lock()
+		for _, handle := range g.handles[:len(conns)] {
+			delete(h.iso.pending, handle)
+		}
+		h.iso.mu.Unlock()
+	}()
+
+	if err := h.Send(&c, nil); err != nil {
+		return nil, fmt.Errorf("failed to create cis: %v", err)
+	}
+
+	var chs []*ISOChannel
+	fail := func(err error) ([]*ISOChannel, error) {
+		for _, ch := range chs {
+			ch.Close()
+		}
+		return nil, err
+	}
+	for i, w := range waits {
+		b, err := h.waitISOEvent(ctx, w)
+		if err != nil {
+			return fail(err)
+		}
+		e := evt.LECISEstablished(b)
+		if e.Status() != 0x00 {
+			return fail(fmt.Errorf("failed to establish cis %04X: %v", g.handles[i], ErrCommand(e.Status())))
+		}
+		ch, err := h.openCIS(e, conns[i], true)
+		if err != nil {
+			return fail(err)
+		}
+		chs = append(chs, ch)
+	}
+	return chs, nil
+}
+
+// Remove removes the CIG from the controller. Its CISes must be closed.
+func (g *CIG) Remove() error {
+	rp := cmd.LERemoveCIGRP{}
+	if err := g.hci.Send(&cmd.LERemoveCIG{CIGID: g.id}, &rp); err != nil {
+		return fmt.Errorf("failed to remove cig: %v", err)
+	}
+	return nil
+}
+
+// CISRequest is a request of a central to establish a CIS.
+type CISRequest struct {
+	Conn  *Conn
+	CIGID uint8
+	CISID uint8
+}
+
+// SetCISRequestHandler sets the function deciding on CIS requests of
+// centrals. Accepted CISes are returned by AcceptCIS. Requests are rejected
+// while f is nil.
+func (h *HCI) SetCISRequestHandler(f func(CISRequest) bool) error {
+	if err := h.EnableISO(true); err != nil {
+		return err
+	}
+	h.iso.mu.Lock()
+	h.iso.cisReqHandler = f
+	h.iso.mu.Unlock()
+	return nil
+}
+
+// AcceptCIS returns the next CIS established on request of a central.
+func (h *HCI) AcceptCIS(ctx context.Context) (*ISOChannel, error) {
+	if err := h.EnableISO(true); err != nil {
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-h.done:
+		return nil, fmt.Errorf("hci closed")
+	case c := <-h.iso.chCIS:
+		return c, nil
+	}
+}
+
+func (h *HCI) handleLECISRequest(b []byte) error {
+	e := evt.LECISRequest(b)
+	req := CISRequest{
+		Conn:  h.findConnection(e.ACLConnectionHandle()),
+		CIGID: e.CIGID(),
+		CISID: e.CISID(),
+	}
+	handle := e.CISConnectionHandle()
+
+	h.iso.mu.Lock()
+	f := h.iso.cisReqHandler
+	h.iso.mu.Unlock()
+
+	// The handler is user code, and the commands can't be sent from the
+	// event loop.
+	h.group.Go(func() {
+		if f == nil || req.Conn == nil || !f(req) {
+			rp := cmd.LERejectCISRequestRP{}
+			err := h.Send(&cmd.LERejectCISRequest{ConnectionHandle: handle, Reason: uint8(ErrLimitedResource)}, &rp)
+			if err != nil {
+				h.Warnf("failed to reject cis request: %v", err)
+			}
+			return
+		}
+		h.iso.mu.Lock()
+		h.iso.accepted[handle] = req.Conn
+		h.iso.mu.Unlock()
+		if err := h.Send(&cmd.LEAcceptCISRequest{ConnectionHandle: handle}, nil); err != nil {
+			h.Warnf("failed to accept cis request: %v", err)
+			h.iso.mu.Lock()
+			delete(h.iso.accepted, handle)
+			h.iso.mu.Unlock()
+		}
+	})
+	return nil
+}
+
+func (h *HCI) handleLECISEstablished(b []byte) error {
+	e := evt.LECISEstablished(append([]byte(nil), b...))
+	handle := e.ConnectionHandle()
+
+	h.iso.mu.Lock()
+	w, central := h.iso.pending[handle]
+	conn, peripheral := h.iso.accepted[handle]
+	delete(h.iso.pending, handle)
+	delete(h.iso.accepted, handle)
+	h.iso.mu.Unlock()
+
+	switch {
+	case central:
+		w <- e
+	case peripheral:
+		if e.Status() != 0x00 {
+			h.Warnf("cis %04X not established: %v", handle, ErrCommand(e.Status()))
+			return nil
+		}
+		h.group.Go(func() {
+			c, err := h.openCIS(e, conn, false)
+			if err != nil {
+				h.Warnf("failed to open cis %04X: %v", handle, err)
+				return
+			}
+			select {
+			case h.iso.chCIS <- c:
+			case <-h.done:
+			}
+		})
+	default:
+		h.Warnf("handleLECISEstablished: unexpected cis %04X", handle)
+	}
+	return nil
+}
+
+// BIG is a broadcast isochronous group, either created by this device or
+// synchronized to.
+type BIG struct {
+	hci      *HCI
+	handle   uint8
+	sync     bool
+	channels []*ISOChannel
+}
+
+// CreateBIG creates a BIG, and returns it once the controller broadcasts its
+// BISes [Vol 2, Part E, 7.8.103]. The advertising set of p.AdvertisingHandle
+// must have periodic advertising configured, e.g. with custom commands.
+func (h *HCI) CreateBIG(ctx context.Context, p cmd.LECreateBIG) (*BIG, error) {
+	if err := h.EnableISO(false); err != nil {
+		return nil, err
+	}
+	cancel := func() { h.Send(&cmd.LETerminateBIG{BIGHandle: p.BIGHandle, Reason: uint8(ErrLocalHost)}, nil) }
+	b, err := h.openBIG(ctx, p.BIGHandle, &p, cancel)
+	if err != nil {
+		return nil, err
+	}
+
+	e := evt.LECreateBIGComplete(b)
+	if e.Status() != 0x00 {
+		return nil, fmt.Errorf("failed to create big: %v", ErrCommand(e.Status()))
+	}
+	handles := make([]uint16, e.NumBIS())
+	for i := range handles {
+		handles[i] = e.ConnectionHandle(i)
+	}
+	return h.newBIG(p.BIGHandle, false, handles)
+}
+
+// BIGCreateSync synchronizes to the BISes of a BIG, and returns it once the
+// synchronization is established [Vol 2, Part E, 7.8.106]. p.SyncHandle
+// refers to a periodic advertising train, synchronized to e.g. with custom
+// commands.
+func (h *HCI) BIGCreateSync(ctx context.Context, p cmd.LEBIGCreateSync) (*BIG, error) {
+	if err := h.EnableISO(false); err != nil {
+		return nil, err
+	}
+	cancel := func() { h.Send(&cmd.LEBIGTerminateSync{BIGHandle: p.BIGHandle}, &cmd.LEBIGTerminateSyncRP{}) }
+	b, err := h.openBIG(ctx, p.BIGHandle, &p, cancel)
+	if err != nil {
+		return nil, err
+	}
+
+	e := evt.LEBIGSyncEstablished(b)
+	if e.Status() != 0x00 {
+		return nil, fmt.Errorf("failed to sync to big: %v", ErrCommand(e.Status()))
+	}
+	handles := make([]uint16, e.NumBIS())
+	for i := range handles {
+		handles[i] = e.ConnectionHandle(i)
+	}
+	return h.newBIG(p.BIGHandle, true, handles)
+}
+
+// openBIG sends the command creating or synchronizing to a BIG, and returns
+// the event completing it. If ctx is done first, cancel is called.
+func (h *HCI) openBIG(ctx context.Context, handle uint8, c Command, cancel func()) ([]byte, error) {
+	w := make(chan []byte, 1)
+	h.iso.mu.Lock()
+	if _, ok := h.iso.bigPending[handle]; ok {
+		h.iso.mu.Unlock()
+		return nil, fmt.Errorf("big %d busy", handle)
+	}
+	h.iso.bigPending[handle] = w
+	h.iso.mu.Unlock()
+
+	defer func() {
+		h.iso.mu.Lock()
+		delete(h.iso.bigPending, handle)
+		h.iso.mu.Unlock()
+	}()
+
+	if err := h.Send(c, nil); err != nil {
+		return nil, fmt.Errorf("%v: %v", c, err)
+	}
+	b, err := h.waitISOEvent(ctx, w)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return b, nil
+}
+
+// newBIG registers a BIG and sets up the data paths of its BISes.
+func (h *HCI) newBIG(handle uint8, sync bool, handles []uint16) (*BIG, error) {
+	g := &BIG{hci: h, handle: handle, sync: sync}
+	dir := uint8(ISODataPathInput)
+	if sync {
+		dir = ISODataPathOutput
+	}
+	for _, ch := range handles {
+		g.channels = append(g.channels, h.newISOChannel(ch, nil))
+		if err := h.setupISODataPath(ch, dir); err != nil {
+			g.Close()
+			return nil, err
+		}
+	}
+	h.iso.mu.Lock()
+	h.iso.bigs[handle] = g
+	h.iso.mu.Unlock()
+	return g, nil
+}
+
+// Handle returns the BIG handle.
+func (g *BIG) Handle() uint8 { return g.handle }
+
+// Channels returns the channels of the BISes.
+func (g *BIG) Channels() []*ISOChannel { return g.channels }
+
+// Close terminates a created BIG, or the synchronization to one.
+func (g *BIG) Close() error {
+	h := g.hci
+	var err error
+	if g.sync {
+		err = h.Send(&cmd.LEBIGTerminateSync{BIGHandle: g.handle}, &cmd.LEBIGTerminateSyncRP{})
+	} else {
+		err = h.Send(&cmd.LETerminateBIG{BIGHandle: g.handle, Reason: uint8(ErrLocalHost)}, nil)
+	}
+	h.closeBIG(g.handle, g)
+	if err != nil {
+		return fmt.Errorf("failed to terminate big: %v", err)
+	}
+	return nil
+}
+
+// closeBIG closes the channels of a BIG.
+func (h *HCI) closeBIG(handle uint8, g *BIG) {
+	h.iso.mu.Lock()
+	if g == nil {
+		g = h.iso.bigs[handle]
+	}
+	delete(h.iso.bigs, handle)
+	h.iso.mu.Unlock()
+	if g == nil {
+		return
+	}
+	for _, c := range g.channels {
+		h.closeISOChannel(c.handle)
+	}
+}
+
+// deliverBIGEvent passes a BIG event to the pending operation on the BIG.
+func (h *HCI) deliverBIGEvent(handle uint8, b []byte) bool {
+	h.iso.mu.Lock()
+	w, ok := h.iso.bigPending[handle]
+	delete(h.iso.bigPending, handle)
+	h.iso.mu.Unlock()
+	if ok {
+		w <- append([]byte(nil), b...)
+	}
+	return ok
+}
+
+func (h *HCI) handleLECreateBIGComplete(b []byte) error {
+	e := evt.LECreateBIGComplete(b)
+	if !h.deliverBIGEvent(e.BIGHandle(), b) {
+		h.Warnf("handleLECreateBIGComplete: unexpected big %d", e.BIGHandle())
+	}
+	return nil
+}
+
+func (h *HCI) handleLEBIGSyncEstablished(b []byte) error {
+	e := evt.LEBIGSyncEstablished(b)
+	if !h.deliverBIGEvent(e.BIGHandle(), b) {
+		h.Warnf("handleLEBIGSyncEstablished: unexpected big %d", e.BIGHandle())
+	}
+	return nil
+}
+
+func (h *HCI) handleLETerminateBIGComplete(b []byte) error {
+	e := evt.LETerminateBIGComplete(b)
+	h.Debugf("big %d terminated: %v", e.BIGHandle(), ErrCommand(e.Reason()))
+	h.closeBIG(e.BIGHandle(), nil)
+	return nil
+}
+
+func (h *HCI) handleLEBIGSyncLost(b []byte) error {
+	e := evt.LEBIGSyncLost(b)
+	h.Warnf("big %d sync lost: %v", e.BIGHandle(), ErrCommand(e.Reason()))
+	h.closeBIG(e.BIGHandle(), nil)
+	return nil
+}
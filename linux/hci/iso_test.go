@@ -0,0 +1,83 @@
+package hci
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+func TestISOReassembly(t *testing.T) {
+	c := &ISOChannel{handle: 0x0060, chSDU: make(chan ISOSDU, 1)}
+
+	// A complete SDU with a timestamp.
+	if err := c.handleFragment(isoPBComplete, true, []byte{0x10, 0x00, 0x00, 0x00, 0x07, 0x00, 0x02, 0x00, 0xAA, 0xBB}); err != nil {
+		t.Fatal(err)
+	}
+	sdu := <-c.chSDU
+	if !sdu.HasTimestamp || sdu.Timestamp != 0x10 || sdu.Seq != 7 || !bytes.Equal(sdu.Data, []byte{0xAA, 0xBB}) {
+		t.Fatalf("unexpected sdu %+v", sdu)
+	}
+
+	// A fragmented SDU, marked as lost data.
+	frags := []struct {
+		pb uint16
+		b  []byte
+	}{
+		{isoPBFirst, []byte{0x08, 0x00, 0x05, 0x80, 1, 2}},
+		{isoPBContinue, []byte{3, 4}},
+		{isoPBLast, []byte{5}},
+	}
+	for _, f := range frags {
+		if err := c.handleFragment(f.pb, false, f.b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sdu = <-c.chSDU
+	if sdu.HasTimestamp || sdu.Seq != 8 || sdu.Status != ISOStatusLost || !bytes.Equal(sdu.Data, []byte{1, 2, 3, 4, 5}) {
+		t.Fatalf("unexpected sdu %+v", sdu)
+	}
+
+	if c.handleFragment(isoPBLast, false, []byte{1}) == nil {
+		t.Error("expected error for fragment without start")
+	}
+	if c.handleFragment(isoPBComplete, false, []byte{0x09, 0x00, 0x03, 0x00, 1}) == nil {
+		t.Error("expected error for short sdu")
+	}
+}
+
+func TestBIGInfo(t *testing.T) {
+	b := []byte{
+		0x22,       // Subevent code
+		0x01, 0x00, // Sync handle
+		0x02,       // Num BIS
+		0x04,       // NSE
+		0x08, 0x00, // ISO interval, 10 ms
+		0x02,       // BN
+		0x00,       // PTO
+		0x02,       // IRC
+		0x28, 0x00, // Max PDU
+		0x10, 0x27, 0x00, // SDU interval, 10 ms
+		0x28, 0x00, // Max SDU
+		0x02, // PHY
+		0x00, // Framing
+		0x01, // Encryption
+	}
+	exp := BIGInfo{
+		SyncHandle:  1,
+		NumBIS:      2,
+		NSE:         4,
+		ISOInterval: 10 * time.Millisecond,
+		BN:          2,
+		IRC:         2,
+		MaxPDU:      40,
+		SDUInterval: 10 * time.Millisecond,
+		MaxSDU:      40,
+		PHY:         2,
+		Encrypted:   true,
+	}
+	if i := newBIGInfo(evt.LEBIGInfoAdvertisingReport(b)); i != exp {
+		t.Fatalf("have %+v, want %+v", i, exp)
+	}
+}
@@ -0,0 +1,653 @@
+package hci
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/leso-kn/ble"
+)
+
+// LE credit based connection-oriented channels [Vol 3, Part A, 10.1].
+const (
+	// Dynamically allocated CIDs of LE-U [Vol 3, Part A, 2.1].
+	cidDynamicFirst uint16 = 0x0040
+	cidDynamicLast  uint16 = 0x007F
+
+	// Dynamically allocated LE_PSMs [Vol 3, Part A, 4.22].
+	psmDynamicFirst uint16 = 0x0080
+	psmDynamicLast  uint16 = 0x00FF
+
+	// cocMTU and cocMPS are the largest SDU and K-frame payload the channels
+	// accept, and cocCredits the K-frames the remote device may send ahead
+	// of Read.
+	cocMTU     = 2048
+	cocMPS     = 247
+	cocCredits = 16
+
+	// cocMinMTU is the smallest MTU and MPS a channel may have.
+	cocMinMTU = 23
+
+	// cocMaxCredits is the most credits a device may have been granted
+	// [Vol 3, Part A, 10.1].
+	cocMaxCredits = 65535
+
+	// cocAcceptQueue is the number of channels a listener queues for Accept.
+	cocAcceptQueue = 8
+)
+
+// L2CAPResult is the result of an LE Credit Based Connection Request, the
+// error OpenL2CAPChannel fails with if the remote device refused the
+// channel [Vol 3, Part A, 4.23].
+type L2CAPResult uint16
+
+// Results of LE Credit Based Connection Requests.
+const (
+	L2CAPSuccess                L2CAPResult = 0x0000
+	L2CAPPSMNotSupported        L2CAPResult = 0x0002
+	L2CAPNoResources            L2CAPResult = 0x0004
+	L2CAPInsufficientAuth       L2CAPResult = 0x0005
+	L2CAPInsufficientAuthz      L2CAPResult = 0x0006
+	L2CAPInsufficientKeySize    L2CAPResult = 0x0007
+	L2CAPInsufficientEncryption L2CAPResult = 0x0008
+	L2CAPInvalidSourceCID       L2CAPResult = 0x0009
+	L2CAPSourceCIDAllocated     L2CAPResult = 0x000A
+	L2CAPUnacceptableParams     L2CAPResult = 0x000B
+)
+
+var l2capResults = map[L2CAPResult]string{
+	L2CAPPSMNotSupported:        "LE_PSM not supported",
+	L2CAPNoResources:            "no resources available",
+	L2CAPInsufficientAuth:       "insufficient authentication",
+	L2CAPInsufficientAuthz:      "insufficient authorization",
+	L2CAPInsufficientKeySize:    "insufficient encryption key size",
+	L2CAPInsufficientEncryption: "insufficient encryption",
+	L2CAPInvalidSourceCID:       "invalid source CID",
+	L2CAPSourceCIDAllocated:     "source CID already allocated",
+	L2CAPUnacceptableParams:     "unacceptable parameters",
+}
+
+func (r L2CAPResult) Error() string {
+	if s, ok := l2capResults[r]; ok {
+		return "l2cap channel refused: " + s
+	}
+	return fmt.Sprintf("l2cap channel refused: result 0x%04X", uint16(r))
+}
+
+// L2CAPChannel is an LE credit based connection-oriented channel of a Conn.
+// Each Write sends one SDU, segmented into K-frames as the remote device
+// grants credits, and each Read returns one SDU.
+type L2CAPChannel struct {
+	c    *Conn
+	psm  uint16
+	scid uint16 // The local CID.
+	dcid uint16 // The CID of the remote device.
+
+	txMTU int
+	txMPS int
+
+	// muTx serializes the Writes, whose K-frames mustn't interleave.
+	muTx sync.Mutex
+
+	// muCredits guards txCredits, the K-frames the remote device accepts,
+	// and rxCredits, those it may send. chCredits is signaled when the
+	// remote device grants credits.
+	muCredits sync.Mutex
+	txCredits int
+	rxCredits int
+	chCredits chan struct{}
+
+	// sdu is the SDU being reassembled by the goroutine receiving the data
+	// of the connection, of sduLen bytes, and frames the K-frames it took.
+	sdu    []byte
+	sduLen int
+	frames int
+
+	// chSDU queues the SDUs for Read. As each holds a credit until it's
+	// read, it never holds more than cocCredits. muRx serializes the Reads,
+	// and guards pending, the SDU a short buffer didn't take.
+	chSDU   chan cocSDU
+	muRx    sync.Mutex
+	pending *cocSDU
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+type cocSDU struct {
+	data   []byte
+	frames int
+}
+
+func newL2CAPChannel(c *Conn, psm uint16) *L2CAPChannel {
+	return &L2CAPChannel{
+		c:         c,
+		psm:       psm,
+		rxCredits: cocCredits,
+		chCredits: make(chan struct{}, 1),
+		chSDU:     make(chan cocSDU, cocCredits),
+		done:      make(chan struct{}),
+	}
+}
+
+// PSM returns the LE_PSM of the channel.
+func (ch *L2CAPChannel) PSM() uint16 { return ch.psm }
+
+// MTU returns the largest SDU the remote device accepts.
+func (ch *L2CAPChannel) MTU() int { return ch.txMTU }
+
+// Conn returns the connection the channel is on.
+func (ch *L2CAPChannel) Conn() ble.Conn { return ch.c }
+
+// Read copies the next SDU into b. It fails with io.ErrShortBuffer, and
+// keeps the SDU, if b is too short, and with io.EOF once the channel or its
+// connection is closed and the SDUs received are read.
+func (ch *L2CAPChannel) Read(b []byte) (int, error) {
+	ch.muRx.Lock()
+	defer ch.muRx.Unlock()
+
+	var s cocSDU
+	if ch.pending != nil {
+		s = *ch.pending
+	} else {
+		select {
+		case s = <-ch.chSDU:
+		default:
+			select {
+			case s = <-ch.chSDU:
+			case <-ch.done:
+				return 0, io.EOF
+			case <-ch.c.chDone:
+				return 0, io.EOF
+			}
+		}
+	}
+	if len(b) < len(s.data) {
+		ch.pending = &s
+		return 0, io.ErrShortBuffer
+	}
+	ch.pending = nil
+	n := copy(b, s.data)
+
+	// Grant the remote device the credits the SDU took.
+	ch.muCredits.Lock()
+	ch.rxCredits += s.frames
+	ch.muCredits.Unlock()
+	if err := ch.c.SendSignal(&LEFlowControlCredit{CID: ch.scid, Credits: uint16(s.frames)}); err != nil {
+		ch.c.Debugf("l2cap: grant credits: %v", err)
+	}
+	return n, nil
+}
+
+// Write sends b as one SDU, which mustn't be larger than the MTU. It waits
+// for the credits of its K-frames, until the channel is closed.
+func (ch *L2CAPChannel) Write(b []byte) (int, error) {
+	if len(b) > ch.txMTU {
+		return 0, fmt.Errorf("sdu (%d) larger than the channel mtu (%d)", len(b), ch.txMTU)
+	}
+	ch.muTx.Lock()
+	defer ch.muTx.Unlock()
+	select {
+	case <-ch.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	sent := 0
+	for first := true; first || sent < len(b); first = false {
+		if err := ch.takeCredit(); err != nil {
+			return sent, err
+		}
+		// The first K-frame starts with the length of the SDU.
+		hlen := 0
+		if first {
+			hlen = 2
+		}
+		n := len(b) - sent
+		if n > ch.txMPS-hlen {
+			n = ch.txMPS - hlen
+		}
+		f := make([]byte, 4, 4+hlen+n)
+		binary.LittleEndian.PutUint16(f[0:2], uint16(hlen+n))
+		binary.LittleEndian.PutUint16(f[2:4], ch.dcid)
+		if first {
+			f = append(f, uint8(len(b)), uint8(len(b)>>8))
+		}
+		f = append(f, b[sent:sent+n]...)
+		if _, err := ch.c.writePDU(f); err != nil {
+			return sent, err
+		}
+		sent += n
+	}
+	return sent, nil
+}
+
+// takeCredit waits for a credit to send a K-frame.
+func (ch *L2CAPChannel) takeCredit() error {
+	for {
+		ch.muCredits.Lock()
+		if ch.txCredits > 0 {
+			ch.txCredits--
+			ch.muCredits.Unlock()
+			return nil
+		}
+		ch.muCredits.Unlock()
+		select {
+		case <-ch.chCredits:
+		case <-ch.done:
+			return io.ErrClosedPipe
+		case <-ch.c.chDone:
+			return io.ErrClosedPipe
+		}
+	}
+}
+
+// addCredits adds the credits the remote device granted. It reports false
+// if they exceed the most a device may have.
+func (ch *L2CAPChannel) addCredits(n int) bool {
+	ch.muCredits.Lock()
+	defer ch.muCredits.Unlock()
+	if ch.txCredits+n > cocMaxCredits {
+		return false
+	}
+	ch.txCredits += n
+	select {
+	case ch.chCredits <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Close disconnects the channel [Vol 3, Part A, 4.6]. The SDUs received
+// are discarded.
+func (ch *L2CAPChannel) Close() error {
+	if !ch.closeLocal() {
+		return nil
+	}
+	select {
+	case <-ch.c.chDone:
+		return nil
+	default:
+	}
+	return ch.c.Signal(&DisconnectRequest{DestinationCID: ch.dcid, SourceCID: ch.scid}, &DisconnectResponse{})
+}
+
+// closeLocal closes the channel, and frees its CID. It reports whether it
+// did, rather than a previous call.
+func (ch *L2CAPChannel) closeLocal() bool {
+	closed := false
+	ch.closeOnce.Do(func() {
+		close(ch.done)
+		ch.c.removeChannel(ch)
+		closed = true
+	})
+	return closed
+}
+
+// abort closes the channel on a protocol error of the remote device. It's
+// called from the goroutine receiving the data of the connection, which
+// handles the response to the Disconnect Request, so it doesn't wait.
+func (ch *L2CAPChannel) abort(format string, a ...interface{}) {
+	ch.c.Errorf("l2cap: channel 0x%04X: "+format, append([]interface{}{ch.scid}, a...)...)
+	go func() {
+		if err := ch.Close(); err != nil {
+			ch.c.Debugf("l2cap: close channel 0x%04X: %v", ch.scid, err)
+		}
+	}()
+}
+
+// handleKFrame reassembles the SDUs from the K-frames received on the
+// channel [Vol 3, Part A, 3.4].
+func (ch *L2CAPChannel) handleKFrame(b []byte) {
+	select {
+	case <-ch.done:
+		return
+	default:
+	}
+	ch.muCredits.Lock()
+	ch.rxCredits--
+	credits := ch.rxCredits
+	ch.muCredits.Unlock()
+	if credits < 0 {
+		ch.abort("K-frame without credit")
+		return
+	}
+	if len(b) > cocMPS {
+		ch.abort("K-frame (%d) larger than the MPS (%d)", len(b), cocMPS)
+		return
+	}
+
+	ch.frames++
+	if ch.sdu == nil {
+		if len(b) < 2 {
+			ch.abort("K-frame without SDU length")
+			return
+		}
+		ch.sduLen = int(binary.LittleEndian.Uint16(b))
+		if ch.sduLen > cocMTU {
+			ch.abort("SDU (%d) larger than the MTU (%d)", ch.sduLen, cocMTU)
+			return
+		}
+		ch.sdu = make([]byte, 0, ch.sduLen)
+		b = b[2:]
+	}
+	if len(ch.sdu)+len(b) > ch.sduLen {
+		ch.abort("SDU longer than its length (%d)", ch.sduLen)
+		return
+	}
+	ch.sdu = append(ch.sdu, b...)
+	if len(ch.sdu) < ch.sduLen {
+		return
+	}
+	ch.chSDU <- cocSDU{data: ch.sdu, frames: ch.frames}
+	ch.sdu, ch.sduLen, ch.frames = nil, 0, 0
+}
+
+// addChannel allocates a local CID to ch, and adds it to the channels of
+// the connection.
+func (c *Conn) addChannel(ch *L2CAPChannel) error {
+	c.muChans.Lock()
+	defer c.muChans.Unlock()
+	if c.chans == nil {
+		c.chans = make(map[uint16]*L2CAPChannel)
+	}
+	for cid := cidDynamicFirst; cid <= cidDynamicLast; cid++ {
+		if _, ok := c.chans[cid]; !ok {
+			ch.scid = cid
+			c.chans[cid] = ch
+			return nil
+		}
+	}
+	return errors.New("no l2cap CID available")
+}
+
+func (c *Conn) removeChannel(ch *L2CAPChannel) {
+	c.muChans.Lock()
+	defer c.muChans.Unlock()
+	if c.chans[ch.scid] == ch {
+		delete(c.chans, ch.scid)
+	}
+}
+
+// channel returns the channel with the local CID, if any.
+func (c *Conn) channel(cid uint16) *L2CAPChannel {
+	c.muChans.Lock()
+	defer c.muChans.Unlock()
+	return c.chans[cid]
+}
+
+// remoteChannel returns the channel with the CID of the remote device, if
+// any.
+func (c *Conn) remoteChannel(cid uint16) *L2CAPChannel {
+	c.muChans.Lock()
+	defer c.muChans.Unlock()
+	for _, ch := range c.chans {
+		if ch.dcid == cid {
+			return ch
+		}
+	}
+	return nil
+}
+
+// OpenL2CAPChannel opens an LE credit based channel to the LE_PSM of the
+// remote device [Vol 3, Part A, 4.22]. It fails with the L2CAPResult of
+// the remote device if it refused the channel.
+func (c *Conn) OpenL2CAPChannel(psm uint16) (ble.L2CAPChannel, error) {
+	ch := newL2CAPChannel(c, psm)
+	if err := c.addChannel(ch); err != nil {
+		return nil, err
+	}
+	req := &LECreditBasedConnectionRequest{
+		LEPSM:          psm,
+		SourceCID:      ch.scid,
+		MTU:            cocMTU,
+		MPS:            cocMPS,
+		InitialCredits: cocCredits,
+	}
+
+	// The channel is set up by the goroutine receiving the response, before
+	// it handles the credits or K-frames which may follow.
+	result := L2CAPSuccess
+	setup := func(s sigCmd) {
+		var rsp LECreditBasedConnectionResponse
+		if s.code() != SignalLECreditBasedConnectionResponse || rsp.Unmarshal(s.data()) != nil {
+			return
+		}
+		result = L2CAPResult(rsp.Result)
+		if result != L2CAPSuccess {
+			return
+		}
+		c.muChans.Lock()
+		ch.dcid = rsp.DestinationCID
+		c.muChans.Unlock()
+		if rsp.DestinationCID < cidDynamicFirst || rsp.DestinationCID > cidDynamicLast ||
+			rsp.MTU < cocMinMTU || rsp.MPS < cocMinMTU || rsp.MPS > 65533 {
+			result = L2CAPUnacceptableParams
+			return
+		}
+		ch.txMTU, ch.txMPS = int(rsp.MTU), int(rsp.MPS)
+		ch.addCredits(int(rsp.InitialCreditsCID))
+	}
+	err := c.signal(req, &LECreditBasedConnectionResponse{}, setup)
+	if err == nil && result != L2CAPSuccess {
+		err = result
+	}
+	if err != nil {
+		ch.closeLocal()
+		if result == L2CAPUnacceptableParams {
+			// The remote device allocated the channel.
+			_ = c.Signal(&DisconnectRequest{DestinationCID: ch.dcid, SourceCID: ch.scid}, nil)
+		}
+		return nil, err
+	}
+	return ch, nil
+}
+
+// LECreditBasedConnectionRequest implements LE Credit Based Connection
+// Request (0x14) [Vol 3, Part A, 4.22], for the PSMs published with
+// ListenL2CAP.
+func (c *Conn) LECreditBasedConnectionRequest(s sigCmd) {
+	var req LECreditBasedConnectionRequest
+	if err := req.Unmarshal(s.data()); err != nil {
+		return
+	}
+	ch, l, result := c.acceptChannel(&req)
+	rsp := &LECreditBasedConnectionResponse{Result: uint16(result)}
+	if result == L2CAPSuccess {
+		rsp.DestinationCID = ch.scid
+		rsp.MTU = cocMTU
+		rsp.MPS = cocMPS
+		rsp.InitialCreditsCID = cocCredits
+	}
+	if _, err := c.sendResponse(SignalLECreditBasedConnectionResponse, s.id(), rsp); err != nil {
+		c.Errorf("l2cap: respond to channel request: %v", err)
+		if ch != nil {
+			ch.closeLocal()
+			l.release()
+		}
+		return
+	}
+	if ch != nil {
+		l.deliver(ch)
+	}
+}
+
+// acceptChannel checks a channel request, and returns the channel and the
+// listener it's queued to if it's accepted.
+func (c *Conn) acceptChannel(req *LECreditBasedConnectionRequest) (*L2CAPChannel, *L2CAPListener, L2CAPResult) {
+	l := c.hci.l2capListener(req.LEPSM)
+	switch {
+	case l == nil:
+		return nil, nil, L2CAPPSMNotSupported
+	case l.encrypted && !c.encryptionEnabled:
+		return nil, nil, L2CAPInsufficientEncryption
+	case req.SourceCID < cidDynamicFirst || req.SourceCID > cidDynamicLast:
+		return nil, nil, L2CAPInvalidSourceCID
+	case c.remoteChannel(req.SourceCID) != nil:
+		return nil, nil, L2CAPSourceCIDAllocated
+	case req.MTU < cocMinMTU || req.MPS < cocMinMTU || req.MPS > 65533:
+		return nil, nil, L2CAPUnacceptableParams
+	}
+	if !l.reserve() {
+		return nil, nil, L2CAPNoResources
+	}
+	ch := newL2CAPChannel(c, req.LEPSM)
+	ch.dcid = req.SourceCID
+	ch.txMTU, ch.txMPS = int(req.MTU), int(req.MPS)
+	ch.txCredits = int(req.InitialCredits)
+	if err := c.addChannel(ch); err != nil {
+		l.release()
+		return nil, nil, L2CAPNoResources
+	}
+	return ch, l, L2CAPSuccess
+}
+
+// LEFlowControlCredit implements LE Flow Control Credit (0x16) [Vol 3,
+// Part A, 4.24].
+func (c *Conn) LEFlowControlCredit(s sigCmd) {
+	var f LEFlowControlCredit
+	if err := f.Unmarshal(s.data()); err != nil {
+		return
+	}
+	ch := c.remoteChannel(f.CID)
+	if ch == nil {
+		return
+	}
+	if !ch.addCredits(int(f.Credits)) {
+		ch.abort("credits exceed %d", cocMaxCredits)
+	}
+}
+
+// L2CAPListener accepts the LE credit based channels remote devices open to
+// an LE_PSM published with ListenL2CAP.
+type L2CAPListener struct {
+	h         *HCI
+	psm       uint16
+	encrypted bool
+
+	// mu guards queued, the channels queued or to be, which chAccept holds
+	// room for, and closed.
+	mu       sync.Mutex
+	queued   int
+	closed   bool
+	chAccept chan *L2CAPChannel
+	done     chan struct{}
+}
+
+// ListenL2CAP publishes an LE_PSM, or allocates one of the dynamic range if
+// psm is zero. With encrypted, channels need an encrypted link, and are
+// refused with L2CAPInsufficientEncryption otherwise.
+func (h *HCI) ListenL2CAP(psm uint16, encrypted bool) (*L2CAPListener, error) {
+	h.muL2CAP.Lock()
+	defer h.muL2CAP.Unlock()
+	if h.l2capListeners == nil {
+		h.l2capListeners = make(map[uint16]*L2CAPListener)
+	}
+	if psm == 0 {
+		for p := psmDynamicFirst; p <= psmDynamicLast; p++ {
+			if _, ok := h.l2capListeners[p]; !ok {
+				psm = p
+				break
+			}
+		}
+		if psm == 0 {
+			return nil, errors.New("no dynamic LE_PSM available")
+		}
+	}
+	if psm > psmDynamicLast {
+		return nil, fmt.Errorf("invalid LE_PSM 0x%04X", psm)
+	}
+	if _, ok := h.l2capListeners[psm]; ok {
+		return nil, fmt.Errorf("LE_PSM 0x%04X already published", psm)
+	}
+	l := &L2CAPListener{
+		h:         h,
+		psm:       psm,
+		encrypted: encrypted,
+		chAccept:  make(chan *L2CAPChannel, cocAcceptQueue),
+		done:      make(chan struct{}),
+	}
+	h.l2capListeners[psm] = l
+	return l, nil
+}
+
+// l2capListener returns the listener of the LE_PSM, if any.
+func (h *HCI) l2capListener(psm uint16) *L2CAPListener {
+	h.muL2CAP.Lock()
+	defer h.muL2CAP.Unlock()
+	return h.l2capListeners[psm]
+}
+
+// PSM returns the LE_PSM the listener is published on.
+func (l *L2CAPListener) PSM() uint16 { return l.psm }
+
+// Accept waits for and returns the next channel.
+func (l *L2CAPListener) Accept() (ble.L2CAPChannel, error) {
+	select {
+	case ch := <-l.chAccept:
+		l.release()
+		return ch, nil
+	case <-l.done:
+		return nil, io.ErrClosedPipe
+	case <-l.h.done:
+		return nil, l.h.Error()
+	}
+}
+
+// Close unpublishes the LE_PSM, and closes the channels not accepted yet.
+// Accepted channels aren't closed.
+func (l *L2CAPListener) Close() error {
+	l.h.muL2CAP.Lock()
+	if l.h.l2capListeners[l.psm] == l {
+		delete(l.h.l2capListeners, l.psm)
+	}
+	l.h.muL2CAP.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	for {
+		select {
+		case ch := <-l.chAccept:
+			go ch.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// reserve reserves room for a channel in the queue of Accept.
+func (l *L2CAPListener) reserve() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed || l.queued == cap(l.chAccept) {
+		return false
+	}
+	l.queued++
+	return true
+}
+
+func (l *L2CAPListener) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queued--
+}
+
+// deliver queues a channel, in the room reserved for it, or closes it if
+// the listener was closed since.
+func (l *L2CAPListener) deliver(ch *L2CAPChannel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		l.queued--
+		go ch.Close()
+		return
+	}
+	l.chAccept <- ch
+}
@@ -0,0 +1,36 @@
+package hci
+
+import (
+	"fmt"
+
+	"github.com/leso-kn/ble/linux/hci/cmd"
+)
+
+// lmpNoBREDR is the BR/EDR Not Supported bit of the LMP features
+// [Vol 2, Part C, 3.3].
+const lmpNoBREDR = 1 << 37
+
+// SetLEOnly sets whether Init configures a dual-mode controller for LE only.
+// The BR/EDR inquiry and page scans are disabled, so it's neither
+// discoverable nor connectable over BR/EDR, e.g. after another stack used
+// it, and LE isn't used simultaneously with BR/EDR, as always.
+func (h *HCI) SetLEOnly(on bool) error {
+	h.leOnly = on
+	return nil
+}
+
+// configureLEOnly disables the BR/EDR scans of a dual-mode controller.
+// Controllers which don't support BR/EDR are left as is.
+func (h *HCI) configureLEOnly() error {
+	rp := cmd.ReadLocalSupportedFeaturesRP{}
+	if err := h.Send(&cmd.ReadLocalSupportedFeatures{}, &rp); err != nil {
+		return fmt.Errorf("LE-only: read local supported features: %v", err)
+	}
+	if rp.LMPFeatures&lmpNoBREDR != 0 {
+		return nil
+	}
+	if err := h.Send(&cmd.WriteScanEnable{ScanEnable: 0x00}, nil); err != nil {
+		return fmt.Errorf("LE-only: disable BR/EDR scans: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package hci_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/hci/virtual"
+)
+
+func TestLEOnly(t *testing.T) {
+	air := virtual.NewAir()
+	for i, leOnly := range []bool{false, true} {
+		c, err := air.NewController(fmt.Sprintf("AA:BB:CC:DD:EE:%02X", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetDualMode(true)
+		d, err := linux.NewDevice(ble.OptTransportVirtual(c), ble.OptLEOnly(leOnly))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Stop()
+
+		want := uint8(0x03)
+		if leOnly {
+			want = 0x00
+		}
+		if s := c.BREDRScanEnable(); s != want {
+			t.Errorf("LE-only %v: BR/EDR scans 0x%02X, want 0x%02X", leOnly, s, want)
+		}
+	}
+
+	// Controllers without BR/EDR are left as is.
+	c, err := air.NewController("11:22:33:44:55:66")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := linux.NewDevice(ble.OptTransportVirtual(c), ble.OptLEOnly(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Stop()
+}
@@ -0,0 +1,57 @@
+package hci
+
+import "github.com/leso-kn/ble"
+
+// SetLogger sets the logger of the device, and of its connections, instead
+// of the one of the package, e.g. a child of it telling which adapter the
+// lines are from.
+func (h *HCI) SetLogger(l ble.Logger) error {
+	h.muLogs.Lock()
+	defer h.muLogs.Unlock()
+	h.Logger = l
+	h.sampledLogs = nil
+	return nil
+}
+
+// SetLogSampling samples the debug and info lines of subsystem, such as
+// ble.LogScan, according to s.
+func (h *HCI) SetLogSampling(subsystem string, s ble.LogSampling) error {
+	h.muLogs.Lock()
+	defer h.muLogs.Unlock()
+	if h.logSamplers == nil {
+		h.logSamplers = make(map[string]*ble.LogSampler)
+	}
+	h.logSamplers[subsystem] = ble.NewLogSampler(s)
+	h.sampledLogs = nil
+	return nil
+}
+
+// SampledLogger returns l, with the lines sampled as set for subsystem, if
+// they are, e.g. for the ATT servers of the connections.
+func (h *HCI) SampledLogger(subsystem string, l ble.Logger) ble.Logger {
+	h.muLogs.Lock()
+	s := h.logSamplers[subsystem]
+	h.muLogs.Unlock()
+	if s == nil {
+		return l
+	}
+	return s.Logger(l)
+}
+
+// logs returns the logger of the device for subsystem.
+func (h *HCI) logs(subsystem string) ble.Logger {
+	h.muLogs.Lock()
+	defer h.muLogs.Unlock()
+	if l, ok := h.sampledLogs[subsystem]; ok {
+		return l
+	}
+	l := h.Logger
+	if s := h.logSamplers[subsystem]; s != nil {
+		l = s.Logger(l)
+	}
+	if h.sampledLogs == nil {
+		h.sampledLogs = make(map[string]ble.Logger)
+	}
+	h.sampledLogs[subsystem] = l
+	return l
+}
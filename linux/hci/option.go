@@ -3,11 +3,17 @@ package hci
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"time"
 
+	"github.com/leso-kn/ble"
 	"github.com/leso-kn/ble/cache"
 
 	"github.com/leso-kn/ble/linux/hci/cmd"
+	"github.com/leso-kn/ble/linux/hci/h4"
+	"github.com/leso-kn/ble/linux/hci/socket"
+	"github.com/leso-kn/ble/registry"
 )
 
 // SetDialerTimeout sets dialing timeout for Dialer.
@@ -22,6 +28,12 @@ func (h *HCI) SetListenerTimeout(d time.Duration) error {
 	return nil
 }
 
+// SetACLWriteTimeout sets how long writes wait for controller buffers.
+func (h *HCI) SetACLWriteTimeout(d time.Duration) error {
+	h.aclWriteTimeout = d
+	return nil
+}
+
 // SetConnParams overrides default connection parameters.
 func (h *HCI) SetConnParams(param cmd.LECreateConnection) error {
 	h.params.connParams = param
@@ -34,21 +46,317 @@ func (h *HCI) EnableSecurity(bm interface{}) error {
 		return fmt.Errorf("unknown bond manager type")
 	}
 	h.smpEnabled = true
+	h.bondManager = bondManager
 	if h.smp != nil {
-		h.smp.SetBondManager(bondManager)
+		h.smp.SetBondManager(&identityBondManager{bondManager, h})
+	}
+	return nil
+}
+
+// SetPairingAuthData sets the auth data used when the peer initiates pairing.
+func (h *HCI) SetPairingAuthData(ad ble.AuthData) error {
+	h.pairingAuthData = ad
+	return nil
+}
+
+// SetPairingFeatures sets the IO capability and the authentication
+// requirements of the pairing request and response.
+func (h *HCI) SetPairingFeatures(f ble.PairingFeatures) error {
+	c, err := h.smpConfig.WithFeatures(f)
+	if err != nil {
+		return err
+	}
+	h.smpConfig = c
+	return nil
+}
+
+// SetStaticPasskey pairs with the fixed passkey, e.g. printed on the device,
+// in Passkey Entry pairing, in either role. The device pairs as a display
+// only one, requiring MITM protection, so the peer inputs the passkey.
+func (h *HCI) SetStaticPasskey(passkey int) error {
+	if passkey < 0 || passkey > 999999 {
+		return fmt.Errorf("invalid passkey %d", passkey)
+	}
+	c, err := h.smpConfig.WithFeatures(ble.PairingFeatures{IOCap: ble.IOCapDisplayOnly, Bond: true, MITM: true})
+	if err != nil {
+		return err
+	}
+	c.StaticPasskey = &passkey
+	h.smpConfig = c
+	return nil
+}
+
+// SetRestoreEncryption has Dial encrypt the links with bonded peers.
+func (h *HCI) SetRestoreEncryption(on bool) error {
+	h.restoreEnc = on
+	return nil
+}
+
+// SetMaxConnections limits the connections of the local device, in either
+// role; 0 leaves the limit to the controller.
+func (h *HCI) SetMaxConnections(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid maximum number of connections %d", n)
+	}
+	h.muConns.Lock()
+	h.maxConns = n
+	h.muConns.Unlock()
+	return nil
+}
+
+// SetInsecureDebugKeys has LE Secure Connections pairing use the debug key
+// pair, which lets sniffers decrypt the traffic.
+func (h *HCI) SetInsecureDebugKeys(enable bool) error {
+	if h.smp == nil {
+		return fmt.Errorf("security not supported")
+	}
+	h.smp.SetDebugKeys(enable)
+	return nil
+}
+
+// SetSmpCrypto sets the cryptographic functions of pairing, an smp.Crypto
+// for the factory of linux/hci/smp.
+func (h *HCI) SetSmpCrypto(c interface{}) error {
+	if h.smp == nil {
+		return fmt.Errorf("security not supported")
+	}
+	return h.smp.SetCrypto(c)
+}
+
+// SetKeyDistribution sets the key distribution fields of the pairing request
+// and response.
+func (h *HCI) SetKeyDistribution(initKeys, respKeys uint8) error {
+	const keys = KeyDistEncKey | KeyDistIdKey | KeyDistSignKey
+	if initKeys&^keys != 0 || respKeys&^keys != 0 {
+		return fmt.Errorf("unsupported key distribution 0x%02X, 0x%02X", initKeys, respKeys)
+	}
+	h.smpConfig.InitKeyDist = initKeys
+	h.smpConfig.RespKeyDist = respKeys
+	return nil
+}
+
+// SetEncKeySize sets the range of encryption key sizes accepted.
+func (h *HCI) SetEncKeySize(min, max uint8) error {
+	if min < EncKeySizeMin || max > EncKeySizeMax || min > max {
+		return fmt.Errorf("invalid encryption key size range %d-%d", min, max)
+	}
+	h.smpConfig.MinKeySize = min
+	h.smpConfig.MaxKeySize = max
+	return nil
+}
+
+// SetPrivacy enables controller-based privacy with the given local IRK,
+// which bonded peers are given to resolve the local RPAs, so it must persist
+// across restarts.
+func (h *HCI) SetPrivacy(localIRK []byte, rpaTimeout time.Duration) error {
+	if rpaTimeout < RPATimeoutMin || rpaTimeout > RPATimeoutMax {
+		return fmt.Errorf("invalid rpa timeout %v", rpaTimeout)
+	}
+	if len(localIRK) != 16 {
+		return fmt.Errorf("invalid local irk length %v", len(localIRK))
+	}
+
+	p := &privacy{rpaTimeout: rpaTimeout}
+	copy(p.localIRK[:], localIRK)
+	h.privacy = p
+	return nil
+}
+
+// SetPrivacyFile is like SetPrivacy, with the local IRK read from filename.
+// If the file doesn't exist, a new IRK is generated and stored there.
+func (h *HCI) SetPrivacyFile(filename string, rpaTimeout time.Duration) error {
+	irk, err := loadLocalIRK(filename)
+	if err != nil {
+		return err
+	}
+	return h.SetPrivacy(irk[:], rpaTimeout)
+}
+
+// SetEventMask unmasks events in addition to the ones the hci package
+// handles. If the device is already initialized, the new masks are sent to
+// the controller immediately.
+func (h *HCI) SetEventMask(mask, leMask uint64) error {
+	h.evtMask, h.leEvtMask = mask, leMask
+	if h.socket() == nil || !h.isOpen() {
+		return nil
+	}
+	return h.setEventMask()
+}
+
+// SetAdvTxPowerLevel sets whether the TX Power Level AD field is included in
+// advertisements.
+func (h *HCI) SetAdvTxPowerLevel(include bool) error {
+	h.advTxPower = include
+	return nil
+}
+
+// SetAdvValidation sets whether the advertising and scan response data are
+// checked with adv.Validate before they're sent to the controller.
+func (h *HCI) SetAdvValidation(on bool) error {
+	h.advValidate = on
+	return nil
+}
+
+// SetRandomStaticAddr sets the random static address used instead of the
+// public address. If a is nil, the address is read from filename, or generated
+// and stored there if the file doesn't exist yet. If filename is empty too,
+// a new address is generated.
+func (h *HCI) SetRandomStaticAddr(a ble.Addr, filename string) error {
+	var err error
+	var ra net.HardwareAddr
+	switch {
+	case a != nil:
+		ra, err = net.ParseMAC(a.String())
+		if err == nil {
+			err = ValidateRandomStaticAddr(ra)
+		}
+	case filename != "":
+		ra, err = loadRandomStaticAddr(filename)
+	default:
+		ra, err = NewRandomStaticAddr()
+	}
+	if err != nil {
+		return err
+	}
+
+	h.randomAddr = ra
+	return nil
+}
+
+// SetExtConnParams makes Dial use LE Extended Create Connection with the
+// given parameters.
+func (h *HCI) SetExtConnParams(param cmd.LEExtendedCreateConnection) error {
+	if err := ValidateExtConnParams(param); err != nil {
+		return err
+	}
+	h.params.extConnParams = &param
+	return nil
+}
+
+// SetConnPHYs makes Dial initiate connections on the given PHYs, with the
+// parameters of the legacy connection parameters on each.
+func (h *HCI) SetConnPHYs(phys uint8) error {
+	return h.SetExtConnParams(ExtConnParams(phys, h.params.connParams))
+}
+
+// SetInitiatorScan sets the scan interval, window and filter policy Dial
+// initiates connections with, on every PHY of the extended connection
+// parameters as well.
+func (h *HCI) SetInitiatorScan(interval, window time.Duration, acceptList bool) error {
+	policy := uint8(FilterPolicyAcceptAll)
+	if acceptList {
+		policy = FilterPolicyAcceptWhitelist
+	}
+	cp := h.params.connParams
+	cp.LEScanInterval = scanUnits(interval)
+	cp.LEScanWindow = scanUnits(window)
+	cp.InitiatorFilterPolicy = policy
+	if err := ValidateConnParams(cp); err != nil {
+		return err
+	}
+	var ep *cmd.LEExtendedCreateConnection
+	if h.params.extConnParams != nil {
+		e := *h.params.extConnParams
+		e.InitiatorFilterPolicy = policy
+		e.PHYs = append([]cmd.LEExtendedCreateConnectionPHY(nil), e.PHYs...)
+		for i := range e.PHYs {
+			e.PHYs[i].ScanInterval = cp.LEScanInterval
+			e.PHYs[i].ScanWindow = cp.LEScanWindow
+		}
+		if err := ValidateExtConnParams(e); err != nil {
+			return err
+		}
+		ep = &e
 	}
+	h.params.connParams = cp
+	h.params.extConnParams = ep
 	return nil
 }
 
 // SetScanParams overrides default scanning parameters.
 func (h *HCI) SetScanParams(param cmd.LESetScanParameters) error {
-	h.params.scanParams = param
+	return h.updateScanParams(func(p *cmd.LESetScanParameters) { *p = param })
+}
+
+// SetScanInterval sets how often and how long the controller scans.
+func (h *HCI) SetScanInterval(interval, window time.Duration) error {
+	return h.updateScanParams(func(p *cmd.LESetScanParameters) {
+		p.LEScanInterval = scanUnits(interval)
+		p.LEScanWindow = scanUnits(window)
+	})
+}
+
+// SetScanType selects active or passive scanning.
+func (h *HCI) SetScanType(active bool) error {
+	return h.updateScanParams(func(p *cmd.LESetScanParameters) {
+		p.LEScanType = LEScanTypePassive
+		if active {
+			p.LEScanType = LEScanTypeActive
+		}
+	})
+}
+
+// SetScanOwnAddrType sets the address type used in scan requests.
+func (h *HCI) SetScanOwnAddrType(typ uint8) error {
+	return h.updateScanParams(func(p *cmd.LESetScanParameters) { p.OwnAddressType = typ })
+}
+
+// updateScanParams validates and applies a change of the scan parameters.
+// They're sent to the controller with the next Scan.
+func (h *HCI) updateScanParams(f func(p *cmd.LESetScanParameters)) error {
+	h.params.Lock()
+	defer h.params.Unlock()
+	sp := h.params.scanParams
+	f(&sp)
+	if err := ValidateScanParams(sp); err != nil {
+		return err
+	}
+	h.params.scanParams = sp
+	h.params.scanParamsDirty = true
 	return nil
 }
 
 // SetAdvParams overrides default advertising parameters.
 func (h *HCI) SetAdvParams(param cmd.LESetAdvertisingParameters) error {
-	h.params.advParams = param
+	return h.updateAdvParams(func(p *cmd.LESetAdvertisingParameters) { *p = param })
+}
+
+// SetAdvInterval sets the range of the advertising interval.
+func (h *HCI) SetAdvInterval(min, max time.Duration) error {
+	return h.updateAdvParams(func(p *cmd.LESetAdvertisingParameters) {
+		p.AdvertisingIntervalMin = scanUnits(min)
+		p.AdvertisingIntervalMax = scanUnits(max)
+	})
+}
+
+// SetAdvChannelMap sets the channels used for advertising.
+func (h *HCI) SetAdvChannelMap(m uint8) error {
+	return h.updateAdvParams(func(p *cmd.LESetAdvertisingParameters) { p.AdvertisingChannelMap = m })
+}
+
+// SetAdvOwnAddrType sets the address type used in advertisements.
+func (h *HCI) SetAdvOwnAddrType(typ uint8) error {
+	return h.updateAdvParams(func(p *cmd.LESetAdvertisingParameters) { p.OwnAddressType = typ })
+}
+
+// SetAdvFilterPolicy sets which scan and connection requests are processed
+// while advertising.
+func (h *HCI) SetAdvFilterPolicy(policy uint8) error {
+	return h.updateAdvParams(func(p *cmd.LESetAdvertisingParameters) { p.AdvertisingFilterPolicy = policy })
+}
+
+// updateAdvParams validates and applies a change of the advertising
+// parameters. They're sent to the controller with the next Advertise.
+func (h *HCI) updateAdvParams(f func(p *cmd.LESetAdvertisingParameters)) error {
+	h.params.Lock()
+	defer h.params.Unlock()
+	ap := h.params.advParams
+	f(&ap)
+	if err := ValidateAdvParams(ap); err != nil {
+		return err
+	}
+	h.params.advParams = ap
+	h.params.advParamsDirty = true
 	return nil
 }
 
@@ -68,36 +376,366 @@ func (h *HCI) SetAdvHandlerSync(sync bool) error {
 	return nil
 }
 
+// SetScanDedup sets the window in which advertisements with the same address
+// and payload are reported only once. Zero disables the filtering.
+func (h *HCI) SetScanDedup(window time.Duration) error {
+	if window < 0 {
+		return fmt.Errorf("invalid dedup window %v", window)
+	}
+	h.advDedup.Lock()
+	h.advDedup.window = window
+	h.advDedup.seen = nil
+	h.advDedup.Unlock()
+	return nil
+}
+
+// SetScanAggregate sets the period in which the reports of each peer are
+// merged into a single advertisement. Zero reports each packet as received.
+// It applies from the next scan on.
+func (h *HCI) SetScanAggregate(period time.Duration) error {
+	if period < 0 {
+		return fmt.Errorf("invalid aggregation period %v", period)
+	}
+	h.advAggregator.Lock()
+	h.advAggregator.period = period
+	h.advAggregator.Unlock()
+	return nil
+}
+
+// SetScanResponseWait sets how long active scans hold the scannable
+// advertisements of a peer, waiting for its scan response to deliver them
+// together. Zero delivers each report as received. It applies from the next
+// scan on.
+func (h *HCI) SetScanResponseWait(wait time.Duration) error {
+	if wait < 0 {
+		return fmt.Errorf("invalid scan response wait %v", wait)
+	}
+	h.advStitcher.Lock()
+	h.advStitcher.wait = wait
+	h.advStitcher.Unlock()
+	return nil
+}
+
+// SetScanDutyCycle makes scans alternate window of scanning and idle of
+// rest, to save power. Zero durations scan continuously, as by default. It
+// applies from the next scan on.
+func (h *HCI) SetScanDutyCycle(window, idle time.Duration) error {
+	if window < 0 || idle < 0 {
+		return fmt.Errorf("invalid scan duty cycle %v/%v", window, idle)
+	}
+	h.scanSched.Lock()
+	h.scanSched.window, h.scanSched.idle = window, idle
+	h.scanSched.Unlock()
+	return nil
+}
+
+// SetAdvParseErrorHandler sets the handler passed the raw bytes of malformed
+// advertising reports.
+func (h *HCI) SetAdvParseErrorHandler(f func(raw []byte, err error)) error {
+	h.advParseErrorHandler = f
+	return nil
+}
+
+// SetLenientAdvParsing sets whether malformed AD structures are skipped,
+// reporting the rest of the advertisement along with the parse errors,
+// rather than dropping the whole advertisement.
+func (h *HCI) SetLenientAdvParsing(lenient bool) error {
+	h.advLenient = lenient
+	return nil
+}
+
+// SetLazyAdvParsing sets whether the fields of advertisements are decoded
+// on demand, from the advertising report, rather than into a map for each
+// report.
+func (h *HCI) SetLazyAdvParsing(lazy bool) error {
+	h.advLazy = lazy
+	return nil
+}
+
 // SetErrorHandler ...
 func (h *HCI) SetErrorHandler(handler func(error)) error {
 	h.errorHandler = handler
 	return nil
 }
 
+// SetConnParamsRequestHandler sets the policy for remote connection parameter requests.
+func (h *HCI) SetConnParamsRequestHandler(f ble.ConnParamsRequestHandler) error {
+	h.connParamsReqHandler = f
+	return nil
+}
+
+// SetPreferredConnParams has the peripheral request the connection
+// parameters p from the centrals, delay after they connect, and notify
+// result, if not nil, of the outcome.
+func (h *HCI) SetPreferredConnParams(p ble.ConnParams, delay time.Duration, result ble.ConnParamsResultHandler) error {
+	if err := ValidatePreferredConnParams(p); err != nil {
+		return err
+	}
+	h.prefConnParams = &p
+	h.prefConnDelay = delay
+	h.prefConnResult = result
+	return nil
+}
+
+// SetAuthPayloadTimeout sets the authenticated payload timeout applied to
+// encrypted links, and the handler notified when it expires.
+func (h *HCI) SetAuthPayloadTimeout(d time.Duration, expired func(ble.Addr)) error {
+	if d != 0 {
+		if err := ValidateAuthPayloadTimeout(d); err != nil {
+			return err
+		}
+	}
+	h.aptoTimeout = d
+	h.aptoExpired = expired
+	return nil
+}
+
+// SetSkipHCIReset sets whether the HCI Reset command is skipped at init.
+func (h *HCI) SetSkipHCIReset(skip bool) error {
+	h.skipReset = skip
+	return nil
+}
+
+// SetInitCommands sets commands sent before and after the init sequence.
+func (h *HCI) SetInitCommands(pre, post []ble.HCICommand) error {
+	h.preInitCmds = pre
+	h.postInitCmds = post
+	return nil
+}
+
+// SetRecovery enables the watchdog, which resets and re-initializes the
+// controller when it fails, and reports recovery attempts to handler.
+func (h *HCI) SetRecovery(handler ble.RecoveryHandler) error {
+	h.watchdog = newWatchdog(handler)
+	return nil
+}
+
+// SetHostAddrResolution enables resolving the private addresses of advertisers
+// on the host, using the identities of bonded peers.
+func (h *HCI) SetHostAddrResolution(enable bool) error {
+	h.resolver = nil
+	if enable {
+		h.resolver = &resolver{}
+	}
+	return nil
+}
+
+// SetAddressRotation rotates the private address of the local device every
+// period: the controller does with controller-based privacy, else the host.
+func (h *HCI) SetAddressRotation(period time.Duration) error {
+	if period <= 0 || period > RPATimeoutMax {
+		return fmt.Errorf("invalid rotation period %v", period)
+	}
+	h.rotation = &addrRotation{period: period}
+	return nil
+}
+
 // SetTransportHCISocket sets HCI device for hci socket
 func (h *HCI) SetTransportHCISocket(id int) error {
 	h.transport = transport{
-		hci: &transportHci{id},
+		hci: &transportHci{id: id},
+	}
+	return nil
+}
+
+// SetHCIChannel sets the channel the device of the hci socket transport is
+// opened on, a socket.Channel.
+func (h *HCI) SetHCIChannel(ch interface{}) error {
+	c, ok := ch.(socket.Channel)
+	if !ok {
+		return fmt.Errorf("unknown hci channel type")
+	}
+	if h.transport.hci == nil {
+		return fmt.Errorf("hci channel requires the hci socket transport")
 	}
+	h.transport.hci.ch = c
 	return nil
 }
 
 // SetTransportH4Socket sets h4 socket server
 func (h *HCI) SetTransportH4Socket(addr string, timeout time.Duration) error {
 	h.transport = transport{
-		h4socket: &transportH4Socket{addr, timeout},
+		h4socket: &transportH4Socket{addr: addr, timeout: timeout},
 	}
 	return nil
 }
 
+// SetH4SocketAuth sets the TLS and token settings, an *h4.SocketAuth, of the
+// h4 socket transport.
+func (h *HCI) SetH4SocketAuth(auth interface{}) error {
+	a, ok := auth.(*h4.SocketAuth)
+	if !ok {
+		return fmt.Errorf("unknown h4 socket auth type")
+	}
+	if h.transport.h4socket == nil {
+		return fmt.Errorf("h4 socket auth requires the h4 socket transport")
+	}
+	h.transport.h4socket.auth = a
+	return nil
+}
+
 // SetTransportH4Uart sets h4 uart path
 func (h *HCI) SetTransportH4Uart(path string, baud int) error {
 	h.transport = transport{
-		h4uart: &transportH4Uart{path, baud},
+		h4uart: &transportH4Uart{path: path, baud: baud},
 	}
 	return nil
 }
 
+// SetTransportVirtual sets a virtual controller as the transport.
+func (h *HCI) SetTransportVirtual(ctrl io.ReadWriteCloser) error {
+	h.transport = transport{
+		virtual: &transportVirtual{ctrl: ctrl},
+	}
+	return nil
+}
+
+// SetTransportRecord records the session of the transport to w, as a
+// btsnoop trace.
+func (h *HCI) SetTransportRecord(w io.Writer) error {
+	if w == nil {
+		return fmt.Errorf("no trace writer")
+	}
+	h.transport.record = w
+	return nil
+}
+
+// SetAdvRestart sets whether advertising restarts after a central connects,
+// and the number of connected centrals it stops at; 0 means no limit.
+func (h *HCI) SetAdvRestart(on bool, maxConns int) error {
+	if maxConns < 0 {
+		return fmt.Errorf("invalid maximum number of connections %d", maxConns)
+	}
+	h.muAdv.Lock()
+	h.advNoRestart, h.advMaxConns = !on, maxConns
+	h.muAdv.Unlock()
+	return nil
+}
+
+// SetTransportTrace writes a human-readable decode of the session of the
+// transport to w.
+func (h *HCI) SetTransportTrace(w io.Writer) error {
+	if w == nil {
+		return fmt.Errorf("no trace writer")
+	}
+	h.transport.trace = w
+	return nil
+}
+
+// SetUartVendor sets the vendor hook which brings up the controller of the
+// h4 uart transport.
+func (h *HCI) SetUartVendor(vendor interface{}, initBaud int) error {
+	v, ok := vendor.(h4.Vendor)
+	if !ok {
+		return fmt.Errorf("unknown uart vendor type")
+	}
+	if h.transport.h4uart == nil {
+		return fmt.Errorf("uart vendor requires the h4 uart transport")
+	}
+	if initBaud < 0 {
+		return fmt.Errorf("invalid initial baud rate %d", initBaud)
+	}
+	h.transport.h4uart.vendor = v
+	h.transport.h4uart.initBaud = uint(initBaud)
+	return nil
+}
+
 func (h *HCI) SetGattCacheFile(filename string) {
 	h.cache = cache.New(filename)
 }
+
+// SetNotificationWorkers sets the number of goroutines handling the
+// notifications of each connection dialed.
+func (h *HCI) SetNotificationWorkers(n int) error {
+	if n < 1 {
+		return fmt.Errorf("invalid number of notification workers %d", n)
+	}
+	h.notifWorkers = n
+	return nil
+}
+
+// SetUUIDCompression sets whether the UUIDs discovered by the clients of the
+// connections dialed are compressed.
+func (h *HCI) SetUUIDCompression(on bool) error {
+	h.fullUUIDs = !on
+	return nil
+}
+
+// SetReadCoalescing sets whether the clients of the connections dialed
+// coalesce concurrent reads of the same characteristic.
+func (h *HCI) SetReadCoalescing(on bool) error {
+	h.coalesceReads = on
+	return nil
+}
+
+// SetGATTRateLimit limits the operations of the clients of the connections
+// dialed to rate per second, spaced by at least spacing.
+func (h *HCI) SetGATTRateLimit(rate float64, spacing time.Duration) error {
+	if rate < 0 || spacing < 0 {
+		return fmt.Errorf("invalid GATT rate limit %v/s, spacing %v", rate, spacing)
+	}
+	h.gattRate, h.gattSpacing = rate, spacing
+	return nil
+}
+
+// SetNotificationOrdering sets whether the reads of the clients of the
+// connections dialed wait for the notifications received before them.
+func (h *HCI) SetNotificationOrdering(on bool) error {
+	h.orderNotifs = on
+	return nil
+}
+
+// SetStrictSequential sets whether the requests of the clients of the
+// connections dialed fail while another one is pending.
+func (h *HCI) SetStrictSequential(on bool) error {
+	h.strictSeq = on
+	return nil
+}
+
+// SetNotificationTimestamps sets whether the ACL data packets are stamped
+// with the time they're received at, which Conn.ReadTimestamped returns.
+func (h *HCI) SetNotificationTimestamps(on bool) error {
+	h.rxTimestamps = on
+	return nil
+}
+
+// SetResponseTolerance sets for how long the GATT clients expect the late
+// responses of their requests timed out, and whether they discard the
+// responses which don't answer their pending request. It applies to the
+// clients of the connections dialed, and to those of linux.Device.PeerClient
+// on the connections accepted.
+func (h *HCI) SetResponseTolerance(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("invalid response tolerance %v", d)
+	}
+	h.respTolerance = d
+	return nil
+}
+
+// ResponseTolerance returns the tolerance set by SetResponseTolerance.
+func (h *HCI) ResponseTolerance() time.Duration {
+	return h.respTolerance
+}
+
+// SetRegistry records the devices observed while scanning in the registry r,
+// a *registry.Registry.
+func (h *HCI) SetRegistry(r interface{}) error {
+	reg, ok := r.(*registry.Registry)
+	if !ok || reg == nil {
+		return fmt.Errorf("unknown registry type")
+	}
+	h.registry = reg
+	return nil
+}
+
+// Registry returns the registry set with SetRegistry, or nil.
+func (h *HCI) Registry() *registry.Registry {
+	return h.registry
+}
+
+// SetCentralOnly makes the HCI refuse incoming connections, and Accept fail
+// with ErrCentralOnly, for applications without a GATT server. It must be
+// set before the HCI is initialized.
+func (h *HCI) SetCentralOnly(on bool) {
+	h.centralOnly = on
+}
@@ -3,7 +3,9 @@ package hci
 import (
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/leso-kn/ble"
 	"github.com/leso-kn/ble/linux/hci/cmd"
 )
 
@@ -15,6 +17,20 @@ const (
 	LEScanTypePassive           = 0
 	LEScanTypeActive            = 1
 
+	// Advertising filter policies [Vol 2, Part E, 7.8.5]: which scan and
+	// connection requests are processed, from any device or only from the
+	// devices of the filter accept list.
+	AdvFilterPolicyAll         = 0x00 // Scan and connection requests from any device.
+	AdvFilterPolicyScan        = 0x01 // Scan requests from the list, connection requests from any device.
+	AdvFilterPolicyConn        = 0x02 // Scan requests from any device, connection requests from the list.
+	AdvFilterPolicyScanAndConn = 0x03 // Scan and connection requests from the list.
+
+	AdvIntervalMin = 0x0020
+	AdvIntervalMax = 0x4000
+
+	AdvChannelMapMin = 0x01
+	AdvChannelMapMax = 0x07
+
 	LEScanIntervalMin = 0x0004
 	LEScanIntervalMax = 0x4000
 	LEScanWindowMin   = 0x0004
@@ -35,6 +51,11 @@ const (
 type params struct {
 	sync.RWMutex
 
+	// scanParamsDirty and advParamsDirty are set when scanParams or advParams
+	// changed after they were sent.
+	scanParamsDirty bool
+	advParamsDirty  bool
+
 	advEnable  cmd.LESetAdvertiseEnable
 	scanEnable cmd.LESetScanEnable
 	connCancel cmd.LECreateConnectionCancel
@@ -44,6 +65,9 @@ type params struct {
 	advParams  cmd.LESetAdvertisingParameters
 	scanParams cmd.LESetScanParameters
 	connParams cmd.LECreateConnection
+
+	// extConnParams is used by Dial instead of connParams, if set.
+	extConnParams *cmd.LEExtendedCreateConnection
 }
 
 func (p *params) init() {
@@ -80,6 +104,89 @@ func (p *params) init() {
 	}
 }
 
+// scanUnits converts a duration to the 0.625 msec units of scan and
+// advertising intervals.
+func scanUnits(d time.Duration) uint16 {
+	n := d / (625 * time.Microsecond)
+	if n > 0xFFFF {
+		return 0xFFFF
+	}
+	return uint16(n)
+}
+
+// connIntervalUnits converts a duration to the 1.25 msec units of
+// connection intervals.
+func connIntervalUnits(d time.Duration) uint16 {
+	n := d / (1250 * time.Microsecond)
+	if n > 0xFFFF {
+		return 0xFFFF
+	}
+	return uint16(n)
+}
+
+// applyDialParams overrides the connection parameters c with the non-zero
+// ones of p. The caller validates the result.
+func applyDialParams(c *cmd.LECreateConnection, p ble.DialParams) {
+	if p.ScanInterval != 0 {
+		c.LEScanInterval = scanUnits(p.ScanInterval)
+	}
+	if p.ScanWindow != 0 {
+		c.LEScanWindow = scanUnits(p.ScanWindow)
+	}
+	if p.ConnIntervalMin != 0 {
+		c.ConnIntervalMin = connIntervalUnits(p.ConnIntervalMin)
+	}
+	if p.ConnIntervalMax != 0 {
+		c.ConnIntervalMax = connIntervalUnits(p.ConnIntervalMax)
+	}
+	if p.SupervisionTimeout != 0 {
+		n := p.SupervisionTimeout / (10 * time.Millisecond)
+		if n > 0xFFFF {
+			n = 0xFFFF
+		}
+		c.SupervisionTimeout = uint16(n)
+	}
+	c.ConnLatency = p.ConnLatency
+}
+
+// applyExtDialParams overrides the parameters of each PHY of e with the
+// non-zero ones of p.
+func applyExtDialParams(e *cmd.LEExtendedCreateConnection, p ble.DialParams) {
+	phys := make([]cmd.LEExtendedCreateConnectionPHY, len(e.PHYs))
+	for i, pp := range e.PHYs {
+		c := cmd.LECreateConnection{
+			LEScanInterval:     pp.ScanInterval,
+			LEScanWindow:       pp.ScanWindow,
+			ConnIntervalMin:    pp.ConnIntervalMin,
+			ConnIntervalMax:    pp.ConnIntervalMax,
+			ConnLatency:        pp.ConnLatency,
+			SupervisionTimeout: pp.SupervisionTimeout,
+		}
+		applyDialParams(&c, p)
+		pp.ScanInterval, pp.ScanWindow = c.LEScanInterval, c.LEScanWindow
+		pp.ConnIntervalMin, pp.ConnIntervalMax = c.ConnIntervalMin, c.ConnIntervalMax
+		pp.ConnLatency, pp.SupervisionTimeout = c.ConnLatency, c.SupervisionTimeout
+		phys[i] = pp
+	}
+	e.PHYs = phys
+}
+
+// setOwnAddressType sets the own address type of the parameters to oat. The
+// advertising and scanning ones are kept if the user chose them explicitly,
+// other than prev.
+func (p *params) setOwnAddressType(prev, oat uint8) {
+	if p.advParams.OwnAddressType == prev {
+		p.advParams.OwnAddressType = oat
+	}
+	if p.scanParams.OwnAddressType == prev {
+		p.scanParams.OwnAddressType = oat
+	}
+	p.connParams.OwnAddressType = oat
+	if p.extConnParams != nil {
+		p.extConnParams.OwnAddressType = oat
+	}
+}
+
 func (p *params) validate() error {
 	if p == nil {
 		return fmt.Errorf("params nil")
@@ -90,7 +197,14 @@ func (p *params) validate() error {
 	if err := ValidateScanParams(p.scanParams); err != nil {
 		return err
 	}
-	// todo: validate the rest
+	if err := ValidateAdvParams(p.advParams); err != nil {
+		return err
+	}
+	if p.extConnParams != nil {
+		if err := ValidateExtConnParams(*p.extConnParams); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -109,7 +223,7 @@ func ValidateScanParams(p cmd.LESetScanParameters) error {
 	case p.LEScanWindow > p.LEScanInterval:
 		return fmt.Errorf("LEScanWindow %v > LEScanInterval %v", p.LEScanWindow, p.LEScanInterval)
 
-	case p.OwnAddressType != AddressTypePublic && p.OwnAddressType != AddressTypeRandom:
+	case p.OwnAddressType > AddressTypeRPAOrRandom:
 		// this probably is filled later
 		return fmt.Errorf("invalid OwnAddressType %v", p.OwnAddressType)
 
@@ -120,6 +234,37 @@ func ValidateScanParams(p cmd.LESetScanParameters) error {
 	return nil
 }
 
+// ValidateAdvParams checks the advertising parameters [Vol 2, Part E, 7.8.5].
+func ValidateAdvParams(p cmd.LESetAdvertisingParameters) error {
+	switch {
+	case p.AdvertisingIntervalMin < AdvIntervalMin || p.AdvertisingIntervalMin > AdvIntervalMax:
+		return fmt.Errorf("invalid AdvertisingIntervalMin %v", p.AdvertisingIntervalMin)
+
+	case p.AdvertisingIntervalMax < AdvIntervalMin || p.AdvertisingIntervalMax > AdvIntervalMax:
+		return fmt.Errorf("invalid AdvertisingIntervalMax %v", p.AdvertisingIntervalMax)
+
+	case p.AdvertisingIntervalMin > p.AdvertisingIntervalMax:
+		return fmt.Errorf("AdvertisingIntervalMin %v > AdvertisingIntervalMax %v", p.AdvertisingIntervalMin, p.AdvertisingIntervalMax)
+
+	case p.AdvertisingType > 0x04:
+		return fmt.Errorf("invalid AdvertisingType %v", p.AdvertisingType)
+
+	case p.OwnAddressType > AddressTypeRPAOrRandom:
+		return fmt.Errorf("invalid OwnAddressType %v", p.OwnAddressType)
+
+	case p.DirectAddressType != AddressTypePublic && p.DirectAddressType != AddressTypeRandom:
+		return fmt.Errorf("invalid DirectAddressType %v", p.DirectAddressType)
+
+	case p.AdvertisingChannelMap < AdvChannelMapMin || p.AdvertisingChannelMap > AdvChannelMapMax:
+		return fmt.Errorf("invalid AdvertisingChannelMap %v", p.AdvertisingChannelMap)
+
+	case p.AdvertisingFilterPolicy > 0x03:
+		return fmt.Errorf("invalid AdvertisingFilterPolicy %v", p.AdvertisingFilterPolicy)
+	}
+
+	return nil
+}
+
 func ValidateConnParams(p cmd.LECreateConnection) error {
 
 	/* The Supervision_Timeout in milliseconds shall be larger than
@@ -144,7 +289,7 @@ func ValidateConnParams(p cmd.LECreateConnection) error {
 	case p.InitiatorFilterPolicy != FilterPolicyAcceptAll && p.InitiatorFilterPolicy != FilterPolicyAcceptWhitelist:
 		return fmt.Errorf("invalid InitiatorFilterPolicy %v", p.InitiatorFilterPolicy)
 
-	case p.OwnAddressType != AddressTypePublic && p.OwnAddressType != AddressTypeRandom:
+	case p.OwnAddressType > AddressTypeRPAOrRandom:
 		// this probably is filled later
 		return fmt.Errorf("invalid OwnAddressType %v", p.OwnAddressType)
 
@@ -183,3 +328,32 @@ func ValidateConnParams(p cmd.LECreateConnection) error {
 
 	return nil
 }
+
+// ValidateConnParamsRequest checks the parameters used to answer a remote
+// connection parameter request [Vol 2, Part E, 7.8.31].
+func ValidateConnParamsRequest(p ble.ConnParams) error {
+	minStoMs := (1 + float64(p.Latency)) * (float64(p.IntervalMax) * 1.25) * 2
+	stoMs := float64(p.Timeout) * 10
+
+	switch {
+	case p.IntervalMin < ConnIntervalMin || p.IntervalMin > ConnIntervalMax:
+		return fmt.Errorf("invalid IntervalMin %v", p.IntervalMin)
+
+	case p.IntervalMax < ConnIntervalMin || p.IntervalMax > ConnIntervalMax:
+		return fmt.Errorf("invalid IntervalMax %v", p.IntervalMax)
+
+	case p.IntervalMin > p.IntervalMax:
+		return fmt.Errorf("IntervalMin %v > IntervalMax %v", p.IntervalMin, p.IntervalMax)
+
+	case p.Latency < ConnLatencyMin || p.Latency > ConnLatencyMax:
+		return fmt.Errorf("invalid Latency %v", p.Latency)
+
+	case p.Timeout < SupervisionTimeoutMin || p.Timeout > SupervisionTimeoutMax:
+		return fmt.Errorf("invalid Timeout %v", p.Timeout)
+
+	case stoMs < minStoMs:
+		return fmt.Errorf("invalid Timeout %v (too small)", p.Timeout)
+	}
+
+	return nil
+}
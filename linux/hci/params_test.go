@@ -0,0 +1,142 @@
+package hci
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+)
+
+func TestScanUnits(t *testing.T) {
+	for _, tc := range []struct {
+		d    time.Duration
+		want uint16
+	}{
+		{20 * time.Millisecond, 0x0020},
+		{2500 * time.Microsecond, 0x0004},
+		{10240 * time.Millisecond, 0x4000},
+		{time.Hour, 0xFFFF},
+	} {
+		if got := scanUnits(tc.d); got != tc.want {
+			t.Errorf("scanUnits(%v) = %#04x, want %#04x", tc.d, got, tc.want)
+		}
+	}
+}
+
+func TestValidateAdvParams(t *testing.T) {
+	var p params
+	p.init()
+	if err := ValidateAdvParams(p.advParams); err != nil {
+		t.Fatalf("default params: %v", err)
+	}
+
+	ap := p.advParams
+	ap.AdvertisingIntervalMin, ap.AdvertisingIntervalMax = 0x0100, 0x0080
+	if ValidateAdvParams(ap) == nil {
+		t.Error("expected error for min interval > max interval")
+	}
+
+	ap = p.advParams
+	ap.AdvertisingChannelMap = 0
+	if ValidateAdvParams(ap) == nil {
+		t.Error("expected error for empty channel map")
+	}
+}
+
+func TestValidateConnParamsRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		p    ble.ConnParams
+		ok   bool
+	}{
+		{"valid", ble.ConnParams{IntervalMin: 24, IntervalMax: 40, Latency: 0, Timeout: 400}, true},
+		{"limits", ble.ConnParams{IntervalMin: 0x0006, IntervalMax: 0x0c80, Latency: 0, Timeout: 0x0c80}, true},
+		{"min interval too small", ble.ConnParams{IntervalMin: 0x0005, IntervalMax: 40, Timeout: 400}, false},
+		{"min interval too large", ble.ConnParams{IntervalMin: 0x0c81, IntervalMax: 0x0c80, Timeout: 0x0c80}, false},
+		{"max interval too small", ble.ConnParams{IntervalMin: 0x0006, IntervalMax: 0x0005, Timeout: 400}, false},
+		{"max interval too large", ble.ConnParams{IntervalMin: 24, IntervalMax: 0x0c81, Timeout: 0x0c80}, false},
+		{"min interval > max interval", ble.ConnParams{IntervalMin: 40, IntervalMax: 24, Timeout: 400}, false},
+		{"max latency", ble.ConnParams{IntervalMin: 6, IntervalMax: 6, Latency: 0x01f3, Timeout: 0x0c80}, true},
+		{"latency too large", ble.ConnParams{IntervalMin: 6, IntervalMax: 6, Latency: 0x01f4, Timeout: 0x0c80}, false},
+		{"timeout too small", ble.ConnParams{IntervalMin: 6, IntervalMax: 6, Timeout: 0x0009}, false},
+		{"timeout too large", ble.ConnParams{IntervalMin: 24, IntervalMax: 40, Timeout: 0x0c81}, false},
+		// The timeout is at least (1 + latency) * max interval * 2: 500 ms
+		// for 50 ms and a latency of 4.
+		{"timeout at interval and latency", ble.ConnParams{IntervalMin: 24, IntervalMax: 40, Latency: 4, Timeout: 50}, true},
+		{"timeout below interval and latency", ble.ConnParams{IntervalMin: 24, IntervalMax: 40, Latency: 4, Timeout: 49}, false},
+	} {
+		if err := ValidateConnParamsRequest(tc.p); (err == nil) != tc.ok {
+			t.Errorf("%s: %+v: %v", tc.name, tc.p, err)
+		}
+	}
+}
+
+func TestApplyDialParams(t *testing.T) {
+	var p params
+	p.init()
+
+	c := p.connParams
+	applyDialParams(&c, ble.DialParams{
+		ConnIntervalMin:    30 * time.Millisecond,
+		ConnIntervalMax:    50 * time.Millisecond,
+		SupervisionTimeout: 4 * time.Second,
+		ConnLatency:        2,
+	})
+	if c.ConnIntervalMin != 24 || c.ConnIntervalMax != 40 || c.SupervisionTimeout != 400 || c.ConnLatency != 2 {
+		t.Fatalf("unexpected params %+v", c)
+	}
+	if c.LEScanInterval != p.connParams.LEScanInterval || c.LEScanWindow != p.connParams.LEScanWindow {
+		t.Fatal("zero scan durations changed the defaults")
+	}
+	if err := ValidateConnParams(c); err != nil {
+		t.Fatal(err)
+	}
+
+	e := ExtConnParams(PHY1M|PHYCoded, p.connParams)
+	orig := e.PHYs
+	applyExtDialParams(&e, ble.DialParams{ScanInterval: 100 * time.Millisecond, ScanWindow: 50 * time.Millisecond})
+	for _, pp := range e.PHYs {
+		if pp.ScanInterval != 160 || pp.ScanWindow != 80 {
+			t.Fatalf("unexpected PHY params %+v", pp)
+		}
+	}
+	if orig[0].ScanInterval != p.connParams.LEScanInterval {
+		t.Fatal("applyExtDialParams modified the original PHY params")
+	}
+}
+
+func TestSetInitiatorScan(t *testing.T) {
+	h := &HCI{}
+	h.params.init()
+	if err := h.SetConnPHYs(PHY1M | PHYCoded); err != nil {
+		t.Fatal(err)
+	}
+	orig := h.params.extConnParams.PHYs
+
+	if err := h.SetInitiatorScan(20*time.Millisecond, 20*time.Millisecond, true); err != nil {
+		t.Fatal(err)
+	}
+	cp := h.params.connParams
+	if cp.LEScanInterval != 0x20 || cp.LEScanWindow != 0x20 || cp.InitiatorFilterPolicy != FilterPolicyAcceptWhitelist {
+		t.Fatalf("unexpected params %+v", cp)
+	}
+	e := h.params.extConnParams
+	if e.InitiatorFilterPolicy != FilterPolicyAcceptWhitelist {
+		t.Fatal("extended filter policy not set")
+	}
+	for _, pp := range e.PHYs {
+		if pp.ScanInterval != 0x20 || pp.ScanWindow != 0x20 {
+			t.Fatalf("unexpected PHY params %+v", pp)
+		}
+	}
+	if orig[0].ScanInterval == 0x20 {
+		t.Fatal("SetInitiatorScan modified the previous PHY params")
+	}
+
+	if h.SetInitiatorScan(10*time.Millisecond, 20*time.Millisecond, false) == nil {
+		t.Fatal("expected error for window > interval")
+	}
+	if h.params.connParams.LEScanWindow != 0x20 {
+		t.Fatal("invalid parameters applied")
+	}
+}
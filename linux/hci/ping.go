@@ -0,0 +1,89 @@
+package hci
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/leso-kn/ble/linux/hci/cmd"
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+// Valid authenticated payload timeout range [Vol 2, Part E, 7.3.94].
+const (
+	AuthPayloadTimeoutMin = 0x0001 * 10 * time.Millisecond
+	AuthPayloadTimeoutMax = 0xFFFF * 10 * time.Millisecond
+)
+
+// ValidateAuthPayloadTimeout checks an authenticated payload timeout.
+func ValidateAuthPayloadTimeout(d time.Duration) error {
+	if d < AuthPayloadTimeoutMin || d > AuthPayloadTimeoutMax {
+		return fmt.Errorf("invalid authenticated payload timeout %v", d)
+	}
+	return nil
+}
+
+// SetAuthPayloadTimeout sets the maximum time between packets with a valid MIC
+// on the encrypted link. The controller uses LE Ping to keep the link alive
+// when there is no traffic, and reports peers which don't respond in time.
+// The timeout must be larger than the connection interval times (1 + latency).
+func (c *Conn) SetAuthPayloadTimeout(d time.Duration) error {
+	if err := ValidateAuthPayloadTimeout(d); err != nil {
+		return err
+	}
+	wr := &cmd.WriteAuthenticatedPayloadTimeout{
+		ConnectionHandle:            c.param.ConnectionHandle(),
+		AuthenticatedPayloadTimeout: uint16(d / (10 * time.Millisecond)),
+	}
+	if err := c.hci.Send(wr, nil); err != nil {
+		return fmt.Errorf("failed to write authenticated payload timeout: %v", err)
+	}
+	return nil
+}
+
+// AuthPayloadTimeout returns the authenticated payload timeout of the link.
+func (c *Conn) AuthPayloadTimeout() (time.Duration, error) {
+	rd := &cmd.ReadAuthenticatedPayloadTimeout{ConnectionHandle: c.param.ConnectionHandle()}
+	rp := cmd.ReadAuthenticatedPayloadTimeoutRP{}
+	if err := c.hci.Send(rd, &rp); err != nil {
+		return 0, fmt.Errorf("failed to read authenticated payload timeout: %v", err)
+	}
+	return time.Duration(rp.AuthenticatedPayloadTimeout) * 10 * time.Millisecond, nil
+}
+
+// applyAuthPayloadTimeout configures the default authenticated payload
+// timeout, if any, once the link is encrypted.
+func (c *Conn) applyAuthPayloadTimeout() {
+	if c.hci.aptoTimeout == 0 {
+		return
+	}
+	if err := c.SetAuthPayloadTimeout(c.hci.aptoTimeout); err != nil {
+		c.Warnf("authPayloadTimeout: %v", err)
+	}
+}
+
+func (h *HCI) handleAuthPayloadTimeoutExpired(b []byte) error {
+	e := evt.AuthenticatedPayloadTimeoutExpired(b)
+	if len(e) < 2 {
+		return fmt.Errorf("invalid authenticated payload timeout expired: % X", b)
+	}
+
+	c := h.findConnection(e.ConnectionHandle())
+	if c == nil {
+		return fmt.Errorf("authPayloadTimeoutExpired: unknown connection handle %04X", e.ConnectionHandle())
+	}
+
+	c.Warnf("authPayloadTimeoutExpired: no authenticated payload received in time")
+	if h.aptoExpired != nil {
+		go h.aptoExpired(c.RemoteAddr())
+	}
+	return nil
+}
+
+// setEventMaskPage2 unmasks the events of page 2 which are handled.
+func (h *HCI) setEventMaskPage2() error {
+	if h.aptoTimeout == 0 && h.aptoExpired == nil {
+		return nil
+	}
+	rp := cmd.SetEventMaskPage2RP{}
+	return h.Send(&cmd.SetEventMaskPage2{EventMaskPage2: evtMask2AuthPayloadTimeoutExpired}, &rp)
+}
@@ -0,0 +1,270 @@
+package hci
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/leso-kn/ble/linux/hci/cmd"
+	"github.com/leso-kn/ble/sliceops"
+)
+
+// Own address types for controller-based privacy [Vol 2, Part E, 7.8.5].
+const (
+	AddressTypeRPAOrPublic = 2 // Controller generated RPA, public address if no match in the resolving list.
+	AddressTypeRPAOrRandom = 3 // Controller generated RPA, random address if no match in the resolving list.
+)
+
+// Valid RPA timeout range [Vol 2, Part E, 7.8.45].
+const (
+	RPATimeoutMin = 0x0001 * time.Second
+	RPATimeoutMax = 0x0E10 * time.Second
+)
+
+type privacy struct {
+	localIRK   [16]byte
+	rpaTimeout time.Duration
+}
+
+// initPrivacy populates the controller's resolving list with the local IRK
+// and the identities of bonded peers, then enables address resolution.
+func (h *HCI) initPrivacy() error {
+	if h.privacy == nil {
+		return nil
+	}
+
+	err := h.Send(&cmd.LESetAddressResolutionEnable{AddressResolutionEnable: 0}, nil)
+	if err == ErrUnknownCommand {
+		return errPrivacyUnsupported
+	}
+	if err != nil {
+		return fmt.Errorf("privacy: disable address resolution: %v", err)
+	}
+	if err := h.Send(&cmd.LEClearResolvingList{}, nil); err != nil {
+		return fmt.Errorf("privacy: clear resolving list: %v", err)
+	}
+
+	to := uint16(h.privacy.rpaTimeout / time.Second)
+	if err := h.Send(&cmd.LESetResolvablePrivateAddressTimeout{RPATimeout: to}, nil); err != nil {
+		return fmt.Errorf("privacy: set rpa timeout: %v", err)
+	}
+
+	// An entry with an all-zero peer identity is used by the controller to
+	// generate the local RPA for undirected advertising and scanning.
+	if err := h.AddToResolvingList(&Identity{IRK: make([]byte, 16), Addr: make([]byte, 6)}); err != nil {
+		return fmt.Errorf("privacy: add local irk: %v", err)
+	}
+
+	if h.bondManager != nil {
+		bonds, err := h.bondManager.List()
+		if err != nil {
+			h.Warnf("privacy: list bonds: %v", err)
+		}
+		for addr, bi := range bonds {
+			id := bi.Identity()
+			if id == nil {
+				continue
+			}
+			if err := h.AddToResolvingList(id); err != nil {
+				h.Warnf("privacy: add %s to resolving list: %v", addr, err)
+			}
+		}
+	}
+
+	return h.Send(&cmd.LESetAddressResolutionEnable{AddressResolutionEnable: 1}, nil)
+}
+
+// AddToResolvingList adds a peer identity to the controller's resolving list,
+// paired with the local IRK. Privacy must be enabled with OptPrivacy.
+func (h *HCI) AddToResolvingList(id *Identity) error {
+	if h.privacy == nil {
+		return fmt.Errorf("privacy not enabled")
+	}
+	if id == nil || len(id.IRK) != 16 || len(id.Addr) != 6 {
+		return fmt.Errorf("invalid identity")
+	}
+
+	c := &cmd.LEAddDeviceToResolvingList{
+		PeerIdentityAddressType: id.AddrType,
+		LocalIRK:                h.privacy.localIRK,
+	}
+	copy(c.PeerIdentityAddress[:], sliceops.SwapBuf(id.Addr))
+	copy(c.PeerIRK[:], id.IRK)
+	return h.Send(c, nil)
+}
+
+// RemoveFromResolvingList removes a peer identity from the controller's resolving list.
+func (h *HCI) RemoveFromResolvingList(id *Identity) error {
+	if id == nil || len(id.Addr) != 6 {
+		return fmt.Errorf("invalid identity")
+	}
+
+	c := &cmd.LERemoveDeviceFromResolvingList{PeerIdentityAddressType: id.AddrType}
+	copy(c.PeerIdentityAddress[:], sliceops.SwapBuf(id.Addr))
+	return h.Send(c, nil)
+}
+
+// Peer address types of identity addresses, resolved by the controller
+// [Vol 2, Part E, 7.8.12].
+const (
+	peerAddrTypePublicIdentity = 0x02
+	peerAddrTypeRandomIdentity = 0x03
+)
+
+// dialAddr returns the address type and the address, least significant octet
+// first, to dial a, given most significant octet first, with the address
+// type pat. Bonded peers are dialed by their identity address though they
+// advertise with rotating RPAs: the controller resolves the identity if
+// privacy is enabled, else the RPA the host last resolved to it is dialed.
+func (h *HCI) dialAddr(a []byte, pat uint8) (uint8, []byte) {
+	var id [6]byte
+	copy(id[:], a)
+	if h.privacy != nil {
+		if bi := h.bondedIdentity(id); bi != nil {
+			return bi.AddrType | peerAddrTypePublicIdentity, sliceops.SwapBuf(a)
+		}
+	}
+	if h.resolver != nil {
+		if rpa, ok := h.resolver.currentRPA(id); ok {
+			h.Debugf("dial: identity %X last seen with rpa %X", id, rpa)
+			return AddressTypeRandom, sliceops.SwapBuf(rpa[:])
+		}
+	}
+	return pat, sliceops.SwapBuf(a)
+}
+
+// bondedIdentity returns the identity of the bonded peer with the identity
+// address id, given most significant octet first, or nil.
+func (h *HCI) bondedIdentity(id [6]byte) *Identity {
+	if h.bondManager == nil {
+		return nil
+	}
+	bonds, err := h.bondManager.List()
+	if err != nil {
+		h.Warnf("privacy: list bonds: %v", err)
+		return nil
+	}
+	for _, bi := range bonds {
+		if i := bi.Identity(); i != nil && bytes.Equal(i.Addr, id[:]) {
+			return i
+		}
+	}
+	return nil
+}
+
+// identityBondManager makes the identities of new bonds known to the
+// controller's resolving list and the host-side resolver.
+type identityBondManager struct {
+	BondManager
+	h *HCI
+}
+
+func (m *identityBondManager) Save(addr string, bi BondInfo) error {
+	if err := m.BondManager.Save(addr, bi); err != nil {
+		return err
+	}
+	id := bi.Identity()
+	if id == nil {
+		return nil
+	}
+	if m.h.resolver != nil {
+		m.h.resolver.add(id)
+	}
+	if m.h.privacy != nil {
+		// This fails if the controller is advertising, scanning or initiating
+		// with address resolution enabled. The bond is loaded on the next Init.
+		if err := m.h.AddToResolvingList(id); err != nil {
+			m.h.Warnf("privacy: add %s to resolving list: %v", addr, err)
+		}
+	}
+	return nil
+}
+
+// localIdentity returns the identity distributed when pairing: the local IRK,
+// which the host rotates RPAs with if the controller doesn't support privacy,
+// or all zeros if privacy isn't enabled, and the identity address, which is
+// the random static address, if any, or the public address.
+func (h *HCI) localIdentity() *Identity {
+	id := &Identity{IRK: make([]byte, 16), Addr: append([]byte{}, h.addr...)}
+	if h.privacy != nil {
+		copy(id.IRK, h.privacy.localIRK[:])
+	} else if h.rotation != nil && h.rotation.irk != nil {
+		copy(id.IRK, h.rotation.irk)
+	}
+	if h.randomAddr != nil {
+		id.Addr, id.AddrType = append([]byte{}, h.randomAddr...), 1
+	}
+	return id
+}
+
+// Import adds the identities of the imported bonds, like Save.
+func (m *identityBondManager) Import(r io.Reader) error {
+	old, err := m.BondManager.List()
+	if err != nil {
+		return err
+	}
+	if err := m.BondManager.Import(r); err != nil {
+		return err
+	}
+	bonds, err := m.BondManager.List()
+	if err != nil {
+		return err
+	}
+	for addr, bi := range bonds {
+		if _, ok := old[addr]; ok {
+			continue
+		}
+		id := bi.Identity()
+		if id == nil {
+			continue
+		}
+		if m.h.resolver != nil {
+			m.h.resolver.add(id)
+		}
+		if m.h.privacy != nil {
+			if err := m.h.AddToResolvingList(id); err != nil {
+				m.h.Warnf("privacy: add %s to resolving list: %v", addr, err)
+			}
+		}
+	}
+	return nil
+}
+
+func newLocalIRK() ([16]byte, error) {
+	var irk [16]byte
+	_, err := rand.Read(irk[:])
+	return irk, err
+}
+
+// loadLocalIRK reads a hex encoded local IRK from filename. If the file
+// doesn't exist, a new IRK is generated and stored there, readable by the
+// owner only.
+func loadLocalIRK(filename string) ([16]byte, error) {
+	var irk [16]byte
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		irk, err := newLocalIRK()
+		if err != nil {
+			return irk, err
+		}
+		if err := ioutil.WriteFile(filename, []byte(hex.EncodeToString(irk[:])+"\n"), 0600); err != nil {
+			return irk, fmt.Errorf("failed to store local irk: %v", err)
+		}
+		return irk, nil
+	}
+	if err != nil {
+		return irk, fmt.Errorf("failed to read local irk: %v", err)
+	}
+	k, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(k) != len(irk) {
+		return irk, fmt.Errorf("failed to parse local irk")
+	}
+	copy(irk[:], k)
+	return irk, nil
+}
@@ -0,0 +1,45 @@
+package hci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSetPrivacyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "irk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "irk")
+
+	// The IRK generated on the first start is the one of the next starts.
+	h := &HCI{}
+	if err := h.SetPrivacyFile(filename, 15*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	irk := h.privacy.localIRK
+	h = &HCI{}
+	if err := h.SetPrivacyFile(filename, 15*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if h.privacy.localIRK != irk {
+		t.Fatalf("irk % X, stored % X", h.privacy.localIRK, irk)
+	}
+	if fi, err := os.Stat(filename); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("irk file %v, %v", fi.Mode(), err)
+	}
+
+	if err := h.SetPrivacy(nil, 15*time.Minute); err == nil {
+		t.Fatal("privacy enabled without an irk")
+	}
+	if err := ioutil.WriteFile(filename, []byte("0011\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetPrivacyFile(filename, 15*time.Minute); err == nil {
+		t.Fatal("short irk read")
+	}
+}
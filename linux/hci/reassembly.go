@@ -0,0 +1,32 @@
+package hci
+
+// pduRing holds the buffers fragmented PDUs of a connection are reassembled
+// into, reused in turn rather than allocated for each PDU.
+//
+// A buffer is reused once len(bufs) more PDUs were reassembled, so there
+// must be more buffers than PDUs held by the consumers: those queued to the
+// reader, the one it's reading, and the one being reassembled.
+type pduRing struct {
+	bufs [][]byte
+	next int
+}
+
+func newPDURing(n int) *pduRing {
+	return &pduRing{bufs: make([][]byte, n)}
+}
+
+// get returns the next buffer, emptied, with a capacity of at least size,
+// or of the PDUs of an rx MTU of mtu, whichever is larger. Buffers grow as
+// the MTU does, when they're reused.
+func (r *pduRing) get(size, mtu int) pdu {
+	if size < 4+mtu {
+		size = 4 + mtu
+	}
+	b := r.bufs[r.next]
+	if cap(b) < size {
+		b = make([]byte, 0, size)
+		r.bufs[r.next] = b
+	}
+	r.next = (r.next + 1) % len(r.bufs)
+	return b[:0]
+}
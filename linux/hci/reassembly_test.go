@@ -0,0 +1,121 @@
+package hci
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+)
+
+func newTestConn(mtu int) *Conn {
+	c := &Conn{
+		hci:     &HCI{done: make(chan bool)},
+		rxMTU:   mtu,
+		rxMPS:   mtu,
+		chInPkt: make(chan rxPacket, 16),
+		chInPDU: make(chan rxPDU, 16),
+		Logger:  ble.GetLogger(),
+		aclLog:  ble.GetLogger(),
+	}
+	c.rxRing = newPDURing(cap(c.chInPDU) + 2)
+	go func() {
+		for c.recombine() == nil {
+		}
+		close(c.chInPDU)
+	}()
+	return c
+}
+
+// fragments returns the ACL packets of an ATT PDU carrying sdu, split into
+// fragments of up to n bytes.
+func fragments(sdu []byte, n int) []packet {
+	p := make([]byte, 4, 4+len(sdu))
+	binary.LittleEndian.PutUint16(p, uint16(len(sdu)))
+	binary.LittleEndian.PutUint16(p[2:], cidLEAtt)
+	p = append(p, sdu...)
+
+	var pkts []packet
+	pbf := pbfControllerToHostStart
+	for len(p) > 0 {
+		l := n
+		if len(p) < l {
+			l = len(p)
+		}
+		pkt := []byte{0x40, byte(pbf << 4), byte(l), byte(l >> 8)}
+		pkts = append(pkts, append(pkt, p[:l]...))
+		p = p[l:]
+		pbf = pbfContinuing
+	}
+	return pkts
+}
+
+func TestConnReassembly(t *testing.T) {
+	c := newTestConn(247)
+	defer close(c.chInPkt)
+
+	// More PDUs than buffers, each read after the next ones are queued.
+	sdus := make([][]byte, 50)
+	for i := range sdus {
+		sdus[i] = bytes.Repeat([]byte{byte(i)}, 100+i)
+	}
+	go func() {
+		for _, sdu := range sdus {
+			for _, pkt := range fragments(sdu, 27) {
+				c.chInPkt <- rxPacket{packet: pkt}
+			}
+		}
+	}()
+	b := make([]byte, 512)
+	for i, want := range sdus {
+		n, err := c.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b[:n], want) {
+			t.Fatalf("pdu %d = % X, want % X", i, b[:n], want)
+		}
+	}
+}
+
+func BenchmarkConnReassembly(b *testing.B) {
+	c := newTestConn(247)
+	defer close(c.chInPkt)
+	pkts := fragments(make([]byte, 244), 27)
+	buf := make([]byte, 512)
+
+	b.ReportAllocs()
+	b.SetBytes(244)
+	for i := 0; i < b.N; i++ {
+		for _, pkt := range pkts {
+			c.chInPkt <- rxPacket{packet: pkt}
+		}
+		if _, err := c.Read(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestConnReadTimestamped(t *testing.T) {
+	c := newTestConn(247)
+	defer close(c.chInPkt)
+
+	// The PDU is stamped with the time its first fragment was received at.
+	t0 := time.Unix(1000, 0)
+	go func() {
+		for i, pkt := range fragments(bytes.Repeat([]byte{0x5A}, 100), 27) {
+			c.chInPkt <- rxPacket{packet: pkt, at: t0.Add(time.Duration(i) * time.Millisecond)}
+		}
+		c.chInPkt <- rxPacket{packet: fragments([]byte{0x01}, 27)[0]}
+	}()
+	b := make([]byte, 512)
+	n, at, err := c.ReadTimestamped(b)
+	if err != nil || n != 100 || !at.Equal(t0) {
+		t.Fatalf("read %d bytes stamped at %v, %v", n, at, err)
+	}
+	// Unstamped packets, with timestamps disabled, give the zero time.
+	if n, at, err := c.ReadTimestamped(b); err != nil || n != 1 || !at.IsZero() {
+		t.Fatalf("read %d bytes stamped at %v, %v", n, at, err)
+	}
+}
@@ -0,0 +1,136 @@
+package hci
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci/cmd"
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+// remoteInfoTimeout bounds the wait for the remote features and version.
+const remoteInfoTimeout = 10 * time.Second
+
+// remoteInfo holds the features and version information read from the remote
+// device after the connection is established. Each result is set once, by
+// either the event loop or readRemoteInfo, whichever reports first.
+type remoteInfo struct {
+	onceFeatures sync.Once
+	chFeatures   chan struct{} // Closed when features or featuresErr is set.
+	features     uint64
+	featuresErr  error
+
+	onceVersion sync.Once
+	chVersion   chan struct{} // Closed when version or versionErr is set.
+	version     ble.RemoteVersion
+	versionErr  error
+}
+
+func newRemoteInfo() remoteInfo {
+	return remoteInfo{
+		chFeatures: make(chan struct{}),
+		chVersion:  make(chan struct{}),
+	}
+}
+
+// readRemoteInfo asks the controller for the remote features and version. The
+// results are delivered by the corresponding complete events.
+func (c *Conn) readRemoteInfo() {
+	h := c.param.ConnectionHandle()
+	if err := c.hci.Send(&cmd.LEReadRemoteUsedFeatures{ConnectionHandle: h}, nil); err != nil {
+		c.setRemoteFeatures(0, fmt.Errorf("read remote features: %v", err))
+	}
+	if err := c.hci.Send(&cmd.ReadRemoteVersionInformation{ConnectionHandle: h}, nil); err != nil {
+		c.setRemoteVersion(ble.RemoteVersion{}, fmt.Errorf("read remote version: %v", err))
+	}
+}
+
+// setRemoteFeatures sets the remote features, unless they're already set; a
+// later features exchange doesn't change the result.
+func (c *Conn) setRemoteFeatures(f uint64, err error) {
+	c.remote.onceFeatures.Do(func() {
+		c.remote.features, c.remote.featuresErr = f, err
+		close(c.remote.chFeatures)
+	})
+}
+
+// setRemoteVersion sets the remote version, unless it's already set.
+func (c *Conn) setRemoteVersion(v ble.RemoteVersion, err error) {
+	c.remote.onceVersion.Do(func() {
+		c.remote.version, c.remote.versionErr = v, err
+		close(c.remote.chVersion)
+	})
+}
+
+// RemoteFeatures returns the LE features supported by the remote device. It
+// waits until they are read, if the procedure is still in progress.
+func (c *Conn) RemoteFeatures() (uint64, error) {
+	select {
+	case <-c.remote.chFeatures:
+		return c.remote.features, c.remote.featuresErr
+	case <-c.chDone:
+		return 0, fmt.Errorf("disconnected")
+	case <-time.After(remoteInfoTimeout):
+		return 0, fmt.Errorf("read remote features timed out")
+	}
+}
+
+// RemoteVersion returns the link layer version information of the remote
+// device. It waits until it is read, if the procedure is still in progress.
+func (c *Conn) RemoteVersion() (ble.RemoteVersion, error) {
+	select {
+	case <-c.remote.chVersion:
+		return c.remote.version, c.remote.versionErr
+	case <-c.chDone:
+		return ble.RemoteVersion{}, fmt.Errorf("disconnected")
+	case <-time.After(remoteInfoTimeout):
+		return ble.RemoteVersion{}, fmt.Errorf("read remote version timed out")
+	}
+}
+
+func (h *HCI) handleLEReadRemoteUsedFeaturesComplete(b []byte) error {
+	e := evt.LEReadRemoteUsedFeaturesComplete(b)
+	if len(e) < 12 {
+		return fmt.Errorf("invalid read remote features complete: % X", b)
+	}
+
+	c := h.findConnection(e.ConnectionHandle())
+	if c == nil {
+		return fmt.Errorf("readRemoteFeatures: unknown connection handle %04X", e.ConnectionHandle())
+	}
+
+	if status := e.Status(); status != 0 {
+		c.setRemoteFeatures(0, fmt.Errorf("read remote features: %v", ErrCommand(status)))
+		return nil
+	}
+	c.Debugf("readRemoteFeatures: %016X", e.LEFeatures())
+	c.setRemoteFeatures(e.LEFeatures(), nil)
+	return nil
+}
+
+func (h *HCI) handleReadRemoteVersionInformationComplete(b []byte) error {
+	e := evt.ReadRemoteVersionInformationComplete(b)
+	if len(e) < 8 {
+		return fmt.Errorf("invalid read remote version complete: % X", b)
+	}
+
+	c := h.findConnection(e.ConnectionHandle())
+	if c == nil {
+		return fmt.Errorf("readRemoteVersion: unknown connection handle %04X", e.ConnectionHandle())
+	}
+
+	if status := e.Status(); status != 0 {
+		c.setRemoteVersion(ble.RemoteVersion{}, fmt.Errorf("read remote version: %v", ErrCommand(status)))
+		return nil
+	}
+	v := ble.RemoteVersion{
+		Version:    e.Version(),
+		CompanyID:  e.ManufacturerName(),
+		Subversion: e.Subversion(),
+	}
+	c.Debugf("readRemoteVersion: %+v", v)
+	c.setRemoteVersion(v, nil)
+	return nil
+}
@@ -0,0 +1,57 @@
+package hci_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux/hci"
+)
+
+func TestRemoteInfo(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	defer cln.CancelConnection()
+
+	// The virtual controllers support LE Encryption, and Core 5.0.
+	if f, err := cln.Conn().RemoteFeatures(); err != nil || f != 0x01 {
+		t.Fatalf("features %016X, %v", f, err)
+	}
+	v, err := cln.Conn().RemoteVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != 0x09 || v.CompanyID != 0x05F1 {
+		t.Fatalf("version %+v", v)
+	}
+}
+
+func TestRemoteInfoStatus(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	pair.CentralController.SetRemoteInfoStatus(uint8(hci.ErrUnsupportedLMP))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	defer cln.CancelConnection()
+
+	want := hci.ErrUnsupportedLMP.Error()
+	if _, err := cln.Conn().RemoteFeatures(); err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("features: %v", err)
+	}
+	if _, err := cln.Conn().RemoteVersion(); err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("version: %v", err)
+	}
+	// The connection survives the failed reads.
+	if _, err := cln.DiscoverServices([]ble.UUID{ble.GAPUUID}); err != nil {
+		t.Fatal(err)
+	}
+}
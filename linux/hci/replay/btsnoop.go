@@ -0,0 +1,186 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	pktTypeCommand = 0x01
+	pktTypeACLData = 0x02
+	pktTypeEvent   = 0x04
+
+	// datalinkH4 is the btsnoop datalink of packets with an H4 type octet.
+	datalinkH4 = 1002
+	// datalinkMonitor is the datalink of btmon, which has the packet type
+	// and controller index in the flags.
+	datalinkMonitor = 2001
+
+	flagReceived = 0x01 // Sent by the controller.
+	flagCommand  = 0x02 // A command or event.
+
+	// epochDelta is the Unix epoch in btsnoop time, microseconds since
+	// midnight January 1st, 0 AD.
+	epochDelta = 0x00DCDDB30F2F8000
+)
+
+var btsnoopMagic = []byte("btsnoop\x00")
+
+// Direction is the direction of a recorded packet.
+type Direction int
+
+const (
+	// HostToController packets are commands and data sent by the host.
+	HostToController Direction = iota
+	// ControllerToHost packets are events and data sent by the controller.
+	ControllerToHost
+)
+
+func (d Direction) String() string {
+	if d == HostToController {
+		return "host > controller"
+	}
+	return "controller > host"
+}
+
+// Packet is a recorded HCI packet, starting with its H4 type octet.
+type Packet struct {
+	Time time.Time
+	Dir  Direction
+	Data []byte
+}
+
+func writeHeader(w io.Writer) error {
+	h := make([]byte, 16)
+	copy(h, btsnoopMagic)
+	binary.BigEndian.PutUint32(h[8:], 1)
+	binary.BigEndian.PutUint32(h[12:], datalinkH4)
+	_, err := w.Write(h)
+	return err
+}
+
+func writePacket(w io.Writer, p Packet) error {
+	flags := uint32(0)
+	if p.Dir == ControllerToHost {
+		flags |= flagReceived
+	}
+	if len(p.Data) > 0 && (p.Data[0] == pktTypeCommand || p.Data[0] == pktTypeEvent) {
+		flags |= flagCommand
+	}
+	h := make([]byte, 24)
+	binary.BigEndian.PutUint32(h[0:], uint32(len(p.Data)))
+	binary.BigEndian.PutUint32(h[4:], uint32(len(p.Data)))
+	binary.BigEndian.PutUint32(h[8:], flags)
+	binary.BigEndian.PutUint64(h[16:], uint64(p.Time.UnixNano()/1000+epochDelta))
+	_, err := w.Write(append(h, p.Data...))
+	return err
+}
+
+// monitorTypes maps the btmon opcodes of HCI packets to their H4 type and
+// direction.
+var monitorTypes = map[uint16]struct {
+	typ byte
+	dir Direction
+}{
+	2:  {pktTypeCommand, HostToController},
+	3:  {pktTypeEvent, ControllerToHost},
+	4:  {pktTypeACLData, HostToController},
+	5:  {pktTypeACLData, ControllerToHost},
+	6:  {0x03, HostToController},
+	7:  {0x03, ControllerToHost},
+	18: {0x05, HostToController},
+	19: {0x05, ControllerToHost},
+}
+
+// ReadTrace reads the packets of a btsnoop file, as written by a Recorder,
+// btmon -w or Android's HCI snoop log. Of a btmon trace, the packets of the
+// first controller are read.
+func ReadTrace(r io.Reader) ([]Packet, error) {
+	tr, err := NewTraceReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var pkts []Packet
+	for {
+		p, err := tr.Next()
+		if err == io.EOF {
+			return pkts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		pkts = append(pkts, p)
+	}
+}
+
+// TraceReader reads the packets of a btsnoop stream one at a time, e.g. of
+// a live capture served by a Tap.
+type TraceReader struct {
+	r     io.Reader
+	dl    uint32
+	index int // Controller index of a btmon trace, or -1 until known.
+	n     int // Records read.
+}
+
+// NewTraceReader reads the header of the btsnoop stream r, and returns a
+// reader of its packets.
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	h := make([]byte, 16)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil, fmt.Errorf("can't read header: %v", err)
+	}
+	if !bytes.Equal(h[:8], btsnoopMagic) {
+		return nil, fmt.Errorf("not a btsnoop file")
+	}
+	dl := binary.BigEndian.Uint32(h[12:])
+	if dl != datalinkH4 && dl != datalinkMonitor {
+		return nil, fmt.Errorf("unsupported datalink %d", dl)
+	}
+	return &TraceReader{r: r, dl: dl, index: -1}, nil
+}
+
+// Next returns the next packet. It returns io.EOF at the end of the stream.
+func (t *TraceReader) Next() (Packet, error) {
+	for {
+		rh := make([]byte, 24)
+		_, err := io.ReadFull(t.r, rh)
+		if err == io.EOF {
+			return Packet{}, io.EOF
+		}
+		if err != nil {
+			return Packet{}, fmt.Errorf("truncated record %d", t.n)
+		}
+		incl := binary.BigEndian.Uint32(rh[4:])
+		flags := binary.BigEndian.Uint32(rh[8:])
+		ts := int64(binary.BigEndian.Uint64(rh[16:])) - epochDelta
+
+		b := make([]byte, incl)
+		if _, err := io.ReadFull(t.r, b); err != nil {
+			return Packet{}, fmt.Errorf("truncated record %d", t.n)
+		}
+		t.n++
+		p := Packet{
+			Time: time.Unix(0, ts*1000),
+			Dir:  HostToController,
+			Data: b,
+		}
+		if t.dl == datalinkMonitor {
+			mt, ok := monitorTypes[uint16(flags)]
+			if !ok || (t.index != -1 && t.index != int(flags>>16)) {
+				continue
+			}
+			t.index = int(flags >> 16)
+			p.Dir = mt.dir
+			p.Data = append([]byte{mt.typ}, b...)
+		} else if flags&flagReceived != 0 {
+			p.Dir = ControllerToHost
+		}
+		if len(p.Data) == 0 {
+			continue
+		}
+		return p, nil
+	}
+}
@@ -0,0 +1,48 @@
+package replay
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// linkTypeH4WithPHDR is the pcap link type of H4 packets preceded by a
+// 4-octet direction, LINKTYPE_BLUETOOTH_HCI_H4_WITH_PHDR.
+const linkTypeH4WithPHDR = 201
+
+// PcapWriter writes packets to a pcap stream, the format Wireshark reads
+// from extcap pipes.
+type PcapWriter struct {
+	w io.Writer
+}
+
+// NewPcapWriter writes the pcap header to w, and returns a writer of
+// packets to it.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	h := make([]byte, 24)
+	binary.LittleEndian.PutUint32(h[0:], 0xA1B2C3D4)
+	binary.LittleEndian.PutUint16(h[4:], 2)
+	binary.LittleEndian.PutUint16(h[6:], 4)
+	binary.LittleEndian.PutUint32(h[16:], 65535)
+	binary.LittleEndian.PutUint32(h[20:], linkTypeH4WithPHDR)
+	if _, err := w.Write(h); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w}, nil
+}
+
+// WritePacket writes the packet p, in a single write.
+func (pw *PcapWriter) WritePacket(p Packet) error {
+	n := uint32(4 + len(p.Data))
+	h := make([]byte, 20, 20+n)
+	us := p.Time.UnixNano() / 1000
+	binary.LittleEndian.PutUint32(h[0:], uint32(us/1000000))
+	binary.LittleEndian.PutUint32(h[4:], uint32(us%1000000))
+	binary.LittleEndian.PutUint32(h[8:], n)
+	binary.LittleEndian.PutUint32(h[12:], n)
+	// The direction is big endian: 0 sent by the host, 1 received.
+	if p.Dir == ControllerToHost {
+		h[19] = 1
+	}
+	_, err := pw.w.Write(append(h, p.Data...))
+	return err
+}
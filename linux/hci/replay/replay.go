@@ -0,0 +1,236 @@
+// Package replay records HCI sessions to btsnoop files, and replays them as
+// the controller side of a transport.
+//
+// A trace captured in the field, with a Recorder, btmon -w or Android's HCI
+// snoop log, can be replayed against the host with ble.OptTransportVirtual to
+// reproduce parser and state machine bugs in a regression test.
+//
+// A Tap serves the recording live, e.g. to Wireshark with cmd/bleextcap,
+// which converts it to pcap with a PcapWriter.
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Recorder wraps a transport, and writes the packets passing through it to
+// a btsnoop file.
+type Recorder struct {
+	rwc io.ReadWriteCloser
+
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewRecorder returns a Recorder of the transport rwc, writing to w.
+func NewRecorder(rwc io.ReadWriteCloser, w io.Writer) (*Recorder, error) {
+	if err := writeHeader(w); err != nil {
+		return nil, err
+	}
+	return &Recorder{rwc: rwc, w: w}, nil
+}
+
+// Read reads a packet from the controller.
+func (r *Recorder) Read(b []byte) (int, error) {
+	n, err := r.rwc.Read(b)
+	if n > 0 {
+		r.record(ControllerToHost, b[:n])
+	}
+	return n, err
+}
+
+// Write writes a packet to the controller.
+func (r *Recorder) Write(b []byte) (int, error) {
+	n, err := r.rwc.Write(b)
+	if n > 0 {
+		r.record(HostToController, b[:n])
+	}
+	return n, err
+}
+
+// Close closes the transport.
+func (r *Recorder) Close() error {
+	return r.rwc.Close()
+}
+
+// Err returns the first error writing the trace. Recording stops on error,
+// while the transport keeps working.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(d Direction, b []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	p := Packet{Time: time.Now(), Dir: d, Data: b}
+	r.err = writePacket(r.w, p)
+}
+
+// MatchFunc reports whether the host sent the packet got where the trace has
+// want.
+type MatchFunc func(want, got []byte) bool
+
+// MatchHeader matches commands by opcode, and data by packet type and
+// connection handle. Parameters and payloads may differ, e.g. by random
+// addresses or keys.
+func MatchHeader(want, got []byte) bool {
+	if len(want) == 0 || len(got) == 0 || want[0] != got[0] {
+		return false
+	}
+	switch want[0] {
+	case pktTypeCommand:
+		return len(want) >= 3 && len(got) >= 3 && bytes.Equal(want[1:3], got[1:3])
+	default:
+		return len(want) >= 3 && len(got) >= 3 &&
+			binary.LittleEndian.Uint16(want[1:])&0x0FFF == binary.LittleEndian.Uint16(got[1:])&0x0FFF
+	}
+}
+
+// MatchExact matches identical packets.
+func MatchExact(want, got []byte) bool {
+	return bytes.Equal(want, got)
+}
+
+// Replayer plays the controller side of a trace. Its host is checked to send
+// the recorded packets, and the controller's packets are returned in order,
+// each once the host has sent the packets recorded before it. Timing isn't
+// replayed, so a replay is deterministic.
+type Replayer struct {
+	pkts  []Packet
+	match MatchFunc
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	nextHost int // Index of the next packet the host has to send.
+	nextCtrl int // Index of the next packet to return.
+	err      error
+	closed   bool
+	done     chan struct{}
+}
+
+// NewReplayer returns a Replayer of the packets, usually read with
+// ReadTrace. The host's packets are checked with match, or MatchHeader if
+// it's nil.
+func NewReplayer(pkts []Packet, match MatchFunc) *Replayer {
+	if match == nil {
+		match = MatchHeader
+	}
+	r := &Replayer{
+		pkts:  pkts,
+		match: match,
+		done:  make(chan struct{}),
+	}
+	r.cond = sync.NewCond(&r.mu)
+	r.nextHost = r.next(0, HostToController)
+	r.nextCtrl = r.next(0, ControllerToHost)
+	r.checkDone()
+	return r
+}
+
+// Read returns the next packet of the controller. It blocks until the host
+// has caught up with the trace. At the end of the trace, or after a mismatch,
+// it blocks until the Replayer is closed, leaving the host's state for
+// inspection.
+func (r *Replayer) Read(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for !r.closed && (r.err != nil || r.nextCtrl == len(r.pkts) || r.nextHost < r.nextCtrl) {
+		r.cond.Wait()
+	}
+	if r.closed {
+		return 0, io.EOF
+	}
+	p := r.pkts[r.nextCtrl].Data
+	if len(b) < len(p) {
+		return 0, io.ErrShortBuffer
+	}
+	r.nextCtrl = r.next(r.nextCtrl+1, ControllerToHost)
+	r.checkDone()
+	return copy(b, p), nil
+}
+
+// Write checks a packet of the host against the trace.
+func (r *Replayer) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.closed:
+		return 0, io.ErrClosedPipe
+	case r.err != nil:
+		return 0, r.err
+	case r.nextHost == len(r.pkts):
+		r.fail(fmt.Errorf("unexpected packet % X after the end of the trace", b))
+		return 0, r.err
+	}
+	want := r.pkts[r.nextHost].Data
+	if !r.match(want, b) {
+		r.fail(fmt.Errorf("packet %d: want % X, got % X", r.nextHost, want, b))
+		return 0, r.err
+	}
+	r.nextHost = r.next(r.nextHost+1, HostToController)
+	r.checkDone()
+	r.cond.Broadcast()
+	return len(b), nil
+}
+
+// Close ends the replay; Read returns io.EOF.
+func (r *Replayer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.cond.Broadcast()
+	return nil
+}
+
+// Done is closed when the whole trace has been replayed, or on a mismatch.
+func (r *Replayer) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns the mismatch which stopped the replay, if any.
+func (r *Replayer) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// next returns the index of the first packet in direction d from i on.
+func (r *Replayer) next(i int, d Direction) int {
+	for ; i < len(r.pkts); i++ {
+		if r.pkts[i].Dir == d {
+			return i
+		}
+	}
+	return len(r.pkts)
+}
+
+func (r *Replayer) fail(err error) {
+	r.err = err
+	r.finish()
+	r.cond.Broadcast()
+}
+
+func (r *Replayer) checkDone() {
+	if r.nextHost == len(r.pkts) && r.nextCtrl == len(r.pkts) {
+		r.finish()
+	}
+}
+
+func (r *Replayer) finish() {
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+}
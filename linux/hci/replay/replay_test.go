@@ -0,0 +1,201 @@
+package replay_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/hci/replay"
+	"github.com/leso-kn/ble/linux/hci/virtual"
+)
+
+func TestReplayerOrder(t *testing.T) {
+	reset := []byte{0x01, 0x03, 0x0C, 0x00}
+	pkts := []replay.Packet{
+		{Dir: replay.HostToController, Data: reset},
+		{Dir: replay.ControllerToHost, Data: []byte{0x04, 0x0E, 0x04, 0x01, 0x03, 0x0C, 0x00}},
+	}
+	r := replay.NewReplayer(pkts, nil)
+	defer r.Close()
+
+	read := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, 64)
+		n, _ := r.Read(b)
+		read <- b[:n]
+	}()
+	select {
+	case <-read:
+		t.Fatal("event returned before its command")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if _, err := r.Write(reset); err != nil {
+		t.Fatal(err)
+	}
+	if b := <-read; !bytes.Equal(b, pkts[1].Data) {
+		t.Errorf("read % X, want % X", b, pkts[1].Data)
+	}
+	select {
+	case <-r.Done():
+	case <-time.After(time.Second):
+		t.Fatal("replay not done")
+	}
+	if _, err := r.Write(reset); err == nil || r.Err() == nil {
+		t.Error("packet after the end of the trace accepted")
+	}
+}
+
+func TestReplayerMismatch(t *testing.T) {
+	pkts := []replay.Packet{
+		{Dir: replay.HostToController, Data: []byte{0x01, 0x03, 0x0C, 0x00}},
+	}
+	r := replay.NewReplayer(pkts, nil)
+	if _, err := r.Write([]byte{0x01, 0x01, 0x10, 0x00}); err == nil {
+		t.Fatal("mismatched command accepted")
+	}
+	<-r.Done()
+	if r.Err() == nil {
+		t.Error("no mismatch reported")
+	}
+}
+
+// scanFor scans with d until an advertisement named name is found.
+func scanFor(t *testing.T, d ble.Device, name string) ble.Advertisement {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	found := make(chan ble.Advertisement, 1)
+	err := d.Scan(ctx, false, func(a ble.Advertisement) {
+		if a.LocalName() == name {
+			select {
+			case found <- a:
+				cancel()
+			default:
+			}
+		}
+	})
+	select {
+	case a := <-found:
+		return a
+	default:
+		t.Fatalf("%s not found: %v", name, err)
+		return nil
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	air := virtual.NewAir()
+	pc, err := air.NewController("11:22:33:44:55:66")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := air.NewController("AA:BB:CC:DD:EE:FF")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := linux.NewDevice(ble.OptTransportVirtual(pc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+
+	// Record a scan.
+	trace := &bytes.Buffer{}
+	s, err := linux.NewDevice(ble.OptTransportVirtual(sc), ble.OptTransportRecord(trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := scanFor(t, s, "Gopher")
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay it without the peripheral.
+	pkts, err := replay.ReadTrace(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := replay.NewReplayer(pkts, nil)
+	d, err := linux.NewDevice(ble.OptTransportVirtual(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	got := scanFor(t, d, "Gopher")
+	if got.Addr().String() != want.Addr().String() {
+		t.Errorf("replayed %v, want %v", got.Addr(), want.Addr())
+	}
+	if err := r.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+// controller accepts the host's packets, and returns resp to reads.
+type controller struct{ resp []byte }
+
+func (c *controller) Read(b []byte) (int, error)  { return copy(b, c.resp), nil }
+func (c *controller) Write(b []byte) (int, error) { return len(b), nil }
+func (c *controller) Close() error                { return nil }
+
+func TestTap(t *testing.T) {
+	tap, err := replay.ListenTap("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tap.Close()
+	c, err := net.Dial("tcp", tap.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// The header is sent once the client is registered.
+	tr, err := replay.NewTraceReader(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reset := []byte{0x01, 0x03, 0x0C, 0x00}
+	complete := []byte{0x04, 0x0E, 0x04, 0x01, 0x03, 0x0C, 0x00}
+	r, err := replay.NewRecorder(&controller{complete}, tap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write(reset)
+	r.Read(make([]byte, 16))
+
+	for _, want := range []replay.Packet{
+		{Dir: replay.HostToController, Data: reset},
+		{Dir: replay.ControllerToHost, Data: complete},
+	} {
+		p, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Dir != want.Dir || !bytes.Equal(p.Data, want.Data) {
+			t.Fatalf("read %v % X, want %v % X", p.Dir, p.Data, want.Dir, want.Data)
+		}
+	}
+
+	// The pcap record has the direction before the packet.
+	b := &bytes.Buffer{}
+	pw, err := replay.NewPcapWriter(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw.WritePacket(replay.Packet{Dir: replay.ControllerToHost, Data: complete})
+	if b.Len() != 24+16+4+len(complete) || binary.LittleEndian.Uint32(b.Bytes()[20:]) != 201 {
+		t.Fatalf("pcap % X", b.Bytes())
+	}
+	if d := binary.BigEndian.Uint32(b.Bytes()[40:]); d != 1 {
+		t.Fatalf("direction %d", d)
+	}
+}
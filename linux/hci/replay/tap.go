@@ -0,0 +1,129 @@
+package replay
+
+import (
+	"bytes"
+	"net"
+	"sync"
+)
+
+// tapBacklog is the number of records buffered for a client of a Tap. A
+// client which falls behind further is disconnected, rather than stalling
+// the transport.
+const tapBacklog = 1024
+
+// Tap serves a live btsnoop capture of a session to the clients of a
+// listener, such as cmd/bleextcap, which opens it in Wireshark. It's the
+// writer of a Recorder, e.g. with ble.OptTransportRecord. Each client
+// receives a btsnoop header, then the packets recorded from then on.
+type Tap struct {
+	l net.Listener
+
+	mu      sync.Mutex
+	clients map[net.Conn]chan []byte
+	closed  bool
+}
+
+// ListenTap returns a Tap serving on the TCP address addr.
+func ListenTap(addr string) (*Tap, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewTap(l), nil
+}
+
+// NewTap returns a Tap serving the clients of l.
+func NewTap(l net.Listener) *Tap {
+	t := &Tap{l: l, clients: make(map[net.Conn]chan []byte)}
+	go t.loop()
+	return t
+}
+
+// Addr returns the address the Tap serves on.
+func (t *Tap) Addr() net.Addr {
+	return t.l.Addr()
+}
+
+func (t *Tap) loop() {
+	for {
+		c, err := t.l.Accept()
+		if err != nil {
+			return
+		}
+		ch := make(chan []byte, tapBacklog)
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			c.Close()
+			return
+		}
+		t.clients[c] = ch
+		t.mu.Unlock()
+		go t.serve(c, ch)
+	}
+}
+
+// serve writes the header and the records to the client c, until it fails
+// or is dropped.
+func (t *Tap) serve(c net.Conn, ch chan []byte) {
+	defer t.drop(c)
+	if err := writeHeader(c); err != nil {
+		return
+	}
+	for b := range ch {
+		if _, err := c.Write(b); err != nil {
+			return
+		}
+	}
+}
+
+// drop disconnects the client c.
+func (t *Tap) drop(c net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ch, ok := t.clients[c]; ok {
+		close(ch)
+		delete(t.clients, c)
+	}
+	c.Close()
+}
+
+// Write sends the btsnoop records b to the clients. The header written by
+// a Recorder is skipped, as each client gets its own. Write never fails, so
+// that recording goes on while no client is connected.
+func (t *Tap) Write(b []byte) (int, error) {
+	if bytes.HasPrefix(b, btsnoopMagic) {
+		return len(b), nil
+	}
+	r := append([]byte(nil), b...)
+	t.mu.Lock()
+	var slow []net.Conn
+	for c, ch := range t.clients {
+		select {
+		case ch <- r:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	t.mu.Unlock()
+	for _, c := range slow {
+		t.drop(c)
+	}
+	return len(b), nil
+}
+
+// Close stops serving, and disconnects the clients.
+func (t *Tap) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	var cs []net.Conn
+	for c := range t.clients {
+		cs = append(cs, c)
+	}
+	t.mu.Unlock()
+	err := t.l.Close()
+	for _, c := range cs {
+		t.drop(c)
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package hci
+
+import (
+	"bytes"
+	"crypto/aes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/leso-kn/ble/sliceops"
+)
+
+// maxResolvedCache bounds the number of RPAs remembered by the resolver.
+const maxResolvedCache = 256
+
+// rpaMaxAge is how long an observed RPA is taken as current: the default RPA
+// timeout [Vol 3, Part C, Appendix A].
+const rpaMaxAge = 15 * time.Minute
+
+// resolver resolves the private addresses of advertisers on the host, using
+// the identities of bonded peers [Vol 3, Part C, 10.8.2.3].
+type resolver struct {
+	sync.Mutex
+
+	ids []*Identity
+
+	// cache maps RPAs, which are reused until rotated, to their identity.
+	// Unresolvable addresses are cached as nil.
+	cache map[[6]byte]*Identity
+
+	// current maps identity addresses to the RPA they were last seen with.
+	current map[[6]byte]observedRPA
+}
+
+type observedRPA struct {
+	addr [6]byte
+	at   time.Time
+}
+
+// load replaces the known identities with those of the bond store.
+func (r *resolver) load(bm BondManager) error {
+	if bm == nil {
+		return fmt.Errorf("security not enabled")
+	}
+	bonds, err := bm.List()
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.ids = r.ids[:0]
+	for _, bi := range bonds {
+		if id := bi.Identity(); id != nil {
+			r.ids = append(r.ids, id)
+		}
+	}
+	r.cache = nil
+	return nil
+}
+
+// add adds a single identity, e.g. after a new bond was saved.
+func (r *resolver) add(id *Identity) {
+	r.Lock()
+	defer r.Unlock()
+	r.ids = append(r.ids, id)
+	r.cache = nil
+}
+
+// resolve returns the identity of a resolvable private address, given most
+// significant octet first, or nil if it can't be resolved.
+func (r *resolver) resolve(a [6]byte) *Identity {
+	if !isRPA(a) {
+		return nil
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	found, ok := r.cache[a]
+	if !ok {
+		for _, id := range r.ids {
+			if ok, _ := resolveRPA(id.IRK, a); ok {
+				found = id
+				break
+			}
+		}
+		if r.cache == nil || len(r.cache) >= maxResolvedCache {
+			r.cache = make(map[[6]byte]*Identity)
+		}
+		r.cache[a] = found
+	}
+
+	if found != nil && len(found.Addr) == 6 {
+		if r.current == nil {
+			r.current = make(map[[6]byte]observedRPA)
+		}
+		var k [6]byte
+		copy(k[:], found.Addr)
+		r.current[k] = observedRPA{addr: a, at: time.Now()}
+	}
+	return found
+}
+
+// currentRPA returns the RPA the peer of the identity address id, given most
+// significant octet first, was last seen with, unless it's likely rotated.
+func (r *resolver) currentRPA(id [6]byte) ([6]byte, bool) {
+	r.Lock()
+	defer r.Unlock()
+	o, ok := r.current[id]
+	if !ok || time.Since(o.at) > rpaMaxAge {
+		return [6]byte{}, false
+	}
+	return o.addr, true
+}
+
+// isRPA reports whether a random address, given most significant octet
+// first, is a resolvable private address [Vol 6, Part B, 1.3.2.2].
+func isRPA(a [6]byte) bool {
+	return a[0]&0xC0 == 0x40
+}
+
+// resolveRPA checks a resolvable private address, given most significant
+// octet first, against an IRK, given least significant octet first.
+func resolveRPA(irk []byte, a [6]byte) (bool, error) {
+	// prand is the upper and hash the lower 24 bits of the address.
+	prand := []byte{a[2], a[1], a[0]}
+	hash := []byte{a[5], a[4], a[3]}
+
+	h, err := ah(irk, prand)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(h, hash), nil
+}
+
+// ah is the random address hash function [Vol 3, Part H, 2.2.2].
+// The key, r and the result are least significant octet first.
+func ah(k []byte, r []byte) ([]byte, error) {
+	if len(k) != 16 || len(r) != 3 {
+		return nil, fmt.Errorf("ah: invalid length k %v r %v", len(k), len(r))
+	}
+
+	c, err := aes.NewCipher(sliceops.SwapBuf(k))
+	if err != nil {
+		return nil, err
+	}
+
+	// r' = padding || r
+	rp := make([]byte, 16)
+	copy(rp[13:], sliceops.SwapBuf(r))
+
+	out := make([]byte, 16)
+	c.Encrypt(out, rp)
+	return sliceops.SwapBuf(out[13:]), nil
+}
@@ -0,0 +1,70 @@
+package hci
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/sliceops"
+)
+
+// Sample data from [Vol 3, Part H, Appendix D.7].
+var (
+	testIRK   = sliceops.SwapBuf([]byte{0xec, 0x02, 0x34, 0xa3, 0x57, 0xc8, 0xad, 0x05, 0x34, 0x10, 0x10, 0xa6, 0x0a, 0x39, 0x7d, 0x9b})
+	testPrand = sliceops.SwapBuf([]byte{0x70, 0x81, 0x94})
+	testHash  = sliceops.SwapBuf([]byte{0x0d, 0xfb, 0xaa})
+)
+
+func TestAh(t *testing.T) {
+	h, err := ah(testIRK, testPrand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h, testHash) {
+		t.Fatalf("ah mismatch: exp %x got %x", testHash, h)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	id := &Identity{IRK: testIRK, Addr: []byte{0xc0, 1, 2, 3, 4, 5}, AddrType: 1}
+	r := &resolver{}
+	r.add(id)
+
+	rpa := [6]byte{0x70, 0x81, 0x94, 0x0d, 0xfb, 0xaa}
+	if got := r.resolve(rpa); got != id {
+		t.Fatalf("rpa not resolved")
+	}
+
+	other := [6]byte{0x70, 0x81, 0x94, 0x0d, 0xfb, 0xab}
+	if got := r.resolve(other); got != nil {
+		t.Fatalf("unexpected resolution of %x", other)
+	}
+
+	static := [6]byte{0xc0, 1, 2, 3, 4, 5}
+	if got := r.resolve(static); got != nil {
+		t.Fatalf("static address resolved")
+	}
+}
+
+func TestDialAddrRPA(t *testing.T) {
+	id := &Identity{IRK: testIRK, Addr: []byte{0xc0, 1, 2, 3, 4, 5}, AddrType: 1}
+	h := &HCI{Logger: ble.GetLogger(), resolver: &resolver{}}
+	h.resolver.add(id)
+
+	// Not seen yet, the identity is dialed as is.
+	if pat, a := h.dialAddr(id.Addr, 1); pat != 1 || !bytes.Equal(a, sliceops.SwapBuf(id.Addr)) {
+		t.Fatalf("dial %d %X", pat, a)
+	}
+
+	rpa := [6]byte{0x70, 0x81, 0x94, 0x0d, 0xfb, 0xaa}
+	h.resolver.resolve(rpa)
+	if pat, a := h.dialAddr(id.Addr, 1); pat != AddressTypeRandom || !bytes.Equal(a, sliceops.SwapBuf(rpa[:])) {
+		t.Fatalf("dial %d %X, want the rpa", pat, a)
+	}
+
+	// Others are dialed as is.
+	other := []byte{0xc0, 1, 2, 3, 4, 6}
+	if pat, a := h.dialAddr(other, 1); pat != 1 || !bytes.Equal(a, sliceops.SwapBuf(other)) {
+		t.Fatalf("dial %d %X", pat, a)
+	}
+}
@@ -0,0 +1,240 @@
+package hci
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci/cmd"
+	"github.com/leso-kn/ble/sliceops"
+)
+
+// rotationRetry is the delay after which a rotation held back by a Dial is
+// tried again.
+const rotationRetry = time.Second
+
+// errPrivacyUnsupported is returned by initPrivacy if the controller doesn't
+// support controller-based privacy.
+var errPrivacyUnsupported = errors.New("privacy not supported by the controller")
+
+// addrRotation rotates the private addresses every period. Unless the
+// controller rotates its RPAs, the host rotates the random address of the
+// local device: to RPAs made from irk, if set, else to non-resolvable private
+// addresses.
+type addrRotation struct {
+	period time.Duration
+	irk    []byte
+
+	// addr is the random address the host set last, or nil.
+	mu   sync.Mutex
+	addr net.HardwareAddr
+}
+
+// newAddr returns a new private address, an RPA if irk is set, or else a
+// non-resolvable private address.
+func (r *addrRotation) newAddr(rpa bool) (net.HardwareAddr, error) {
+	if rpa && r.irk != nil {
+		return NewResolvableAddr(r.irk)
+	}
+	return NewNonResolvableAddr()
+}
+
+// current returns the random address the host set last, or nil.
+func (r *addrRotation) current() net.HardwareAddr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addr
+}
+
+// hostRotation reports whether the host rotates the random address of the
+// local device, rather than the controller.
+func (h *HCI) hostRotation() bool {
+	return h.rotation != nil && h.privacy == nil
+}
+
+// initRotation sets the first private address of the local device, if the
+// host rotates it, and starts the rotation.
+func (h *HCI) initRotation() error {
+	r := h.rotation
+	if r == nil {
+		return nil
+	}
+	if h.privacy != nil {
+		r.irk = append([]byte{}, h.privacy.localIRK[:]...)
+	}
+	if h.hostRotation() {
+		if err := h.rotateOwnAddr(); err != nil {
+			return fmt.Errorf("address rotation: %v", err)
+		}
+	}
+	h.group.Go(func() { h.runRotation(r.period) })
+	return nil
+}
+
+func (h *HCI) runRotation(period time.Duration) {
+	t := time.NewTimer(period)
+	defer t.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-t.C:
+		}
+		err := h.RotateAddress()
+		if err == ErrBusyDialing {
+			t.Reset(rotationRetry)
+			continue
+		}
+		if err != nil {
+			// Tried again once the period is over again.
+			h.Warnf("address rotation: %v", err)
+		}
+		t.Reset(period)
+	}
+}
+
+// RotateAddress rotates the private addresses now, rather than once the
+// period set by OptAddressRotation is over: the random address of the local
+// device, if the host rotates it, and the random addresses of the advertising
+// sets which aren't static. It fails with ErrBusyDialing while a Dial
+// initiates a connection, which uses the current address. The connections
+// keep the address they were established with.
+func (h *HCI) RotateAddress() error {
+	if h.rotation == nil {
+		return fmt.Errorf("address rotation not enabled")
+	}
+	select {
+	case h.dialSem <- struct{}{}:
+		defer func() { <-h.dialSem }()
+	default:
+		return ErrBusyDialing
+	}
+	if h.hostRotation() {
+		if err := h.rotateOwnAddr(); err != nil {
+			return err
+		}
+	}
+	h.rotateAdvSetAddrs()
+	return nil
+}
+
+// PrivateAddr returns the random address the host rotates the address of the
+// local device to, or nil if it doesn't.
+func (h *HCI) PrivateAddr() ble.Addr {
+	if !h.hostRotation() {
+		return nil
+	}
+	a := h.rotation.current()
+	if a == nil {
+		return nil
+	}
+	return ble.NewAddr(a.String())
+}
+
+// rotateOwnAddr sets a new random address for the local device. The
+// controller doesn't take it while it advertises or scans, which are paused
+// around the change.
+func (h *HCI) rotateOwnAddr() error {
+	a, err := h.rotation.newAddr(true)
+	if err != nil {
+		return err
+	}
+
+	h.muScan.Lock()
+	defer h.muScan.Unlock()
+	h.muAdv.Lock()
+	defer h.muAdv.Unlock()
+	defer h.syncAdvState()
+
+	scanning := h.params.scanEnable.LEScanEnable == 1 && !h.scanSched.paused
+	advertising := h.params.advEnable.AdvertisingEnable == 1 && !h.advStopped
+	if scanning {
+		if err := h.Send(&cmd.LESetScanEnable{LEScanEnable: 0}, nil); err != nil {
+			return fmt.Errorf("pause scanning: %v", err)
+		}
+	}
+	if advertising {
+		if err := h.Send(&cmd.LESetAdvertiseEnable{AdvertisingEnable: 0}, nil); err != nil {
+			// The controller may have accepted a connection meanwhile.
+			h.Debugf("address rotation: pause advertising: %v", err)
+			advertising = false
+		}
+	}
+
+	c := &cmd.LESetRandomAddress{}
+	copy(c.RandomAddress[:], sliceops.SwapBuf(a))
+	err = h.Send(c, nil)
+	if err == nil {
+		h.rotation.mu.Lock()
+		h.rotation.addr = a
+		h.rotation.mu.Unlock()
+		h.Debugf("address rotation: random address %v", a)
+	}
+
+	if advertising {
+		if err := h.Send(&h.params.advEnable, nil); err != nil {
+			// Resumed like after a Dial.
+			h.Debugf("address rotation: resume advertising: %v", err)
+			h.advStopped = true
+		}
+	}
+	if scanning {
+		c := &cmd.LESetScanEnable{LEScanEnable: 1, FilterDuplicates: h.params.scanEnable.FilterDuplicates}
+		if err := h.Send(c, nil); err != nil {
+			h.Warnf("address rotation: resume scanning: %v", err)
+		}
+	}
+	return err
+}
+
+// rotateAdvSetAddrs sets new random addresses for the advertising sets which
+// use private ones. The controller refuses them for connectable sets while
+// they advertise; these keep their address until the next rotation.
+func (h *HCI) rotateAdvSetAddrs() {
+	h.muAdvSetAddrs.Lock()
+	addrs := make(map[uint8]ble.Addr, len(h.advSetAddrs))
+	for handle, a := range h.advSetAddrs {
+		addrs[handle] = a
+	}
+	h.muAdvSetAddrs.Unlock()
+
+	for handle, a := range addrs {
+		b := a.Bytes()
+		if len(b) != 6 || b[0]&0xC0 == 0xC0 {
+			// Static addresses are kept.
+			continue
+		}
+		na, err := h.rotation.newAddr(b[0]&0xC0 == 0x40)
+		if err != nil {
+			h.Warnf("address rotation: %v", err)
+			return
+		}
+		if err := h.SetAdvertisingSetRandomAddress(handle, ble.NewAddr(na.String())); err != nil {
+			h.Debugf("address rotation: advertising set %d: %v", handle, err)
+		}
+	}
+}
+
+// rotatedLocalAddr returns the random address the host set, least
+// significant octet first, if the connection of role r was established with
+// it, as the own address type of the connection parameters or the
+// advertising parameters is random.
+func (h *HCI) rotatedLocalAddr(r uint8) ([6]byte, bool) {
+	var la [6]byte
+	if !h.hostRotation() {
+		return la, false
+	}
+	oat := h.params.advParams.OwnAddressType
+	if r == roleMaster {
+		oat = h.params.connParams.OwnAddressType
+	}
+	a := h.rotation.current()
+	if oat != AddressTypeRandom || a == nil {
+		return la, false
+	}
+	copy(la[:], sliceops.SwapBuf(a))
+	return la, true
+}
@@ -0,0 +1,85 @@
+package hci_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux/hci"
+)
+
+func TestAddressRotation(t *testing.T) {
+	// The virtual controller doesn't support privacy: the host rotates RPAs.
+	pair := virtualtest.NewPair(t, []ble.Option{ble.OptPrivacy(bytes.Repeat([]byte{0x42}, 16), time.Minute), ble.OptAddressRotation(time.Hour)}, nil)
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+
+	seen := make(chan string, 16)
+	sctx, scancel := context.WithCancel(ctx)
+	go c.Scan(sctx, true, func(a ble.Advertisement) {
+		select {
+		case seen <- a.Addr().String():
+		default:
+		}
+	})
+	// waitAdvertised waits for the peripheral to advertise with a.
+	waitAdvertised := func(a ble.Addr) {
+		t.Helper()
+		for {
+			select {
+			case s := <-seen:
+				if s == a.String() {
+					return
+				}
+			case <-ctx.Done():
+				t.Fatalf("%v not advertised", a)
+			}
+		}
+	}
+
+	first := p.HCI.PrivateAddr()
+	if first == nil || first.Bytes()[0]&0xC0 != 0x40 {
+		t.Fatalf("private address %v, want an RPA", first)
+	}
+	waitAdvertised(first)
+	if err := p.HCI.RotateAddress(); err != nil {
+		t.Fatal(err)
+	}
+	second := p.HCI.PrivateAddr()
+	if second.String() == first.String() {
+		t.Fatal("address not rotated")
+	}
+	waitAdvertised(second)
+	scancel()
+
+	cln, err := c.Dial(ctx, hci.RandomAddress{Addr: second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cln.CancelConnection()
+
+	// The connection keeps the address it was established with.
+	if err := p.HCI.RotateAddress(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cln.DiscoverServices(nil); err != nil {
+		t.Fatal(err)
+	}
+	if a := cln.Conn().RemoteAddr(); a.String() != second.String() {
+		t.Errorf("remote address %v, want %v", a, second)
+	}
+	conns := p.Conns()
+	if len(conns) != 1 {
+		t.Fatalf("%d connections", len(conns))
+	}
+	if a := conns[0].ConnInfo().LocalRPA; a == nil || a.String() != second.String() {
+		t.Errorf("local address %v, want %v", a, second)
+	}
+}
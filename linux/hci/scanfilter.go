@@ -0,0 +1,138 @@
+package hci
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+// scanFilter holds the host allow and deny lists of the advertisers. They're
+// applied to the advertising reports before they're parsed, so the reports
+// of irrelevant devices cost little in dense environments.
+type scanFilter struct {
+	sync.RWMutex
+	allow *addrList
+	deny  *addrList
+}
+
+// addrList matches addresses, most significant octet first, either in full
+// or by the OUI prefix of public addresses.
+type addrList struct {
+	addrs map[[6]byte]bool
+	ouis  map[[3]byte]bool
+}
+
+// parseAddrList parses addresses such as "aa:bb:cc:dd:ee:ff" and OUI
+// prefixes such as "aa:bb:cc". It returns nil if there are none.
+func parseAddrList(entries []string) (*addrList, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	l := &addrList{addrs: make(map[[6]byte]bool), ouis: make(map[[3]byte]bool)}
+	for _, s := range entries {
+		b, err := hex.DecodeString(strings.NewReplacer(":", "", "-", "").Replace(s))
+		switch {
+		case err == nil && len(b) == 6:
+			var a [6]byte
+			copy(a[:], b)
+			l.addrs[a] = true
+		case err == nil && len(b) == 3:
+			var oui [3]byte
+			copy(oui[:], b)
+			l.ouis[oui] = true
+		default:
+			return nil, fmt.Errorf("invalid address or OUI %q", s)
+		}
+	}
+	return l, nil
+}
+
+func (l *addrList) match(a [6]byte, public bool) bool {
+	if l.addrs[a] {
+		return true
+	}
+	var oui [3]byte
+	copy(oui[:], a[:3])
+	return public && l.ouis[oui]
+}
+
+// SetScanAllowList sets the advertisers whose reports are passed on, by
+// address, or by OUI prefix for public addresses, e.g. "aa:bb:cc". The
+// identity address of a resolvable private address, resolved with the IRKs
+// of the bonds, matches too. The reports of the others are dropped before
+// they're parsed. An empty list lets all advertisers through. It may be
+// changed while scanning.
+func (h *HCI) SetScanAllowList(addrs ...string) error {
+	l, err := parseAddrList(addrs)
+	if err != nil {
+		return err
+	}
+	h.scanFilter.Lock()
+	h.scanFilter.allow = l
+	h.scanFilter.Unlock()
+	return nil
+}
+
+// SetScanDenyList sets the advertisers whose reports are dropped before
+// they're parsed, matched like those of SetScanAllowList. It takes
+// precedence over the allow list. It may be changed while scanning.
+func (h *HCI) SetScanDenyList(addrs ...string) error {
+	l, err := parseAddrList(addrs)
+	if err != nil {
+		return err
+	}
+	h.scanFilter.Lock()
+	h.scanFilter.deny = l
+	h.scanFilter.Unlock()
+	return nil
+}
+
+// admitAdv reports whether the report i of e passes the allow and deny
+// lists. Malformed reports pass, for the parsing to report them.
+func (h *HCI) admitAdv(e evt.LEAdvertisingReport, i int) bool {
+	h.scanFilter.RLock()
+	allow, deny := h.scanFilter.allow, h.scanFilter.deny
+	h.scanFilter.RUnlock()
+	if allow == nil && deny == nil {
+		return true
+	}
+
+	at, err := e.AddressTypeWErr(i)
+	if err != nil {
+		return true
+	}
+	b, err := e.AddressWErr(i)
+	if err != nil {
+		return true
+	}
+	a := [6]byte{b[5], b[4], b[3], b[2], b[1], b[0]}
+	// The controller reports resolved identity addresses with the types
+	// 0x02 and 0x03.
+	public := at == AddressTypePublic || at == 0x02
+
+	var id *Identity
+	if h.resolver != nil && at == AddressTypeRandom {
+		if id = h.resolver.resolve(a); id != nil && len(id.Addr) != 6 {
+			id = nil
+		}
+	}
+	match := func(l *addrList) bool {
+		if l.match(a, public) {
+			return true
+		}
+		if id == nil {
+			return false
+		}
+		var ida [6]byte
+		copy(ida[:], id.Addr)
+		return l.match(ida, id.AddrType == AddressTypePublic)
+	}
+
+	if deny != nil && match(deny) {
+		return false
+	}
+	return allow == nil || match(allow)
+}
@@ -0,0 +1,78 @@
+package hci
+
+import (
+	"testing"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+func TestScanAllowDenyLists(t *testing.T) {
+	got := make(chan string, 8)
+	h := &HCI{Logger: ble.GetLogger(), advHandlerSync: true, adHist: make([]*Advertisement, 128), resolver: &resolver{}}
+	h.resolver.add(&Identity{IRK: testIRK, Addr: []byte{0xc0, 1, 2, 3, 4, 5}, AddrType: AddressTypeRandom})
+	h.advHandler = func(a ble.Advertisement) { got <- a.Addr().String() }
+
+	// report reports an advertisement of addr, most significant octet first.
+	report := func(at uint8, addr [6]byte) {
+		b := []byte{evt.LEAdvertisingReportSubCode, 1, evtTypAdvNonconnInd, at,
+			addr[5], addr[4], addr[3], addr[2], addr[1], addr[0], 3, 0x02, 0x01, 0x06, 0xC0}
+		if err := h.handleLEAdvertisingReport(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case a := <-got:
+				if a != w {
+					t.Fatalf("advertisement of %s, want %s", a, w)
+				}
+			default:
+				t.Fatalf("no advertisement, want %s", w)
+			}
+		}
+		if len(got) != 0 {
+			t.Fatalf("unexpected advertisement of %s", <-got)
+		}
+	}
+	public := [6]byte{0x00, 0x1a, 0x7d, 0x11, 0x22, 0x33}
+	other := [6]byte{0x00, 0x1b, 0x7d, 0x11, 0x22, 0x33}
+	rpa := [6]byte{0x70, 0x81, 0x94, 0x0d, 0xfb, 0xaa}
+
+	if err := h.SetScanAllowList("00:1a:7d", "c0:01:02:03:04:05"); err != nil {
+		t.Fatal(err)
+	}
+	report(AddressTypePublic, public)
+	report(AddressTypePublic, other)
+	report(AddressTypeRandom, rpa)
+	// The OUI doesn't match random addresses.
+	report(AddressTypeRandom, public)
+	expect("00:1a:7d:11:22:33", "70:81:94:0d:fb:aa")
+
+	// The deny list takes precedence.
+	if err := h.SetScanDenyList("00-1A-7D-11-22-33", "c0:01:02:03:04:05"); err != nil {
+		t.Fatal(err)
+	}
+	report(AddressTypePublic, public)
+	report(AddressTypeRandom, rpa)
+	expect()
+
+	// Empty lists let everything through.
+	if err := h.SetScanAllowList(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetScanDenyList(); err != nil {
+		t.Fatal(err)
+	}
+	report(AddressTypePublic, other)
+	expect("00:1b:7d:11:22:33")
+
+	if s := h.ScanStats(); s.Filtered != 4 {
+		t.Fatalf("%d reports filtered, want 4", s.Filtered)
+	}
+	if err := h.SetScanAllowList("00:1a"); err == nil {
+		t.Fatal("invalid OUI accepted")
+	}
+}
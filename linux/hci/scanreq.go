@@ -0,0 +1,80 @@
+package hci
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+// ScanRequest is a scan request received by an extended advertising set,
+// from a scanner actively probing it.
+type ScanRequest struct {
+	AdvertisingHandle uint8
+
+	// Scanner is the address of the scanner, a RandomAddress if it's random.
+	Scanner ble.Addr
+
+	// ScannerAddrType is 0x00 for a public and 0x01 for a random address,
+	// and 0x02 and 0x03 for the public and random identity addresses of a
+	// scanner whose RPA the controller resolved.
+	ScannerAddrType uint8
+
+	// Identity is the identity of a bonded scanner, if its RPA was
+	// resolved by the host, with OptHostAddrResolution.
+	Identity *Identity
+}
+
+// SetScanRequestHandler sets the function passed the scan requests received
+// by advertising sets, e.g. for presence analytics, or nil to stop. The
+// controller reports them for the sets created with Scan_Request_Notification_Enable
+// set in their LE Set Extended Advertising Parameters; like for CreateBIG,
+// the sets are created with custom commands. f is called on its own
+// goroutine.
+func (h *HCI) SetScanRequestHandler(f func(ScanRequest)) error {
+	h.muScanReq.Lock()
+	h.scanReqHandler = f
+	h.muScanReq.Unlock()
+	if h.socket() == nil {
+		// Applied by Init.
+		return nil
+	}
+	if err := h.setEventMask(); err != nil {
+		return fmt.Errorf("failed to unmask scan request events: %v", err)
+	}
+	return nil
+}
+
+func (h *HCI) scanRequestHandler() func(ScanRequest) {
+	h.muScanReq.Lock()
+	defer h.muScanReq.Unlock()
+	return h.scanReqHandler
+}
+
+func (h *HCI) handleLEScanRequestReceived(b []byte) error {
+	e := evt.LEScanRequestReceived(b)
+	if len(e) < 9 {
+		return fmt.Errorf("invalid scan request received event: % X", b)
+	}
+	f := h.scanRequestHandler()
+	if f == nil {
+		return nil
+	}
+
+	a := e.ScannerAddress()
+	ma := [6]byte{a[5], a[4], a[3], a[2], a[1], a[0]}
+	req := ScanRequest{
+		AdvertisingHandle: e.AdvertisingHandle(),
+		Scanner:           ble.NewAddr(net.HardwareAddr(ma[:]).String()),
+		ScannerAddrType:   e.ScannerAddressType(),
+	}
+	if req.ScannerAddrType&0x01 != 0 {
+		req.Scanner = RandomAddress{req.Scanner}
+	}
+	if req.ScannerAddrType == AddressTypeRandom && h.resolver != nil {
+		req.Identity = h.resolver.resolve(ma)
+	}
+	h.group.Go(func() { f(req) })
+	return nil
+}
@@ -0,0 +1,35 @@
+package hci
+
+import (
+	"testing"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+func TestScanRequestReceived(t *testing.T) {
+	id := &Identity{IRK: testIRK, Addr: []byte{0xc0, 1, 2, 3, 4, 5}, AddrType: 1}
+	h := &HCI{Logger: ble.GetLogger(), resolver: &resolver{}}
+	h.resolver.add(id)
+	got := make(chan ScanRequest, 1)
+	if err := h.SetScanRequestHandler(func(r ScanRequest) { got <- r }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set 2 probed by the RPA 70:81:94:0D:FB:AA.
+	e := []byte{evt.LEScanRequestReceivedSubCode, 0x02, 0x01, 0xaa, 0xfb, 0x0d, 0x94, 0x81, 0x70}
+	if err := h.handleLEScanRequestReceived(e); err != nil {
+		t.Fatal(err)
+	}
+	r := <-got
+	if r.AdvertisingHandle != 2 || r.Scanner.String() != "70:81:94:0d:fb:aa" || r.Identity != id {
+		t.Fatalf("request %+v", r)
+	}
+	if _, ok := r.Scanner.(RandomAddress); !ok {
+		t.Error("scanner address not random")
+	}
+
+	if err := h.handleLEScanRequestReceived(e[:5]); err == nil {
+		t.Error("no error on a short event")
+	}
+}
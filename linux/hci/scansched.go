@@ -0,0 +1,95 @@
+package hci
+
+import (
+	"sync"
+	"time"
+
+	"github.com/leso-kn/ble/linux/hci/cmd"
+)
+
+// scanScheduler duty-cycles scanning: the controller scans for window, then
+// idles for idle, and so on, while the scan stays enabled as far as Scan and
+// StopScanning are concerned.
+type scanScheduler struct {
+	sync.Mutex
+	window time.Duration
+	idle   time.Duration
+	stop   chan struct{}
+
+	// paused is set while the controller idles. It's guarded by h.muScan.
+	paused bool
+}
+
+// startScanSchedule duty-cycles the scan just enabled, until
+// stopScanSchedule. Must be called with h.muScan held.
+func (h *HCI) startScanSchedule() {
+	s := &h.scanSched
+	s.Lock()
+	defer s.Unlock()
+	if s.window <= 0 || s.idle <= 0 || s.stop != nil {
+		return
+	}
+	stop := make(chan struct{})
+	s.stop = stop
+	s.paused = false
+	window, idle := s.window, s.idle
+	h.group.Go(func() { h.runScanSchedule(window, idle, stop) })
+}
+
+// stopScanSchedule stops duty-cycling, and reports whether the controller
+// idles. Must be called with h.muScan held.
+func (h *HCI) stopScanSchedule() bool {
+	s := &h.scanSched
+	s.Lock()
+	defer s.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	paused := s.paused
+	s.paused = false
+	h.setScanPaused(false)
+	return paused
+}
+
+func (h *HCI) runScanSchedule(window, idle time.Duration, stop chan struct{}) {
+	t := time.NewTimer(window)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-h.done:
+			return
+		case <-t.C:
+		}
+
+		h.muScan.Lock()
+		select {
+		case <-stop:
+			h.muScan.Unlock()
+			return
+		default:
+		}
+		paused := h.scanSched.paused
+		c := &cmd.LESetScanEnable{FilterDuplicates: h.params.scanEnable.FilterDuplicates}
+		if paused {
+			c.LEScanEnable = 1
+		}
+		if err := h.Send(c, nil); err != nil {
+			// Retried once the period is over again.
+			h.Warnf("scan schedule: %v", err)
+		} else {
+			paused = !paused
+			h.scanSched.paused = paused
+			h.setScanPaused(paused)
+		}
+		h.muScan.Unlock()
+
+		if paused {
+			t.Reset(idle)
+		} else {
+			t.Reset(window)
+		}
+	}
+}
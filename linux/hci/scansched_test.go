@@ -0,0 +1,61 @@
+package hci_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+)
+
+func TestScanDutyCycle(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, []ble.Option{ble.OptScanDutyCycle(100*time.Millisecond, 300*time.Millisecond)})
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+
+	// The reports come in bursts, the scan resuming by itself.
+	var mu sync.Mutex
+	var reports []time.Time
+	paused := false
+	sctx, scancel := context.WithTimeout(ctx, time.Second)
+	defer scancel()
+	go func() {
+		for sctx.Err() == nil {
+			if c.HCI.State().ScanningPaused {
+				mu.Lock()
+				paused = true
+				mu.Unlock()
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	c.Scan(sctx, true, func(a ble.Advertisement) {
+		mu.Lock()
+		reports = append(reports, time.Now())
+		mu.Unlock()
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !paused {
+		t.Error("scan never paused")
+	}
+	bursts := 0
+	for i := range reports {
+		if i == 0 || reports[i].Sub(reports[i-1]) > 200*time.Millisecond {
+			bursts++
+		}
+	}
+	if bursts < 2 {
+		t.Errorf("%d bursts of reports, want at least 2", bursts)
+	}
+	if s := c.HCI.State(); s.Scanning || s.ScanningPaused {
+		t.Errorf("state %+v once stopped", s)
+	}
+}
@@ -0,0 +1,147 @@
+package hci
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// scanStatsMaxAddrs bounds the per-address counts. Reports of further
+	// addresses are only counted in total.
+	scanStatsMaxAddrs = 4096
+
+	// maxAdvHandlers bounds the advertisement handlers running
+	// asynchronously. Advertisements are dropped beyond it.
+	maxAdvHandlers = 1024
+)
+
+// ScanStats are counters of the scanning subsystem, since the HCI was
+// created or the counters were reset.
+type ScanStats struct {
+	Reports     uint64 // Advertising reports received.
+	ParseErrors uint64 // Reports dropped as malformed.
+	Dropped     uint64 // Advertisements dropped as too many handlers were running.
+	Filtered    uint64 // Reports dropped by the scan allow and deny lists.
+
+	// ReportsPerSecond is the rate of reports in the last complete second.
+	ReportsPerSecond float64
+
+	// PerAddress counts the reports of each address.
+	PerAddress map[string]uint64
+}
+
+type scanStats struct {
+	sync.Mutex
+	s        ScanStats
+	secStart time.Time
+	secCount uint64
+	handlers int
+}
+
+// received counts n reports.
+func (st *scanStats) received(n int, now time.Time) {
+	st.Lock()
+	defer st.Unlock()
+	st.s.Reports += uint64(n)
+	switch d := now.Sub(st.secStart); {
+	case d >= 2*time.Second:
+		// No reports in the last complete second.
+		st.s.ReportsPerSecond = 0
+		st.secStart, st.secCount = now, 0
+	case d >= time.Second:
+		st.s.ReportsPerSecond = float64(st.secCount)
+		st.secStart, st.secCount = st.secStart.Add(time.Second), 0
+	}
+	st.secCount += uint64(n)
+}
+
+// parsed counts a report of addr.
+func (st *scanStats) parsed(addr string) {
+	st.Lock()
+	defer st.Unlock()
+	if st.s.PerAddress == nil {
+		st.s.PerAddress = make(map[string]uint64)
+	}
+	if _, ok := st.s.PerAddress[addr]; ok || len(st.s.PerAddress) < scanStatsMaxAddrs {
+		st.s.PerAddress[addr]++
+	}
+}
+
+func (st *scanStats) filtered() {
+	st.Lock()
+	st.s.Filtered++
+	st.Unlock()
+}
+
+func (st *scanStats) parseError() {
+	st.Lock()
+	st.s.ParseErrors++
+	st.Unlock()
+}
+
+// acquire reserves an asynchronous handler, or counts the advertisement as
+// dropped if too many are running.
+func (st *scanStats) acquire() bool {
+	st.Lock()
+	defer st.Unlock()
+	if st.handlers >= maxAdvHandlers {
+		st.s.Dropped++
+		return false
+	}
+	st.handlers++
+	return true
+}
+
+func (st *scanStats) release() {
+	st.Lock()
+	st.handlers--
+	st.Unlock()
+}
+
+// ScanStats returns the counters of the scanning subsystem.
+func (h *HCI) ScanStats() ScanStats {
+	st := &h.scanStats
+	st.Lock()
+	defer st.Unlock()
+	s := st.s
+	s.PerAddress = make(map[string]uint64, len(st.s.PerAddress))
+	for k, v := range st.s.PerAddress {
+		s.PerAddress[k] = v
+	}
+	return s
+}
+
+// ResetScanStats resets the counters of the scanning subsystem.
+func (h *HCI) ResetScanStats() {
+	st := &h.scanStats
+	st.Lock()
+	st.s = ScanStats{}
+	st.secStart, st.secCount = time.Time{}, 0
+	st.Unlock()
+}
+
+// dispatchAdv passes an advertisement to the handler.
+func (h *HCI) dispatchAdv(a *Advertisement) {
+	if h.registry != nil {
+		h.registry.Observe(a)
+	}
+	if h.advHandlerSync {
+		h.advHandler(delivered(a))
+		return
+	}
+	if !h.scanStats.acquire() {
+		return
+	}
+	h.group.Go(func() {
+		defer h.scanStats.release()
+		h.advHandler(delivered(a))
+	})
+}
+
+// delivered returns a copy of a stamped with its delivery time. The event
+// loop may still copy a to attach a scan response.
+func delivered(a *Advertisement) *Advertisement {
+	d := *a
+	d.delivered = time.Now()
+	return &d
+}
@@ -0,0 +1,47 @@
+package hci
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanStats(t *testing.T) {
+	h := &HCI{}
+	st := &h.scanStats
+	t0 := time.Unix(100, 0)
+
+	st.received(1, t0)
+	for i := 0; i < 5; i++ {
+		st.received(1, t0.Add(time.Duration(i)*100*time.Millisecond))
+	}
+	st.received(1, t0.Add(1100*time.Millisecond))
+	if s := h.ScanStats(); s.Reports != 7 || s.ReportsPerSecond != 6 {
+		t.Fatalf("reports %d, rate %v; want 7, 6", s.Reports, s.ReportsPerSecond)
+	}
+	st.received(1, t0.Add(5*time.Second))
+	if s := h.ScanStats(); s.ReportsPerSecond != 0 {
+		t.Fatalf("rate %v after a pause, want 0", s.ReportsPerSecond)
+	}
+
+	st.parsed("a")
+	st.parsed("a")
+	st.parseError()
+	s := h.ScanStats()
+	if s.PerAddress["a"] != 2 || s.ParseErrors != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	s.PerAddress["a"] = 0
+	if h.ScanStats().PerAddress["a"] != 2 {
+		t.Fatal("ScanStats returned the internal map")
+	}
+
+	st.handlers = maxAdvHandlers
+	if st.acquire() || h.ScanStats().Dropped != 1 {
+		t.Fatal("advertisement not dropped with all handlers busy")
+	}
+
+	h.ResetScanStats()
+	if s := h.ScanStats(); s.Reports != 0 || len(s.PerAddress) != 0 {
+		t.Fatalf("stats not reset: %+v", s)
+	}
+}
@@ -4,18 +4,90 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
 	"time"
 
+	"github.com/leso-kn/ble"
 	"github.com/leso-kn/ble/linux/hci/cmd"
 )
 
-// Signal ...
+// Signal is an L2CAP LE signaling command [Vol 3, Part A, 4].
 type Signal interface {
 	Code() int
 	Marshal() ([]byte, error)
 	Unmarshal([]byte) error
 }
 
+// RawSignal is a signaling command of any code, with its data as is. It
+// sends and receives the commands the package doesn't define.
+type RawSignal struct {
+	Cmd  uint8
+	Data []byte
+}
+
+// Code returns the code of the command.
+func (s *RawSignal) Code() int { return int(s.Cmd) }
+
+// Marshal returns the data of the command.
+func (s *RawSignal) Marshal() ([]byte, error) { return s.Data, nil }
+
+// Unmarshal sets the data of the command to a copy of b.
+func (s *RawSignal) Unmarshal(b []byte) error {
+	s.Data = append([]byte(nil), b...)
+	return nil
+}
+
+// Error makes a Command Reject received in response to Signal its error.
+func (s *CommandReject) Error() string {
+	return fmt.Sprintf("signaling command rejected, reason 0x%04X", s.Reason)
+}
+
+// signalTimeout is how long Signal waits for a response, the RTX timer
+// [Vol 3, Part A, 6.2.1].
+var signalTimeout = time.Second
+
+// A SignalHandler handles a signaling command received on a connection,
+// with its identifier and data. It's called from the goroutine receiving the
+// data of the connection, so it mustn't block; it may respond with
+// RespondSignal, from any goroutine, but mustn't wait for Signal.
+type SignalHandler func(c *Conn, id uint8, data []byte)
+
+// SetSignalHandler sets the handler of the signaling commands with the code
+// received on all connections, in place of the package's handling, if any.
+// Responses to the requests sent with Signal aren't passed to it. A nil f
+// removes the handler.
+func (h *HCI) SetSignalHandler(code uint8, f SignalHandler) {
+	h.muSigh.Lock()
+	defer h.muSigh.Unlock()
+	if f == nil {
+		delete(h.usrSigh, code)
+		return
+	}
+	if h.usrSigh == nil {
+		h.usrSigh = make(map[uint8]SignalHandler)
+	}
+	h.usrSigh[code] = f
+}
+
+// userSignalHandler returns the user handler for a signaling code, if any.
+func (h *HCI) userSignalHandler(code uint8) SignalHandler {
+	h.muSigh.RLock()
+	defer h.muSigh.RUnlock()
+	return h.usrSigh[code]
+}
+
+// sigResponses are the codes of the signaling responses, which are matched
+// with the pending request rather than handled.
+var sigResponses = map[int]bool{
+	SignalCommandReject:                     true,
+	SignalDisconnectResponse:                true,
+	SignalConnectionParameterUpdateResponse: true,
+	SignalLECreditBasedConnectionResponse:   true,
+	0x18:                                    true, // Credit Based Connection Response.
+	0x1A:                                    true, // Credit Based Reconfigure Response.
+}
+
 type sigCmd []byte
 
 func (s sigCmd) code() int    { return int(s[0]) }
@@ -23,60 +95,90 @@ func (s sigCmd) id() uint8    { return s[1] }
 func (s sigCmd) len() int     { return int(binary.LittleEndian.Uint16(s[2:4])) }
 func (s sigCmd) data() []byte { return s[4 : 4+s.len()] }
 
-// Signal ...
-func (c *Conn) Signal(req Signal, rsp Signal) error {
-	data, err := req.Marshal()
-	if err != nil {
-		return err
-	}
-	buf := bytes.NewBuffer(make([]byte, 0))
-	if err := binary.Write(buf, binary.LittleEndian, uint16(4+len(data))); err != nil {
-		return err
-	}
-	if err := binary.Write(buf, binary.LittleEndian, cidLESignal); err != nil {
-		return err
+// nextSigID returns the identifier of the next command sent, which is never
+// zero [Vol 3, Part A, 4].
+func (c *Conn) nextSigID() uint8 {
+	c.muSig.Lock()
+	defer c.muSig.Unlock()
+	c.sigID++
+	if c.sigID == 0 {
+		c.sigID++
 	}
+	return c.sigID
+}
 
-	if err := binary.Write(buf, binary.LittleEndian, uint8(req.Code())); err != nil {
-		return err
-	}
-	if err := binary.Write(buf, binary.LittleEndian, uint8(c.sigID)); err != nil {
-		return err
-	}
-	if err := binary.Write(buf, binary.LittleEndian, uint16(len(data))); err != nil {
-		return err
-	}
-	if err := binary.Write(buf, binary.LittleEndian, data); err != nil {
-		return err
-	}
+// Signal sends the request req, and waits for its response, which it
+// unmarshals in rsp, if not nil. It fails with the *CommandReject the peer
+// responded with, if it rejected the request, or if the response doesn't
+// have the code of rsp. Requests are sent one at a time.
+func (c *Conn) Signal(req Signal, rsp Signal) error {
+	return c.signal(req, rsp, nil)
+}
 
-	c.sigSent = make(chan []byte)
-	defer close(c.sigSent)
-	if _, err := c.writePDU(buf.Bytes()); err != nil {
+// signal is Signal, which also passes the response to f, if not nil, from
+// the goroutine receiving it, before any command that follows is handled.
+func (c *Conn) signal(req Signal, rsp Signal, f func(sigCmd)) error {
+	c.muSigReq.Lock()
+	defer c.muSigReq.Unlock()
+
+	id := c.nextSigID()
+	ch := make(chan sigCmd, 1)
+	c.muSig.Lock()
+	c.sigPending, c.sigRsp, c.sigOnRsp = id, ch, f
+	c.muSig.Unlock()
+	defer func() {
+		c.muSig.Lock()
+		c.sigPending, c.sigRsp, c.sigOnRsp = 0, nil, nil
+		c.muSig.Unlock()
+	}()
+
+	if _, err := c.sendSignal(uint8(req.Code()), id, req); err != nil {
 		return err
 	}
 	var s sigCmd
 	select {
-	case s = <-c.sigSent:
-	case <-time.After(time.Second):
-		// TODO: Find the proper timed out defined in spec, if any.
+	case s = <-ch:
+	case <-c.chDone:
+		return io.ErrClosedPipe
+	case <-time.After(signalTimeout):
 		return errors.New("signaling request timed out")
 	}
 
-	if s.code() != req.Code() {
-		return errors.New("mismatched signaling response")
-	}
-	if s.id() != c.sigID {
-		return errors.New("mismatched signaling id")
+	if s.code() == SignalCommandReject {
+		var rej CommandReject
+		if err := rej.Unmarshal(s.data()); err != nil {
+			return err
+		}
+		return &rej
 	}
-	c.sigID++
 	if rsp == nil {
 		return nil
 	}
+	if s.code() != rsp.Code() {
+		return fmt.Errorf("mismatched signaling response 0x%02X", s.code())
+	}
 	return rsp.Unmarshal(s.data())
 }
 
+// SendSignal sends a command which isn't answered, such as an LE Flow
+// Control Credit, with an identifier of its own.
+func (c *Conn) SendSignal(s Signal) error {
+	_, err := c.sendSignal(uint8(s.Code()), c.nextSigID(), s)
+	return err
+}
+
+// RespondSignal sends the response r to the request with the identifier id
+// passed to a SignalHandler.
+func (c *Conn) RespondSignal(id uint8, r Signal) error {
+	_, err := c.sendSignal(uint8(r.Code()), id, r)
+	return err
+}
+
 func (c *Conn) sendResponse(code uint8, id uint8, r Signal) (int, error) {
+	return c.sendSignal(code, id, r)
+}
+
+func (c *Conn) sendSignal(code uint8, id uint8, r Signal) (int, error) {
 	data, err := r.Marshal()
 	if err != nil {
 		return 0, err
@@ -104,6 +206,26 @@ func (c *Conn) sendResponse(code uint8, id uint8, r Signal) (int, error) {
 	return c.writePDU(buf.Bytes())
 }
 
+// handleResponse passes a response to the pending request, if it's its
+// response, and reports whether it did.
+func (c *Conn) handleResponse(s sigCmd) bool {
+	if !sigResponses[s.code()] {
+		return false
+	}
+	c.muSig.Lock()
+	defer c.muSig.Unlock()
+	if c.sigRsp == nil || s.id() != c.sigPending {
+		// Responses to no pending request are silently discarded.
+		return true
+	}
+	if c.sigOnRsp != nil {
+		c.sigOnRsp(s)
+	}
+	c.sigRsp <- append(sigCmd(nil), s...)
+	c.sigRsp, c.sigOnRsp = nil, nil
+	return true
+}
+
 func (c *Conn) handleSignal(p pdu) error {
 	c.Debugf("signal: recv [%X]", p)
 	// When multiple commands are included in an L2CAP packet and the packet
@@ -126,7 +248,17 @@ func (c *Conn) handleSignal(p pdu) error {
 	}
 
 	s := sigCmd(p.payload())
-	for len(s) > 0 {
+	for len(s) >= 4 && len(s) >= 4+s.len() {
+		if c.handleResponse(s) {
+			s = s[4+s.len():]
+			continue
+		}
+		if f := c.hci.userSignalHandler(uint8(s.code())); f != nil {
+			f(c, s.id(), append([]byte(nil), s.data()...))
+			s = s[4+s.len():]
+			continue
+		}
+
 		// Check if it's a supported request.
 		switch s.code() {
 		case SignalDisconnectRequest:
@@ -138,13 +270,6 @@ func (c *Conn) handleSignal(p pdu) error {
 		case SignalLEFlowControlCredit:
 			c.LEFlowControlCredit(s)
 		default:
-			// Check if it's a response to a sent command.
-			select {
-			case c.sigSent <- s:
-				continue
-			default:
-			}
-
 			c.sendResponse(
 				SignalCommandReject,
 				s.id(),
@@ -165,6 +290,22 @@ func (c *Conn) handleDisconnectRequest(s sigCmd) {
 		return
 	}
 
+	if ch := c.channel(req.DestinationCID); ch != nil {
+		// Silently discard the request if SCID isn't the channel's.
+		if req.SourceCID != ch.dcid {
+			return
+		}
+		ch.closeLocal()
+		c.sendResponse(
+			SignalDisconnectResponse,
+			s.id(),
+			&DisconnectResponse{
+				DestinationCID: req.DestinationCID,
+				SourceCID:      req.SourceCID,
+			})
+		return
+	}
+
 	// Send Command Reject when the DCID is unrecognized.
 	if req.DestinationCID != cidLEAtt {
 		endpoints := make([]byte, 4)
@@ -218,22 +359,37 @@ func (c *Conn) handleConnectionParameterUpdateRequest(s sigCmd) {
 		return
 	}
 
+	p, ok := c.hci.connParamsRequestPolicy(c.RemoteAddr(), ble.ConnParams{
+		IntervalMin: req.IntervalMin,
+		IntervalMax: req.IntervalMax,
+		Latency:     req.SlaveLatency,
+		Timeout:     req.TimeoutMultiplier,
+	})
+	if !ok {
+		c.sendResponse(
+			SignalConnectionParameterUpdateResponse,
+			s.id(),
+			&ConnectionParameterUpdateResponse{
+				Result: 1, // Reject.
+			})
+		return
+	}
+
 	// LE Connection Update (0x08|0x0013) [Vol 2, Part E, 7.8.18]
 	c.hci.Send(&cmd.LEConnectionUpdate{
 		ConnectionHandle:   c.param.ConnectionHandle(),
-		ConnIntervalMin:    req.IntervalMin,
-		ConnIntervalMax:    req.IntervalMax,
-		ConnLatency:        req.SlaveLatency,
-		SupervisionTimeout: req.TimeoutMultiplier,
+		ConnIntervalMin:    p.IntervalMin,
+		ConnIntervalMax:    p.IntervalMax,
+		ConnLatency:        p.Latency,
+		SupervisionTimeout: p.Timeout,
 		MinimumCELength:    0, // Informational, and spec doesn't specify the use.
 		MaximumCELength:    0, // Informational, and spec doesn't specify the use.
 	}, nil)
 
-	// Currently, we (as a slave host) accept all the parameters and forward
-	// it to the controller. The controller might update all, partial or even
-	// none (ignore) of the parameters. The slave(remote) host will be indicated
-	// by its controller if the update actually happens.
-	// TODO: allow users to implement what parameters to accept.
+	// The parameters accepted by the policy (all of them, if no policy is
+	// set) are forwarded to the controller. The controller might update all,
+	// partial or even none (ignore) of the parameters. The slave(remote) host
+	// will be indicated by its controller if the update actually happens.
 	c.sendResponse(
 		SignalConnectionParameterUpdateResponse,
 		s.id(),
@@ -241,13 +397,3 @@ func (c *Conn) handleConnectionParameterUpdateRequest(s sigCmd) {
 			Result: 0, // Accept.
 		})
 }
-
-// LECreditBasedConnectionRequest ...
-func (c *Conn) LECreditBasedConnectionRequest(s sigCmd) {
-	// TODO:
-}
-
-// LEFlowControlCredit ...
-func (c *Conn) LEFlowControlCredit(s sigCmd) {
-	// TODO:
-}
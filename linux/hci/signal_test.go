@@ -0,0 +1,47 @@
+package hci_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux/hci"
+)
+
+func TestSignal(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p := pair.Peripheral
+
+	// The peripheral answers a procedure the package doesn't implement.
+	const req, rsp = 0x17, 0x18
+	p.HCI.SetSignalHandler(req, func(c *hci.Conn, id uint8, data []byte) {
+		if err := c.RespondSignal(id, &hci.RawSignal{Cmd: rsp, Data: append([]byte{0xAA}, data...)}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	defer cln.CancelConnection()
+	conn := cln.Conn().(*hci.Conn)
+
+	for i := 0; i < 3; i++ {
+		r := &hci.RawSignal{Cmd: rsp}
+		if err := conn.Signal(&hci.RawSignal{Cmd: req, Data: []byte{byte(i)}}, r); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(r.Data, []byte{0xAA, byte(i)}) {
+			t.Fatalf("response % X", r.Data)
+		}
+	}
+
+	// Unknown commands are rejected.
+	err := conn.Signal(&hci.RawSignal{Cmd: 0x7F}, nil)
+	if rej, ok := err.(*hci.CommandReject); !ok || rej.Reason != 0x0000 {
+		t.Fatalf("unknown command: %v", err)
+	}
+}
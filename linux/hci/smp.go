@@ -1,6 +1,7 @@
 package hci
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/leso-kn/ble"
@@ -20,6 +21,14 @@ const (
 	IoCapsReservedStart   = 0x05
 )
 
+// Key distribution flags [Vol 3, Part H, 3.6.1].
+const (
+	KeyDistEncKey  = 0x01
+	KeyDistIdKey   = 0x02
+	KeyDistSignKey = 0x04
+	KeyDistLinkKey = 0x08
+)
+
 type OobDataFlag byte
 
 const (
@@ -30,6 +39,14 @@ const (
 type SmpManagerFactory interface {
 	Create(SmpConfig, ble.Logger) SmpManager
 	SetBondManager(BondManager)
+	LocalOOBData() (*ble.OOBData, error)
+
+	// SetDebugKeys has LE Secure Connections pairing use the debug key pair.
+	SetDebugKeys(enable bool)
+
+	// SetCrypto sets the cryptographic functions of pairing, whose type
+	// is up to the implementation.
+	SetCrypto(c interface{}) error
 }
 
 type SmpManager interface {
@@ -37,7 +54,6 @@ type SmpManager interface {
 		localAddrType, remoteAddrType uint8)
 	Handle(data []byte) error
 	Pair(authData ble.AuthData, to time.Duration) error
-	PrepareCustomPairing(chan bool)
 	BondInfoFor(addr string) BondInfo
 	DeleteBondInfo() error
 	SaveBondInfo(BondInfo) error
@@ -45,13 +61,89 @@ type SmpManager interface {
 	SetWritePDUFunc(func([]byte) (int, error))
 	SetEncryptFunc(func(BondInfo) error)
 	LegacyPairingInfo() (bool, []byte)
+
+	// SetAuthData sets the auth data used when the peer initiates pairing.
+	SetAuthData(ble.AuthData)
+
+	// LongTermKey returns the key to encrypt the link with when the peer
+	// starts encryption, or nil if there's none.
+	LongTermKey(ediv uint16, rand uint64) []byte
+
+	// EncryptionChanged is called once the link is encrypted.
+	EncryptionChanged()
+
+	// SetLocalIdentity sets the identity distributed to the peer.
+	SetLocalIdentity(*Identity)
+
+	// VerifySignature verifies the signature at the end of data, signed by
+	// the bonded peer with its CSRK.
+	VerifySignature(data []byte) error
+
+	// PairingState describes the state of the pairing, e.g. for
+	// HCI.DumpState.
+	PairingState() string
 }
 
+// Valid encryption key sizes, in octets [Vol 3, Part H, 2.3.4].
+const (
+	EncKeySizeMin = 7
+	EncKeySizeMax = 16
+)
+
 type SmpConfig struct {
 	IoCap, OobFlag, AuthReq, MaxKeySize, InitKeyDist, RespKeyDist byte
+
+	// MinKeySize is the smallest encryption key size accepted, either when
+	// pairing or when the link is encrypted.
+	MinKeySize byte
+
+	// StaticPasskey, if set, is the fixed passkey of Passkey Entry pairing,
+	// used whichever device displays or inputs it, in place of a generated
+	// or typed in one.
+	StaticPasskey *int
+}
+
+// Authentication requirements flags [Vol 3, Part H, 3.5.1].
+const (
+	authReqBond = 0x01
+	authReqMITM = 0x04
+)
+
+// WithFeatures returns the config with the IO capability and the
+// authentication requirements of f.
+func (c SmpConfig) WithFeatures(f ble.PairingFeatures) (SmpConfig, error) {
+	if f.IOCap >= IoCapsReservedStart {
+		return c, fmt.Errorf("invalid io capability 0x%02X", f.IOCap)
+	}
+	c.IoCap = byte(f.IOCap)
+	c.AuthReq &^= 0x03 | authReqMITM
+	if f.Bond {
+		c.AuthReq |= authReqBond
+	}
+	if f.MITM {
+		c.AuthReq |= authReqMITM
+	}
+	return c, nil
 }
 
-//todo: make these configurable
 var defaultSmpConfig = SmpConfig{
-	IoCapsKeyboardDisplay, byte(OobNotPresent), 0x09, 16, 0x00, 0x01,
+	IoCapsKeyboardDisplay, byte(OobNotPresent), 0x09, EncKeySizeMax, KeyDistIdKey, KeyDistEncKey | KeyDistIdKey,
+	EncKeySizeMin, nil,
+}
+
+// LocalOOBData generates LE Secure Connections OOB data of the device, to pass
+// to a peer out of band, such as over NFC or a QR code. Pairing of
+// connections created from then on uses the key pair and random value the
+// data commits to, so the peer can authenticate the device with it.
+// Data generated earlier is no longer valid.
+func (h *HCI) LocalOOBData() (*ble.OOBData, error) {
+	if !h.smpEnabled || h.smp == nil {
+		return nil, fmt.Errorf("security not enabled")
+	}
+	d, err := h.smp.LocalOOBData()
+	if err != nil {
+		return nil, err
+	}
+	d.Addr = h.Addr()
+	return d, nil
 }
@@ -16,6 +16,16 @@ const (
 	pairingDHKeyCheck       = 0x0D // Pairing DHKey Check LE-U
 	pairingKeypress         = 0x0E // Pairing Keypress Notification LE-U
 
+	passkeyEntryFailed      = 0x01 // Pairing Failed reasons
+	authRequirementsFailed  = 0x03
+	confirmValueFailed      = 0x04
+	pairingNotSupported     = 0x05
+	dhKeyCheckFailed        = 0x0B
+	encryptionKeySizeFailed = 0x06
+	numericComparisonFailed = 0x0C
+
+	passkeyMax = 999999
+
 	passkeyIterationCount = 20
 
 	oobData
@@ -24,4 +34,5 @@ const (
 	authReqBondMask = byte(0x03)
 	authReqBond     = byte(0x01)
 	authReqNoBond   = byte(0x00)
+	authReqMITM     = byte(0x04)
 )
@@ -28,6 +28,8 @@ var pairingTypeStrings = map[int]string{
 }
 
 type pairingContext struct {
+	config         hci.SmpConfig // Local configuration.
+	responder      bool          // The peer initiated the pairing.
 	request        hci.SmpConfig
 	response       hci.SmpConfig
 	remoteAddr     []byte
@@ -45,21 +47,98 @@ type pairingContext struct {
 	scRemotePubKey     crypto.PublicKey
 	scDHKey            []byte
 	scRemoteDHKeyCheck []byte
+	oobRandom          []byte // Random value of the local OOB data.
+	cr                 Crypto // Crypto of the factory, or nil for DefaultCrypto.
 
-	legacy               bool
-	customPairingHandler *chan bool
-	shortTermKey         []byte
+	legacy       bool
+	shortTermKey []byte
 
 	passKeyIteration int
 
 	pairingType int
 	state       PairingState
 	authData    ble.AuthData
+	user        userGate // Holds back the responder until the user answered.
 	bond        hci.BondInfo
+	remoteIRK   []byte
+
+	// Key distribution [Vol 3, Part H, 3.6].
+	pendingKeys    byte // Keys yet to be received from the peer.
+	localIdentity  *hci.Identity
+	remoteIdentity *hci.Identity
+	localCSRK      []byte
+	remoteCSRK     []byte
 
 	ble.Logger
 }
 
+// crypto returns the cryptographic functions the pairing uses.
+func (p *pairingContext) crypto() Crypto {
+	if p.cr == nil {
+		return DefaultCrypto
+	}
+	return p.cr
+}
+
+// localConfig and remoteConfig return the pairing features of the local and
+// the remote device.
+func (p *pairingContext) localConfig() hci.SmpConfig {
+	if p.responder {
+		return p.response
+	}
+	return p.request
+}
+
+func (p *pairingContext) remoteConfig() hci.SmpConfig {
+	if p.responder {
+		return p.request
+	}
+	return p.response
+}
+
+// addrs returns the addresses of the initiator and the responder, A and B,
+// each followed by its type.
+func (p *pairingContext) addrs() ([]byte, []byte) {
+	la := append(append([]byte{}, p.localAddr...), p.localAddrType)
+	ra := append(append([]byte{}, p.remoteAddr...), p.remoteAddrType)
+	if p.responder {
+		return ra, la
+	}
+	return la, ra
+}
+
+// nonces returns the random values of the initiator and the responder, Na and
+// Nb.
+func (p *pairingContext) nonces() ([]byte, []byte) {
+	if p.responder {
+		return p.remoteRandom, p.localRandom
+	}
+	return p.localRandom, p.remoteRandom
+}
+
+// publicKeysX returns the X coordinates of the public keys of the initiator
+// and the responder, PKax and PKbx.
+func (p *pairingContext) publicKeysX() ([]byte, []byte) {
+	lx := MarshalPublicKeyX(p.scECDHKeys.public)
+	rx := MarshalPublicKeyX(p.scRemotePubKey)
+	if p.responder {
+		return rx, lx
+	}
+	return lx, rx
+}
+
+// localInputsPasskey returns whether the local device inputs the passkey,
+// rather than displaying it, in Passkey Entry pairing.
+func (p *pairingContext) localInputsPasskey() bool {
+	init, resp := p.request.IoCap, p.response.IoCap
+	if !p.responder {
+		return passkeyInput(init, resp)
+	}
+	// Both input with keyboards only.
+	return !passkeyInput(init, resp) ||
+		init == hci.IoCapsKeyboardOnly && resp == hci.IoCapsKeyboardOnly
+}
+
 func (p *pairingContext) checkConfirm() error {
 	if p == nil {
 		return fmt.Errorf("context nil")
@@ -71,7 +150,7 @@ func (p *pairingContext) checkConfirm() error {
 	kax := MarshalPublicKeyX(p.scECDHKeys.public)
 	nb := p.remoteRandom
 
-	calcConf, err := smpF4(kbx, kax, nb, 0)
+	calcConf, err := p.crypto().F4(kbx, kax, nb, 0)
 	if err != nil {
 		return err
 	}
@@ -84,6 +163,24 @@ func (p *pairingContext) checkConfirm() error {
 	return nil
 }
 
+// checkOOBConfirm checks the confirm value of the OOB data of the peer, if
+// given, against its public key: Cb = f4(PKbx, PKbx, rb, 0).
+func (p *pairingContext) checkOOBConfirm() error {
+	if len(p.authData.OOBConfirm) == 0 || len(p.authData.OOBData) == 0 {
+		return nil
+	}
+	pkbx := MarshalPublicKeyX(p.scRemotePubKey)
+	c, err := p.crypto().F4(pkbx, pkbx, p.authData.OOBData, 0)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(c, p.authData.OOBConfirm) {
+		return fmt.Errorf("oob confirm mismatch, exp %v got %v",
+			hex.EncodeToString(p.authData.OOBConfirm), hex.EncodeToString(c))
+	}
+	return nil
+}
+
 func (p *pairingContext) checkPasskeyConfirm() error {
 	// make the keys work as expected
 	kbx := MarshalPublicKeyX(p.scRemotePubKey)
@@ -96,7 +193,7 @@ func (p *pairingContext) checkPasskeyConfirm() error {
 	z := 0x80 | (byte)((key&(1<<uint(i)))>>uint(i))
 
 	//Cb =f4(PKbx,PKax, Nb, rb)
-	calcConf, err := smpF4(kbx, kax, nb, z)
+	calcConf, err := p.crypto().F4(kbx, kax, nb, z)
 	if err != nil {
 		return err
 	}
@@ -128,7 +225,7 @@ func (p *pairingContext) generatePassKeyConfirm() ([]byte, []byte) {
 	i := p.passKeyIteration
 	z := 0x80 | (byte)((p.authData.Passkey&(1<<uint(i)))>>uint(i))
 
-	calcConf, err := smpF4(kax, kbx, nai, z)
+	calcConf, err := p.crypto().F4(kax, kbx, nai, z)
 	if err != nil {
 		p.Errorf("generatePasskeyConfirm: %v", err)
 	}
@@ -138,6 +235,14 @@ func (p *pairingContext) generatePassKeyConfirm() ([]byte, []byte) {
 	return calcConf, nai
 }
 
+// numericComparisonValue returns the 6-digit value of Numeric Comparison
+// pairing, Va = g2(PKax, PKbx, Na, Nb) mod 10^6.
+func (p *pairingContext) numericComparisonValue() (uint32, error) {
+	pkax, pkbx := p.publicKeysX()
+	na, nb := p.nonces()
+	return p.crypto().G2(pkax, pkbx, na, nb)
+}
+
 func (p *pairingContext) calcMacLtk() error {
 	err := p.generateDHKey()
 	if err != nil {
@@ -145,19 +250,15 @@ func (p *pairingContext) calcMacLtk() error {
 	}
 
 	// MacKey || LTK = f5(DHKey, N_master, N_slave, BD_ADDR_master,BD_ADDR_slave)
-	la := p.localAddr
-	la = append(la, p.localAddrType)
-	ra := p.remoteAddr
-	ra = append(ra, p.remoteAddrType)
-	na := p.localRandom
-	nb := p.remoteRandom
+	a, b := p.addrs()
+	na, nb := p.nonces()
 
-	mk, ltk, err := smpF5(p.scDHKey, na, nb, la, ra)
+	mk, ltk, err := p.crypto().F5(p.scDHKey, na, nb, a, b)
 	if err != nil {
 		return err
 	}
 
-	p.bond = hci.NewBondInfo(ltk, 0, 0, false)
+	p.bond = hci.NewBondInfo(p.maskKey(ltk), 0, 0, false)
 	p.scMacKey = mk
 
 	return nil
@@ -172,7 +273,8 @@ func (p *pairingContext) checkDHKeyCheck() error {
 	na := p.localRandom
 	nb := p.remoteRandom
 
-	ioCap := sliceops.SwapBuf([]byte{p.response.AuthReq, p.response.OobFlag, p.response.IoCap})
+	rc := p.remoteConfig()
+	ioCap := sliceops.SwapBuf([]byte{rc.AuthReq, rc.OobFlag, rc.IoCap})
 
 	ra := make([]byte, 16)
 	if p.pairingType == Passkey {
@@ -185,12 +287,13 @@ func (p *pairingContext) checkDHKeyCheck() error {
 
 		//swap to little endian
 		ra = sliceops.SwapBuf(ra)
-	} else if p.pairingType == Oob {
-		ra = p.authData.OOBData
-		//todo: does this need to be swapped?
+	} else if p.pairingType == Oob && rc.OobFlag == byte(hci.OobPreset) &&
+		p.oobRandom != nil {
+		// The peer has our OOB data.
+		ra = p.oobRandom
 	}
 
-	dhKeyCheck, err := smpF6(p.scMacKey, nb, na, ra, ioCap, rAddr, la)
+	dhKeyCheck, err := p.crypto().F6(p.scMacKey, nb, na, ra, ioCap, rAddr, la)
 	if err != nil {
 		return err
 	}
@@ -214,7 +317,12 @@ func (p *pairingContext) generateDHKey() error {
 
 	prv := p.scECDHKeys.private
 
-	dk, err := GenerateSecret(prv, p.scRemotePubKey)
+	// The debug keys are computed in software, whatever the crypto.
+	c := p.crypto()
+	if _, ok := prv.(rawPrivateKey); ok {
+		c = DefaultCrypto
+	}
+	dk, err := c.DHKey(prv, MarshalPublicKeyXY(p.scRemotePubKey))
 	if err != nil {
 		return err
 	}
@@ -223,32 +331,116 @@ func (p *pairingContext) generateDHKey() error {
 }
 
 func (p *pairingContext) checkLegacyConfirm() error {
+	c1, err := p.legacyConfirm(p.remoteRandom)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(p.remoteConfirm, c1) {
+		return fmt.Errorf("confirm does not match: exp %s calc %s",
+			hex.EncodeToString(p.remoteConfirm), hex.EncodeToString(c1))
+	}
+
+	return nil
+}
+
+// legacyConfirm returns the legacy pairing confirm value of the random value
+// r, c1(TK, r, preq, pres, iat, rat, ia, ra).
+func (p *pairingContext) legacyConfirm(r []byte) ([]byte, error) {
 	preq := buildPairingReq(p.request)
 	pres := buildPairingRsp(p.response)
-	la := p.localAddr
-	ra := p.remoteAddr
-	sRand := p.remoteRandom
 
 	k := make([]byte, 16)
 	if p.pairingType == Passkey {
 		k = getLegacyParingTK(p.authData.Passkey)
 	}
-	c1, err := smpC1(k, sRand, preq, pres,
-		p.localAddrType,
-		p.remoteAddrType,
-		la,
-		ra,
-	)
-	if err != nil {
-		return err
+
+	ia, iat, ra, rat := p.localAddr, p.localAddrType, p.remoteAddr, p.remoteAddrType
+	if p.responder {
+		ia, iat, ra, rat = ra, rat, ia, iat
+	}
+	return p.crypto().C1(k, r, preq, pres, iat, rat, ia, ra)
+}
+
+// resetKeys drops the keys distributed by an earlier pairing.
+func (p *pairingContext) resetKeys() {
+	p.bond, p.remoteIRK, p.remoteIdentity = nil, nil, nil
+	p.localCSRK, p.remoteCSRK = nil, nil
+	p.pendingKeys = 0
+}
+
+// localKeyDist masks out the identity key if there's no identity to
+// distribute.
+func (p *pairingContext) localKeyDist(keys byte) byte {
+	if p.localIdentity == nil {
+		keys &^= hci.KeyDistIdKey
 	}
+	return keys
+}
+
+// keyDist returns the keys distributed by the local and the remote device.
+func (p *pairingContext) keyDist() (byte, byte) {
+	local, remote := p.response.InitKeyDist, p.response.RespKeyDist
+	if p.responder {
+		local, remote = remote, local
+	}
+	if !p.legacy {
+		// The LTK is derived from the DHKey instead.
+		local &^= hci.KeyDistEncKey
+		remote &^= hci.KeyDistEncKey
+	}
+	return local, remote
+}
+
+// bondInfo returns the bond, along with the keys distributed besides the LTK.
+func (p *pairingContext) bondInfo() hci.BondInfo {
+	if p.bond == nil {
+		return nil
+	}
+	var sk *hci.SigningKeys
+	if p.localCSRK != nil || p.remoteCSRK != nil {
+		sk = &hci.SigningKeys{RemoteCSRK: p.remoteCSRK, LocalCSRK: p.localCSRK}
+	}
+	bi := p.bond
+	return hci.NewBondInfoWithKeys(bi.LongTermKey(), bi.EDiv(), bi.Random(), bi.Legacy(), p.remoteIdentity, sk)
+}
 
-	sConfirm := p.remoteConfirm
+// keySize returns the encryption key size of the pairing, the smaller of the
+// maximum key sizes of both devices.
+func (p *pairingContext) keySize() int {
+	n := p.request.MaxKeySize
+	if p.response.MaxKeySize < n {
+		n = p.response.MaxKeySize
+	}
+	return int(n)
+}
 
-	if !bytes.Equal(sConfirm, c1) {
-		return fmt.Errorf("sConfirm does not match: exp %s calc %s",
-			hex.EncodeToString(sConfirm), hex.EncodeToString(c1))
+// checkKeySize checks the key size of the pairing against the local policy.
+func (p *pairingContext) checkKeySize() error {
+	min := int(p.config.MinKeySize)
+	if min < hci.EncKeySizeMin {
+		min = hci.EncKeySizeMin
+	}
+	if n := p.keySize(); n < min || n > hci.EncKeySizeMax {
+		return fmt.Errorf("encryption key size %d, expected %d to %d", n, min, hci.EncKeySizeMax)
 	}
+	return nil
+}
 
+// maskKey shortens a key, least significant octet first, to the key size of
+// the pairing by zeroing its most significant octets. [Vol 3, Part H, 2.3.4]
+func (p *pairingContext) maskKey(k []byte) []byte {
+	for i := p.keySize(); i < len(k); i++ {
+		k[i] = 0
+	}
+	return k
+}
+
+// checkMITM fails Just Works pairing, which isn't protected against
+// man-in-the-middle attacks, if the local device requires the protection.
+func (p *pairingContext) checkMITM() error {
+	if p.localConfig().AuthReq&authReqMITM != 0 && p.pairingType == JustWorks {
+		return fmt.Errorf("pairing requires MITM protection, but the IO capabilities only allow for Just Works")
+	}
 	return nil
 }
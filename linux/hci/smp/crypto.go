@@ -1,6 +1,7 @@
 package smp
 
 import (
+	"crypto"
 	"encoding/binary"
 	"fmt"
 
@@ -8,7 +9,101 @@ import (
 	"github.com/pkg/errors"
 )
 
-func smpF4(u, v, x []byte, z uint8) ([]byte, error) {
+// Primitives are the cryptographic primitives the functions of the SMP are
+// built on. Keys, blocks and public keys are least significant octet first,
+// as the SMP PDUs carry them.
+//
+// Implement them to pair with hardware-backed keys, e.g. of a TPM or a
+// secure element, or with a validated cryptographic module, and turn them
+// into a Crypto with NewCrypto.
+type Primitives interface {
+	// E is the security function e, AES-128 encrypting the block msg with
+	// key [Vol 3, Part H, 2.2.1].
+	E(key, msg []byte) ([]byte, error)
+
+	// AESCMAC returns the AES-CMAC of msg with key [Vol 3, Part H, 2.2.5].
+	AESCMAC(key, msg []byte) ([]byte, error)
+
+	// GenerateKeys generates a P-256 key pair of LE Secure Connections.
+	// Keys whose private key doesn't leave the hardware are made with
+	// NewECDHKeys.
+	GenerateKeys() (*ECDHKeys, error)
+
+	// DHKey returns the X coordinate of the shared secret of the private
+	// key of GenerateKeys and the remote public key, X then Y.
+	DHKey(private crypto.PrivateKey, remote []byte) ([]byte, error)
+}
+
+// Crypto provides the cryptographic functions of the SMP [Vol 3, Part H,
+// 2.2], which a factory pairs with, as set by SetCrypto.
+type Crypto interface {
+	Primitives
+
+	// C1 is the confirm value generation function of legacy pairing.
+	C1(k, r, preq, pres []byte, iat, rat uint8, ia, ra []byte) ([]byte, error)
+
+	// S1 is the key generation function of legacy pairing.
+	S1(k, r1, r2 []byte) ([]byte, error)
+
+	// F4 is the confirm value generation function of LE Secure Connections.
+	F4(u, v, x []byte, z uint8) ([]byte, error)
+
+	// F5 is the key generation function of LE Secure Connections.
+	F5(w, n1, n2, a1, a2 []byte) (macKey, ltk []byte, err error)
+
+	// F6 is the check value generation function of LE Secure Connections.
+	F6(w, n1, n2, r, ioCap, a1, a2 []byte) ([]byte, error)
+
+	// G2 is the numeric comparison value generation function, modulo
+	// 10^6.
+	G2(u, v, x, y []byte) (uint32, error)
+}
+
+// NewCrypto returns the Crypto computing the functions of the SMP with the
+// primitives p.
+func NewCrypto(p Primitives) Crypto {
+	return functions{p}
+}
+
+// DefaultCrypto is the pure Go implementation of the functions.
+var DefaultCrypto = NewCrypto(goPrimitives{})
+
+// goPrimitives are the primitives of the Go standard library.
+type goPrimitives struct{}
+
+func (goPrimitives) E(key, msg []byte) ([]byte, error) {
+	out := aes128(sliceops.SwapBuf(key), sliceops.SwapBuf(msg))
+	if out == nil {
+		return nil, fmt.Errorf("failed to encrypt message")
+	}
+	return sliceops.SwapBuf(out), nil
+}
+
+func (goPrimitives) AESCMAC(key, msg []byte) ([]byte, error) {
+	return aesCMAC(key, msg)
+}
+
+func (goPrimitives) GenerateKeys() (*ECDHKeys, error) {
+	return GenerateKeys()
+}
+
+func (goPrimitives) DHKey(private crypto.PrivateKey, remote []byte) ([]byte, error) {
+	if len(remote) != 64 {
+		return nil, fmt.Errorf("invalid public key length %d", len(remote))
+	}
+	pub, ok := UnmarshalPublicKey(remote)
+	if !ok {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	return GenerateSecret(private, pub)
+}
+
+// functions computes the functions of the SMP with its primitives.
+type functions struct {
+	Primitives
+}
+
+func (f functions) F4(u, v, x []byte, z uint8) ([]byte, error) {
 	switch {
 	case len(u) != 32:
 		return nil, fmt.Errorf("length error u got %v, want 32", len(u))
@@ -24,10 +119,10 @@ func smpF4(u, v, x []byte, z uint8) ([]byte, error) {
 	m = append(m, v...)
 	m = append(m, u...)
 
-	return aesCMAC(x, m)
+	return f.AESCMAC(x, m)
 }
 
-func smpF5(w, n1, n2, a1, a2 []byte) ([]byte, []byte, error) {
+func (f functions) F5(w, n1, n2, a1, a2 []byte) ([]byte, []byte, error) {
 	switch {
 	case len(w) != 32:
 		return nil, nil, fmt.Errorf("length error w")
@@ -46,7 +141,7 @@ func smpF5(w, n1, n2, a1, a2 []byte) ([]byte, []byte, error) {
 		0x38, 0xa5, 0xf5, 0xaa, 0x91, 0x83, 0x88, 0x6c}
 	length := []byte{0x00, 0x01}
 
-	t, err := aesCMAC(salt, w)
+	t, err := f.AESCMAC(salt, w)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "generateF5Key")
 	}
@@ -59,7 +154,7 @@ func smpF5(w, n1, n2, a1, a2 []byte) ([]byte, []byte, error) {
 	m = append(m, btle...)
 	m = append(m, 0x00)
 
-	macKey, err := aesCMAC(t, m)
+	macKey, err := f.AESCMAC(t, m)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "generateMacKey")
 	}
@@ -67,7 +162,7 @@ func smpF5(w, n1, n2, a1, a2 []byte) ([]byte, []byte, error) {
 	//ltk generation bit
 	m[52] = 0x01
 
-	ltk, err := aesCMAC(t, m)
+	ltk, err := f.AESCMAC(t, m)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "generateLTK")
 	}
@@ -75,7 +170,7 @@ func smpF5(w, n1, n2, a1, a2 []byte) ([]byte, []byte, error) {
 	return macKey, ltk, nil
 }
 
-func smpF6(w, n1, n2, r, ioCap, a1, a2 []byte) ([]byte, error) {
+func (f functions) F6(w, n1, n2, r, ioCap, a1, a2 []byte) ([]byte, error) {
 	if len(w) != 16 || len(n1) != 16 || len(n2) != 16 || len(r) != 16 || len(ioCap) != 3 || len(a1) != 7 || len(a2) != 7 {
 		return nil, fmt.Errorf("length error")
 	}
@@ -87,10 +182,10 @@ func smpF6(w, n1, n2, r, ioCap, a1, a2 []byte) ([]byte, error) {
 	m = append(m, n2...)
 	m = append(m, n1...)
 
-	return aesCMAC(w, m)
+	return f.AESCMAC(w, m)
 }
 
-func smpG2(u, v, x, y []byte) (uint32, error) {
+func (f functions) G2(u, v, x, y []byte) (uint32, error) {
 	if len(u) != 32 || len(v) != 32 || len(x) != 16 || len(y) != 16 {
 		return 0, fmt.Errorf("length error")
 	}
@@ -99,7 +194,7 @@ func smpG2(u, v, x, y []byte) (uint32, error) {
 	m := append(y, v...)
 	m = append(m, u...)
 
-	h, err := aesCMAC(x, m)
+	h, err := f.AESCMAC(x, m)
 	if err != nil {
 		return 0, err
 	}
@@ -108,21 +203,8 @@ func smpG2(u, v, x, y []byte) (uint32, error) {
 	return uint32(out % 1000000), nil
 }
 
-//smpE: From Bluetooth Core Spec 5.0: Part H, Section 2, 2.2.1
-func smpE(key, msg []byte) ([]byte, error) {
-	tk := sliceops.SwapBuf(key)
-	msgMsb := sliceops.SwapBuf(msg)
-
-	out := aes128(tk, msgMsb)
-	if out == nil {
-		return nil, fmt.Errorf("failed to encrypt message")
-	}
-
-	return sliceops.SwapBuf(out), nil
-}
-
-//smpC1: From Bluetooth Core Spec 5.0: Part H, Section 2, 2.2.3
-func smpC1(k, r, preq, pres []byte, iatP, ratP uint8, la, ra []byte) ([]byte, error) {
+// C1: From Bluetooth Core Spec 5.0: Part H, Section 2, 2.2.3
+func (f functions) C1(k, r, preq, pres []byte, iatP, ratP uint8, la, ra []byte) ([]byte, error) {
 	//p1 = pres || preq || rat’ || iat’
 	p1 := []byte{iatP, ratP}
 	p1 = append(p1, preq...)
@@ -134,14 +216,14 @@ func smpC1(k, r, preq, pres []byte, iatP, ratP uint8, la, ra []byte) ([]byte, er
 	p2 = append(p2, []byte{0, 0, 0, 0}...)
 
 	rXorP1 := xorSlice(r, p1)
-	msg1, err := smpE(k, rXorP1)
+	msg1, err := f.E(k, rXorP1)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encrypt rxorp1: %s", err)
 	}
 
 	msg1XorP2 := xorSlice(msg1, p2)
 
-	out, err := smpE(k, msg1XorP2)
+	out, err := f.E(k, msg1XorP2)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encrypt msg1XorP2: %s", err)
 	}
@@ -149,7 +231,7 @@ func smpC1(k, r, preq, pres []byte, iatP, ratP uint8, la, ra []byte) ([]byte, er
 	return out, nil
 }
 
-func smpS1(k, r1, r2 []byte) ([]byte, error) {
+func (f functions) S1(k, r1, r2 []byte) ([]byte, error) {
 	switch {
 	case len(k) != 16:
 		return nil, fmt.Errorf("s1: invalid length for k: %d", len(k))
@@ -165,7 +247,7 @@ func smpS1(k, r1, r2 []byte) ([]byte, error) {
 	r = append(r, r2[:8]...)
 	r = append(r, r1[:8]...)
 
-	out, err := smpE(k, r)
+	out, err := f.E(k, r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encrypt r in S1: %s", err)
 	}
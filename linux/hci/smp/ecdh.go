@@ -4,6 +4,8 @@ import (
 	"crypto"
 	"crypto/elliptic"
 	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 
 	"github.com/leso-kn/ble/sliceops"
 	"github.com/wsddn/go-ecdh"
@@ -27,6 +29,40 @@ func GenerateKeys() (*ECDHKeys, error) {
 	return &kp, nil
 }
 
+// NewECDHKeys returns a key pair of the public key, X then Y, least
+// significant octet first, and of the private key, which is only passed
+// back to the DHKey of the Primitives generating it, e.g. a handle of a key
+// held by a secure element.
+func NewECDHKeys(public []byte, private crypto.PrivateKey) (*ECDHKeys, error) {
+	if len(public) != 64 {
+		return nil, fmt.Errorf("invalid public key length %d", len(public))
+	}
+	pub, ok := UnmarshalPublicKey(public)
+	if !ok {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	return &ECDHKeys{public: pub, private: private}, nil
+}
+
+// The debug key pair of LE Secure Connections, which lets sniffers decrypt the
+// traffic [Vol 3, Part H, 2.3.5.6.1]. It's for development only.
+const (
+	debugPrivateKey = "3f49f6d4a3c55f3874c9b3e3d2103f504aff607beb40b7995899b8a6cd3c1abd"
+	debugPublicKeyX = "20b003d2f297be2c5e2c83a7e9f9a5b9eff49111acf4fddbcc0301480e359de6"
+	debugPublicKeyY = "dc809c49652aeb6d63329abf5a52155c766345c28fed3024741c8ed01589d28b"
+)
+
+// rawPrivateKey is a P-256 private key, big-endian, which isn't generated.
+type rawPrivateKey []byte
+
+// DebugKeys returns the LE Secure Connections debug key pair.
+func DebugKeys() *ECDHKeys {
+	d, _ := hex.DecodeString(debugPrivateKey)
+	p, _ := hex.DecodeString("04" + debugPublicKeyX + debugPublicKeyY)
+	pub, _ := ecdh.NewEllipticECDH(elliptic.P256()).Unmarshal(p)
+	return &ECDHKeys{public: pub, private: rawPrivateKey(d)}
+}
+
 func UnmarshalPublicKey(b []byte) (crypto.PublicKey, bool) {
 	e := ecdh.NewEllipticECDH(elliptic.P256())
 	xs := sliceops.SwapBuf(b[:32])
@@ -66,7 +102,20 @@ func MarshalPublicKeyX(k crypto.PublicKey) []byte {
 
 func GenerateSecret(prv crypto.PrivateKey, pub crypto.PublicKey) ([]byte, error) {
 	e := ecdh.NewEllipticECDH(elliptic.P256())
-	b, err := e.GenerateSharedSecret(prv, pub)
-	b = sliceops.SwapBuf(b)
-	return b, err
+	var b []byte
+	if d, ok := prv.(rawPrivateKey); ok {
+		x, y := elliptic.Unmarshal(elliptic.P256(), e.Marshal(pub))
+		sx, _ := elliptic.P256().ScalarMult(x, y, d)
+		b = sx.Bytes()
+	} else {
+		var err error
+		if b, err = e.GenerateSharedSecret(prv, pub); err != nil {
+			return nil, err
+		}
+	}
+	// The leading zeros of the X coordinate are stripped.
+	if len(b) < 32 {
+		b = append(make([]byte, 32-len(b)), b...)
+	}
+	return sliceops.SwapBuf(b), nil
 }
@@ -1,22 +1,102 @@
 package smp
 
 import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+
 	"github.com/leso-kn/ble"
 	"github.com/leso-kn/ble/linux/hci"
 )
 
 type factory struct {
 	bm hci.BondManager
+
+	// Local OOB data, whose key pair and random value pairing uses.
+	oobMu     sync.Mutex
+	oobKeys   *ECDHKeys
+	oobRandom []byte
+
+	debugKeys bool   // Also guarded by oobMu.
+	crypto    Crypto // Also guarded by oobMu.
 }
 
 func NewSmpFactory(bm hci.BondManager) *factory {
-	return &factory{bm}
+	return &factory{bm: bm, crypto: DefaultCrypto}
 }
 
 func (f *factory) Create(config hci.SmpConfig, l ble.Logger) hci.SmpManager {
-	return NewSmpManager(config, f.bm, l)
+	m := NewSmpManager(config, f.bm, l)
+	f.oobMu.Lock()
+	m.pairing.cr = f.crypto
+	m.pairing.scECDHKeys = f.oobKeys
+	m.pairing.oobRandom = f.oobRandom
+	if f.debugKeys && f.oobKeys == nil {
+		l.Warnf("smp: pairing with the insecure debug keys")
+		m.pairing.scECDHKeys = DebugKeys()
+	}
+	f.oobMu.Unlock()
+	return m
+}
+
+// SetDebugKeys has LE Secure Connections pairing use the debug key pair,
+// which lets sniffers decrypt the traffic.
+func (f *factory) SetDebugKeys(enable bool) {
+	f.oobMu.Lock()
+	f.debugKeys = enable
+	f.oobMu.Unlock()
+}
+
+// SetCrypto sets the cryptographic functions pairing uses, a Crypto, e.g.
+// made with NewCrypto of hardware-backed primitives. Connections created
+// from then on use them.
+func (f *factory) SetCrypto(c interface{}) error {
+	cr, ok := c.(Crypto)
+	if !ok {
+		return fmt.Errorf("unknown smp crypto type %T", c)
+	}
+	f.oobMu.Lock()
+	f.crypto = cr
+	f.oobMu.Unlock()
+	return nil
 }
 
 func (f *factory) SetBondManager(bm hci.BondManager) {
 	f.bm = bm
 }
+
+// LocalOOBData generates a key pair and random value for LE Secure
+// Connections OOB pairing, and returns the OOB data committing to them.
+// Connections created from then on pair with them; data generated earlier
+// is no longer valid.
+func (f *factory) LocalOOBData() (*ble.OOBData, error) {
+	f.oobMu.Lock()
+	debug, cr := f.debugKeys, f.crypto
+	f.oobMu.Unlock()
+	keys := DebugKeys()
+	if !debug {
+		var err error
+		if keys, err = cr.GenerateKeys(); err != nil {
+			return nil, err
+		}
+	}
+	r := make([]byte, 16)
+	if _, err := rand.Read(r); err != nil {
+		return nil, err
+	}
+	pkx := MarshalPublicKeyX(keys.public)
+	c, err := cr.F4(pkx, pkx, r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &ble.OOBData{}
+	copy(d.Random[:], r)
+	copy(d.Confirm[:], c)
+	copy(d.PublicKey[:], MarshalPublicKeyXY(keys.public))
+
+	f.oobMu.Lock()
+	f.oobKeys, f.oobRandom = keys, r
+	f.oobMu.Unlock()
+	return d, nil
+}
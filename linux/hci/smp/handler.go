@@ -5,26 +5,13 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"net"
 
+	"github.com/leso-kn/ble"
 	"github.com/leso-kn/ble/linux/hci"
+	"github.com/leso-kn/ble/sliceops"
 )
 
-//func smpOnPairingRequest(c *Conn, in pdu) ([]byte, error) {
-//	if len(in) < 6 {
-//		return nil, fmt.Errorf("%v, invalid length %v", hex.EncodeToString(in), len(in))
-//	}
-//
-//	rx := smpConfig{}
-//	rx.ioCap = in[0]
-//	rx.oobFlag = in[1]
-//	rx.authReq = in[2]
-//	rx.maxKeySz = in[3]
-//	rx.initKeyDist = in[4]
-//	rx.respKeyDist = in[5]
-//
-//	return nil, nil
-//}
-
 func smpOnPairingResponse(t *transport, in pdu) ([]byte, error) {
 	if len(in) < 6 {
 		return nil, fmt.Errorf("%v, invalid length %v", hex.EncodeToString(in), len(in))
@@ -39,11 +26,17 @@ func smpOnPairingResponse(t *transport, in pdu) ([]byte, error) {
 	rx.RespKeyDist = in[5]
 	t.pairing.response = rx
 
+	if err := t.pairing.checkKeySize(); err != nil {
+		t.send([]byte{pairingFailed, encryptionKeySizeFailed})
+		return nil, err
+	}
+
 	t.pairing.pairingType = JustWorks
 	t.pairing.passKeyIteration = 0
 
 	t.pairing.legacy = isLegacy(rx.AuthReq)
 	t.pairing.pairingType = determinePairingType(t)
+	_, t.pairing.pendingKeys = t.pairing.keyDist()
 
 	pts, ok := pairingTypeStrings[t.pairing.pairingType]
 	if !ok {
@@ -51,23 +44,22 @@ func smpOnPairingResponse(t *transport, in pdu) ([]byte, error) {
 	}
 	t.Infof("smpOnPairingResponse: detected pairing type '%v'", pts)
 
+	if err := t.pairing.checkMITM(); err != nil {
+		t.send([]byte{pairingFailed, authRequirementsFailed})
+		return nil, err
+	}
+
 	if t.pairing.pairingType == Oob &&
 		len(t.pairing.authData.OOBData) == 0 {
 		t.pairing.state = Error
 		return nil, fmt.Errorf("pairing requires OOB data but OOB data not specified")
 	}
 
-	if t.pairing.customPairingHandler != nil {
-		// Short term pairing is done.
-		// Next: Perform custom pairing logic.
-		t.pairing.state = Init
-		*t.pairing.customPairingHandler <- true
-		return nil, nil
-	} else if t.pairing.legacy {
-		return nil, t.sendMConfirm()
+	if t.pairing.legacy {
+		return nil, t.withPasskey(t.sendLegacyConfirm)
 	}
 
-	return nil, t.sendPublicKey()
+	return nil, t.withPasskey(t.sendPublicKey)
 }
 
 func smpOnPairingConfirm(t *transport, in pdu) ([]byte, error) {
@@ -80,6 +72,9 @@ func smpOnPairingConfirm(t *transport, in pdu) ([]byte, error) {
 	}
 
 	t.pairing.remoteConfirm = in
+	if t.pairing.responder {
+		return nil, onResponderConfirm(t)
+	}
 
 	err := t.sendPairingRandom()
 	if err != nil {
@@ -99,6 +94,9 @@ func smpOnPairingRandom(t *transport, in pdu) ([]byte, error) {
 	}
 
 	t.pairing.remoteRandom = in
+	if t.pairing.responder {
+		return nil, onResponderRandom(t)
+	}
 
 	//conf check
 	if t.pairing.legacy {
@@ -118,7 +116,7 @@ func onSecureRandom(t *transport) ([]byte, error) {
 		if more {
 			return nil, nil
 		}
-	} else {
+	} else if t.pairing.pairingType != Oob {
 		err := t.pairing.checkConfirm()
 		if err != nil {
 			t.Errorf("smpOnSecureRandom: checkConfirm - %v", err)
@@ -126,23 +124,59 @@ func onSecureRandom(t *transport) ([]byte, error) {
 		}
 	}
 
-	// TODO
-	// here we would do the compare from g2(...) but this is just works only for now
-	// move on to auth stage 2 (2.3.5.6.5) calc mackey, ltk
+	if t.pairing.pairingType == NumericComp &&
+		t.pairing.authData.ConfirmNumericComparison != nil {
+		return nil, confirmNumericComparison(t, func() error { return finishSecureAuth(t) })
+	}
+
+	return nil, finishSecureAuth(t)
+}
+
+// finishSecureAuth moves on to authentication stage 2 (2.3.5.6.5),
+// calculating the MacKey and LTK, and sending the DHKey check.
+func finishSecureAuth(t *transport) error {
 	err := t.pairing.calcMacLtk()
 	if err != nil {
 		t.Errorf("smpOnSecureRandom: calcMacLtk - %v", err)
-		return nil, err
+		return err
 	}
 
 	//send dhkey check
 	err = t.sendDHKeyCheck()
 	if err != nil {
 		t.Errorf("smpOnSecureRandom: sendDHKeyCheck - %v", err)
-		return nil, err
+		return err
 	}
 
-	return nil, nil
+	return nil
+}
+
+// confirmNumericComparison has the user confirm the comparison value, and
+// then calls next to finish authentication stage 1. The user may take a
+// while to answer, so the pairing continues once they do.
+func confirmNumericComparison(t *transport, next func() error) error {
+	v, err := t.pairing.numericComparisonValue()
+	if err != nil {
+		return err
+	}
+	c := ble.NumericComparison{
+		Peer:  ble.NewAddr(net.HardwareAddr(sliceops.SwapBuf(t.pairing.remoteAddr)).String()),
+		Value: int(v),
+	}
+	go func() {
+		ok := t.pairing.authData.ConfirmNumericComparison(c)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if !ok {
+			t.send([]byte{pairingFailed, numericComparisonFailed})
+			t.fail(fmt.Errorf("numeric comparison %06d rejected", c.Value))
+			return
+		}
+		if err := next(); err != nil {
+			t.fail(err)
+		}
+	}()
+	return nil
 }
 
 func onLegacyRandom(t *transport) ([]byte, error) {
@@ -151,8 +185,7 @@ func onLegacyRandom(t *transport) ([]byte, error) {
 		return nil, err
 	}
 
-	lRand := t.pairing.localRandom
-	rRand := t.pairing.remoteRandom
+	na, nb := t.pairing.nonces()
 
 	//calculate STK
 	var k []byte
@@ -162,15 +195,12 @@ func onLegacyRandom(t *transport) ([]byte, error) {
 		k = getLegacyParingTK(0)
 	}
 
-	stk, err := smpS1(k, rRand, lRand)
+	stk, err := t.pairing.crypto().S1(k, nb, na)
 	if err != nil {
 		return nil, err
 	}
-	t.pairing.shortTermKey = stk
-
-	if t.pairing.request.AuthReq&authReqBondMask == authReqNoBond {
-		t.pairing.state = Finished
-	}
+	t.pairing.shortTermKey = t.pairing.maskKey(stk)
+	t.pairing.state = WaitEncryption
 
 	err = t.encrypter.Encrypt()
 	return nil, err
@@ -199,9 +229,20 @@ func smpOnPairingPublicKey(t *transport, in pdu) ([]byte, error) {
 	}
 
 	t.pairing.scRemotePubKey = pubk
+	if t.pairing.responder {
+		return nil, onResponderPublicKey(t)
+	}
 
 	if t.pairing.pairingType == Passkey {
 		startPassKeyPairing(t)
+	} else if t.pairing.pairingType == Oob {
+		// No confirm values are exchanged in band; the one of the peer is
+		// from its OOB data.
+		if err := t.pairing.checkOOBConfirm(); err != nil {
+			t.send([]byte{pairingFailed, confirmValueFailed})
+			return nil, err
+		}
+		return nil, t.sendPairingRandom()
 	}
 	return nil, nil
 }
@@ -212,6 +253,11 @@ func smpOnDHKeyCheck(t *transport, in pdu) ([]byte, error) {
 	}
 
 	t.pairing.scRemoteDHKeyCheck = in
+	if t.pairing.responder {
+		// Numeric Comparison holds the check back until the user confirmed.
+		return nil, t.pairing.user.do(func() error { return onResponderDHKeyCheck(t) })
+	}
+
 	err := t.pairing.checkDHKeyCheck()
 	if err != nil {
 		//dhkeycheck failed!
@@ -219,13 +265,7 @@ func smpOnDHKeyCheck(t *transport, in pdu) ([]byte, error) {
 	}
 
 	t.Debugf("dhKeyCheck: OK")
-	err = t.saveBondInfo()
-	if err != nil {
-		return nil, err
-	}
-
-	//at this point, the pairing is complete
-	t.pairing.state = Finished
+	t.pairing.state = WaitEncryption
 
 	//todo: separate this out
 	return nil, t.encrypter.Encrypt()
@@ -275,21 +315,57 @@ func smpOnEncryptionInformation(t *transport, in pdu) ([]byte, error) {
 }
 
 func smpOnMasterIdentification(t *transport, in pdu) ([]byte, error) {
+	if len(in) != 10 {
+		return nil, fmt.Errorf("%v, invalid length %v", hex.EncodeToString(in), len(in))
+	}
+	if t.pairing.bond == nil {
+		return nil, fmt.Errorf("master id received without encryption information")
+	}
+
 	data := []byte(in)
 	ediv := binary.LittleEndian.Uint16(data[:2])
 	randVal := binary.LittleEndian.Uint64(data[2:])
 
 	ltk := t.pairing.bond.LongTermKey()
 	t.pairing.bond = hci.NewBondInfo(ltk, ediv, randVal, true)
+	return nil, t.keysReceived(hci.KeyDistEncKey)
+}
 
-	if err := t.saveBondInfo(); err != nil {
-		return nil, err
+func smpOnIdentityInformation(t *transport, in pdu) ([]byte, error) {
+	if len(in) != 16 {
+		return nil, fmt.Errorf("%v, invalid length %v", hex.EncodeToString(in), len(in))
 	}
 
-	t.pairing.state = Finished
+	t.pairing.remoteIRK = append([]byte{}, in...)
 	return nil, nil
 }
 
+func smpOnIdentityAddrInformation(t *transport, in pdu) ([]byte, error) {
+	if len(in) != 7 {
+		return nil, fmt.Errorf("%v, invalid length %v", hex.EncodeToString(in), len(in))
+	}
+
+	if t.pairing.remoteIRK == nil {
+		return nil, fmt.Errorf("identity address received without identity resolving key")
+	}
+
+	t.pairing.remoteIdentity = &hci.Identity{
+		IRK:      t.pairing.remoteIRK,
+		Addr:     sliceops.SwapBuf(in[1:]),
+		AddrType: in[0],
+	}
+	return nil, t.keysReceived(hci.KeyDistIdKey)
+}
+
+func smpOnSigningInformation(t *transport, in pdu) ([]byte, error) {
+	if len(in) != 16 {
+		return nil, fmt.Errorf("%v, invalid length %v", hex.EncodeToString(in), len(in))
+	}
+
+	t.pairing.remoteCSRK = append([]byte{}, in...)
+	return nil, t.keysReceived(hci.KeyDistSignKey)
+}
+
 func handlePassKeyRandom(t *transport) (bool, error) {
 	err := t.pairing.checkPasskeyConfirm()
 	if err != nil {
@@ -339,8 +415,6 @@ var ioCapsTableLegacy = [][]int{
 }
 
 func determinePairingType(t *transport) int {
-	mitmMask := byte(0x04)
-
 	req := t.pairing.request
 	rsp := t.pairing.response
 
@@ -352,8 +426,8 @@ func determinePairingType(t *transport) int {
 		return Oob
 	}
 
-	if req.AuthReq&mitmMask == 0x00 &&
-		rsp.AuthReq&mitmMask == 0x00 {
+	if req.AuthReq&authReqMITM == 0x00 &&
+		rsp.AuthReq&authReqMITM == 0x00 {
 		return JustWorks
 	}
 
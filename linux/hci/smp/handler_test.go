@@ -2,8 +2,14 @@ package smp
 
 import (
 	"bytes"
+	"crypto/elliptic"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci"
+	"github.com/leso-kn/ble/sliceops"
 )
 
 func TestOnSmpPairingPublicKey(t *testing.T) {
@@ -53,3 +59,193 @@ func TestOnSmpPairingPublicKeyMatchingRemoteKey(t *testing.T) {
 		t.Fatal("failed to detected remote public key matching local public key")
 	}
 }
+
+func TestPasskeyInput(t *testing.T) {
+	for _, tc := range []struct {
+		init, resp byte
+		input      bool
+	}{
+		{hci.IoCapsKeyboardOnly, hci.IoCapsDisplayOnly, true},
+		{hci.IoCapsKeyboardOnly, hci.IoCapsKeyboardOnly, true},
+		{hci.IoCapsDisplayOnly, hci.IoCapsKeyboardOnly, false},
+		{hci.IoCapsDisplayYesNo, hci.IoCapsKeyboardDisplay, false},
+		{hci.IoCapsKeyboardDisplay, hci.IoCapsDisplayYesNo, true},
+		{hci.IoCapsKeyboardDisplay, hci.IoCapsKeyboardOnly, false},
+		{hci.IoCapsKeyboardDisplay, hci.IoCapsKeyboardDisplay, false},
+	} {
+		if in := passkeyInput(tc.init, tc.resp); in != tc.input {
+			t.Errorf("init %v, resp %v: input %v, want %v", tc.init, tc.resp, in, tc.input)
+		}
+	}
+}
+
+func TestWithPasskey(t *testing.T) {
+	newTransport := func(init, resp byte, ad ble.AuthData) *transport {
+		p := &pairingContext{pairingType: Passkey, authData: ad}
+		p.request.IoCap, p.response.IoCap = init, resp
+		return &transport{
+			pairing:  p,
+			writePDU: func(b []byte) (int, error) { return len(b), nil },
+			result:   make(chan error, 1),
+		}
+	}
+
+	shown := -1
+	tr := newTransport(hci.IoCapsDisplayOnly, hci.IoCapsKeyboardOnly, ble.AuthData{
+		DisplayPasskey: func(n int) { shown = n },
+	})
+	called := false
+	if err := tr.withPasskey(func() error { called = true; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if !called || shown < 0 || shown > passkeyMax || shown != tr.pairing.authData.Passkey {
+		t.Fatalf("display: called %v, shown %v, passkey %v", called, shown, tr.pairing.authData.Passkey)
+	}
+
+	tr = newTransport(hci.IoCapsKeyboardOnly, hci.IoCapsDisplayOnly, ble.AuthData{
+		InputPasskey: func() (int, error) { return 123456, nil },
+	})
+	done := make(chan struct{})
+	if err := tr.withPasskey(func() error { close(done); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("input: next not called")
+	}
+	if tr.pairing.authData.Passkey != 123456 {
+		t.Fatalf("input: passkey %v", tr.pairing.authData.Passkey)
+	}
+
+	tr = newTransport(hci.IoCapsKeyboardOnly, hci.IoCapsDisplayOnly, ble.AuthData{
+		InputPasskey: func() (int, error) { return 1000000, nil },
+	})
+	if err := tr.withPasskey(func() error { t.Error("next called"); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-tr.result:
+		if err == nil || tr.pairing.state != Error {
+			t.Fatalf("invalid input: err %v, state %v", err, tr.pairing.state)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("invalid input: no result")
+	}
+}
+
+func TestConfirmNumericComparisonReject(t *testing.T) {
+	local, err := GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []byte
+	got := make(chan ble.NumericComparison, 1)
+	tr := &transport{
+		pairing: &pairingContext{
+			pairingType:    NumericComp,
+			scECDHKeys:     local,
+			scRemotePubKey: remote.public,
+			localRandom:    bytes.Repeat([]byte{0x01}, 16),
+			remoteRandom:   bytes.Repeat([]byte{0x02}, 16),
+			remoteAddr:     []byte{0x66, 0x55, 0x44, 0x33, 0x22, 0x11},
+			authData: ble.AuthData{
+				ConfirmNumericComparison: func(c ble.NumericComparison) bool {
+					got <- c
+					return false
+				},
+			},
+		},
+		writePDU: func(b []byte) (int, error) { sent = b; return len(b), nil },
+		result:   make(chan error, 1),
+	}
+
+	if err := confirmNumericComparison(tr, func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	c := <-got
+	if c.Peer.String() != "11:22:33:44:55:66" || c.Value < 0 || c.Value > 999999 {
+		t.Fatalf("comparison %+v", c)
+	}
+	select {
+	case err := <-tr.result:
+		if err == nil {
+			t.Fatal("rejected comparison didn't fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no result")
+	}
+	if !bytes.HasSuffix(sent, []byte{pairingFailed, numericComparisonFailed}) {
+		t.Fatalf("sent % X", sent)
+	}
+}
+
+func TestLocalOOBData(t *testing.T) {
+	f := NewSmpFactory(nil)
+	d, err := f.LocalOOBData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := f.Create(hci.SmpConfig{}, nil).(*manager)
+	if !bytes.Equal(MarshalPublicKeyXY(m.pairing.scECDHKeys.public), d.PublicKey[:]) ||
+		!bytes.Equal(m.pairing.oobRandom, d.Random[:]) {
+		t.Fatal("pairing doesn't use the local oob data")
+	}
+
+	// The peer checks the confirm value against our public key.
+	pk, ok := UnmarshalPublicKey(d.PublicKey[:])
+	if !ok {
+		t.Fatal("invalid public key")
+	}
+	p := &pairingContext{
+		scRemotePubKey: pk,
+		authData:       ble.AuthData{OOBData: d.Random[:], OOBConfirm: d.Confirm[:]},
+	}
+	if err := p.checkOOBConfirm(); err != nil {
+		t.Fatal(err)
+	}
+	p.authData.OOBConfirm = append([]byte{d.Confirm[0] ^ 0xFF}, d.Confirm[1:]...)
+	if err := p.checkOOBConfirm(); err == nil {
+		t.Fatal("tampered confirm value accepted")
+	}
+}
+
+func TestDebugKeys(t *testing.T) {
+	k := DebugKeys()
+	d := []byte(k.private.(rawPrivateKey))
+	x, y := elliptic.P256().ScalarBaseMult(d)
+	exp := append(sliceops.SwapBuf(x.Bytes()), sliceops.SwapBuf(y.Bytes())...)
+	if pk := MarshalPublicKeyXY(k.public); !bytes.Equal(pk, exp) {
+		t.Fatalf("debug public key %X, expected %X", pk, exp)
+	}
+
+	// Both sides agree on the DHKey with either kind of private key.
+	other, err := GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1, err := GenerateSecret(k.private, other.public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := GenerateSecret(other.private, k.public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s1) != 32 || !bytes.Equal(s1, s2) {
+		t.Errorf("dhkeys %X and %X", s1, s2)
+	}
+
+	f := NewSmpFactory(nil)
+	f.SetDebugKeys(true)
+	m := f.Create(hci.SmpConfig{}, ble.GetLogger()).(*manager)
+	if !bytes.Equal(MarshalPublicKeyXY(m.pairing.scECDHKeys.public), exp) {
+		t.Error("pairing doesn't use the debug keys")
+	}
+}
@@ -19,10 +19,25 @@ const (
 	WaitConfirm
 	WaitRandom
 	WaitDhKeyCheck
+	WaitEncryption
+	WaitKeys
 	Finished
 	Error
 )
 
+var pairingStateNames = []string{
+	"init", "wait pairing response", "wait public key", "wait confirm",
+	"wait random", "wait DHKey check", "wait encryption", "wait keys",
+	"finished", "error",
+}
+
+func (s PairingState) String() string {
+	if s < 0 || int(s) >= len(pairingStateNames) {
+		return fmt.Sprintf("PairingState(%d)", int(s))
+	}
+	return pairingStateNames[s]
+}
+
 type manager struct {
 	config      hci.SmpConfig
 	pairing     *pairingContext
@@ -36,15 +51,27 @@ type manager struct {
 //todo: need to have on instance per connection which requires a mutex in the bond manager
 //todo: remove bond manager from input parameters?
 func NewSmpManager(config hci.SmpConfig, bm hci.BondManager, l ble.Logger) *manager {
-	p := &pairingContext{request: config, state: Init, Logger: l}
-	m := &manager{config: config, pairing: p, bondManager: bm, result: make(chan error), Logger: l}
+	p := &pairingContext{config: config, request: config, state: Init, Logger: l}
+	m := &manager{config: config, pairing: p, bondManager: bm, result: make(chan error, 1), Logger: l}
 	t := NewSmpTransport(p, bm, m, nil, nil, l)
+	t.result = m.result
 	m.t = t
 	return m
 }
 
 func (m *manager) SetConfig(config hci.SmpConfig) {
 	m.config = config
+	m.pairing.config = config
+}
+
+func (m *manager) PairingState() string {
+	m.t.mu.Lock()
+	defer m.t.mu.Unlock()
+	return m.pairing.state.String()
+}
+
+func (m *manager) SetAuthData(ad ble.AuthData) {
+	m.pairing.authData = ad
 }
 
 func (m *manager) SetWritePDUFunc(w func([]byte) (int, error)) {
@@ -78,39 +105,52 @@ func (m *manager) Handle(in []byte) error {
 	payload := p.payload()
 	code := payload[0]
 	data := payload[1:]
+	m.t.mu.Lock()
+	defer m.t.mu.Unlock()
 	v, ok := dispatcher[code]
 	if !ok || v.handler == nil {
 		m.Errorf("smp: unhandled smp code %v", code)
 
 		// C.5.1 Pairing Not Supported
-		return m.t.send([]byte{pairingFailed, 0x05})
+		return m.t.send([]byte{pairingFailed, pairingNotSupported})
 	}
 
 	_, err := v.handler(m.t, data)
 	if err != nil {
-		m.t.pairing.state = Error
-		m.result <- err
+		m.t.fail(err)
 		return err
 	}
 
-	if m.t.pairing.state == Finished {
-		select {
-		case <-m.result:
-		default:
-			close(m.result)
-		}
-	}
-
 	return nil
 }
 
 func (m *manager) Pair(authData ble.AuthData, to time.Duration) error {
-	if m.t.pairing.state != Init {
+	m.t.mu.Lock()
+	switch m.t.pairing.state {
+	case Init:
+	case Finished, Error:
+		// Pair again, e.g. after the peer initiated pairing.
+		m.result = make(chan error, 1)
+		m.t.result = m.result
+	default:
+		m.t.mu.Unlock()
 		return fmt.Errorf("Pairing already in progress")
 	}
 
 	//todo: can this be made less bad??
 	m.t.pairing = m.pairing
+	m.t.pairing.responder = false
+	m.t.pairing.request = m.pairing.config
+	if f := authData.Features; f != nil {
+		c, err := m.pairing.config.WithFeatures(*f)
+		if err != nil {
+			m.t.mu.Unlock()
+			return err
+		}
+		m.t.pairing.request = c
+	}
+	m.t.pairing.request.InitKeyDist = m.pairing.localKeyDist(m.pairing.config.InitKeyDist)
+	m.t.pairing.resetKeys()
 	m.t.pairing.authData = authData
 
 	//set a default timeout
@@ -123,6 +163,7 @@ func (m *manager) Pair(authData ble.AuthData, to time.Duration) error {
 	}
 
 	err := m.t.StartPairing(to)
+	m.t.mu.Unlock()
 	if err != nil {
 		return err
 	}
@@ -130,15 +171,21 @@ func (m *manager) Pair(authData ble.AuthData, to time.Duration) error {
 	return m.waitResult(to)
 }
 
-func (m *manager) PrepareCustomPairing(ch chan bool) {
-	m.pairing.customPairingHandler = &ch
-}
-
 func (m *manager) waitResult(to time.Duration) error {
 	select {
 	case err := <-m.result:
 		return err
 	case <-time.After(to):
+		// Give up on the pairing, so it can be tried again.
+		m.t.mu.Lock()
+		defer m.t.mu.Unlock()
+		select {
+		case err := <-m.result:
+			return err
+		default:
+		}
+		m.t.pairing.state = Error
+		m.t.stopTimer()
 		return fmt.Errorf("pairing operation timed out")
 	}
 }
@@ -178,6 +225,59 @@ func (m *manager) LegacyPairingInfo() (bool, []byte) {
 	return false, nil
 }
 
+// LongTermKey returns the key to encrypt the link with when the peer starts
+// encryption: the STK or LTK of the pairing in progress, or the LTK of the
+// bond with the peer, if the EDIV and Rand match.
+func (m *manager) LongTermKey(ediv uint16, rand uint64) []byte {
+	m.t.mu.Lock()
+	defer m.t.mu.Unlock()
+	p := m.pairing
+	if p.responder && p.state == WaitEncryption {
+		if p.legacy {
+			return p.shortTermKey
+		}
+		return p.bond.LongTermKey()
+	}
+
+	if m.bondManager == nil {
+		return nil
+	}
+	bi, err := m.bondManager.Find(hex.EncodeToString(p.remoteAddr))
+	if err != nil || bi.EDiv() != ediv || bi.Random() != rand {
+		return nil
+	}
+	return bi.LongTermKey()
+}
+
+// EncryptionChanged moves on to key distribution, once the link is encrypted
+// while pairing. The responder distributes its keys first.
+func (m *manager) EncryptionChanged() {
+	m.t.mu.Lock()
+	defer m.t.mu.Unlock()
+	if m.pairing.state != WaitEncryption {
+		return
+	}
+	if m.pairing.responder {
+		if err := m.t.distributeKeys(); err != nil {
+			m.Errorf("encryptionChanged: distributeKeys - %v", err)
+			m.t.fail(err)
+			return
+		}
+	}
+	m.pairing.state = WaitKeys
+	if err := m.t.keysReceived(0); err != nil {
+		m.Errorf("encryptionChanged: %v", err)
+		m.t.fail(err)
+	}
+}
+
+// SetLocalIdentity sets the identity distributed to the peer.
+func (m *manager) SetLocalIdentity(id *hci.Identity) {
+	m.t.mu.Lock()
+	defer m.t.mu.Unlock()
+	m.pairing.localIdentity = id
+}
+
 func (m *manager) EnableEncryption(addr string) error {
 	return m.encrypt(m.pairing.bond)
 }
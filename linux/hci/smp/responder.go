@@ -0,0 +1,305 @@
+package smp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci"
+	"github.com/leso-kn/ble/sliceops"
+)
+
+// userGate holds back a step of the pairing until the user has answered,
+// e.g. typed in the passkey, as the peer may move on meanwhile.
+type userGate struct {
+	mu      sync.Mutex
+	closed  bool
+	pending func() error
+}
+
+// reset opens the gate, dropping the step held back, if any.
+func (g *userGate) reset() {
+	g.mu.Lock()
+	g.closed, g.pending = false, nil
+	g.mu.Unlock()
+}
+
+func (g *userGate) close() {
+	g.mu.Lock()
+	g.closed, g.pending = true, nil
+	g.mu.Unlock()
+}
+
+// do calls f, or holds it back until the gate is opened, if it's closed.
+func (g *userGate) do(f func() error) error {
+	g.mu.Lock()
+	if g.closed {
+		g.pending = f
+		g.mu.Unlock()
+		return nil
+	}
+	g.mu.Unlock()
+	return f()
+}
+
+// open opens the gate, and calls the step held back, if any.
+func (g *userGate) open() error {
+	g.mu.Lock()
+	f := g.pending
+	g.closed, g.pending = false, nil
+	g.mu.Unlock()
+	if f == nil {
+		return nil
+	}
+	return f()
+}
+
+// responseConfig returns the pairing features to respond to the request of an
+// initiator with.
+func responseConfig(local, req hci.SmpConfig, oob bool) hci.SmpConfig {
+	rsp := local
+	rsp.OobFlag = byte(hci.OobNotPresent)
+	if oob {
+		rsp.OobFlag = byte(hci.OobPreset)
+	}
+	if req.AuthReq&authReqBondMask != authReqBond {
+		rsp.AuthReq &^= authReqBondMask
+	}
+	if req.MaxKeySize < rsp.MaxKeySize {
+		rsp.MaxKeySize = req.MaxKeySize
+	}
+
+	// An LTK distributed by the initiator is for the reverse roles, which
+	// isn't kept, as a bond holds a single LTK.
+	rsp.InitKeyDist = req.InitKeyDist & local.InitKeyDist &^ hci.KeyDistEncKey
+	rsp.RespKeyDist = req.RespKeyDist & local.RespKeyDist
+	return rsp
+}
+
+func smpOnPairingRequest(t *transport, in pdu) ([]byte, error) {
+	if len(in) < 6 {
+		return nil, fmt.Errorf("%v, invalid length %v", hex.EncodeToString(in), len(in))
+	}
+
+	p := t.pairing
+	p.responder = true
+	p.request = hci.SmpConfig{
+		IoCap:       in[0],
+		OobFlag:     in[1],
+		AuthReq:     in[2],
+		MaxKeySize:  in[3],
+		InitKeyDist: in[4],
+		RespKeyDist: in[5],
+	}
+	local := p.config
+	local.RespKeyDist = p.localKeyDist(local.RespKeyDist)
+	if f := p.authData.AcceptPairing; f != nil {
+		d := f(p.pairingRequest())
+		if d.Reject {
+			reason := d.Reason
+			if reason == 0 {
+				reason = pairingNotSupported
+			}
+			t.send([]byte{pairingFailed, reason})
+			return nil, fmt.Errorf("pairing rejected, reason 0x%02X", reason)
+		}
+		if d.Confirm {
+			local.AuthReq |= authReqMITM
+		}
+	}
+	p.response = responseConfig(local, p.request, len(p.authData.OOBData) > 0)
+	if err := p.checkKeySize(); err != nil {
+		t.send([]byte{pairingFailed, encryptionKeySizeFailed})
+		return nil, err
+	}
+
+	p.localRandom, p.remoteRandom, p.remoteConfirm = nil, nil, nil
+	p.scRemotePubKey, p.scDHKey, p.scMacKey, p.scRemoteDHKeyCheck = nil, nil, nil, nil
+	p.shortTermKey = nil
+	p.passKeyIteration = 0
+	p.resetKeys()
+
+	p.legacy = isLegacy(p.request.AuthReq) || isLegacy(p.response.AuthReq)
+	p.pairingType = determinePairingType(t)
+	_, p.pendingKeys = p.keyDist()
+
+	pts, ok := pairingTypeStrings[p.pairingType]
+	if !ok {
+		return nil, fmt.Errorf("invalid pairing type %v", p.pairingType)
+	}
+	t.Infof("smpOnPairingRequest: detected pairing type '%v'", pts)
+
+	if err := p.checkMITM(); err != nil {
+		t.send([]byte{pairingFailed, authRequirementsFailed})
+		return nil, err
+	}
+
+	if !p.legacy && p.scECDHKeys == nil {
+		keys, err := p.crypto().GenerateKeys()
+		if err != nil {
+			return nil, err
+		}
+		p.scECDHKeys = keys
+	}
+
+	if err := t.send(buildPairingRsp(p.response)); err != nil {
+		return nil, err
+	}
+	p.state = WaitPublicKey
+	if p.legacy {
+		p.state = WaitConfirm
+	}
+
+	p.user.reset()
+	switch {
+	case p.pairingType == Passkey:
+		p.user.close()
+		return nil, t.withPasskey(p.user.open)
+	case p.pairingType == NumericComp && p.authData.ConfirmNumericComparison != nil:
+		p.user.close()
+	}
+	return nil, nil
+}
+
+// pairingRequest returns the request of the initiator, for the user to decide on.
+func (p *pairingContext) pairingRequest() ble.PairingRequest {
+	req := p.request
+	return ble.PairingRequest{
+		Peer: ble.NewAddr(net.HardwareAddr(sliceops.SwapBuf(p.remoteAddr)).String()),
+		Features: ble.PairingFeatures{
+			IOCap: ble.IOCapability(req.IoCap),
+			Bond:  req.AuthReq&authReqBondMask == authReqBond,
+			MITM:  req.AuthReq&authReqMITM != 0,
+		},
+		SecureConnections: !isLegacy(req.AuthReq),
+		OOB:               req.OobFlag == byte(hci.OobPreset),
+	}
+}
+
+func onResponderPublicKey(t *transport) error {
+	p := t.pairing
+	if err := t.sendPublicKey(); err != nil {
+		return err
+	}
+
+	switch p.pairingType {
+	case JustWorks, NumericComp:
+		p.state = WaitRandom
+		return t.sendConfirm()
+	case Passkey:
+		p.passKeyIteration = 0
+		p.state = WaitConfirm
+	case Oob:
+		if err := p.checkOOBConfirm(); err != nil {
+			t.send([]byte{pairingFailed, confirmValueFailed})
+			return err
+		}
+		p.state = WaitRandom
+	}
+	return nil
+}
+
+func onResponderConfirm(t *transport) error {
+	p := t.pairing
+	switch {
+	case p.legacy:
+		// Reply to Mconfirm with Sconfirm, once the passkey is set.
+		return p.user.do(t.sendLegacyConfirm)
+	case p.pairingType == Passkey:
+		// Reply to Cai with Cbi, once the passkey is set.
+		return p.user.do(func() error {
+			p.state = WaitRandom
+			continuePassKeyPairing(t)
+			return nil
+		})
+	}
+	return fmt.Errorf("unexpected pairing confirm")
+}
+
+func onResponderRandom(t *transport) error {
+	p := t.pairing
+	switch {
+	case p.legacy:
+		if err := p.checkLegacyConfirm(); err != nil {
+			t.send([]byte{pairingFailed, confirmValueFailed})
+			return err
+		}
+		if err := t.sendPairingRandom(); err != nil {
+			return err
+		}
+
+		k := getLegacyParingTK(0)
+		if p.pairingType == Passkey {
+			k = getLegacyParingTK(p.authData.Passkey)
+		}
+		na, nb := p.nonces()
+		stk, err := p.crypto().S1(k, nb, na)
+		if err != nil {
+			return err
+		}
+		p.shortTermKey = p.maskKey(stk)
+		p.state = WaitEncryption
+		return nil
+
+	case p.pairingType == Passkey:
+		if err := p.checkPasskeyConfirm(); err != nil {
+			t.send([]byte{pairingFailed, confirmValueFailed})
+			return err
+		}
+		if err := t.sendPairingRandom(); err != nil {
+			return err
+		}
+		p.passKeyIteration++
+		p.state = WaitDhKeyCheck
+		if p.passKeyIteration < passkeyIterationCount {
+			p.state = WaitConfirm
+		}
+		return nil
+	}
+
+	// Just Works, Numeric Comparison and OOB
+	if err := t.sendPairingRandom(); err != nil {
+		return err
+	}
+	p.state = WaitDhKeyCheck
+	if p.pairingType == NumericComp && p.authData.ConfirmNumericComparison != nil {
+		return confirmNumericComparison(t, p.user.open)
+	}
+	return nil
+}
+
+func onResponderDHKeyCheck(t *transport) error {
+	p := t.pairing
+	if err := p.calcMacLtk(); err != nil {
+		return err
+	}
+	if err := p.checkDHKeyCheck(); err != nil {
+		t.send([]byte{pairingFailed, dhKeyCheckFailed})
+		return err
+	}
+	if err := t.sendDHKeyCheck(); err != nil {
+		return err
+	}
+	p.state = WaitEncryption
+	return nil
+}
+
+// sendConfirm sends the confirm value of a new random value in Just Works and
+// Numeric Comparison pairing, Cb = f4(PKbx, PKax, Nb, 0).
+func (t *transport) sendConfirm() error {
+	nb := make([]byte, 16)
+	if _, err := rand.Read(nb); err != nil {
+		return err
+	}
+	t.pairing.localRandom = nb
+
+	pkax, pkbx := t.pairing.publicKeysX()
+	c, err := t.pairing.crypto().F4(pkbx, pkax, nb, 0)
+	if err != nil {
+		return err
+	}
+	return t.send(append([]byte{pairingConfirm}, c...))
+}
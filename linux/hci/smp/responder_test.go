@@ -0,0 +1,610 @@
+package smp
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci"
+	"github.com/leso-kn/ble/sliceops"
+)
+
+type memBonds struct {
+	sync.Mutex
+	m map[string]hci.BondInfo
+}
+
+func (b *memBonds) Find(addr string) (hci.BondInfo, error) {
+	b.Lock()
+	defer b.Unlock()
+	bi, ok := b.m[addr]
+	if !ok {
+		return nil, fmt.Errorf("bond %v not found", addr)
+	}
+	return bi, nil
+}
+
+func (b *memBonds) Save(addr string, bi hci.BondInfo) error {
+	b.Lock()
+	defer b.Unlock()
+	b.m[addr] = bi
+	return nil
+}
+
+func (b *memBonds) Exists(addr string) bool {
+	_, err := b.Find(addr)
+	return err == nil
+}
+
+func (b *memBonds) Delete(addr string) error {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.m, addr)
+	return nil
+}
+
+func (b *memBonds) List() (map[string]hci.BondInfo, error) {
+	b.Lock()
+	defer b.Unlock()
+	out := make(map[string]hci.BondInfo, len(b.m))
+	for k, v := range b.m {
+		out[k] = v
+	}
+	return out, nil
+}
+
+func (b *memBonds) DeleteAll() error {
+	b.Lock()
+	defer b.Unlock()
+	b.m = map[string]hci.BondInfo{}
+	return nil
+}
+
+func (b *memBonds) Export(io.Writer) error { return fmt.Errorf("not supported") }
+func (b *memBonds) Import(io.Reader) error { return fmt.Errorf("not supported") }
+
+var (
+	initIdentity = &hci.Identity{IRK: bytes.Repeat([]byte{0x1A}, 16), Addr: []byte{0x66, 0x55, 0x44, 0x33, 0x22, 0x11}}
+	respIdentity = &hci.Identity{IRK: bytes.Repeat([]byte{0x2B}, 16), Addr: []byte{0xC6, 0xC5, 0xC4, 0xC3, 0xC2, 0xC1}, AddrType: 1}
+)
+
+// pairPeers pairs an initiator and a responder manager, passing the PDUs
+// between them, and returns the result of the initiator and the bonds of
+// both. The setup functions are called with both managers first.
+func pairPeers(t *testing.T, initCfg, respCfg hci.SmpConfig, initAD, respAD ble.AuthData, setup ...func(m *manager)) (error, *memBonds, *memBonds) {
+	initBonds := &memBonds{m: map[string]hci.BondInfo{}}
+	respBonds := &memBonds{m: map[string]hci.BondInfo{}}
+	initiator := NewSmpManager(initCfg, initBonds, ble.GetLogger())
+	responder := NewSmpManager(respCfg, respBonds, ble.GetLogger())
+	for _, f := range setup {
+		f(initiator)
+		f(responder)
+	}
+	responder.SetAuthData(respAD)
+	initiator.SetLocalIdentity(initIdentity)
+	responder.SetLocalIdentity(respIdentity)
+
+	initAddr := []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}
+	respAddr := []byte{0xC1, 0xC2, 0xC3, 0xC4, 0xC5, 0xC6}
+	initiator.InitContext(initAddr, respAddr, 0x00, 0x01)
+	responder.InitContext(respAddr, initAddr, 0x01, 0x00)
+
+	done := make(chan struct{})
+	defer close(done)
+	link := func(from, to *manager) {
+		ch := make(chan []byte, 16)
+		from.SetWritePDUFunc(func(b []byte) (int, error) {
+			ch <- append([]byte{}, b...)
+			return len(b), nil
+		})
+		go func() {
+			for {
+				select {
+				case b := <-ch:
+					to.Handle(b)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	link(initiator, responder)
+	link(responder, initiator)
+
+	// Starting encryption has the controller of the responder ask for the key.
+	initiator.SetEncryptFunc(func(bi hci.BondInfo) error {
+		var ltk []byte
+		var ediv uint16
+		var rand uint64
+		if legacy, stk := initiator.LegacyPairingInfo(); legacy {
+			ltk = stk
+		} else {
+			ltk, ediv, rand = bi.LongTermKey(), bi.EDiv(), bi.Random()
+		}
+		if k := responder.LongTermKey(ediv, rand); !bytes.Equal(k, ltk) {
+			return fmt.Errorf("responder key %X, want %X", k, ltk)
+		}
+		go responder.EncryptionChanged()
+		go initiator.EncryptionChanged()
+		return nil
+	})
+
+	err := initiator.Pair(initAD, 5*time.Second)
+	if err == nil {
+		// Let key distribution settle.
+		state := func() PairingState {
+			responder.t.mu.Lock()
+			defer responder.t.mu.Unlock()
+			return responder.pairing.state
+		}
+		for i := 0; i < 100 && state() != Finished; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if s := state(); s != Finished {
+			t.Fatalf("responder state %v", s)
+		}
+	}
+	return err, initBonds, respBonds
+}
+
+func TestResponderPairing(t *testing.T) {
+	sc := hci.SmpConfig{IoCap: hci.IoCapsNone, AuthReq: 0x09, MaxKeySize: 16, RespKeyDist: hci.KeyDistEncKey}
+	legacy := hci.SmpConfig{IoCap: hci.IoCapsNone, AuthReq: 0x01, MaxKeySize: 16, RespKeyDist: hci.KeyDistEncKey}
+	mitm := func(c hci.SmpConfig, io byte) hci.SmpConfig {
+		c.IoCap = io
+		c.AuthReq |= 0x04
+		return c
+	}
+
+	// The user types in the passkey shown on the other device.
+	shown := make(chan int, 1)
+	display := ble.AuthData{DisplayPasskey: func(n int) { shown <- n }}
+	input := ble.AuthData{InputPasskey: func() (int, error) { return <-shown, nil }}
+	accept := ble.AuthData{ConfirmNumericComparison: func(ble.NumericComparison) bool { return true }}
+
+	for _, tc := range []struct {
+		name           string
+		init, resp     hci.SmpConfig
+		initAD, respAD ble.AuthData
+	}{
+		{"sc just works", sc, sc, ble.AuthData{}, ble.AuthData{}},
+		{"sc numeric comparison", mitm(sc, hci.IoCapsDisplayYesNo), mitm(sc, hci.IoCapsDisplayYesNo), accept, accept},
+		{"sc passkey", mitm(sc, hci.IoCapsKeyboardOnly), mitm(sc, hci.IoCapsDisplayOnly), input, display},
+		{"legacy just works", legacy, legacy, ble.AuthData{}, ble.AuthData{}},
+		{"legacy passkey", mitm(legacy, hci.IoCapsDisplayOnly), mitm(legacy, hci.IoCapsKeyboardOnly), display, input},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err, _, bonds := pairPeers(t, tc.init, tc.resp, tc.initAD, tc.respAD)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bonds.m) != 1 {
+				t.Fatalf("responder bonds %v", bonds.m)
+			}
+		})
+	}
+}
+
+func TestResponderRejectsNumericComparison(t *testing.T) {
+	sc := hci.SmpConfig{IoCap: hci.IoCapsDisplayYesNo, AuthReq: 0x0D, MaxKeySize: 16, RespKeyDist: hci.KeyDistEncKey}
+	reject := ble.AuthData{ConfirmNumericComparison: func(ble.NumericComparison) bool { return false }}
+	if err, _, _ := pairPeers(t, sc, sc, ble.AuthData{}, reject); err == nil {
+		t.Fatal("pairing succeeded")
+	}
+}
+
+func TestKeyDistribution(t *testing.T) {
+	keys := byte(hci.KeyDistIdKey | hci.KeyDistSignKey)
+	for _, authReq := range []byte{0x01, 0x09} {
+		t.Run(fmt.Sprintf("authreq %02X", authReq), func(t *testing.T) {
+			c := hci.SmpConfig{IoCap: hci.IoCapsNone, AuthReq: authReq, MaxKeySize: 16,
+				InitKeyDist: keys, RespKeyDist: hci.KeyDistEncKey | keys}
+			err, initBonds, respBonds := pairPeers(t, c, c, ble.AuthData{}, ble.AuthData{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ib := initBonds.m["c6c5c4c3c2c1"]
+			rb := respBonds.m["665544332211"]
+			if ib == nil || rb == nil {
+				t.Fatalf("bonds %v, %v", initBonds.m, respBonds.m)
+			}
+			if !bytes.Equal(ib.LongTermKey(), rb.LongTermKey()) || ib.EDiv() != rb.EDiv() || ib.Random() != rb.Random() {
+				t.Errorf("initiator ltk %X, responder ltk %X", ib.LongTermKey(), rb.LongTermKey())
+			}
+
+			for _, tc := range []struct {
+				name     string
+				got, exp *hci.Identity
+			}{
+				{"initiator", ib.Identity(), respIdentity},
+				{"responder", rb.Identity(), initIdentity},
+			} {
+				if tc.got == nil || !bytes.Equal(tc.got.IRK, tc.exp.IRK) ||
+					!bytes.Equal(tc.got.Addr, tc.exp.Addr) || tc.got.AddrType != tc.exp.AddrType {
+					t.Errorf("%s: peer identity %+v, expected %+v", tc.name, tc.got, tc.exp)
+				}
+			}
+
+			is, rs := ib.Signing(), rb.Signing()
+			if is == nil || rs == nil {
+				t.Fatalf("signing keys %v, %v", is, rs)
+			}
+			if len(is.LocalCSRK) != 16 || !bytes.Equal(is.LocalCSRK, rs.RemoteCSRK) ||
+				len(rs.LocalCSRK) != 16 || !bytes.Equal(rs.LocalCSRK, is.RemoteCSRK) {
+				t.Errorf("initiator csrk %+v, responder csrk %+v", is, rs)
+			}
+		})
+	}
+}
+
+func TestKeySizePolicy(t *testing.T) {
+	c := hci.SmpConfig{IoCap: hci.IoCapsNone, AuthReq: 0x09, MaxKeySize: 16, RespKeyDist: hci.KeyDistEncKey}
+	short := c
+	short.MaxKeySize = 10
+
+	// Both devices accept the key size of the initiator.
+	err, _, bonds := pairPeers(t, short, c, ble.AuthData{}, ble.AuthData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bi := range bonds.m {
+		if ltk := bi.LongTermKey(); !bytes.Equal(ltk[10:], make([]byte, 6)) {
+			t.Errorf("ltk %X not masked to 10 octets", ltk)
+		}
+	}
+
+	strict := c
+	strict.MinKeySize = 16
+	if err, _, _ := pairPeers(t, short, strict, ble.AuthData{}, ble.AuthData{}); err == nil {
+		t.Error("responder accepted short key")
+	}
+	if err, _, _ := pairPeers(t, strict, short, ble.AuthData{}, ble.AuthData{}); err == nil {
+		t.Error("initiator accepted short key")
+	}
+}
+
+func TestPairingTimeout(t *testing.T) {
+	c := hci.SmpConfig{IoCap: hci.IoCapsNone, AuthReq: 0x09, MaxKeySize: 16}
+	m := NewSmpManager(c, &memBonds{m: map[string]hci.BondInfo{}}, ble.GetLogger())
+	m.InitContext([]byte{1, 2, 3, 4, 5, 6}, []byte{6, 5, 4, 3, 2, 1}, 0, 0)
+	m.SetWritePDUFunc(func(b []byte) (int, error) { return len(b), nil })
+	m.t.timeout = 10 * time.Millisecond
+
+	// The peer doesn't respond; the SMP timer fails the pairing.
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		err := m.Pair(ble.AuthData{}, 5*time.Second)
+		if err == nil || time.Since(start) > time.Second {
+			t.Fatalf("pairing %d: %v after %v", i, err, time.Since(start))
+		}
+	}
+
+	// Nor does the pairing get stuck if the caller gives up first.
+	m.t.timeout = time.Minute
+	for i := 0; i < 2; i++ {
+		if err := m.Pair(ble.AuthData{}, 10*time.Millisecond); err == nil || err.Error() != "pairing operation timed out" {
+			t.Fatalf("pairing %d: %v", i, err)
+		}
+	}
+}
+
+func TestPairingFeatures(t *testing.T) {
+	c := hci.SmpConfig{IoCap: hci.IoCapsDisplayYesNo, AuthReq: 0x09, MaxKeySize: 16, RespKeyDist: hci.KeyDistEncKey}
+	noIO := c
+	noIO.IoCap = hci.IoCapsNone
+
+	// MITM protection can't be had without IO capabilities.
+	mitm := ble.AuthData{Features: &ble.PairingFeatures{IOCap: ble.IOCapNoInputNoOutput, Bond: true, MITM: true}}
+	if err, _, _ := pairPeers(t, c, c, mitm, ble.AuthData{}); err == nil {
+		t.Error("initiator paired without MITM protection")
+	}
+	strict, err := noIO.WithFeatures(ble.PairingFeatures{IOCap: ble.IOCapDisplayYesNo, Bond: true, MITM: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, _, _ := pairPeers(t, noIO, strict, ble.AuthData{}, ble.AuthData{}); err == nil {
+		t.Error("responder paired without MITM protection")
+	}
+
+	// The features of the pairing override those of the device.
+	noBond := ble.AuthData{Features: &ble.PairingFeatures{IOCap: ble.IOCapDisplayYesNo}}
+	err, initBonds, _ := pairPeers(t, c, c, noBond, ble.AuthData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(initBonds.m) != 0 {
+		t.Errorf("bonded without bonding: %v", initBonds.m)
+	}
+
+	if _, err := c.WithFeatures(ble.PairingFeatures{IOCap: 0x05}); err == nil {
+		t.Error("accepted invalid io capability")
+	}
+}
+
+func TestStaticPasskey(t *testing.T) {
+	static := func(authReq byte, passkey int) hci.SmpConfig {
+		c, err := hci.SmpConfig{AuthReq: authReq, MaxKeySize: 16, RespKeyDist: hci.KeyDistEncKey}.
+			WithFeatures(ble.PairingFeatures{IOCap: ble.IOCapDisplayOnly, Bond: true, MITM: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.StaticPasskey = &passkey
+		return c
+	}
+	peer := func(authReq byte) hci.SmpConfig {
+		return hci.SmpConfig{IoCap: hci.IoCapsKeyboardDisplay, AuthReq: authReq, MaxKeySize: 16, RespKeyDist: hci.KeyDistEncKey}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		authReq byte
+	}{
+		{"sc", 0x09},
+		{"legacy", 0x01},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The static passkey is used in either role, without callbacks.
+			if err, _, _ := pairPeers(t, peer(tc.authReq), static(tc.authReq, 123456), ble.PeerPasskeyAuthData(123456), ble.AuthData{}); err != nil {
+				t.Errorf("static responder: %v", err)
+			}
+			if err, _, _ := pairPeers(t, static(tc.authReq, 4321), peer(tc.authReq), ble.AuthData{}, ble.PeerPasskeyAuthData(4321)); err != nil {
+				t.Errorf("static initiator: %v", err)
+			}
+			if err, _, _ := pairPeers(t, peer(tc.authReq), static(tc.authReq, 123456), ble.PeerPasskeyAuthData(654321), ble.AuthData{}); err == nil {
+				t.Error("paired with the wrong passkey")
+			}
+		})
+	}
+}
+
+func TestAcceptPairing(t *testing.T) {
+	c := hci.SmpConfig{IoCap: hci.IoCapsDisplayYesNo, AuthReq: 0x09, MaxKeySize: 16, RespKeyDist: hci.KeyDistEncKey}
+	var got ble.PairingRequest
+	policy := func(d ble.PairingDecision) ble.AuthData {
+		return ble.AuthData{
+			AcceptPairing: func(r ble.PairingRequest) ble.PairingDecision {
+				got = r
+				return d
+			},
+			ConfirmNumericComparison: func(ble.NumericComparison) bool { return true },
+		}
+	}
+
+	if err, _, _ := pairPeers(t, c, c, ble.AuthData{}, policy(ble.PairingDecision{})); err != nil {
+		t.Fatal(err)
+	}
+	exp := ble.PairingRequest{
+		Peer:              ble.NewAddr("11:22:33:44:55:66"),
+		Features:          ble.PairingFeatures{IOCap: ble.IOCapDisplayYesNo, Bond: true},
+		SecureConnections: true,
+	}
+	if got.Peer.String() != exp.Peer.String() || got.Features != exp.Features ||
+		got.SecureConnections != exp.SecureConnections || got.OOB != exp.OOB {
+		t.Errorf("pairing request %+v, expected %+v", got, exp)
+	}
+
+	err, _, _ := pairPeers(t, c, c, ble.AuthData{}, policy(ble.PairingDecision{Reject: true, Reason: 0x08}))
+	if err == nil || err.Error() != "pairing failed: unspecified reason" {
+		t.Errorf("rejected pairing: %v", err)
+	}
+
+	// Confirmation requires MITM protection, which Just Works pairing lacks.
+	noIO := c
+	noIO.IoCap = hci.IoCapsNone
+	if err, _, _ := pairPeers(t, noIO, c, ble.AuthData{}, policy(ble.PairingDecision{Confirm: true})); err == nil {
+		t.Error("confirmed Just Works pairing")
+	}
+	confirmed := false
+	ad := policy(ble.PairingDecision{Confirm: true})
+	ad.ConfirmNumericComparison = func(ble.NumericComparison) bool {
+		confirmed = true
+		return true
+	}
+	if err, _, _ := pairPeers(t, c, c, ble.AuthData{}, ad); err != nil || !confirmed {
+		t.Errorf("confirmed pairing: %v, confirmed %v", err, confirmed)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	csrk := bytes.Repeat([]byte{0x2B}, 16)
+	bonds := &memBonds{m: map[string]hci.BondInfo{}}
+	bonds.m["010203040506"] = hci.NewBondInfoWithKeys(bytes.Repeat([]byte{0x4D}, 16), 0, 0, false,
+		nil, &hci.SigningKeys{RemoteCSRK: csrk, RemoteSignCounter: 5})
+	m := NewSmpManager(hci.SmpConfig{}, bonds, ble.GetLogger())
+	m.InitContext([]byte{1, 2, 3, 4, 5, 6}, []byte{6, 5, 4, 3, 2, 1}, 0, 0)
+
+	signed := func(counter uint32) []byte {
+		msg := []byte{0xD2, 0x03, 0x00, 0xAA, 0xBB}
+		sig, err := signature(DefaultCrypto, csrk, msg, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(msg, sig...)
+	}
+
+	if err := m.VerifySignature(signed(4)); err == nil {
+		t.Error("accepted an old sign counter")
+	}
+	if err := m.VerifySignature(signed(7)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.VerifySignature(signed(7)); err == nil {
+		t.Error("accepted a replayed signature")
+	}
+	if bi, _ := bonds.Find("010203040506"); bi.Signing().RemoteSignCounter != 8 {
+		t.Errorf("sign counter %d, expected 8", bi.Signing().RemoteSignCounter)
+	}
+
+	d := signed(8)
+	d[3] ^= 0x01
+	if err := m.VerifySignature(d); err == nil {
+		t.Error("accepted a tampered write")
+	}
+	if err := m.VerifySignature(signed(8)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifySignatureCounterExhausted(t *testing.T) {
+	csrk := bytes.Repeat([]byte{0x2B}, 16)
+	bonds := &memBonds{m: map[string]hci.BondInfo{}}
+	bonds.m["010203040506"] = hci.NewBondInfoWithKeys(bytes.Repeat([]byte{0x4D}, 16), 0, 0, false,
+		nil, &hci.SigningKeys{RemoteCSRK: csrk, RemoteSignCounter: math.MaxUint32 - 1})
+	m := NewSmpManager(hci.SmpConfig{}, bonds, ble.GetLogger())
+	m.InitContext([]byte{1, 2, 3, 4, 5, 6}, []byte{6, 5, 4, 3, 2, 1}, 0, 0)
+
+	signed := func(counter uint32) []byte {
+		msg := []byte{0xD2, 0x03, 0x00, 0xAA, 0xBB}
+		sig, err := signature(DefaultCrypto, csrk, msg, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(msg, sig...)
+	}
+
+	if err := m.VerifySignature(signed(math.MaxUint32 - 1)); err != nil {
+		t.Fatal(err)
+	}
+	// The counter doesn't wrap around, which would let the earlier writes
+	// be replayed.
+	if err := m.VerifySignature(signed(math.MaxUint32)); err == nil {
+		t.Fatal("accepted the last sign counter")
+	}
+	if bi, _ := bonds.Find("010203040506"); bi.Signing().RemoteSignCounter != math.MaxUint32 {
+		t.Fatalf("sign counter %d", bi.Signing().RemoteSignCounter)
+	}
+	if err := m.VerifySignature(signed(0)); err == nil {
+		t.Fatal("accepted a replayed signature")
+	}
+}
+
+// TestSignatureKnownAnswer checks the signatures against the AES-CMAC
+// samples [Vol 3, Part H, Appendix D.1], whose messages are taken as the
+// data and the SignCounter. The samples are most significant octet first:
+// the first 4 octets are the SignCounter, and the MAC is the first 8 of the
+// AES-CMAC.
+func TestSignatureKnownAnswer(t *testing.T) {
+	key := "2b7e151628aed2a6abf7158809cf4f3c"
+	for _, tc := range []struct {
+		m, mac string
+	}{
+		{"6bc1bee22e409f96e93d7e117393172a", "070a16b46b4d4144f79bdd9dd04a287c"},
+		{"6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411", "dfa66747de9ae63030ca32611497c827"},
+	} {
+		m := sliceops.SwapBuf(mustDecode(t, tc.m))
+		data, counter := m[:len(m)-4], binary.LittleEndian.Uint32(m[len(m)-4:])
+		sig, err := signature(DefaultCrypto, sliceops.SwapBuf(mustDecode(t, key)), data, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append(append([]byte{}, m[len(m)-4:]...), sliceops.SwapBuf(mustDecode(t, tc.mac[:16]))...)
+		if !bytes.Equal(sig, want) {
+			t.Errorf("signature of %s = % X, want % X", tc.m, sig, want)
+		}
+	}
+}
+
+func mustDecode(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// countingPrimitives counts the uses of the pure Go primitives.
+type countingPrimitives struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (p *countingPrimitives) count(name string) {
+	p.mu.Lock()
+	p.calls[name]++
+	p.mu.Unlock()
+}
+
+func (p *countingPrimitives) E(key, msg []byte) ([]byte, error) {
+	p.count("E")
+	return goPrimitives{}.E(key, msg)
+}
+
+func (p *countingPrimitives) AESCMAC(key, msg []byte) ([]byte, error) {
+	p.count("AESCMAC")
+	return goPrimitives{}.AESCMAC(key, msg)
+}
+
+// GenerateKeys keeps the private key out of ECDHKeys, as hardware would,
+// behind a handle.
+func (p *countingPrimitives) GenerateKeys() (*ECDHKeys, error) {
+	p.count("GenerateKeys")
+	k, err := GenerateKeys()
+	if err != nil {
+		return nil, err
+	}
+	return NewECDHKeys(MarshalPublicKeyXY(k.public), &keyHandle{k.private})
+}
+
+type keyHandle struct {
+	key crypto.PrivateKey
+}
+
+func (p *countingPrimitives) DHKey(private crypto.PrivateKey, remote []byte) ([]byte, error) {
+	p.count("DHKey")
+	h, ok := private.(*keyHandle)
+	if !ok {
+		return nil, fmt.Errorf("unknown private key %T", private)
+	}
+	return goPrimitives{}.DHKey(h.key, remote)
+}
+
+func TestCrypto(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		authReq byte
+		want    []string
+	}{
+		{"sc", 0x09, []string{"AESCMAC", "GenerateKeys", "DHKey"}},
+		{"legacy", 0x01, []string{"E"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &countingPrimitives{calls: map[string]int{}}
+			c := hci.SmpConfig{IoCap: hci.IoCapsNone, AuthReq: tc.authReq, MaxKeySize: 16, RespKeyDist: hci.KeyDistEncKey}
+			err, _, _ := pairPeers(t, c, c, ble.AuthData{}, ble.AuthData{}, func(m *manager) {
+				m.pairing.cr = NewCrypto(p)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tc.want {
+				if p.calls[name] == 0 {
+					t.Errorf("%s not used, calls %v", name, p.calls)
+				}
+			}
+		})
+	}
+
+	f := NewSmpFactory(nil)
+	if err := f.SetCrypto("aes"); err == nil {
+		t.Error("unknown crypto accepted")
+	}
+	p := &countingPrimitives{calls: map[string]int{}}
+	if err := f.SetCrypto(NewCrypto(p)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.LocalOOBData(); err != nil {
+		t.Fatal(err)
+	}
+	if p.calls["GenerateKeys"] != 1 || p.calls["AESCMAC"] != 1 {
+		t.Errorf("local oob data calls %v", p.calls)
+	}
+}
@@ -0,0 +1,79 @@
+package smp
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+
+	"github.com/leso-kn/ble/linux/hci"
+)
+
+// signatureLen is the length of the SignCounter and MAC appended to signed data.
+const signatureLen = 12
+
+// signature returns the SignCounter and MAC of m, signed with the CSRK
+// [Vol 3, Part H, 2.4.5]. The MAC is the 64 most significant bits of the
+// AES-CMAC of m and the SignCounter, computed by c.
+func signature(c Crypto, csrk, m []byte, counter uint32) ([]byte, error) {
+	if len(csrk) != 16 {
+		return nil, fmt.Errorf("invalid csrk length %d", len(csrk))
+	}
+	sig := make([]byte, 4, signatureLen)
+	binary.LittleEndian.PutUint32(sig, counter)
+
+	msg := append(append([]byte{}, m...), sig...)
+	mac, err := c.AESCMAC(csrk, msg)
+	if err != nil {
+		return nil, err
+	}
+	return append(sig, mac[8:]...), nil
+}
+
+// VerifySignature verifies the signature at the end of data with the CSRK
+// distributed by the bonded peer. The SignCounter must not be lower than the
+// one following the last verified signature, which is saved with the bond.
+// The last SignCounter, 0xFFFFFFFF, is refused, as none could follow it: the
+// peer must pair again for a new CSRK.
+func (m *manager) VerifySignature(data []byte) error {
+	if len(data) < signatureLen {
+		return fmt.Errorf("signed data too short")
+	}
+	if m.bondManager == nil {
+		return fmt.Errorf("no bond manager")
+	}
+
+	m.t.mu.Lock()
+	defer m.t.mu.Unlock()
+	addr := hex.EncodeToString(m.pairing.remoteAddr)
+	bi, err := m.bondManager.Find(addr)
+	if err != nil {
+		return err
+	}
+	sk := bi.Signing()
+	if sk == nil || len(sk.RemoteCSRK) == 0 {
+		return fmt.Errorf("no csrk for %s", addr)
+	}
+
+	msg, sig := data[:len(data)-signatureLen], data[len(data)-signatureLen:]
+	counter := binary.LittleEndian.Uint32(sig)
+	if counter < sk.RemoteSignCounter {
+		return fmt.Errorf("sign counter %d replayed, expected at least %d", counter, sk.RemoteSignCounter)
+	}
+	if counter == math.MaxUint32 {
+		return fmt.Errorf("sign counter exhausted, pair again")
+	}
+	exp, err := signature(m.pairing.crypto(), sk.RemoteCSRK, msg, counter)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(sig, exp) != 1 {
+		return fmt.Errorf("invalid signature")
+	}
+
+	next := *sk
+	next.RemoteSignCounter = counter + 1
+	return m.bondManager.Save(addr, hci.NewBondInfoWithKeys(bi.LongTermKey(), bi.EDiv(), bi.Random(),
+		bi.Legacy(), bi.Identity(), &next))
+}
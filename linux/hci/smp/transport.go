@@ -6,6 +6,8 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"math/big"
+	"sync"
 	"time"
 
 	"github.com/leso-kn/ble"
@@ -22,6 +24,10 @@ func buildPairingRsp(p hci.SmpConfig) []byte {
 }
 
 type transport struct {
+	// mu serializes the steps of the pairing, which are also taken outside
+	// of the handling of PDUs, e.g. once the user answered.
+	mu sync.Mutex
+
 	pairing  *pairingContext
 	writePDU func([]byte) (int, error)
 
@@ -30,12 +36,21 @@ type transport struct {
 
 	nopFunc func() error //workaround stuff
 
+	// The SMP timer fails the pairing if it isn't reset in time.
+	timeout  time.Duration
+	timer    *time.Timer
+	timerGen int
+
 	result chan error
 	ble.Logger
 }
 
+// smpTimeout is the SMP transaction timeout [Vol 3, Part H, 3.4].
+const smpTimeout = 30 * time.Second
+
 func NewSmpTransport(ctx *pairingContext, bm hci.BondManager, e hci.Encrypter, writePDU func([]byte) (int, error), nopFunc func() error, l ble.Logger) *transport {
-	return &transport{ctx, writePDU, bm, e, nopFunc, make(chan error), l}
+	return &transport{pairing: ctx, writePDU: writePDU, bondManager: bm, encrypter: e,
+		nopFunc: nopFunc, timeout: smpTimeout, result: make(chan error), Logger: l}
 }
 
 func (t *transport) SetContext(ctx *pairingContext) {
@@ -54,11 +69,41 @@ func (t *transport) StartPairing(to time.Duration) error {
 }
 
 func (t *transport) saveBondInfo() error {
-	if t.pairing.request.AuthReq&authReqBondMask != authReqBond {
+	bi := t.pairing.bondInfo()
+	if t.pairing.request.AuthReq&authReqBondMask != authReqBond ||
+		t.pairing.response.AuthReq&authReqBondMask != authReqBond ||
+		bi == nil {
 		return nil
 	}
 	addr := hex.EncodeToString(t.pairing.remoteAddr)
-	return t.bondManager.Save(addr, t.pairing.bond)
+	return t.bondManager.Save(addr, bi)
+}
+
+// keysReceived marks keys of the peer as received, and completes the
+// pairing once all of them are, and the link is encrypted. The initiator
+// distributes its keys only then. [Vol 3, Part H, 3.6.1]
+func (t *transport) keysReceived(keys byte) error {
+	p := t.pairing
+	p.pendingKeys &^= keys
+	if p.state != WaitKeys || p.pendingKeys != 0 {
+		return nil
+	}
+	if !p.responder {
+		if err := t.distributeKeys(); err != nil {
+			return err
+		}
+	}
+
+	p.state = Finished
+	t.stopTimer()
+	if err := t.saveBondInfo(); err != nil {
+		return err
+	}
+	select {
+	case t.result <- nil:
+	default:
+	}
+	return nil
 }
 
 func (t *transport) send(pdu []byte) error {
@@ -73,7 +118,48 @@ func (t *transport) send(pdu []byte) error {
 		return err
 	}
 	_, err := t.writePDU(buf.Bytes())
-	return err
+	if err != nil {
+		return err
+	}
+
+	// The timer is reset whenever a command is sent.
+	if pdu[0] == pairingFailed {
+		t.stopTimer()
+	} else {
+		t.resetTimer()
+	}
+	return nil
+}
+
+// resetTimer restarts the SMP timer.
+func (t *transport) resetTimer() {
+	t.stopTimer()
+	gen := t.timerGen
+	t.timer = time.AfterFunc(t.timeout, func() { t.expire(gen) })
+}
+
+func (t *transport) stopTimer() {
+	t.timerGen++
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+}
+
+// expire fails the pairing in progress, once the peer didn't respond in time.
+func (t *transport) expire(gen int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if gen != t.timerGen {
+		return
+	}
+	t.timer = nil
+	switch t.pairing.state {
+	case Init, Finished, Error:
+		return
+	}
+	t.Errorf("smp: transaction timed out in state %v", t.pairing.state)
+	t.fail(fmt.Errorf("pairing timed out"))
 }
 
 func (t *transport) sendPairingRequest() error {
@@ -95,7 +181,7 @@ func (t *transport) sendPairingRequest() error {
 
 func (t *transport) sendPublicKey() error {
 	if t.pairing.scECDHKeys == nil {
-		keys, err := GenerateKeys()
+		keys, err := t.pairing.crypto().GenerateKeys()
 		if err != nil {
 			t.Errorf("sendPublicKey: generateKeys - %v", err)
 		}
@@ -148,7 +234,8 @@ func (t *transport) sendDHKeyCheck() error {
 	na := p.localRandom
 	nb := p.remoteRandom
 
-	ioCap := sliceops.SwapBuf([]byte{t.pairing.request.AuthReq, t.pairing.request.OobFlag, t.pairing.request.IoCap})
+	lc := t.pairing.localConfig()
+	ioCap := sliceops.SwapBuf([]byte{lc.AuthReq, lc.OobFlag, lc.IoCap})
 
 	rb := make([]byte, 16)
 	if t.pairing.pairingType == Passkey {
@@ -161,12 +248,13 @@ func (t *transport) sendDHKeyCheck() error {
 
 		//swap to little endian
 		rb = sliceops.SwapBuf(rb)
-	} else if t.pairing.pairingType == Oob {
+	} else if t.pairing.pairingType == Oob && len(t.pairing.authData.OOBData) > 0 {
+		// We have the OOB data of the peer.
 		rb = t.pairing.authData.OOBData
 		//todo: does this need to be swapped?
 	}
 
-	ea, err := smpF6(t.pairing.scMacKey, na, nb, rb, ioCap, la, ra)
+	ea, err := t.pairing.crypto().F6(t.pairing.scMacKey, na, nb, rb, ioCap, la, ra)
 	if err != nil {
 		return err
 	}
@@ -176,14 +264,13 @@ func (t *transport) sendDHKeyCheck() error {
 	return t.send(out)
 }
 
-func (t *transport) sendMConfirm() error {
+// sendLegacyConfirm sends the legacy pairing confirm value of a new random
+// value, Mconfirm or Sconfirm.
+func (t *transport) sendLegacyConfirm() error {
 	if t.pairing == nil {
 		return fmt.Errorf("no pairing context")
 	}
 
-	preq := buildPairingReq(t.pairing.request)
-	pres := buildPairingRsp(t.pairing.response)
-
 	r := make([]byte, 16)
 	_, err := rand.Read(r)
 	if err != nil {
@@ -191,19 +278,7 @@ func (t *transport) sendMConfirm() error {
 	}
 	t.pairing.localRandom = r
 
-	la := t.pairing.localAddr
-	lat := t.pairing.localAddrType
-	ra := t.pairing.remoteAddr
-	rat := t.pairing.remoteAddrType
-
-	k := make([]byte, 16)
-	if t.pairing.pairingType == Passkey {
-		k = getLegacyParingTK(t.pairing.authData.Passkey)
-	}
-
-	c1, err := smpC1(k, r, preq, pres,
-		lat, rat, la, ra,
-	)
+	c1, err := t.pairing.legacyConfirm(r)
 	if err != nil {
 		return err
 	}
@@ -212,3 +287,129 @@ func (t *transport) sendMConfirm() error {
 	out := append([]byte{pairingConfirm}, c1...)
 	return t.send(out)
 }
+
+// withPasskey sets the passkey of Passkey Entry pairing, and then calls next.
+// The passkey is generated and displayed, or typed in by the user, depending
+// on the IO capabilities; without the matching callback, the preset passkey
+// is used. Waiting for the user doesn't hold up incoming PDUs, so next is
+// then called once the passkey is typed in.
+func (t *transport) withPasskey(next func() error) error {
+	if t.pairing.pairingType != Passkey {
+		return next()
+	}
+
+	ad := &t.pairing.authData
+	if k := t.pairing.config.StaticPasskey; k != nil {
+		ad.Passkey = *k
+		return next()
+	}
+	if !t.pairing.localInputsPasskey() {
+		if ad.DisplayPasskey != nil {
+			n, err := rand.Int(rand.Reader, big.NewInt(passkeyMax+1))
+			if err != nil {
+				return err
+			}
+			ad.Passkey = int(n.Int64())
+			ad.DisplayPasskey(ad.Passkey)
+		}
+		return next()
+	}
+
+	if ad.InputPasskey == nil {
+		return next()
+	}
+	go func() {
+		key, err := ad.InputPasskey()
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if err == nil && (key < 0 || key > passkeyMax) {
+			err = fmt.Errorf("invalid passkey %v", key)
+		}
+		if err != nil {
+			t.send([]byte{pairingFailed, passkeyEntryFailed})
+			t.fail(fmt.Errorf("passkey entry: %w", err))
+			return
+		}
+		ad.Passkey = key
+		if err := next(); err != nil {
+			t.fail(err)
+		}
+	}()
+	return nil
+}
+
+// distributeKeys sends the keys the local device distributes.
+func (t *transport) distributeKeys() error {
+	p := t.pairing
+	local, _ := p.keyDist()
+
+	if local&hci.KeyDistEncKey != 0 {
+		ltk := make([]byte, 16)
+		if _, err := rand.Read(ltk); err != nil {
+			return err
+		}
+		p.maskKey(ltk)
+		id := make([]byte, 10)
+		for binary.LittleEndian.Uint16(id) == 0 || binary.LittleEndian.Uint64(id[2:]) == 0 {
+			if _, err := rand.Read(id); err != nil {
+				return err
+			}
+		}
+		if err := t.send(append([]byte{encryptionInformation}, ltk...)); err != nil {
+			return err
+		}
+		if err := t.send(append([]byte{masterIdentification}, id...)); err != nil {
+			return err
+		}
+		// The LTK of the initiator is for when the roles are reversed, which
+		// isn't kept, as a bond holds a single LTK.
+		if p.responder {
+			p.bond = hci.NewBondInfo(ltk, binary.LittleEndian.Uint16(id), binary.LittleEndian.Uint64(id[2:]), true)
+		}
+	}
+
+	if local&hci.KeyDistIdKey != 0 && p.localIdentity != nil {
+		id := p.localIdentity
+		if err := t.send(append([]byte{identityInformation}, id.IRK...)); err != nil {
+			return err
+		}
+		b := append([]byte{identityAddrInformation, id.AddrType}, sliceops.SwapBuf(id.Addr)...)
+		if err := t.send(b); err != nil {
+			return err
+		}
+	}
+
+	if local&hci.KeyDistSignKey != 0 {
+		csrk := make([]byte, 16)
+		if _, err := rand.Read(csrk); err != nil {
+			return err
+		}
+		if err := t.send(append([]byte{signingInformation}, csrk...)); err != nil {
+			return err
+		}
+		p.localCSRK = csrk
+	}
+	return nil
+}
+
+// fail ends the pairing with err.
+func (t *transport) fail(err error) {
+	t.pairing.state = Error
+	t.stopTimer()
+	select {
+	case t.result <- err:
+	default:
+	}
+}
+
+// passkeyInput returns whether the initiator inputs the passkey, rather than
+// displaying it, in Passkey Entry pairing. [Vol 3, Part H, 2.3.5.1]
+func passkeyInput(initIoCap, respIoCap byte) bool {
+	switch initIoCap {
+	case hci.IoCapsKeyboardOnly:
+		return true
+	case hci.IoCapsKeyboardDisplay:
+		return respIoCap == hci.IoCapsDisplayOnly || respIoCap == hci.IoCapsDisplayYesNo
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// DeviceInfo describes a HCI device known to the kernel.
+type DeviceInfo struct {
+	ID   int              // Device id, as in hciX.
+	Name string           // Device name, e.g. "hci0".
+	Addr net.HardwareAddr // Public device address.
+	Up   bool             // Whether the device is up.
+}
+
+// Channel is the HCI socket channel a device is opened on.
+type Channel int
+
+const (
+	// ChannelUser gives exclusive access to the device, bypassing the
+	// kernel's host stack. The device is taken down to bind it.
+	ChannelUser Channel = iota
+
+	// ChannelRaw shares the device with the kernel's host stack, e.g. while
+	// bluetoothd manages it. The kernel sees the same events and may act on
+	// them, so it suits observing and scanning more than connections. Skip
+	// the HCI Reset on it, with ble.OptSkipHCIReset.
+	ChannelRaw
+
+	// ChannelAuto tries the user channel, and falls back to the raw channel
+	// if the device is busy.
+	ChannelAuto
+)
+
+func (c Channel) String() string {
+	switch c {
+	case ChannelUser:
+		return "user channel"
+	case ChannelRaw:
+		return "raw channel"
+	case ChannelAuto:
+		return "auto channel"
+	}
+	return fmt.Sprintf("channel %d", int(c))
+}
+
+// Errors opening a device, to test an OpenError against with errors.Is.
+var (
+	// ErrPermission means the process lacks CAP_NET_ADMIN or CAP_NET_RAW.
+	ErrPermission = errors.New("permission denied")
+	// ErrBusy means the device is used by another process or socket.
+	ErrBusy = errors.New("device busy")
+	// ErrNoDevice means the device, or Bluetooth support, doesn't exist.
+	ErrNoDevice = errors.New("no such device")
+)
+
+// OpenError is an error opening a HCI device. linux.NewDevice wraps it; use
+// errors.Cause of github.com/pkg/errors to get it back.
+type OpenError struct {
+	ID  int    // Device id, or -1 if none was chosen.
+	Op  string // The failed step, e.g. "bind user channel".
+	Err error  // Usually a syscall.Errno.
+}
+
+func (e *OpenError) Error() string {
+	s := fmt.Sprintf("can't %s: %v", e.Op, e.Err)
+	if e.ID >= 0 {
+		s = fmt.Sprintf("hci%d: %s", e.ID, s)
+	}
+	switch {
+	case errors.Is(e, ErrPermission):
+		s += "; run as root, or grant the capabilities with " +
+			"setcap 'cap_net_raw,cap_net_admin+eip' <binary>"
+	case errors.Is(e, ErrBusy):
+		s += "; the device is in use, e.g. by bluetoothd or another " +
+			"program; stop it, or use the raw channel"
+	case errors.Is(e, ErrNoDevice):
+		s += "; check the device exists and Bluetooth is enabled in the kernel"
+	}
+	return s
+}
+
+// Unwrap returns the underlying error.
+func (e *OpenError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is ErrPermission, ErrBusy or ErrNoDevice.
+func (e *OpenError) Is(target error) bool {
+	var errno syscall.Errno
+	if !errors.As(e.Err, &errno) {
+		return false
+	}
+	switch target {
+	case ErrPermission:
+		return errno == syscall.EPERM || errno == syscall.EACCES
+	case ErrBusy:
+		return errno == syscall.EBUSY || errno == syscall.EALREADY || errno == syscall.EADDRINUSE
+	case ErrNoDevice:
+		return errno == syscall.ENODEV || errno == syscall.ENXIO || errno == syscall.EAFNOSUPPORT
+	}
+	return false
+}
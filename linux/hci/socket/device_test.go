@@ -0,0 +1,40 @@
+package socket
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestOpenError(t *testing.T) {
+	tests := []struct {
+		errno syscall.Errno
+		is    error
+		hint  string
+	}{
+		{syscall.EPERM, ErrPermission, "setcap"},
+		{syscall.EBUSY, ErrBusy, "raw channel"},
+		{syscall.ENODEV, ErrNoDevice, "Bluetooth is enabled"},
+	}
+	for _, tt := range tests {
+		var err error = &OpenError{ID: 0, Op: "bind user channel", Err: tt.errno}
+		err = fmt.Errorf("can't init hci: %w", err)
+		if !errors.Is(err, tt.is) {
+			t.Errorf("%v: not %v", tt.errno, tt.is)
+		}
+		if !errors.Is(err, tt.errno) {
+			t.Errorf("%v: errno not unwrapped", tt.errno)
+		}
+		if !strings.HasPrefix(err.Error(), "can't init hci: hci0: can't bind user channel") ||
+			!strings.Contains(err.Error(), tt.hint) {
+			t.Errorf("%v: message %q", tt.errno, err)
+		}
+		for _, o := range tests {
+			if o.is != tt.is && errors.Is(err, o.is) {
+				t.Errorf("%v: is also %v", tt.errno, o.is)
+			}
+		}
+	}
+}
@@ -1,4 +1,5 @@
-// +build !linux
+//go:build !linux && !freebsd
+// +build !linux,!freebsd
 
 package socket
 
@@ -7,7 +8,17 @@ import (
 	"io"
 )
 
-// NewSocket is a dummy function for non-Linux platform.
+// List is a dummy function for unsupported platforms.
+func List() ([]DeviceInfo, error) {
+	return nil, fmt.Errorf("only available on linux and freebsd")
+}
+
+// NewSocket is a dummy function for unsupported platforms.
 func NewSocket(id int) (io.ReadWriteCloser, error) {
-	return nil, fmt.Errorf("only available on linux")
+	return nil, fmt.Errorf("only available on linux and freebsd")
+}
+
+// Open is a dummy function for unsupported platforms.
+func Open(id int, ch Channel) (io.ReadWriteCloser, error) {
+	return nil, fmt.Errorf("only available on linux and freebsd")
 }
@@ -0,0 +1,109 @@
+//go:build linux || freebsd
+// +build linux freebsd
+
+package socket
+
+import (
+	"io"
+	"sync"
+
+	"github.com/leso-kn/ble"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	readTimeout    = 1000
+	unixPollErrors = int16(unix.POLLHUP | unix.POLLNVAL | unix.POLLERR)
+	unixPollDataIn = int16(unix.POLLIN)
+)
+
+// Socket implements a HCI User Channel, or Raw Channel, as ReadWriteCloser.
+type Socket struct {
+	fd   int
+	ch   Channel
+	rmu  sync.Mutex
+	wmu  sync.Mutex
+	done chan int
+	cmu  sync.Mutex
+	ble.Logger
+}
+
+// Channel returns the channel the socket is bound to.
+func (s *Socket) Channel() Channel {
+	return s.ch
+}
+
+func (s *Socket) Read(p []byte) (int, error) {
+	if !s.isOpen() {
+		return 0, io.EOF
+	}
+
+	var err error
+	n := 0
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	// don't need to add unixPollErrors, they are always returned
+	pfds := []unix.PollFd{{Fd: int32(s.fd), Events: unixPollDataIn}}
+	unix.Poll(pfds, readTimeout)
+	evts := pfds[0].Revents
+
+	switch {
+	case evts&unixPollErrors != 0:
+		s.Errorf("socketRead: unixPoll events 0x%04x", evts)
+		return 0, io.EOF
+
+	case evts&unixPollDataIn != 0:
+		// there is data!
+		n, err = unix.Read(s.fd, p)
+
+	default:
+		// no data, read timeout
+		return 0, nil
+	}
+
+	// check if we are still open since the read takes a while
+	if !s.isOpen() {
+		return 0, io.EOF
+	}
+	return n, errors.Wrap(err, "readSocket")
+}
+
+func (s *Socket) Write(p []byte) (int, error) {
+	if !s.isOpen() {
+		return 0, io.EOF
+	}
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	n, err := unix.Write(s.fd, p)
+	return n, errors.Wrap(err, "writeSocket")
+}
+
+func (s *Socket) Close() error {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+
+	default:
+		close(s.done)
+		s.Debugf("closing socket")
+		s.rmu.Lock()
+		err := unix.Close(s.fd)
+		s.rmu.Unlock()
+
+		return errors.Wrap(err, "closeSocket")
+	}
+}
+
+func (s *Socket) isOpen() bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
@@ -4,9 +4,11 @@
 package socket
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/binary"
+	stderrors "errors"
 	"io"
-	"sync"
+	"net"
 	"time"
 	"unsafe"
 
@@ -31,12 +33,14 @@ func ioctl(fd, op, arg uintptr) error {
 }
 
 const (
-	ioctlSize      = 4
-	hciMaxDevices  = 16
-	typHCI         = 72 // 'H'
-	readTimeout    = 1000
-	unixPollErrors = int16(unix.POLLHUP | unix.POLLNVAL | unix.POLLERR)
-	unixPollDataIn = int16(unix.POLLIN)
+	ioctlSize     = 4
+	hciMaxDevices = 16
+	typHCI        = 72 // 'H'
+
+	solHCI        = 0 // SOL_HCI
+	hciFilter     = 2 // HCI_FILTER
+	hciACLDataPkt = 2
+	hciEventPkt   = 4
 )
 
 var (
@@ -55,57 +59,170 @@ type devListRequest struct {
 	}
 }
 
-// Socket implements a HCI User Channel as ReadWriteCloser.
-type Socket struct {
-	fd   int
-	rmu  sync.Mutex
-	wmu  sync.Mutex
-	done chan int
-	cmu  sync.Mutex
-	ble.Logger
+// devInfo mirrors struct hci_dev_info of the kernel.
+type devInfo struct {
+	id         uint16
+	name       [8]byte
+	bdaddr     [6]byte
+	flags      uint32
+	typ        uint8
+	features   [8]uint8
+	pktType    uint32
+	linkPolicy uint32
+	linkMode   uint32
+	aclMTU     uint16
+	aclPkts    uint16
+	scoMTU     uint16
+	scoPkts    uint16
+	stat       [10]uint32
+}
+
+const hciDevFlagUp = 1 << 0 // HCI_UP
+
+// List returns the HCI devices known to the kernel.
+func List() ([]DeviceInfo, error) {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW, unix.BTPROTO_HCI)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create socket")
+	}
+	defer unix.Close(fd)
+
+	req := devListRequest{devNum: hciMaxDevices}
+	if err = ioctl(uintptr(fd), hciGetDeviceList, uintptr(unsafe.Pointer(&req))); err != nil {
+		return nil, errors.Wrap(err, "can't get device list")
+	}
+
+	var devs []DeviceInfo
+	for i := 0; i < int(req.devNum); i++ {
+		di := devInfo{id: req.devRequest[i].id}
+		if err = ioctl(uintptr(fd), hciGetDeviceInfo, uintptr(unsafe.Pointer(&di))); err != nil {
+			return nil, errors.Wrapf(err, "can't get info of hci%d", di.id)
+		}
+
+		// bdaddr is stored least significant byte first.
+		a := make(net.HardwareAddr, 6)
+		for j := range a {
+			a[j] = di.bdaddr[5-j]
+		}
+		devs = append(devs, DeviceInfo{
+			ID:   int(di.id),
+			Name: string(bytes.TrimRight(di.name[:], "\x00")),
+			Addr: a,
+			Up:   di.flags&hciDevFlagUp != 0,
+		})
+	}
+	return devs, nil
 }
 
 // NewSocket returns a HCI User Channel of specified device id.
 // If id is -1, the first available HCI device is returned.
 func NewSocket(id int) (*Socket, error) {
-	var err error
-	// Create RAW HCI Socket.
-	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW, unix.BTPROTO_HCI)
+	return Open(id, ChannelUser)
+}
+
+// Open returns a socket of the device id on the channel ch. A busy or
+// missing device is retried for a minute; missing permissions fail at once.
+//
+// If id is -1, the devices are tried in order, skipping busy ones. With
+// ChannelAuto, all of them are tried on the user channel before the raw one.
+// Errors are *OpenError, which can be tested with errors.Is against
+// ErrPermission, ErrBusy and ErrNoDevice.
+func Open(id int, ch Channel) (*Socket, error) {
+	if id == -1 {
+		return openAny(ch)
+	}
+
+	to := time.Now().Add(time.Second * 60)
+	for {
+		s, err := openDevice(id, ch)
+		if err == nil || stderrors.Is(err, ErrPermission) || time.Now().After(to) {
+			return s, err
+		}
+		<-time.After(time.Second)
+	}
+}
+
+// openAny opens the first available device.
+func openAny(ch Channel) (*Socket, error) {
+	ids, err := deviceIDs()
 	if err != nil {
-		return nil, errors.Wrap(err, "can't create socket")
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, &OpenError{ID: -1, Op: "find a device", Err: unix.ENODEV}
 	}
 
-	if id != -1 {
-		to := time.Now().Add(time.Second * 60)
-		var err error
-		var s *Socket
-		for time.Now().Before(to) {
-			s, err = open(fd, id)
+	chans := []Channel{ch}
+	if ch == ChannelAuto {
+		chans = []Channel{ChannelUser, ChannelRaw}
+	}
+	var last error
+	for _, c := range chans {
+		for _, id := range ids {
+			// Devices in use by another Socket, e.g. in this process, fail to
+			// bind and are skipped.
+			s, err := openChannel(id, c)
 			if err == nil {
 				return s, nil
 			}
-			unix.Close(fd)
-			<-time.After(time.Second)
+			if stderrors.Is(err, ErrPermission) {
+				return nil, err
+			}
+			last = err
 		}
+	}
+	return nil, last
+}
 
-		return nil, err
+// openDevice opens the device on ch, falling back from the user channel to
+// the raw channel for ChannelAuto.
+func openDevice(id int, ch Channel) (*Socket, error) {
+	if ch == ChannelRaw {
+		return openChannel(id, ChannelRaw)
+	}
+	s, err := openChannel(id, ChannelUser)
+	if err == nil || ch == ChannelUser || !stderrors.Is(err, ErrBusy) {
+		return s, err
 	}
+	return openChannel(id, ChannelRaw)
+}
+
+// deviceIDs returns the ids of the devices known to the kernel.
+func deviceIDs() ([]int, error) {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW, unix.BTPROTO_HCI)
+	if err != nil {
+		return nil, &OpenError{ID: -1, Op: "create socket", Err: err}
+	}
+	defer unix.Close(fd)
 
 	req := devListRequest{devNum: hciMaxDevices}
 	if err = ioctl(uintptr(fd), hciGetDeviceList, uintptr(unsafe.Pointer(&req))); err != nil {
-		unix.Close(fd)
-		return nil, errors.Wrap(err, "can't get device list")
+		return nil, &OpenError{ID: -1, Op: "get device list", Err: err}
 	}
-	var msg string
-	for id := 0; id < int(req.devNum); id++ {
-		s, err := open(fd, id)
-		if err == nil {
-			return s, nil
-		}
-		msg = msg + fmt.Sprintf("(hci%d: %s)", id, err)
+	ids := make([]int, req.devNum)
+	for i := range ids {
+		ids[i] = int(req.devRequest[i].id)
+	}
+	return ids, nil
+}
+
+// openChannel opens the device on the user or raw channel.
+func openChannel(id int, ch Channel) (*Socket, error) {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW, unix.BTPROTO_HCI)
+	if err != nil {
+		return nil, &OpenError{ID: id, Op: "create socket", Err: err}
+	}
+	var s *Socket
+	if ch == ChannelRaw {
+		s, err = openRaw(fd, id)
+	} else {
+		s, err = open(fd, id)
 	}
-	unix.Close(fd)
-	return nil, errors.Errorf("no devices available: %s", msg)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return s, nil
 }
 
 func open(fd, id int) (*Socket, error) {
@@ -113,13 +230,13 @@ func open(fd, id int) (*Socket, error) {
 	// HCI User Channel requires exclusive access to the device.
 	// The device has to be down at the time of binding.
 	if err := ioctl(uintptr(fd), hciDownDevice, uintptr(id)); err != nil {
-		return nil, errors.Wrap(err, "can't down device")
+		return nil, &OpenError{ID: id, Op: "down device", Err: err}
 	}
 
 	// Bind the RAW socket to HCI User Channel
 	sa := unix.SockaddrHCI{Dev: uint16(id), Channel: unix.HCI_CHANNEL_USER}
 	if err := unix.Bind(fd, &sa); err != nil {
-		return nil, errors.Wrap(err, "can't bind socket to hci user channel")
+		return nil, &OpenError{ID: id, Op: "bind user channel", Err: err}
 	}
 
 	// poll for 20ms to see if any data becomes available, then clear it
@@ -136,79 +253,31 @@ func open(fd, id int) (*Socket, error) {
 		unix.Read(fd, b)
 	}
 
-	return &Socket{fd: fd, done: make(chan int), Logger: ble.GetLogger()}, nil
+	return &Socket{fd: fd, ch: ChannelUser, done: make(chan int), Logger: ble.GetLogger()}, nil
 }
 
-func (s *Socket) Read(p []byte) (int, error) {
-	if !s.isOpen() {
-		return 0, io.EOF
-	}
-
-	var err error
-	n := 0
-	s.rmu.Lock()
-	defer s.rmu.Unlock()
-	// don't need to add unixPollErrors, they are always returned
-	pfds := []unix.PollFd{{Fd: int32(s.fd), Events: unixPollDataIn}}
-	unix.Poll(pfds, readTimeout)
-	evts := pfds[0].Revents
-
-	switch {
-	case evts&unixPollErrors != 0:
-		s.Errorf("socketRead: unixPoll events 0x%04x", evts)
-		return 0, io.EOF
-
-	case evts&unixPollDataIn != 0:
-		// there is data!
-		n, err = unix.Read(s.fd, p)
-
-	default:
-		// no data, read timeout
-		return 0, nil
-	}
-
-	// check if we are still open since the read takes a while
-	if !s.isOpen() {
-		return 0, io.EOF
+// openRaw binds the HCI Raw Channel, which the kernel's host stack keeps
+// using. The device is brought up if needed.
+func openRaw(fd, id int) (*Socket, error) {
+	err := ioctl(uintptr(fd), hciUpDevice, uintptr(id))
+	if err != nil && err != unix.EALREADY {
+		return nil, &OpenError{ID: id, Op: "up device", Err: err}
 	}
-	return n, errors.Wrap(err, "readSocket")
-}
 
-func (s *Socket) Write(p []byte) (int, error) {
-	if !s.isOpen() {
-		return 0, io.EOF
+	sa := unix.SockaddrHCI{Dev: uint16(id), Channel: unix.HCI_CHANNEL_RAW}
+	if err := unix.Bind(fd, &sa); err != nil {
+		return nil, &OpenError{ID: id, Op: "bind raw channel", Err: err}
 	}
 
-	s.wmu.Lock()
-	defer s.wmu.Unlock()
-	n, err := unix.Write(s.fd, p)
-	return n, errors.Wrap(err, "writeSocket")
-}
-
-func (s *Socket) Close() error {
-	s.cmu.Lock()
-	defer s.cmu.Unlock()
-
-	select {
-	case <-s.done:
-		return nil
-
-	default:
-		close(s.done)
-		s.Debugf("closing socket")
-		s.rmu.Lock()
-		err := unix.Close(s.fd)
-		s.rmu.Unlock()
-
-		return errors.Wrap(err, "closeSocket")
+	// Receive all events and ACL data; the default filter drops them.
+	// struct hci_filter is the packet type mask, event mask and opcode.
+	f := make([]byte, 16)
+	binary.LittleEndian.PutUint32(f[0:], 1<<hciEventPkt|1<<hciACLDataPkt)
+	binary.LittleEndian.PutUint32(f[4:], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint32(f[8:], 0xFFFFFFFF)
+	if err := unix.SetsockoptString(fd, solHCI, hciFilter, string(f)); err != nil {
+		return nil, &OpenError{ID: id, Op: "set raw channel filter", Err: err}
 	}
-}
 
-func (s *Socket) isOpen() bool {
-	select {
-	case <-s.done:
-		return false
-	default:
-		return true
-	}
+	return &Socket{fd: fd, ch: ChannelRaw, done: make(chan int), Logger: ble.GetLogger()}, nil
 }
@@ -0,0 +1,207 @@
+//go:build freebsd
+// +build freebsd
+
+package socket
+
+import (
+	"encoding/binary"
+	stderrors "errors"
+	"fmt"
+	"net"
+	"time"
+	"unsafe"
+
+	"github.com/leso-kn/ble"
+	"golang.org/x/sys/unix"
+)
+
+// FreeBSD's Bluetooth stack is made of netgraph nodes: each controller has
+// an ng_hci node, named after its driver node, e.g. "ubt0hci". Raw HCI
+// sockets, bound and connected to a node, send and receive its packets,
+// with the same packet indicator as the H4 transport and the Linux sockets
+// [ng_btsocket.h, ng_btsocket_hci_raw(4)].
+const (
+	btprotoHCI = 134 // BLUETOOTH_PROTO_HCI
+
+	solHCIRaw      = 0x0802 // SOL_HCI_RAW
+	soHCIRawFilter = 1      // SO_HCI_RAW_FILTER
+
+	hciMaxDevices = 16
+	nodeNameSize  = 32 // NG_NODESIZ
+
+	hciACLDataPkt = 2
+	hciEventPkt   = 4
+
+	// probeTimeout is how long List waits for a node to answer.
+	probeTimeout = 500 * time.Millisecond
+)
+
+// sockaddrHCI mirrors struct sockaddr_hci.
+type sockaddrHCI struct {
+	len    uint8
+	family uint8
+	node   [nodeNameSize]byte
+}
+
+// NodeName returns the name of the ng_hci node of the device id, as the
+// device ids of FreeBSD are the unit numbers of the ubt(4) driver.
+func NodeName(id int) string {
+	return fmt.Sprintf("ubt%dhci", id)
+}
+
+// List returns the HCI devices whose nodes answer the Read BD_ADDR command.
+func List() ([]DeviceInfo, error) {
+	var devs []DeviceInfo
+	for id := 0; id < hciMaxDevices; id++ {
+		s, err := openChannel(id, ChannelRaw)
+		if err != nil {
+			if stderrors.Is(err, ErrPermission) {
+				return nil, err
+			}
+			continue
+		}
+		a, err := s.readBDAddr(probeTimeout)
+		s.Close()
+		if err != nil {
+			continue
+		}
+		devs = append(devs, DeviceInfo{ID: id, Name: NodeName(id), Addr: a, Up: true})
+	}
+	return devs, nil
+}
+
+// NewSocket returns a raw HCI socket of specified device id.
+// If id is -1, the first available HCI device is returned.
+func NewSocket(id int) (*Socket, error) {
+	return Open(id, ChannelRaw)
+}
+
+// Open returns a raw HCI socket of the ng_hci node of the device id, e.g.
+// "ubt0hci" for 0. A missing device is retried for a minute; missing
+// permissions fail at once.
+//
+// FreeBSD has no user channel: the kernel's host stack always shares the
+// device, as on the raw channel of Linux, whatever ch is. Skip the HCI
+// Reset, with ble.OptSkipHCIReset, not to disturb it. The events with codes
+// above 0x40 aren't passed to raw sockets, and sending most commands
+// requires root.
+//
+// If id is -1, the devices are tried in order.
+func Open(id int, ch Channel) (*Socket, error) {
+	if id == -1 {
+		return openAny()
+	}
+
+	to := time.Now().Add(time.Second * 60)
+	for {
+		s, err := openProbed(id)
+		if err == nil || stderrors.Is(err, ErrPermission) || time.Now().After(to) {
+			return s, err
+		}
+		<-time.After(time.Second)
+	}
+}
+
+// openAny opens the first device whose node answers.
+func openAny() (*Socket, error) {
+	var last error = &OpenError{ID: -1, Op: "find a device", Err: unix.ENODEV}
+	for id := 0; id < hciMaxDevices; id++ {
+		s, err := openProbed(id)
+		if err == nil {
+			return s, nil
+		}
+		if stderrors.Is(err, ErrPermission) {
+			return nil, err
+		}
+		if !stderrors.Is(err, ErrNoDevice) {
+			last = err
+		}
+	}
+	return nil, last
+}
+
+// openProbed opens the device, and checks its node exists, as binding to
+// a missing node succeeds.
+func openProbed(id int) (*Socket, error) {
+	s, err := openChannel(id, ChannelRaw)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.readBDAddr(probeTimeout); err != nil {
+		s.Close()
+		return nil, &OpenError{ID: id, Op: "probe " + NodeName(id), Err: err}
+	}
+	return s, nil
+}
+
+// openChannel binds and connects a raw HCI socket to the node of the device.
+func openChannel(id int, ch Channel) (*Socket, error) {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW, btprotoHCI)
+	if err != nil {
+		return nil, &OpenError{ID: id, Op: "create socket", Err: err}
+	}
+
+	sa := sockaddrHCI{len: uint8(unsafe.Sizeof(sockaddrHCI{})), family: unix.AF_BLUETOOTH}
+	copy(sa.node[:nodeNameSize-1], NodeName(id))
+	for _, call := range []struct {
+		op  string
+		num uintptr
+	}{{"bind", unix.SYS_BIND}, {"connect", unix.SYS_CONNECT}} {
+		_, _, ep := unix.Syscall(call.num, uintptr(fd), uintptr(unsafe.Pointer(&sa)), unsafe.Sizeof(sa))
+		if ep != 0 {
+			unix.Close(fd)
+			return nil, &OpenError{ID: id, Op: call.op + " " + NodeName(id), Err: ep}
+		}
+	}
+
+	// Receive all events and ACL data; the default filter drops them.
+	// struct ng_btsocket_hci_raw_filter is the bit strings of the packet
+	// types and of the event codes, less one.
+	f := make([]byte, 12)
+	binary.LittleEndian.PutUint32(f[0:], 1<<(hciEventPkt-1)|1<<(hciACLDataPkt-1))
+	binary.LittleEndian.PutUint64(f[4:], 0xFFFFFFFFFFFFFFFF)
+	if err := unix.SetsockoptString(fd, solHCIRaw, soHCIRawFilter, string(f)); err != nil {
+		unix.Close(fd)
+		return nil, &OpenError{ID: id, Op: "set raw socket filter", Err: err}
+	}
+
+	return &Socket{fd: fd, ch: ChannelRaw, done: make(chan int), Logger: ble.GetLogger()}, nil
+}
+
+// readBDAddr sends the Read BD_ADDR command, and waits for its Command
+// Complete event, skipping the other packets.
+func (s *Socket) readBDAddr(timeout time.Duration) (net.HardwareAddr, error) {
+	if _, err := s.Write([]byte{0x01, 0x09, 0x10, 0x00}); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 512)
+	to := time.Now().Add(timeout)
+	for time.Now().Before(to) {
+		pfds := []unix.PollFd{{Fd: int32(s.fd), Events: unixPollDataIn}}
+		if _, err := unix.Poll(pfds, int(time.Until(to)/time.Millisecond)); err != nil {
+			return nil, err
+		}
+		if pfds[0].Revents&unixPollDataIn == 0 {
+			break
+		}
+		n, err := unix.Read(s.fd, b)
+		if err != nil {
+			return nil, err
+		}
+		// Event, Command Complete, length, credits, opcode 0x1009, status,
+		// and the address, least significant byte first.
+		p := b[:n]
+		if n < 13 || p[0] != hciEventPkt || p[1] != 0x0E || p[4] != 0x09 || p[5] != 0x10 {
+			continue
+		}
+		if p[6] != 0 {
+			return nil, fmt.Errorf("read BD_ADDR: status 0x%02X", p[6])
+		}
+		a := make(net.HardwareAddr, 6)
+		for i := range a {
+			a[i] = p[12-i]
+		}
+		return a, nil
+	}
+	return nil, unix.ENODEV
+}
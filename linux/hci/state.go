@@ -0,0 +1,93 @@
+package hci
+
+// State is the state the HCI drives the controller in.
+type State struct {
+	// Scanning is set while scanning is enabled. ScanningPaused is set as
+	// well while a duty-cycled scan idles.
+	Scanning       bool
+	ScanningPaused bool
+
+	// Advertising is set while advertising is enabled. AdvertisingPaused is
+	// set as well while the controller doesn't advertise for now: once it
+	// accepted a connection, or during a Dial, until advertising resumes.
+	Advertising       bool
+	AdvertisingPaused bool
+
+	// Dialing is set while a Dial waits for its connection.
+	Dialing bool
+
+	// Connections is the number of connections, in both roles.
+	Connections int
+
+	// Closed is set once the HCI is closed.
+	Closed bool
+}
+
+// State returns the current state of the HCI. Scan, Advertise and their
+// Stop counterparts may be called from any goroutine; calls which don't
+// change the state do nothing.
+func (h *HCI) State() State {
+	h.muState.Lock()
+	s := h.state
+	h.muState.Unlock()
+
+	h.muDial.Lock()
+	s.Dialing = h.dialing
+	h.muDial.Unlock()
+
+	h.muConns.Lock()
+	s.Connections = len(h.conns)
+	h.muConns.Unlock()
+
+	s.Closed = !h.isOpen()
+	return s
+}
+
+func (h *HCI) setScanning(on bool) {
+	h.muState.Lock()
+	h.state.Scanning = on
+	h.muState.Unlock()
+}
+
+// setScanPaused mirrors whether a duty-cycled scan idles.
+func (h *HCI) setScanPaused(on bool) {
+	h.muState.Lock()
+	h.state.ScanningPaused = on
+	h.muState.Unlock()
+}
+
+// syncAdvState mirrors the advertising state. Must be called with h.muAdv
+// held.
+func (h *HCI) syncAdvState() {
+	on := h.params.advEnable.AdvertisingEnable == 1
+	h.muState.Lock()
+	h.state.Advertising = on
+	h.state.AdvertisingPaused = on && h.advStopped
+	h.muState.Unlock()
+}
+
+// busyErr returns the error of the operation the HCI is busy with, if the
+// controller disallowed a command in its current state, or else err.
+func (h *HCI) busyErr(err error) error {
+	if err != ErrDisallowed {
+		return err
+	}
+	s := h.State()
+	switch {
+	case s.Dialing:
+		return ErrBusyDialing
+	case s.Scanning:
+		return ErrBusyScanning
+	case s.Advertising && !s.AdvertisingPaused:
+		return ErrBusyAdvertising
+	}
+	return err
+}
+
+// advParamsDirty reports whether the advertising parameters changed since
+// they were last sent.
+func (h *HCI) advParamsDirty() bool {
+	h.params.Lock()
+	defer h.params.Unlock()
+	return h.params.advParamsDirty
+}
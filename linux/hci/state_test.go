@@ -0,0 +1,77 @@
+package hci_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux/hci"
+)
+
+func TestState(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p := pair.Peripheral
+
+	if s := p.State(); s != (hci.State{}) {
+		t.Fatalf("initial state %+v", s)
+	}
+	// Repeated calls do nothing.
+	for i := 0; i < 2; i++ {
+		if err := p.HCI.Scan(false); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.HCI.AdvertiseNameAndServices("Gopher"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := p.State(); !s.Scanning || !s.Advertising || s.AdvertisingPaused {
+		t.Fatalf("state %+v", s)
+	}
+	for i := 0; i < 2; i++ {
+		if err := p.HCI.StopScanning(); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.HCI.StopAdvertising(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := p.State(); s != (hci.State{}) {
+		t.Fatalf("stopped state %+v", s)
+	}
+
+	// Interleaved calls are serialized.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				var err error
+				switch (i + j) % 4 {
+				case 0:
+					err = p.HCI.Scan(j%2 == 0)
+				case 1:
+					err = p.HCI.StopScanning()
+				case 2:
+					err = p.HCI.Advertise()
+				case 3:
+					err = p.HCI.StopAdvertising()
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	p.Stop()
+	if err := p.HCI.StopScanning(); err != nil {
+		t.Errorf("stop scanning once closed: %v", err)
+	}
+	if s := p.State(); !s.Closed {
+		t.Errorf("state %+v once closed", s)
+	}
+}
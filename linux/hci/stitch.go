@@ -0,0 +1,97 @@
+package hci
+
+import (
+	"sync"
+	"time"
+)
+
+// advStitcher holds the scannable advertisements of active scans until the
+// scan response of the advertiser arrives, or until wait is over, so the
+// advertising data and the scan response are delivered together rather than
+// as two advertisements.
+type advStitcher struct {
+	sync.Mutex
+	wait    time.Duration
+	active  bool
+	pending map[string]*heldAdv
+}
+
+type heldAdv struct {
+	a *Advertisement
+	t *time.Timer
+}
+
+// holdAdv holds the scannable advertisement a, if the scan waits for scan
+// responses, and reports whether it did. A newer advertisement of the same
+// peer replaces the one held, within the same wait.
+func (h *HCI) holdAdv(a *Advertisement) bool {
+	g := &h.advStitcher
+	g.Lock()
+	defer g.Unlock()
+	if !g.active {
+		return false
+	}
+	if g.pending == nil {
+		g.pending = make(map[string]*heldAdv)
+	}
+	k := a.Addr().String()
+	if p := g.pending[k]; p != nil {
+		p.a = a
+		return true
+	}
+	p := &heldAdv{a: a}
+	p.t = time.AfterFunc(g.wait, func() { h.stitchTimeout(k, p) })
+	g.pending[k] = p
+	return true
+}
+
+// releaseAdv drops the advertisement held for the peer addr, as its scan
+// response arrived and is delivered along with it.
+func (h *HCI) releaseAdv(addr string) {
+	g := &h.advStitcher
+	g.Lock()
+	defer g.Unlock()
+	if p := g.pending[addr]; p != nil {
+		p.t.Stop()
+		delete(g.pending, addr)
+	}
+}
+
+// stitchTimeout delivers the advertisement p held for the peer k, without
+// its scan response, which didn't arrive in time.
+func (h *HCI) stitchTimeout(k string, p *heldAdv) {
+	g := &h.advStitcher
+	g.Lock()
+	if g.pending[k] != p {
+		// Released meanwhile.
+		g.Unlock()
+		return
+	}
+	delete(g.pending, k)
+	// The event loop may copy the advertisement it keeps in its history.
+	a := *p.a
+	g.Unlock()
+	h.deliverAdv(&a)
+}
+
+// startStitching makes the scan starting wait for scan responses, if it's
+// active and a wait is set.
+func (h *HCI) startStitching(active bool) {
+	g := &h.advStitcher
+	g.Lock()
+	defer g.Unlock()
+	g.active = active && g.wait > 0
+	g.pending = nil
+}
+
+// stopStitching drops the advertisements held.
+func (h *HCI) stopStitching() {
+	g := &h.advStitcher
+	g.Lock()
+	defer g.Unlock()
+	for _, p := range g.pending {
+		p.t.Stop()
+	}
+	g.active = false
+	g.pending = nil
+}
@@ -0,0 +1,57 @@
+package hci
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+func TestScanResponseWait(t *testing.T) {
+	got := make(chan *Advertisement, 4)
+	h := &HCI{Logger: ble.GetLogger(), advHandlerSync: true, adHist: make([]*Advertisement, 128)}
+	h.advHandler = func(a ble.Advertisement) { got <- a.(*Advertisement) }
+	if err := h.SetScanResponseWait(20 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	h.startStitching(true)
+	defer h.stopStitching()
+
+	report := func(et uint8, data []byte) {
+		b := []byte{evt.LEAdvertisingReportSubCode, 1, et, 0, 1, 2, 3, 4, 5, 6, byte(len(data))}
+		b = append(append(b, data...), 0xC0)
+		if err := h.handleLEAdvertisingReport(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ad := []byte{0x02, 0x01, 0x06, 0x03, 0x03, 0x0D, 0x18}
+
+	// The advertising data is held until the scan response arrives.
+	report(evtTypAdvInd, ad)
+	if len(got) != 0 {
+		t.Fatal("scannable advertisement delivered before its scan response")
+	}
+	report(evtTypScanRsp, []byte{0x04, 0x09, 'h', 'r', 's'})
+	a := <-got
+	if !a.HasScanResponse() || a.LocalName() != "hrs" || len(a.Services()) != 1 {
+		t.Fatalf("name %q, services %v, scan response %v", a.LocalName(), a.Services(), a.HasScanResponse())
+	}
+
+	// Or until the wait is over.
+	report(evtTypAdvScanInd, ad)
+	select {
+	case a := <-got:
+		if a.HasScanResponse() || len(a.Services()) != 1 {
+			t.Fatalf("services %v, scan response %v", a.Services(), a.HasScanResponse())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("advertisement held past the wait")
+	}
+
+	// Advertisements which aren't scannable aren't held.
+	report(evtTypAdvNonconnInd, ad)
+	if len(got) != 1 {
+		t.Fatal("non-scannable advertisement held")
+	}
+}
@@ -0,0 +1,102 @@
+package hci
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+// leMetaCode is the code of the LE Meta event, the first parameter of which
+// is the subevent code.
+const leMetaCode = 0x3E
+
+// Event is an HCI event received from the controller, as passed to the
+// taps.
+type Event struct {
+	// Time is when the event was received.
+	Time time.Time
+
+	// Code is the event code, and SubCode the subevent code of LE Meta
+	// events.
+	Code    uint8
+	SubCode uint8
+
+	// Name is the name of the event, or of the LE Meta subevent.
+	Name string
+
+	// Raw is the event packet: the event code, the parameter length and
+	// the parameters. It's shared by the taps, and must not be modified.
+	Raw []byte
+}
+
+// Params returns the parameters of the event, which start with the
+// subevent code for LE Meta events, as the types of the evt package expect,
+// e.g. evt.LEConnectionComplete(e.Params()).
+func (e Event) Params() []byte {
+	return e.Raw[2:]
+}
+
+// EventTap receives a copy of the HCI events, for external decoders such as
+// analytics or anomaly detection, without interfering with the handling of
+// the events by the stack: it never blocks the event loop, the events it
+// has no room for are dropped.
+type EventTap struct {
+	// C receives the events, in order. It's closed by Close.
+	C <-chan Event
+
+	c       chan Event
+	h       *HCI
+	dropped uint64
+}
+
+// TapEvents returns a tap of the HCI events, with room for n events not
+// received from C yet.
+func (h *HCI) TapEvents(n int) *EventTap {
+	c := make(chan Event, n)
+	t := &EventTap{C: c, c: c, h: h}
+	h.muTaps.Lock()
+	h.taps = append(h.taps, t)
+	h.muTaps.Unlock()
+	return t
+}
+
+// Dropped returns the number of events dropped as C was full.
+func (t *EventTap) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// Close removes the tap, and closes C.
+func (t *EventTap) Close() {
+	h := t.h
+	h.muTaps.Lock()
+	defer h.muTaps.Unlock()
+	for i, o := range h.taps {
+		if o == t {
+			h.taps = append(h.taps[:i:i], h.taps[i+1:]...)
+			close(t.c)
+			return
+		}
+	}
+}
+
+// tapEvent passes the event packet b to the taps, if any.
+func (h *HCI) tapEvent(b []byte) {
+	h.muTaps.RLock()
+	defer h.muTaps.RUnlock()
+	if len(h.taps) == 0 || len(b) < 2 {
+		return
+	}
+	e := Event{Time: time.Now(), Code: b[0], Raw: append([]byte(nil), b...)}
+	if e.Code == leMetaCode && len(b) > 2 {
+		e.SubCode = b[2]
+	}
+	e.Name = evt.Name(e.Code, e.SubCode)
+	for _, t := range h.taps {
+		select {
+		case t.c <- e:
+		default:
+			atomic.AddUint64(&t.dropped, 1)
+		}
+	}
+}
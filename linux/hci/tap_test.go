@@ -0,0 +1,50 @@
+package hci_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+func TestTapEvents(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	c := pair.Central
+
+	tap := c.HCI.TapEvents(64)
+	full := c.HCI.TapEvents(0)
+	defer full.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	defer cln.CancelConnection()
+
+	var complete bool
+	for !complete {
+		select {
+		case e := <-tap.C:
+			if e.Name == "Command Complete" && e.Code != evt.CommandCompleteCode {
+				t.Errorf("Command Complete with code 0x%02X", e.Code)
+			}
+			if int(e.Raw[1]) != len(e.Params()) {
+				t.Errorf("%s: parameter length %d, with %d parameters", e.Name, e.Raw[1], len(e.Params()))
+			}
+			if e.Name == "LE Enhanced Connection Complete" || e.Name == "LE Connection Complete" {
+				complete = true
+			}
+		case <-ctx.Done():
+			t.Fatal("no connection complete event")
+		}
+	}
+	// C is closed, after the events still buffered.
+	tap.Close()
+	for range tap.C {
+	}
+	if full.Dropped() == 0 {
+		t.Error("no events dropped by a full tap")
+	}
+}
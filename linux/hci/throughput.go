@@ -0,0 +1,76 @@
+package hci
+
+import (
+	"time"
+
+	"github.com/leso-kn/ble"
+)
+
+// NotifyThroughput is an estimate of the notification throughput achievable
+// to the central of a connection, from its ATT_MTU, its connection interval
+// and the controller's ACL buffers it may occupy.
+//
+// The host may only queue as many ACL packets as it has credits for, which
+// the controller returns as it transmits them, on the connection events. It
+// isn't an upper bound: the controller may transmit more packets per event,
+// or the central close the events early.
+type NotifyThroughput struct {
+	// ValueSize is the size of the largest value a notification carries,
+	// ATT_MTU - 3.
+	ValueSize int
+
+	// Fragments is the number of ACL packets a notification of ValueSize
+	// bytes is sent in.
+	Fragments int
+
+	// Credits is the number of ACL buffers of the controller the connection
+	// may occupy, its share of those of all the connections.
+	Credits int
+
+	// Interval is the connection interval the connection was established
+	// with.
+	Interval time.Duration
+
+	// PerEvent is the number of notifications which may be queued for a
+	// connection event, at least one.
+	PerEvent int
+
+	// Rate is the estimated number of notifications per second, and
+	// BytesPerSecond that of value bytes.
+	Rate           float64
+	BytesPerSecond float64
+}
+
+// NotifyThroughput estimates the notification throughput to the central of
+// c, for a streaming peripheral to size its values and pace its Notify calls,
+// e.g. with the Pacer of the estimate. It changes with the ATT_MTU, and with
+// the number of connections sharing the ACL buffers.
+func (c *Conn) NotifyThroughput() NotifyThroughput {
+	credits, size := c.txBuffer.Quota()
+	t := NotifyThroughput{
+		ValueSize: c.TxMTU() - 3,
+		Credits:   credits,
+		Interval:  c.ConnInfo().Interval,
+	}
+	// A notification is an L2CAP basic frame: a 4 octets header, the 3
+	// octets of the ATT PDU header and the value. [Vol 3, Part A, 3.1]
+	t.Fragments = 1
+	if size > 0 {
+		t.Fragments = (4 + 3 + t.ValueSize + size - 1) / size
+	}
+	t.PerEvent = credits / t.Fragments
+	if t.PerEvent < 1 {
+		t.PerEvent = 1
+	}
+	if t.Interval > 0 {
+		t.Rate = float64(t.PerEvent) / t.Interval.Seconds()
+		t.BytesPerSecond = t.Rate * float64(t.ValueSize)
+	}
+	return t
+}
+
+// Pacer returns a pacer of the notifications of n at the estimated rate,
+// allowing bursts of a connection event.
+func (t NotifyThroughput) Pacer(n ble.Notifier) *ble.NotifyPacer {
+	return ble.NewNotifyPacer(n, t.Rate, t.PerEvent)
+}
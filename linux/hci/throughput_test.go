@@ -0,0 +1,45 @@
+package hci
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+func TestNotifyThroughput(t *testing.T) {
+	// 8 buffers of 27 octets of payload.
+	p, err := NewPool(1+4+27, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &HCI{done: make(chan bool), pool: p, Logger: ble.GetLogger()}
+	defer close(h.done)
+
+	// Handle 0x0041, peripheral role, interval 15ms.
+	e := evt.LEConnectionComplete{evt.LEConnectionCompleteSubCode, 0x00, 0x41, 0x00, 0x01, 0x00,
+		0x66, 0x55, 0x44, 0x33, 0x22, 0x11, 0x0c, 0x00, 0x00, 0x00, 0x48, 0x00, 0x00}
+	c := newConn(h, e, connRPA{}, "112233445566")
+
+	// An ATT_MTU of 23 fits a single fragment.
+	c.SetTxMTU(23)
+	want := NotifyThroughput{ValueSize: 20, Fragments: 1, Credits: 8, Interval: 15 * time.Millisecond, PerEvent: 8}
+	got := c.NotifyThroughput()
+	if got.Rate < 533 || got.Rate > 534 || got.BytesPerSecond != got.Rate*20 {
+		t.Errorf("rate %v, %v B/s", got.Rate, got.BytesPerSecond)
+	}
+	got.Rate, got.BytesPerSecond = 0, 0
+	if got != want {
+		t.Errorf("%+v, want %+v", got, want)
+	}
+
+	// 247 octets take 10 fragments, more than the credits of the
+	// connection, which shares the buffers with another.
+	c.SetTxMTU(247)
+	NewClient(p)
+	got = c.NotifyThroughput()
+	if got.ValueSize != 244 || got.Fragments != 10 || got.Credits != 4 || got.PerEvent != 1 {
+		t.Errorf("%+v with an ATT_MTU of 247", got)
+	}
+}
@@ -0,0 +1,414 @@
+package trace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/att"
+)
+
+const (
+	pktTypeCommand = 0x01
+	pktTypeACLData = 0x02
+	pktTypeEvent   = 0x04
+
+	evtLEMeta = 0x3E
+
+	cidATT       = 0x0004
+	cidLESignal  = 0x0005
+	cidSMP       = 0x0006
+	pbfContinued = 0x01
+)
+
+// Packet decodes an HCI packet, starting with its H4 type octet. The ATT and
+// SMP PDUs of ACL data are decoded in full; commands and events are only
+// named by their codes.
+func Packet(b []byte) string {
+	if len(b) == 0 {
+		return "empty packet"
+	}
+	p := b[1:]
+	switch b[0] {
+	case pktTypeCommand:
+		if len(p) < 3 {
+			return fmt.Sprintf("HCI Command, truncated: % X", p)
+		}
+		op := binary.LittleEndian.Uint16(p)
+		return fmt.Sprintf("HCI Command 0x%02x|0x%04x plen %d", op>>10, op&0x3FF, p[2])
+	case pktTypeEvent:
+		if len(p) < 2 {
+			return fmt.Sprintf("HCI Event, truncated: % X", p)
+		}
+		if p[0] == evtLEMeta && len(p) >= 3 {
+			return fmt.Sprintf("HCI Event LE Meta 0x%02x plen %d", p[2], p[1])
+		}
+		return fmt.Sprintf("HCI Event 0x%02x plen %d", p[0], p[1])
+	case pktTypeACLData:
+		return acl(p)
+	}
+	return fmt.Sprintf("packet type 0x%02x, %d bytes", b[0], len(p))
+}
+
+func acl(p []byte) string {
+	if len(p) < 4 {
+		return fmt.Sprintf("ACL Data, truncated: % X", p)
+	}
+	h := binary.LittleEndian.Uint16(p)
+	s := fmt.Sprintf("ACL Data handle 0x%04x", h&0x0FFF)
+	p = p[4:]
+	if (h>>12)&0x3 == pbfContinued {
+		return fmt.Sprintf("%s continued, %d bytes", s, len(p))
+	}
+	if len(p) < 4 {
+		return fmt.Sprintf("%s, truncated: % X", s, p)
+	}
+	l := int(binary.LittleEndian.Uint16(p))
+	cid := binary.LittleEndian.Uint16(p[2:])
+	pdu := p[4:]
+	if len(pdu) < l {
+		// The start of a PDU continued by the next fragments.
+		s += fmt.Sprintf(" (%d of %d bytes)", len(pdu), l)
+	}
+	switch cid {
+	case cidATT:
+		return s + " ATT: " + ATT(pdu)
+	case cidSMP:
+		return s + " SMP: " + SMP(pdu)
+	case cidLESignal:
+		if len(pdu) > 0 {
+			return fmt.Sprintf("%s L2CAP Signaling code 0x%02x", s, pdu[0])
+		}
+	}
+	return fmt.Sprintf("%s L2CAP cid 0x%04x: % X", s, cid, pdu)
+}
+
+var attNames = map[byte]string{
+	att.ErrorResponseCode:           "Error Response",
+	att.ExchangeMTURequestCode:      "Exchange MTU Request",
+	att.ExchangeMTUResponseCode:     "Exchange MTU Response",
+	att.FindInformationRequestCode:  "Find Information Request",
+	att.FindInformationResponseCode: "Find Information Response",
+	att.FindByTypeValueRequestCode:  "Find By Type Value Request",
+	att.FindByTypeValueResponseCode: "Find By Type Value Response",
+	att.ReadByTypeRequestCode:       "Read By Type Request",
+	att.ReadByTypeResponseCode:      "Read By Type Response",
+	att.ReadRequestCode:             "Read Request",
+	att.ReadResponseCode:            "Read Response",
+	att.ReadBlobRequestCode:         "Read Blob Request",
+	att.ReadBlobResponseCode:        "Read Blob Response",
+	att.ReadMultipleRequestCode:     "Read Multiple Request",
+	att.ReadMultipleResponseCode:    "Read Multiple Response",
+	att.ReadByGroupTypeRequestCode:  "Read By Group Type Request",
+	att.ReadByGroupTypeResponseCode: "Read By Group Type Response",
+	att.WriteRequestCode:            "Write Request",
+	att.WriteResponseCode:           "Write Response",
+	att.WriteCommandCode:            "Write Command",
+	att.SignedWriteCommandCode:      "Signed Write Command",
+	att.PrepareWriteRequestCode:     "Prepare Write Request",
+	att.PrepareWriteResponseCode:    "Prepare Write Response",
+	att.ExecuteWriteRequestCode:     "Execute Write Request",
+	att.ExecuteWriteResponseCode:    "Execute Write Response",
+	att.HandleValueNotificationCode: "Handle Value Notification",
+	att.HandleValueIndicationCode:   "Handle Value Indication",
+	att.HandleValueConfirmationCode: "Handle Value Confirmation",
+}
+
+func attName(op byte) string {
+	if n, ok := attNames[op]; ok {
+		return n
+	}
+	return fmt.Sprintf("Unknown opcode 0x%02x", op)
+}
+
+// ATT decodes an ATT PDU [Vol 3, Part F, 3.4].
+func ATT(b []byte) string {
+	if len(b) == 0 {
+		return "empty PDU"
+	}
+	d := &decoder{b: b[1:]}
+	d.printf("%s (0x%02x)", attName(b[0]), b[0])
+	switch b[0] {
+	case att.ErrorResponseCode:
+		op := d.u8()
+		h := d.u16()
+		e := d.u8()
+		if d.ok() {
+			d.printf(" %s handle 0x%04x: %s (0x%02x)", attName(op), h, ble.ATTError(e), e)
+		}
+	case att.ExchangeMTURequestCode, att.ExchangeMTUResponseCode:
+		d.field("mtu %d", d.u16())
+	case att.FindInformationRequestCode, att.ReadByTypeRequestCode, att.ReadByGroupTypeRequestCode:
+		start, end := d.u16(), d.u16()
+		d.field("handles 0x%04x-0x%04x", start, end)
+		if b[0] != att.FindInformationRequestCode {
+			d.field("type %s", uuid(d.rest()))
+		}
+	case att.FindInformationResponseCode:
+		n := 2
+		if d.u8() == 0x02 {
+			n = 16
+		}
+		for d.ok() && len(d.b) >= 2+n {
+			h := d.u16()
+			d.field("0x%04x %s", h, uuid(d.bytes(n)))
+		}
+	case att.FindByTypeValueRequestCode:
+		start, end, t := d.u16(), d.u16(), d.bytes(2)
+		d.field("handles 0x%04x-0x%04x type %s value % X", start, end, uuid(t), d.rest())
+	case att.FindByTypeValueResponseCode:
+		for d.ok() && len(d.b) >= 4 {
+			d.field("0x%04x-0x%04x", d.u16(), d.u16())
+		}
+	case att.ReadByTypeResponseCode, att.ReadByGroupTypeResponseCode:
+		n := int(d.u8())
+		hn := 2
+		if b[0] == att.ReadByGroupTypeResponseCode {
+			hn = 4
+		}
+		for d.ok() && n > hn && len(d.b) >= n {
+			e := &decoder{b: d.bytes(n)}
+			h := e.u16()
+			if hn == 4 {
+				// The group is a service, whose UUID is the value.
+				d.field("0x%04x-0x%04x %s", h, e.u16(), uuid(e.rest()))
+			} else {
+				d.field("0x%04x: % X", h, e.rest())
+			}
+		}
+	case att.ReadRequestCode:
+		d.field("handle 0x%04x", d.u16())
+	case att.ReadBlobRequestCode:
+		h, off := d.u16(), d.u16()
+		d.field("handle 0x%04x offset %d", h, off)
+	case att.ReadMultipleRequestCode:
+		for d.ok() && len(d.b) >= 2 {
+			d.field("0x%04x", d.u16())
+		}
+	case att.ReadResponseCode, att.ReadBlobResponseCode, att.ReadMultipleResponseCode:
+		d.field("value % X", d.rest())
+	case att.WriteRequestCode, att.WriteCommandCode,
+		att.HandleValueNotificationCode, att.HandleValueIndicationCode:
+		h := d.u16()
+		d.field("handle 0x%04x value % X", h, d.rest())
+	case att.SignedWriteCommandCode:
+		h := d.u16()
+		if len(d.b) < 12 {
+			d.err = true
+			break
+		}
+		v := d.bytes(len(d.b) - 12)
+		d.field("handle 0x%04x value % X signature % X", h, v, d.rest())
+	case att.PrepareWriteRequestCode, att.PrepareWriteResponseCode:
+		h, off := d.u16(), d.u16()
+		d.field("handle 0x%04x offset %d value % X", h, off, d.rest())
+	case att.ExecuteWriteRequestCode:
+		switch f := d.u8(); f {
+		case 0x00:
+			d.field("cancel")
+		case 0x01:
+			d.field("write")
+		default:
+			d.field("flags 0x%02x", f)
+		}
+	}
+	return d.String()
+}
+
+var smpNames = map[byte]string{
+	0x01: "Pairing Request",
+	0x02: "Pairing Response",
+	0x03: "Pairing Confirm",
+	0x04: "Pairing Random",
+	0x05: "Pairing Failed",
+	0x06: "Encryption Information",
+	0x07: "Central Identification",
+	0x08: "Identity Information",
+	0x09: "Identity Address Information",
+	0x0A: "Signing Information",
+	0x0B: "Security Request",
+	0x0C: "Pairing Public Key",
+	0x0D: "Pairing DHKey Check",
+	0x0E: "Pairing Keypress Notification",
+}
+
+// smpReasons are the reasons of Pairing Failed [Vol 3, Part H, 3.5.5].
+var smpReasons = map[byte]string{
+	0x01: "Passkey Entry Failed",
+	0x02: "OOB Not Available",
+	0x03: "Authentication Requirements",
+	0x04: "Confirm Value Failed",
+	0x05: "Pairing Not Supported",
+	0x06: "Encryption Key Size",
+	0x07: "Command Not Supported",
+	0x08: "Unspecified Reason",
+	0x09: "Repeated Attempts",
+	0x0A: "Invalid Parameters",
+	0x0B: "DHKey Check Failed",
+	0x0C: "Numeric Comparison Failed",
+	0x0D: "BR/EDR Pairing In Progress",
+	0x0E: "Cross-transport Key Derivation Not Allowed",
+	0x0F: "Key Rejected",
+}
+
+var ioCapabilities = []string{"DisplayOnly", "DisplayYesNo", "KeyboardOnly", "NoInputNoOutput", "KeyboardDisplay"}
+
+// SMP decodes a Security Manager Protocol PDU [Vol 3, Part H, 3.3].
+func SMP(b []byte) string {
+	if len(b) == 0 {
+		return "empty PDU"
+	}
+	d := &decoder{b: b[1:]}
+	name, ok := smpNames[b[0]]
+	if !ok {
+		name = fmt.Sprintf("Unknown code 0x%02x", b[0])
+	}
+	d.printf("%s (0x%02x)", name, b[0])
+	switch b[0] {
+	case 0x01, 0x02:
+		io, oob, auth, size, ikd, rkd := d.u8(), d.u8(), d.u8(), d.u8(), d.u8(), d.u8()
+		if !d.ok() {
+			break
+		}
+		ioc := fmt.Sprintf("0x%02x", io)
+		if int(io) < len(ioCapabilities) {
+			ioc = ioCapabilities[io]
+		}
+		d.field("io %s oob %t auth %s key size %d keys 0x%02x/0x%02x", ioc, oob == 0x01, authReq(auth), size, ikd, rkd)
+	case 0x05:
+		r := d.u8()
+		if d.ok() {
+			n, ok := smpReasons[r]
+			if !ok {
+				n = "Unknown"
+			}
+			d.field("reason %s (0x%02x)", n, r)
+		}
+	case 0x07:
+		ediv := d.u16()
+		d.field("ediv 0x%04x rand % X", ediv, d.rest())
+	case 0x09:
+		t := d.u8()
+		a := d.bytes(6)
+		if d.ok() {
+			typ := "public"
+			if t == 0x01 {
+				typ = "random"
+			}
+			d.field("address %s (%s)", addr(a), typ)
+		}
+	case 0x0B:
+		d.field("auth %s", authReq(d.u8()))
+	case 0x0E:
+		d.field("type %d", d.u8())
+	default:
+		// Confirm and random values, keys, and DHKey checks.
+		if len(d.b) > 0 {
+			d.field("% X", d.rest())
+		}
+	}
+	return d.String()
+}
+
+// authReq decodes the AuthReq flags of SMP [Vol 3, Part H, 3.5.1].
+func authReq(a uint8) string {
+	var f []string
+	if a&0x03 == 0x01 {
+		f = append(f, "bonding")
+	}
+	if a&0x04 != 0 {
+		f = append(f, "mitm")
+	}
+	if a&0x08 != 0 {
+		f = append(f, "sc")
+	}
+	if a&0x10 != 0 {
+		f = append(f, "keypress")
+	}
+	if len(f) == 0 {
+		return "none"
+	}
+	return strings.Join(f, "|")
+}
+
+func uuid(b []byte) string {
+	if len(b) != 2 && len(b) != 4 && len(b) != 16 {
+		return fmt.Sprintf("% X", b)
+	}
+	u := ble.UUID(b)
+	if n := ble.Name(u); n != "" {
+		return fmt.Sprintf("%s (%s)", u, n)
+	}
+	return u.String()
+}
+
+func addr(b []byte) string {
+	a := make([]string, len(b))
+	for i := range b {
+		a[len(b)-1-i] = fmt.Sprintf("%02X", b[i])
+	}
+	return strings.Join(a, ":")
+}
+
+// decoder reads the fields of a PDU, and writes their description. Reading
+// past the end marks the PDU as truncated.
+type decoder struct {
+	b   []byte
+	s   strings.Builder
+	err bool
+}
+
+func (d *decoder) ok() bool { return !d.err }
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err || len(d.b) < n {
+		d.err = true
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+func (d *decoder) rest() []byte {
+	b := d.b
+	d.b = nil
+	return b
+}
+
+func (d *decoder) u8() uint8 {
+	b := d.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) u16() uint16 {
+	b := d.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (d *decoder) printf(format string, a ...interface{}) {
+	fmt.Fprintf(&d.s, format, a...)
+}
+
+// field describes a field, unless the PDU is truncated.
+func (d *decoder) field(format string, a ...interface{}) {
+	if d.err {
+		return
+	}
+	d.s.WriteByte(' ')
+	d.printf(format, a...)
+}
+
+func (d *decoder) String() string {
+	if d.err {
+		return d.s.String() + ", truncated"
+	}
+	return d.s.String()
+}
@@ -0,0 +1,78 @@
+package trace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestATT(t *testing.T) {
+	for _, tc := range []struct {
+		pdu  []byte
+		want string
+	}{
+		{[]byte{0x02, 0x05, 0x02}, "Exchange MTU Request (0x02) mtu 517"},
+		{[]byte{0x01, 0x0A, 0x03, 0x00, 0x02}, "Error Response (0x01) Read Request handle 0x0003: read not permitted (0x02)"},
+		{[]byte{0x10, 0x01, 0x00, 0xFF, 0xFF, 0x00, 0x28}, "Read By Group Type Request (0x10) handles 0x0001-0xffff type 2800 (Primary Service)"},
+		{[]byte{0x11, 0x06, 0x01, 0x00, 0x05, 0x00, 0x0D, 0x18}, "Read By Group Type Response (0x11) 0x0001-0x0005 180d (Heart Rate)"},
+		{[]byte{0x05, 0x01, 0x03, 0x00, 0x02, 0x29}, "Find Information Response (0x05) 0x0003 2902 (Client Characteristic Configuration)"},
+		{[]byte{0x12, 0x03, 0x00, 0x01, 0x00}, "Write Request (0x12) handle 0x0003 value 01 00"},
+		{[]byte{0x1B, 0x10, 0x00, 0xAA}, "Handle Value Notification (0x1b) handle 0x0010 value AA"},
+		{[]byte{0x0C, 0x03}, "Read Blob Request (0x0c), truncated"},
+		{[]byte{0x7F}, "Unknown opcode 0x7f (0x7f)"},
+	} {
+		if s := ATT(tc.pdu); s != tc.want {
+			t.Errorf("ATT(% X) = %q, want %q", tc.pdu, s, tc.want)
+		}
+	}
+}
+
+func TestSMP(t *testing.T) {
+	for _, tc := range []struct {
+		pdu  []byte
+		want string
+	}{
+		{[]byte{0x01, 0x03, 0x00, 0x0D, 0x10, 0x01, 0x01}, "Pairing Request (0x01) io NoInputNoOutput oob false auth bonding|mitm|sc key size 16 keys 0x01/0x01"},
+		{[]byte{0x05, 0x04}, "Pairing Failed (0x05) reason Confirm Value Failed (0x04)"},
+		{[]byte{0x09, 0x00, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11}, "Identity Address Information (0x09) address 11:22:33:44:55:66 (public)"},
+		{[]byte{0x0B, 0x05}, "Security Request (0x0b) auth bonding|mitm"},
+	} {
+		if s := SMP(tc.pdu); s != tc.want {
+			t.Errorf("SMP(% X) = %q, want %q", tc.pdu, s, tc.want)
+		}
+	}
+}
+
+func TestPacket(t *testing.T) {
+	for _, tc := range []struct {
+		pkt  []byte
+		want string
+	}{
+		{[]byte{0x01, 0x03, 0x0C, 0x00}, "HCI Command 0x03|0x0003 plen 0"},
+		{[]byte{0x04, 0x3E, 0x13, 0x01}, "HCI Event LE Meta 0x01 plen 19"},
+		{[]byte{0x02, 0x40, 0x20, 0x07, 0x00, 0x03, 0x00, 0x04, 0x00, 0x0A, 0x03, 0x00},
+			"ACL Data handle 0x0040 ATT: Read Request (0x0a) handle 0x0003"},
+		{[]byte{0x02, 0x40, 0x20, 0x06, 0x00, 0x02, 0x00, 0x06, 0x00, 0x05, 0x08},
+			"ACL Data handle 0x0040 SMP: Pairing Failed (0x05) reason Unspecified Reason (0x08)"},
+		{[]byte{0x02, 0x40, 0x10, 0x02, 0x00, 0xAA, 0xBB}, "ACL Data handle 0x0040 continued, 2 bytes"},
+	} {
+		if s := Packet(tc.pkt); s != tc.want {
+			t.Errorf("Packet(% X) = %q, want %q", tc.pkt, s, tc.want)
+		}
+	}
+}
+
+type loopback struct{ bytes.Buffer }
+
+func (l *loopback) Close() error { return nil }
+
+func TestTracer(t *testing.T) {
+	var out bytes.Buffer
+	tr := NewTracer(&loopback{}, &out)
+	tr.Write([]byte{0x02, 0x40, 0x00, 0x07, 0x00, 0x03, 0x00, 0x04, 0x00, 0x0A, 0x03, 0x00})
+	tr.Read(make([]byte, 64))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], " < ACL Data") || !strings.Contains(lines[1], " > ACL Data") {
+		t.Fatalf("trace:\n%s", out.String())
+	}
+}
@@ -0,0 +1,56 @@
+// Package trace decodes HCI packets, and the ATT and SMP PDUs they carry,
+// into human-readable lines, and traces the packets of a transport with
+// them, giving logs similar to btmon without root or other tools.
+package trace
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Tracer wraps a transport, and writes a line decoding each packet passing
+// through it to w. Lines of packets sent to the controller start with '<',
+// and those of packets received from it with '>'.
+type Tracer struct {
+	rwc io.ReadWriteCloser
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewTracer returns a Tracer of the transport rwc, writing to w.
+func NewTracer(rwc io.ReadWriteCloser, w io.Writer) *Tracer {
+	return &Tracer{rwc: rwc, w: w}
+}
+
+// Read reads a packet from the controller.
+func (t *Tracer) Read(b []byte) (int, error) {
+	n, err := t.rwc.Read(b)
+	if n > 0 {
+		t.trace('>', b[:n])
+	}
+	return n, err
+}
+
+// Write writes a packet to the controller.
+func (t *Tracer) Write(b []byte) (int, error) {
+	n, err := t.rwc.Write(b)
+	if n > 0 {
+		t.trace('<', b[:n])
+	}
+	return n, err
+}
+
+// Close closes the transport.
+func (t *Tracer) Close() error {
+	return t.rwc.Close()
+}
+
+func (t *Tracer) trace(dir byte, b []byte) {
+	s := Packet(b)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "%s %c %s\n", time.Now().Format("15:04:05.000000"), dir, s)
+}
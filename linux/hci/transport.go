@@ -6,44 +6,102 @@ import (
 	"time"
 
 	"github.com/leso-kn/ble/linux/hci/h4"
+	"github.com/leso-kn/ble/linux/hci/replay"
 	"github.com/leso-kn/ble/linux/hci/socket"
+	"github.com/leso-kn/ble/linux/hci/trace"
 )
 
+// DeviceInfo describes a HCI device available to the transport.
+type DeviceInfo = socket.DeviceInfo
+
+// List returns the HCI devices available on the system, with their
+// addresses and up/down state.
+func List() ([]DeviceInfo, error) {
+	return socket.List()
+}
+
 type transportHci struct {
 	id int
+	ch socket.Channel
 }
 
 type transportH4Socket struct {
 	addr    string
 	timeout time.Duration
+	auth    *h4.SocketAuth
 }
 
 type transportH4Uart struct {
-	path string
-	baud int
+	path     string
+	baud     int
+	vendor   h4.Vendor
+	initBaud uint
+}
+
+type transportVirtual struct {
+	ctrl   io.ReadWriteCloser
+	opened bool
+}
+
+// reopener is implemented by the virtual controllers, which are replaced by a
+// new controller once closed, e.g. when the watchdog recovers the device.
+type reopener interface {
+	Reopen() (io.ReadWriteCloser, error)
 }
 
 type transport struct {
 	hci      *transportHci
 	h4uart   *transportH4Uart
 	h4socket *transportH4Socket
+	virtual  *transportVirtual
+
+	// record, if set, receives a btsnoop trace of the session.
+	record io.Writer
+	// trace, if set, receives a human-readable decode of the session.
+	trace io.Writer
 }
 
 func getTransport(t transport) (io.ReadWriteCloser, error) {
+	rwc, err := openTransport(t)
+	if err != nil {
+		return nil, err
+	}
+	if t.trace != nil {
+		rwc = trace.NewTracer(rwc, t.trace)
+	}
+	if t.record == nil {
+		return rwc, nil
+	}
+	r, err := replay.NewRecorder(rwc, t.record)
+	if err != nil {
+		rwc.Close()
+		return nil, fmt.Errorf("can't record transport: %v", err)
+	}
+	return r, nil
+}
+
+func openTransport(t transport) (io.ReadWriteCloser, error) {
 	switch {
 	case t.hci != nil:
-		return socket.NewSocket(t.hci.id)
+		return socket.Open(t.hci.id, t.hci.ch)
 
 	case t.h4socket != nil:
-		return h4.NewSocket(t.h4socket.addr, t.h4socket.timeout)
+		return h4.NewSocketWithAuth(t.h4socket.addr, t.h4socket.timeout, t.h4socket.auth)
 
 	case t.h4uart != nil:
-		so := h4.DefaultSerialOptions()
-		so.PortName = t.h4uart.path
-		if t.h4uart.baud != -1 {
-			so.BaudRate = uint(t.h4uart.baud)
+		return openH4Uart(t.h4uart)
+
+	case t.virtual != nil:
+		v := t.virtual
+		if r, ok := v.ctrl.(reopener); ok && v.opened {
+			ctrl, err := r.Reopen()
+			if err != nil {
+				return nil, err
+			}
+			v.ctrl = ctrl
 		}
-		return h4.NewSerial(so)
+		v.opened = true
+		return v.ctrl, nil
 
 	default:
 		return nil, fmt.Errorf("no valid transport found")
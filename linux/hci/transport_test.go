@@ -0,0 +1,24 @@
+package hci
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// TestFreeBSDBuild cross-builds the device for FreeBSD, where the HCI
+// socket works but the H4 UART transport doesn't.
+func TestFreeBSDBuild(t *testing.T) {
+	if testing.Short() {
+		t.Skip("cross-build skipped in short mode")
+	}
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	cmd := exec.Command(gocmd, "build", "github.com/leso-kn/ble/linux", "github.com/leso-kn/ble/linux/hci")
+	cmd.Env = append(os.Environ(), "GOOS=freebsd", "GOARCH=amd64", "CGO_ENABLED=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("GOOS=freebsd go build: %v\n%s", err, out)
+	}
+}
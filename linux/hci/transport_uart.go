@@ -0,0 +1,22 @@
+//go:build !freebsd
+// +build !freebsd
+
+package hci
+
+import (
+	"io"
+
+	"github.com/leso-kn/ble/linux/hci/h4"
+)
+
+func openH4Uart(u *transportH4Uart) (io.ReadWriteCloser, error) {
+	so := h4.DefaultSerialOptions()
+	so.PortName = u.path
+	if u.baud != -1 {
+		so.BaudRate = uint(u.baud)
+	}
+	if u.vendor != nil {
+		return h4.NewSerialWithVendor(so, u.vendor, u.initBaud)
+	}
+	return h4.NewSerial(so)
+}
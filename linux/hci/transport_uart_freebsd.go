@@ -0,0 +1,15 @@
+//go:build freebsd
+// +build freebsd
+
+package hci
+
+import (
+	"fmt"
+	"io"
+)
+
+// openH4Uart fails on FreeBSD, which the serial port package doesn't
+// support.
+func openH4Uart(u *transportH4Uart) (io.ReadWriteCloser, error) {
+	return nil, fmt.Errorf("h4 uart transport not supported on freebsd")
+}
@@ -0,0 +1,42 @@
+package hci_test
+
+import (
+	"testing"
+
+	"github.com/leso-kn/ble/internal/virtualtest"
+)
+
+func TestAdvertisingSetTxPower(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p := pair.Peripheral.HCI
+
+	// The virtual controller transmits at up to +10 dBm, and at 0 dBm
+	// without a preference.
+	for _, tc := range []struct {
+		handle uint8
+		level  int8
+		want   int8
+	}{
+		{0, -20, -20},
+		{1, 20, 10},
+		{2, 127, 0},
+	} {
+		got, err := p.SetAdvertisingSetTxPower(tc.handle, tc.level)
+		if err != nil {
+			t.Fatalf("set %d: %v", tc.handle, err)
+		}
+		if got != tc.want {
+			t.Errorf("set %d: %d dBm selected for %d dBm, want %d dBm", tc.handle, got, tc.level, tc.want)
+		}
+		if l, ok := p.AdvertisingSetTxPower(tc.handle); !ok || l != tc.want {
+			t.Errorf("set %d: level %d, %v", tc.handle, l, ok)
+		}
+	}
+	if _, err := p.SetAdvertisingSetTxPower(0, 21); err == nil {
+		t.Error("set a tx power level above +20 dBm")
+	}
+	if _, ok := p.AdvertisingSetTxPower(3); ok {
+		t.Error("level of an advertising set not set up")
+	}
+}
@@ -13,6 +13,13 @@ type CustomCommand struct {
 	length  int
 }
 
+// NewCustomCommand returns a command with the given opcode, whose parameters
+// are payload serialized in little endian. length is the length of the
+// serialized payload.
+func NewCustomCommand(opCode int, length int, payload interface{}) *CustomCommand {
+	return &CustomCommand{Payload: payload, opCode: opCode, length: length}
+}
+
 func (c *CustomCommand) OpCode() int {
 	return c.opCode
 }
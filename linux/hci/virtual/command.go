@@ -0,0 +1,666 @@
+package virtual
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/leso-kn/ble/linux/hci"
+	"github.com/leso-kn/ble/linux/hci/cmd"
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+const (
+	evtDisconnectionComplete        = evt.DisconnectionCompleteCode
+	evtEncryptionChange             = evt.EncryptionChangeCode
+	evtReadRemoteVersionComplete    = evt.ReadRemoteVersionInformationCompleteCode
+	evtCommandComplete              = evt.CommandCompleteCode
+	evtCommandStatus                = evt.CommandStatusCode
+	evtNumberOfCompletedPackets     = evt.NumberOfCompletedPacketsCode
+	evtEncryptionKeyRefreshComplete = evt.EncryptionKeyRefreshCompleteCode
+	evtLEMeta                       = evt.LEConnectionCompleteCode
+
+	errUnknownCommand = byte(hci.ErrUnknownCommand)
+	errConnID         = byte(hci.ErrConnID)
+	errPINMissing     = byte(hci.ErrPINMissing)
+	errConnTimeout    = byte(hci.ErrConnTimeout)
+	errDisallowed     = byte(hci.ErrDisallowed)
+	errConnLimit      = byte(hci.ErrConnLimit)
+	errMemoryCapacity = byte(hci.ErrMemoryCapacity)
+	errInvalidParams  = byte(hci.ErrInvalidParams)
+	errLocalHost      = byte(hci.ErrLocalHost)
+	errMIC            = byte(hci.ErrMIC)
+
+	// Controller properties.
+	aclDataPacketLength = 251
+	aclDataPackets      = 8
+	leFeatures          = 0x01 // LE Encryption
+	lmpNoBREDR          = 1 << 37
+	acceptListSize      = 8
+	version             = 0x09 // Core 5.0
+	maxTxPower          = 10   // dBm; the advertising channel power is 0 dBm.
+	manufacturer        = 0x05F1
+	rssi                = 0xD8 // -40 dBm
+
+	// reportInterval is how often advertisers are reported while scanning.
+	reportInterval = 20 * time.Millisecond
+)
+
+var (
+	opReset                             = (&cmd.Reset{}).OpCode()
+	opSetEventMask                      = (&cmd.SetEventMask{}).OpCode()
+	opSetEventMaskPage2                 = (&cmd.SetEventMaskPage2{}).OpCode()
+	opWriteLEHostSupport                = (&cmd.WriteLEHostSupport{}).OpCode()
+	opReadLocalVersionInformation       = (&cmd.ReadLocalVersionInformation{}).OpCode()
+	opReadBufferSize                    = (&cmd.ReadBufferSize{}).OpCode()
+	opReadLocalSupportedFeatures        = (&cmd.ReadLocalSupportedFeatures{}).OpCode()
+	opWriteScanEnable                   = (&cmd.WriteScanEnable{}).OpCode()
+	opReadBDADDR                        = (&cmd.ReadBDADDR{}).OpCode()
+	opReadRSSI                          = (&cmd.ReadRSSI{}).OpCode()
+	opDisconnect                        = (&cmd.Disconnect{}).OpCode()
+	opReadRemoteVersionInformation      = (&cmd.ReadRemoteVersionInformation{}).OpCode()
+	opLESetEventMask                    = (&cmd.LESetEventMask{}).OpCode()
+	opLEReadBufferSize                  = (&cmd.LEReadBufferSize{}).OpCode()
+	opLEReadLocalSupportedFeatures      = (&cmd.LEReadLocalSupportedFeatures{}).OpCode()
+	opLESetRandomAddress                = (&cmd.LESetRandomAddress{}).OpCode()
+	opLESetAdvertisingParameters        = (&cmd.LESetAdvertisingParameters{}).OpCode()
+	opLEReadAdvertisingChannelTxPower   = (&cmd.LEReadAdvertisingChannelTxPower{}).OpCode()
+	opLESetExtAdvParameters             = (&cmd.LESetExtendedAdvertisingParameters{}).OpCode()
+	opLESetAdvertisingData              = (&cmd.LESetAdvertisingData{}).OpCode()
+	opLESetScanResponseData             = (&cmd.LESetScanResponseData{}).OpCode()
+	opLESetAdvertiseEnable              = (&cmd.LESetAdvertiseEnable{}).OpCode()
+	opLESetScanParameters               = (&cmd.LESetScanParameters{}).OpCode()
+	opLESetScanEnable                   = (&cmd.LESetScanEnable{}).OpCode()
+	opLECreateConnection                = (&cmd.LECreateConnection{}).OpCode()
+	opLECreateConnectionCancel          = (&cmd.LECreateConnectionCancel{}).OpCode()
+	opLEConnectionUpdate                = (&cmd.LEConnectionUpdate{}).OpCode()
+	opLEReadRemoteUsedFeatures          = (&cmd.LEReadRemoteUsedFeatures{}).OpCode()
+	opLEStartEncryption                 = (&cmd.LEStartEncryption{}).OpCode()
+	opLELongTermKeyRequestReply         = (&cmd.LELongTermKeyRequestReply{}).OpCode()
+	opLELongTermKeyRequestNegativeReply = (&cmd.LELongTermKeyRequestNegativeReply{}).OpCode()
+	opLEWriteSuggestedDefaultDataLength = (&cmd.LEWriteSuggestedDefaultDataLength{}).OpCode()
+	opLEReadWhiteListSize               = (&cmd.LEReadWhiteListSize{}).OpCode()
+	opLEClearWhiteList                  = (&cmd.LEClearWhiteList{}).OpCode()
+	opLEAddDeviceToWhiteList            = (&cmd.LEAddDeviceToWhiteList{}).OpCode()
+	opLERemoveDeviceFromWhiteList       = (&cmd.LERemoveDeviceFromWhiteList{}).OpCode()
+)
+
+// command handles a command from the host. Called with air.mu held.
+func (c *Controller) command(op int, p []byte) {
+	switch op {
+	case opSetEventMask, opSetEventMaskPage2, opWriteLEHostSupport, opLESetEventMask,
+		opLEWriteSuggestedDefaultDataLength:
+		// Accepted; all events are delivered regardless of the masks.
+		c.complete(op, 0x00)
+
+	case opReset:
+		c.reset()
+		c.complete(op, 0x00)
+	case opReadLocalVersionInformation:
+		c.complete(op, rp(&cmd.ReadLocalVersionInformationRP{
+			HCIVersion:       version,
+			LMPPAMVersion:    version,
+			ManufacturerName: manufacturer,
+		})...)
+	case opReadBufferSize:
+		c.complete(op, rp(&cmd.ReadBufferSizeRP{
+			HCACLDataPacketLength:    aclDataPacketLength,
+			HCTotalNumACLDataPackets: aclDataPackets,
+		})...)
+	case opReadBDADDR:
+		c.complete(op, append([]byte{0x00}, c.addr[:]...)...)
+	case opReadLocalSupportedFeatures:
+		var f uint64 = lmpNoBREDR
+		if c.dualMode {
+			f = 0
+		}
+		c.complete(op, rp(&cmd.ReadLocalSupportedFeaturesRP{LMPFeatures: f})...)
+	case opWriteScanEnable:
+		if !c.dualMode {
+			c.complete(op, errUnknownCommand)
+			break
+		}
+		c.bredrScan = p[0]
+		c.complete(op, 0x00)
+	case opLEReadBufferSize:
+		c.complete(op, rp(&cmd.LEReadBufferSizeRP{
+			HCLEDataPacketLength:    aclDataPacketLength,
+			HCTotalNumLEDataPackets: aclDataPackets,
+		})...)
+	case opLEReadLocalSupportedFeatures:
+		c.complete(op, rp(&cmd.LEReadLocalSupportedFeaturesRP{LEFeatures: leFeatures})...)
+	case opLEReadAdvertisingChannelTxPower:
+		c.complete(op, 0x00, 0x00)
+	case opLESetExtAdvParameters:
+		// Only the TX power is emulated: the controller selects the level
+		// requested, up to its maximum, or 0 dBm if the host has no
+		// preference.
+		var m cmd.LESetExtendedAdvertisingParameters
+		if !c.decode(op, p, &m) {
+			return
+		}
+		pwr := m.AdvertisingTXPower
+		switch {
+		case pwr == 127:
+			pwr = 0
+		case pwr > 20:
+			c.complete(op, errInvalidParams)
+			return
+		case pwr > maxTxPower:
+			pwr = maxTxPower
+		}
+		c.complete(op, rp(&cmd.LESetExtendedAdvertisingParametersRP{SelectedTXPower: pwr})...)
+
+	case opLESetRandomAddress:
+		var m cmd.LESetRandomAddress
+		if !c.decode(op, p, &m) {
+			return
+		}
+		c.randomAddr = m.RandomAddress
+		c.complete(op, 0x00)
+	case opLESetAdvertisingParameters:
+		var m cmd.LESetAdvertisingParameters
+		if !c.decode(op, p, &m) {
+			return
+		}
+		if c.advertising {
+			c.complete(op, errDisallowed)
+			return
+		}
+		c.advParams = m
+		c.complete(op, 0x00)
+	case opLESetAdvertisingData:
+		var m cmd.LESetAdvertisingData
+		if !c.decode(op, p, &m) {
+			return
+		}
+		c.advData = append([]byte{}, m.AdvertisingData[:min(int(m.AdvertisingDataLength), 31)]...)
+		c.complete(op, 0x00)
+	case opLESetScanResponseData:
+		var m cmd.LESetScanResponseData
+		if !c.decode(op, p, &m) {
+			return
+		}
+		c.scanRspData = append([]byte{}, m.ScanResponseData[:min(int(m.ScanResponseDataLength), 31)]...)
+		c.complete(op, 0x00)
+	case opLESetAdvertiseEnable:
+		var m cmd.LESetAdvertiseEnable
+		if !c.decode(op, p, &m) {
+			return
+		}
+		if m.AdvertisingEnable != 0 && c.atLimit() && c.connectable(nil) {
+			c.complete(op, errConnLimit)
+			return
+		}
+		c.advertising = m.AdvertisingEnable != 0
+		c.complete(op, 0x00)
+		if c.advertising {
+			c.air.connectInitiators(c)
+		}
+
+	case opLEReadWhiteListSize:
+		c.complete(op, 0x00, acceptListSize)
+	case opLEClearWhiteList:
+		if c.acceptListInUse() {
+			c.complete(op, errDisallowed)
+			return
+		}
+		c.acceptList = nil
+		c.complete(op, 0x00)
+	case opLEAddDeviceToWhiteList, opLERemoveDeviceFromWhiteList:
+		var m cmd.LEAddDeviceToWhiteList
+		if !c.decode(op, p, &m) {
+			return
+		}
+		if c.acceptListInUse() {
+			c.complete(op, errDisallowed)
+			return
+		}
+		k := acceptEntry{m.AddressType & 0x01, m.Address}
+		if op == opLERemoveDeviceFromWhiteList {
+			delete(c.acceptList, k)
+			c.complete(op, 0x00)
+			return
+		}
+		if c.acceptList == nil {
+			c.acceptList = map[acceptEntry]bool{}
+		}
+		if len(c.acceptList) >= acceptListSize && !c.acceptList[k] {
+			c.complete(op, errMemoryCapacity)
+			return
+		}
+		c.acceptList[k] = true
+		c.complete(op, 0x00)
+
+	case opLESetScanParameters:
+		var m cmd.LESetScanParameters
+		if !c.decode(op, p, &m) {
+			return
+		}
+		if c.scanning {
+			c.complete(op, errDisallowed)
+			return
+		}
+		c.scanParams = m
+		c.complete(op, 0x00)
+	case opLESetScanEnable:
+		var m cmd.LESetScanEnable
+		if !c.decode(op, p, &m) {
+			return
+		}
+		c.scanGen++
+		c.scanning = m.LEScanEnable != 0
+		c.filterDup = m.FilterDuplicates != 0
+		c.seen = map[string]bool{}
+		c.complete(op, 0x00)
+		if c.scanning {
+			go c.scanLoop(c.scanGen)
+		}
+
+	case opLECreateConnection:
+		var m cmd.LECreateConnection
+		if !c.decode(op, p, &m) {
+			return
+		}
+		if c.initiating != nil || (c.advertising && c.noAdvInit) {
+			c.status(op, errDisallowed)
+			return
+		}
+		if c.atLimit() {
+			c.status(op, errConnLimit)
+			return
+		}
+		c.initiating = &m
+		c.status(op, 0x00)
+		c.air.connectInitiators(nil)
+	case opLECreateConnectionCancel:
+		if c.initiating == nil {
+			c.complete(op, errDisallowed)
+			return
+		}
+		c.initiating = nil
+		c.complete(op, 0x00)
+		c.connectionComplete(errConnID, nil, 0x00, 0x00, [6]byte{})
+
+	case opDisconnect:
+		var m cmd.Disconnect
+		if !c.decode(op, p, &m) {
+			return
+		}
+		l, ok := c.links[m.ConnectionHandle]
+		if !ok {
+			c.status(op, errConnID)
+			return
+		}
+		c.status(op, 0x00)
+		c.drop(l, m.Reason, true)
+	case opReadRemoteVersionInformation:
+		var m cmd.ReadRemoteVersionInformation
+		if !c.decode(op, p, &m) {
+			return
+		}
+		h := m.ConnectionHandle
+		if _, ok := c.links[h]; !ok {
+			c.status(op, errConnID)
+			return
+		}
+		c.status(op, 0x00)
+		if c.remoteInfo != 0x00 {
+			c.event(evtReadRemoteVersionComplete, c.remoteInfo, byte(h), byte(h>>8), 0, 0, 0, 0, 0)
+			return
+		}
+		e := []byte{0x00, byte(h), byte(h >> 8), version, 0, 0, 0x00, 0x00}
+		binary.LittleEndian.PutUint16(e[4:], manufacturer)
+		c.event(evtReadRemoteVersionComplete, e...)
+	case opLEReadRemoteUsedFeatures:
+		var m cmd.LEReadRemoteUsedFeatures
+		if !c.decode(op, p, &m) {
+			return
+		}
+		h := m.ConnectionHandle
+		if _, ok := c.links[h]; !ok {
+			c.status(op, errConnID)
+			return
+		}
+		c.status(op, 0x00)
+		f := make([]byte, 8)
+		if c.remoteInfo == 0x00 {
+			binary.LittleEndian.PutUint64(f, leFeatures)
+		}
+		c.event(evtLEMeta, append([]byte{evt.LEReadRemoteUsedFeaturesCompleteSubCode, c.remoteInfo, byte(h), byte(h >> 8)}, f...)...)
+	case opLEConnectionUpdate:
+		var m cmd.LEConnectionUpdate
+		if !c.decode(op, p, &m) {
+			return
+		}
+		l, ok := c.links[m.ConnectionHandle]
+		if !ok {
+			c.status(op, errConnID)
+			return
+		}
+		c.status(op, 0x00)
+		l.interval, l.latency, l.timeout = m.ConnIntervalMax, m.ConnLatency, m.SupervisionTimeout
+		e := make([]byte, 10)
+		e[0] = evt.LEConnectionUpdateCompleteSubCode
+		binary.LittleEndian.PutUint16(e[2:], l.handle)
+		binary.LittleEndian.PutUint16(e[4:], l.interval)
+		binary.LittleEndian.PutUint16(e[6:], l.latency)
+		binary.LittleEndian.PutUint16(e[8:], l.timeout)
+		l.central.event(evtLEMeta, e...)
+		l.peripheral.event(evtLEMeta, e...)
+	case opReadRSSI:
+		var m cmd.ReadRSSI
+		if !c.decode(op, p, &m) {
+			return
+		}
+		h := m.Handle
+		if _, ok := c.links[h]; !ok {
+			c.complete(op, errConnID, byte(h), byte(h>>8), 0x00)
+			return
+		}
+		c.complete(op, 0x00, byte(h), byte(h>>8), rssi)
+
+	case opLEStartEncryption:
+		var m cmd.LEStartEncryption
+		if !c.decode(op, p, &m) {
+			return
+		}
+		l, ok := c.links[m.ConnectionHandle]
+		switch {
+		case !ok:
+			c.status(op, errConnID)
+			return
+		case l.central != c || l.ltk != nil:
+			c.status(op, errDisallowed)
+			return
+		}
+		c.status(op, 0x00)
+		ltk := m.LongTermKey
+		l.ltk = &ltk
+		e := make([]byte, 13)
+		e[0] = evt.LELongTermKeyRequestSubCode
+		binary.LittleEndian.PutUint16(e[1:], l.handle)
+		binary.LittleEndian.PutUint64(e[3:], m.RandomNumber)
+		binary.LittleEndian.PutUint16(e[11:], m.EncryptedDiversifier)
+		l.peripheral.event(evtLEMeta, e...)
+	case opLELongTermKeyRequestReply:
+		var m cmd.LELongTermKeyRequestReply
+		if !c.decode(op, p, &m) {
+			return
+		}
+		l := c.ltkRequest(op, m.ConnectionHandle)
+		if l == nil {
+			return
+		}
+		h := l.handle
+		c.complete(op, 0x00, byte(h), byte(h>>8))
+		switch {
+		case m.LongTermKey != *l.ltk:
+			l.ltk = nil
+			c.drop(l, errMIC, false)
+			c.event(evtDisconnectionComplete, 0x00, byte(h), byte(h>>8), errMIC)
+		case l.encrypted:
+			l.ltk = nil
+			l.central.event(evtEncryptionKeyRefreshComplete, 0x00, byte(h), byte(h>>8))
+			l.peripheral.event(evtEncryptionKeyRefreshComplete, 0x00, byte(h), byte(h>>8))
+		default:
+			l.ltk = nil
+			l.encrypted = true
+			l.central.event(evtEncryptionChange, 0x00, byte(h), byte(h>>8), 0x01)
+			l.peripheral.event(evtEncryptionChange, 0x00, byte(h), byte(h>>8), 0x01)
+		}
+	case opLELongTermKeyRequestNegativeReply:
+		var m cmd.LELongTermKeyRequestNegativeReply
+		if !c.decode(op, p, &m) {
+			return
+		}
+		l := c.ltkRequest(op, m.ConnectionHandle)
+		if l == nil {
+			return
+		}
+		h := l.handle
+		l.ltk = nil
+		c.complete(op, 0x00, byte(h), byte(h>>8))
+		enabled := byte(0x00)
+		if l.encrypted {
+			enabled = 0x01
+		}
+		l.central.event(evtEncryptionChange, errPINMissing, byte(h), byte(h>>8), enabled)
+
+	default:
+		c.complete(op, errUnknownCommand)
+	}
+}
+
+// ltkRequest returns the link of a pending LTK request, answered by the
+// peripheral, or completes the command with an error.
+func (c *Controller) ltkRequest(op int, h uint16) *link {
+	l, ok := c.links[h]
+	switch {
+	case !ok:
+		c.complete(op, errConnID, byte(h), byte(h>>8))
+		return nil
+	case l.peripheral != c || l.ltk == nil:
+		c.complete(op, errDisallowed, byte(h), byte(h>>8))
+		return nil
+	}
+	return l
+}
+
+// connectInitiators connects the initiators to the advertisers they wait
+// for. If adv is set, only its initiators are considered.
+func (a *Air) connectInitiators(adv *Controller) {
+	for _, i := range a.ctrls {
+		if i.initiating == nil {
+			continue
+		}
+		for _, p := range a.ctrls {
+			if p == i || !p.advertising || (adv != nil && p != adv) {
+				continue
+			}
+			t, addr := p.ownAddr(p.advParams.OwnAddressType)
+			if i.initiating.InitiatorFilterPolicy != 0x00 {
+				if !i.acceptList[acceptEntry{t, addr}] {
+					continue
+				}
+			} else if t != i.initiating.PeerAddressType&0x01 || addr != i.initiating.PeerAddress {
+				continue
+			}
+			if !p.connectable(i) {
+				continue
+			}
+			a.connect(i, p)
+			break
+		}
+	}
+}
+
+// atLimit reports whether the controller has as many links as it supports.
+func (c *Controller) atLimit() bool {
+	return c.maxLinks > 0 && len(c.links) >= c.maxLinks
+}
+
+// acceptEntry is an entry of the filter accept list: an address type and an
+// address.
+type acceptEntry struct {
+	typ  uint8
+	addr [6]byte
+}
+
+// acceptListInUse reports whether advertising or initiating uses the filter
+// accept list, which can't be changed then.
+func (c *Controller) acceptListInUse() bool {
+	return (c.advertising && c.advParams.AdvertisingFilterPolicy != 0x00) ||
+		(c.initiating != nil && c.initiating.InitiatorFilterPolicy != 0x00)
+}
+
+// accepts reports whether the advertising filter policy lets requests of the
+// given kind, 0x01 for scan requests and 0x02 for connection requests, from
+// o using its own address type t through.
+func (c *Controller) accepts(kind uint8, o *Controller, t uint8) bool {
+	if c.advParams.AdvertisingFilterPolicy&kind == 0 {
+		return true
+	}
+	t, addr := o.ownAddr(t)
+	return c.acceptList[acceptEntry{t, addr}]
+}
+
+// connectable reports whether the advertiser accepts connections from i, or
+// from any initiator if i is nil.
+func (c *Controller) connectable(i *Controller) bool {
+	switch c.advParams.AdvertisingType {
+	case 0x00: // ADV_IND
+		if i == nil {
+			return true
+		}
+		return c.accepts(0x02, i, i.initiating.OwnAddressType)
+	case 0x01, 0x04: // ADV_DIRECT_IND
+		if i == nil {
+			return true
+		}
+		_, addr := i.ownAddr(i.initiating.OwnAddressType)
+		return addr == c.advParams.DirectAddress
+	default:
+		return false
+	}
+}
+
+// connect establishes a link between the initiator and the advertiser, which
+// stops advertising.
+func (a *Air) connect(central, peripheral *Controller) {
+	m := central.initiating
+	l := &link{
+		handle:     a.nextHandle(),
+		central:    central,
+		peripheral: peripheral,
+		interval:   m.ConnIntervalMin,
+		latency:    m.ConnLatency,
+		timeout:    m.SupervisionTimeout,
+	}
+	central.links[l.handle] = l
+	peripheral.links[l.handle] = l
+	central.initiating = nil
+	peripheral.advertising = false
+
+	pt, pa := peripheral.ownAddr(peripheral.advParams.OwnAddressType)
+	ct, ca := central.ownAddr(m.OwnAddressType)
+	central.connectionComplete(0x00, l, 0x00, pt, pa)
+	peripheral.connectionComplete(0x00, l, 0x01, ct, ca)
+}
+
+// connectionComplete reports a new link, or a failed one if l is nil.
+func (c *Controller) connectionComplete(status byte, l *link, role, peerType byte, peer [6]byte) {
+	e := make([]byte, 19)
+	e[0] = evt.LEConnectionCompleteSubCode
+	e[1] = status
+	e[4] = role
+	if l != nil {
+		binary.LittleEndian.PutUint16(e[2:], l.handle)
+		e[5] = peerType
+		copy(e[6:], peer[:])
+		binary.LittleEndian.PutUint16(e[12:], l.interval)
+		binary.LittleEndian.PutUint16(e[14:], l.latency)
+		binary.LittleEndian.PutUint16(e[16:], l.timeout)
+	}
+	c.event(evtLEMeta, e...)
+}
+
+// scanLoop reports the advertisers, until the scan of generation gen stops.
+func (c *Controller) scanLoop(gen int) {
+	t := time.NewTicker(reportInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+		}
+
+		a := c.air
+		a.mu.Lock()
+		if !c.scanning || c.scanGen != gen {
+			a.mu.Unlock()
+			return
+		}
+		for _, o := range a.ctrls {
+			if o != c && o.advertising {
+				c.report(o)
+			}
+		}
+		a.mu.Unlock()
+	}
+}
+
+// report reports an advertiser, and its scan response when scanning actively.
+func (c *Controller) report(adv *Controller) {
+	typ := adv.advParams.AdvertisingType
+	switch typ {
+	case 0x01, 0x04:
+		// Directed advertising is only reported to its target.
+		if _, addr := c.ownAddr(c.scanParams.OwnAddressType); addr != adv.advParams.DirectAddress {
+			return
+		}
+		typ = 0x01
+	case 0x00, 0x02, 0x03:
+	default:
+		return
+	}
+
+	t, addr := adv.ownAddr(adv.advParams.OwnAddressType)
+	c.advertisingReport(typ, t, addr, adv.advData)
+	if c.scanParams.LEScanType == 0x01 && (typ == 0x00 || typ == 0x02) &&
+		adv.accepts(0x01, c, c.scanParams.OwnAddressType) {
+		c.advertisingReport(0x04, t, addr, adv.scanRspData)
+	}
+}
+
+func (c *Controller) advertisingReport(typ, addrType byte, addr [6]byte, data []byte) {
+	if c.filterDup {
+		k := string(append([]byte{typ, addrType}, addr[:]...))
+		if c.seen[k] {
+			return
+		}
+		c.seen[k] = true
+	}
+	e := []byte{evt.LEAdvertisingReportSubCode, 1, typ, addrType}
+	e = append(e, addr[:]...)
+	e = append(e, byte(len(data)))
+	e = append(e, data...)
+	e = append(e, rssi)
+	c.event(evtLEMeta, e...)
+}
+
+// decode decodes the command parameters, or completes the command with an error.
+func (c *Controller) decode(op int, p []byte, m interface{}) bool {
+	if err := binary.Read(bytes.NewReader(p), binary.LittleEndian, m); err != nil {
+		c.complete(op, errInvalidParams)
+		return false
+	}
+	return true
+}
+
+// rp encodes the return parameters of a command.
+func rp(m interface{}) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, m)
+	return b.Bytes()
+}
+
+func (c *Controller) complete(op int, rp ...byte) {
+	c.event(evtCommandComplete, append([]byte{1, byte(op), byte(op >> 8)}, rp...)...)
+}
+
+func (c *Controller) status(op int, status byte) {
+	c.event(evtCommandStatus, status, 1, byte(op), byte(op>>8))
+}
+
+func (c *Controller) event(code byte, params ...byte) {
+	c.push(append([]byte{pktTypeEvent, code, byte(len(params))}, params...))
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,124 @@
+package virtual_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux/hci"
+)
+
+func TestL2CAPChannel(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p := pair.Peripheral
+
+	l, err := ble.ListenL2CAP(p, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.PSM() != 0x0080 {
+		t.Fatalf("dynamic PSM 0x%04X", l.PSM())
+	}
+	enc, err := p.ListenL2CAP(0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	if _, err := p.ListenL2CAP(l.PSM(), false); err == nil {
+		t.Fatal("published a PSM twice")
+	}
+
+	// The peripheral echoes the SDUs, and reports the end of the channel.
+	eof := make(chan error, 1)
+	go func() {
+		ch, err := l.Accept()
+		if err != nil {
+			eof <- err
+			return
+		}
+		b := make([]byte, 4096)
+		for {
+			n, err := ch.Read(b)
+			if err != nil {
+				eof <- err
+				return
+			}
+			if _, err := ch.Write(b[:n]); err != nil {
+				eof <- err
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	defer cln.CancelConnection()
+
+	if _, err := ble.OpenL2CAPChannel(cln, 0x00F0); err != hci.L2CAPPSMNotSupported {
+		t.Fatalf("unpublished PSM: %v", err)
+	}
+	if _, err := ble.OpenL2CAPChannel(cln, enc.PSM()); err != hci.L2CAPInsufficientEncryption {
+		t.Fatalf("encrypted PSM: %v", err)
+	}
+
+	ch, err := ble.OpenL2CAPChannel(cln, l.PSM())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.PSM() != l.PSM() || ch.MTU() != 2048 {
+		t.Fatalf("PSM 0x%04X, MTU %d", ch.PSM(), ch.MTU())
+	}
+	if _, err := ch.Write(make([]byte, ch.MTU()+1)); err == nil {
+		t.Fatal("wrote an SDU larger than the MTU")
+	}
+
+	// SDUs of one and several K-frames, more than the credits granted at
+	// once, come back whole and in order.
+	b := make([]byte, ch.MTU())
+	for i, n := range []int{0, 1, 245, 246, 1000, 2048, 2048, 2048, 2048, 2048, 2048, 2048, 2048, 7} {
+		sdu := bytes.Repeat([]byte{byte(i)}, n)
+		if _, err := ch.Write(sdu); err != nil {
+			t.Fatal(err)
+		}
+		m, err := ch.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b[:m], sdu) {
+			t.Fatalf("SDU %d: %d bytes, want %d", i, m, n)
+		}
+	}
+
+	// A short buffer doesn't lose the SDU.
+	if _, err := ch.Write([]byte("gopher")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ch.Read(make([]byte, 3)); err != io.ErrShortBuffer {
+		t.Fatalf("short buffer: %v", err)
+	}
+	if m, err := ch.Read(b); err != nil || string(b[:m]) != "gopher" {
+		t.Fatalf("read %q, %v", b[:m], err)
+	}
+
+	if err := ch.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-eof:
+		if err != io.EOF {
+			t.Fatalf("peripheral: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed on the peripheral")
+	}
+	if _, err := ch.Write([]byte{1}); err == nil {
+		t.Fatal("wrote to a closed channel")
+	}
+}
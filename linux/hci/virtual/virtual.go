@@ -0,0 +1,340 @@
+// Package virtual emulates LE controllers in memory, so applications using
+// linux.Device can be tested without hardware or root.
+//
+// Controllers attached to the same Air see each other's advertising, and
+// connections between them carry ACL data. Commands which aren't emulated
+// fail with Unknown HCI Command.
+package virtual
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/leso-kn/ble/linux/hci/cmd"
+	"github.com/leso-kn/ble/sliceops"
+)
+
+const (
+	pktTypeCommand = 0x01
+	pktTypeACLData = 0x02
+	pktTypeEvent   = 0x04
+)
+
+// Air is the medium shared by virtual controllers.
+type Air struct {
+	mu     sync.Mutex
+	ctrls  []*Controller
+	handle uint16
+}
+
+// NewAir returns an empty medium.
+func NewAir() *Air {
+	return &Air{handle: 0x0040}
+}
+
+// NewController attaches a controller with the public address addr, e.g.
+// "11:22:33:44:55:66", to the air. The controller is the transport of an HCI
+// host; use it with ble.OptTransportVirtual.
+func (a *Air) NewController(addr string) (*Controller, error) {
+	mac, err := net.ParseMAC(addr)
+	if err != nil || len(mac) != 6 {
+		return nil, fmt.Errorf("invalid address %q", addr)
+	}
+	c := &Controller{
+		air:   a,
+		done:  make(chan struct{}),
+		links: map[uint16]*link{},
+	}
+	copy(c.addr[:], sliceops.SwapBuf(mac))
+	c.q.ready = make(chan struct{}, 1)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, o := range a.ctrls {
+		if o.addr == c.addr {
+			return nil, fmt.Errorf("address %s in use", addr)
+		}
+	}
+	a.ctrls = append(a.ctrls, c)
+	return c, nil
+}
+
+// nextHandle returns an unused connection handle.
+func (a *Air) nextHandle() uint16 {
+	h := a.handle
+	a.handle = (a.handle + 1) & 0x0EFF
+	return h
+}
+
+// link is a connection between two controllers, known by the same handle to
+// both of them.
+type link struct {
+	handle     uint16
+	central    *Controller
+	peripheral *Controller
+
+	interval uint16
+	latency  uint16
+	timeout  uint16
+
+	ltk       *[16]byte // The key the central started encryption with.
+	encrypted bool
+}
+
+func (l *link) peer(c *Controller) *Controller {
+	if c == l.central {
+		return l.peripheral
+	}
+	return l.central
+}
+
+// queue holds the packets to the host. It's unbounded, so controllers never
+// block each other.
+type queue struct {
+	mu    sync.Mutex
+	pkts  [][]byte
+	ready chan struct{}
+}
+
+func (q *queue) push(p []byte) {
+	q.mu.Lock()
+	q.pkts = append(q.pkts, p)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next packet, or nil once done is closed.
+func (q *queue) pop(done chan struct{}) []byte {
+	for {
+		q.mu.Lock()
+		if len(q.pkts) > 0 {
+			p := q.pkts[0]
+			q.pkts = q.pkts[1:]
+			q.mu.Unlock()
+			return p
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.ready:
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// Controller is a virtual controller. It reads commands and ACL data from the
+// host with Write, and returns events and ACL data with Read.
+type Controller struct {
+	air  *Air
+	addr [6]byte // Least significant octet first, as in HCI.
+	q    queue
+	done chan struct{}
+	once sync.Once
+
+	// Guarded by air.mu.
+	randomAddr  [6]byte
+	advParams   cmd.LESetAdvertisingParameters
+	advData     []byte
+	scanRspData []byte
+	advertising bool
+	scanParams  cmd.LESetScanParameters
+	scanning    bool
+	filterDup   bool
+	scanGen     int
+	seen        map[string]bool
+	initiating  *cmd.LECreateConnection
+	links       map[uint16]*link
+	maxLinks    int
+	noAdvInit   bool
+	acceptList  map[acceptEntry]bool
+	dualMode    bool
+	bredrScan   uint8
+	remoteInfo  uint8 // The status of reading the remote features and version.
+}
+
+// SetConnectionLimit limits the controller to n connections, in either role;
+// 0 means no limit. At the limit, it refuses to initiate connections and to
+// enable connectable advertising with Connection Limit Exceeded.
+func (c *Controller) SetConnectionLimit(n int) {
+	c.air.mu.Lock()
+	c.maxLinks = n
+	c.air.mu.Unlock()
+}
+
+// SetRemoteInfoStatus sets the status the controller reports reading the
+// features and the version of remote devices with, e.g. Connection Timeout;
+// 0x00, the default, reports them.
+func (c *Controller) SetRemoteInfoStatus(status uint8) {
+	c.air.mu.Lock()
+	c.remoteInfo = status
+	c.air.mu.Unlock()
+}
+
+// SetInitiateWhileAdvertising sets whether the controller can initiate a
+// connection while it's advertising, as most can. If not, it refuses with
+// Command Disallowed.
+func (c *Controller) SetInitiateWhileAdvertising(ok bool) {
+	c.air.mu.Lock()
+	c.noAdvInit = !ok
+	c.air.mu.Unlock()
+}
+
+// SetDualMode sets whether the controller reports it supports BR/EDR too.
+// A dual-mode controller starts with its BR/EDR inquiry and page scans
+// enabled, as another stack may leave them; they're only modeled by the
+// value Write Scan Enable sets.
+func (c *Controller) SetDualMode(on bool) {
+	c.air.mu.Lock()
+	c.dualMode = on
+	c.bredrScan = 0
+	if on {
+		c.bredrScan = 0x03
+	}
+	c.air.mu.Unlock()
+}
+
+// BREDRScanEnable returns the Scan_Enable value of the BR/EDR inquiry and
+// page scans of a dual-mode controller [Vol 2, Part E, 7.3.18].
+func (c *Controller) BREDRScanEnable() uint8 {
+	c.air.mu.Lock()
+	defer c.air.mu.Unlock()
+	return c.bredrScan
+}
+
+// Read returns the next packet to the host. It blocks until there's one, or
+// returns io.EOF once the controller is closed.
+func (c *Controller) Read(b []byte) (int, error) {
+	p := c.q.pop(c.done)
+	if p == nil {
+		return 0, io.EOF
+	}
+	if len(b) < len(p) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(b, p), nil
+}
+
+// Write handles a command or ACL data packet from the host.
+func (c *Controller) Write(b []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	a := c.air
+	switch {
+	case len(b) >= 4 && b[0] == pktTypeCommand && len(b) == 4+int(b[3]):
+		a.mu.Lock()
+		c.command(int(binary.LittleEndian.Uint16(b[1:])), b[4:])
+		a.mu.Unlock()
+	case len(b) >= 5 && b[0] == pktTypeACLData && len(b) == 5+int(binary.LittleEndian.Uint16(b[3:])):
+		a.mu.Lock()
+		c.acl(b)
+		a.mu.Unlock()
+	default:
+		return 0, fmt.Errorf("invalid packet % X", b)
+	}
+	return len(b), nil
+}
+
+// Close detaches the controller from the air. Its peers see their
+// connections time out.
+func (c *Controller) Close() error {
+	c.once.Do(func() {
+		a := c.air
+		a.mu.Lock()
+		c.reset()
+		for i, o := range a.ctrls {
+			if o == c {
+				a.ctrls = append(a.ctrls[:i], a.ctrls[i+1:]...)
+				break
+			}
+		}
+		a.mu.Unlock()
+		close(c.done)
+	})
+	return nil
+}
+
+// Reopen returns a new controller with the address of c, attached to the
+// same air, once c is closed; as an adapter which came back after its
+// transport failed. The hci package reopens a virtual controller with it
+// when the watchdog recovers the device.
+func (c *Controller) Reopen() (io.ReadWriteCloser, error) {
+	select {
+	case <-c.done:
+	default:
+		return nil, fmt.Errorf("controller not closed")
+	}
+	n, err := c.air.NewController(net.HardwareAddr(sliceops.SwapBuf(c.addr[:])).String())
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// push queues a packet to the host.
+func (c *Controller) push(p []byte) {
+	c.q.push(p)
+}
+
+// acl forwards ACL data to the peer, and completes the packet.
+func (c *Controller) acl(b []byte) {
+	h := binary.LittleEndian.Uint16(b[1:]) & 0x0FFF
+	l, ok := c.links[h]
+	if !ok {
+		return
+	}
+
+	p := append([]byte{}, b...)
+	if pbf := p[2] >> 4 & 0x3; pbf == 0x00 {
+		// The start of a PDU, as the peer's controller reports it.
+		p[2] |= 0x02 << 4
+	}
+	l.peer(c).push(p)
+
+	c.event(evtNumberOfCompletedPackets, 1, byte(h), byte(h>>8), 1, 0)
+}
+
+// reset stops advertising, scanning and initiating, and drops the links.
+func (c *Controller) reset() {
+	for _, l := range c.links {
+		c.drop(l, errConnTimeout, false)
+	}
+	c.randomAddr = [6]byte{}
+	c.advParams = cmd.LESetAdvertisingParameters{}
+	c.advData, c.scanRspData = nil, nil
+	c.advertising = false
+	c.acceptList = nil
+	c.scanParams = cmd.LESetScanParameters{}
+	c.scanning = false
+	c.scanGen++
+	c.initiating = nil
+}
+
+// drop disconnects the link, reporting reason to the peer. The local host is
+// told the connection was terminated by itself, if notify is set.
+func (c *Controller) drop(l *link, reason byte, notify bool) {
+	p := l.peer(c)
+	delete(c.links, l.handle)
+	delete(p.links, l.handle)
+	if notify {
+		c.event(evtDisconnectionComplete, 0x00, byte(l.handle), byte(l.handle>>8), errLocalHost)
+	}
+	p.event(evtDisconnectionComplete, 0x00, byte(l.handle), byte(l.handle>>8), reason)
+}
+
+// ownAddr returns the address of the given own address type.
+func (c *Controller) ownAddr(t uint8) (uint8, [6]byte) {
+	if t&0x01 != 0 {
+		return 0x01, c.randomAddr
+	}
+	return 0x00, c.addr
+}
@@ -0,0 +1,70 @@
+package virtual_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+)
+
+func TestGATTOverVirtualControllers(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	svcUUID := ble.MustParse("00010000-0001-1000-8000-00805F9B34FB")
+	chrUUID := ble.MustParse("00010000-0002-1000-8000-00805F9B34FB")
+	written := make(chan []byte, 1)
+	svc := ble.NewService(svcUUID)
+	chr := svc.NewCharacteristic(chrUUID)
+	chr.HandleRead(ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		rsp.Write([]byte("hello"))
+	}))
+	chr.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		written <- append([]byte{}, req.Data()...)
+	}))
+	if err := p.AddService(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher", svcUUID)
+
+	cln, err := c.Connect(ctx, func(a ble.Advertisement) bool { return a.LocalName() == "Gopher" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cln.Addr().String() != "11:22:33:44:55:66" {
+		t.Errorf("connected to %v", cln.Addr())
+	}
+	prof, err := cln.DiscoverProfile(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := prof.FindCharacteristic(ble.NewCharacteristic(chrUUID))
+	if v == nil {
+		t.Fatal("characteristic not discovered")
+	}
+	b, err := cln.ReadCharacteristic(v)
+	if err != nil || string(b) != "hello" {
+		t.Fatalf("read %q, %v", b, err)
+	}
+	if err := cln.WriteCharacteristic(v, []byte("world"), false); err != nil {
+		t.Fatal(err)
+	}
+	if b := <-written; string(b) != "world" {
+		t.Errorf("wrote %q", b)
+	}
+
+	if err := cln.CancelConnection(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-cln.Disconnected():
+	case <-ctx.Done():
+		t.Fatal("not disconnected")
+	}
+}
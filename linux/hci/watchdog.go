@@ -0,0 +1,195 @@
+package hci
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/hci/evt"
+)
+
+// recoveryRetryInterval is the delay between failed recovery attempts.
+const recoveryRetryInterval = 2 * time.Second
+
+// watchdog supervises the controller and recovers it when it stops responding,
+// the transport fails, or a hardware error is reported.
+type watchdog struct {
+	handler ble.RecoveryHandler
+
+	chFault chan error
+
+	mu         sync.Mutex
+	recovering bool
+
+	// readDone is closed when the read loop of the current transport exits.
+	readDone chan struct{}
+}
+
+func newWatchdog(handler ble.RecoveryHandler) *watchdog {
+	return &watchdog{
+		handler: handler,
+		chFault: make(chan error, 1),
+	}
+}
+
+// fault reports a controller failure. Failures reported while a recovery is in
+// progress, or while another one is already queued, are dropped.
+func (w *watchdog) fault(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.recovering {
+		return
+	}
+	select {
+	case w.chFault <- err:
+	default:
+	}
+}
+
+func (w *watchdog) setRecovering(b bool) {
+	w.mu.Lock()
+	w.recovering = b
+	w.mu.Unlock()
+}
+
+// fault reports a controller failure to the watchdog, if enabled.
+func (h *HCI) fault(err error) {
+	if h.watchdog == nil || !h.isOpen() {
+		return
+	}
+	h.watchdog.fault(err)
+}
+
+func (h *HCI) handleHardwareError(b []byte) error {
+	e := evt.HardwareError(b)
+	if len(e) < 1 {
+		return fmt.Errorf("invalid hardware error: % X", b)
+	}
+	err := fmt.Errorf("controller hardware error 0x%02X", e.HardwareCode())
+	h.Errorf("%v", err)
+	h.fault(err)
+	return nil
+}
+
+// superviseLoop recovers the controller whenever a failure is reported, until
+// the device is closed.
+func (h *HCI) superviseLoop() {
+	w := h.watchdog
+	for {
+		var cause error
+		select {
+		case <-h.done:
+			return
+		case cause = <-w.chFault:
+		}
+
+		h.Warnf("watchdog: controller failure: %v, recovering", cause)
+		w.setRecovering(true)
+		for {
+			err := h.recoverController()
+			if w.handler != nil {
+				w.handler(cause, err)
+			}
+			if err == nil {
+				h.Infof("watchdog: recovered")
+				break
+			}
+			h.Errorf("watchdog: recovery failed: %v", err)
+			select {
+			case <-h.done:
+				return
+			case <-time.After(recoveryRetryInterval):
+			}
+		}
+
+		// Drain failures caused by the recovery itself.
+		select {
+		case <-w.chFault:
+		default:
+		}
+		w.setRecovering(false)
+	}
+}
+
+// recoverController reopens the transport, resets and re-initializes the
+// controller, and restores advertising and scanning. Connections don't survive
+// the reset and are cleaned up.
+func (h *HCI) recoverController() error {
+	w := h.watchdog
+
+	// Stop the read loop of the failed transport, if it's still running.
+	h.socket().Close()
+	select {
+	case <-w.readDone:
+	case <-h.done:
+		return io.EOF
+	}
+
+	h.muConns.Lock()
+	hh := make([]uint16, 0, len(h.conns))
+	for ch := range h.conns {
+		hh = append(hh, ch)
+	}
+	h.muConns.Unlock()
+	for _, ch := range hh {
+		h.cleanupConnectionHandle(ch)
+	}
+
+	skt, err := getTransport(h.transport)
+	if err != nil {
+		return fmt.Errorf("reopen transport: %v", err)
+	}
+	h.muSkt.Lock()
+	h.skt = skt
+	h.err = nil
+	h.muSkt.Unlock()
+	h.setAllowedCommands(1)
+	w.readDone = make(chan struct{})
+	go h.sktReadLoop(skt, w.readDone)
+
+	if err := h.init(); err != nil {
+		return fmt.Errorf("init: %v", err)
+	}
+	if err := h.initRandomAddr(); err != nil {
+		return err
+	}
+	if err := h.initPrivacy(); err != nil {
+		return err
+	}
+	return h.restoreState()
+}
+
+// restoreState reconfigures advertising and scanning, and re-enables them if
+// they were enabled before the controller failed.
+func (h *HCI) restoreState() error {
+	p := &h.params
+	if err := h.Send(&p.advParams, nil); err != nil {
+		return fmt.Errorf("restore adv params: %v", err)
+	}
+	if err := h.Send(&p.scanParams, nil); err != nil {
+		return fmt.Errorf("restore scan params: %v", err)
+	}
+
+	if p.advEnable.AdvertisingEnable == 1 {
+		if err := h.Send(&p.advData, nil); err != nil {
+			return fmt.Errorf("restore adv data: %v", err)
+		}
+		if err := h.Send(&p.scanResp, nil); err != nil {
+			return fmt.Errorf("restore scan response: %v", err)
+		}
+		if err := h.Send(&p.advEnable, nil); err != nil {
+			return fmt.Errorf("restore advertising: %v", err)
+		}
+	}
+
+	if p.scanEnable.LEScanEnable == 1 {
+		h.adHist = make([]*Advertisement, 128)
+		h.adLast = 0
+		if err := h.Send(&p.scanEnable, nil); err != nil {
+			return fmt.Errorf("restore scanning: %v", err)
+		}
+	}
+	return nil
+}
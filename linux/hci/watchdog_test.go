@@ -0,0 +1,38 @@
+package hci_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+)
+
+func TestWatchdogRecovery(t *testing.T) {
+	recovered := make(chan error, 4)
+	pair := virtualtest.NewPair(t, []ble.Option{ble.OptRecovery(func(cause, err error) {
+		recovered <- err
+	})}, nil)
+	defer pair.Stop()
+
+	// Closing the controller kills the transport, as an unplugged adapter.
+	pair.PeripheralController.Close()
+	select {
+	case err := <-recovered:
+		if err != nil {
+			t.Fatalf("recovery: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("controller not recovered")
+	}
+
+	// The reopened controller advertises, and accepts the central.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cln := pair.Connect(ctx, t)
+	defer cln.CancelConnection()
+	if _, err := cln.DiscoverServices([]ble.UUID{ble.GAPUUID}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,143 @@
+package linux
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/linux/att"
+	"github.com/leso-kn/ble/linux/hci"
+)
+
+var (
+	// ErrListening means the device already has a listener.
+	ErrListening = errors.New("device already listening")
+
+	// ErrListenerClosed is returned by the Accept of a closed listener.
+	ErrListenerClosed = errors.New("listener closed")
+
+	// ErrDecided means an incoming connection was already accepted or
+	// rejected.
+	ErrDecided = errors.New("incoming connection already accepted or rejected")
+)
+
+// Incoming is a connection a central established to the device, which the
+// application accepts, to serve it a GATT database, or rejects. The requests
+// of the central wait until then.
+type Incoming struct {
+	// Conn is the connection, e.g. to check the address of the central,
+	// or the role and parameters of the connection with ConnInfo.
+	Conn ble.Conn
+
+	d    *Device
+	once sync.Once
+}
+
+func newIncoming(d *Device, c ble.Conn) *Incoming {
+	return &Incoming{Conn: c, d: d}
+}
+
+// Accept serves the GATT database of the device to the central.
+func (in *Incoming) Accept() error {
+	return in.AcceptDB(nil)
+}
+
+// AcceptDB serves the database db to the central, e.g. one returned by
+// gatt.Server.DBWithServices, or that of the device if nil.
+func (in *Incoming) AcceptDB(db *att.DB) error {
+	err := ErrDecided
+	in.once.Do(func() {
+		select {
+		case <-in.Conn.Disconnected():
+			err = io.ErrClosedPipe
+		default:
+			err = in.d.serve(in.Conn, db)
+		}
+	})
+	return err
+}
+
+// Reject disconnects the central with the reason, one of those
+// hci.Conn.Disconnect allows, e.g. hci.ErrAuth or hci.ErrRemoteLowResources.
+func (in *Incoming) Reject(reason hci.ErrCommand) error {
+	err := ErrDecided
+	in.once.Do(func() {
+		if c, ok := in.Conn.(interface{ Disconnect(hci.ErrCommand) error }); ok {
+			err = c.Disconnect(reason)
+			return
+		}
+		err = in.Conn.Close()
+	})
+	return err
+}
+
+// Listener hands the connections the centrals establish to the device to
+// the application, instead of serving them the GATT database right away,
+// e.g. to gate the connections, or to serve each central its database.
+type Listener struct {
+	d    *Device
+	c    chan *Incoming
+	done chan struct{}
+	once sync.Once
+}
+
+// Listen returns a listener of the incoming connections, until it's closed.
+// A device has one listener at most.
+func (d *Device) Listen() (*Listener, error) {
+	if d.Server == nil {
+		return nil, hci.ErrCentralOnly
+	}
+	d.muListen.Lock()
+	defer d.muListen.Unlock()
+	if d.listener != nil {
+		return nil, ErrListening
+	}
+	d.listener = &Listener{d: d, c: make(chan *Incoming), done: make(chan struct{})}
+	return d.listener, nil
+}
+
+func (d *Device) currentListener() *Listener {
+	d.muListen.Lock()
+	defer d.muListen.Unlock()
+	return d.listener
+}
+
+// Accept waits for and returns the next incoming connection, which must be
+// accepted or rejected. It returns io.EOF once the device is stopped, and
+// ErrListenerClosed once l is closed.
+func (l *Listener) Accept() (*Incoming, error) {
+	select {
+	case in := <-l.c:
+		return in, nil
+	case <-l.done:
+		return nil, ErrListenerClosed
+	case <-l.d.loopDone:
+		return nil, io.EOF
+	}
+}
+
+// Close stops the listener. The device serves the connections established
+// from then on the GATT database, as it did before Listen.
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		l.d.muListen.Lock()
+		if l.d.listener == l {
+			l.d.listener = nil
+		}
+		l.d.muListen.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+// deliver hands in to the application, and reports whether it took it
+// before l was closed.
+func (l *Listener) deliver(in *Incoming) bool {
+	select {
+	case l.c <- in:
+		return true
+	case <-l.done:
+		return false
+	}
+}
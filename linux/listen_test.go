@@ -0,0 +1,121 @@
+package linux_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/linux"
+	"github.com/leso-kn/ble/linux/hci"
+)
+
+func TestListen(t *testing.T) {
+	pair := virtualtest.NewPair(t, nil, nil)
+	defer pair.Stop()
+	p, c := pair.Peripheral, pair.Central
+
+	// The device serves a public service, and some centrals a private one.
+	pub := ble.NewService(ble.UUID16(0xFF00))
+	pub.NewCharacteristic(ble.UUID16(0xFF01)).SetValue([]byte("public"))
+	if err := p.AddService(pub); err != nil {
+		t.Fatal(err)
+	}
+	priv := ble.NewService(ble.UUID16(0xFE00))
+	priv.NewCharacteristic(ble.UUID16(0xFE01)).SetValue([]byte("private"))
+	privDB := p.Server.DBWithServices([]*ble.Service{priv})
+
+	l, err := p.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Listen(); err != linux.ErrListening {
+		t.Fatalf("second listener: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher")
+
+	accepted := make(chan ble.Conn, 1)
+	go func() {
+		in, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := in.Reject(hci.ErrRemoteLowResources); err != nil {
+			t.Error(err)
+		}
+		if err := in.Accept(); err != linux.ErrDecided {
+			t.Errorf("accept of a rejected connection: %v", err)
+		}
+		in, err = l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := in.AcceptDB(privDB); err != nil {
+			t.Error(err)
+		}
+		accepted <- in.Conn
+	}()
+
+	// The first connection is rejected.
+	cln, err := c.Dial(ctx, ble.NewAddr(virtualtest.PeripheralAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-cln.Disconnected():
+	case <-ctx.Done():
+		t.Fatal("rejected connection not disconnected")
+	}
+
+	// The second one is served the private database.
+	read := func(cln ble.Client, u ble.UUID) (string, error) {
+		prof, err := cln.DiscoverProfile(true)
+		if err != nil {
+			return "", err
+		}
+		ch := prof.FindCharacteristic(ble.NewCharacteristic(u))
+		if ch == nil {
+			return "", fmt.Errorf("%s not discovered", u)
+		}
+		v, err := cln.ReadCharacteristic(ch)
+		return string(v), err
+	}
+	cln, err = c.Dial(ctx, ble.NewAddr(virtualtest.PeripheralAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-accepted:
+	case <-ctx.Done():
+		t.Fatal("connection not accepted")
+	}
+	if v, err := read(cln, ble.UUID16(0xFE01)); err != nil || v != "private" {
+		t.Fatalf("read %q, %v", v, err)
+	}
+	if _, err := read(cln, ble.UUID16(0xFF01)); err == nil {
+		t.Fatal("public service served with the private database")
+	}
+	cln.CancelConnection()
+	<-cln.Disconnected()
+
+	// Once the listener is closed, the device serves its database again.
+	l.Close()
+	if _, err := l.Accept(); err != linux.ErrListenerClosed {
+		t.Fatalf("accept of a closed listener: %v", err)
+	}
+	cln, err = c.Dial(ctx, ble.NewAddr(virtualtest.PeripheralAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cln.CancelConnection()
+	if v, err := read(cln, ble.UUID16(0xFF01)); err != nil || v != "public" {
+		t.Fatalf("read %q, %v", v, err)
+	}
+}
@@ -0,0 +1,113 @@
+package ble
+
+import (
+	"sync"
+	"time"
+)
+
+// Subsystems of the high-frequency log paths, whose debug lines may be
+// sampled, e.g. with OptLogSampling.
+const (
+	// LogScan is the advertising reports received while scanning.
+	LogScan = "scan"
+
+	// LogACL is the ACL data packets sent and received.
+	LogACL = "acl"
+
+	// LogATT is the ATT PDUs sent and received, including notifications.
+	LogATT = "att"
+)
+
+// LogSampling limits the debug and info lines of a high-frequency path, so
+// debug logging can be enabled in production without flooding the logs,
+// while still giving representative traces. In each Period, the First lines
+// are logged, and then every Thereafter-th one; none if Thereafter is 0.
+// Warnings and errors are always logged.
+type LogSampling struct {
+	Period     time.Duration
+	First      int
+	Thereafter int
+}
+
+// LogSampler samples the lines logged through the loggers it wraps, which
+// share its budget, e.g. the loggers of all the connections of a device.
+type LogSampler struct {
+	s LogSampling
+
+	mu      sync.Mutex
+	start   time.Time
+	n       int
+	dropped int
+}
+
+// NewLogSampler returns a sampler of the lines according to s.
+func NewLogSampler(s LogSampling) *LogSampler {
+	return &LogSampler{s: s}
+}
+
+// Logger returns a logger passing the sampled debug and info lines to l.
+// Its child loggers share the sampler.
+func (s *LogSampler) Logger(l Logger) Logger {
+	return &sampledLogger{Logger: l, s: s}
+}
+
+// sample reports whether the next line is logged, and how many were
+// dropped in the previous period, once, when it's over.
+func (s *LogSampler) sample() (bool, int) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dropped := 0
+	if now.Sub(s.start) >= s.s.Period {
+		s.start, s.n, dropped, s.dropped = now, 0, s.dropped, 0
+	}
+	s.n++
+	if s.n <= s.s.First || (s.s.Thereafter > 0 && (s.n-s.s.First)%s.s.Thereafter == 0) {
+		return true, dropped
+	}
+	s.dropped++
+	return false, dropped
+}
+
+type sampledLogger struct {
+	Logger
+	s *LogSampler
+}
+
+// sample reports whether the next line is logged, and logs the number of
+// lines dropped before.
+func (l *sampledLogger) sample() bool {
+	ok, dropped := l.s.sample()
+	if dropped > 0 {
+		l.Logger.Debugf("%d log lines dropped by sampling", dropped)
+	}
+	return ok
+}
+
+func (l *sampledLogger) Info(args ...interface{}) {
+	if l.sample() {
+		l.Logger.Info(args...)
+	}
+}
+
+func (l *sampledLogger) Debug(args ...interface{}) {
+	if l.sample() {
+		l.Logger.Debug(args...)
+	}
+}
+
+func (l *sampledLogger) Infof(format string, args ...interface{}) {
+	if l.sample() {
+		l.Logger.Infof(format, args...)
+	}
+}
+
+func (l *sampledLogger) Debugf(format string, args ...interface{}) {
+	if l.sample() {
+		l.Logger.Debugf(format, args...)
+	}
+}
+
+func (l *sampledLogger) ChildLogger(tags map[string]interface{}) Logger {
+	return &sampledLogger{Logger: l.Logger.ChildLogger(tags), s: l.s}
+}
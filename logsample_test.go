@@ -0,0 +1,70 @@
+package ble
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordLogger records the lines logged through it and its children.
+type recordLogger struct {
+	mu    *sync.Mutex
+	lines *[]string
+}
+
+func newRecordLogger() recordLogger {
+	return recordLogger{mu: &sync.Mutex{}, lines: new([]string)}
+}
+
+func (l recordLogger) log(s string) {
+	l.mu.Lock()
+	*l.lines = append(*l.lines, s)
+	l.mu.Unlock()
+}
+
+func (l recordLogger) Info(a ...interface{})                     { l.log(fmt.Sprint(a...)) }
+func (l recordLogger) Debug(a ...interface{})                    { l.log(fmt.Sprint(a...)) }
+func (l recordLogger) Error(a ...interface{})                    { l.log(fmt.Sprint(a...)) }
+func (l recordLogger) Warn(a ...interface{})                     { l.log(fmt.Sprint(a...)) }
+func (l recordLogger) Infof(f string, a ...interface{})          { l.log(fmt.Sprintf(f, a...)) }
+func (l recordLogger) Debugf(f string, a ...interface{})         { l.log(fmt.Sprintf(f, a...)) }
+func (l recordLogger) Errorf(f string, a ...interface{})         { l.log(fmt.Sprintf(f, a...)) }
+func (l recordLogger) Warnf(f string, a ...interface{})          { l.log(fmt.Sprintf(f, a...)) }
+func (l recordLogger) ChildLogger(map[string]interface{}) Logger { return l }
+
+func (l recordLogger) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := *l.lines
+	*l.lines = nil
+	return s
+}
+
+func TestLogSampler(t *testing.T) {
+	rec := newRecordLogger()
+	s := NewLogSampler(LogSampling{Period: time.Hour, First: 2, Thereafter: 3})
+	l := s.Logger(rec)
+	child := l.ChildLogger(map[string]interface{}{"conn": 1})
+
+	for i := 1; i <= 5; i++ {
+		l.Debugf("line %d", i)
+	}
+	child.Infof("line %d", 6)
+	l.Warn("warning")
+	l.Errorf("error %d", 1)
+
+	want := []string{"line 1", "line 2", "line 5", "warning", "error 1"}
+	got := rec.take()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("logged %q, want %q", got, want)
+	}
+
+	// The lines dropped are counted once the period is over.
+	s.start = time.Now().Add(-time.Hour)
+	l.Debug("line 7")
+	want = []string{"3 log lines dropped by sampling", "line 7"}
+	if got := rec.take(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("logged %q, want %q", got, want)
+	}
+}
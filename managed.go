@@ -0,0 +1,480 @@
+package ble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrNotConnected is returned by the operations of a ManagedClient which
+// fails fast, while its peer isn't connected.
+var ErrNotConnected = errors.New("not connected")
+
+// ErrClientClosed is returned by the operations of a closed ManagedClient.
+var ErrClientClosed = errors.New("client closed")
+
+// ManagedState is the state of the connection of a ManagedClient.
+type ManagedState int
+
+// States of the connection of a ManagedClient.
+const (
+	ManagedStateDisconnected ManagedState = iota // Waiting to dial, e.g. backing off after a failure.
+	ManagedStateConnecting                       // Scanning for the peer, or dialing it.
+	ManagedStateSettingUp                        // Encrypting, discovering and subscribing.
+	ManagedStateReady                            // Set up, operations run.
+	ManagedStateClosed                           // Closed, for good.
+)
+
+func (s ManagedState) String() string {
+	switch s {
+	case ManagedStateDisconnected:
+		return "disconnected"
+	case ManagedStateConnecting:
+		return "connecting"
+	case ManagedStateSettingUp:
+		return "setting up"
+	case ManagedStateReady:
+		return "ready"
+	case ManagedStateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// ManagedClientOptions configures a ManagedClient. Zero values select the
+// defaults.
+type ManagedClientOptions struct {
+	// Filter selects the peer, if no address is given: the first
+	// connectable advertiser matching it. Later connections dial its
+	// address.
+	Filter AdvFilter
+
+	// DialTimeout bounds a single dial, including the scan for Filter.
+	// Defaults to 10 seconds.
+	DialTimeout time.Duration
+
+	// MinBackoff and MaxBackoff bound the delay before redialing. It starts
+	// at MinBackoff and doubles with every failed attempt. Default to 1
+	// second and 1 minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Encrypt has each connection encrypted with the bond stored for the
+	// peer before it's used. Without a bond, the connection is used
+	// unencrypted; if the encryption fails, it's dropped and redialed.
+	Encrypt bool
+
+	// FailFast makes operations return ErrNotConnected while the peer isn't
+	// ready, rather than wait for it to be.
+	FailFast bool
+
+	// Setup, if set, is called with the client of each connection once it's
+	// discovered and subscribed, e.g. to exchange the MTU. An error drops
+	// the connection.
+	Setup func(Client) error
+
+	// OnStateChange is called whenever the state changes, with the error
+	// which caused it, if any. It must not block.
+	OnStateChange func(ManagedState, error)
+}
+
+// ManagedClient maintains a connection to one peripheral until it's closed.
+// It dials the peer, and redials it with backoff when the connection is
+// lost. Each connection is encrypted, discovered, using the profile cache
+// if any, and subscribed to the characteristics subscribed to so far,
+// before operations run on it. Operations issued meanwhile wait for it.
+type ManagedClient struct {
+	dev  Device
+	opts ManagedClientOptions
+
+	mu      sync.Mutex
+	addr    Addr
+	state   ManagedState
+	cln     Client        // Set while ready.
+	ready   chan struct{} // Closed once ready, or closed.
+	subs    map[managedSub]*managedHandler
+	profile *Profile
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type managedSub struct {
+	uuid string
+	ind  bool
+}
+
+type managedHandler struct {
+	u   UUID
+	h   NotificationHandler
+	cln Client // The client it's subscribed with, if any.
+}
+
+// NewManagedClient returns a client maintaining a connection with d to the
+// peer at addr, or, if addr is nil, to the first advertiser matching
+// opts.Filter.
+func NewManagedClient(d Device, addr Addr, opts ManagedClientOptions) (*ManagedClient, error) {
+	if addr == nil && opts.Filter == nil {
+		return nil, fmt.Errorf("no address or filter")
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 10 * time.Second
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = time.Second
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = time.Minute
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &ManagedClient{
+		dev:    d,
+		opts:   opts,
+		addr:   addr,
+		ready:  make(chan struct{}),
+		subs:   make(map[managedSub]*managedHandler),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go m.maintain(ctx)
+	return m, nil
+}
+
+// Addr returns the address of the peer, or nil until a peer selected by a
+// filter is found.
+func (m *ManagedClient) Addr() Addr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.addr
+}
+
+// State returns the state of the connection.
+func (m *ManagedClient) State() ManagedState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// Profile returns the profile discovered on the last connection, or nil
+// until the first one is set up.
+func (m *ManagedClient) Profile() *Profile {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.profile
+}
+
+// Close disconnects the peer, and stops maintaining the connection.
+// Operations waiting for it return ErrClientClosed.
+func (m *ManagedClient) Close() error {
+	m.cancel()
+	<-m.done
+	return nil
+}
+
+// Do calls f with the client of the connection, once it's ready, or until
+// ctx is done. Operations of different goroutines may run concurrently, as
+// with a Client.
+func (m *ManagedClient) Do(ctx context.Context, f func(Client) error) error {
+	for {
+		m.mu.Lock()
+		cln, ready, state := m.cln, m.ready, m.state
+		m.mu.Unlock()
+		switch {
+		case state == ManagedStateClosed:
+			return ErrClientClosed
+		case cln != nil:
+			return f(cln)
+		case m.opts.FailFast:
+			return ErrNotConnected
+		}
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ReadCharacteristic reads the value of the characteristic of the peer with
+// the UUID of c.
+func (m *ManagedClient) ReadCharacteristic(ctx context.Context, c *Characteristic) ([]byte, error) {
+	var b []byte
+	err := m.Do(ctx, func(cln Client) error {
+		c, err := findCharacteristic(cln, c)
+		if err != nil {
+			return err
+		}
+		b, err = cln.ReadCharacteristic(c)
+		return err
+	})
+	return b, err
+}
+
+// WriteCharacteristic writes the value of the characteristic of the peer
+// with the UUID of c.
+func (m *ManagedClient) WriteCharacteristic(ctx context.Context, c *Characteristic, v []byte, noRsp bool) error {
+	return m.Do(ctx, func(cln Client) error {
+		c, err := findCharacteristic(cln, c)
+		if err != nil {
+			return err
+		}
+		return cln.WriteCharacteristic(c, v, noRsp)
+	})
+}
+
+// Subscribe subscribes to the notifications, or indications if ind is set,
+// of the characteristic of the peer with the UUID of c, on this connection
+// and the following ones. If the peer isn't ready, the subscription is made
+// once it is, and Subscribe doesn't wait for it.
+func (m *ManagedClient) Subscribe(c *Characteristic, ind bool, h NotificationHandler) error {
+	m.mu.Lock()
+	if m.state == ManagedStateClosed {
+		m.mu.Unlock()
+		return ErrClientClosed
+	}
+	s := managedSub{c.UUID.String(), ind}
+	mh := &managedHandler{u: c.UUID, h: h}
+	m.subs[s] = mh
+	cln := m.cln
+	m.mu.Unlock()
+	if cln == nil {
+		return nil
+	}
+	return m.subscribe(cln, s, mh)
+}
+
+// Unsubscribe cancels a subscription made with Subscribe.
+func (m *ManagedClient) Unsubscribe(c *Characteristic, ind bool) error {
+	m.mu.Lock()
+	delete(m.subs, managedSub{c.UUID.String(), ind})
+	cln := m.cln
+	m.mu.Unlock()
+	if cln == nil {
+		return nil
+	}
+	c, err := findCharacteristic(cln, c)
+	if err != nil {
+		return err
+	}
+	return cln.Unsubscribe(c, ind)
+}
+
+func findCharacteristic(cln Client, c *Characteristic) (*Characteristic, error) {
+	p := cln.Profile()
+	if p == nil {
+		return nil, fmt.Errorf("no profile")
+	}
+	found := p.FindCharacteristic(c)
+	if found == nil {
+		return nil, fmt.Errorf("characteristic %s not found", c.UUID)
+	}
+	return found, nil
+}
+
+// subscribe makes the subscription s with cln.
+func (m *ManagedClient) subscribe(cln Client, s managedSub, mh *managedHandler) error {
+	c, err := findCharacteristic(cln, &Characteristic{UUID: mh.u})
+	if err != nil {
+		return err
+	}
+	if err := cln.Subscribe(c, s.ind, mh.h); err != nil {
+		return fmt.Errorf("can't subscribe to %s: %w", s.uuid, err)
+	}
+	m.mu.Lock()
+	mh.cln = cln
+	m.mu.Unlock()
+	return nil
+}
+
+// resubscribe makes the subscriptions not made with cln yet.
+func (m *ManagedClient) resubscribe(cln Client) error {
+	m.mu.Lock()
+	subs := make(map[managedSub]*managedHandler)
+	for s, mh := range m.subs {
+		if mh.cln != cln {
+			subs[s] = mh
+		}
+	}
+	m.mu.Unlock()
+	for s, mh := range subs {
+		if err := m.subscribe(cln, s, mh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *ManagedClient) setState(s ManagedState, cln Client, err error) {
+	m.mu.Lock()
+	m.state, m.cln = s, cln
+	switch s {
+	case ManagedStateReady, ManagedStateClosed:
+		if !m.isReady() {
+			close(m.ready)
+		}
+	default:
+		if m.isReady() {
+			m.ready = make(chan struct{})
+		}
+	}
+	m.mu.Unlock()
+	if m.opts.OnStateChange != nil {
+		m.opts.OnStateChange(s, err)
+	}
+}
+
+// isReady reports whether ready was closed. Must be called with m.mu held.
+func (m *ManagedClient) isReady() bool {
+	select {
+	case <-m.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// maintain connects to the peer, and reconnects whenever the connection is
+// lost, until ctx is done.
+func (m *ManagedClient) maintain(ctx context.Context) {
+	defer close(m.done)
+	defer m.setState(ManagedStateClosed, nil, nil)
+
+	backoff := m.opts.MinBackoff
+	for {
+		m.setState(ManagedStateConnecting, nil, nil)
+		cln, err := m.connect(ctx)
+		if err == nil {
+			m.setState(ManagedStateSettingUp, nil, nil)
+			if err = m.setup(ctx, cln); err != nil {
+				cln.CancelConnection()
+			}
+		}
+		if ctx.Err() != nil {
+			if cln != nil {
+				cln.CancelConnection()
+			}
+			return
+		}
+		if err != nil {
+			m.setState(ManagedStateDisconnected, nil, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > m.opts.MaxBackoff {
+				backoff = m.opts.MaxBackoff
+			}
+			continue
+		}
+		backoff = m.opts.MinBackoff
+
+		// Subscriptions made while setting up are made now.
+		m.setState(ManagedStateReady, cln, nil)
+		if err := m.resubscribe(cln); err != nil {
+			cln.CancelConnection()
+		}
+		select {
+		case <-cln.Disconnected():
+		case <-ctx.Done():
+			cln.CancelConnection()
+			return
+		}
+		m.setState(ManagedStateDisconnected, nil, fmt.Errorf("disconnected"))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// connect dials the peer, scanning for it first if it's only known by the
+// filter.
+func (m *ManagedClient) connect(ctx context.Context) (Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.opts.DialTimeout)
+	defer cancel()
+	if a := m.Addr(); a != nil {
+		return m.dev.Dial(ctx, a)
+	}
+
+	ctx2, stop := context.WithCancel(ctx)
+	defer stop()
+	ch := make(chan Advertisement, 1)
+	h := func(a Advertisement) {
+		if !a.Connectable() || !m.opts.Filter(a) {
+			return
+		}
+		select {
+		case ch <- a:
+			stop()
+		default:
+		}
+	}
+	if err := m.dev.Scan(ctx2, false, h); err != nil && err != context.Canceled {
+		return nil, fmt.Errorf("can't scan: %w", err)
+	}
+	var a Advertisement
+	select {
+	case a = <-ch:
+	default:
+		return nil, ctx.Err()
+	}
+	cln, err := m.dev.Dial(ctx, a.Addr())
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.addr = a.Addr()
+	m.mu.Unlock()
+	return cln, nil
+}
+
+// setup encrypts and discovers a new connection, and restores the
+// subscriptions.
+func (m *ManagedClient) setup(ctx context.Context, cln Client) error {
+	if m.opts.Encrypt {
+		ch := make(chan EncryptionChangedInfo, 1)
+		// An error means there's no bond to encrypt with.
+		if err := cln.StartEncryption(ch); err == nil {
+			select {
+			case info := <-ch:
+				if info.Err != nil {
+					return fmt.Errorf("can't encrypt: %w", info.Err)
+				}
+				if !info.Enabled {
+					return fmt.Errorf("can't encrypt: status 0x%02X", info.Status)
+				}
+			case <-cln.Disconnected():
+				return fmt.Errorf("disconnected while encrypting")
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	p, err := cln.DiscoverAndCacheProfile(false)
+	if err != nil {
+		// Without a cache, discover the whole profile every time.
+		if p, err = cln.DiscoverProfile(false); err != nil {
+			return fmt.Errorf("can't discover profile: %w", err)
+		}
+	}
+	m.mu.Lock()
+	m.profile = p
+	m.mu.Unlock()
+	if err := m.resubscribe(cln); err != nil {
+		return err
+	}
+
+	if m.opts.Setup != nil {
+		return m.opts.Setup(cln)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package ble
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// managedDevice dials the clients sent on dials.
+type managedDevice struct {
+	Device
+	dials chan *managedPeer
+}
+
+func (d *managedDevice) Dial(ctx context.Context, a Addr) (Client, error) {
+	select {
+	case c := <-d.dials:
+		return c, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// managedPeer is the client of a connection with a heart rate
+// characteristic.
+type managedPeer struct {
+	Client
+	profile *Profile
+	disc    chan struct{}
+	once    sync.Once
+
+	mu   sync.Mutex
+	subs map[string]NotificationHandler
+}
+
+func newManagedPeer() *managedPeer {
+	s := &Service{UUID: UUID16(0x180D)}
+	s.AddCharacteristic(&Characteristic{UUID: UUID16(0x2A37)})
+	return &managedPeer{
+		profile: &Profile{Services: []*Service{s}},
+		disc:    make(chan struct{}),
+		subs:    make(map[string]NotificationHandler),
+	}
+}
+
+func (c *managedPeer) DiscoverAndCacheProfile(force bool) (*Profile, error) {
+	return nil, errors.New("no cache")
+}
+func (c *managedPeer) DiscoverProfile(force bool) (*Profile, error) { return c.profile, nil }
+func (c *managedPeer) Profile() *Profile                            { return c.profile }
+func (c *managedPeer) Disconnected() <-chan struct{}                { return c.disc }
+
+func (c *managedPeer) CancelConnection() error {
+	c.once.Do(func() { close(c.disc) })
+	return nil
+}
+
+func (c *managedPeer) ReadCharacteristic(ch *Characteristic) ([]byte, error) {
+	return []byte{0x42}, nil
+}
+
+func (c *managedPeer) Subscribe(ch *Characteristic, ind bool, h NotificationHandler) error {
+	c.mu.Lock()
+	c.subs[ch.UUID.String()] = h
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *managedPeer) subscribed(u UUID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subs[u.String()] != nil
+}
+
+func TestManagedClient(t *testing.T) {
+	d := &managedDevice{dials: make(chan *managedPeer)}
+	states := make(chan ManagedState, 100)
+	m, err := NewManagedClient(d, NewAddr("11:22:33:44:55:66"), ManagedClientOptions{
+		MinBackoff:    time.Millisecond,
+		OnStateChange: func(s ManagedState, err error) { states <- s },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	hr := &Characteristic{UUID: UUID16(0x2A37)}
+
+	// Issued before the connection, the read waits for it.
+	read := make(chan []byte)
+	go func() {
+		b, err := m.ReadCharacteristic(context.Background(), hr)
+		if err != nil {
+			t.Error(err)
+		}
+		read <- b
+	}()
+	if err := m.Subscribe(hr, false, func(uint, []byte) {}); err != nil {
+		t.Fatal(err)
+	}
+	c1 := newManagedPeer()
+	d.dials <- c1
+	if b := <-read; len(b) != 1 || b[0] != 0x42 {
+		t.Fatalf("read % X", b)
+	}
+	if !c1.subscribed(hr.UUID) {
+		t.Fatal("not subscribed")
+	}
+
+	// The subscription is restored on the next connection.
+	c1.CancelConnection()
+	c2 := newManagedPeer()
+	d.dials <- c2
+	if _, err := m.ReadCharacteristic(context.Background(), hr); err != nil {
+		t.Fatal(err)
+	}
+	if !c2.subscribed(hr.UUID) {
+		t.Fatal("not resubscribed")
+	}
+
+	want := []ManagedState{
+		ManagedStateConnecting, ManagedStateSettingUp, ManagedStateReady, ManagedStateDisconnected,
+		ManagedStateConnecting, ManagedStateSettingUp, ManagedStateReady,
+	}
+	for _, w := range want {
+		if s := <-states; s != w {
+			t.Fatalf("state %v, want %v", s, w)
+		}
+	}
+}
+
+func TestManagedClientPolicy(t *testing.T) {
+	d := &managedDevice{dials: make(chan *managedPeer)}
+	a := NewAddr("11:22:33:44:55:66")
+	hr := &Characteristic{UUID: UUID16(0x2A37)}
+
+	m, err := NewManagedClient(d, a, ManagedClientOptions{FailFast: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ReadCharacteristic(context.Background(), hr); err != ErrNotConnected {
+		t.Fatalf("fail fast read: %v", err)
+	}
+	m.Close()
+
+	m, err = NewManagedClient(d, a, ManagedClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error)
+	go func() {
+		_, err := m.ReadCharacteristic(context.Background(), hr)
+		errc <- err
+	}()
+	m.Close()
+	if err := <-errc; err != ErrClientClosed {
+		t.Fatalf("read after close: %v", err)
+	}
+	if s := m.State(); s != ManagedStateClosed {
+		t.Fatalf("state %v after close", s)
+	}
+}
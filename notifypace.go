@@ -0,0 +1,144 @@
+package ble
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// NotifyPacer paces the notifications, or indications, of a Notifier to a
+// rate, so a streaming peripheral neither leaves the connection idle nor
+// fills the controller's buffers, and its writes start failing. Its Write
+// waits for the turn of the value, allowing bursts of a few values after an
+// idle period. It's a Notifier itself, safe for concurrent use.
+type NotifyPacer struct {
+	Notifier
+
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+	next     time.Time
+}
+
+// NewNotifyPacer returns a pacer of the notifications of n to rate values
+// per second, in bursts of up to burst values. A rate of zero or less
+// doesn't pace them.
+func NewNotifyPacer(n Notifier, rate float64, burst int) *NotifyPacer {
+	p := &NotifyPacer{Notifier: n}
+	p.SetRate(rate, burst)
+	return p
+}
+
+// SetRate changes the rate and the bursts of p, e.g. as the estimate of the
+// throughput changes.
+func (p *NotifyPacer) SetRate(rate float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.interval = 0
+	if rate > 0 {
+		p.interval = time.Duration(float64(time.Second) / rate)
+	}
+	p.burst = burst
+}
+
+// Wait waits for the turn of the next value, or until ctx is done.
+func (p *NotifyPacer) Wait(ctx context.Context) error {
+	p.mu.Lock()
+	now := time.Now()
+	if p.interval == 0 {
+		p.mu.Unlock()
+		return ctx.Err()
+	}
+	// The schedule doesn't lag behind by more than a burst, which the values
+	// may catch up with.
+	if earliest := now.Add(-time.Duration(p.burst-1) * p.interval); p.next.Before(earliest) {
+		p.next = earliest
+	}
+	at := p.next
+	p.next = p.next.Add(p.interval)
+	p.mu.Unlock()
+
+	d := at.Sub(now)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Write waits for the turn of b, and sends it. It fails if the notifier is
+// closed first, e.g. as the central unsubscribes.
+func (p *NotifyPacer) Write(b []byte) (int, error) {
+	if err := p.Wait(p.Context()); err != nil {
+		return 0, err
+	}
+	return p.Notifier.Write(b)
+}
+
+// NotifyBenchmark is the notification throughput measured by BenchmarkNotify.
+type NotifyBenchmark struct {
+	Count   int           // Values sent.
+	Bytes   int           // Bytes of the values sent.
+	Elapsed time.Duration // Time spent sending them.
+
+	// Rate is the number of values sent per second, and BytesPerSecond that
+	// of their bytes.
+	Rate           float64
+	BytesPerSecond float64
+}
+
+// BenchmarkNotify measures the notification throughput to a central, by
+// sending values of size bytes with n as fast as it accepts them, for d or
+// until ctx is done. The first 4 bytes of the values, if they fit, are their
+// little-endian sequence number, for the central to detect lost values.
+//
+// It reports the throughput of the values sent before n failed, along with
+// the error.
+func BenchmarkNotify(ctx context.Context, n Notifier, size int, d time.Duration) (NotifyBenchmark, error) {
+	var r NotifyBenchmark
+	if size <= 0 {
+		return r, errors.New("invalid value size")
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	b := make([]byte, size)
+	start := time.Now()
+	var err error
+	for ctx.Err() == nil {
+		select {
+		case <-n.Context().Done():
+			err = n.Context().Err()
+		default:
+		}
+		if err != nil {
+			break
+		}
+		if size >= 4 {
+			binary.LittleEndian.PutUint32(b, uint32(r.Count))
+		}
+		var m int
+		if m, err = n.Write(b); err != nil {
+			break
+		}
+		r.Count++
+		r.Bytes += m
+	}
+	r.Elapsed = time.Since(start)
+	if s := r.Elapsed.Seconds(); s > 0 {
+		r.Rate = float64(r.Count) / s
+		r.BytesPerSecond = float64(r.Bytes) / s
+	}
+	return r, err
+}
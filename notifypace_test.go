@@ -0,0 +1,84 @@
+package ble
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countNotifier records the times of the values written to it, and fails
+// once it recorded max values.
+type countNotifier struct {
+	Notifier
+	mu    sync.Mutex
+	times []time.Time
+	vals  [][]byte
+	max   int
+}
+
+func (n *countNotifier) Write(b []byte) (int, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.max > 0 && len(n.vals) == n.max {
+		return 0, errors.New("buffers full")
+	}
+	n.times = append(n.times, time.Now())
+	n.vals = append(n.vals, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func TestNotifyPacer(t *testing.T) {
+	n := &countNotifier{Notifier: NewNotifier(nil)}
+	p := NewNotifyPacer(n, 100, 3)
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if _, err := p.Write([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A burst of 3 values, then one every 10ms.
+	if d := n.times[2].Sub(start); d > 5*time.Millisecond {
+		t.Errorf("burst delayed by %v", d)
+	}
+	if d := n.times[5].Sub(start); d < 25*time.Millisecond {
+		t.Errorf("6 values sent in %v, want 30ms", d)
+	}
+
+	n.Close()
+	if _, err := p.Write([]byte{0}); err != context.Canceled {
+		t.Errorf("write after close: %v", err)
+	}
+}
+
+func TestBenchmarkNotify(t *testing.T) {
+	n := &countNotifier{Notifier: NewNotifier(nil), max: 5}
+	r, err := BenchmarkNotify(context.Background(), n, 8, time.Second)
+	if err == nil || err.Error() != "buffers full" {
+		t.Fatalf("error %v, want buffers full", err)
+	}
+	if r.Count != 5 || r.Bytes != 40 || r.Rate <= 0 {
+		t.Errorf("result %+v, want 5 values of 8 bytes", r)
+	}
+	for i, v := range n.vals {
+		if s := binary.LittleEndian.Uint32(v); s != uint32(i) {
+			t.Errorf("value %d has the sequence number %d", i, s)
+		}
+	}
+
+	n = &countNotifier{Notifier: NewNotifier(nil)}
+	p := NewNotifyPacer(n, 200, 1)
+	r, err = BenchmarkNotify(context.Background(), p, 2, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Count < 5 || r.Count > 12 {
+		t.Errorf("%d values paced at 200/s in 50ms", r.Count)
+	}
+	if _, err := BenchmarkNotify(context.Background(), n, 0, time.Second); err == nil {
+		t.Error("empty values accepted")
+	}
+}
@@ -1,6 +1,7 @@
 package ble
 
 import (
+	"io"
 	"time"
 
 	"github.com/leso-kn/ble/linux/hci/cmd"
@@ -10,19 +11,81 @@ import (
 type DeviceOption interface {
 	SetDialerTimeout(time.Duration) error
 	SetListenerTimeout(time.Duration) error
+	SetACLWriteTimeout(time.Duration) error
 	SetConnParams(cmd.LECreateConnection) error
+	SetExtConnParams(cmd.LEExtendedCreateConnection) error
+	SetConnPHYs(phys uint8) error
+	SetInitiatorScan(interval, window time.Duration, acceptList bool) error
 	SetScanParams(cmd.LESetScanParameters) error
+	SetScanInterval(interval, window time.Duration) error
+	SetScanType(active bool) error
+	SetScanOwnAddrType(typ uint8) error
 	SetAdvParams(cmd.LESetAdvertisingParameters) error
+	SetAdvInterval(min, max time.Duration) error
+	SetAdvChannelMap(m uint8) error
+	SetAdvOwnAddrType(typ uint8) error
+	SetAdvFilterPolicy(policy uint8) error
+	SetAdvRestart(on bool, maxConns int) error
 	SetPeripheralRole() error
 	SetCentralRole() error
 	SetAdvHandlerSync(bool) error
+	SetScanDedup(window time.Duration) error
+	SetScanAllowList(addrs ...string) error
+	SetScanDenyList(addrs ...string) error
+	SetScanAggregate(period time.Duration) error
+	SetScanResponseWait(wait time.Duration) error
+	SetScanDutyCycle(window, idle time.Duration) error
+	SetAdvParseErrorHandler(f func(raw []byte, err error)) error
+	SetLenientAdvParsing(lenient bool) error
+	SetLazyAdvParsing(lazy bool) error
 	SetErrorHandler(handler func(error)) error
+	SetConnParamsRequestHandler(ConnParamsRequestHandler) error
+	SetPreferredConnParams(p ConnParams, delay time.Duration, result ConnParamsResultHandler) error
 	EnableSecurity(interface{}) error
+	SetPairingAuthData(AuthData) error
+	SetKeyDistribution(initKeys, respKeys uint8) error
+	SetEncKeySize(min, max uint8) error
+	SetPairingFeatures(PairingFeatures) error
+	SetStaticPasskey(passkey int) error
+	SetRestoreEncryption(on bool) error
+	SetMaxConnections(n int) error
+	SetInsecureDebugKeys(enable bool) error
+	SetSmpCrypto(c interface{}) error
+	SetPrivacy(localIRK []byte, rpaTimeout time.Duration) error
+	SetPrivacyFile(filename string, rpaTimeout time.Duration) error
+	SetHostAddrResolution(enable bool) error
+	SetAddressRotation(period time.Duration) error
+	SetRandomStaticAddr(a Addr, filename string) error
+	SetAdvTxPowerLevel(include bool) error
+	SetAdvValidation(on bool) error
+	SetLogger(l Logger) error
+	SetLogSampling(subsystem string, s LogSampling) error
+	SetEventMask(mask, leMask uint64) error
+	SetRecovery(handler RecoveryHandler) error
+	SetSkipHCIReset(skip bool) error
+	SetInitCommands(pre, post []HCICommand) error
+	SetAuthPayloadTimeout(d time.Duration, expired func(Addr)) error
 
 	SetTransportHCISocket(id int) error
 	SetTransportH4Socket(addr string, timeout time.Duration) error
+	SetH4SocketAuth(auth interface{}) error
 	SetTransportH4Uart(path string, baud int) error
+	SetTransportVirtual(ctrl io.ReadWriteCloser) error
+	SetHCIChannel(ch interface{}) error
+	SetTransportRecord(w io.Writer) error
+	SetTransportTrace(w io.Writer) error
+	SetUartVendor(vendor interface{}, initBaud int) error
 	SetGattCacheFile(filename string)
+	SetNotificationWorkers(n int) error
+	SetUUIDCompression(on bool) error
+	SetRegistry(r interface{}) error
+	SetReadCoalescing(on bool) error
+	SetGATTRateLimit(rate float64, spacing time.Duration) error
+	SetLEOnly(on bool) error
+	SetNotificationOrdering(on bool) error
+	SetStrictSequential(on bool) error
+	SetNotificationTimestamps(on bool) error
+	SetResponseTolerance(d time.Duration) error
 }
 
 // An Option is a configuration function, which configures the device.
@@ -49,6 +112,18 @@ func OptListenerTimeout(d time.Duration) Option {
 	}
 }
 
+// OptACLWriteTimeout sets how long writes on a connection wait for the
+// controller to free ACL data buffers. Writes block until the connection
+// closes if d is zero, which is the default, and fail immediately when the
+// buffers are full if d is negative. hci.ErrACLBufferFull is returned on timeout.
+// Each connection gets a fair share of the buffers, so a stalled one doesn't
+// block the others.
+func OptACLWriteTimeout(d time.Duration) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetACLWriteTimeout(d)
+	}
+}
+
 // OptConnParams overrides default connection parameters.
 func OptConnParams(param cmd.LECreateConnection) Option {
 	return func(opt DeviceOption) error {
@@ -57,6 +132,48 @@ func OptConnParams(param cmd.LECreateConnection) Option {
 	}
 }
 
+// OptExtConnParams makes Dial use LE Extended Create Connection, which can
+// initiate on several PHYs at once with separate parameters for each. The
+// peer address is filled in by Dial. The controller must support extended
+// advertising.
+func OptExtConnParams(param cmd.LEExtendedCreateConnection) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetExtConnParams(param)
+	}
+}
+
+// PHYs to initiate connections on with OptConnPHYs.
+const (
+	PHY1M    uint8 = 0x01
+	PHY2M    uint8 = 0x02
+	PHYCoded uint8 = 0x04
+)
+
+// OptConnPHYs makes Dial initiate connections on the given combination of
+// PHY1M, PHY2M and PHYCoded, using the connection parameters set before this
+// option for each of them. PHYCoded is needed to connect to long range
+// advertisers.
+func OptConnPHYs(phys uint8) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetConnPHYs(phys)
+	}
+}
+
+// OptInitiatorScan sets how Dial and Connect scan for the peer while
+// initiating connections, apart from the parameters of Scan. Both range from
+// 2.5 msec to 10.24 sec, in steps of 0.625 msec, and window must not exceed
+// interval; a window equal to the interval connects fastest. With
+// acceptList, the controller connects to the first peer of its filter
+// accept list it finds, e.g. the bonded peers after AcceptBonded, rather
+// than looking for the dialed address; Dial then disconnects and fails if
+// that's another peer. DialParams still override the interval and window of
+// a single Dial.
+func OptInitiatorScan(interval, window time.Duration, acceptList bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetInitiatorScan(interval, window, acceptList)
+	}
+}
+
 // OptScanParams overrides default scanning parameters.
 func OptScanParams(param cmd.LESetScanParameters) Option {
 	return func(opt DeviceOption) error {
@@ -65,6 +182,33 @@ func OptScanParams(param cmd.LESetScanParameters) Option {
 	}
 }
 
+// OptScanInterval sets how often the controller scans, and for how long each
+// time. Both range from 2.5 msec to 10.24 sec, in steps of 0.625 msec, and
+// window must not exceed interval. A small window relative to the interval
+// lowers the duty cycle, and power consumption, of scanning.
+func OptScanInterval(interval, window time.Duration) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetScanInterval(interval, window)
+	}
+}
+
+// OptActiveScan selects active scanning, which requests scan responses from
+// advertisers, or passive scanning, which only listens. Scanning is active by default.
+func OptActiveScan(active bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetScanType(active)
+	}
+}
+
+// OptScanOwnAddrType sets the address type the device uses in scan requests:
+// 0x00 public, 0x01 random, 0x02 resolvable private or public, 0x03
+// resolvable private or random.
+func OptScanOwnAddrType(typ uint8) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetScanOwnAddrType(typ)
+	}
+}
+
 // OptAdvParams overrides default advertising parameters.
 func OptAdvParams(param cmd.LESetAdvertisingParameters) Option {
 	return func(opt DeviceOption) error {
@@ -73,6 +217,60 @@ func OptAdvParams(param cmd.LESetAdvertisingParameters) Option {
 	}
 }
 
+// OptAdvInterval sets the range of the advertising interval, from 20 msec to
+// 10.24 sec in steps of 0.625 msec. Shorter intervals make the device faster
+// to discover, at the cost of power consumption.
+func OptAdvInterval(min, max time.Duration) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetAdvInterval(min, max)
+	}
+}
+
+// Advertising channels for OptAdvChannelMap.
+const (
+	AdvChannel37   uint8 = 0x01
+	AdvChannel38   uint8 = 0x02
+	AdvChannel39   uint8 = 0x04
+	AdvChannelsAll uint8 = AdvChannel37 | AdvChannel38 | AdvChannel39
+)
+
+// OptAdvChannelMap sets the channels used for advertising, as a combination
+// of AdvChannel37, AdvChannel38 and AdvChannel39. All channels are used by default.
+func OptAdvChannelMap(m uint8) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetAdvChannelMap(m)
+	}
+}
+
+// OptAdvOwnAddrType sets the address type the device advertises with:
+// 0x00 public, 0x01 random, 0x02 resolvable private or public, 0x03
+// resolvable private or random.
+func OptAdvOwnAddrType(typ uint8) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetAdvOwnAddrType(typ)
+	}
+}
+
+// OptAdvFilterPolicy sets which scan and connection requests are processed
+// while advertising, e.g. hci.AdvFilterPolicyScanAndConn to ignore all devices
+// but the ones of the controller's filter accept list, such as bonded peers.
+// It applies to all the Advertise functions, from the next one on.
+func OptAdvFilterPolicy(policy uint8) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetAdvFilterPolicy(policy)
+	}
+}
+
+// OptAdvRestart sets whether advertising restarts when a central connects, as
+// the controller stops it; it does by default. With maxConns above 0, it
+// stops restarting while maxConns centrals are connected, and restarts once
+// one disconnects.
+func OptAdvRestart(on bool, maxConns int) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetAdvRestart(on, maxConns)
+	}
+}
+
 // OptPeripheralRole configures the device to perform Peripheral tasks.
 func OptPeripheralRole() Option {
 	return func(opt DeviceOption) error {
@@ -97,7 +295,99 @@ func OptAdvHandlerSync(sync bool) Option {
 	}
 }
 
-// OptErrorHandler sets error handler
+// OptScanDedup filters advertisements in the host, reporting those with the
+// same address and payload only once per window. Unlike the controller's
+// duplicate filtering, it applies when scanning with allowDup too, and lets
+// changed payloads through. Zero disables it.
+func OptScanDedup(window time.Duration) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetScanDedup(window)
+	}
+}
+
+// OptScanAllowList reports only the advertisements of the advertisers in
+// addrs, given as addresses such as "aa:bb:cc:dd:ee:ff" or as OUI prefixes of
+// public addresses such as "aa:bb:cc". With host address resolution, the
+// identity addresses of the bonded peers match their private addresses. The
+// reports of the others are dropped before they're parsed.
+func OptScanAllowList(addrs ...string) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetScanAllowList(addrs...)
+	}
+}
+
+// OptScanDenyList drops the advertisements of the advertisers in addrs,
+// matched like those of OptScanAllowList, before they're parsed. It takes
+// precedence over the allow list.
+func OptScanDenyList(addrs ...string) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetScanDenyList(addrs...)
+	}
+}
+
+// OptScanResponseWait makes active scans hold the scannable advertisements
+// of a peer for up to wait, until its scan response arrives, and report them
+// as a single advertisement with both the advertising data and the scan
+// response. If the scan response doesn't arrive in time, the advertising
+// data is reported alone. The linux Advertisement tells them apart with
+// HasScanResponse. Zero reports each packet as received.
+func OptScanResponseWait(wait time.Duration) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetScanResponseWait(wait)
+	}
+}
+
+// OptScanAggregate makes scans report one consolidated advertisement per
+// peer and period instead of every packet. It merges the latest advertising
+// data and scan response of the peer, e.g. the services from the former and
+// the name from the latter, with the freshest RSSI. Zero disables it.
+func OptScanAggregate(period time.Duration) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetScanAggregate(period)
+	}
+}
+
+// OptScanDutyCycle saves power by scanning for window, then resting for idle,
+// e.g. 1s every 10s, and so on until the scan stops. Scanning resumes by
+// itself, so the advertisement handler just receives fewer reports. Zero
+// durations scan continuously.
+func OptScanDutyCycle(window, idle time.Duration) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetScanDutyCycle(window, idle)
+	}
+}
+
+// OptAdvParseErrorHandler sets a handler called with the raw bytes of each
+// advertising report that fails to parse, in addition to the error handler.
+func OptAdvParseErrorHandler(f func(raw []byte, err error)) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetAdvParseErrorHandler(f)
+	}
+}
+
+// OptLenientAdvParsing makes scans skip malformed AD structures, and report
+// what could be parsed of the advertisement along with the parse errors,
+// rather than dropping the whole advertisement.
+func OptLenientAdvParsing(lenient bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetLenientAdvParsing(lenient)
+	}
+}
+
+// OptLazyAdvParsing makes scans decode the fields of advertisements on
+// demand, from the advertising report, rather than into a map for each
+// report. It cuts the allocations of dense scans whose handlers look at a
+// few fields. ToMap still works, decoding all the fields when called.
+// Malformed AD structures are skipped, as with OptLenientAdvParsing.
+func OptLazyAdvParsing(lazy bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetLazyAdvParsing(lazy)
+	}
+}
+
+// OptErrorHandler sets error handler. It's also called with the error which
+// stopped the device, e.g. ErrAdapterLost when an H4 UART adapter was
+// unplugged.
 func OptErrorHandler(handler func(error)) Option {
 	return func(opt DeviceOption) error {
 		opt.SetErrorHandler(handler)
@@ -105,6 +395,27 @@ func OptErrorHandler(handler func(error)) Option {
 	}
 }
 
+// OptConnParamsRequestHandler sets the policy applied when a remote device
+// requests different connection parameters. Without it, requests are accepted as is.
+func OptConnParamsRequestHandler(h ConnParamsRequestHandler) Option {
+	return func(opt DeviceOption) error {
+		opt.SetConnParamsRequestHandler(h)
+		return nil
+	}
+}
+
+// OptPreferredConnParams has the peripheral request the connection
+// parameters p from each central, delay after it connects, as battery
+// powered peripherals do rather than keep the interval the central chose.
+// They're also the value of the Peripheral Preferred Connection Parameters
+// characteristic of the GAP service. result, if not nil, is notified
+// whether the central accepted them.
+func OptPreferredConnParams(p ConnParams, delay time.Duration, result ConnParamsResultHandler) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetPreferredConnParams(p, delay, result)
+	}
+}
+
 // OptEnableSecurity enables bonding with devices
 func OptEnableSecurity(bondManager interface{}) Option {
 	return func(opt DeviceOption) error {
@@ -113,6 +424,263 @@ func OptEnableSecurity(bondManager interface{}) Option {
 	}
 }
 
+// OptPairingAuthData sets the auth data used when the peer initiates pairing,
+// e.g. the callbacks to display or input a passkey.
+func OptPairingAuthData(ad AuthData) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetPairingAuthData(ad)
+	}
+}
+
+// OptPairingFeatures sets the IO capability and the authentication
+// requirements of the device when pairing, which determine the pairing
+// method. AuthData.Features overrides them for a single pairing.
+// The default is DefaultPairingFeatures.
+func OptPairingFeatures(f PairingFeatures) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetPairingFeatures(f)
+	}
+}
+
+// OptStaticPasskey pairs the device with a fixed 6-digit passkey, as many
+// industrial peripherals do, in either role. It pairs as a display only
+// device requiring MITM protection, so the peer inputs the passkey, and
+// uses it in both LE legacy and LE Secure Connections Passkey Entry pairing,
+// without AuthData callbacks. It overrides the IO capability and the
+// authentication requirements set by OptPairingFeatures.
+func OptStaticPasskey(passkey int) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetStaticPasskey(passkey)
+	}
+}
+
+// OptRestoreEncryption has Dial and Connect encrypt the links with bonded
+// peers with the keys of the bond manager, before they return, so
+// applications don't call Pair again. If the peer lost the bond, they fail
+// with ErrBondMissing, or else ErrBondRejected if the peer refused the keys,
+// and disconnect.
+func OptRestoreEncryption(on bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetRestoreEncryption(on)
+	}
+}
+
+// OptMaxConnections limits the connections of the device, in either role, to
+// n; 0 leaves the limit to the controller. At the limit, Dial and Connect
+// fail with an error caused by the Connection Limit Exceeded HCI error
+// rather than reaching the controller.
+func OptMaxConnections(n int) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetMaxConnections(n)
+	}
+}
+
+// OptInsecureDebugKeys has LE Secure Connections pairing use the debug key
+// pair defined by the specification, so sniffers can decrypt the traffic.
+// INSECURE: anyone can decrypt the traffic and take over the bonds. This is
+// for development only; peers may refuse to pair with the debug keys.
+func OptInsecureDebugKeys(enable bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetInsecureDebugKeys(enable)
+	}
+}
+
+// OptSmpCrypto sets the cryptographic functions of pairing, AES-CMAC, the
+// P-256 ECDH and the functions built on them, in place of the pure Go ones,
+// e.g. to keep the keys in a TPM or a secure element, or to use a validated
+// module. On Linux, c is an smp.Crypto of linux/hci/smp, such as one made
+// with smp.NewCrypto of the primitives.
+func OptSmpCrypto(c interface{}) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetSmpCrypto(c)
+	}
+}
+
+// OptKeyDistribution sets the keys requested from, and offered to, the peer
+// when pairing, as the key distribution fields of the pairing request and
+// response: initKeys are distributed by the initiator, respKeys by the
+// responder. See the hci.KeyDist flags. The default is the identity key of
+// the initiator, and the LTK and identity key of the responder.
+func OptKeyDistribution(initKeys, respKeys uint8) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetKeyDistribution(initKeys, respKeys)
+	}
+}
+
+// OptEncKeySize sets the range of encryption key sizes, in octets, accepted
+// when pairing, between 7 and 16. Pairing fails if the keys would be shorter
+// than min, and links encrypted with a shorter key are disconnected, which
+// guards against key size downgrades. The default is 7 to 16.
+func OptEncKeySize(min, max uint8) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetEncKeySize(min, max)
+	}
+}
+
+// OptPrivacy enables controller-based privacy. The local device uses resolvable
+// private addresses generated from localIRK, which are rotated every rpaTimeout,
+// and resolves the addresses of bonded peers. The 16 bytes localIRK is given to
+// bonding peers, so it must be the same across restarts for them to resolve the
+// addresses; see OptPrivacyFile.
+func OptPrivacy(localIRK []byte, rpaTimeout time.Duration) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetPrivacy(localIRK, rpaTimeout)
+	}
+}
+
+// OptPrivacyFile is like OptPrivacy, but reads the local IRK from filename. If
+// the file doesn't exist, a new IRK is generated and stored there, so it
+// persists across restarts.
+func OptPrivacyFile(filename string, rpaTimeout time.Duration) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetPrivacyFile(filename, rpaTimeout)
+	}
+}
+
+// OptHostAddrResolution enables resolving the private addresses of advertisers
+// on the host, using the IRKs of bonded peers. This works independently of
+// controller support for privacy. Security must be enabled with a bond manager
+// which is able to list its bonds.
+func OptHostAddrResolution(enable bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetHostAddrResolution(enable)
+	}
+}
+
+// OptAddressRotation rotates the private address of the local device every
+// period. With OptPrivacy, the controller rotates its RPAs, unless it doesn't
+// support privacy; otherwise, the host rotates the random address, pausing
+// advertising and scanning around the change. The random addresses of the
+// advertising sets which aren't static are rotated as well.
+func OptAddressRotation(period time.Duration) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetAddressRotation(period)
+	}
+}
+
+// OptEventMask unmasks HCI events (Set Event Mask) and LE Meta subevents
+// (LE Set Event Mask) in addition to the ones the device handles itself,
+// so they can be delivered to user registered event handlers.
+func OptEventMask(mask, leMask uint64) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetEventMask(mask, leMask)
+	}
+}
+
+// OptAdvTxPowerLevel includes the TX Power Level AD field in advertisements,
+// with the advertising transmit power reported by the controller.
+func OptAdvTxPowerLevel(include bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetAdvTxPowerLevel(include)
+	}
+}
+
+// OptAdvValidation has the device check the advertising and scan response
+// data against the rules of the Core Specification Supplement, such as the
+// flags of connectable advertising, repeated AD types and the consistency
+// of the local names, before they're sent to the controller. The device
+// then fails to advertise them with an error telling what's wrong, rather
+// than the bare status code of a controller rejecting them, or a packet
+// scanners ignore. See adv.Validate.
+func OptAdvValidation(on bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetAdvValidation(on)
+	}
+}
+
+// OptLogger sets the logger of the device, instead of the one of the
+// package, e.g. a child of it telling which adapter the lines are from when
+// several are used:
+//
+//	ble.OptLogger(ble.GetLogger().ChildLogger(map[string]interface{}{"hci": 1}))
+func OptLogger(l Logger) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetLogger(l)
+	}
+}
+
+// OptLogSampling samples the debug and info lines of a high-frequency
+// subsystem of the device, LogScan, LogACL or LogATT, so debug logging can
+// be enabled in production:
+//
+//	ble.OptLogSampling(ble.LogScan, ble.LogSampling{Period: time.Second, First: 10, Thereafter: 100})
+func OptLogSampling(subsystem string, s LogSampling) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetLogSampling(subsystem, s)
+	}
+}
+
+// OptRandomStaticAddr makes the device advertise, scan and initiate connections
+// with the given random static address instead of its public address.
+// A new address is generated on every start if a is nil.
+func OptRandomStaticAddr(a Addr) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetRandomStaticAddr(a, "")
+	}
+}
+
+// OptRandomStaticAddrFile is like OptRandomStaticAddr, but reads the address
+// from filename. If the file doesn't exist, a new address is generated and
+// stored there, so it persists across restarts.
+func OptRandomStaticAddrFile(filename string) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetRandomStaticAddr(nil, filename)
+	}
+}
+
+// OptRecovery enables a watchdog which detects controller failures, such as
+// commands timing out, the transport closing or hardware error events. The
+// controller is then reset and re-initialized, and advertising and scanning
+// are restored; open connections are lost. Each recovery attempt is reported
+// to handler, which may be nil.
+//
+// An unplugged H4 UART adapter is reported with a cause wrapping
+// ErrAdapterLost, and attempts continue until the port returns. Use a stable
+// port name, such as /dev/serial/by-id/..., as the adapter may come back on
+// another ttyUSB.
+func OptRecovery(handler RecoveryHandler) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetRecovery(handler)
+	}
+}
+
+// OptAuthPayloadTimeout sets the authenticated payload timeout of encrypted
+// links. The controller pings peers which don't send packets within d, and
+// expired is called with the peer's address when no valid packet arrived in
+// time. A zero d keeps the controller's default of 30 seconds; expired may be nil.
+func OptAuthPayloadTimeout(d time.Duration, expired func(a Addr)) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetAuthPayloadTimeout(d, expired)
+	}
+}
+
+// HCICommand is a raw HCI command, e.g. a hci.CustomCommand.
+type HCICommand interface {
+	OpCode() int
+	Len() int
+	Marshal([]byte) error
+	String() string
+}
+
+// OptSkipHCIReset skips the HCI Reset command of the init sequence, e.g. when
+// the controller is shared with another host stack, or its configuration
+// doesn't survive a reset.
+func OptSkipHCIReset() Option {
+	return func(opt DeviceOption) error {
+		return opt.SetSkipHCIReset(true)
+	}
+}
+
+// OptInitCommands sends the pre commands before the standard init sequence,
+// which starts with the HCI Reset, and the post commands after it. This is
+// useful for vendor setup, like setting the BD_ADDR on controllers which
+// boot with an all-zero address. Init fails if any of the commands fails.
+func OptInitCommands(pre, post []HCICommand) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetInitCommands(pre, post)
+	}
+}
+
 // OptTransportHCISocket set hci socket transport
 func OptTransportHCISocket(id int) Option {
 	return func(opt DeviceOption) error {
@@ -121,6 +689,17 @@ func OptTransportHCISocket(id int) Option {
 	}
 }
 
+// OptHCIChannel sets the channel of the hci socket transport, a
+// socket.Channel from linux/hci/socket: the exclusive user channel, which is
+// the default, the raw channel shared with the kernel's host stack, or the
+// user channel falling back to the raw one for busy devices. It must follow
+// OptTransportHCISocket.
+func OptHCIChannel(ch interface{}) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetHCIChannel(ch)
+	}
+}
+
 // OptTransportH4Socket set h4 socket transport
 func OptTransportH4Socket(addr string, timeout time.Duration) Option {
 	return func(opt DeviceOption) error {
@@ -129,6 +708,15 @@ func OptTransportH4Socket(addr string, timeout time.Duration) Option {
 	}
 }
 
+// OptH4SocketAuth secures the h4 socket transport with an *h4.SocketAuth
+// from linux/hci/h4: TLS, optionally with a client certificate, and a token
+// checked by the proxy. It must follow OptTransportH4Socket.
+func OptH4SocketAuth(auth interface{}) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetH4SocketAuth(auth)
+	}
+}
+
 // OptTransportH4Uart set h4 uart transport
 func OptTransportH4Uart(path string, baud int) Option {
 	return func(opt DeviceOption) error {
@@ -137,9 +725,158 @@ func OptTransportH4Uart(path string, baud int) Option {
 	}
 }
 
+// OptTransportVirtual sets a virtual controller from linux/hci/virtual, or a
+// trace replayer from linux/hci/replay, as the transport, e.g. to test
+// applications without hardware.
+func OptTransportVirtual(ctrl io.ReadWriteCloser) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetTransportVirtual(ctrl)
+	}
+}
+
+// OptTransportRecord records the HCI session to w as a btsnoop trace, which
+// can be opened with Wireshark or replayed with linux/hci/replay. It must
+// follow the transport option.
+func OptTransportRecord(w io.Writer) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetTransportRecord(w)
+	}
+}
+
+// OptTransportTrace writes a human-readable line to w for each HCI packet of
+// the session, decoding the ATT and SMP PDUs in full, like btmon. It must
+// follow the transport option.
+func OptTransportTrace(w io.Writer) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetTransportTrace(w)
+	}
+}
+
+// OptUartVendor brings up the controller of the H4 UART transport with a
+// vendor hook, such as an h4.Broadcom or h4.Realtek firmware loader, before
+// the standard init. The hook runs at initBaud; 0 uses the transport's rate.
+// It must follow OptTransportH4Uart.
+func OptUartVendor(vendor interface{}, initBaud int) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetUartVendor(vendor, initBaud)
+	}
+}
+
 func OptGattCacheFile(filename string) Option {
 	return func(opt DeviceOption) error {
 		opt.SetGattCacheFile(filename)
 		return nil
 	}
 }
+
+// OptNotificationWorkers sets the number of goroutines handling the
+// notifications and indications of each connection, 1 by default. Those of
+// a characteristic are handled in order, while those of different
+// characteristics may be handled in parallel, so a slow handler only delays
+// its own characteristic. Handlers must then be safe for concurrent use.
+func OptNotificationWorkers(n int) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetNotificationWorkers(n)
+	}
+}
+
+// OptUUIDCompression sets whether the 128-bit UUIDs based on the Bluetooth
+// Base UUID are compressed to their 16-bit or 32-bit form in discovered
+// profiles, as they are by default, so they compare equal to UUID16 and
+// UUID32 whichever form the peripheral encoded them in.
+func OptUUIDCompression(on bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetUUIDCompression(on)
+	}
+}
+
+// OptRegistry records the devices observed while scanning in the registry
+// r, a *registry.Registry, whatever the advertisement handler.
+func OptRegistry(r interface{}) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetRegistry(r)
+	}
+}
+
+// OptReadCoalescing sets whether concurrent reads of the same characteristic
+// over a connection are coalesced into a single ATT transaction, whose result
+// they share, for applications whose modules poll the same characteristics.
+func OptReadCoalescing(on bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetReadCoalescing(on)
+	}
+}
+
+// OptGATTRateLimit limits the GATT operations over each connection dialed to
+// rate per second, in bursts of up to a second's worth, and spaces them by at
+// least spacing, for fragile peripherals which crash or disconnect when
+// they're polled too quickly. Operations wait for their turn rather than
+// failing. A single peer can be limited with the SetRateLimit method of its
+// client instead. Zero values lift the limits.
+func OptGATTRateLimit(rate float64, spacing time.Duration) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetGATTRateLimit(rate, spacing)
+	}
+}
+
+// OptNotificationOrdering sets whether a characteristic read over a
+// connection dialed returns only once the notifications and indications of
+// the characteristic received before the read response are handled. By
+// default, they're handled concurrently with the read, so its result may be
+// returned first. Notification handlers may issue GATT operations of the
+// connection, but must then not wait for those of other goroutines.
+func OptNotificationOrdering(on bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetNotificationOrdering(on)
+	}
+}
+
+// OptStrictSequential sets whether a GATT operation over a connection
+// dialed, attempted while the request of another one waits for its
+// response, fails at once with an error matching att.ErrRequestPending of
+// linux/att, rather than waiting. The ATT allows a single pending request
+// per connection, so a notification handler waiting for an operation while
+// the notifications hold back the response of another deadlocks; this
+// reports it instead. The gatt.Client of linux/gatt tells the pending
+// request, and cancels it.
+func OptStrictSequential(on bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetStrictSequential(on)
+	}
+}
+
+// OptLEOnly configures dual-mode controllers for LE only at init: their
+// BR/EDR inquiry and page scans are disabled, so they're neither
+// discoverable nor connectable over BR/EDR, whatever state another stack
+// left them in.
+func OptLEOnly(on bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetLEOnly(on)
+	}
+}
+
+// OptNotificationTimestamps sets whether the data packets received are
+// stamped with the time the HCI received them at, before they're queued, so
+// that the handlers subscribed with gatt.Client.SubscribeTimestamped of
+// linux/gatt get the time a notification was received at, e.g. to align the
+// samples of several peripherals, and gatt.Client.EstimateLatency measures
+// the round trips of the connection from them.
+func OptNotificationTimestamps(on bool) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetNotificationTimestamps(on)
+	}
+}
+
+// OptResponseTolerance sets whether the ATT responses received over a
+// connection dialed, or by the peer client of a connection accepted, are
+// correlated with the pending request, for
+// peripherals which send duplicate responses, or late ones after a request
+// timed out. Those are discarded, rather than returned as the responses of
+// the next requests, and counted by gatt.Client.DiscardedResponses of
+// linux/gatt. The response of a request timed out is expected for d; a d of
+// zero disables the correlation.
+func OptResponseTolerance(d time.Duration) Option {
+	return func(opt DeviceOption) error {
+		return opt.SetResponseTolerance(d)
+	}
+}
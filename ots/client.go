@@ -0,0 +1,397 @@
+package ots
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/leso-kn/ble"
+)
+
+// procedureTimeout is the time the responses of the control points are
+// waited for, that of an ATT transaction.
+const procedureTimeout = 30 * time.Second
+
+// ErrAborted is returned by a Read aborted with Abort.
+var ErrAborted = errors.New("ots: read aborted")
+
+// Client transfers the objects of the Object Transfer Service of a remote
+// device. Its methods act on the current object, selected with those of the
+// list, e.g. First and Next.
+type Client struct {
+	cln     ble.Client
+	feature *ble.Characteristic
+	name    *ble.Characteristic
+	typ     *ble.Characteristic
+	size    *ble.Characteristic
+	id      *ble.Characteristic
+	props   *ble.Characteristic
+	oacp    *ble.Characteristic
+	olcp    *ble.Characteristic
+
+	// rsp receives the responses indicated by the control points.
+	rsp chan []byte
+
+	mu     sync.Mutex // Serializes the procedures.
+	muXfer sync.Mutex // Serializes the transfers.
+
+	muCh    sync.Mutex
+	ch      ble.L2CAPChannel
+	r       *bufio.Reader
+	aborted bool
+}
+
+// NewClient returns a client of the Object Transfer Service of the
+// discovered profile of cln, and subscribes to its control points.
+func NewClient(cln ble.Client) (*Client, error) {
+	p := cln.Profile()
+	if p == nil {
+		return nil, errors.New("ots: profile not discovered")
+	}
+	var svc *ble.Service
+	for _, s := range p.Services {
+		if s.UUID.Equal(ServiceUUID) {
+			svc = s
+		}
+	}
+	if svc == nil {
+		return nil, ErrNotFound
+	}
+	c := &Client{cln: cln, rsp: make(chan []byte, 1)}
+	chars := []struct {
+		u ble.UUID
+		c **ble.Characteristic
+	}{
+		{FeatureUUID, &c.feature},
+		{NameUUID, &c.name},
+		{TypeUUID, &c.typ},
+		{SizeUUID, &c.size},
+		{IDUUID, &c.id},
+		{PropertiesUUID, &c.props},
+		{OACPUUID, &c.oacp},
+		{OLCPUUID, &c.olcp},
+	}
+	for _, f := range chars {
+		for _, ch := range svc.Characteristics {
+			if ch.UUID.Equal(f.u) {
+				*f.c = ch
+			}
+		}
+		if *f.c == nil {
+			return nil, ErrNotFound
+		}
+	}
+
+	h := func(id uint, b []byte) {
+		select {
+		case c.rsp <- append([]byte{}, b...):
+		default:
+		}
+	}
+	if err := cln.Subscribe(c.oacp, true, h); err != nil {
+		return nil, err
+	}
+	if err := cln.Subscribe(c.olcp, true, h); err != nil {
+		cln.Unsubscribe(c.oacp, true)
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close unsubscribes from the control points, and closes the channel.
+func (c *Client) Close() error {
+	err := c.cln.Unsubscribe(c.oacp, true)
+	if err2 := c.cln.Unsubscribe(c.olcp, true); err == nil {
+		err = err2
+	}
+	c.closeChannel()
+	return err
+}
+
+// Features returns the features of the server.
+func (c *Client) Features() (Features, error) {
+	var f Features
+	b, err := c.cln.ReadCharacteristic(c.feature)
+	if err != nil {
+		return f, err
+	}
+	return f, f.unmarshal(b)
+}
+
+// Metadata returns the metadata of the current object.
+func (c *Client) Metadata() (Metadata, error) {
+	var md Metadata
+	b, err := c.cln.ReadLongCharacteristic(c.name)
+	if err != nil {
+		return md, err
+	}
+	md.Name = string(b)
+	if b, err = c.cln.ReadCharacteristic(c.typ); err != nil {
+		return md, err
+	}
+	md.Type = ble.UUID(b)
+	if b, err = c.cln.ReadCharacteristic(c.size); err != nil {
+		return md, err
+	}
+	if len(b) < 8 {
+		return md, errors.New("ots: short size value")
+	}
+	md.Size = binary.LittleEndian.Uint32(b)
+	md.AllocatedSize = binary.LittleEndian.Uint32(b[4:])
+	if b, err = c.cln.ReadCharacteristic(c.id); err != nil {
+		return md, err
+	}
+	if len(b) < 6 {
+		return md, errors.New("ots: short id value")
+	}
+	md.ID = uint48(b)
+	if b, err = c.cln.ReadCharacteristic(c.props); err != nil {
+		return md, err
+	}
+	if len(b) < 4 {
+		return md, errors.New("ots: short properties value")
+	}
+	md.Properties = Properties(binary.LittleEndian.Uint32(b))
+	return md, nil
+}
+
+// First, Last, Previous and Next select the first, the last, the previous
+// or the next object of the list as the current object.
+func (c *Client) First() error    { _, err := c.list(OpFirst, nil); return err }
+func (c *Client) Last() error     { _, err := c.list(OpLast, nil); return err }
+func (c *Client) Previous() error { _, err := c.list(OpPrevious, nil); return err }
+func (c *Client) Next() error     { _, err := c.list(OpNext, nil); return err }
+
+// GoTo selects the object with the id as the current object.
+func (c *Client) GoTo(id uint64) error {
+	if id > maxObjectID {
+		return errors.New("ots: invalid object id")
+	}
+	p := make([]byte, 6)
+	putUint48(p, id)
+	_, err := c.list(OpGoTo, p)
+	return err
+}
+
+// Count returns the number of objects of the list.
+func (c *Client) Count() (int, error) {
+	p, err := c.list(OpNumberOf, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(p) < 4 {
+		return 0, errors.New("ots: short response")
+	}
+	return int(binary.LittleEndian.Uint32(p)), nil
+}
+
+// Create creates an object of the type typ, with size octets allocated to
+// it, and selects it as the current object.
+func (c *Client) Create(size uint32, typ ble.UUID) error {
+	p := make([]byte, 4, 4+len(typ))
+	binary.LittleEndian.PutUint32(p, size)
+	_, err := c.action(OpCreate, append(p, typ...))
+	return err
+}
+
+// Delete deletes the current object.
+func (c *Client) Delete() error {
+	_, err := c.action(OpDelete, nil)
+	return err
+}
+
+// Checksum returns the CRC-32 the server calculates of n octets of the
+// current object at off.
+func (c *Client) Checksum(off, n uint32) (uint32, error) {
+	p, err := c.action(OpChecksum, rangeParams(off, n))
+	if err != nil {
+		return 0, err
+	}
+	if len(p) < 4 {
+		return 0, errors.New("ots: short response")
+	}
+	return binary.LittleEndian.Uint32(p), nil
+}
+
+// Verify checks that b is the contents of the current object at off,
+// with the checksum of the server. It returns ErrChecksum if it isn't.
+func (c *Client) Verify(off uint32, b []byte) error {
+	sum, err := c.Checksum(off, uint32(len(b)))
+	if err != nil {
+		return err
+	}
+	if sum != crc32.ChecksumIEEE(b) {
+		return ErrChecksum
+	}
+	return nil
+}
+
+// Read reads n octets of the current object at off over the channel,
+// opened to PSM on the first transfer.
+func (c *Client) Read(off, n uint32) ([]byte, error) {
+	c.muXfer.Lock()
+	defer c.muXfer.Unlock()
+	_, r, err := c.channel()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.action(OpRead, rangeParams(off, n)); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		c.closeChannel()
+		c.muCh.Lock()
+		defer c.muCh.Unlock()
+		if c.aborted {
+			return nil, ErrAborted
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// Write writes b to the current object at off over the channel, opened to
+// PSM on the first transfer. With truncate, the object is truncated after
+// b. It returns once b is sent; the server may still be writing it.
+func (c *Client) Write(off uint32, b []byte, truncate bool) error {
+	c.muXfer.Lock()
+	defer c.muXfer.Unlock()
+	ch, _, err := c.channel()
+	if err != nil {
+		return err
+	}
+	mode := byte(0)
+	if truncate {
+		mode = writeModeTruncate
+	}
+	if _, err := c.action(OpWrite, append(rangeParams(off, uint32(len(b))), mode)); err != nil {
+		return err
+	}
+	mtu := ch.MTU()
+	if mtu <= 0 {
+		mtu = ble.DefaultMTU
+	}
+	for len(b) > 0 {
+		n := len(b)
+		if n > mtu {
+			n = mtu
+		}
+		if _, err := ch.Write(b[:n]); err != nil {
+			c.closeChannel()
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// Abort aborts the ongoing Read, e.g. from another goroutine, which fails
+// with ErrAborted. The channel is reopened by the next transfer.
+func (c *Client) Abort() error {
+	if _, err := c.action(OpAbort, nil); err != nil {
+		return err
+	}
+	c.muCh.Lock()
+	ch := c.ch
+	c.aborted = ch != nil
+	c.muCh.Unlock()
+	if ch != nil {
+		ch.Close()
+	}
+	return nil
+}
+
+// channel returns the channel of the transfers, and its reader, opening it
+// if needed.
+func (c *Client) channel() (ble.L2CAPChannel, *bufio.Reader, error) {
+	c.muCh.Lock()
+	defer c.muCh.Unlock()
+	if c.ch == nil {
+		ch, err := ble.OpenL2CAPChannel(c.cln, PSM)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Channels return a whole SDU per read, which must fit.
+		c.ch, c.r, c.aborted = ch, bufio.NewReaderSize(ch, 1<<16), false
+	}
+	return c.ch, c.r, nil
+}
+
+// closeChannel closes the channel, if it's open, for the next transfer to
+// open another.
+func (c *Client) closeChannel() {
+	c.muCh.Lock()
+	ch := c.ch
+	c.ch, c.r = nil, nil
+	c.muCh.Unlock()
+	if ch != nil {
+		ch.Close()
+	}
+}
+
+// action runs the procedure op of the Object Action Control Point, and
+// returns the parameters of its response.
+func (c *Client) action(op byte, p []byte) ([]byte, error) {
+	res, params, err := c.procedure(c.oacp, opOACPResponse, op, p)
+	if err != nil {
+		return nil, err
+	}
+	if r := OACPResult(res); r != OACPSuccess {
+		return nil, &OACPError{Op: op, Result: r}
+	}
+	return params, nil
+}
+
+// list runs the procedure op of the Object List Control Point, and returns
+// the parameters of its response.
+func (c *Client) list(op byte, p []byte) ([]byte, error) {
+	res, params, err := c.procedure(c.olcp, opOLCPResponse, op, p)
+	if err != nil {
+		return nil, err
+	}
+	if r := OLCPResult(res); r != OLCPSuccess {
+		return nil, &OLCPError{Op: op, Result: r}
+	}
+	return params, nil
+}
+
+// procedure writes the procedure op with the parameters p to the control
+// point cp, and waits for its response, indicated with the opcode rspOp.
+func (c *Client) procedure(cp *ble.Characteristic, rspOp, op byte, p []byte) (byte, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Drop the responses to the procedures which timed out.
+	for len(c.rsp) > 0 {
+		<-c.rsp
+	}
+	if err := c.cln.WriteCharacteristic(cp, append([]byte{op}, p...), false); err != nil {
+		return 0, nil, err
+	}
+	t := time.NewTimer(procedureTimeout)
+	defer t.Stop()
+	for {
+		select {
+		case v := <-c.rsp:
+			if len(v) < 3 || v[0] != rspOp || v[1] != op {
+				continue
+			}
+			return v[2], v[3:], nil
+		case <-c.cln.Disconnected():
+			return 0, nil, io.ErrClosedPipe
+		case <-t.C:
+			return 0, nil, errors.New("ots: procedure timed out")
+		}
+	}
+}
+
+func rangeParams(off, n uint32) []byte {
+	p := make([]byte, 8)
+	binary.LittleEndian.PutUint32(p, off)
+	binary.LittleEndian.PutUint32(p[4:], n)
+	return p
+}
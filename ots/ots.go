@@ -0,0 +1,281 @@
+// Package ots implements the Object Transfer Service [OTS v1.0], a standard
+// way to exchange large objects, e.g. firmware images or logs, with a GATT
+// server: the objects are listed and selected with the Object List Control
+// Point, their metadata read from characteristics, and their contents
+// transferred over an L2CAP connection-oriented channel, as directed by the
+// Object Action Control Point.
+//
+// A Server serves the objects of a Store, and a Client transfers them. Both
+// need a backend supporting L2CAP channels, see ble.L2CAPDialer and
+// ble.L2CAPPublisher, which the linux and darwin ones do.
+package ots
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/leso-kn/ble"
+)
+
+// PSM is the LE protocol/service multiplexer of the object transfer channel.
+const PSM = 0x0025
+
+var (
+	// ServiceUUID is the UUID of the Object Transfer Service.
+	ServiceUUID = ble.UUID16(0x1825)
+
+	FeatureUUID    = ble.UUID16(0x2ABD) // OACP and OLCP features, two uint32.
+	NameUUID       = ble.UUID16(0x2ABE) // Name of the current object.
+	TypeUUID       = ble.UUID16(0x2ABF) // Type of the current object, a UUID.
+	SizeUUID       = ble.UUID16(0x2AC0) // Current and allocated sizes, two uint32.
+	IDUUID         = ble.UUID16(0x2AC3) // ID of the current object, a uint48.
+	PropertiesUUID = ble.UUID16(0x2AC4) // Properties of the current object, a uint32.
+	OACPUUID       = ble.UUID16(0x2AC5) // Object Action Control Point.
+	OLCPUUID       = ble.UUID16(0x2AC6) // Object List Control Point.
+)
+
+// Application errors of the characteristics. [OTS, 3.1]
+const (
+	ErrWriteRejected     ble.ATTError = 0x80
+	ErrObjectNotSelected ble.ATTError = 0x81
+	ErrConcurrencyLimit  ble.ATTError = 0x82
+	ErrObjectNameExists  ble.ATTError = 0x83
+)
+
+// FirstObjectID is the lowest ID of the objects other than the directory
+// listing object, whose ID is 0.
+const FirstObjectID = 0x100
+
+// maxObjectID is the largest ID of an object, a uint48.
+const maxObjectID = 1<<48 - 1
+
+// Properties are the procedures an object permits. [OTS, 3.2.8]
+type Properties uint32
+
+// Object properties.
+const (
+	PropDelete   Properties = 1 << 0
+	PropExecute  Properties = 1 << 1
+	PropRead     Properties = 1 << 2
+	PropWrite    Properties = 1 << 3
+	PropAppend   Properties = 1 << 4
+	PropTruncate Properties = 1 << 5
+	PropPatch    Properties = 1 << 6
+	PropMark     Properties = 1 << 7
+)
+
+// OACPFeatures are the procedures of the Object Action Control Point a
+// server supports. [OTS, 3.1.1]
+type OACPFeatures uint32
+
+// OACP features.
+const (
+	OACPCreate   OACPFeatures = 1 << 0
+	OACPDelete   OACPFeatures = 1 << 1
+	OACPChecksum OACPFeatures = 1 << 2
+	OACPExecute  OACPFeatures = 1 << 3
+	OACPRead     OACPFeatures = 1 << 4
+	OACPWrite    OACPFeatures = 1 << 5
+	OACPAppend   OACPFeatures = 1 << 6
+	OACPTruncate OACPFeatures = 1 << 7
+	OACPPatch    OACPFeatures = 1 << 8
+	OACPAbort    OACPFeatures = 1 << 9
+)
+
+// OLCPFeatures are the procedures of the Object List Control Point a server
+// supports, beyond the mandatory First, Last, Previous and Next.
+type OLCPFeatures uint32
+
+// OLCP features.
+const (
+	OLCPGoTo         OLCPFeatures = 1 << 0
+	OLCPOrder        OLCPFeatures = 1 << 1
+	OLCPNumberOf     OLCPFeatures = 1 << 2
+	OLCPClearMarking OLCPFeatures = 1 << 3
+)
+
+// Features are the features of a server, the value of its OTS Feature
+// characteristic.
+type Features struct {
+	OACP OACPFeatures
+	OLCP OLCPFeatures
+}
+
+func (f Features) marshal() []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b, uint32(f.OACP))
+	binary.LittleEndian.PutUint32(b[4:], uint32(f.OLCP))
+	return b
+}
+
+func (f *Features) unmarshal(b []byte) error {
+	if len(b) < 8 {
+		return errors.New("ots: short feature value")
+	}
+	f.OACP = OACPFeatures(binary.LittleEndian.Uint32(b))
+	f.OLCP = OLCPFeatures(binary.LittleEndian.Uint32(b[4:]))
+	return nil
+}
+
+// Metadata describes an object.
+type Metadata struct {
+	// ID identifies the object, from FirstObjectID up to 2^48-1.
+	ID uint64
+
+	// Name is the name of the object, up to 120 octets of UTF-8.
+	Name string
+
+	// Type is the type of the object, a 16-bit or 128-bit UUID.
+	Type ble.UUID
+
+	// Size is the current size of the object, and AllocatedSize the
+	// number of octets allocated to it, which a write may extend it to.
+	Size          uint32
+	AllocatedSize uint32
+
+	Properties Properties
+}
+
+// Opcodes of the Object Action Control Point. [OTS, 3.3.2]
+const (
+	OpCreate   byte = 0x01 // size uint32, type UUID
+	OpDelete   byte = 0x02
+	OpChecksum byte = 0x03 // offset uint32, length uint32
+	OpExecute  byte = 0x04
+	OpRead     byte = 0x05 // offset uint32, length uint32
+	OpWrite    byte = 0x06 // offset uint32, length uint32, mode uint8
+	OpAbort    byte = 0x07
+
+	opOACPResponse byte = 0x60 // request opcode, result, parameters
+)
+
+// writeModeTruncate truncates the object after the data written.
+const writeModeTruncate = 0x02
+
+// OACPResult is the result of an Object Action Control Point procedure.
+type OACPResult byte
+
+// OACP results.
+const (
+	OACPSuccess              OACPResult = 0x01
+	OACPOpcodeNotSupported   OACPResult = 0x02
+	OACPInvalidParameter     OACPResult = 0x03
+	OACPInsufficientResource OACPResult = 0x04
+	OACPInvalidObject        OACPResult = 0x05
+	OACPChannelUnavailable   OACPResult = 0x06
+	OACPUnsupportedType      OACPResult = 0x07
+	OACPNotPermitted         OACPResult = 0x08
+	OACPObjectLocked         OACPResult = 0x09
+	OACPOperationFailed      OACPResult = 0x0A
+)
+
+var oacpResults = map[OACPResult]string{
+	OACPSuccess:              "success",
+	OACPOpcodeNotSupported:   "opcode not supported",
+	OACPInvalidParameter:     "invalid parameter",
+	OACPInsufficientResource: "insufficient resources",
+	OACPInvalidObject:        "invalid object",
+	OACPChannelUnavailable:   "channel unavailable",
+	OACPUnsupportedType:      "unsupported type",
+	OACPNotPermitted:         "procedure not permitted",
+	OACPObjectLocked:         "object locked",
+	OACPOperationFailed:      "operation failed",
+}
+
+func (r OACPResult) String() string {
+	if s, ok := oacpResults[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("result 0x%02X", byte(r))
+}
+
+// Opcodes of the Object List Control Point. [OTS, 3.4.2]
+const (
+	OpFirst        byte = 0x01
+	OpLast         byte = 0x02
+	OpPrevious     byte = 0x03
+	OpNext         byte = 0x04
+	OpGoTo         byte = 0x05 // id uint48
+	OpOrder        byte = 0x06
+	OpNumberOf     byte = 0x07
+	OpClearMarking byte = 0x08
+
+	opOLCPResponse byte = 0x70 // request opcode, result, parameters
+)
+
+// OLCPResult is the result of an Object List Control Point procedure.
+type OLCPResult byte
+
+// OLCP results.
+const (
+	OLCPSuccess            OLCPResult = 0x01
+	OLCPOpcodeNotSupported OLCPResult = 0x02
+	OLCPInvalidParameter   OLCPResult = 0x03
+	OLCPOperationFailed    OLCPResult = 0x04
+	OLCPOutOfBounds        OLCPResult = 0x05
+	OLCPTooManyObjects     OLCPResult = 0x06
+	OLCPNoObject           OLCPResult = 0x07
+	OLCPIDNotFound         OLCPResult = 0x08
+)
+
+var olcpResults = map[OLCPResult]string{
+	OLCPSuccess:            "success",
+	OLCPOpcodeNotSupported: "opcode not supported",
+	OLCPInvalidParameter:   "invalid parameter",
+	OLCPOperationFailed:    "operation failed",
+	OLCPOutOfBounds:        "out of bounds",
+	OLCPTooManyObjects:     "too many objects",
+	OLCPNoObject:           "no object",
+	OLCPIDNotFound:         "object id not found",
+}
+
+func (r OLCPResult) String() string {
+	if s, ok := olcpResults[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("result 0x%02X", byte(r))
+}
+
+// OACPError is the failure of an Object Action Control Point procedure.
+type OACPError struct {
+	Op     byte
+	Result OACPResult
+}
+
+func (e *OACPError) Error() string {
+	return fmt.Sprintf("ots: action 0x%02X: %s", e.Op, e.Result)
+}
+
+// OLCPError is the failure of an Object List Control Point procedure.
+type OLCPError struct {
+	Op     byte
+	Result OLCPResult
+}
+
+func (e *OLCPError) Error() string {
+	return fmt.Sprintf("ots: list 0x%02X: %s", e.Op, e.Result)
+}
+
+var (
+	// ErrNotFound means the remote device doesn't serve the Object
+	// Transfer Service.
+	ErrNotFound = errors.New("ots: service not found")
+
+	// ErrChecksum means the checksum the server calculated doesn't match
+	// the data.
+	ErrChecksum = errors.New("ots: checksum mismatch")
+
+	// ErrNotSupported is returned by the stores which don't support a
+	// procedure.
+	ErrNotSupported = errors.New("ots: not supported")
+)
+
+func putUint48(b []byte, v uint64) {
+	binary.LittleEndian.PutUint32(b, uint32(v))
+	binary.LittleEndian.PutUint16(b[4:], uint16(v>>32))
+}
+
+func uint48(b []byte) uint64 {
+	return uint64(binary.LittleEndian.Uint32(b)) | uint64(binary.LittleEndian.Uint16(b[4:]))<<32
+}
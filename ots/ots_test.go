@@ -0,0 +1,274 @@
+package ots
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+)
+
+// fakeConn is the connection of a fake client.
+type fakeConn struct {
+	ble.Conn
+	done chan struct{}
+}
+
+func (c *fakeConn) Disconnected() <-chan struct{} { return c.done }
+
+// fakeChannel is an end of a pipe between a fake client and a server.
+type fakeChannel struct {
+	p net.Conn
+	c ble.Conn
+}
+
+func (ch *fakeChannel) Read(b []byte) (int, error)  { return ch.p.Read(b) }
+func (ch *fakeChannel) Write(b []byte) (int, error) { return ch.p.Write(b) }
+func (ch *fakeChannel) Close() error                { return ch.p.Close() }
+
+func (ch *fakeChannel) PSM() uint16    { return PSM }
+func (ch *fakeChannel) MTU() int       { return 64 }
+func (ch *fakeChannel) Conn() ble.Conn { return ch.c }
+
+// fakeListener accepts the channels opened by fake clients.
+type fakeListener struct {
+	chs  chan ble.L2CAPChannel
+	done chan struct{}
+}
+
+func (l *fakeListener) Accept() (ble.L2CAPChannel, error) {
+	select {
+	case ch := <-l.chs:
+		return ch, nil
+	case <-l.done:
+		return nil, errors.New("closed")
+	}
+}
+
+func (l *fakeListener) PSM() uint16  { return PSM }
+func (l *fakeListener) Close() error { close(l.done); return nil }
+
+// fakeClient calls the handlers of the service of a server directly.
+type fakeClient struct {
+	ble.Client
+	conn *fakeConn
+	srv  *Server
+	l    *fakeListener
+	subs map[*ble.Characteristic]ble.Notifier
+}
+
+func (c *fakeClient) Profile() *ble.Profile {
+	return &ble.Profile{Services: []*ble.Service{c.srv.Service()}}
+}
+
+func (c *fakeClient) Disconnected() <-chan struct{} { return c.conn.done }
+
+func (c *fakeClient) ReadCharacteristic(ch *ble.Characteristic) ([]byte, error) {
+	if ch.Value != nil {
+		return ch.Value, nil
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 512))
+	rsp := ble.NewResponseWriter(buf)
+	ch.ReadHandler.ServeRead(ble.NewRequest(c.conn, nil, 0), rsp)
+	if rsp.Status() != ble.ErrSuccess {
+		return nil, rsp.Status()
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *fakeClient) ReadLongCharacteristic(ch *ble.Characteristic) ([]byte, error) {
+	return c.ReadCharacteristic(ch)
+}
+
+func (c *fakeClient) WriteCharacteristic(ch *ble.Characteristic, v []byte, noRsp bool) error {
+	rsp := ble.NewResponseWriter(nil)
+	ch.WriteHandler.ServeWrite(ble.NewRequest(c.conn, v, 0), rsp)
+	if rsp.Status() != ble.ErrSuccess {
+		return rsp.Status()
+	}
+	return nil
+}
+
+func (c *fakeClient) Subscribe(ch *ble.Characteristic, ind bool, h ble.NotificationHandler) error {
+	n := ble.NewNotifier(func(b []byte) (int, error) {
+		h(0, b)
+		return len(b), nil
+	})
+	c.subs[ch] = n
+	go ch.IndicateHandler.ServeNotify(ble.NewRequest(c.conn, nil, 0), n)
+	// Wait for the server to take the notifier, as the CCCD write would.
+	for {
+		ss := c.srv.session(c.conn)
+		c.srv.mu.Lock()
+		ok := c.srv.indicator(ss, ch) == n
+		c.srv.mu.Unlock()
+		if ok {
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (c *fakeClient) Unsubscribe(ch *ble.Characteristic, ind bool) error {
+	c.subs[ch].Close()
+	return nil
+}
+
+func (c *fakeClient) OpenL2CAPChannel(psm uint16) (ble.L2CAPChannel, error) {
+	a, b := net.Pipe()
+	sch := &fakeChannel{p: b, c: c.conn}
+	c.l.chs <- sch
+	// Wait for the server to bind the channel, as it would accept it.
+	for {
+		ss := c.srv.session(c.conn)
+		c.srv.mu.Lock()
+		ok := ss.ch == ble.L2CAPChannel(sch)
+		c.srv.mu.Unlock()
+		if ok {
+			return &fakeChannel{p: a, c: c.conn}, nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	fwType := ble.UUID16(0x2AA6)
+	logType := ble.MustParse("6e7a0100-5c2d-4f0b-9d3e-8b1f2a6c4d10")
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	store := NewMemStore(PropRead|PropWrite|PropDelete, fwType)
+	fw := store.Add("firmware", fwType, data, PropRead|PropWrite|PropTruncate)
+	store.Add("log", logType, []byte("boot\n"), PropRead)
+
+	srv := NewServer(Config{Store: store, Create: true, Delete: true})
+	l := &fakeListener{chs: make(chan ble.L2CAPChannel), done: make(chan struct{})}
+	go srv.Serve(l)
+	defer l.Close()
+	fc := &fakeClient{conn: &fakeConn{done: make(chan struct{})}, srv: srv, l: l, subs: make(map[*ble.Characteristic]ble.Notifier)}
+	defer close(fc.conn.done)
+
+	c, err := NewClient(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if f, err := c.Features(); err != nil || f != srv.Features() || f.OACP&OACPCreate == 0 {
+		t.Fatalf("features %+v, %v", f, err)
+	}
+	if _, err := c.Metadata(); err != ErrObjectNotSelected {
+		t.Fatalf("metadata without an object: %v", err)
+	}
+	if n, err := c.Count(); n != 2 || err != nil {
+		t.Fatalf("count %d, %v", n, err)
+	}
+
+	// Browse the list.
+	if err := c.First(); err != nil {
+		t.Fatal(err)
+	}
+	md, err := c.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Metadata{ID: FirstObjectID, Name: "firmware", Type: fwType, Size: 1000, AllocatedSize: 1000, Properties: PropRead | PropWrite | PropTruncate}
+	if md.ID != want.ID || md.Name != want.Name || !md.Type.Equal(want.Type) || md.Size != want.Size ||
+		md.AllocatedSize != want.AllocatedSize || md.Properties != want.Properties {
+		t.Fatalf("metadata %+v, want %+v", md, want)
+	}
+	if err := c.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if md, _ := c.Metadata(); md.Name != "log" || !md.Type.Equal(logType) {
+		t.Fatalf("next object %+v", md)
+	}
+	var le *OLCPError
+	if err := c.Next(); !errors.As(err, &le) || le.Result != OLCPOutOfBounds {
+		t.Fatalf("next past the end: %v", err)
+	}
+	if err := c.GoTo(0x1234); !errors.As(err, &le) || le.Result != OLCPIDNotFound {
+		t.Fatalf("go to an unknown object: %v", err)
+	}
+
+	// Read and verify the firmware.
+	if err := c.GoTo(FirstObjectID); err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.Read(100, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data[100:600]) {
+		t.Fatal("read data differs")
+	}
+	if err := c.Verify(100, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Verify(0, b); err != ErrChecksum {
+		t.Fatalf("verify of other data: %v", err)
+	}
+	var ae *OACPError
+	if _, err := c.Read(900, 200); !errors.As(err, &ae) || ae.Result != OACPInvalidParameter {
+		t.Fatalf("read past the end: %v", err)
+	}
+
+	// Overwrite it with a smaller image.
+	img := bytes.Repeat([]byte{0xA5}, 300)
+	if err := c.Write(0, img, true); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return bytes.Equal(fw.Bytes(), img) })
+	if b, err := c.Read(0, 300); err != nil || !bytes.Equal(b, img) {
+		t.Fatalf("read back %v", err)
+	}
+
+	// The log is read-only, and can't be deleted.
+	if err := c.Last(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Write(0, []byte("x"), false); !errors.As(err, &ae) || ae.Result != OACPNotPermitted {
+		t.Fatalf("write of a read-only object: %v", err)
+	}
+	if err := c.Delete(); !errors.As(err, &ae) || ae.Result != OACPNotPermitted {
+		t.Fatalf("delete of the log: %v", err)
+	}
+
+	// Create, fill and delete an object.
+	if err := c.Create(16, logType); !errors.As(err, &ae) || ae.Result != OACPUnsupportedType {
+		t.Fatalf("create of an unsupported type: %v", err)
+	}
+	if err := c.Create(16, fwType); err != nil {
+		t.Fatal(err)
+	}
+	if md, _ := c.Metadata(); md.Size != 0 || md.AllocatedSize != 16 || md.ID != FirstObjectID+2 {
+		t.Fatalf("created object %+v", md)
+	}
+	if err := c.Write(0, []byte("0123456789abcdef"), false); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { md, _ := c.Metadata(); return md.Size == 16 })
+	if err := c.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Count(); n != 2 {
+		t.Fatalf("%d objects after the delete", n)
+	}
+	if _, err := c.Metadata(); err != ErrObjectNotSelected {
+		t.Fatalf("metadata of the deleted object: %v", err)
+	}
+}
+
+func waitFor(t *testing.T, f func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if f() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out")
+}
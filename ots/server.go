@@ -0,0 +1,513 @@
+package ots
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"sync"
+
+	"github.com/leso-kn/ble"
+)
+
+// Config configures a Server.
+type Config struct {
+	// Store holds the objects served.
+	Store Store
+
+	// Create and Delete let the clients create objects in the store, and
+	// delete those with the PropDelete property.
+	Create bool
+	Delete bool
+}
+
+// Server serves the objects of a store to the clients, each of which
+// selects its current object, and transfers it over an L2CAP channel it
+// opened to PSM. Objects are compared with ==, and must be comparable.
+type Server struct {
+	cfg  Config
+	svc  *ble.Service
+	oacp *ble.Characteristic
+	olcp *ble.Characteristic
+
+	mu       sync.Mutex
+	sessions map[ble.Conn]*session
+	locked   map[Object]bool // Objects being transferred.
+}
+
+// session is the state of a client.
+type session struct {
+	cur Object
+
+	// oacp and olcp indicate the responses of the control points, while
+	// the client is subscribed to them.
+	oacp ble.Notifier
+	olcp ble.Notifier
+
+	ch    ble.L2CAPChannel
+	abort chan struct{} // Closed to abort the ongoing read.
+}
+
+// NewServer returns a server of the objects of cfg.Store.
+func NewServer(cfg Config) *Server {
+	s := &Server{
+		cfg:      cfg,
+		sessions: make(map[ble.Conn]*session),
+		locked:   make(map[Object]bool),
+	}
+	s.svc = ble.NewService(ServiceUUID)
+	s.svc.NewCharacteristic(FeatureUUID).SetValue(s.Features().marshal())
+	s.svc.NewCharacteristic(NameUUID).HandleRead(s.read(func(md Metadata) []byte {
+		return []byte(md.Name)
+	}))
+	s.svc.NewCharacteristic(TypeUUID).HandleRead(s.read(func(md Metadata) []byte {
+		return md.Type
+	}))
+	s.svc.NewCharacteristic(SizeUUID).HandleRead(s.read(func(md Metadata) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint32(b, md.Size)
+		binary.LittleEndian.PutUint32(b[4:], md.AllocatedSize)
+		return b
+	}))
+	s.svc.NewCharacteristic(IDUUID).HandleRead(s.read(func(md Metadata) []byte {
+		b := make([]byte, 6)
+		putUint48(b, md.ID)
+		return b
+	}))
+	s.svc.NewCharacteristic(PropertiesUUID).HandleRead(s.read(func(md Metadata) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(md.Properties))
+		return b
+	}))
+	s.oacp = s.controlPoint(OACPUUID, opOACPResponse, s.action)
+	s.olcp = s.controlPoint(OLCPUUID, opOLCPResponse, s.list)
+	return s
+}
+
+// Service returns the Object Transfer Service, to add to a device.
+func (s *Server) Service() *ble.Service { return s.svc }
+
+// Features returns the features of s.
+func (s *Server) Features() Features {
+	f := Features{
+		OACP: OACPChecksum | OACPRead | OACPWrite | OACPAppend | OACPTruncate | OACPAbort,
+		OLCP: OLCPGoTo | OLCPNumberOf,
+	}
+	if s.cfg.Create {
+		f.OACP |= OACPCreate
+	}
+	if s.cfg.Delete {
+		f.OACP |= OACPDelete
+	}
+	return f
+}
+
+// Serve binds the channels accepted by l, which listens on PSM, to the
+// clients which opened them, for their transfers. It returns the error of
+// Accept, once l is closed.
+func (s *Server) Serve(l ble.L2CAPListener) error {
+	for {
+		ch, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		ss := s.session(ch.Conn())
+		s.mu.Lock()
+		old := ss.ch
+		ss.ch = ch
+		s.mu.Unlock()
+		if old != nil {
+			old.Close()
+		}
+	}
+}
+
+// session returns the session of the client of c, which ends once it
+// disconnects.
+func (s *Server) session(c ble.Conn) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.sessions[c]
+	if !ok {
+		ss = &session{}
+		s.sessions[c] = ss
+		go func() {
+			<-c.Disconnected()
+			s.mu.Lock()
+			delete(s.sessions, c)
+			s.mu.Unlock()
+		}()
+	}
+	return ss
+}
+
+// read returns a read handler of the value f returns for the metadata of
+// the current object.
+func (s *Server) read(f func(md Metadata) []byte) ble.ReadHandler {
+	return ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		ss := s.session(req.Conn())
+		s.mu.Lock()
+		o := ss.cur
+		s.mu.Unlock()
+		if o == nil {
+			rsp.SetStatus(ErrObjectNotSelected)
+			return
+		}
+		v := f(o.Metadata())
+		if req.Offset() < len(v) {
+			rsp.Write(v[req.Offset():])
+		}
+	})
+}
+
+// procedure runs the procedure op of a control point, with the parameters
+// p, and returns its result, the parameters of the response, and what to
+// do once the response is indicated, or failed to be, if anything.
+type procedure func(ss *session, op byte, p []byte) (result byte, params []byte, then func(err error))
+
+// controlPoint adds a control point, whose procedures are run by f, and
+// whose responses are indicated with the opcode rspOp.
+func (s *Server) controlPoint(u ble.UUID, rspOp byte, f procedure) *ble.Characteristic {
+	c := s.svc.NewCharacteristic(u)
+	c.HandleIndicate(ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {
+		ss := s.session(req.Conn())
+		s.mu.Lock()
+		s.setIndicator(ss, c, n)
+		s.mu.Unlock()
+		<-n.Context().Done()
+		s.mu.Lock()
+		if s.indicator(ss, c) == n {
+			s.setIndicator(ss, c, nil)
+		}
+		s.mu.Unlock()
+	}))
+	c.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		b := req.Data()
+		if len(b) == 0 {
+			rsp.SetStatus(ble.ErrInvalAttrValueLen)
+			return
+		}
+		ss := s.session(req.Conn())
+		s.mu.Lock()
+		ind := s.indicator(ss, c)
+		s.mu.Unlock()
+		if ind == nil {
+			rsp.SetStatus(ble.ErrCCCDImproperConf)
+			return
+		}
+		result, params, then := f(ss, b[0], b[1:])
+		v := append([]byte{rspOp, b[0], result}, params...)
+		// The response is indicated by another goroutine, as the
+		// indication waits for the confirmation.
+		go func() {
+			_, err := ind.Write(v)
+			if then != nil {
+				then(err)
+			}
+		}()
+	}))
+	// The control points are written, not read.
+	c.Property &^= ble.CharWriteNR
+	return c
+}
+
+func (s *Server) indicator(ss *session, c *ble.Characteristic) ble.Notifier {
+	if c == s.oacp {
+		return ss.oacp
+	}
+	return ss.olcp
+}
+
+func (s *Server) setIndicator(ss *session, c *ble.Characteristic, n ble.Notifier) {
+	if c == s.oacp {
+		ss.oacp = n
+		return
+	}
+	ss.olcp = n
+}
+
+// action runs a procedure of the Object Action Control Point.
+func (s *Server) action(ss *session, op byte, p []byte) (byte, []byte, func(error)) {
+	res, params, then := s.oacpProcedure(ss, op, p)
+	return byte(res), params, then
+}
+
+func (s *Server) oacpProcedure(ss *session, op byte, p []byte) (OACPResult, []byte, func(error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if op == OpCreate {
+		return s.create(ss, p)
+	}
+	if op == OpAbort {
+		if ss.abort != nil {
+			close(ss.abort)
+			ss.abort = nil
+		}
+		return OACPSuccess, nil, nil
+	}
+	o := ss.cur
+	switch {
+	case op != OpDelete && op != OpChecksum && op != OpRead && op != OpWrite:
+		return OACPOpcodeNotSupported, nil, nil
+	case op == OpDelete && !s.cfg.Delete:
+		return OACPOpcodeNotSupported, nil, nil
+	case o == nil:
+		return OACPInvalidObject, nil, nil
+	}
+	md := o.Metadata()
+
+	if op == OpDelete {
+		switch {
+		case md.Properties&PropDelete == 0:
+			return OACPNotPermitted, nil, nil
+		case s.locked[o]:
+			return OACPObjectLocked, nil, nil
+		}
+		if err := s.cfg.Store.Delete(o); err != nil {
+			return OACPOperationFailed, nil, nil
+		}
+		for _, other := range s.sessions {
+			if other.cur == o {
+				other.cur = nil
+			}
+		}
+		return OACPSuccess, nil, nil
+	}
+
+	if len(p) < 8 || (op == OpWrite) != (len(p) == 9) || len(p) > 9 {
+		return OACPInvalidParameter, nil, nil
+	}
+	off := binary.LittleEndian.Uint32(p)
+	n := binary.LittleEndian.Uint32(p[4:])
+	end := uint64(off) + uint64(n)
+
+	switch op {
+	case OpChecksum:
+		if end > uint64(md.Size) {
+			return OACPInvalidParameter, nil, nil
+		}
+		sum, err := checksum(o, off, n)
+		if err != nil {
+			return OACPOperationFailed, nil, nil
+		}
+		params := make([]byte, 4)
+		binary.LittleEndian.PutUint32(params, sum)
+		return OACPSuccess, params, nil
+
+	case OpRead:
+		switch {
+		case md.Properties&PropRead == 0:
+			return OACPNotPermitted, nil, nil
+		case end > uint64(md.Size):
+			return OACPInvalidParameter, nil, nil
+		case ss.ch == nil:
+			return OACPChannelUnavailable, nil, nil
+		case s.locked[o]:
+			return OACPObjectLocked, nil, nil
+		}
+		s.locked[o] = true
+		ss.abort = make(chan struct{})
+		ch, abort := ss.ch, ss.abort
+		return OACPSuccess, nil, func(err error) { s.send(ss, o, ch, off, n, abort, err) }
+
+	default:
+		truncate := p[8]&writeModeTruncate != 0
+		switch {
+		case md.Properties&PropWrite == 0:
+			return OACPNotPermitted, nil, nil
+		case truncate && md.Properties&PropTruncate == 0:
+			return OACPNotPermitted, nil, nil
+		case off > md.Size:
+			return OACPInvalidParameter, nil, nil
+		case end > uint64(md.AllocatedSize) && md.Properties&PropAppend == 0:
+			return OACPInvalidParameter, nil, nil
+		case ss.ch == nil:
+			return OACPChannelUnavailable, nil, nil
+		case s.locked[o]:
+			return OACPObjectLocked, nil, nil
+		}
+		s.locked[o] = true
+		ch := ss.ch
+		return OACPSuccess, nil, func(err error) { s.receive(o, ch, off, n, truncate, err) }
+	}
+}
+
+// create creates an object with the parameters p, the allocated size and
+// the type, and makes it the current object. Must be called with s.mu held.
+func (s *Server) create(ss *session, p []byte) (OACPResult, []byte, func(error)) {
+	switch {
+	case !s.cfg.Create:
+		return OACPOpcodeNotSupported, nil, nil
+	case len(p) != 4+2 && len(p) != 4+16:
+		return OACPInvalidParameter, nil, nil
+	}
+	o, err := s.cfg.Store.Create(binary.LittleEndian.Uint32(p), ble.UUID(append([]byte{}, p[4:]...)))
+	switch {
+	case err == ErrNotSupported:
+		return OACPUnsupportedType, nil, nil
+	case err != nil:
+		return OACPInsufficientResource, nil, nil
+	}
+	ss.cur = o
+	return OACPSuccess, nil, nil
+}
+
+// send sends n octets of o at off over ch, until abort is closed, unless
+// the response of the read failed to be indicated with err.
+func (s *Server) send(ss *session, o Object, ch ble.L2CAPChannel, off, n uint32, abort chan struct{}, err error) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.locked, o)
+		if ss.abort == abort {
+			ss.abort = nil
+		}
+		s.mu.Unlock()
+	}()
+	if err != nil {
+		return
+	}
+	buf := make([]byte, ch.MTU())
+	if len(buf) == 0 {
+		buf = make([]byte, ble.DefaultMTU)
+	}
+	for n > 0 {
+		select {
+		case <-abort:
+			return
+		default:
+		}
+		m := uint32(len(buf))
+		if m > n {
+			m = n
+		}
+		k, err := o.ReadAt(buf[:m], int64(off))
+		if k == 0 && err != nil {
+			ch.Close()
+			return
+		}
+		if _, err := ch.Write(buf[:k]); err != nil {
+			return
+		}
+		off += uint32(k)
+		n -= uint32(k)
+	}
+}
+
+// receive writes n octets received over ch to o at off, and truncates o
+// after them if truncate is set, unless the response of the write failed to
+// be indicated with err.
+func (s *Server) receive(o Object, ch ble.L2CAPChannel, off, n uint32, truncate bool, err error) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.locked, o)
+		s.mu.Unlock()
+	}()
+	if err != nil {
+		return
+	}
+	// Channels return a whole SDU per read, which must fit.
+	buf := make([]byte, 1<<16)
+	for n > 0 {
+		k, err := ch.Read(buf)
+		if err != nil {
+			return
+		}
+		if uint32(k) > n {
+			k = int(n)
+		}
+		if _, err := o.WriteAt(buf[:k], int64(off)); err != nil {
+			ch.Close()
+			return
+		}
+		off += uint32(k)
+		n -= uint32(k)
+	}
+	if truncate {
+		o.Truncate(off)
+	}
+}
+
+// checksum returns the CRC-32 of n octets of o at off. [OTS, 3.3.2.4]
+func checksum(o Object, off, n uint32) (uint32, error) {
+	h := crc32.NewIEEE()
+	buf := make([]byte, 4096)
+	for n > 0 {
+		m := uint32(len(buf))
+		if m > n {
+			m = n
+		}
+		k, err := o.ReadAt(buf[:m], int64(off))
+		if k == 0 && err != nil {
+			return 0, err
+		}
+		h.Write(buf[:k])
+		off += uint32(k)
+		n -= uint32(k)
+	}
+	return h.Sum32(), nil
+}
+
+// list runs a procedure of the Object List Control Point.
+func (s *Server) list(ss *session, op byte, p []byte) (byte, []byte, func(error)) {
+	res, params := s.olcpProcedure(ss, op, p)
+	return byte(res), params, nil
+}
+
+func (s *Server) olcpProcedure(ss *session, op byte, p []byte) (OLCPResult, []byte) {
+	objs := s.cfg.Store.Objects()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur := -1
+	for i, o := range objs {
+		if o == ss.cur {
+			cur = i
+		}
+	}
+
+	switch op {
+	case OpNumberOf:
+		params := make([]byte, 4)
+		binary.LittleEndian.PutUint32(params, uint32(len(objs)))
+		return OLCPSuccess, params
+	case OpFirst, OpLast, OpPrevious, OpNext, OpGoTo:
+	default:
+		return OLCPOpcodeNotSupported, nil
+	}
+	if op == OpGoTo && len(p) != 6 {
+		return OLCPInvalidParameter, nil
+	}
+	if len(objs) == 0 {
+		return OLCPNoObject, nil
+	}
+
+	i := cur
+	switch op {
+	case OpFirst:
+		i = 0
+	case OpLast:
+		i = len(objs) - 1
+	case OpPrevious, OpNext:
+		if cur < 0 {
+			return OLCPOperationFailed, nil
+		}
+		if op == OpPrevious {
+			i--
+		} else {
+			i++
+		}
+		if i < 0 || i >= len(objs) {
+			return OLCPOutOfBounds, nil
+		}
+	case OpGoTo:
+		id := uint48(p)
+		i = -1
+		for j, o := range objs {
+			if o.Metadata().ID == id {
+				i = j
+			}
+		}
+		if i < 0 {
+			return OLCPIDNotFound, nil
+		}
+	}
+	ss.cur = objs[i]
+	return OLCPSuccess, nil
+}
@@ -0,0 +1,179 @@
+package ots
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/leso-kn/ble"
+)
+
+// Object is an object of a Store. Its contents are read and written at
+// offsets within its size, and a write may extend it up to its allocated
+// size.
+type Object interface {
+	io.ReaderAt
+	io.WriterAt
+
+	// Metadata returns the current metadata of the object.
+	Metadata() Metadata
+
+	// Truncate changes the size of the object to size, at most its current
+	// size.
+	Truncate(size uint32) error
+}
+
+// Store holds the objects a Server serves.
+type Store interface {
+	// Objects returns the objects, in the order of the list the clients
+	// browse.
+	Objects() []Object
+
+	// Create creates an empty object of the type typ, with size octets
+	// allocated to it. It returns ErrNotSupported if the store doesn't
+	// create objects of the type.
+	Create(size uint32, typ ble.UUID) (Object, error)
+
+	// Delete deletes the object o.
+	Delete(o Object) error
+}
+
+// MemStore is a Store of objects held in memory.
+type MemStore struct {
+	mu      sync.Mutex
+	objs    []*MemObject
+	nextID  uint64
+	types   []ble.UUID
+	created Properties
+}
+
+// NewMemStore returns an empty store, whose clients may create objects of
+// the types types, with the properties props, if any.
+func NewMemStore(props Properties, types ...ble.UUID) *MemStore {
+	return &MemStore{nextID: FirstObjectID, types: types, created: props}
+}
+
+// Add adds an object with the name, the type typ, the contents data and
+// the properties props, and returns it.
+func (s *MemStore) Add(name string, typ ble.UUID, data []byte, props Properties) *MemObject {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := &MemObject{md: Metadata{
+		ID:            s.nextID,
+		Name:          name,
+		Type:          typ,
+		Size:          uint32(len(data)),
+		AllocatedSize: uint32(len(data)),
+		Properties:    props,
+	}, data: append([]byte{}, data...)}
+	s.nextID++
+	s.objs = append(s.objs, o)
+	return o
+}
+
+// Objects returns the objects, in the order they were added.
+func (s *MemStore) Objects() []Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objs := make([]Object, len(s.objs))
+	for i, o := range s.objs {
+		objs[i] = o
+	}
+	return objs
+}
+
+// Create adds an empty object of the type typ, if the store was set up to
+// create objects of the type.
+func (s *MemStore) Create(size uint32, typ ble.UUID) (Object, error) {
+	for _, t := range s.types {
+		if t.Equal(typ) {
+			o := s.Add("", typ, nil, s.created)
+			o.mu.Lock()
+			o.md.AllocatedSize = size
+			o.mu.Unlock()
+			return o, nil
+		}
+	}
+	return nil, ErrNotSupported
+}
+
+// Delete removes the object o.
+func (s *MemStore) Delete(o Object) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, mo := range s.objs {
+		if mo == o {
+			s.objs = append(s.objs[:i], s.objs[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("ots: no such object")
+}
+
+// MemObject is an object of a MemStore.
+type MemObject struct {
+	mu   sync.Mutex
+	md   Metadata
+	data []byte
+}
+
+// Metadata returns the metadata of o.
+func (o *MemObject) Metadata() Metadata {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.md
+}
+
+// Bytes returns a copy of the contents of o.
+func (o *MemObject) Bytes() []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]byte{}, o.data...)
+}
+
+// ReadAt reads the contents of o at the offset off.
+func (o *MemObject) ReadAt(b []byte, off int64) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if off >= int64(len(o.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, o.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes b at the offset off, within or at the end of the contents
+// of o, extending its size, and its allocated size, if needed.
+func (o *MemObject) WriteAt(b []byte, off int64) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if off > int64(len(o.data)) {
+		return 0, errors.New("ots: write past the end of the object")
+	}
+	end := off + int64(len(b))
+	if end > int64(o.md.AllocatedSize) {
+		o.md.AllocatedSize = uint32(end)
+	}
+	if end > int64(len(o.data)) {
+		o.data = append(o.data[:off], b...)
+	} else {
+		copy(o.data[off:], b)
+	}
+	o.md.Size = uint32(len(o.data))
+	return len(b), nil
+}
+
+// Truncate truncates o to size.
+func (o *MemObject) Truncate(size uint32) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if size > uint32(len(o.data)) {
+		return errors.New("ots: truncate past the end of the object")
+	}
+	o.data = o.data[:size]
+	o.md.Size = size
+	return nil
+}
@@ -0,0 +1,154 @@
+package parser
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/leso-kn/ble"
+)
+
+// encodeOrder is the order in which Encode emits the fields of byte values.
+var encodeOrder = []struct {
+	key string
+	typ byte
+}{
+	{keys.flags, types.flags},
+	{keys.localName, types.namecomp},
+	{keys.txpwr, types.txpwr},
+	{keys.appearance, types.appearance},
+	{keys.advint, types.advint},
+	{keys.uri, types.uri},
+	{keys.lefeatures, types.lefeatures},
+	{keys.pubtarget, types.pubtarget},
+	{keys.randtarget, types.randtarget},
+	{keys.mfgdata, types.mfgdata},
+}
+
+// Encode encodes a map, as returned by Parse, back into AD structures, such
+// that parsing them returns an equal map. Service UUIDs are encoded as
+// complete lists, and names as complete names, as the map doesn't tell
+// otherwise. The result may exceed the length of a single advertising
+// packet.
+func Encode(m map[string]interface{}) ([]byte, error) {
+	var b []byte
+	add := func(typ byte, data []byte) error {
+		if len(data)+1 > 0xFF {
+			return fmt.Errorf("adv type %v: length %v", typ, len(data))
+		}
+		b = append(b, byte(len(data)+1), typ)
+		b = append(b, data...)
+		return nil
+	}
+
+	for _, f := range encodeOrder {
+		v, ok := m[f.key]
+		if !ok {
+			continue
+		}
+		var data []byte
+		switch v := v.(type) {
+		case []byte:
+			data = v
+		case string:
+			data = []byte(v)
+		default:
+			return nil, fmt.Errorf("%v: unexpected value type %T", f.key, v)
+		}
+		if err := add(f.typ, data); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, l := range []struct {
+		key  string
+		t16  byte
+		t32  byte
+		t128 byte
+	}{
+		{keys.services, types.uuid16comp, types.uuid32comp, types.uuid128comp},
+		{keys.solicited, types.sol16, types.sol32, types.sol128},
+	} {
+		v, ok := m[l.key]
+		if !ok {
+			continue
+		}
+		uuids, ok := v.([]ble.UUID)
+		if !ok {
+			return nil, fmt.Errorf("%v: unexpected value type %T", l.key, v)
+		}
+		// Runs of same sized UUIDs make a list each, which keeps their order.
+		typs := map[int]byte{2: l.t16, 4: l.t32, 16: l.t128}
+		for i := 0; i < len(uuids); {
+			typ, ok := typs[len(uuids[i])]
+			if !ok {
+				return nil, fmt.Errorf("%v: uuid %v: invalid length", l.key, uuids[i])
+			}
+			var d []byte
+			j := i
+			for ; j < len(uuids) && len(uuids[j]) == len(uuids[i]); j++ {
+				d = append(d, uuids[j]...)
+			}
+			if err := add(typ, d); err != nil {
+				return nil, err
+			}
+			i = j
+		}
+	}
+
+	if v, ok := m[keys.serviceData]; ok {
+		msd, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%v: unexpected value type %T", keys.serviceData, v)
+		}
+		var sus []string
+		for su := range msd {
+			sus = append(sus, su)
+		}
+		sort.Strings(sus)
+		for _, su := range sus {
+			u, err := hex.DecodeString(su)
+			if err != nil {
+				return nil, fmt.Errorf("%v: uuid %v: %w", keys.serviceData, su, err)
+			}
+			typ := map[int]byte{2: types.svc16, 4: types.svc32, 16: types.svc128}[len(u)]
+			if typ == 0 {
+				return nil, fmt.Errorf("%v: uuid %v: invalid length", keys.serviceData, su)
+			}
+			arr, _ := msd[su].([]interface{})
+			for _, sd := range arr {
+				d, ok := sd.([]byte)
+				if !ok {
+					return nil, fmt.Errorf("%v: unexpected data type %T", keys.serviceData, sd)
+				}
+				if err := add(typ, append(ble.Reverse(u), d...)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	for _, f := range []struct {
+		key string
+		typ byte
+	}{
+		{keys.pbadv, types.pbadv},
+		{keys.meshmsg, types.meshmsg},
+		{keys.meshbeacon, types.meshbeacon},
+	} {
+		v, ok := m[f.key]
+		if !ok {
+			continue
+		}
+		pdus, ok := v.([][]byte)
+		if !ok {
+			return nil, fmt.Errorf("%v: unexpected value type %T", f.key, v)
+		}
+		for _, pdu := range pdus {
+			if err := add(f.typ, pdu); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
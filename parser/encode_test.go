@@ -0,0 +1,60 @@
+package parser
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	p := testPdu{}
+	p.add(types.flags, []byte{0x06})
+	p.add(types.uuid16inc, []byte{0x0D, 0x18})
+	p.add(types.uuid128comp, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15})
+	p.add(types.uuid16comp, []byte{0x0F, 0x18})
+	p.add(types.sol32, []byte{1, 2, 3, 4})
+	p.add(types.nameshort, []byte("name"))
+	p.add(types.txpwr, []byte{0xF4})
+	p.add(types.svc16, []byte{0x0D, 0x18, 1, 2})
+	p.add(types.svc16, []byte{0x0D, 0x18, 3})
+	p.add(types.svc32, []byte{1, 2, 3, 4, 5})
+	p.add(types.appearance, []byte{0x40, 0x03})
+	p.add(types.meshmsg, []byte{1, 2})
+	p.add(types.meshmsg, []byte{3})
+	p.add(types.mfgdata, []byte{0x4C, 0x00, 1, 2, 3})
+	p.add(0x2C, []byte{1}) // not decoded, dropped
+
+	m, err := Parse(p.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, m2) {
+		t.Fatalf("decode->encode->decode:\nhave %v\nwant %v", m2, m)
+	}
+
+	// encoding is stable from then on
+	b2, err := Encode(m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, b2) {
+		t.Fatalf("encode not stable:\nhave % X\nwant % X", b2, b)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	if _, err := Encode(map[string]interface{}{keys.flags: 6}); err == nil {
+		t.Fatal("no error on unexpected value type")
+	}
+	if _, err := Encode(map[string]interface{}{keys.mfgdata: make([]byte, 255)}); err == nil {
+		t.Fatal("no error on oversized field")
+	}
+}
@@ -0,0 +1,83 @@
+package parser
+
+import "fmt"
+
+// Iterator iterates over the raw AD structures of one or more advertising
+// data buffers, such as the advertising data and the scan response of an
+// advertisement. It yields every structure, including the types Parse
+// doesn't decode.
+//
+//	it := parser.NewIterator(a.Data(), a.SrData())
+//	for it.Next() {
+//		fmt.Println(it.Type(), it.Payload())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	bufs [][]byte
+	buf  int
+	off  int
+
+	typ     byte
+	payload []byte
+	err     error
+}
+
+// NewIterator returns an iterator over the AD structures of bufs, in order.
+func NewIterator(bufs ...[]byte) *Iterator {
+	return &Iterator{bufs: bufs}
+}
+
+// Next advances to the next AD structure. It returns false when there are
+// no more structures, or a malformed one was met; Err tells these apart.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for it.buf < len(it.bufs) {
+		b := it.bufs[it.buf]
+
+		// A zero length ends the significant part of the data, the rest is
+		// padding. [Vol 3, Part C, 11]
+		if it.off >= len(b) || b[it.off] == 0 {
+			it.buf++
+			it.off = 0
+			continue
+		}
+
+		length := int(b[it.off])
+		if it.off+length >= len(b) {
+			it.err = fmt.Errorf("buffer overflow: want %v, have %v, buf %v, idx %v", it.off+length, len(b), it.buf, it.off)
+			return false
+		}
+		it.typ = b[it.off+1]
+		it.payload = b[it.off+2 : it.off+1+length]
+		it.off += length + 1
+		return true
+	}
+	return false
+}
+
+// Type returns the AD type of the current structure.
+func (it *Iterator) Type() byte {
+	return it.typ
+}
+
+// Payload returns the data of the current structure, without the length and
+// type. It refers to the iterated buffer, and must be copied to be retained
+// after it changes.
+func (it *Iterator) Payload() []byte {
+	return it.payload
+}
+
+// Buffer returns the index of the buffer of the current structure in the
+// buffers passed to NewIterator.
+func (it *Iterator) Buffer() int {
+	return it.buf
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIterator(t *testing.T) {
+	ad := testPdu{}
+	ad.add(types.flags, []byte{0x06})
+	ad.add(0x2a, []byte{1, 2, 3}) // not in the decode map
+	ad.b = append(ad.b, 0, 0, 0)  // padding
+
+	sr := testPdu{}
+	sr.add(types.namecomp, []byte("name"))
+
+	type rec struct {
+		buf     int
+		typ     byte
+		payload []byte
+	}
+	exp := []rec{
+		{0, types.flags, []byte{0x06}},
+		{0, 0x2a, []byte{1, 2, 3}},
+		{2, types.namecomp, []byte("name")},
+	}
+
+	it := NewIterator(ad.bytes(), nil, sr.bytes())
+	var have []rec
+	for it.Next() {
+		have = append(have, rec{it.Buffer(), it.Type(), it.Payload()})
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(have) != len(exp) {
+		t.Fatalf("have %v structures, want %v", len(have), len(exp))
+	}
+	for i := range exp {
+		if have[i].buf != exp[i].buf || have[i].typ != exp[i].typ || !bytes.Equal(have[i].payload, exp[i].payload) {
+			t.Fatalf("structure %v: have %v, want %v", i, have[i], exp[i])
+		}
+	}
+
+	// overflow stops the iteration with an error
+	bad := testPdu{}
+	bad.add(types.flags, []byte{0x06})
+	bad.addBad(types.namecomp, 10, []byte("name"))
+	it = NewIterator(bad.bytes())
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if n != 1 || it.Err() == nil {
+		t.Fatalf("have %v structures and error %v, want 1 and an error", n, it.Err())
+	}
+}
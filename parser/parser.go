@@ -28,6 +28,15 @@ var types = struct {
 	nameshort   byte
 	namecomp    byte
 	txpwr       byte
+	pubtarget   byte
+	randtarget  byte
+	appearance  byte
+	advint      byte
+	uri         byte
+	lefeatures  byte
+	pbadv       byte
+	meshmsg     byte
+	meshbeacon  byte
 	mfgdata     byte
 }{
 	flags:       0x01,
@@ -46,6 +55,15 @@ var types = struct {
 	nameshort:   0x08,
 	namecomp:    0x09,
 	txpwr:       0x0a,
+	pubtarget:   0x17,
+	randtarget:  0x18,
+	appearance:  0x19,
+	advint:      0x1a,
+	uri:         0x24,
+	lefeatures:  0x27,
+	pbadv:       0x29,
+	meshmsg:     0x2a,
+	meshbeacon:  0x2b,
 	mfgdata:     0xff,
 }
 
@@ -56,6 +74,15 @@ var keys = struct {
 	serviceData string
 	localName   string
 	txpwr       string
+	pubtarget   string
+	randtarget  string
+	appearance  string
+	advint      string
+	uri         string
+	lefeatures  string
+	pbadv       string
+	meshmsg     string
+	meshbeacon  string
 	mfgdata     string
 }{
 	flags:       ble.AdvertisementMapKeys.Flags,
@@ -64,9 +91,27 @@ var keys = struct {
 	serviceData: ble.AdvertisementMapKeys.ServiceData,
 	localName:   ble.AdvertisementMapKeys.Name,
 	txpwr:       ble.AdvertisementMapKeys.TxPower,
+	pubtarget:   ble.AdvertisementMapKeys.PublicTargetAddresses,
+	randtarget:  ble.AdvertisementMapKeys.RandomTargetAddresses,
+	appearance:  ble.AdvertisementMapKeys.Appearance,
+	advint:      ble.AdvertisementMapKeys.AdvInterval,
+	uri:         ble.AdvertisementMapKeys.URI,
+	lefeatures:  ble.AdvertisementMapKeys.LEFeatures,
+	pbadv:       ble.AdvertisementMapKeys.PBADV,
+	meshmsg:     ble.AdvertisementMapKeys.MeshMessage,
+	meshbeacon:  ble.AdvertisementMapKeys.MeshBeacon,
 	mfgdata:     ble.AdvertisementMapKeys.MFG,
 }
 
+// listKeys are the keys of the AD types whose structures are kept apart, as
+// a [][]byte of the payloads, rather than concatenated. Each structure of
+// these types is a PDU of its own.
+var listKeys = map[string]bool{
+	keys.pbadv:      true,
+	keys.meshmsg:    true,
+	keys.meshbeacon: true,
+}
+
 type pduRecord struct {
 	arrayElementSz int
 	minSz          int
@@ -177,6 +222,60 @@ var pduDecodeMap = map[byte]pduRecord{
 		0,
 		keys.flags,
 	},
+	types.pubtarget: {
+		0,
+		6,
+		0,
+		keys.pubtarget,
+	},
+	types.randtarget: {
+		0,
+		6,
+		0,
+		keys.randtarget,
+	},
+	types.appearance: {
+		0,
+		2,
+		0,
+		keys.appearance,
+	},
+	types.advint: {
+		0,
+		2,
+		0,
+		keys.advint,
+	},
+	types.uri: {
+		0,
+		1,
+		0,
+		keys.uri,
+	},
+	types.lefeatures: {
+		0,
+		1,
+		0,
+		keys.lefeatures,
+	},
+	types.pbadv: {
+		0,
+		1,
+		0,
+		keys.pbadv,
+	},
+	types.meshmsg: {
+		0,
+		1,
+		0,
+		keys.meshmsg,
+	},
+	types.meshbeacon: {
+		0,
+		1,
+		0,
+		keys.meshbeacon,
+	},
 }
 
 func getArray(size int, bytes []byte) ([]ble.UUID, error) {
@@ -208,6 +307,8 @@ func getArray(size int, bytes []byte) ([]ble.UUID, error) {
 	return arr, nil
 }
 
+// Parse decodes the AD structures of pdu. It stops at the first malformed
+// structure, returning what was decoded before it along with the error.
 func Parse(pdu []byte) (map[string]interface{}, error) {
 	if len(pdu) == 0 {
 		return nil, EmptyOrNilPdu
@@ -215,80 +316,132 @@ func Parse(pdu []byte) (map[string]interface{}, error) {
 
 	m := make(map[string]interface{})
 	for i := 0; (i + 1) < len(pdu); {
-		//length @ offset 0
-		//type @ offset 1
-		//data @ 1 - (length-1)
-		length := int(pdu[i])
-		typ := pdu[i+1]
-
-		//length should be more than 1 since there is a type byte
-		if length < 1 {
-			return m, fmt.Errorf("invalid record length %v, idx %v", length, i)
+		length, err := recordLength(pdu, i)
+		if err != nil {
+			return m, err
 		}
-
-		//do we have all the bytes for the payload?
-		if (i + length) >= len(pdu) {
-			return m, fmt.Errorf("buffer overflow: want %v, have %v, idx %v", i+length, len(pdu), i)
+		if err := decodeRecord(m, pdu, i, length); err != nil {
+			return m, err
 		}
+		i += length + 1
+	}
 
-		start := i + 2
-		end := start + length - 1
-		bytes := make([]byte, len(pdu[start:end]))
-		copy(bytes, pdu[start:end])
-		dec, ok := pduDecodeMap[typ]
-		if ok && len(bytes) != 0 {
-			//have min length?
-			if dec.minSz > len(bytes) {
-				return m, fmt.Errorf("adv type %v: min length %v, have %v, idx %v", typ, dec.minSz, len(bytes), i)
-			}
+	return m, nil
+}
+
+// ParseLenient decodes the AD structures of pdu like Parse, but skips the
+// malformed ones instead of stopping at them. It returns what could be
+// decoded, and the errors of the skipped structures. A structure overflowing
+// the pdu still ends the decoding, as the following ones can't be located.
+func ParseLenient(pdu []byte) (map[string]interface{}, []error) {
+	if len(pdu) == 0 {
+		return nil, []error{EmptyOrNilPdu}
+	}
 
-			//expecting array?
-			if dec.arrayElementSz > 0 {
-				arr, err := getArray(dec.arrayElementSz, bytes)
-
-				//is this fatal?
-				if err != nil {
-					return m, fmt.Errorf("adv type %v, idx %v: %w", typ, i, err)
-				}
-
-				v, ok := m[dec.key].([]ble.UUID)
-				if !ok {
-					//nx key
-					m[dec.key] = arr
-				} else {
-					m[dec.key] = append(v, arr...)
-				}
-
-			} else if dec.svcDataUUIDSz > 0 {
-				su := ble.UUID(bytes[:dec.svcDataUUIDSz]).String()
-				sd := bytes[dec.svcDataUUIDSz:]
-
-				// service data map?
-				msd, ok := m[dec.key].(map[string]interface{})
-				if !ok {
-					msd = make(map[string]interface{})
-				}
-
-				// add/append
-				arr, ok := msd[su].([]interface{})
-				if !ok {
-					msd[su] = []interface{}{sd}
-				} else {
-					msd[su] = append(arr, sd)
-				}
-
-				//save result
-				m[dec.key] = msd
-			} else {
-				//we already checked for min length so just copy
-				writeOrAppendBytes(m, dec.key, bytes)
+	var errs []error
+	m := make(map[string]interface{})
+	for i := 0; (i + 1) < len(pdu); {
+		length, err := recordLength(pdu, i)
+		if err != nil {
+			errs = append(errs, err)
+			if length < 1 {
+				//skip the length byte and resync on the next one
+				i++
+				continue
 			}
+			break
+		}
+		if err := decodeRecord(m, pdu, i, length); err != nil {
+			errs = append(errs, err)
 		}
-
 		i += length + 1
 	}
 
-	return m, nil
+	return m, errs
+}
+
+// recordLength returns the length of the AD structure at offset i of pdu.
+func recordLength(pdu []byte, i int) (int, error) {
+	//length @ offset 0
+	//type @ offset 1
+	//data @ 1 - (length-1)
+	length := int(pdu[i])
+
+	//length should be more than 1 since there is a type byte
+	if length < 1 {
+		return length, fmt.Errorf("invalid record length %v, idx %v", length, i)
+	}
+
+	//do we have all the bytes for the payload?
+	if (i + length) >= len(pdu) {
+		return length, fmt.Errorf("buffer overflow: want %v, have %v, idx %v", i+length, len(pdu), i)
+	}
+	return length, nil
+}
+
+// decodeRecord decodes the AD structure of the given length at offset i of
+// pdu into m. m is left unchanged if the structure is malformed.
+func decodeRecord(m map[string]interface{}, pdu []byte, i int, length int) error {
+	typ := pdu[i+1]
+	start := i + 2
+	end := start + length - 1
+	bytes := make([]byte, len(pdu[start:end]))
+	copy(bytes, pdu[start:end])
+	dec, ok := pduDecodeMap[typ]
+	if !ok || len(bytes) == 0 {
+		return nil
+	}
+
+	//have min length?
+	if dec.minSz > len(bytes) {
+		return fmt.Errorf("adv type %v: min length %v, have %v, idx %v", typ, dec.minSz, len(bytes), i)
+	}
+
+	//expecting array?
+	if dec.arrayElementSz > 0 {
+		arr, err := getArray(dec.arrayElementSz, bytes)
+
+		//is this fatal?
+		if err != nil {
+			return fmt.Errorf("adv type %v, idx %v: %w", typ, i, err)
+		}
+
+		v, ok := m[dec.key].([]ble.UUID)
+		if !ok {
+			//nx key
+			m[dec.key] = arr
+		} else {
+			m[dec.key] = append(v, arr...)
+		}
+
+	} else if dec.svcDataUUIDSz > 0 {
+		su := ble.UUID(bytes[:dec.svcDataUUIDSz]).String()
+		sd := bytes[dec.svcDataUUIDSz:]
+
+		// service data map?
+		msd, ok := m[dec.key].(map[string]interface{})
+		if !ok {
+			msd = make(map[string]interface{})
+		}
+
+		// add/append
+		arr, ok := msd[su].([]interface{})
+		if !ok {
+			msd[su] = []interface{}{sd}
+		} else {
+			msd[su] = append(arr, sd)
+		}
+
+		//save result
+		m[dec.key] = msd
+	} else if listKeys[dec.key] {
+		v, _ := m[dec.key].([][]byte)
+		m[dec.key] = append(v, bytes)
+	} else {
+		//we already checked for min length so just copy
+		writeOrAppendBytes(m, dec.key, bytes)
+	}
+	return nil
 }
 
 func writeOrAppendBytes(m map[string]interface{}, key string, data []byte) {
@@ -671,3 +671,73 @@ func Test_ParseErrors(t *testing.T) {
 	}
 
 }
+
+func Test_ParseLenient(t *testing.T) {
+	// missing a byte on uuid128, followed by good records
+	u128bad := []byte{0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3}
+	md := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	name := []byte("name")
+
+	p := testPdu{}
+	p.add(types.uuid128comp, u128bad)
+	p.add(types.mfgdata, md)
+	p.b = append(p.b, 0) // zero length record
+	p.add(types.namecomp, name)
+
+	m, errs := ParseLenient(p.bytes())
+	if len(errs) != 2 {
+		t.Fatalf("have %v errors, want 2: %v", len(errs), errs)
+	}
+
+	var v, exp interface{}
+	exp = md
+	v = m[keys.mfgdata]
+	if !reflect.DeepEqual(v, exp) {
+		t.Fatalf("have %v (%T), want %v (%T)", v, v, exp, exp)
+	}
+	exp = name
+	v = m[keys.localName]
+	if !reflect.DeepEqual(v, exp) {
+		t.Fatalf("have %v (%T), want %v (%T)", v, v, exp, exp)
+	}
+	if v, ok := m[keys.services]; ok {
+		t.Fatalf("service field present on bad input, got %v", v)
+	}
+
+	// overflowing record ends the decoding
+	p = testPdu{}
+	p.add(types.mfgdata, md)
+	p.addBad(types.namecomp, 10, name)
+
+	m, errs = ParseLenient(p.bytes())
+	if len(errs) != 1 {
+		t.Fatalf("have %v errors, want 1: %v", len(errs), errs)
+	}
+	if _, ok := m[keys.mfgdata]; !ok {
+		t.Fatal("mfg field missing")
+	}
+}
+
+func Test_ParseMesh(t *testing.T) {
+	p := testPdu{}
+	p.add(types.meshmsg, []byte{1, 2, 3})
+	p.add(types.meshmsg, []byte{4, 5})
+	p.add(types.meshbeacon, []byte{0x00, 0xAA})
+
+	m, err := Parse(p.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v, exp interface{}
+	exp = [][]byte{{1, 2, 3}, {4, 5}}
+	v = m[keys.meshmsg]
+	if !reflect.DeepEqual(v, exp) {
+		t.Fatalf("have %v (%T), want %v (%T)", v, v, exp, exp)
+	}
+	exp = [][]byte{{0x00, 0xAA}}
+	v = m[keys.meshbeacon]
+	if !reflect.DeepEqual(v, exp) {
+		t.Fatalf("have %v (%T), want %v (%T)", v, v, exp, exp)
+	}
+}
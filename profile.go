@@ -60,6 +60,31 @@ func (p *Profile) FindService(service *Service) *Service {
 	return nil
 }
 
+// ReplaceService replaces the service with the UUID u by s, or removes it if
+// s is nil. A new service is inserted in handle order.
+func (p *Profile) ReplaceService(u UUID, s *Service) {
+	ss := p.Services[:0:0]
+	done := s == nil
+	for _, x := range p.Services {
+		switch {
+		case x.UUID.Equal(u):
+			if !done {
+				ss = append(ss, s)
+				done = true
+			}
+			continue
+		case !done && x.Handle > s.Handle:
+			ss = append(ss, s)
+			done = true
+		}
+		ss = append(ss, x)
+	}
+	if !done {
+		ss = append(ss, s)
+	}
+	p.Services = ss
+}
+
 // FindCharacteristic searches discoverd profile for the specified characteristic and UUID
 func (p *Profile) FindCharacteristic(char *Characteristic) *Characteristic {
 	for _, s := range p.Services {
@@ -128,6 +153,8 @@ type Characteristic struct {
 	NotifyHandler   NotifyHandler
 	IndicateHandler NotifyHandler
 
+	SubscriptionHandler SubscriptionHandler
+
 	Handle      uint16
 	ValueHandle uint16
 	EndHandle   uint16
@@ -196,6 +223,14 @@ func (c *Characteristic) HandleIndicate(h NotifyHandler) {
 	c.IndicateHandler = h
 }
 
+// HandleSubscription routes the changes of the subscriptions of the peers
+// to the characteristic to h, e.g. to start and stop a data source as the
+// first peer subscribes and the last one unsubscribes.
+// HandleSubscription must be called before the containing service is added to a server.
+func (c *Characteristic) HandleSubscription(h SubscriptionHandler) {
+	c.SubscriptionHandler = h
+}
+
 // Descriptor is a BLE descriptor
 type Descriptor struct {
 	UUID     UUID
@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const fileVersion = 1
+
+// registryFile is the content of a registry file.
+type registryFile struct {
+	Version int      `json:"version"`
+	Devices []Device `json:"devices"`
+}
+
+type fileStore struct {
+	path string
+}
+
+// NewFileStore returns a Store which persists the devices to a JSON file.
+// The file is created on the first save.
+func NewFileStore(path string) Store {
+	return &fileStore{path: path}
+}
+
+func (s *fileStore) Load() ([]Device, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f registryFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("invalid registry file %s: %v", s.path, err)
+	}
+	if f.Version != fileVersion {
+		return nil, fmt.Errorf("unsupported registry file version %d", f.Version)
+	}
+	return f.Devices, nil
+}
+
+func (s *fileStore) Save(ds []Device) error {
+	b, err := json.MarshalIndent(registryFile{Version: fileVersion, Devices: ds}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, b, 0600)
+}
+
+// writeFileAtomic writes data to a temporary file, which then replaces the
+// named file, so a crash doesn't leave a partially written file behind.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,272 @@
+// Package registry keeps a registry of the devices a scanner observes: their
+// addresses, names, advertised services and RSSI history. It persists them
+// to pluggable storage, so that a gateway restarting doesn't lose the
+// neighborhood it learned.
+//
+// A Registry observes the advertisements of a device set with
+// ble.OptRegistry, or passed to Observe.
+package registry
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/leso-kn/ble"
+)
+
+// Defaults of Options.
+const (
+	DefaultHistory      = 32
+	DefaultSaveInterval = time.Minute
+)
+
+// Sample is an RSSI measured at a time.
+type Sample struct {
+	Time time.Time `json:"time"`
+	RSSI int       `json:"rssi"`
+}
+
+// Device is a device observed.
+type Device struct {
+	// Addr is the identity address of the device, if its private address
+	// was resolved, or else its address.
+	Addr     string `json:"addr"`
+	AddrType uint8  `json:"addrType"`
+	// LastAddr is the last private address of a device with a resolved
+	// identity.
+	LastAddr string `json:"lastAddr,omitempty"`
+
+	Name        string    `json:"name,omitempty"`
+	Connectable bool      `json:"connectable"`
+	Services    []string  `json:"services,omitempty"` // Every service UUID advertised.
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+	RSSI        []Sample  `json:"rssi,omitempty"` // The last samples, oldest first.
+}
+
+// Store persists the devices of a registry.
+type Store interface {
+	Load() ([]Device, error)
+	Save(ds []Device) error
+}
+
+// Options tune a Registry.
+type Options struct {
+	// History is the number of RSSI samples kept per device;
+	// DefaultHistory if zero.
+	History int
+	// SampleInterval is the minimum time between the RSSI samples of a
+	// device; advertisements received sooner don't add a sample.
+	SampleInterval time.Duration
+	// MaxAge forgets the devices which weren't seen for longer, when the
+	// registry is saved. Zero keeps them.
+	MaxAge time.Duration
+	// SaveInterval is the time between saves of the changes;
+	// DefaultSaveInterval if zero.
+	SaveInterval time.Duration
+}
+
+// Registry is a registry of observed devices.
+type Registry struct {
+	store Store
+	opts  Options
+
+	mu      sync.Mutex
+	devices map[string]*Device
+	dirty   bool
+
+	done   chan struct{}
+	closed sync.Once
+	saved  chan struct{}
+}
+
+// New returns a registry with the devices of the store s, saving the
+// changes to it. A nil s keeps the registry in memory.
+func New(s Store, o Options) (*Registry, error) {
+	if o.History <= 0 {
+		o.History = DefaultHistory
+	}
+	if o.SaveInterval <= 0 {
+		o.SaveInterval = DefaultSaveInterval
+	}
+	r := &Registry{
+		store:   s,
+		opts:    o,
+		devices: make(map[string]*Device),
+		done:    make(chan struct{}),
+		saved:   make(chan struct{}),
+	}
+	if s == nil {
+		close(r.saved)
+		return r, nil
+	}
+	ds, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ds {
+		d := ds[i]
+		r.devices[d.Addr] = &d
+	}
+	go r.loop()
+	return r, nil
+}
+
+// loop saves the changes periodically, until the registry is closed.
+func (r *Registry) loop() {
+	defer close(r.saved)
+	t := time.NewTicker(r.opts.SaveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			r.Save()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Observe records the advertisement a.
+func (r *Registry) Observe(a ble.Advertisement) {
+	addr := a.Addr().String()
+	key, last := addr, ""
+	if ia, ok := a.(interface{ IdentityAddr() ble.Addr }); ok {
+		if id := ia.IdentityAddr(); id != nil {
+			key, last = id.String(), addr
+		}
+	}
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d, ok := r.devices[key]
+	if !ok {
+		d = &Device{Addr: key, FirstSeen: now}
+		r.devices[key] = d
+	}
+	if last == "" {
+		d.AddrType = a.AddrType()
+	}
+	d.LastAddr = last
+	d.LastSeen = now
+	d.Connectable = a.Connectable()
+	if n := a.LocalName(); n != "" {
+		d.Name = n
+	}
+	for _, u := range a.Services() {
+		d.addService(u.String())
+	}
+	if n := len(d.RSSI); n == 0 || now.Sub(d.RSSI[n-1].Time) >= r.opts.SampleInterval {
+		d.RSSI = append(d.RSSI, Sample{Time: now, RSSI: a.RSSI()})
+		if len(d.RSSI) > r.opts.History {
+			d.RSSI = append([]Sample(nil), d.RSSI[len(d.RSSI)-r.opts.History:]...)
+		}
+	}
+	r.dirty = true
+}
+
+func (d *Device) addService(u string) {
+	for _, s := range d.Services {
+		if s == u {
+			return
+		}
+	}
+	d.Services = append(d.Services, u)
+}
+
+// copy returns a copy of d, which doesn't share its slices.
+func (d *Device) copy() Device {
+	c := *d
+	c.Services = append([]string(nil), d.Services...)
+	c.RSSI = append([]Sample(nil), d.RSSI...)
+	return c
+}
+
+// Devices returns the devices of the registry, the most recently seen
+// first.
+func (r *Registry) Devices() []Device {
+	r.mu.Lock()
+	ds := make([]Device, 0, len(r.devices))
+	for _, d := range r.devices {
+		ds = append(ds, d.copy())
+	}
+	r.mu.Unlock()
+	sort.Slice(ds, func(i, j int) bool {
+		if !ds[i].LastSeen.Equal(ds[j].LastSeen) {
+			return ds[i].LastSeen.After(ds[j].LastSeen)
+		}
+		return ds[i].Addr < ds[j].Addr
+	})
+	return ds
+}
+
+// Device returns the device with the address a, which is its identity
+// address if it has one, or its last private address.
+func (r *Registry) Device(a ble.Addr) (Device, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d, ok := r.lookup(a.String()); ok {
+		return d.copy(), true
+	}
+	return Device{}, false
+}
+
+func (r *Registry) lookup(a string) (*Device, bool) {
+	if d, ok := r.devices[a]; ok {
+		return d, true
+	}
+	for _, d := range r.devices {
+		if d.LastAddr == a {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+// Forget removes the device with the address a.
+func (r *Registry) Forget(a ble.Addr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d, ok := r.lookup(a.String()); ok {
+		delete(r.devices, d.Addr)
+		r.dirty = true
+	}
+}
+
+// Save forgets the devices older than Options.MaxAge, and saves the
+// registry to its store, if it changed.
+func (r *Registry) Save() error {
+	r.mu.Lock()
+	if r.opts.MaxAge > 0 {
+		for k, d := range r.devices {
+			if time.Since(d.LastSeen) > r.opts.MaxAge {
+				delete(r.devices, k)
+				r.dirty = true
+			}
+		}
+	}
+	if r.store == nil || !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	r.dirty = false
+	r.mu.Unlock()
+
+	ds := r.Devices()
+	if err := r.store.Save(ds); err != nil {
+		r.mu.Lock()
+		r.dirty = true
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Close stops the periodic saves, and saves the registry.
+func (r *Registry) Close() error {
+	r.closed.Do(func() { close(r.done) })
+	<-r.saved
+	return r.Save()
+}
@@ -0,0 +1,134 @@
+package registry_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/leso-kn/ble"
+	"github.com/leso-kn/ble/internal/virtualtest"
+	"github.com/leso-kn/ble/registry"
+)
+
+type adv struct {
+	ble.Advertisement
+	addr     string
+	identity string
+	name     string
+	rssi     int
+	svcs     []ble.UUID
+}
+
+func (a *adv) Addr() ble.Addr       { return ble.NewAddr(a.addr) }
+func (a *adv) AddrType() uint8      { return 1 }
+func (a *adv) LocalName() string    { return a.name }
+func (a *adv) RSSI() int            { return a.rssi }
+func (a *adv) Services() []ble.UUID { return a.svcs }
+func (a *adv) Connectable() bool    { return true }
+
+func (a *adv) IdentityAddr() ble.Addr {
+	if a.identity == "" {
+		return nil
+	}
+	return ble.NewAddr(a.identity)
+}
+
+func TestRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "devices.json")
+	r, err := registry.New(registry.NewFileStore(path), registry.Options{History: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Observe(&adv{addr: "11:22:33:44:55:66", name: "Gopher", rssi: -40, svcs: []ble.UUID{ble.UUID16(0x180D)}})
+	r.Observe(&adv{addr: "11:22:33:44:55:66", rssi: -50, svcs: []ble.UUID{ble.UUID16(0x180F)}})
+	r.Observe(&adv{addr: "11:22:33:44:55:66", rssi: -60, svcs: []ble.UUID{ble.UUID16(0x180D)}})
+	// A private address, resolved to an identity.
+	r.Observe(&adv{addr: "7A:00:00:00:00:01", identity: "AA:BB:CC:DD:EE:FF", rssi: -70})
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart keeps the devices.
+	r, err = registry.New(registry.NewFileStore(path), registry.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ds := r.Devices()
+	if len(ds) != 2 || ds[0].Addr != "aa:bb:cc:dd:ee:ff" {
+		t.Fatalf("devices %+v", ds)
+	}
+	d, ok := r.Device(ble.NewAddr("11:22:33:44:55:66"))
+	if !ok {
+		t.Fatal("device not found")
+	}
+	if d.Name != "Gopher" || len(d.Services) != 2 || len(d.RSSI) != 2 || d.RSSI[1].RSSI != -60 {
+		t.Fatalf("device %+v", d)
+	}
+	if d, ok := r.Device(ble.NewAddr("7A:00:00:00:00:01")); !ok || d.Addr != "aa:bb:cc:dd:ee:ff" {
+		t.Fatalf("device by private address %+v, %v", d, ok)
+	}
+
+	r.Forget(ble.NewAddr("AA:BB:CC:DD:EE:FF"))
+	if len(r.Devices()) != 1 {
+		t.Fatal("device not forgotten")
+	}
+}
+
+func TestRegistryMaxAge(t *testing.T) {
+	r, err := registry.New(nil, registry.Options{MaxAge: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Observe(&adv{addr: "11:22:33:44:55:66"})
+	time.Sleep(5 * time.Millisecond)
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if ds := r.Devices(); len(ds) != 0 {
+		t.Fatalf("devices %+v", ds)
+	}
+}
+
+func TestRegistryScan(t *testing.T) {
+	reg, err := registry.New(nil, registry.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Close()
+	pair := virtualtest.NewPair(t, nil, []ble.Option{ble.OptRegistry(reg)})
+	defer pair.Stop()
+	p, s := pair.Peripheral, pair.Central
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.AdvertiseNameAndServices(ctx, "Gopher", ble.UUID16(0x180D))
+
+	// The registry records the peripheral, though the handler ignores it.
+	sctx, scancel := context.WithCancel(ctx)
+	go s.Scan(sctx, true, func(a ble.Advertisement) {})
+	defer scancel()
+	for {
+		d, ok := s.KnownDevice(ble.NewAddr(virtualtest.PeripheralAddr))
+		if ok && d.Name == "Gopher" {
+			if len(d.Services) != 1 || len(d.RSSI) == 0 || len(s.KnownDevices()) != 1 {
+				t.Fatalf("device %+v", d)
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("peripheral not recorded")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -3,4 +3,111 @@ package ble
 type AuthData struct {
 	Passkey int
 	OOBData []byte
-}
\ No newline at end of file
+
+	// OOBConfirm, if set, is the confirm value the peer passed out of band
+	// along with its random value OOBData. It's checked against the public
+	// key of the peer in LE Secure Connections pairing.
+	OOBConfirm []byte
+
+	// DisplayPasskey, if set, is called with a generated passkey to show to
+	// the user, when Passkey Entry pairing has the local device display it.
+	// It must not block.
+	DisplayPasskey func(passkey int)
+
+	// InputPasskey, if set, is called for the passkey typed in by the user,
+	// when Passkey Entry pairing has the local device input it. An error
+	// fails the pairing.
+	InputPasskey func() (int, error)
+
+	// ConfirmNumericComparison, if set, is called in Numeric Comparison
+	// pairing for whether the user confirms that the value matches the one
+	// shown by the peer. It may block until the user answers. Without it,
+	// the value is accepted unconfirmed, as in Just Works pairing.
+	ConfirmNumericComparison func(c NumericComparison) bool
+
+	// Features, if set, override the pairing features of the device set with
+	// OptPairingFeatures for this pairing.
+	Features *PairingFeatures
+
+	// AcceptPairing, if set, decides on the pairing requests of peers, e.g.
+	// to only bond during a provisioning window. It must not block.
+	AcceptPairing func(r PairingRequest) PairingDecision
+}
+
+// PairingRequest is the pairing request of a peer.
+type PairingRequest struct {
+	Peer              Addr
+	Features          PairingFeatures
+	SecureConnections bool
+	OOB               bool // The peer has OOB data of the local device.
+}
+
+// PairingDecision is the answer to a PairingRequest. The zero value accepts
+// the pairing.
+type PairingDecision struct {
+	// Reject fails the pairing with Reason, a Pairing Failed reason code
+	// [Vol 3, Part H, 3.5.5], or Pairing Not Supported if it's zero.
+	Reject bool
+	Reason uint8
+
+	// Confirm accepts the pairing only with protection against MITM attacks,
+	// which has the user confirm it by numeric comparison or passkey entry.
+	Confirm bool
+}
+
+// IOCapability is the input and output capability of a device, which, along
+// with the one of the peer, determines the pairing method. [Vol 3, Part H, 2.3.2]
+type IOCapability uint8
+
+// IO capabilities
+const (
+	IOCapDisplayOnly     IOCapability = 0x00
+	IOCapDisplayYesNo    IOCapability = 0x01
+	IOCapKeyboardOnly    IOCapability = 0x02
+	IOCapNoInputNoOutput IOCapability = 0x03
+	IOCapKeyboardDisplay IOCapability = 0x04
+)
+
+// PairingFeatures are the pairing features of the local device.
+type PairingFeatures struct {
+	IOCap IOCapability
+
+	// Bond has the keys of the pairing stored, to encrypt later connections
+	// without pairing again.
+	Bond bool
+
+	// MITM requires protection against man-in-the-middle attacks. Pairing
+	// fails if the IO capabilities only allow for Just Works pairing.
+	MITM bool
+}
+
+// PeerPasskeyAuthData returns the auth data to pair with a peer whose
+// passkey is fixed, e.g. printed on an industrial peripheral: the local
+// device pairs as a keyboard only one, requiring MITM protection, and inputs
+// passkey in Passkey Entry pairing, without InputPasskey.
+func PeerPasskeyAuthData(passkey int) AuthData {
+	return AuthData{
+		Passkey:  passkey,
+		Features: &PairingFeatures{IOCap: IOCapKeyboardOnly, Bond: true, MITM: true},
+	}
+}
+
+// DefaultPairingFeatures are the pairing features used if none are set.
+var DefaultPairingFeatures = PairingFeatures{IOCap: IOCapKeyboardDisplay, Bond: true}
+
+// OOBData is LE Secure Connections out of band data of the local device, to
+// pass to the peer over another channel, such as NFC or a QR code. Values
+// are in the byte order of the corresponding AD types.
+type OOBData struct {
+	Addr      Addr
+	Random    [16]byte // LE Secure Connections Random Value, r.
+	Confirm   [16]byte // LE Secure Connections Confirmation Value, f4(PKx, PKx, r, 0).
+	PublicKey [64]byte // P-256 public key the confirm value commits to, X || Y.
+}
+
+// NumericComparison is the value of LE Secure Connections Numeric Comparison
+// pairing, for the user to compare with the one shown by the peer.
+type NumericComparison struct {
+	Peer  Addr
+	Value int // 6-digit value.
+}
@@ -0,0 +1,86 @@
+// Package transfer moves objects, e.g. firmware images or logs, over an
+// L2CAP connection-oriented channel, or any other reliable stream.
+//
+// Objects are sent as length-prefixed frames. The receiver tells the sender
+// where to start, so an interrupted transfer resumes from the data it
+// already has, and each side reports its progress.
+package transfer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxMessageSize is the largest message a Conn reads.
+const MaxMessageSize = 1 << 20
+
+// A Conn exchanges length-prefixed messages over a stream. Each message is
+// preceded by its length, as a 4 byte little endian integer.
+type Conn struct {
+	r   *bufio.Reader
+	w   io.Writer
+	mtu int
+}
+
+// mtuer is implemented by channels, such as ble.L2CAPChannel, which take
+// writes of up to MTU bytes.
+type mtuer interface {
+	MTU() int
+}
+
+// NewConn returns a Conn on rw. If rw has an MTU method, as ble.L2CAPChannel
+// does, messages are written in pieces of up to MTU bytes.
+func NewConn(rw io.ReadWriter) *Conn {
+	c := &Conn{
+		// Channels return a whole SDU per read, which must fit.
+		r: bufio.NewReaderSize(rw, 1<<16),
+		w: rw,
+	}
+	if m, ok := rw.(mtuer); ok {
+		c.mtu = m.MTU()
+	}
+	return c
+}
+
+// WriteMessage writes a message.
+func (c *Conn) WriteMessage(b []byte) error {
+	if len(b) > MaxMessageSize {
+		return fmt.Errorf("message too long: %d bytes", len(b))
+	}
+	f := make([]byte, 4+len(b))
+	binary.LittleEndian.PutUint32(f, uint32(len(b)))
+	copy(f[4:], b)
+	for len(f) > 0 {
+		n := len(f)
+		if c.mtu > 0 && n > c.mtu {
+			n = c.mtu
+		}
+		if _, err := c.w.Write(f[:n]); err != nil {
+			return err
+		}
+		f = f[n:]
+	}
+	return nil
+}
+
+// ReadMessage reads a message.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var h [4]byte
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(h[:])
+	if n > MaxMessageSize {
+		return nil, fmt.Errorf("message too long: %d bytes", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,228 @@
+package transfer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Message opcodes.
+const (
+	opOffer  = 0x01 // size uint64, name
+	opAccept = 0x02 // offset uint64
+	opReject = 0x03 // reason
+	opData   = 0x04 // offset uint64, data
+	opEnd    = 0x05 // crc32 uint32 of the data sent
+	opResult = 0x06 // status, reason
+)
+
+// Result statuses.
+const (
+	statusOK       = 0x00
+	statusChecksum = 0x01
+	statusFailed   = 0x02
+)
+
+// DefaultChunkSize is the data carried by each message, if not set.
+const DefaultChunkSize = 4096
+
+var (
+	// ErrRejected means the receiver refused the object.
+	ErrRejected = errors.New("transfer rejected")
+
+	// ErrChecksum means the data received doesn't match the data sent.
+	ErrChecksum = errors.New("transfer checksum mismatch")
+
+	// ErrProtocol means the peer sent an unexpected message.
+	ErrProtocol = errors.New("transfer protocol error")
+)
+
+// Progress is called with the bytes of the object transferred so far,
+// including those of previous attempts, and its size.
+type Progress func(done, total int64)
+
+// Options set up a transfer. The zero value is ready to use.
+type Options struct {
+	// ChunkSize is the data carried by each message. It's independent of
+	// the MTU of the channel.
+	ChunkSize int
+
+	// Progress, if set, is called after each chunk.
+	Progress Progress
+}
+
+func (o *Options) chunkSize() int {
+	if o == nil || o.ChunkSize <= 0 {
+		return DefaultChunkSize
+	}
+	return o.ChunkSize
+}
+
+func (o *Options) progress(done, total int64) {
+	if o != nil && o.Progress != nil {
+		o.Progress(done, total)
+	}
+}
+
+// Send offers the object name of size bytes, read from r, to the receiver at
+// the other end of rw, and sends it from the offset the receiver asks for.
+func Send(rw io.ReadWriter, name string, r io.ReaderAt, size int64, opts *Options) error {
+	c := NewConn(rw)
+
+	offer := make([]byte, 9, 9+len(name))
+	offer[0] = opOffer
+	binary.LittleEndian.PutUint64(offer[1:], uint64(size))
+	if err := c.WriteMessage(append(offer, name...)); err != nil {
+		return err
+	}
+
+	m, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(m) > 0 && m[0] == opReject:
+		return fmt.Errorf("%w: %s", ErrRejected, m[1:])
+	case len(m) != 9 || m[0] != opAccept:
+		return ErrProtocol
+	}
+	off := int64(binary.LittleEndian.Uint64(m[1:]))
+	if off < 0 || off > size {
+		return fmt.Errorf("%w: offset %d beyond size %d", ErrProtocol, off, size)
+	}
+	opts.progress(off, size)
+
+	crc := crc32.NewIEEE()
+	buf := make([]byte, 9+opts.chunkSize())
+	buf[0] = opData
+	for off < size {
+		n := int64(len(buf) - 9)
+		if size-off < n {
+			n = size - off
+		}
+		d := buf[9 : 9+n]
+		if _, err := r.ReadAt(d, off); err != nil && err != io.EOF {
+			return err
+		}
+		binary.LittleEndian.PutUint64(buf[1:], uint64(off))
+		if err := c.WriteMessage(buf[:9+n]); err != nil {
+			return err
+		}
+		crc.Write(d)
+		off += n
+		opts.progress(off, size)
+	}
+
+	end := make([]byte, 5)
+	end[0] = opEnd
+	binary.LittleEndian.PutUint32(end[1:], crc.Sum32())
+	if err := c.WriteMessage(end); err != nil {
+		return err
+	}
+
+	if m, err = c.ReadMessage(); err != nil {
+		return err
+	}
+	if len(m) < 2 || m[0] != opResult {
+		return ErrProtocol
+	}
+	switch m[1] {
+	case statusOK:
+		return nil
+	case statusChecksum:
+		return ErrChecksum
+	default:
+		return fmt.Errorf("transfer failed: %s", m[2:])
+	}
+}
+
+// OpenFunc is called by Receive with the name and size of the object
+// offered. It returns where to write the object, and the offset to resume
+// from, e.g. the size of the data kept from an interrupted transfer. An
+// error rejects the object.
+type OpenFunc func(name string, size int64) (w io.WriterAt, offset int64, err error)
+
+// Receive receives an object from the sender at the other end of rw, and
+// writes it where open says. It returns the name of the object.
+func Receive(rw io.ReadWriter, open OpenFunc, opts *Options) (string, error) {
+	c := NewConn(rw)
+
+	m, err := c.ReadMessage()
+	if err != nil {
+		return "", err
+	}
+	if len(m) < 9 || m[0] != opOffer {
+		return "", ErrProtocol
+	}
+	size := int64(binary.LittleEndian.Uint64(m[1:]))
+	name := string(m[9:])
+
+	w, off, err := open(name, size)
+	if err == nil && (off < 0 || off > size) {
+		err = fmt.Errorf("invalid offset %d", off)
+	}
+	if err != nil {
+		c.WriteMessage(append([]byte{opReject}, err.Error()...))
+		return name, fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	accept := make([]byte, 9)
+	accept[0] = opAccept
+	binary.LittleEndian.PutUint64(accept[1:], uint64(off))
+	if err := c.WriteMessage(accept); err != nil {
+		return name, err
+	}
+	opts.progress(off, size)
+
+	crc := crc32.NewIEEE()
+	var werr error
+	for {
+		m, err := c.ReadMessage()
+		if err != nil {
+			return name, err
+		}
+		if len(m) == 0 {
+			return name, ErrProtocol
+		}
+		switch m[0] {
+		case opData:
+			if len(m) < 9 || int64(binary.LittleEndian.Uint64(m[1:])) != off {
+				return name, ErrProtocol
+			}
+			d := m[9:]
+			if off+int64(len(d)) > size {
+				return name, ErrProtocol
+			}
+			if werr == nil {
+				_, werr = w.WriteAt(d, off)
+			}
+			crc.Write(d)
+			off += int64(len(d))
+			opts.progress(off, size)
+
+		case opEnd:
+			if len(m) != 5 {
+				return name, ErrProtocol
+			}
+			res := []byte{opResult, statusOK}
+			switch {
+			case werr != nil:
+				res = append([]byte{opResult, statusFailed}, werr.Error()...)
+			case off != size:
+				res = append([]byte{opResult, statusFailed}, "short transfer"...)
+				werr = io.ErrUnexpectedEOF
+			case binary.LittleEndian.Uint32(m[1:]) != crc.Sum32():
+				res[1] = statusChecksum
+				werr = ErrChecksum
+			}
+			if err := c.WriteMessage(res); err != nil {
+				return name, err
+			}
+			return name, werr
+
+		default:
+			return name, ErrProtocol
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+// object is an io.WriterAt in memory.
+type object struct {
+	b []byte
+}
+
+func (o *object) WriteAt(p []byte, off int64) (int, error) {
+	if n := int(off) + len(p); n > len(o.b) {
+		o.b = append(o.b, make([]byte, n-len(o.b))...)
+	}
+	return copy(o.b[off:], p), nil
+}
+
+// channel limits writes to mtu bytes, as an L2CAP channel does.
+type channel struct {
+	net.Conn
+	mtu int
+}
+
+func (c *channel) MTU() int { return c.mtu }
+
+func (c *channel) Write(b []byte) (int, error) {
+	if len(b) > c.mtu {
+		return 0, errors.New("sdu exceeds mtu")
+	}
+	return c.Conn.Write(b)
+}
+
+func pipe(mtu int) (io.ReadWriteCloser, io.ReadWriteCloser) {
+	a, b := net.Pipe()
+	return &channel{a, mtu}, &channel{b, mtu}
+}
+
+func data(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(b)
+	return b
+}
+
+type result struct {
+	name string
+	err  error
+}
+
+func receive(rw io.ReadWriter, open OpenFunc, opts *Options) <-chan result {
+	ch := make(chan result, 1)
+	go func() {
+		name, err := Receive(rw, open, opts)
+		ch <- result{name, err}
+	}()
+	return ch
+}
+
+func TestTransfer(t *testing.T) {
+	s, r := pipe(64)
+	defer s.Close()
+	defer r.Close()
+
+	src := data(10000)
+	dst := &object{}
+	var got []int64
+	done := receive(r, func(name string, size int64) (io.WriterAt, int64, error) {
+		if size != int64(len(src)) {
+			t.Errorf("size = %d, want %d", size, len(src))
+		}
+		return dst, 0, nil
+	}, &Options{Progress: func(done, total int64) { got = append(got, done) }})
+
+	var sent int64
+	err := Send(s, "fw.bin", bytes.NewReader(src), int64(len(src)), &Options{
+		ChunkSize: 1000,
+		Progress:  func(done, total int64) { sent = done },
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	res := <-done
+	if res.err != nil || res.name != "fw.bin" {
+		t.Fatalf("receive: %q, %v", res.name, res.err)
+	}
+	if !bytes.Equal(dst.b, src) {
+		t.Errorf("received data differs")
+	}
+	if sent != int64(len(src)) {
+		t.Errorf("sender progress = %d, want %d", sent, len(src))
+	}
+	if len(got) != 11 || got[0] != 0 || got[10] != int64(len(src)) {
+		t.Errorf("receiver progress = %v", got)
+	}
+}
+
+func TestTransferResume(t *testing.T) {
+	s, r := pipe(128)
+	defer s.Close()
+	defer r.Close()
+
+	src := data(5000)
+	dst := &object{b: append([]byte{}, src[:3000]...)}
+	done := receive(r, func(name string, size int64) (io.WriterAt, int64, error) {
+		return dst, int64(len(dst.b)), nil
+	}, nil)
+
+	var first int64 = -1
+	err := Send(s, "log", bytes.NewReader(src), int64(len(src)), &Options{
+		Progress: func(done, total int64) {
+			if first < 0 {
+				first = done
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if res := <-done; res.err != nil {
+		t.Fatalf("receive: %v", res.err)
+	}
+	if first != 3000 {
+		t.Errorf("progress started at %d, want 3000", first)
+	}
+	if !bytes.Equal(dst.b, src) {
+		t.Errorf("received data differs")
+	}
+}
+
+func TestTransferReject(t *testing.T) {
+	s, r := pipe(64)
+	defer s.Close()
+	defer r.Close()
+
+	done := receive(r, func(name string, size int64) (io.WriterAt, int64, error) {
+		return nil, 0, errors.New("too large")
+	}, nil)
+
+	err := Send(s, "big", bytes.NewReader(nil), 1<<30, nil)
+	if !errors.Is(err, ErrRejected) {
+		t.Errorf("send: %v, want ErrRejected", err)
+	}
+	if res := <-done; !errors.Is(res.err, ErrRejected) {
+		t.Errorf("receive: %v, want ErrRejected", res.err)
+	}
+}
+
+func TestConnMessages(t *testing.T) {
+	a, b := pipe(23)
+	defer a.Close()
+	defer b.Close()
+
+	msgs := [][]byte{{}, []byte("hello"), data(300)}
+	go func() {
+		c := NewConn(a)
+		for _, m := range msgs {
+			c.WriteMessage(m)
+		}
+	}()
+	c := NewConn(b)
+	for _, want := range msgs {
+		got, err := c.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("message = % X, want % X", got, want)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+// Package twin keeps the fields of a Go struct in sync with the
+// characteristics of a device, for devices with many configuration or
+// state characteristics.
+//
+// The fields are mapped to characteristics by their ble tags:
+//
+//	type Thermostat struct {
+//		Battery  uint8   `ble:"2a19,notify"`
+//		Setpoint int16   `ble:"abcd/2a6e"`
+//		Name     string  `ble:"2a00"`
+//		Unit     []byte  `ble:"ffe1,noread"`
+//		Cached   float32 // not mapped
+//	}
+//
+// The tag is the UUID of the characteristic, optionally preceded by that of
+// its service and a slash, followed by options: notify or indicate to
+// subscribe to the characteristic, noread not to read it and readonly not
+// to write it. Fields are []byte, string, bool, integers and floats, in
+// little-endian order, or implement encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler.
+//
+// Read populates the struct, the notifications received once subscribed
+// update it, and Write writes the fields changed since back:
+//
+//	var th Thermostat
+//	tw, err := twin.New(cln, &th, nil)
+//	...
+//	err = tw.Read()
+//	err = tw.Subscribe()
+//	tw.Lock()
+//	th.Setpoint = 2150
+//	tw.Unlock()
+//	err = tw.Write()
+package twin
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/leso-kn/ble"
+)
+
+// Options set up a twin. The zero value is ready to use.
+type Options struct {
+	// Changed, if set, is called with the name of the field updated by a
+	// notification, with the twin locked.
+	Changed func(field string)
+
+	// Conflict, if set, is called as a notification changes a field which
+	// was changed locally and not written yet, with the local and the new
+	// remote value, and the twin locked. It returns whether to keep the
+	// local value, to be written by the next Write. Without it, the remote
+	// value replaces the local one.
+	Conflict func(field string, local, remote []byte) (keepLocal bool)
+}
+
+// FieldError is an error syncing a field.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Errors are the errors syncing the fields, the others are synced anyway.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	s := make([]string, len(e))
+	for i, fe := range e {
+		s[i] = fe.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// err returns e, or nil if it's empty.
+func (e Errors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ErrNotFound means the client's profile has no characteristic for a tag.
+var ErrNotFound = errors.New("characteristic not found")
+
+// field is a struct field mapped to a characteristic.
+type field struct {
+	name     string
+	v        reflect.Value
+	c        *ble.Characteristic
+	notify   bool
+	indicate bool
+	noRead   bool
+	readOnly bool
+
+	// synced is the last value known to be on the device, nil if unknown.
+	synced []byte
+}
+
+// Twin syncs a struct with the characteristics of a client.
+type Twin struct {
+	sync.Mutex
+
+	cln    ble.Client
+	opts   Options
+	fields []*field
+}
+
+// New returns a twin of the struct pointed to by v, whose tagged fields are
+// mapped to the characteristics of the profile of cln, which must be
+// discovered.
+//
+// The struct is modified by the notifications once subscribed: access it
+// with the twin locked.
+func New(cln ble.Client, v interface{}, opts *Options) (*Twin, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("twin of %T, want a pointer to a struct", v)
+	}
+	p := cln.Profile()
+	if p == nil {
+		return nil, errors.New("profile not discovered")
+	}
+	t := &Twin{cln: cln}
+	if opts != nil {
+		t.opts = *opts
+	}
+
+	rv = rv.Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		sf := rv.Type().Field(i)
+		tag, ok := sf.Tag.Lookup("ble")
+		if !ok || tag == "-" {
+			continue
+		}
+		f := &field{name: sf.Name, v: rv.Field(i)}
+		if !f.v.CanSet() {
+			return nil, fmt.Errorf("%s: unexported field", f.name)
+		}
+		if err := checkType(f.v); err != nil {
+			return nil, &FieldError{Field: f.name, Err: err}
+		}
+		opts := strings.Split(tag, ",")
+		c, err := findCharacteristic(p, opts[0])
+		if err != nil {
+			return nil, &FieldError{Field: f.name, Err: err}
+		}
+		f.c = c
+		for _, o := range opts[1:] {
+			switch o {
+			case "notify":
+				f.notify = true
+			case "indicate":
+				f.indicate = true
+			case "noread":
+				f.noRead = true
+			case "readonly":
+				f.readOnly = true
+			default:
+				return nil, &FieldError{Field: f.name, Err: fmt.Errorf("unknown option %q", o)}
+			}
+		}
+		t.fields = append(t.fields, f)
+	}
+	return t, nil
+}
+
+// findCharacteristic returns the characteristic of p for "char" or
+// "service/char".
+func findCharacteristic(p *ble.Profile, s string) (*ble.Characteristic, error) {
+	var su ble.UUID
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		u, err := ble.Parse(s[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid service UUID %q: %v", s[:i], err)
+		}
+		su, s = u, s[i+1:]
+	}
+	cu, err := ble.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid characteristic UUID %q: %v", s, err)
+	}
+
+	var found *ble.Characteristic
+	for _, svc := range p.Services {
+		if su != nil && !svc.UUID.Equal(su) {
+			continue
+		}
+		for _, c := range svc.Characteristics {
+			if !c.UUID.Equal(cu) {
+				continue
+			}
+			if found != nil {
+				return nil, fmt.Errorf("characteristic %s in several services, prefix it with the service UUID", cu)
+			}
+			found = c
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, cu)
+	}
+	return found, nil
+}
+
+// Read reads the characteristics of the fields, but those tagged noread,
+// and sets the fields, discarding their local changes.
+func (t *Twin) Read() error {
+	var errs Errors
+	for _, f := range t.fields {
+		if f.noRead {
+			continue
+		}
+		b, err := t.cln.ReadLongCharacteristic(f.c)
+		if err == nil {
+			t.Lock()
+			if err = decode(f.v, b); err == nil {
+				f.synced = b
+			}
+			t.Unlock()
+		}
+		if err != nil {
+			errs = append(errs, &FieldError{Field: f.name, Err: err})
+		}
+	}
+	return errs.err()
+}
+
+// Subscribe subscribes to the characteristics of the fields tagged notify
+// or indicate, whose notifications then update the fields.
+func (t *Twin) Subscribe() error {
+	var errs Errors
+	for _, f := range t.fields {
+		if !f.notify && !f.indicate {
+			continue
+		}
+		f := f
+		err := t.cln.Subscribe(f.c, f.indicate, func(_ uint, b []byte) {
+			t.notified(f, append([]byte(nil), b...))
+		})
+		if err != nil {
+			errs = append(errs, &FieldError{Field: f.name, Err: err})
+		}
+	}
+	return errs.err()
+}
+
+// Unsubscribe unsubscribes from the characteristics of the fields.
+func (t *Twin) Unsubscribe() error {
+	var errs Errors
+	for _, f := range t.fields {
+		if !f.notify && !f.indicate {
+			continue
+		}
+		if err := t.cln.Unsubscribe(f.c, f.indicate); err != nil {
+			errs = append(errs, &FieldError{Field: f.name, Err: err})
+		}
+	}
+	return errs.err()
+}
+
+// notified updates the field f with the value b notified.
+func (t *Twin) notified(f *field, b []byte) {
+	t.Lock()
+	defer t.Unlock()
+	local, err := encode(f.v)
+	if err != nil {
+		return
+	}
+	pending := f.synced != nil && !bytes.Equal(local, f.synced)
+	f.synced = b
+	if pending && !bytes.Equal(local, b) && t.opts.Conflict != nil && t.opts.Conflict(f.name, local, b) {
+		return
+	}
+	if bytes.Equal(local, b) || decode(f.v, b) != nil {
+		return
+	}
+	if t.opts.Changed != nil {
+		t.opts.Changed(f.name)
+	}
+}
+
+// Changed returns the names of the fields changed since they were last
+// read, notified or written, which Write would write.
+func (t *Twin) Changed() []string {
+	t.Lock()
+	defer t.Unlock()
+	var names []string
+	for _, f := range t.fields {
+		if b, ok := t.changed(f); ok && b != nil {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// changed returns the value of f, and whether it's to be written: it isn't
+// readonly, and differs from the last value known on the device, if any.
+func (t *Twin) changed(f *field) ([]byte, bool) {
+	if f.readOnly {
+		return nil, false
+	}
+	b, err := encode(f.v)
+	if err != nil {
+		return nil, false
+	}
+	return b, f.synced == nil || !bytes.Equal(b, f.synced)
+}
+
+// Write writes the fields changed, those never read included, to their
+// characteristics, with a response. Fields tagged readonly aren't written.
+func (t *Twin) Write() error {
+	var errs Errors
+	for _, f := range t.fields {
+		t.Lock()
+		b, ok := t.changed(f)
+		t.Unlock()
+		if !ok {
+			continue
+		}
+		if err := t.write(f.c, b); err != nil {
+			errs = append(errs, &FieldError{Field: f.name, Err: err})
+			continue
+		}
+		t.Lock()
+		f.synced = b
+		t.Unlock()
+	}
+	return errs.err()
+}
+
+// longWriter is implemented by clients writing values longer than a Write
+// Request carries, such as the one of linux/gatt.
+type longWriter interface {
+	WriteLongCharacteristic(c *ble.Characteristic, v []byte) error
+}
+
+// write writes b to c, with Prepare Write Requests if it's too long for a
+// Write Request and the client supports them.
+func (t *Twin) write(c *ble.Characteristic, b []byte) error {
+	if lw, ok := t.cln.(longWriter); ok {
+		if conn := t.cln.Conn(); conn != nil && len(b) > ble.MaxValueLen(conn.TxMTU(), ble.WriteOverhead) {
+			return lw.WriteLongCharacteristic(c, b)
+		}
+	}
+	return t.cln.WriteCharacteristic(c, b, false)
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// checkType checks the type of v is supported.
+func checkType(v reflect.Value) error {
+	if v.Type().Implements(marshalerType) && v.Addr().Type().Implements(unmarshalerType) {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported type %s", v.Type())
+}
+
+// encode returns the value of v as a characteristic value.
+func encode(v reflect.Value) ([]byte, error) {
+	if m, ok := v.Interface().(encoding.BinaryMarshaler); ok {
+		return m.MarshalBinary()
+	}
+	b := make([]byte, 8)
+	switch v.Kind() {
+	case reflect.Slice:
+		return append([]byte{}, v.Bytes()...), nil
+	case reflect.String:
+		return []byte(v.String()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		binary.LittleEndian.PutUint64(b, uint64(v.Int()))
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		binary.LittleEndian.PutUint64(b, v.Uint())
+	case reflect.Float32:
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		binary.LittleEndian.PutUint64(b, math.Float64bits(v.Float()))
+	}
+	return b[:v.Type().Size()], nil
+}
+
+// decode sets v to the characteristic value b.
+func decode(v reflect.Value, b []byte) error {
+	if u, ok := v.Addr().Interface().(encoding.BinaryUnmarshaler); ok {
+		return u.UnmarshalBinary(b)
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		v.SetBytes(append([]byte{}, b...))
+		return nil
+	case reflect.String:
+		v.SetString(string(b))
+		return nil
+	}
+	if n := int(v.Type().Size()); len(b) != n {
+		return fmt.Errorf("value of %d bytes, want %d", len(b), n)
+	}
+	d := make([]byte, 8)
+	copy(d, b)
+	u := binary.LittleEndian.Uint64(d)
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(u != 0)
+	case reflect.Int8:
+		v.SetInt(int64(int8(u)))
+	case reflect.Int16:
+		v.SetInt(int64(int16(u)))
+	case reflect.Int32:
+		v.SetInt(int64(int32(u)))
+	case reflect.Int64:
+		v.SetInt(int64(u))
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(u)
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(uint32(u))))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(u))
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package twin
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/leso-kn/ble"
+)
+
+// client serves the values of the characteristics of a profile.
+type client struct {
+	ble.Client
+
+	mu       sync.Mutex
+	p        *ble.Profile
+	values   map[*ble.Characteristic][]byte
+	handlers map[*ble.Characteristic]ble.NotificationHandler
+	writes   []string
+	fail     error
+}
+
+func newClient(chars ...*ble.Characteristic) *client {
+	s := ble.NewService(ble.UUID16(0xabcd))
+	for _, c := range chars {
+		s.AddCharacteristic(c)
+	}
+	return &client{
+		p:        &ble.Profile{Services: []*ble.Service{s}},
+		values:   make(map[*ble.Characteristic][]byte),
+		handlers: make(map[*ble.Characteristic]ble.NotificationHandler),
+	}
+}
+
+func (c *client) Profile() *ble.Profile { return c.p }
+func (c *client) Conn() ble.Conn        { return nil }
+
+func (c *client) ReadLongCharacteristic(ch *ble.Characteristic) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[ch], nil
+}
+
+func (c *client) WriteCharacteristic(ch *ble.Characteristic, v []byte, noRsp bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail != nil {
+		return c.fail
+	}
+	c.values[ch] = v
+	c.writes = append(c.writes, ch.UUID.String())
+	return nil
+}
+
+func (c *client) Subscribe(ch *ble.Characteristic, ind bool, h ble.NotificationHandler) error {
+	c.handlers[ch] = h
+	return nil
+}
+
+func (c *client) notify(ch *ble.Characteristic, v []byte) {
+	c.values[ch] = v
+	c.handlers[ch](0, v)
+}
+
+type level uint8
+
+func (l level) MarshalBinary() ([]byte, error) { return []byte{uint8(l) * 10}, nil }
+
+func (l *level) UnmarshalBinary(b []byte) error {
+	if len(b) != 1 {
+		return errors.New("invalid level")
+	}
+	*l = level(b[0] / 10)
+	return nil
+}
+
+type device struct {
+	Battery  uint8   `ble:"2a19,notify,readonly"`
+	Setpoint int16   `ble:"abcd/2a6e,notify"`
+	Name     string  `ble:"2a00"`
+	Key      []byte  `ble:"ffe1,noread"`
+	Scale    float32 `ble:"ffe2"`
+	Level    level   `ble:"ffe3"`
+	Cached   int
+}
+
+func TestTwin(t *testing.T) {
+	battery := ble.NewCharacteristic(ble.UUID16(0x2a19))
+	setpoint := ble.NewCharacteristic(ble.UUID16(0x2a6e))
+	name := ble.NewCharacteristic(ble.UUID16(0x2a00))
+	key := ble.NewCharacteristic(ble.UUID16(0xffe1))
+	scale := ble.NewCharacteristic(ble.UUID16(0xffe2))
+	lvl := ble.NewCharacteristic(ble.UUID16(0xffe3))
+	cln := newClient(battery, setpoint, name, key, scale, lvl)
+	cln.values[battery] = []byte{87}
+	cln.values[setpoint] = []byte{0x66, 0x08}
+	cln.values[name] = []byte("Gopher")
+	cln.values[scale] = []byte{0x00, 0x00, 0xc0, 0x3f}
+	cln.values[lvl] = []byte{30}
+
+	var changed []string
+	var conflicts []string
+	var d device
+	tw, err := New(cln, &d, &Options{
+		Changed: func(f string) { changed = append(changed, f) },
+		Conflict: func(f string, local, remote []byte) bool {
+			conflicts = append(conflicts, f)
+			return true
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Read(); err != nil {
+		t.Fatal(err)
+	}
+	want := device{Battery: 87, Setpoint: 2150, Name: "Gopher", Scale: 1.5, Level: 3}
+	if !reflect.DeepEqual(d, want) {
+		t.Fatalf("read %+v, want %+v", d, want)
+	}
+	// Key was never read.
+	if c := tw.Changed(); !reflect.DeepEqual(c, []string{"Key"}) {
+		t.Fatalf("changed %v, want [Key]", c)
+	}
+
+	if err := tw.Subscribe(); err != nil {
+		t.Fatal(err)
+	}
+	cln.notify(battery, []byte{86})
+	if d.Battery != 86 || !reflect.DeepEqual(changed, []string{"Battery"}) {
+		t.Fatalf("battery %d, changed %v after notification", d.Battery, changed)
+	}
+
+	// A notification of a field changed locally is a conflict, which keeps
+	// the local value.
+	tw.Lock()
+	d.Setpoint = 2200
+	d.Name = "Gordon"
+	d.Key = []byte{1, 2}
+	d.Battery = 50
+	tw.Unlock()
+	cln.notify(setpoint, []byte{0x70, 0x08})
+	if d.Setpoint != 2200 || !reflect.DeepEqual(conflicts, []string{"Setpoint"}) {
+		t.Fatalf("setpoint %d, conflicts %v", d.Setpoint, conflicts)
+	}
+
+	cln.writes = nil
+	if err := tw.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{"2a6e", "2a00", "ffe1"}; !reflect.DeepEqual(cln.writes, w) {
+		t.Fatalf("wrote %v, want %v", cln.writes, w)
+	}
+	if v := cln.values[setpoint]; !reflect.DeepEqual(v, []byte{0x98, 0x08}) {
+		t.Fatalf("setpoint written % X", v)
+	}
+	if c := tw.Changed(); len(c) != 0 {
+		t.Fatalf("changed %v after write", c)
+	}
+
+	cln.writes = nil
+	cln.fail = errors.New("write failed")
+	d.Level = 5
+	err = tw.Write()
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "Level" {
+		t.Fatalf("write error %v, want a Level error", err)
+	}
+	if c := tw.Changed(); !reflect.DeepEqual(c, []string{"Level"}) {
+		t.Fatalf("changed %v after failed write, want [Level]", c)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	cln := newClient(ble.NewCharacteristic(ble.UUID16(0x2a19)))
+	cln.p.Services = append(cln.p.Services, ble.NewService(ble.UUID16(0x180f)))
+	cln.p.Services[1].AddCharacteristic(ble.NewCharacteristic(ble.UUID16(0x2a19)))
+
+	for _, v := range []interface{}{
+		device{},
+		&struct {
+			A uint8 `ble:"2a19"` // in two services
+		}{},
+		&struct {
+			A uint8 `ble:"2a20"`
+		}{},
+		&struct {
+			A int `ble:"180f/2a19"`
+		}{},
+		&struct {
+			A uint8 `ble:"180f/2a19,often"`
+		}{},
+	} {
+		if _, err := New(cln, v, nil); err == nil {
+			t.Errorf("%T accepted", v)
+		}
+	}
+	var ok struct {
+		A uint8 `ble:"180f/2a19"`
+	}
+	if _, err := New(cln, &ok, nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -42,17 +42,62 @@ func MustParse(s string) UUID {
 	return u
 }
 
+// UUID32 converts a uint32 to a UUID.
+func UUID32(i uint32) UUID {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, i)
+	return UUID(b)
+}
+
+// BaseUUID is the Bluetooth Base UUID, of which the 16-bit and 32-bit UUIDs
+// are aliases. [Vol 3, Part B, 2.5.1]
+var BaseUUID = MustParse("00000000-0000-1000-8000-00805F9B34FB")
+
 // lenErr returns an error if n is an invalid UUID length.
 func lenErr(n int) error {
 	switch n {
-	case 2, 16:
+	case 2, 4, 16:
 		return nil
 	}
-	return fmt.Errorf("UUIDs must have length 2 or 16, got %d", n)
+	return fmt.Errorf("UUIDs must have length 2, 4 or 16, got %d", n)
+}
+
+// Compress returns the 16-bit or 32-bit alias of u, if it's a 128-bit UUID
+// based on the Bluetooth Base UUID, or else u.
+func (u UUID) Compress() UUID {
+	if len(u) != 16 || !bytes.Equal(u[:12], BaseUUID[:12]) {
+		return u
+	}
+	if u[14] == 0 && u[15] == 0 {
+		return UUID{u[12], u[13]}
+	}
+	return UUID{u[12], u[13], u[14], u[15]}
+}
+
+// Expand returns the 128-bit form of u: 16-bit and 32-bit UUIDs are aliases
+// of the Bluetooth Base UUID, whose bits 96 to 127 they replace.
+// [Vol 3, Part B, 2.5.1]
+func (u UUID) Expand() UUID {
+	switch len(u) {
+	case 2:
+		return UUID(append(append([]byte{}, BaseUUID[:12]...), u[0], u[1], 0, 0))
+	case 4:
+		return UUID(append(append([]byte{}, BaseUUID[:12]...), u...))
+	}
+	return u
+}
+
+// ATTForm returns u in a form ATT PDUs carry: 16-bit and 128-bit UUIDs as
+// they are, and 32-bit UUIDs expanded to 128 bits. [Vol 3, Part F, 3.2.1]
+func (u UUID) ATTForm() UUID {
+	if len(u) == 4 {
+		return u.Expand()
+	}
+	return u
 }
 
 // Len returns the length of the UUID, in bytes.
-// BLE UUIDs are either 2 or 16 bytes.
+// BLE UUIDs are either 2, 4 or 16 bytes.
 func (u UUID) Len() int {
 	return len(u)
 }
@@ -62,9 +107,14 @@ func (u UUID) String() string {
 	return fmt.Sprintf("%x", Reverse(u))
 }
 
-// Equal returns a boolean reporting whether v represent the same UUID as u.
+// Equal returns a boolean reporting whether v represent the same UUID as u,
+// in any of their 16-bit, 32-bit or 128-bit forms, e.g. a 32-bit service
+// UUID advertised and its 128-bit form discovered over GATT.
 func (u UUID) Equal(v UUID) bool {
-	return bytes.Equal(u, v)
+	if len(u) == len(v) {
+		return bytes.Equal(u, v)
+	}
+	return bytes.Equal(u.Expand(), v.Expand())
 }
 
 // Contains returns a boolean reporting whether u is in the slice s.
@@ -96,7 +146,13 @@ func Reverse(u []byte) []byte {
 
 // Name returns name of know services, characteristics, or descriptors.
 func Name(u UUID) string {
-	return knownUUID[u.String()].Name
+	return knownUUID[u.Compress().String()].Name
+}
+
+// Type returns the type of known services, characteristics, or descriptors,
+// as the Bluetooth SIG names it, e.g. org.bluetooth.service.heart_rate.
+func Type(u UUID) string {
+	return knownUUID[u.Compress().String()].Type
 }
 
 // A dictionary of known service names and type (keyed by service uuid)
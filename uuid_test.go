@@ -26,3 +26,61 @@ func TestReverse(t *testing.T) {
 		}
 	}
 }
+
+func TestUUIDCompress(t *testing.T) {
+	for _, tt := range []struct {
+		u, want UUID
+	}{
+		{MustParse("0000180D-0000-1000-8000-00805F9B34FB"), UUID16(0x180D)},
+		{MustParse("12345678-0000-1000-8000-00805F9B34FB"), UUID32(0x12345678)},
+		{MustParse("6E400001-B5A3-F393-E0A9-E50E24DCCA9E"), MustParse("6E400001-B5A3-F393-E0A9-E50E24DCCA9E")},
+		{UUID16(0x2A37), UUID16(0x2A37)},
+	} {
+		if got := tt.u.Compress(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s compressed to %s, want %s", tt.u, got, tt.want)
+		}
+	}
+}
+
+func TestUUIDExpand(t *testing.T) {
+	for _, tt := range []struct {
+		u, want, att UUID
+	}{
+		{UUID16(0x180D), MustParse("0000180D-0000-1000-8000-00805F9B34FB"), UUID16(0x180D)},
+		{UUID32(0x12345678), MustParse("12345678-0000-1000-8000-00805F9B34FB"), MustParse("12345678-0000-1000-8000-00805F9B34FB")},
+		{MustParse("6E400001-B5A3-F393-E0A9-E50E24DCCA9E"), MustParse("6E400001-B5A3-F393-E0A9-E50E24DCCA9E"), MustParse("6E400001-B5A3-F393-E0A9-E50E24DCCA9E")},
+	} {
+		if got := tt.u.Expand(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s expanded to %s, want %s", tt.u, got, tt.want)
+		}
+		if got := tt.u.ATTForm(); !bytes.Equal(got, tt.att) {
+			t.Errorf("ATT form of %s is %s, want %s", tt.u, got, tt.att)
+		}
+	}
+	if u := UUID16(0x180D); !bytes.Equal(u, []byte{0x0D, 0x18}) {
+		t.Errorf("%s was modified by Expand", u)
+	}
+}
+
+func TestUUIDEqualAcrossForms(t *testing.T) {
+	for _, tt := range []struct {
+		u, v UUID
+		want bool
+	}{
+		{UUID32(0x12345678), MustParse("12345678-0000-1000-8000-00805F9B34FB"), true},
+		{UUID16(0x180D), UUID32(0x0000180D), true},
+		{UUID16(0x180D), MustParse("0000180D-0000-1000-8000-00805F9B34FB"), true},
+		{UUID32(0x12345678), MustParse("12345678-0000-1000-8000-00805F9B34FC"), false},
+		{UUID16(0x180D), UUID16(0x180F), false},
+	} {
+		if got := tt.u.Equal(tt.v); got != tt.want {
+			t.Errorf("%s.Equal(%s) = %t, want %t", tt.u, tt.v, got, tt.want)
+		}
+		if got := tt.v.Equal(tt.u); got != tt.want {
+			t.Errorf("%s.Equal(%s) = %t, want %t", tt.v, tt.u, got, tt.want)
+		}
+	}
+	if n := Name(MustParse("0000180D-0000-1000-8000-00805F9B34FB")); n != Name(UUID16(0x180D)) || n == "" {
+		t.Errorf("name of the 128-bit form of 180D is %q", n)
+	}
+}